@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config keys recording where imported nodes came from, so a re-sync can update them
+// and remove hosts that disappeared from the inventory
+const (
+	ansibleInventoryKey      = "ansible_inventory"       // inventory path, on the import folder
+	ansibleInventoryMtimeKey = "ansible_inventory_mtime" // modification time at the last sync
+	ansibleGroupKey          = "ansible_inventory_group" // group name, on folders created from groups
+	ansibleHostKey           = "ansible_inventory_host"  // inventory hostname, on sessions
+	ansibleWatchInterval     = 30 * time.Second
+)
+
+// ansibleGroup is one inventory group; vars and hosts keep file order
+type ansibleGroup struct {
+	name     string
+	hosts    []string
+	children []string
+	vars     map[string]string
+}
+
+type ansibleInventory struct {
+	groups   map[string]*ansibleGroup
+	order    []string
+	hostVars map[string]map[string]string
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{groups: map[string]*ansibleGroup{}, hostVars: map[string]map[string]string{}}
+}
+
+func (inv *ansibleInventory) group(name string) *ansibleGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &ansibleGroup{name: name, vars: map[string]string{}}
+		inv.groups[name] = g
+		inv.order = append(inv.order, name)
+	}
+	return g
+}
+
+func (inv *ansibleInventory) addHost(group, host string, vars map[string]string) {
+	g := inv.group(group)
+	if !containsString(g.hosts, host) {
+		g.hosts = append(g.hosts, host)
+	}
+	hv, ok := inv.hostVars[host]
+	if !ok {
+		hv = map[string]string{}
+		inv.hostVars[host] = hv
+	}
+	for k, v := range vars {
+		hv[k] = v
+	}
+}
+
+func (inv *ansibleInventory) addChild(parent, child string) {
+	g := inv.group(parent)
+	inv.group(child)
+	if !containsString(g.children, child) {
+		g.children = append(g.children, child)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportAnsibleInventory creates an "Ansible: <file>" folder from an INI or YAML inventory:
+// groups become folders (group vars become folder config), hosts become SSH sessions.
+// Importing the same inventory again re-syncs the existing folder.
+func (s *SessionService) ImportAnsibleInventory(opts ConnectionImportOptions) (*SessionImportResult, error) {
+	path, err := filepath.Abs(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseAnsibleInventory(path); err != nil {
+		return nil, err
+	}
+
+	var parentID *string
+	if opts.ParentID != "" {
+		parentID = &opts.ParentID
+	}
+	name := "Ansible: " + filepath.Base(path)
+	root := SessionExportNode{Name: name, Type: "folder", Config: map[string]string{ansibleInventoryKey: path}}
+	result := &SessionImportResult{}
+	if err := s.importNodes(parentID, []SessionExportNode{root}, "merge", nil, result); err != nil {
+		return result, err
+	}
+
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return result, err
+	}
+	for _, node := range all {
+		sameParent := (parentID == nil && node.ParentID == nil) || (parentID != nil && node.ParentID != nil && *node.ParentID == *parentID)
+		if sameParent && node.Type == "folder" && node.Name == name {
+			err = s.syncAnsibleInventory(node.ID, result)
+			return result, err
+		}
+	}
+	return result, fmt.Errorf("inventory folder %q not found after import", name)
+}
+
+// SyncAnsibleInventory re-reads the inventory a folder was imported from, updating
+// hosts and groups and removing the ones no longer listed
+func (s *SessionService) SyncAnsibleInventory(folderID string) (*SessionImportResult, error) {
+	result := &SessionImportResult{}
+	err := s.syncAnsibleInventory(folderID, result)
+	return result, err
+}
+
+func (s *SessionService) syncAnsibleInventory(folderID string, result *SessionImportResult) error {
+	cfg, err := s.db.GetSessionConfigs(folderID)
+	if err != nil {
+		return err
+	}
+	path := cfg[ansibleInventoryKey]
+	if path == "" {
+		return fmt.Errorf("folder was not imported from an Ansible inventory")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("inventory not readable: %w", err)
+	}
+	inv, err := parseAnsibleInventory(path)
+	if err != nil {
+		return err
+	}
+
+	// [all:vars] apply to the whole inventory folder
+	if all, ok := inv.groups["all"]; ok {
+		for key, value := range ansibleVarsConfig(all.vars, false) {
+			if err := s.SetSessionConfig(folderID, key, value, "string"); err != nil {
+				return err
+			}
+		}
+	}
+
+	nodes := inv.nodes()
+	if err := s.importNodes(&folderID, nodes, "merge", nil, result); err != nil {
+		return err
+	}
+	if err := s.removeStaleInventoryNodes(folderID, nodes, result); err != nil {
+		return err
+	}
+	return s.db.SetSessionConfig(folderID, ansibleInventoryMtimeKey, info.ModTime().UTC().Format(time.RFC3339Nano), "string")
+}
+
+// removeStaleInventoryNodes deletes sessions and (empty) group folders created by an earlier
+// sync that are no longer in the inventory; nodes added by hand are left alone
+func (s *SessionService) removeStaleInventoryNodes(folderID string, nodes []SessionExportNode, result *SessionImportResult) error {
+	expected := map[string]bool{}
+	var collect func(prefix string, list []SessionExportNode)
+	collect = func(prefix string, list []SessionExportNode) {
+		for _, n := range list {
+			p := prefix + "/" + n.Type + ":" + n.Name
+			expected[p] = true
+			collect(p, n.Children)
+		}
+	}
+	collect("", nodes)
+
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return err
+	}
+	children := map[string][]string{}
+	names := map[string]string{}
+	types := map[string]string{}
+	for _, node := range all {
+		names[node.ID] = node.Name
+		types[node.ID] = node.Type
+		if node.ParentID != nil {
+			children[*node.ParentID] = append(children[*node.ParentID], node.ID)
+		}
+	}
+
+	// Post-order so folders are only considered once their stale children are gone
+	var walk func(id, prefix string) error
+	walk = func(id, prefix string) error {
+		for _, child := range children[id] {
+			p := prefix + "/" + types[child] + ":" + names[child]
+			if err := walk(child, p); err != nil {
+				return err
+			}
+			if expected[p] {
+				continue
+			}
+			cfg, err := s.db.GetSessionConfigs(child)
+			if err != nil {
+				return err
+			}
+			marker := ansibleHostKey
+			if types[child] == "folder" {
+				marker = ansibleGroupKey
+			}
+			if cfg[marker] == "" || (types[child] == "folder" && s.hasChildren(child)) {
+				continue
+			}
+			if err := s.db.DeleteSession(child, false); err != nil {
+				return err
+			}
+			result.Removed++
+		}
+		return nil
+	}
+	return walk(folderID, "")
+}
+
+func (s *SessionService) hasChildren(id string) bool {
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return true
+	}
+	for _, node := range all {
+		if node.ParentID != nil && *node.ParentID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// StartInventoryWatch periodically re-syncs inventory folders whose file changed
+func (s *SessionService) StartInventoryWatch() {
+	go func() {
+		ticker := time.NewTicker(ansibleWatchInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.syncChangedInventories()
+		}
+	}()
+}
+
+func (s *SessionService) syncChangedInventories() {
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return
+	}
+	for _, node := range all {
+		if node.Type != "folder" {
+			continue
+		}
+		cfg, err := s.db.GetSessionConfigs(node.ID)
+		if err != nil || cfg[ansibleInventoryKey] == "" {
+			continue
+		}
+		info, err := os.Stat(cfg[ansibleInventoryKey])
+		if err != nil || info.ModTime().UTC().Format(time.RFC3339Nano) == cfg[ansibleInventoryMtimeKey] {
+			continue
+		}
+		result, err := s.SyncAnsibleInventory(node.ID)
+		if err != nil {
+			log.Printf("Failed to re-sync Ansible inventory %s: %v", cfg[ansibleInventoryKey], err)
+			continue
+		}
+		if s.app != nil {
+			s.app.Event.Emit("sessions:inventory:synced", map[string]interface{}{
+				"folderId": node.ID,
+				"path":     cfg[ansibleInventoryKey],
+				"created":  result.Created,
+				"updated":  result.Updated,
+				"removed":  result.Removed,
+			})
+		}
+	}
+}
+
+// nodes builds the folder tree: hosts of "all"/"ungrouped" at the top, then top-level groups
+func (inv *ansibleInventory) nodes() []SessionExportNode {
+	isChild := map[string]bool{}
+	for _, g := range inv.groups {
+		if g.name == "all" {
+			continue
+		}
+		for _, c := range g.children {
+			isChild[c] = true
+		}
+	}
+
+	var out []SessionExportNode
+	for _, name := range []string{"all", "ungrouped"} {
+		if g, ok := inv.groups[name]; ok {
+			for _, host := range g.hosts {
+				out = append(out, inv.hostNode(host))
+			}
+		}
+	}
+	for _, name := range inv.order {
+		if name == "all" || name == "ungrouped" || isChild[name] {
+			continue
+		}
+		out = append(out, inv.groupNode(name, map[string]bool{}))
+	}
+	return out
+}
+
+func (inv *ansibleInventory) groupNode(name string, visiting map[string]bool) SessionExportNode {
+	g := inv.groups[name]
+	node := SessionExportNode{Name: name, Type: "folder", Config: ansibleVarsConfig(g.vars, false)}
+	node.Config[ansibleGroupKey] = name
+	visiting[name] = true
+	for _, child := range g.children {
+		if visiting[child] {
+			continue
+		}
+		node.Children = append(node.Children, inv.groupNode(child, visiting))
+	}
+	delete(visiting, name)
+	for _, host := range g.hosts {
+		node.Children = append(node.Children, inv.hostNode(host))
+	}
+	return node
+}
+
+func (inv *ansibleInventory) hostNode(host string) SessionExportNode {
+	vars := inv.hostVars[host]
+	node := SessionExportNode{Name: host, Type: "session", SessionType: "ssh", Config: ansibleVarsConfig(vars, true)}
+	if node.Config["ssh_host"] == "" {
+		node.Config["ssh_host"] = host
+	}
+	node.Config[ansibleHostKey] = host
+	return node
+}
+
+// ansibleVarsConfig maps Ansible connection variables onto SSH config keys;
+// ansible_host is only meaningful for a single host
+func ansibleVarsConfig(vars map[string]string, isHost bool) map[string]string {
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := vars[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	config := map[string]string{}
+	if isHost {
+		if v := first("ansible_host", "ansible_ssh_host"); v != "" {
+			config["ssh_host"] = v
+		}
+	}
+	if v := first("ansible_port", "ansible_ssh_port"); v != "" {
+		config["ssh_port"] = v
+	}
+	if v := first("ansible_user", "ansible_ssh_user"); v != "" {
+		config["ssh_username"] = v
+	}
+	if v := first("ansible_ssh_private_key_file", "ansible_private_key_file"); v != "" {
+		config["ssh_auth_method"] = "key"
+		config["ssh_key_path"] = v
+	} else if v := first("ansible_password", "ansible_ssh_pass"); v != "" {
+		config["ssh_auth_method"] = "password"
+		config["ssh_password"] = v
+	}
+	return config
+}
+
+// parseAnsibleInventory reads a YAML (.yml/.yaml/.json) or INI inventory
+func parseAnsibleInventory(path string) (*ansibleInventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml", ".json":
+		return parseAnsibleYAML(data)
+	default:
+		return parseAnsibleINI(data)
+	}
+}
+
+func parseAnsibleINI(data []byte) (*ansibleInventory, error) {
+	inv := newAnsibleInventory()
+	group, kind := "ungrouped", "hosts"
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section := line[1 : len(line)-1]
+			group, kind = section, "hosts"
+			if i := strings.LastIndex(section, ":"); i >= 0 {
+				switch section[i+1:] {
+				case "vars", "children":
+					group, kind = section[:i], section[i+1:]
+				}
+			}
+			inv.group(group)
+			continue
+		}
+
+		fields, err := splitAnsibleFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("inventory line %d: %w", lineNo, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch kind {
+		case "vars":
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("inventory line %d: expected key=value", lineNo)
+			}
+			inv.group(group).vars[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+		case "children":
+			inv.addChild(group, fields[0])
+		default:
+			vars := map[string]string{}
+			for _, f := range fields[1:] {
+				if k, v, ok := strings.Cut(f, "="); ok {
+					vars[k] = v
+				}
+			}
+			pattern := fields[0]
+			// "host:port" shorthand (but not bare IPv6 addresses)
+			if i := strings.LastIndex(pattern, ":"); i > 0 && strings.Count(pattern, ":") == 1 {
+				if _, err := strconv.Atoi(pattern[i+1:]); err == nil {
+					vars["ansible_port"] = pattern[i+1:]
+					pattern = pattern[:i]
+				}
+			}
+			hosts, err := expandAnsibleHostPattern(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("inventory line %d: %w", lineNo, err)
+			}
+			for _, h := range hosts {
+				inv.addHost(group, h, vars)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// splitAnsibleFields splits a host line on whitespace, honouring quotes and dropping comments
+func splitAnsibleFields(line string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	var quote byte
+	inField := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteByte(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == '#' && !inField:
+			i = len(line)
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteByte(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// expandAnsibleHostPattern expands ranges such as web[01:03].example.com or db-[a:c]
+func expandAnsibleHostPattern(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated range in %q", pattern)
+	}
+	end += start
+	prefix, spec, suffix := pattern[:start], pattern[start+1:end], pattern[end+1:]
+
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid range %q", spec)
+	}
+	step := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid range step %q", parts[2])
+		}
+		step = n
+	}
+
+	var items []string
+	if lo, err := strconv.Atoi(parts[0]); err == nil {
+		hi, err := strconv.Atoi(parts[1])
+		if err != nil || hi < lo {
+			return nil, fmt.Errorf("invalid range %q", spec)
+		}
+		width := 0
+		if strings.HasPrefix(parts[0], "0") && len(parts[0]) > 1 {
+			width = len(parts[0])
+		}
+		for i := lo; i <= hi; i += step {
+			items = append(items, fmt.Sprintf("%0*d", width, i))
+		}
+	} else if len(parts[0]) == 1 && len(parts[1]) == 1 && parts[0] <= parts[1] {
+		for c := parts[0][0]; c <= parts[1][0]; c += byte(step) {
+			items = append(items, string(c))
+		}
+	} else {
+		return nil, fmt.Errorf("invalid range %q", spec)
+	}
+
+	var hosts []string
+	for _, item := range items {
+		rest, err := expandAnsibleHostPattern(suffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rest {
+			hosts = append(hosts, prefix+item+r)
+		}
+	}
+	return hosts, nil
+}
+
+type ansibleYAMLGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*ansibleYAMLGroup      `yaml:"children"`
+}
+
+func parseAnsibleYAML(data []byte) (*ansibleInventory, error) {
+	var top map[string]*ansibleYAMLGroup
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory: %w", err)
+	}
+	inv := newAnsibleInventory()
+	var add func(name string, g *ansibleYAMLGroup)
+	add = func(name string, g *ansibleYAMLGroup) {
+		grp := inv.group(name)
+		if g == nil {
+			return
+		}
+		for _, k := range sortedKeys(g.Vars) {
+			grp.vars[k] = fmt.Sprint(g.Vars[k])
+		}
+		for _, host := range sortedKeys(g.Hosts) {
+			vars := map[string]string{}
+			for k, v := range g.Hosts[host] {
+				vars[k] = fmt.Sprint(v)
+			}
+			inv.addHost(name, host, vars)
+		}
+		for _, child := range sortedKeys(g.Children) {
+			inv.addChild(name, child)
+			add(child, g.Children[child])
+		}
+	}
+	for _, name := range sortedKeys(top) {
+		add(name, top[name])
+	}
+	return inv, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
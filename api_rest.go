@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiPrefix versions every HTTP endpoint; incompatible changes get a new version
+const apiPrefix = "/api/v1"
+
+// REST endpoints for scripts and CI jobs driving the app without the GUI, all below
+// apiPrefix:
+//
+//	GET    /sessions                      session tree nodes
+//	GET    /terminals                     running terminal IDs
+//	POST   /terminals                     {"sessionId", "cols", "rows"} opens a terminal for a session
+//	DELETE /terminals/{id}                closes a terminal
+//	POST   /terminals/{id}/input          {"data"} sends input, e.g. "uptime\n"
+//	POST   /terminals/{id}/recording      {"captureInput"} starts recording the terminal
+//	DELETE /terminals/{id}/recording      stops the recording
+//	POST   /terminals/{id}/share          {"minutes"} creates a read-only share link
+//	DELETE /share/{token}                 revokes a share link
+//	POST   /ui/open                       {"session"} opens a saved session (ID, name or path) in a tab
+//	POST   /ui/ssh                        {"user", "host", "port", "keyPath"} opens an SSH tab
+//
+// Failures answer with an APIErrorResponse.
+func (h *HTTPServer) registerRESTRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+apiPrefix+"/sessions", h.requireToken(h.handleListSessions))
+	mux.HandleFunc("GET "+apiPrefix+"/terminals", h.requireToken(h.handleListTerminals))
+	mux.HandleFunc("POST "+apiPrefix+"/terminals", h.requireToken(h.handleOpenTerminal))
+	mux.HandleFunc("DELETE "+apiPrefix+"/terminals/{id}", h.requireToken(h.handleCloseTerminal))
+	mux.HandleFunc("POST "+apiPrefix+"/terminals/{id}/input", h.requireToken(h.handleTerminalInput))
+	mux.HandleFunc("POST "+apiPrefix+"/terminals/{id}/recording", h.requireToken(h.handleStartRecording))
+	mux.HandleFunc("DELETE "+apiPrefix+"/terminals/{id}/recording", h.requireToken(h.handleStopRecording))
+	mux.HandleFunc("POST "+apiPrefix+"/terminals/{id}/share", h.requireToken(h.handleCreateShareLink))
+	mux.HandleFunc("DELETE "+apiPrefix+"/share/{token}", h.requireToken(h.handleRevokeShareLink))
+	mux.HandleFunc("POST "+apiPrefix+"/ui/open", h.requireToken(h.handleUIOpen))
+	mux.HandleFunc("POST "+apiPrefix+"/ui/ssh", h.requireToken(h.handleUISSH))
+}
+
+// apiTerminal remembers what a terminal opened through the API belongs to, for
+// naming its recordings
+type apiTerminal struct {
+	sessionName, sessionType string
+	cols, rows               uint16
+}
+
+// APISession is a session tree node as listed by the REST API
+type APISession struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"` // "folder" or "session"
+	SessionType string  `json:"sessionType,omitempty"`
+	ParentID    *string `json:"parentId,omitempty"`
+}
+
+// OpenTerminalRequest is the body of POST /terminals
+type OpenTerminalRequest struct {
+	SessionID string `json:"sessionId"`
+	Cols      uint16 `json:"cols"`
+	Rows      uint16 `json:"rows"`
+}
+
+// OpenTerminalResponse answers POST /terminals
+type OpenTerminalResponse struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId"`
+}
+
+// TerminalList answers GET /terminals
+type TerminalList struct {
+	Terminals []string `json:"terminals"`
+}
+
+// UIOpenRequest is the body of POST /ui/open
+type UIOpenRequest struct {
+	Session string `json:"session"`
+}
+
+// APIError describes a failed request: Code is a stable snake_case identifier for
+// programs to branch on, Message is meant for people
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIErrorResponse is the body of every failed API response
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+func (h *HTTPServer) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	nodes, err := h.guacService.sessionService.db.GetAllSessions()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	sessions := make([]APISession, 0, len(nodes))
+	for _, node := range nodes {
+		s := APISession{ID: node.ID, Name: node.Name, Type: node.Type, ParentID: node.ParentID}
+		if node.SessionType != nil {
+			s.SessionType = *node.SessionType
+		}
+		sessions = append(sessions, s)
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (h *HTTPServer) handleListTerminals(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, TerminalList{Terminals: h.termService.GetActiveSessions()})
+}
+
+// handleOpenTerminal starts a terminal for a local shell or SSH session with the
+// session's effective config, like opening it from the tree
+func (h *HTTPServer) handleOpenTerminal(w http.ResponseWriter, r *http.Request) {
+	var req OpenTerminalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_body", fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	sessions := h.guacService.sessionService
+	node, err := sessions.GetSession(req.SessionID)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "session_not_found", fmt.Errorf("session %s not found", req.SessionID))
+		return
+	}
+	if node.SessionType == nil || isRemoteDesktopType(*node.SessionType) {
+		writeJSONError(w, http.StatusBadRequest, "not_a_terminal_session", fmt.Errorf("%s is not a terminal session", node.Name))
+		return
+	}
+	config, err := sessions.GetEffectiveConfig(node.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	if req.Cols == 0 || req.Rows == 0 {
+		req.Cols, req.Rows = 80, 24
+	}
+
+	id := newAPITerminalID()
+	err = h.termService.StartSession(StartSessionRequest{
+		ID:          id,
+		NodeID:      node.ID,
+		SessionType: *node.SessionType,
+		Config:      config,
+		Cols:        req.Cols,
+		Rows:        req.Rows,
+	})
+	if err != nil {
+		status, code := http.StatusBadGateway, "connect_failed"
+		var verr *ConfigValidationError
+		if errors.As(err, &verr) {
+			status, code = http.StatusUnprocessableEntity, "invalid_config"
+		}
+		writeJSONError(w, status, code, err)
+		return
+	}
+	h.mu.Lock()
+	h.terminals[id] = apiTerminal{sessionName: node.Name, sessionType: *node.SessionType, cols: req.Cols, rows: req.Rows}
+	h.mu.Unlock()
+	writeJSON(w, http.StatusCreated, OpenTerminalResponse{ID: id, SessionID: node.ID})
+}
+
+func (h *HTTPServer) handleCloseTerminal(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !h.termService.IsSessionRunning(id) {
+		writeJSONError(w, http.StatusNotFound, "terminal_not_running", fmt.Errorf("terminal %s is not running", id))
+		return
+	}
+	if err := h.termService.CloseSession(id); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	h.mu.Lock()
+	delete(h.terminals, id)
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forgetTerminal drops a REST API terminal whose process has ended
+func (h *HTTPServer) forgetTerminal(id string) {
+	h.mu.Lock()
+	delete(h.terminals, id)
+	h.mu.Unlock()
+}
+
+func (h *HTTPServer) handleTerminalInput(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var body struct {
+		Data string `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_body", fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if !h.termService.IsSessionRunning(id) {
+		writeJSONError(w, http.StatusNotFound, "terminal_not_running", fmt.Errorf("terminal %s is not running", id))
+		return
+	}
+	if err := h.termService.WriteToSession(id, body.Data); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPServer) handleStartRecording(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var body struct {
+		CaptureInput bool `json:"captureInput"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_body", fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	if !h.termService.IsSessionRunning(id) {
+		writeJSONError(w, http.StatusNotFound, "terminal_not_running", fmt.Errorf("terminal %s is not running", id))
+		return
+	}
+	if h.termService.recorder == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "recording_unavailable", fmt.Errorf("recording is not available"))
+		return
+	}
+	h.mu.Lock()
+	t, ok := h.terminals[id]
+	h.mu.Unlock()
+	if !ok {
+		t = apiTerminal{sessionName: id, cols: 80, rows: 24}
+	}
+	err := h.termService.recorder.Start(RecordingOptions{
+		SessionID:    id,
+		SessionName:  t.sessionName,
+		SessionType:  t.sessionType,
+		Cols:         t.cols,
+		Rows:         t.rows,
+		CaptureInput: body.CaptureInput,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPServer) handleStopRecording(w http.ResponseWriter, r *http.Request) {
+	if h.termService.recorder == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "recording_unavailable", fmt.Errorf("recording is not available"))
+		return
+	}
+	if err := h.termService.recorder.Stop(r.PathValue("id")); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func isRemoteDesktopType(sessionType string) bool {
+	switch sessionType {
+	case "rdp", "vnc", "telnet":
+		return true
+	}
+	return false
+}
+
+// newAPITerminalID names terminals opened through the API
+func newAPITerminalID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "api-" + hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleUIOpen opens a saved session in the app window, as `term open` does
+func (h *HTTPServer) handleUIOpen(w http.ResponseWriter, r *http.Request) {
+	var req UIOpenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_body", fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	node, err := findSessionNode(h.guacService.sessionService.db, strings.TrimSpace(req.Session))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "session_not_found", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, openSessionTab(h.termService.app, node))
+}
+
+// handleUISSH opens an SSH tab in the app window, as `term ssh` does
+func (h *HTTPServer) handleUISSH(w http.ResponseWriter, r *http.Request) {
+	var target sshTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_body", fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	result, err := openSSHTarget(h.termService.app, h.guacService.sessionService, target)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "cannot_open", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// writeJSONError answers with an APIErrorResponse; an empty code is derived from the
+// status, e.g. "not_found"
+func writeJSONError(w http.ResponseWriter, status int, code string, err error) {
+	if code == "" {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	writeJSON(w, status, APIErrorResponse{Error: APIError{Code: code, Message: strings.TrimSpace(err.Error())}})
+}
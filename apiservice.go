@@ -0,0 +1,34 @@
+package main
+
+// APIServerInfo describes how other machines reach the HTTP server
+type APIServerInfo struct {
+	Address     string `json:"address"` // host:port listened on
+	TLS         bool   `json:"tls"`
+	Fingerprint string `json:"fingerprint,omitempty"` // SHA-256 of the TLS certificate, to verify a self-signed one
+	Socket      string `json:"socket,omitempty"`      // Unix socket or named pipe serving the API, when enabled
+}
+
+// APIService gives the frontend what it needs to call the local HTTP server
+type APIService struct {
+	server *HTTPServer
+}
+
+// NewAPIService creates the service for the given HTTP server
+func NewAPIService(server *HTTPServer) *APIService {
+	return &APIService{server: server}
+}
+
+// GetAPIToken returns the bearer token the HTTP server requires during this run
+func (a *APIService) GetAPIToken() string {
+	return a.server.token
+}
+
+// GetAPIPort returns the port the HTTP server listens on during this run
+func (a *APIService) GetAPIPort() int {
+	return a.server.Port()
+}
+
+// GetAPIServerInfo returns the address and TLS details of the HTTP server
+func (a *APIService) GetAPIServerInfo() APIServerInfo {
+	return APIServerInfo{Address: a.server.addr, TLS: a.server.tls != nil, Fingerprint: a.server.fingerprint, Socket: a.server.socketPath}
+}
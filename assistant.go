@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+	"term/logging"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+var assistantLog = logging.For("assistant")
+
+// settingAssistant holds the provider of the command assistant, its API key
+// sealed by the secret store
+const settingAssistant = "assistant_config"
+
+const (
+	assistantTimeout      = 2 * time.Minute // local models can be slow
+	assistantMaxJobs      = 4
+	assistantTailBytes    = 64 << 10 // output kept per terminal for the context
+	assistantContextLines = 60
+	assistantMaxLines     = 500
+	assistantMaxReply     = 1 << 20
+)
+
+// assistantProviderTypes are the values AssistantConfig.Provider accepts
+// besides "" (off). openai is any OpenAI-compatible API, which local model
+// servers such as llama.cpp, LM Studio and vLLM also offer; ollama runs models
+// locally.
+var assistantProviderTypes = []string{"openai", "anthropic", "ollama"}
+
+// assistantDefaultURLs are the API base URLs used when none is set
+var assistantDefaultURLs = map[string]string{
+	"openai":    "https://api.openai.com/v1",
+	"anthropic": "https://api.anthropic.com",
+	"ollama":    "http://localhost:11434",
+}
+
+// AssistantConfig is the provider of the command assistant
+type AssistantConfig struct {
+	Provider     string `json:"provider"` // "" turns the assistant off
+	URL          string `json:"url"`      // API base URL; the provider's by default
+	APIKey       string `json:"apiKey"`   // not needed for local models
+	Model        string `json:"model"`
+	ContextLines int    `json:"contextLines"` // lines of terminal output sent; 0 for 60
+}
+
+// assistantProvider is a model API the assistant asks
+type assistantProvider interface {
+	complete(ctx context.Context, system, prompt string) (string, error)
+}
+
+// AssistantService suggests commands and explains errors with a language model,
+// given the recent output of a terminal with secrets redacted. Requests run as
+// jobs answered by an assistant:result event, so the provider and its API key
+// stay in the backend.
+type AssistantService struct {
+	app       *application.App
+	db        *database.DB
+	secrets   *SecretStore
+	terminals *TerminalService
+
+	mu   sync.Mutex
+	next int
+	jobs map[string]context.CancelFunc
+}
+
+// NewAssistantService creates the command assistant service
+func NewAssistantService(app *application.App, db *database.DB, secrets *SecretStore, terminals *TerminalService) *AssistantService {
+	return &AssistantService{app: app, db: db, secrets: secrets, terminals: terminals, jobs: make(map[string]context.CancelFunc)}
+}
+
+// SuggestCommand asks for commands doing what request describes in a terminal
+// and returns the job ID
+func (s *AssistantService) SuggestCommand(terminalID, request string) (string, error) {
+	request = strings.TrimSpace(request)
+	if request == "" {
+		return "", fmt.Errorf("describe what the command should do")
+	}
+	system := "You are a command-line assistant in a terminal app. Answer with the commands that do what the user asks, " +
+		"each in a ```sh code block, and at most two sentences of explanation. Use what the terminal output shows about " +
+		"the system. Values shown as [REDACTED] were hidden from you."
+	return s.start("suggest", terminalID, system, request)
+}
+
+// ExplainError asks what went wrong at the end of a terminal's output and
+// returns the job ID
+func (s *AssistantService) ExplainError(terminalID string) (string, error) {
+	system := "You are a command-line assistant in a terminal app. Explain briefly the error at the end of the terminal " +
+		"output and how to fix it. Put commands that fix it in ```sh code blocks. Values shown as [REDACTED] were hidden from you."
+	return s.start("explain", terminalID, system, "What went wrong, and how do I fix it?")
+}
+
+// CancelAssistant stops a job
+func (s *AssistantService) CancelAssistant(job string) {
+	s.mu.Lock()
+	cancel := s.jobs[job]
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// ServiceShutdown stops the running jobs
+func (s *AssistantService) ServiceShutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.jobs {
+		cancel()
+	}
+	return nil
+}
+
+// start runs a request in the background; assistant:result carries the
+// answer, the commands in it, or the error
+func (s *AssistantService) start(kind, terminalID, system, request string) (string, error) {
+	session := s.terminals.GetSession(terminalID)
+	if session == nil {
+		return "", fmt.Errorf("session %s not found", terminalID)
+	}
+	cfg, err := s.config()
+	if err != nil {
+		return "", err
+	}
+	provider, err := newAssistantProvider(cfg)
+	if err != nil {
+		return "", err
+	}
+	prompt := assistantPrompt(session, cfg.ContextLines, request)
+
+	s.mu.Lock()
+	if len(s.jobs) >= assistantMaxJobs {
+		s.mu.Unlock()
+		return "", fmt.Errorf("too many assistant requests running (max %d)", assistantMaxJobs)
+	}
+	s.next++
+	job := fmt.Sprintf("assistant-%d", s.next)
+	ctx, cancel := context.WithTimeout(context.Background(), assistantTimeout)
+	s.jobs[job] = cancel
+	s.mu.Unlock()
+
+	assistantLog.Info("assistant request", "job", job, "kind", kind, "tab", terminalID, "provider", cfg.Provider, "model", cfg.Model)
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.jobs, job)
+			s.mu.Unlock()
+			cancel()
+		}()
+		reply, err := provider.complete(ctx, system, prompt)
+		result := map[string]interface{}{"jobId": job, "id": terminalID, "kind": kind}
+		switch {
+		case ctx.Err() == context.Canceled:
+			result["error"] = "cancelled"
+		case ctx.Err() == context.DeadlineExceeded:
+			result["error"] = fmt.Sprintf("timed out after %s", assistantTimeout)
+		case err != nil:
+			assistantLog.Warn("assistant request failed", "job", job, "err", err)
+			result["error"] = err.Error()
+		default:
+			result["text"] = reply
+			result["commands"] = suggestedCommands(reply)
+		}
+		s.app.Event.Emit("assistant:result", result)
+	}()
+	return job, nil
+}
+
+// assistantPrompt describes the terminal and its recent output for a request
+func assistantPrompt(session *TerminalSession, lines int, request string) string {
+	if lines <= 0 {
+		lines = assistantContextLines
+	}
+	var b strings.Builder
+	// The host name stays out: it says which machine the output comes from
+	fmt.Fprintf(&b, "Terminal: %s session", session.SessionType)
+	if title := session.Title(); title != "" {
+		fmt.Fprintf(&b, ", titled %q", logging.Redact(title))
+	}
+	if output := session.tail.lines(min(lines, assistantMaxLines)); output != "" {
+		b.WriteString("\nRecent output:\n```\n" + logging.Redact(output) + "\n```")
+	}
+	b.WriteString("\n\n" + request)
+	return b.String()
+}
+
+// assistantCodeBlock matches the fenced code blocks of a reply
+var assistantCodeBlock = regexp.MustCompile("(?s)```[a-zA-Z0-9_-]*\\n(.*?)```")
+
+// suggestedCommands returns the non-empty lines of a reply's code blocks
+func suggestedCommands(reply string) []string {
+	commands := []string{}
+	for _, m := range assistantCodeBlock.FindAllStringSubmatch(reply, -1) {
+		for _, line := range strings.Split(m[1], "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				commands = append(commands, strings.TrimPrefix(line, "$ "))
+			}
+		}
+	}
+	return commands
+}
+
+// outputTail keeps the last output of a terminal, the assistant's context
+type outputTail struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (o *outputTail) add(data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.data = append(o.data, data...)
+	if len(o.data) > 2*assistantTailBytes {
+		o.data = append([]byte(nil), o.data[len(o.data)-assistantTailBytes:]...)
+	}
+}
+
+// terminalEscape matches CSI, OSC and two-byte escape sequences
+var terminalEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)?|\x1b[@-Z\\-_]|\x1b[()][0-9A-Za-z]`)
+
+// lines returns the last n lines of output as plain text
+func (o *outputTail) lines(n int) string {
+	o.mu.Lock()
+	text := string(o.data)
+	o.mu.Unlock()
+	text = terminalEscape.ReplaceAllString(text, "")
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		// What a carriage return overwrote is gone from the screen too
+		if i := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		line = strings.TrimRight(line, "\r \t")
+		kept = append(kept, strings.ToValidUTF8(line, ""))
+	}
+	for len(kept) > 0 && kept[len(kept)-1] == "" {
+		kept = kept[:len(kept)-1]
+	}
+	if len(kept) > n {
+		kept = kept[len(kept)-n:]
+	}
+	return strings.Join(kept, "\n")
+}
+
+// newAssistantProvider returns the provider of a decrypted config
+func newAssistantProvider(cfg AssistantConfig) (assistantProvider, error) {
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("no assistant model configured")
+	}
+	base := strings.TrimRight(cfg.URL, "/")
+	if base == "" {
+		base = assistantDefaultURLs[cfg.Provider]
+	} else if err := checkAssistantURL(base); err != nil {
+		return nil, err
+	}
+	switch cfg.Provider {
+	case "openai":
+		return &openAIAssistant{url: base, key: cfg.APIKey, model: cfg.Model}, nil
+	case "anthropic":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("the Anthropic API needs an API key")
+		}
+		return &anthropicAssistant{url: base, key: cfg.APIKey, model: cfg.Model}, nil
+	case "ollama":
+		return &ollamaAssistant{url: base, model: cfg.Model}, nil
+	}
+	return nil, fmt.Errorf("the command assistant is not configured")
+}
+
+// checkAssistantURL accepts HTTPS URLs, and plain HTTP only to this machine as
+// terminal output and API keys would otherwise cross the network in clear text
+func checkAssistantURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid assistant URL %q", rawURL)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+		return fmt.Errorf("assistant URL %q: plain HTTP is only allowed to localhost; use HTTPS", rawURL)
+	}
+	return fmt.Errorf("invalid assistant URL %q", rawURL)
+}
+
+// postJSON posts a request body to a model API and decodes its reply
+func postJSON(ctx context.Context, endpoint string, headers map[string]string, body, reply interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, assistantMaxReply))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		// Model APIs put the reason in an error object
+		var apiErr struct {
+			Error json.RawMessage `json:"error"`
+		}
+		msg := strings.TrimSpace(string(raw))
+		if json.Unmarshal(raw, &apiErr) == nil && len(apiErr.Error) > 0 {
+			var detail struct {
+				Message string `json:"message"`
+			}
+			if json.Unmarshal(apiErr.Error, &detail) == nil && detail.Message != "" {
+				msg = detail.Message
+			} else if s, err := strconv.Unquote(string(apiErr.Error)); err == nil {
+				msg = s
+			}
+		}
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	if err := json.Unmarshal(raw, reply); err != nil {
+		return fmt.Errorf("invalid reply: %w", err)
+	}
+	return nil
+}
+
+// openAIAssistant uses the chat completions API
+type openAIAssistant struct {
+	url, key, model string
+}
+
+func (a *openAIAssistant) complete(ctx context.Context, system, prompt string) (string, error) {
+	headers := map[string]string{}
+	if a.key != "" {
+		headers["Authorization"] = "Bearer " + a.key
+	}
+	var reply struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	err := postJSON(ctx, a.url+"/chat/completions", headers, map[string]interface{}{
+		"model": a.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+	}, &reply)
+	if err != nil {
+		return "", err
+	}
+	if len(reply.Choices) == 0 {
+		return "", fmt.Errorf("the model returned no answer")
+	}
+	return reply.Choices[0].Message.Content, nil
+}
+
+// anthropicAssistant uses the Messages API
+type anthropicAssistant struct {
+	url, key, model string
+}
+
+func (a *anthropicAssistant) complete(ctx context.Context, system, prompt string) (string, error) {
+	var reply struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	err := postJSON(ctx, a.url+"/v1/messages", map[string]string{
+		"x-api-key":         a.key,
+		"anthropic-version": "2023-06-01",
+	}, map[string]interface{}{
+		"model":      a.model,
+		"max_tokens": 1024,
+		"system":     system,
+		"messages":   []map[string]string{{"role": "user", "content": prompt}},
+	}, &reply)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, block := range reply.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String(), nil
+}
+
+// ollamaAssistant uses the chat API of a local Ollama server
+type ollamaAssistant struct {
+	url, model string
+}
+
+func (a *ollamaAssistant) complete(ctx context.Context, system, prompt string) (string, error) {
+	var reply struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	err := postJSON(ctx, a.url+"/api/chat", nil, map[string]interface{}{
+		"model":  a.model,
+		"stream": false,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+	}, &reply)
+	if err != nil {
+		return "", err
+	}
+	return reply.Message.Content, nil
+}
+
+func loadAssistantConfig(db *database.DB) (AssistantConfig, error) {
+	var cfg AssistantConfig
+	setting, err := db.GetSetting(settingAssistant)
+	if err != nil || setting.Value == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(setting.Value), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid assistant configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// resealAssistantConfig returns the stored assistant configuration with its API
+// key passed through reseal, or "" when there is nothing to re-encrypt
+func resealAssistantConfig(db *database.DB, reseal func(string) (string, error)) (string, error) {
+	cfg, err := loadAssistantConfig(db)
+	if err != nil || cfg.APIKey == "" {
+		return "", err
+	}
+	if cfg.APIKey, err = reseal(cfg.APIKey); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(cfg)
+	return string(data), err
+}
+
+// config returns the assistant configuration with its API key decrypted
+func (s *AssistantService) config() (AssistantConfig, error) {
+	cfg, err := loadAssistantConfig(s.db)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Provider == "" {
+		return cfg, fmt.Errorf("the command assistant is not configured")
+	}
+	if cfg.APIKey, err = s.secrets.Decrypt(cfg.APIKey); err != nil {
+		return cfg, fmt.Errorf("failed to decrypt the assistant API key: %w", err)
+	}
+	return cfg, nil
+}
+
+// GetAssistantConfig returns the assistant configuration with the API key masked
+func (s *AssistantService) GetAssistantConfig() (AssistantConfig, error) {
+	cfg, err := loadAssistantConfig(s.db)
+	cfg.APIKey = maskCredentialSecret(cfg.APIKey)
+	return cfg, err
+}
+
+// SetAssistantConfig stores the assistant configuration; a masked API key keeps
+// the stored one
+func (s *AssistantService) SetAssistantConfig(cfg AssistantConfig) error {
+	cfg.Provider = strings.TrimSpace(cfg.Provider)
+	if cfg.Provider != "" && !containsString(assistantProviderTypes, cfg.Provider) {
+		return fmt.Errorf("unknown assistant provider %q", cfg.Provider)
+	}
+	cfg.URL = strings.TrimSpace(cfg.URL)
+	if cfg.URL != "" {
+		if err := checkAssistantURL(cfg.URL); err != nil {
+			return err
+		}
+	}
+	if cfg.ContextLines < 0 || cfg.ContextLines > assistantMaxLines {
+		return fmt.Errorf("context lines must be between 0 and %d", assistantMaxLines)
+	}
+	cfg.Model = strings.TrimSpace(cfg.Model)
+
+	stored, _ := loadAssistantConfig(s.db)
+	switch cfg.APIKey {
+	case maskedSecretValue:
+		cfg.APIKey = stored.APIKey
+	case "":
+	default:
+		sealed, err := s.secrets.Encrypt(cfg.APIKey)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt the assistant API key: %w", err)
+		}
+		cfg.APIKey = sealed
+	}
+	return s.db.SetSettingJSON(settingAssistant, cfg)
+}
+
+// TestAssistantConfig sends a short request to the configured provider and
+// returns its answer
+func (s *AssistantService) TestAssistantConfig() (string, error) {
+	cfg, err := s.config()
+	if err != nil {
+		return "", err
+	}
+	provider, err := newAssistantProvider(cfg)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), assistantTimeout)
+	defer cancel()
+	return provider.complete(ctx, "Answer in one word.", "Reply with OK.")
+}
@@ -0,0 +1,113 @@
+//go:build !windows
+
+package main
+
+import (
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// localBattery reads the battery from sysfs on Linux and pmset on macOS; nil on
+// machines without one
+func localBattery() *BatteryStatus {
+	if runtime.GOOS == "darwin" {
+		out, err := exec.Command("pmset", "-g", "batt").Output()
+		if err != nil {
+			return nil
+		}
+		return parsePmsetBattery(string(out))
+	}
+	return sysfsBattery()
+}
+
+// sysfsBattery combines the batteries under /sys/class/power_supply. Drivers report
+// levels as energy (µWh, µW) or as charge (µAh, µA); charge is turned into energy
+// with the battery's voltage, so batteries of both kinds add up.
+func sysfsBattery() *BatteryStatus {
+	supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+	var b *BatteryStatus
+	var now, full, rate, capacity float64
+	var count int
+	levels := true // every battery reported its levels as energy or convertible charge
+	for _, dir := range supplies {
+		read := func(name string) string {
+			raw, _ := os.ReadFile(filepath.Join(dir, name))
+			return strings.TrimSpace(string(raw))
+		}
+		num := func(names ...string) (float64, bool) {
+			for _, name := range names {
+				if v, err := strconv.ParseFloat(read(name), 64); err == nil {
+					return v, true
+				}
+			}
+			return 0, false
+		}
+		if read("type") != "Battery" || read("present") == "0" {
+			continue
+		}
+		if b == nil {
+			b = &BatteryStatus{State: "unknown"}
+		}
+		count++
+		c, _ := num("capacity")
+		capacity += c
+		voltage, _ := num("voltage_now", "voltage_min_design") // µV
+		// energy returns an energy (µWh) or power (µW) value, converting charge or current
+		energy := func(energyName, chargeName string) (float64, bool) {
+			if v, ok := num(energyName); ok {
+				return v, true
+			}
+			if v, ok := num(chargeName); ok && voltage > 0 {
+				return v * voltage / 1e6, true
+			}
+			return 0, false
+		}
+		bNow, okNow := energy("energy_now", "charge_now")
+		bFull, okFull := energy("energy_full", "charge_full")
+		if okNow && okFull {
+			now += bNow
+			full += bFull
+		} else {
+			levels = false
+		}
+		// Some drivers report the current as negative while discharging
+		if r, ok := energy("power_now", "current_now"); ok {
+			rate += math.Abs(r)
+		}
+		switch read("status") {
+		case "Charging":
+			b.State = "charging"
+		case "Discharging":
+			if b.State != "charging" {
+				b.State = "discharging"
+			}
+		case "Full", "Not charging":
+			if b.State == "unknown" {
+				b.State = "full"
+			}
+		}
+	}
+	if b == nil {
+		return nil
+	}
+
+	if !levels || full <= 0 {
+		b.Percent = capacity / float64(count)
+		return b
+	}
+	b.Percent = min(now/full*100, 100)
+	if rate > 0 {
+		switch b.State {
+		case "discharging":
+			b.MinutesRemaining = int(now / rate * 60)
+		case "charging":
+			b.MinutesRemaining = int(max(full-now, 0) / rate * 60)
+		}
+	}
+	return b
+}
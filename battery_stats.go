@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// BatteryStatus is the laptop battery in SystemStats
+type BatteryStatus struct {
+	Percent          float64 `json:"percent"`
+	State            string  `json:"state"`                      // "charging", "discharging", "full" or "unknown"
+	MinutesRemaining int     `json:"minutesRemaining,omitempty"` // until empty when discharging, full when charging; 0 when unknown
+}
+
+// pmsetBattery matches a battery line of `pmset -g batt` on macOS, e.g.
+// " -InternalBattery-0 (id=4653155)	85%; discharging; 4:12 remaining present: true"
+var pmsetBattery = regexp.MustCompile(`(\d+)%;\s*([^;]+);\s*(?:(\d+):(\d+) remaining)?`)
+
+// parsePmsetBattery reads `pmset -g batt` output; nil when there is no battery
+func parsePmsetBattery(output string) *BatteryStatus {
+	m := pmsetBattery.FindStringSubmatch(output)
+	if m == nil {
+		return nil
+	}
+	b := &BatteryStatus{State: "unknown"}
+	b.Percent, _ = strconv.ParseFloat(m[1], 64)
+	switch m[2] {
+	case "charging":
+		b.State = "charging"
+	case "discharging":
+		b.State = "discharging"
+	case "charged", "finishing charge":
+		b.State = "full"
+	}
+	if m[3] != "" && b.State != "full" {
+		hours, _ := strconv.Atoi(m[3])
+		minutes, _ := strconv.Atoi(m[4])
+		b.MinutesRemaining = hours*60 + minutes
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+//go:build windows
+
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procGetSystemPowerStatus = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// systemPowerStatus is SYSTEM_POWER_STATUS
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// Battery flags and unknown values of SYSTEM_POWER_STATUS
+const (
+	batteryFlagCharging   = 8
+	batteryFlagNone       = 128
+	batteryFlagUnknown    = 255
+	batteryPercentUnknown = 255
+	batteryTimeUnknown    = 0xFFFFFFFF
+)
+
+// localBattery reads the battery with GetSystemPowerStatus; nil on machines
+// without one
+func localBattery() *BatteryStatus {
+	var st systemPowerStatus
+	if r, _, _ := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&st))); r == 0 {
+		return nil
+	}
+	if st.BatteryFlag&batteryFlagNone != 0 || st.BatteryFlag == batteryFlagUnknown || st.BatteryLifePercent == batteryPercentUnknown {
+		return nil
+	}
+	b := &BatteryStatus{Percent: float64(st.BatteryLifePercent), State: "unknown"}
+	switch {
+	case st.BatteryFlag&batteryFlagCharging != 0:
+		b.State = "charging"
+	case st.ACLineStatus == 1:
+		b.State = "full"
+	case st.ACLineStatus == 0:
+		b.State = "discharging"
+		// Windows only estimates the time left while on battery
+		if st.BatteryLifeTime != batteryTimeUnknown {
+			b.MinutesRemaining = int(st.BatteryLifeTime / 60)
+		}
+	}
+	return b
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// cliDiscoveryFile is written to the config directory while the app runs, with
+// the URL and token of its API
+const cliDiscoveryFile = "api.json"
+
+// cliCommand is a subcommand of the term CLI: `term <name> args...`
+type cliCommand struct {
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+var cliCommands map[string]cliCommand
+
+func init() {
+	cliCommands = map[string]cliCommand{
+		"list":      {"list", "List saved sessions", cliList},
+		"open":      {"open <session>", "Open a saved session (ID, name or folder/name) in a new tab", cliOpen},
+		"ssh":       {"ssh [-i key] [-p port] [user@]host[:port]", "Open an SSH tab, using the saved session for the host if any", cliSSH},
+		"terminals": {"terminals", "List running terminal IDs", cliTerminals},
+		"record":    {"record start [-input] <terminal> | record stop <terminal>", "Start or stop recording a terminal", cliRecord},
+		"recording": {"recording info|convert|decrypt|verify <file.trm>", "Process a recording file, without the app (term recording -h)", cliRecording},
+	}
+}
+
+// runCLI runs a CLI subcommand when args start with one, instead of the GUI; ok
+// is false for anything else, e.g. arguments the OS passes to the app
+func runCLI(args []string) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "help", "-h", "--help", "-help":
+		printCLIUsage(os.Stdout)
+		return 0, true
+	}
+	cmd, found := cliCommands[args[0]]
+	if !found {
+		return 0, false
+	}
+	if err := cmd.run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "term %s: %v\n", args[0], err)
+		return 1, true
+	}
+	return 0, true
+}
+
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: term <command> [arguments]")
+	fmt.Fprintln(w, "\nWithout a command the app window opens. Commands other than recording talk to the running app:")
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", cliCommands[name].usage, cliCommands[name].summary)
+	}
+	tw.Flush()
+}
+
+// cliClient calls the API of the running app, found through its discovery file
+type cliClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+var errAppNotRunning = errors.New("Terminal Manager is not running")
+
+func newCLIClient() (*cliClient, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "term", cliDiscoveryFile))
+	if os.IsNotExist(err) {
+		return nil, errAppNotRunning
+	} else if err != nil {
+		return nil, err
+	}
+	var d apiDiscovery
+	if err := json.Unmarshal(data, &d); err != nil || d.URL == "" {
+		return nil, fmt.Errorf("invalid %s: %v", cliDiscoveryFile, err)
+	}
+	return &cliClient{base: d.URL + apiPrefix, token: d.Token, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// call sends a request with in as JSON body and decodes the answer into out;
+// API errors are returned with their message
+func (c *cliClient) call(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return errAppNotRunning
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		var apiErr APIErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Message != "" {
+			return errors.New(apiErr.Error.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// parseSSHTarget reads [user@]host[:port]; IPv6 hosts with a port are written
+// in brackets, e.g. [::1]:2222
+func parseSSHTarget(s string) (sshTarget, error) {
+	var t sshTarget
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		t.User, s = s[:i], s[i+1:]
+	}
+	host := s
+	bracketed := strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]")
+	if !bracketed && (strings.HasPrefix(s, "[") || strings.Count(s, ":") == 1) {
+		h, port, err := net.SplitHostPort(s)
+		if err != nil {
+			return t, fmt.Errorf("invalid host %q", s)
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return t, fmt.Errorf("invalid port %q", port)
+		}
+		host, t.Port = h, n
+	}
+	t.Host = strings.Trim(host, "[]")
+	if t.Host == "" {
+		return t, fmt.Errorf("host is required")
+	}
+	return t, nil
+}
+
+func cliList(args []string) error {
+	c, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	var sessions []APISession
+	if err := c.call(http.MethodGet, "/sessions", nil, &sessions); err != nil {
+		return err
+	}
+	names := make(map[string]APISession, len(sessions))
+	for _, s := range sessions {
+		names[s.ID] = s
+	}
+	type row struct{ path, sessionType, id string }
+	var rows []row
+	for _, s := range sessions {
+		if s.Type == "folder" {
+			continue
+		}
+		parts := []string{s.Name}
+		for p := s.ParentID; p != nil; {
+			parent, ok := names[*p]
+			if !ok {
+				break
+			}
+			parts = append([]string{parent.Name}, parts...)
+			p = parent.ParentID
+		}
+		rows = append(rows, row{strings.Join(parts, "/"), s.SessionType, s.ID})
+	}
+	sort.Slice(rows, func(i, j int) bool { return strings.ToLower(rows[i].path) < strings.ToLower(rows[j].path) })
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.path, r.sessionType, r.id)
+	}
+	return tw.Flush()
+}
+
+func cliOpen(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: term %s", cliCommands["open"].usage)
+	}
+	c, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	var result UIOpenResult
+	if err := c.call(http.MethodPost, "/ui/open", UIOpenRequest{Session: args[0]}, &result); err != nil {
+		return err
+	}
+	fmt.Printf("Opened %s\n", result.Name)
+	return nil
+}
+
+func cliSSH(args []string) error {
+	fs := flag.NewFlagSet("ssh", flag.ContinueOnError)
+	key := fs.String("i", "", "private key of a host without a saved session")
+	port := fs.Int("p", 0, "port")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: term %s", cliCommands["ssh"].usage)
+	}
+	target, err := parseSSHTarget(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *port != 0 {
+		target.Port = *port
+	}
+	if *key != "" {
+		if target.KeyPath, err = filepath.Abs(*key); err != nil {
+			return err
+		}
+	}
+	c, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	var result UIOpenResult
+	if err := c.call(http.MethodPost, "/ui/ssh", target, &result); err != nil {
+		return err
+	}
+	if result.Mode == "saved" {
+		fmt.Printf("Opened saved session %s\n", result.Name)
+	} else {
+		fmt.Printf("Opened %s\n", result.Name)
+	}
+	return nil
+}
+
+func cliTerminals(args []string) error {
+	c, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	var list TerminalList
+	if err := c.call(http.MethodGet, "/terminals", nil, &list); err != nil {
+		return err
+	}
+	sort.Strings(list.Terminals)
+	for _, id := range list.Terminals {
+		fmt.Println(id)
+	}
+	return nil
+}
+
+func cliRecord(args []string) error {
+	usage := fmt.Errorf("usage: term %s", cliCommands["record"].usage)
+	if len(args) == 0 {
+		return usage
+	}
+	fs := flag.NewFlagSet("record "+args[0], flag.ContinueOnError)
+	input := fs.Bool("input", false, "also record typed input")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usage
+	}
+	path := "/terminals/" + url.PathEscape(fs.Arg(0)) + "/recording"
+	c, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "start":
+		if err := c.call(http.MethodPost, path, map[string]bool{"captureInput": *input}, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Recording %s\n", fs.Arg(0))
+	case "stop":
+		if err := c.call(http.MethodDelete, path, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Stopped recording %s\n", fs.Arg(0))
+	default:
+		return usage
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"term/database"
+)
+
+// Settings naming the password manager CLIs; empty finds them in PATH
+const (
+	settingOnePasswordCLI = "onepassword_cli_path"
+	settingBitwardenCLI   = "bitwarden_cli_path"
+)
+
+// Secret references resolved by the CLI providers, written as config values
+const (
+	onePasswordRefPrefix = "op://" // op://<vault>/<item>/[<section>/]<field>
+	bitwardenRefPrefix   = "bw://" // bw://<item id or name>/<field>
+)
+
+// resolveReferences replaces every config value starting with prefix by what
+// read returns for it. Only the references are returned.
+func resolveReferences(ctx context.Context, cfg map[string]string, prefix string, read func(ctx context.Context, ref string) (string, error)) (map[string]string, error) {
+	values := map[string]string{}
+	for key, value := range cfg {
+		ref := strings.TrimSpace(value)
+		if !strings.HasPrefix(ref, prefix) {
+			continue
+		}
+		secret, err := read(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		values[key] = secret
+	}
+	return values, nil
+}
+
+// runCredentialCLI runs a password manager CLI and returns its output. Its
+// error output explains most failures, e.g. being signed out.
+func runCredentialCLI(ctx context.Context, cli string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, cli, args...)
+	setCmdNoWindow(cmd)
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func credentialCLIPath(db *database.DB, setting, name string) (string, error) {
+	if path := strings.TrimSpace(settingValue(db, setting)); path != "" {
+		return path, nil
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s not found in PATH; install it or set %s", name, setting)
+	}
+	return path, nil
+}
+
+// onePassword resolves op:// secret references with the 1Password CLI, which
+// signs in through the desktop app or OP_SERVICE_ACCOUNT_TOKEN. Secrets are
+// read on every connect and never cached.
+type onePassword struct {
+	db *database.DB
+}
+
+func (p *onePassword) Resolve(ctx context.Context, cfg map[string]string) (map[string]string, error) {
+	cli, err := credentialCLIPath(p.db, settingOnePasswordCLI, "op")
+	if err != nil {
+		return nil, err
+	}
+	return resolveReferences(ctx, cfg, onePasswordRefPrefix, func(ctx context.Context, ref string) (string, error) {
+		out, err := runCredentialCLI(ctx, cli, nil, "read", "--no-newline", ref)
+		if err != nil {
+			return "", fmt.Errorf("op read %s: %w", ref, err)
+		}
+		return string(out), nil
+	})
+}
+
+func (p *onePassword) Forget() {}
+
+// bitwarden resolves bw:// references with the Bitwarden CLI. bw needs an
+// unlocked session: the key from `bw unlock` set with SetBitwardenSession,
+// which is only kept in memory, or BW_SESSION.
+type bitwarden struct {
+	db *database.DB
+
+	mu      sync.Mutex
+	session string
+}
+
+// bitwardenItem is the part of `bw get item` output references can name
+type bitwardenItem struct {
+	ID    string `json:"id"`
+	Notes string `json:"notes"`
+	Login *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"login"`
+	Fields []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+}
+
+func (p *bitwarden) Resolve(ctx context.Context, cfg map[string]string) (map[string]string, error) {
+	cli, err := credentialCLIPath(p.db, settingBitwardenCLI, "bw")
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	p.mu.Lock()
+	if p.session != "" {
+		env = append(env, "BW_SESSION="+p.session)
+	}
+	p.mu.Unlock()
+
+	// Several keys usually name fields of the same item
+	items := map[string]*bitwardenItem{}
+	return resolveReferences(ctx, cfg, bitwardenRefPrefix, func(ctx context.Context, ref string) (string, error) {
+		rest := strings.TrimPrefix(ref, bitwardenRefPrefix)
+		i := strings.LastIndex(rest, "/")
+		if i <= 0 || i == len(rest)-1 {
+			return "", fmt.Errorf("%s: expected bw://<item>/<field>", ref)
+		}
+		name, field := rest[:i], rest[i+1:]
+
+		item, ok := items[name]
+		if !ok {
+			// "--" keeps a name starting with "-" from being read as an option
+			out, err := runCredentialCLI(ctx, cli, env, "get", "item", "--", name)
+			if err != nil {
+				return "", fmt.Errorf("bw get item %s: %w", name, err)
+			}
+			item = &bitwardenItem{}
+			if err := json.Unmarshal(out, item); err != nil {
+				return "", fmt.Errorf("bw get item %s: invalid output: %w", name, err)
+			}
+			items[name] = item
+		}
+
+		switch field {
+		case "username", "password":
+			if item.Login == nil {
+				return "", fmt.Errorf("%s is not a login item", name)
+			}
+			if field == "username" {
+				return item.Login.Username, nil
+			}
+			return item.Login.Password, nil
+		case "notes":
+			return item.Notes, nil
+		case "totp":
+			out, err := runCredentialCLI(ctx, cli, env, "get", "totp", "--", item.ID)
+			if err != nil {
+				return "", fmt.Errorf("bw get totp %s: %w", name, err)
+			}
+			return strings.TrimSpace(string(out)), nil
+		}
+		for _, f := range item.Fields {
+			if f.Name == field {
+				return f.Value, nil
+			}
+		}
+		return "", fmt.Errorf("%s has no field %q", name, field)
+	})
+}
+
+// Forget drops the Bitwarden session key
+func (p *bitwarden) Forget() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.session = ""
+}
+
+// SetBitwardenSession sets the session key printed by `bw unlock`, used until
+// the app quits or the vault locks; "" falls back to BW_SESSION
+func (s *CredentialProviderService) SetBitwardenSession(session string) {
+	s.bitwarden.mu.Lock()
+	defer s.bitwarden.mu.Unlock()
+	s.bitwarden.session = strings.TrimSpace(session)
+}
+
+// CheckCredentialCLI reports whether the CLI of a password manager provider
+// is installed and signed in: the account for 1Password, the vault status for
+// Bitwarden
+func (s *CredentialProviderService) CheckCredentialCLI(provider string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialProviderTimeout)
+	defer cancel()
+	switch provider {
+	case "1password":
+		cli, err := credentialCLIPath(s.db, settingOnePasswordCLI, "op")
+		if err != nil {
+			return "", err
+		}
+		out, err := runCredentialCLI(ctx, cli, nil, "whoami")
+		return strings.TrimSpace(string(out)), err
+	case "bitwarden":
+		cli, err := credentialCLIPath(s.db, settingBitwardenCLI, "bw")
+		if err != nil {
+			return "", err
+		}
+		var env []string
+		s.bitwarden.mu.Lock()
+		if s.bitwarden.session != "" {
+			env = append(env, "BW_SESSION="+s.bitwarden.session)
+		}
+		s.bitwarden.mu.Unlock()
+		out, err := runCredentialCLI(ctx, cli, env, "status")
+		if err != nil {
+			return "", err
+		}
+		var status struct {
+			Status    string `json:"status"` // unauthenticated, locked or unlocked
+			UserEmail string `json:"userEmail"`
+		}
+		if err := json.Unmarshal(out, &status); err != nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+		if status.UserEmail != "" {
+			return status.Status + " (" + status.UserEmail + ")", nil
+		}
+		return status.Status, nil
+	}
+	return "", fmt.Errorf("%s is not a CLI credential provider", provider)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"term/database"
+)
+
+// recordingPassphraseEnv holds the recording passphrase for the offline
+// commands, so it stays out of the process list
+const recordingPassphraseEnv = "TERM_RECORDING_PASSPHRASE"
+
+// `term recording` works on .trm files without the app, e.g. on a server or in CI
+
+const recordingUsage = `Usage: term recording <command> [options] <file.trm>
+
+Commands:
+  info      Print the size, duration, events and markers of a recording
+  convert   Write a recording as asciicast v2 (asciinema) or plain text
+  decrypt   Write an encrypted recording as a plain .trm file
+  verify    Read a whole recording and report damaged or truncated data
+
+Encrypted recordings need the recordings database (-db, by default the app's)
+and the recording passphrase, from -passphrase-file or $` + recordingPassphraseEnv + `.
+`
+
+var recordingSubcommands = map[string]func(args []string) error{
+	"info":    cliRecordingInfo,
+	"convert": cliRecordingConvert,
+	"decrypt": cliRecordingDecrypt,
+	"verify":  cliRecordingVerify,
+}
+
+func cliRecording(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Print(recordingUsage)
+		return nil
+	}
+	run, ok := recordingSubcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], recordingUsage)
+	}
+	return run(args[1:])
+}
+
+// recordingKeyFlags are the options locating the key of encrypted recordings
+type recordingKeyFlags struct {
+	dbPath         string
+	passphraseFile string
+}
+
+func (k *recordingKeyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&k.dbPath, "db", "", "recordings database holding the keys (default: the app's)")
+	fs.StringVar(&k.passphraseFile, "passphrase-file", "", "file with the recording passphrase, - for stdin (default: $"+recordingPassphraseEnv+")")
+}
+
+func (k *recordingKeyFlags) passphrase() (string, error) {
+	if k.passphraseFile == "" {
+		if p := os.Getenv(recordingPassphraseEnv); p != "" {
+			return p, nil
+		}
+		return "", fmt.Errorf("the recording is encrypted; pass -passphrase-file or set %s", recordingPassphraseEnv)
+	}
+	var data []byte
+	var err error
+	if k.passphraseFile == "-" {
+		data, err = bufio.NewReader(os.Stdin).ReadBytes('\n')
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		data, err = os.ReadFile(k.passphraseFile)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// openDB opens the recordings database; it must exist, and an encrypted one is
+// refused, as reading it would write it back under the running app
+func (k *recordingKeyFlags) openDB() (*database.DB, error) {
+	path := k.dbPath
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, "term", "term.db")
+	}
+	if _, err := os.Stat(database.EncryptedPath(path)); err == nil {
+		return nil, fmt.Errorf("%s is encrypted with a master password; replay the recording in the app instead", path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no recordings database at %s; pass -db", path)
+	}
+	return database.New(path)
+}
+
+// openRecording opens a recording file and reads its header, decrypting it when
+// needed; the caller closes the returned file
+func openRecording(path string, keys *recordingKeyFlags) (*os.File, *TermrecReader, *TermrecHeaderRead, bool, error) {
+	plain, err := isPlainTermrec(path)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	var r io.Reader = f
+	if !plain {
+		fileKey, err := recordingFileKey(path, keys)
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, false, err
+		}
+		if r, err = NewChunkedAEADReader(f, fileKey); err != nil {
+			f.Close()
+			return nil, nil, nil, false, err
+		}
+	}
+	tr, err := NewTermrecReader(r)
+	if err != nil {
+		f.Close()
+		if !plain {
+			return nil, nil, nil, false, fmt.Errorf("cannot decrypt %s: %w", filepath.Base(path), err)
+		}
+		return nil, nil, nil, false, err
+	}
+	hdr, err := tr.ReadHeader()
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, false, fmt.Errorf("invalid header: %w", err)
+	}
+	return f, tr, hdr, !plain, nil
+}
+
+// recordingFileKey looks up the wrapped key of a recording file in the database
+// and unwraps it with the passphrase
+func recordingFileKey(path string, keys *recordingKeyFlags) ([]byte, error) {
+	passphrase, err := keys.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	db, err := keys.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rec, err := findRecordingRow(db, path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := db.GetSetting("recording_kdf_salt")
+	if err != nil || s == nil || s.Value == "" {
+		return nil, fmt.Errorf("the database has no recording key salt")
+	}
+	salt, err := decodeB64(s.Value)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapRecordingKey(db, rec.ID, salt, passphrase)
+}
+
+// recordingArgs parses the options and the one file of a recording command
+func recordingArgs(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("usage: term recording %s [options] <file.trm>", fs.Name())
+	}
+	return fs.Arg(0), nil
+}
+
+// createOutput opens the -o file, or stdout for "" and "-"
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func cliRecordingInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	var keys recordingKeyFlags
+	keys.register(fs)
+	asJSON := fs.Bool("json", false, "print JSON")
+	path, err := recordingArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	f, tr, hdr, encrypted, err := openRecording(path, &keys)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info := summarizeRecording(path, encrypted, hdr, tr)
+	if st, err := f.Stat(); err == nil {
+		info.SizeBytes = st.Size()
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "File\t%s\n", info.Path)
+	fmt.Fprintf(tw, "Size\t%d bytes\n", info.SizeBytes)
+	fmt.Fprintf(tw, "Encrypted\t%t\n", info.Encrypted)
+	fmt.Fprintf(tw, "Started\t%s\n", info.StartedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(tw, "Duration\t%s\n", info.Duration)
+	fmt.Fprintf(tw, "Terminal\t%dx%d\n", info.Cols, info.Rows)
+	fmt.Fprintf(tw, "Input captured\t%t\n", info.CaptureInput)
+	fmt.Fprintf(tw, "Events\t%d (%d output bytes, %d input bytes, %d resizes)\n", info.Events, info.OutputBytes, info.InputBytes, info.Resizes)
+	for _, m := range info.Markers {
+		fmt.Fprintf(tw, "Marker\t%s %s\n", m.At, m.Label)
+	}
+	if info.Error != "" {
+		fmt.Fprintf(tw, "Error\t%s\n", info.Error)
+	}
+	return tw.Flush()
+}
+
+func cliRecordingConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	var keys recordingKeyFlags
+	keys.register(fs)
+	format := fs.String("format", "asciicast", "asciicast or text")
+	out := fs.String("o", "", "output file (default: stdout)")
+	input := fs.Bool("input", false, "include typed input in asciicast output")
+	path, err := recordingArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *format != "asciicast" && *format != "text" {
+		return fmt.Errorf("unknown format %q: use asciicast or text", *format)
+	}
+	f, tr, hdr, _, err := openRecording(path, &keys)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := createOutput(*out)
+	if err != nil {
+		return err
+	}
+
+	var handle func(recordingEvent) error
+	var flush func() error
+	if *format == "text" {
+		tw := newTextWriter(w)
+		handle, flush = tw.event, tw.Flush
+	} else {
+		aw, err := newAsciicastWriter(w, hdr, *input)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		handle, flush = aw.event, aw.Flush
+	}
+	walkErr := walkRecording(tr, handle)
+	err = flush()
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// A truncated recording is converted up to where it ends
+	if errors.Is(walkErr, errRecordingTruncated) {
+		fmt.Fprintf(os.Stderr, "warning: %v; converted up to its last complete event\n", walkErr)
+		return nil
+	}
+	return walkErr
+}
+
+func cliRecordingDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	var keys recordingKeyFlags
+	keys.register(fs)
+	out := fs.String("o", "", "decrypted .trm file to write (required)")
+	path, err := recordingArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("-o is required")
+	}
+	if plain, err := isPlainTermrec(path); err != nil {
+		return err
+	} else if plain {
+		return fmt.Errorf("%s is not encrypted", filepath.Base(path))
+	}
+	f, tr, hdr, _, err := openRecording(path, &keys)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dst, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	cp := newTermrecCopier(dst)
+	err = cp.writeHeader(hdr)
+	if err == nil {
+		err = walkRecording(tr, cp.event)
+	}
+	// A truncated recording is decrypted up to where it ends
+	truncated := errors.Is(err, errRecordingTruncated)
+	if err == nil || truncated {
+		if ferr := cp.Flush(); ferr != nil {
+			err, truncated = ferr, false
+		}
+	}
+	if cerr := dst.Close(); cerr != nil && (err == nil || truncated) {
+		err, truncated = cerr, false
+	}
+	if truncated {
+		fmt.Fprintf(os.Stderr, "warning: %v; decrypted up to its last complete event\n", errRecordingTruncated)
+		return nil
+	}
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	return nil
+}
+
+func cliRecordingVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	var keys recordingKeyFlags
+	keys.register(fs)
+	path, err := recordingArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	f, tr, hdr, encrypted, err := openRecording(path, &keys)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info := summarizeRecording(path, encrypted, hdr, tr)
+	if info.Error != "" {
+		return fmt.Errorf("%s: %s after %d events (%s)", filepath.Base(path), info.Error, info.Events, info.Duration)
+	}
+	what := "plain"
+	if encrypted {
+		what = "encrypted, every chunk authenticated"
+	}
+	fmt.Printf("%s: OK, %d events, %s (%s)\n", filepath.Base(path), info.Events, info.Duration, what)
+	return nil
+}
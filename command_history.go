@@ -0,0 +1,391 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+	"term/logging"
+)
+
+var historyLog = logging.For("history")
+
+const (
+	settingCommandHistory          = "command_history"
+	settingCommandHistoryRetention = "command_history_retention_days"
+	defaultHistoryRetentionDays    = 365
+
+	historyPurgeInterval = 24 * time.Hour
+	historyMaxCommand    = 4096 // longer lines are not commands someone looks up
+	historyDefaultLimit  = 100
+	historyMaxLimit      = 1000
+)
+
+// commandHistory follows the terminals to record the commands run in them.
+//
+// Shells with shell integration mark their prompt and each command with OSC 133
+// (or VS Code's OSC 633): A or B at the prompt, C when a command starts and
+// D;<exit code> when it ends; 633;E carries the command line itself. Those
+// commands are recorded with their exit code and duration. In other terminals
+// the lines typed at Enter are recorded, without an exit code. Lines edited with
+// the arrow keys, tab completion or history search are skipped, as what was run
+// is not known, as are lines typed after a password prompt.
+type commandHistory struct {
+	db *database.DB
+
+	mu   sync.Mutex
+	tabs map[string]*historyTab
+}
+
+type historyTab struct {
+	// Typed input
+	line      []rune
+	lineValid bool
+	escape    []byte // escape sequence being typed
+
+	// Output
+	osc      []byte // OSC sequence being received
+	inOSC    bool
+	afterEsc bool
+	lastLine []byte // text after the last newline, for password prompts
+
+	// Shell integration
+	markers     bool
+	atPrompt    bool
+	typed       string // line entered at the prompt
+	commandLine string // from OSC 633;E
+	started     *database.CommandHistoryEntry
+}
+
+func newCommandHistory(db *database.DB) *commandHistory {
+	return &commandHistory{db: db, tabs: make(map[string]*historyTab)}
+}
+
+func (h *commandHistory) tab(id string) *historyTab {
+	tab, ok := h.tabs[id]
+	if !ok {
+		tab = &historyTab{lineValid: true}
+		h.tabs[id] = tab
+	}
+	return tab
+}
+
+// input follows what is typed into a terminal. The methods do nothing on a nil
+// history, so terminals work without one.
+func (h *commandHistory) input(session *TerminalSession, data string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tab := h.tab(session.ID)
+	for _, r := range data {
+		if tab.escape != nil {
+			tab.escape = append(tab.escape, string(r)...)
+			var done bool
+			switch {
+			case len(tab.escape) == 2: // Alt+key, or the start of CSI or SS3
+				done = r != '[' && r != 'O'
+			case tab.escape[1] == 'O':
+				done = true
+			default:
+				done = r >= 0x40 && r <= 0x7e
+			}
+			if done {
+				// Bracketed paste keeps the line; cursor and function keys edit it unseen
+				if seq := string(tab.escape); seq != "\x1b[200~" && seq != "\x1b[201~" {
+					tab.lineValid = false
+				}
+				tab.escape = nil
+			}
+			continue
+		}
+		switch {
+		case r == 0x1b:
+			tab.escape = []byte{0x1b}
+		case r == '\r' || r == '\n':
+			h.enter(session, tab)
+		case r == 0x7f || r == 0x08:
+			if len(tab.line) > 0 {
+				tab.line = tab.line[:len(tab.line)-1]
+			}
+		case r == 0x03 || r == 0x15: // Ctrl+C, Ctrl+U discard the line
+			tab.line, tab.lineValid = nil, true
+		case r < 0x20:
+			tab.lineValid = false
+		default:
+			tab.line = append(tab.line, r)
+		}
+	}
+}
+
+// enter handles Enter: at a shell integration prompt the line is kept for the
+// command that starts next, otherwise it is recorded as typed
+func (h *commandHistory) enter(session *TerminalSession, tab *historyTab) {
+	line := strings.TrimSpace(string(tab.line))
+	valid := tab.lineValid && !tab.atPasswordPrompt()
+	tab.line, tab.lineValid = nil, true
+	if !valid || line == "" || len(line) > historyMaxCommand {
+		return
+	}
+	if tab.markers {
+		if tab.atPrompt {
+			tab.typed = line
+		}
+		return
+	}
+	h.save(&database.CommandHistoryEntry{
+		SessionID:   session.NodeID,
+		SessionName: session.Name,
+		Host:        session.Host,
+		Command:     line,
+		StartedAt:   time.Now(),
+		Source:      "input",
+	})
+}
+
+func (tab *historyTab) atPasswordPrompt() bool {
+	last := strings.ToLower(string(tab.lastLine))
+	return strings.Contains(last, "password") || strings.Contains(last, "passphrase")
+}
+
+// output follows a terminal's output for shell integration markers and prompts
+func (h *commandHistory) output(session *TerminalSession, data []byte) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tab := h.tab(session.ID)
+	for _, b := range data {
+		if tab.inOSC {
+			switch {
+			case b == 0x07:
+				h.osc(session, tab, string(tab.osc))
+				tab.osc, tab.inOSC = nil, false
+			case b == 0x1b:
+				tab.afterEsc = true
+			case tab.afterEsc && b == '\\':
+				h.osc(session, tab, string(tab.osc))
+				tab.osc, tab.inOSC, tab.afterEsc = nil, false, false
+			case len(tab.osc) < historyMaxCommand+64:
+				tab.afterEsc = false
+				tab.osc = append(tab.osc, b)
+			}
+			continue
+		}
+		if tab.afterEsc {
+			tab.afterEsc = false
+			if b == ']' {
+				tab.inOSC = true
+				continue
+			}
+		}
+		switch {
+		case b == 0x1b:
+			tab.afterEsc = true
+		case b == '\n':
+			tab.lastLine = tab.lastLine[:0]
+		case b >= 0x20 && len(tab.lastLine) < 256:
+			tab.lastLine = append(tab.lastLine, b)
+		}
+	}
+}
+
+// osc handles an OSC 133 or 633 shell integration marker
+func (h *commandHistory) osc(session *TerminalSession, tab *historyTab, seq string) {
+	var rest string
+	if after, ok := strings.CutPrefix(seq, "133;"); ok {
+		rest = after
+	} else if after, ok := strings.CutPrefix(seq, "633;"); ok {
+		rest = after
+	} else {
+		return
+	}
+	tab.markers = true
+	kind, params, _ := strings.Cut(rest, ";")
+	switch kind {
+	case "A", "B":
+		// A new prompt without D: the last command's end is unknown
+		if tab.started != nil {
+			h.save(tab.started)
+			tab.started = nil
+		}
+		tab.atPrompt = true
+	case "E":
+		line, _, _ := strings.Cut(params, ";") // a nonce may follow
+		tab.commandLine = unescapeOSC633(line)
+	case "C":
+		tab.atPrompt = false
+		command := tab.commandLine
+		if command == "" {
+			command = tab.typed
+		}
+		tab.typed, tab.commandLine = "", ""
+		if command = strings.TrimSpace(command); command != "" {
+			tab.started = &database.CommandHistoryEntry{
+				SessionID:   session.NodeID,
+				SessionName: session.Name,
+				Host:        session.Host,
+				Command:     command,
+				StartedAt:   time.Now(),
+				Source:      "marker",
+			}
+		}
+	case "D":
+		entry := tab.started
+		tab.started = nil
+		if entry == nil && tab.typed != "" {
+			// Shells that only mark the end of a command
+			entry = &database.CommandHistoryEntry{
+				SessionID:   session.NodeID,
+				SessionName: session.Name,
+				Host:        session.Host,
+				Command:     tab.typed,
+				StartedAt:   time.Now(),
+				Source:      "marker",
+			}
+		} else if entry != nil {
+			ms := time.Since(entry.StartedAt).Milliseconds()
+			entry.DurationMs = &ms
+		}
+		tab.typed = ""
+		if entry == nil {
+			return
+		}
+		if code, err := strconv.Atoi(strings.TrimSpace(params)); err == nil {
+			entry.ExitCode = &code
+		}
+		h.save(entry)
+	}
+}
+
+// unescapeOSC633 decodes the command line of OSC 633;E, which escapes \ and
+// characters below 0x20 and ; as \xAB
+func unescapeOSC633(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] == '\\' {
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+			if s[i+1] == 'x' && i+3 < len(s) {
+				if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					b.WriteByte(byte(v))
+					i += 3
+					continue
+				}
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// forget drops a terminal's state when it closes, recording a command still
+// running
+func (h *commandHistory) forget(id string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if tab, ok := h.tabs[id]; ok && tab.started != nil {
+		h.save(tab.started)
+	}
+	delete(h.tabs, id)
+}
+
+func (h *commandHistory) save(entry *database.CommandHistoryEntry) {
+	if h.db.Locked() || !settingBool(h.db, settingCommandHistory) {
+		return
+	}
+	// Passwords and tokens passed on the command line stay out of the history
+	entry.Command = logging.Redact(entry.Command)
+	if err := h.db.AddCommandHistory(entry); err != nil {
+		historyLog.Warn("failed to record command", "session", entry.SessionID, "err", err)
+	}
+}
+
+// terminalHost names the machine a terminal runs on for the history: the SSH or
+// telnet host, or this computer
+func terminalHost(sessionType string, config map[string]string) string {
+	switch sessionType {
+	case "ssh":
+		return config["ssh_host"]
+	case "telnet":
+		return config["telnet_host"]
+	case "serial":
+		return config["serial_port"]
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// HistoryService searches the commands run across all terminals, e.g. "the
+// kubectl command run on staging last Tuesday"
+type HistoryService struct {
+	db *database.DB
+}
+
+// NewHistoryService creates the history service
+func NewHistoryService(db *database.DB) *HistoryService {
+	return &HistoryService{db: db}
+}
+
+// SearchHistory returns the commands matching query, newest first, and how many
+// match in total
+func (s *HistoryService) SearchHistory(query database.CommandHistoryQuery) ([]database.CommandHistoryEntry, int, error) {
+	if query.Limit <= 0 {
+		query.Limit = historyDefaultLimit
+	}
+	query.Limit = min(query.Limit, historyMaxLimit)
+	query.Offset = max(query.Offset, 0)
+	return s.db.SearchCommandHistory(query)
+}
+
+// DeleteHistoryEntry removes a command from the history, e.g. one with a secret
+func (s *HistoryService) DeleteHistoryEntry(id int64) error {
+	return s.db.DeleteCommandHistory(id)
+}
+
+// ClearHistory removes the whole command history
+func (s *HistoryService) ClearHistory() (int64, error) {
+	return s.db.PurgeCommandHistory(time.Now().Add(time.Minute))
+}
+
+// StartPurge removes commands older than command_history_retention_days, at
+// startup and then daily
+func (s *HistoryService) StartPurge() {
+	go func() {
+		s.purge()
+		ticker := time.NewTicker(historyPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.purge()
+		}
+	}()
+}
+
+func (s *HistoryService) purge() {
+	days := settingInt(s.db, settingCommandHistoryRetention)
+	if days == 0 || s.db.Locked() {
+		return
+	}
+	n, err := s.db.PurgeCommandHistory(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		historyLog.Warn("failed to purge command history", "err", err)
+		return
+	}
+	if n > 0 {
+		historyLog.Info("purged old commands", "count", n, "days", days)
+	}
+}
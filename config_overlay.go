@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"term/database"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config files read from the config directory at startup; the first one found is
+// used
+var configOverlayFiles = []string{"config.toml", "config.json"}
+
+// ConfigOverlayStatus describes the config file applied at startup, so the UI
+// can mark the settings it manages
+type ConfigOverlayStatus struct {
+	Path   string   `json:"path"`   // "" when there is no config file
+	Keys   []string `json:"keys"`   // settings set by the file
+	Errors []string `json:"errors"` // entries that were skipped
+}
+
+// readConfigOverlay parses a config file into setting values. Top-level keys are
+// setting keys; JSON settings such as keymap can be given as tables or arrays.
+func readConfigOverlay(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	if filepath.Ext(path) == ".toml" {
+		err = toml.Unmarshal(data, &values)
+	} else {
+		err = json.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// applyConfigOverlay overrides the database settings with the values of the
+// config file in dir, for provisioned machines and version-controlled defaults.
+// Entries that are unknown, secret or invalid are skipped and logged; the valid
+// ones are written in one transaction, and only when they differ. A locked
+// database is read-only, so the file is applied once it is unlocked.
+func (s *SettingsService) applyConfigOverlay(dir string) {
+	status := ConfigOverlayStatus{Keys: []string{}, Errors: []string{}}
+	defer func() {
+		s.mu.Lock()
+		s.overlay = status
+		s.mu.Unlock()
+	}()
+
+	var values map[string]interface{}
+	for _, name := range configOverlayFiles {
+		path := filepath.Join(dir, name)
+		v, err := readConfigOverlay(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		status.Path = path
+		if err != nil {
+			settingsLog.Error("failed to read config file", "err", err)
+			status.Errors = append(status.Errors, err.Error())
+			return
+		}
+		values = v
+		break
+	}
+	if status.Path == "" {
+		return
+	}
+	if s.db.Locked() {
+		settingsLog.Info("config file is applied once the database is unlocked", "path", status.Path)
+		s.mu.Lock()
+		s.overlayDir = dir
+		s.mu.Unlock()
+		return
+	}
+
+	var changed []database.Setting
+	for key, v := range values {
+		def, ok := settingDefs[key]
+		if !ok || def.Secret {
+			status.Errors = append(status.Errors, fmt.Sprintf("%s: not a setting that can be configured", key))
+			continue
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			status.Errors = append(status.Errors, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		value, err := decodeSettingValue(def, raw)
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %w", key, err)
+		} else {
+			err = validateSetting(def, value)
+		}
+		if err != nil {
+			status.Errors = append(status.Errors, err.Error())
+			continue
+		}
+		status.Keys = append(status.Keys, key)
+		if current, err := s.db.GetSetting(key); err != nil || current.Value != value {
+			changed = append(changed, database.Setting{Key: key, Value: value, ValueType: def.Type})
+		}
+	}
+	sort.Strings(status.Keys)
+	sort.Strings(status.Errors)
+	for _, e := range status.Errors {
+		settingsLog.Warn("config file entry skipped", "path", status.Path, "reason", e)
+	}
+	if len(changed) > 0 {
+		if err := s.db.SetSettings(changed); err != nil {
+			settingsLog.Error("failed to apply config file", "path", status.Path, "err", err)
+			status.Errors = append(status.Errors, err.Error())
+			return
+		}
+	}
+	settingsLog.Info("config file applied", "path", status.Path, "settings", len(status.Keys), "changed", len(changed))
+}
+
+// GetConfigOverlay returns the config file applied at startup. Settings it sets
+// can be changed in the app, but are overridden again at the next start.
+func (s *SettingsService) GetConfigOverlay() ConfigOverlayStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.overlay
+}
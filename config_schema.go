@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config field types
+const (
+	fieldString = "string"
+	fieldInt    = "int"
+	fieldBool   = "bool"
+	fieldEnum   = "enum"
+	fieldPath   = "path"
+	fieldSecret = "secret"
+)
+
+// ConfigField describes one config key of a session type
+type ConfigField struct {
+	Key          string              `json:"key"`
+	Label        string              `json:"label"`
+	Type         string              `json:"type"` // string, int, bool, enum, path, secret
+	Required     bool                `json:"required"`
+	RequiredWhen map[string][]string `json:"requiredWhen,omitempty"` // required only when another key has one of these values
+	Default      string              `json:"default,omitempty"`
+	Allowed      []string            `json:"allowed,omitempty"`
+	Min          *int                `json:"min,omitempty"`
+	Max          *int                `json:"max,omitempty"`
+
+	check        func(string) error // format of string values, e.g. a knock sequence
+	optionalWith string             // another key that stands in for this one, e.g. a fetched key
+}
+
+// ConfigFieldError is a single validation problem
+type ConfigFieldError struct {
+	Key     string `json:"key"`
+	Code    string `json:"code"` // "required", "type", "allowed", "range" or "format"
+	Message string `json:"message"`
+}
+
+// ConfigValidationError carries every problem found in a config
+type ConfigValidationError struct {
+	SessionType string             `json:"sessionType"`
+	Errors      []ConfigFieldError `json:"errors"`
+}
+
+func (e *ConfigValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Message
+	}
+	return fmt.Sprintf("invalid %s config: %s", e.SessionType, strings.Join(msgs, "; "))
+}
+
+func intPtr(n int) *int { return &n }
+
+// rdpPerformanceFields describes the RDP visual effect toggles
+func rdpPerformanceFields() []ConfigField {
+	fields := make([]ConfigField, 0, len(rdpPerformanceFlags))
+	for _, flag := range rdpPerformanceFlags {
+		fields = append(fields, ConfigField{Key: flag.key, Label: flag.label, Type: fieldBool, Default: "false"})
+	}
+	return fields
+}
+
+// fieldGroups concatenates field lists into a new schema
+func fieldGroups(groups ...[]ConfigField) []ConfigField {
+	var fields []ConfigField
+	for _, g := range groups {
+		fields = append(fields, g...)
+	}
+	return fields
+}
+
+var (
+	portField = func(key, label, def string) ConfigField {
+		return ConfigField{Key: key, Label: label, Type: fieldInt, Default: def, Min: intPtr(1), Max: intPtr(65535)}
+	}
+	shellFields = []ConfigField{
+		{Key: "working_directory", Label: "Working directory", Type: fieldPath},
+		{Key: "environment_variables", Label: "Environment variables", Type: fieldString},
+		{Key: "startup_commands", Label: "Startup commands", Type: fieldString},
+	}
+	desktopFields = []ConfigField{
+		{Key: "desktop_width", Label: "Width", Type: fieldInt, Default: "1920", Min: intPtr(320), Max: intPtr(8192)},
+		{Key: "desktop_height", Label: "Height", Type: fieldInt, Default: "1080", Min: intPtr(200), Max: intPtr(8192)},
+		{Key: "desktop_color_depth", Label: "Color depth", Type: fieldEnum, Default: "16", Allowed: []string{"8", "16", "24", "32"}},
+		{Key: "desktop_resize", Label: "Resize with the window", Type: fieldEnum, Default: "display-update", Allowed: []string{"display-update", "reconnect", "none"}},
+		{Key: promptCredentialsKey, Label: "Ask for the password when connecting", Type: fieldBool, Default: "false"},
+	}
+	proxyTypes = []string{"socks4", "socks5", "http"}
+	// remoteFields apply to every session type that connects to a host
+	remoteFields = fieldGroups([]ConfigField{
+		{Key: credentialIDKey, Label: "Credential", Type: fieldString},
+		{Key: credentialProviderKey, Label: "Credential provider", Type: fieldEnum, Default: "none", Allowed: append([]string{"none"}, credentialProviderTypes...)},
+		{Key: healthCheckKey, Label: "Health check", Type: fieldBool, Default: "true"},
+	}, vaultFields, totpFields, wolFields)
+)
+
+// sessionConfigSchemas lists the known config keys of every session type.
+// Keys not listed here are allowed and left unchecked.
+var sessionConfigSchemas = map[string][]ConfigField{
+	"ssh": fieldGroups([]ConfigField{
+		{Key: "ssh_host", Label: "Host", Type: fieldString, Required: true},
+		portField("ssh_port", "Port", "22"),
+		{Key: "ssh_username", Label: "Username", Type: fieldString, Required: true},
+		{Key: "ssh_auth_method", Label: "Authentication", Type: fieldEnum, Default: "password", Allowed: []string{"password", "key"}},
+		{Key: "ssh_password", Label: "Password", Type: fieldSecret, RequiredWhen: map[string][]string{"ssh_auth_method": {"password", ""}}},
+		{Key: "ssh_key_path", Label: "Private key", Type: fieldPath, RequiredWhen: map[string][]string{"ssh_auth_method": {"key"}}, optionalWith: sshPrivateKeyKey},
+		{Key: "ssh_key_passphrase", Label: "Key passphrase", Type: fieldSecret},
+		{Key: sshTOTPAutofillKey, Label: "Answer 2FA prompts with the TOTP code", Type: fieldBool, Default: "true"},
+		{Key: "ssh_host_key_policy", Label: "Host key policy", Type: fieldEnum, Default: "ask", Allowed: []string{"ask", "strict", "accept-new"}},
+		{Key: "ssh_proxy_type", Label: "Proxy", Type: fieldEnum, Default: "none", Allowed: append([]string{"none"}, proxyTypes...)},
+		{Key: "ssh_proxy_host", Label: "Proxy host", Type: fieldString, RequiredWhen: map[string][]string{"ssh_proxy_type": proxyTypes}},
+		portField("ssh_proxy_port", "Proxy port", ""),
+		{Key: "ssh_proxy_username", Label: "Proxy username", Type: fieldString},
+		{Key: "ssh_proxy_password", Label: "Proxy password", Type: fieldSecret},
+	}, knockFields, tmuxFields, remoteFields),
+	"rdp": fieldGroups([]ConfigField{
+		{Key: "rdp_host", Label: "Host", Type: fieldString, Required: true},
+		portField("rdp_port", "Port", "3389"),
+		{Key: "rdp_username", Label: "Username", Type: fieldString},
+		{Key: "rdp_password", Label: "Password", Type: fieldSecret},
+		{Key: "rdp_domain", Label: "Domain", Type: fieldString},
+		{Key: "rdp_security", Label: "Security", Type: fieldEnum, Default: "any", Allowed: []string{"any", "nla", "nla-ext", "tls", "rdp", "vmconnect"}},
+		{Key: "rdp_ignore_cert", Label: "Ignore certificate errors", Type: fieldBool, Default: "false"},
+		{Key: "rdp_cert_fingerprints", Label: "Trusted certificate fingerprints", Type: fieldString},
+		{Key: "rdp_console", Label: "Connect to the console session", Type: fieldBool, Default: "false"},
+		{Key: "rdp_audio", Label: "Play remote audio", Type: fieldBool, Default: "true"},
+		{Key: "rdp_audio_input", Label: "Redirect microphone", Type: fieldBool, Default: "false"},
+		{Key: rdpDriveKey, Label: "Share a drive", Type: fieldBool, Default: "false"},
+		{Key: rdpDrivePathKey, Label: "Drive folder on the guacd host", Type: fieldString, Default: defaultRDPDrive},
+		{Key: rdpDownloadDirKey, Label: "Download folder", Type: fieldPath},
+	}, rdpPerformanceFields(), desktopFields, guacdFields, remoteFields),
+	"vnc": fieldGroups([]ConfigField{
+		{Key: "vnc_host", Label: "Host", Type: fieldString, Required: true},
+		portField("vnc_port", "Port", "5900"),
+		{Key: "vnc_password", Label: "Password", Type: fieldSecret},
+		{Key: vncDestHostKey, Label: "Repeater destination host", Type: fieldString},
+		portField(vncDestPortKey, "Repeater destination port", ""),
+		{Key: vncReverseKey, Label: "Listen for reverse connections", Type: fieldBool, Default: "false"},
+		{Key: vncListenTimeoutKey, Label: "Listen timeout (seconds)", Type: fieldInt, Default: strconv.Itoa(defaultVNCListenTimeout), Min: intPtr(1), Max: intPtr(86400)},
+	}, desktopFields, guacdFields, remoteFields),
+	"telnet": fieldGroups([]ConfigField{
+		{Key: "telnet_host", Label: "Host", Type: fieldString, Required: true},
+		portField("telnet_port", "Port", "23"),
+		{Key: "telnet_username", Label: "Username", Type: fieldString},
+		{Key: "telnet_password", Label: "Password", Type: fieldSecret},
+	}, guacdFields, remoteFields),
+	"serial": {
+		{Key: "serial_line", Label: "Serial line", Type: fieldString, Required: true},
+		{Key: "serial_speed", Label: "Speed", Type: fieldInt, Default: "9600", Min: intPtr(50), Max: intPtr(4000000)},
+	},
+	"custom": append([]ConfigField{
+		{Key: "command", Label: "Command", Type: fieldString, Required: true},
+	}, shellFields...),
+	"bash":       shellFields,
+	"zsh":        shellFields,
+	"fish":       shellFields,
+	"pwsh":       shellFields,
+	"powershell": shellFields,
+	"cmd":        shellFields,
+	"git-bash":   shellFields,
+}
+
+// GetConfigSchema returns the config fields of a session type, for building forms
+func (s *SessionService) GetConfigSchema(sessionType string) ([]ConfigField, error) {
+	fields, ok := sessionConfigSchemas[sessionType]
+	if !ok {
+		return nil, fmt.Errorf("unknown session type: %s", sessionType)
+	}
+	return fields, nil
+}
+
+// ValidateConfig checks a config against a session type's schema; nil means valid
+func (s *SessionService) ValidateConfig(sessionType string, config map[string]string) []ConfigFieldError {
+	return validateSessionConfig(sessionType, config, true)
+}
+
+// ValidateSession checks the effective (inherited) config of a session tree node
+func (s *SessionService) ValidateSession(sessionID string) ([]ConfigFieldError, error) {
+	node, err := s.db.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if node.Type != "session" || node.SessionType == nil || node.IsTemplate {
+		return []ConfigFieldError{}, nil
+	}
+	config, err := s.db.GetEffectiveConfig(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := expandCredential(s.db, config)
+	if err != nil {
+		return []ConfigFieldError{{Key: credentialIDKey, Code: "not_found", Message: err.Error()}}, nil
+	}
+	errs := validateSessionConfig(*node.SessionType, expanded, true)
+	if provider := expanded[credentialProviderKey]; provider != "" && provider != "none" {
+		errs = withoutProvidedCredentials(errs)
+	}
+	if errs == nil {
+		errs = []ConfigFieldError{}
+	}
+	return errs, nil
+}
+
+// validateSessionConfig checks value types and allowed values, plus required keys when
+// complete is set (a connect-time config rather than a single saved key)
+func validateSessionConfig(sessionType string, config map[string]string, complete bool) []ConfigFieldError {
+	fields, ok := sessionConfigSchemas[sessionType]
+	if !ok {
+		return nil
+	}
+	var errs []ConfigFieldError
+	for _, f := range fields {
+		value, present := config[f.Key]
+		if !present || value == "" {
+			if complete && fieldRequired(f, config) {
+				errs = append(errs, ConfigFieldError{Key: f.Key, Code: "required", Message: fmt.Sprintf("%s (%s) is required", f.Label, f.Key)})
+			}
+			continue
+		}
+		if fe := checkConfigValue(f, value); fe != nil {
+			errs = append(errs, *fe)
+		}
+	}
+	return errs
+}
+
+func fieldRequired(f ConfigField, config map[string]string) bool {
+	if f.optionalWith != "" && config[f.optionalWith] != "" {
+		return false
+	}
+	if f.Required {
+		return true
+	}
+	for key, values := range f.RequiredWhen {
+		current := config[key]
+		for _, v := range values {
+			if current == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkConfigValue validates one non-empty value against its field definition
+func checkConfigValue(f ConfigField, value string) *ConfigFieldError {
+	// Template placeholders are filled in later
+	if strings.Contains(value, "${") {
+		return nil
+	}
+	switch f.Type {
+	case fieldInt:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return &ConfigFieldError{Key: f.Key, Code: "type", Message: fmt.Sprintf("%s (%s) must be a number, got %q", f.Label, f.Key, value)}
+		}
+		switch {
+		case f.Min != nil && f.Max != nil && (n < *f.Min || n > *f.Max):
+			return &ConfigFieldError{Key: f.Key, Code: "range", Message: fmt.Sprintf("%s (%s) must be between %d and %d", f.Label, f.Key, *f.Min, *f.Max)}
+		case f.Min != nil && n < *f.Min:
+			return &ConfigFieldError{Key: f.Key, Code: "range", Message: fmt.Sprintf("%s (%s) must be at least %d", f.Label, f.Key, *f.Min)}
+		case f.Max != nil && n > *f.Max:
+			return &ConfigFieldError{Key: f.Key, Code: "range", Message: fmt.Sprintf("%s (%s) must be at most %d", f.Label, f.Key, *f.Max)}
+		}
+	case fieldBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return &ConfigFieldError{Key: f.Key, Code: "type", Message: fmt.Sprintf("%s (%s) must be true or false", f.Label, f.Key)}
+		}
+	case fieldEnum:
+		for _, a := range f.Allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return &ConfigFieldError{Key: f.Key, Code: "allowed", Message: fmt.Sprintf("%s (%s) must be one of %s", f.Label, f.Key, strings.Join(f.Allowed, ", "))}
+	}
+	// A sealed secret's format is only known once it is decrypted to connect
+	if f.check != nil && !(f.Type == fieldSecret && isEncryptedSecret(value)) {
+		if err := f.check(value); err != nil {
+			return &ConfigFieldError{Key: f.Key, Code: "format", Message: fmt.Sprintf("%s (%s): %v", f.Label, f.Key, err)}
+		}
+	}
+	return nil
+}
+
+// validateConfigKey checks a single value being saved. Folders have no session type,
+// so their keys are checked against every schema that defines them.
+func validateConfigKey(sessionType *string, key, value string) *ConfigValidationError {
+	if value == "" || isEncryptedSecret(value) {
+		return nil
+	}
+	types := make([]string, 0, len(sessionConfigSchemas))
+	if sessionType != nil {
+		types = append(types, *sessionType)
+	} else {
+		for t := range sessionConfigSchemas {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+	}
+	for _, t := range types {
+		for _, f := range sessionConfigSchemas[t] {
+			if f.Key != key {
+				continue
+			}
+			if fe := checkConfigValue(f, value); fe != nil {
+				name := t
+				if sessionType == nil {
+					name = "folder"
+				}
+				return &ConfigValidationError{SessionType: name, Errors: []ConfigFieldError{*fe}}
+			}
+			return nil
+		}
+	}
+	return nil
+}
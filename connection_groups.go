@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// heldOutputLimit bounds the output held for a tab that has not attached yet
+const heldOutputLimit = 256 << 10
+
+// ConnectionGroupService manages connection groups: named sets of sessions,
+// e.g. "web tier", that are connected with one action. Connecting a group
+// emits group:status as each member connects or fails, then group:done.
+type ConnectionGroupService struct {
+	app      *application.App
+	db       *database.DB
+	terminal *TerminalService
+}
+
+// NewConnectionGroupService creates the connection group service
+func NewConnectionGroupService(app *application.App, db *database.DB, terminal *TerminalService) *ConnectionGroupService {
+	return &ConnectionGroupService{app: app, db: db, terminal: terminal}
+}
+
+// ListConnectionGroups returns every connection group
+func (s *ConnectionGroupService) ListConnectionGroups() ([]database.ConnectionGroup, error) {
+	return s.db.ListConnectionGroups()
+}
+
+// CreateConnectionGroup adds a connection group
+func (s *ConnectionGroupService) CreateConnectionGroup(g database.ConnectionGroup) (*database.ConnectionGroup, error) {
+	if err := s.validate(&g); err != nil {
+		return nil, err
+	}
+	if err := s.db.CreateConnectionGroup(&g); err != nil {
+		return nil, err
+	}
+	s.emitChanged()
+	return s.db.GetConnectionGroup(g.ID)
+}
+
+// UpdateConnectionGroup changes a connection group
+func (s *ConnectionGroupService) UpdateConnectionGroup(g database.ConnectionGroup) error {
+	if err := s.validate(&g); err != nil {
+		return err
+	}
+	if err := s.db.UpdateConnectionGroup(&g); err != nil {
+		return err
+	}
+	s.emitChanged()
+	return nil
+}
+
+// DeleteConnectionGroup removes a connection group; its sessions stay
+func (s *ConnectionGroupService) DeleteConnectionGroup(id int64) error {
+	if err := s.db.DeleteConnectionGroup(id); err != nil {
+		return err
+	}
+	s.emitChanged()
+	return nil
+}
+
+// ConnectGroup opens a terminal for every member of a group in a window, the
+// main window when empty. Members connect one after the other in the
+// background; the tabs open as they do.
+func (s *ConnectionGroupService) ConnectGroup(id int64, windowID string) error {
+	g, err := s.db.GetConnectionGroup(id)
+	if err != nil {
+		return fmt.Errorf("connection group not found: %w", err)
+	}
+	if len(g.SessionIDs) == 0 {
+		return fmt.Errorf("%s has no sessions", g.Name)
+	}
+	if windowID == "" {
+		windowID = mainWindowName
+	}
+	go s.connect(*g, windowID)
+	return nil
+}
+
+func (s *ConnectionGroupService) connect(g database.ConnectionGroup, windowID string) {
+	var broadcast *broadcastSet
+	if g.Broadcast {
+		broadcast = &broadcastSet{name: g.Name}
+	}
+	termLog.Info("connecting group", "group", g.Name, "members", len(g.SessionIDs), "broadcast", g.Broadcast)
+	connected := 0
+	for _, nodeID := range g.SessionIDs {
+		status := map[string]interface{}{"groupId": g.ID, "sessionId": nodeID, "windowId": windowID, "state": "connecting"}
+		s.app.Event.Emit("group:status", status)
+		tab, err := s.terminal.openNode(nodeID, windowID, broadcast)
+		status = map[string]interface{}{"groupId": g.ID, "sessionId": nodeID, "windowId": windowID, "id": tab, "state": "connected"}
+		if err != nil {
+			termLog.Warn("group member failed to connect", "group", g.Name, "session", nodeID, "err", err)
+			status["state"], status["error"] = "failed", err.Error()
+		} else {
+			connected++
+		}
+		s.app.Event.Emit("group:status", status)
+	}
+	s.app.Event.Emit("group:done", map[string]interface{}{
+		"groupId":   g.ID,
+		"windowId":  windowID,
+		"connected": connected,
+		"failed":    len(g.SessionIDs) - connected,
+	})
+}
+
+// validate checks a group's name and that its members are terminal sessions,
+// dropping duplicates
+func (s *ConnectionGroupService) validate(g *database.ConnectionGroup) error {
+	g.Name = strings.TrimSpace(g.Name)
+	g.Description = strings.TrimSpace(g.Description)
+	if g.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	members := make([]string, 0, len(g.SessionIDs))
+	seen := map[string]bool{}
+	for _, id := range g.SessionIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		node, err := s.db.GetSession(id)
+		if err != nil {
+			return fmt.Errorf("session %s not found: %w", id, err)
+		}
+		if node.Type != "session" || node.SessionType == nil || isRemoteDesktopType(*node.SessionType) {
+			return fmt.Errorf("%s is not a terminal session", node.Name)
+		}
+		members = append(members, id)
+	}
+	g.SessionIDs = members
+	return nil
+}
+
+func (s *ConnectionGroupService) emitChanged() {
+	if s.app != nil {
+		s.app.Event.Emit("groups:changed", map[string]interface{}{})
+	}
+}
+
+// openNode starts a terminal for a session of the tree in the background and
+// opens its tab in a window with terminal:added. Its output is held until the
+// tab attaches. A broadcast set, if any, gets the terminal as a member.
+func (t *TerminalService) openNode(nodeID, windowID string, broadcast *broadcastSet) (string, error) {
+	node, err := t.db.GetSession(nodeID)
+	if err != nil {
+		return "", fmt.Errorf("session %s not found: %w", nodeID, err)
+	}
+	if node.Type != "session" || node.SessionType == nil || isRemoteDesktopType(*node.SessionType) {
+		return "", fmt.Errorf("%s is not a terminal session", node.Name)
+	}
+	config, err := t.db.GetEffectiveConfig(node.ID)
+	if err != nil {
+		return "", err
+	}
+
+	id := newNodeID("tab")
+	t.held.Store(id, &heldOutput{})
+	err = t.StartSession(StartSessionRequest{
+		ID:          id,
+		NodeID:      node.ID,
+		WindowID:    windowID,
+		Name:        node.Name,
+		SessionType: *node.SessionType,
+		Config:      config,
+		Cols:        80,
+		Rows:        24,
+	})
+	if err != nil {
+		t.held.Delete(id)
+		return "", err
+	}
+	if broadcast != nil {
+		t.joinBroadcast(id, broadcast)
+	}
+	session := t.GetSession(id)
+	t.emit(session, "terminal:added", map[string]interface{}{
+		"id":          id,
+		"nodeId":      node.ID,
+		"name":        node.Name,
+		"sessionType": *node.SessionType,
+		"broadcast":   broadcast != nil,
+	})
+	return id, nil
+}
+
+// heldOutput keeps the events of a terminal the backend opened until its tab
+// attaches, as the tab would miss them
+type heldOutput struct {
+	mu     sync.Mutex
+	events []heldEvent
+	size   int // bytes of output held
+	done   bool
+}
+
+type heldEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// heldEventNames are the events held for a tab that did not attach yet
+var heldEventNames = map[string]bool{"terminal:data": true, "terminal:exit": true, "terminal:error": true, "terminal:title": true}
+
+// hold keeps an event of a terminal whose tab did not attach yet, reporting
+// whether it did. The oldest output goes once too much is held.
+func (t *TerminalService) hold(id, name string, data map[string]interface{}) bool {
+	if !heldEventNames[name] {
+		return false
+	}
+	v, ok := t.held.Load(id)
+	if !ok {
+		return false
+	}
+	h := v.(*heldOutput)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		return false
+	}
+	h.events = append(h.events, heldEvent{name: name, data: data})
+	if output, ok := data["data"].(string); ok {
+		h.size += len(output)
+	}
+	for h.size > heldOutputLimit && len(h.events) > 1 {
+		if output, ok := h.events[0].data["data"].(string); ok {
+			h.size -= len(output)
+		}
+		h.events = h.events[1:]
+	}
+	return true
+}
+
+// release sends the held events of a terminal once its tab attached
+func (t *TerminalService) release(session *TerminalSession) {
+	v, ok := t.held.LoadAndDelete(session.ID)
+	if !ok {
+		return
+	}
+	h := v.(*heldOutput)
+	// Events arriving meanwhile wait for the lock, so they stay in order
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.done = true
+	for _, e := range h.events {
+		e.data["windowId"] = session.Window()
+		t.app.Event.Emit(e.name, e.data)
+	}
+	h.events = nil
+}
+
+// broadcastSet is the terminals of a broadcast group, which input typed into
+// one of them goes to
+type broadcastSet struct {
+	name string
+
+	mu      sync.Mutex
+	members []string
+}
+
+func (t *TerminalService) joinBroadcast(id string, set *broadcastSet) {
+	set.mu.Lock()
+	set.members = append(set.members, id)
+	set.mu.Unlock()
+	t.broadcasts.Store(id, set)
+}
+
+// LeaveBroadcast stops sending a terminal's input to the rest of its broadcast
+// group, and the group's input to it
+func (t *TerminalService) LeaveBroadcast(id string) {
+	v, ok := t.broadcasts.LoadAndDelete(id)
+	if !ok {
+		return
+	}
+	set := v.(*broadcastSet)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for i, member := range set.members {
+		if member == id {
+			set.members = append(set.members[:i], set.members[i+1:]...)
+			break
+		}
+	}
+}
+
+// BroadcastMembers returns the terminals of a terminal's broadcast group,
+// e.g. for RunSnippet, or just the terminal when it has none
+func (t *TerminalService) BroadcastMembers(id string) []string {
+	v, ok := t.broadcasts.Load(id)
+	if !ok {
+		return []string{id}
+	}
+	set := v.(*broadcastSet)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return append([]string(nil), set.members...)
+}
+
+// BroadcastInput writes typed input to a terminal and, when it belongs to a
+// broadcast group, to the group's other running terminals. Only the error of
+// the terminal typed into is returned.
+func (t *TerminalService) BroadcastInput(id string, data string) error {
+	err := t.WriteToSession(id, data)
+	v, ok := t.broadcasts.Load(id)
+	if !ok {
+		return err
+	}
+	set := v.(*broadcastSet)
+	set.mu.Lock()
+	members := append([]string(nil), set.members...)
+	set.mu.Unlock()
+	for _, member := range members {
+		if member != id && t.IsSessionRunning(member) {
+			_ = t.WriteToSession(member, data)
+		}
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ConnectionImportOptions controls the importers for other connection managers
+type ConnectionImportOptions struct {
+	Path     string `json:"path"`     // file to import; empty reads the Windows registry where supported
+	ParentID string `json:"parentId"` // folder to import into; empty imports at the root
+	Conflict string `json:"conflict"` // existing session with the same name: "merge" (default), "skip" or "rename"
+	Password string `json:"password"` // decrypts protected files (mRemoteNG)
+}
+
+// savedSession is one saved session's raw values from the registry or an ini/.reg file
+type savedSession struct {
+	Name   string
+	Values map[string]string
+}
+
+// importIntoFolder places imported nodes in a folder named after the source application
+func (s *SessionService) importIntoFolder(folderName string, nodes []SessionExportNode, opts ConnectionImportOptions, result *SessionImportResult) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	var parentID *string
+	if opts.ParentID != "" {
+		parentID = &opts.ParentID
+	}
+	folder := SessionExportNode{Name: folderName, Type: "folder", Children: nodes}
+	return s.importNodes(parentID, []SessionExportNode{folder}, opts.Conflict, nil, result)
+}
+
+// insertAtPath adds node below the folders named by path, creating them as needed
+func insertAtPath(nodes []SessionExportNode, path []string, node SessionExportNode) []SessionExportNode {
+	if len(path) == 0 {
+		return append(nodes, node)
+	}
+	for i := range nodes {
+		if nodes[i].Type == "folder" && nodes[i].Name == path[0] {
+			nodes[i].Children = insertAtPath(nodes[i].Children, path[1:], node)
+			return nodes
+		}
+	}
+	folder := SessionExportNode{Name: path[0], Type: "folder"}
+	folder.Children = insertAtPath(nil, path[1:], node)
+	return append(nodes, folder)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" || value == "0" {
+		return fallback
+	}
+	return value
+}
+
+// regDword normalises a dword value ("dword:00000016" from .reg files, or a decimal) to decimal
+func regDword(value string) string {
+	value = strings.TrimSpace(value)
+	if hex, ok := strings.CutPrefix(value, "dword:"); ok {
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return ""
+		}
+		return strconv.FormatUint(n, 10)
+	}
+	return value
+}
+
+// parseRegSessions reads the subkeys of keyPath (relative to HKCU) from a regedit export
+func parseRegSessions(data []byte, keyPath string) ([]savedSession, error) {
+	text := decodeRegText(data)
+	if !strings.Contains(text, "Windows Registry Editor") && !strings.HasPrefix(strings.TrimSpace(text), "REGEDIT4") {
+		return nil, fmt.Errorf("not a registry export")
+	}
+
+	sessions := map[string]*savedSession{}
+	var current *savedSession
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = nil
+			key := line[1 : len(line)-1]
+			idx := strings.Index(strings.ToLower(key), strings.ToLower(keyPath)+`\`)
+			if idx < 0 || strings.HasPrefix(key, "-") {
+				continue
+			}
+			name := unescapeSessionName(key[idx+len(keyPath)+1:])
+			if name == "" || strings.Contains(name, `\`) || name == "Default Settings" {
+				continue
+			}
+			if sessions[name] == nil {
+				sessions[name] = &savedSession{Name: name, Values: map[string]string{}}
+			}
+			current = sessions[name]
+			continue
+		}
+		if current == nil || !strings.HasPrefix(line, `"`) {
+			continue
+		}
+		name, value, ok := parseRegValue(line)
+		if ok {
+			current.Values[name] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read .reg file: %w", err)
+	}
+	return sortedSessions(sessions), nil
+}
+
+func sortedSessions(sessions map[string]*savedSession) []savedSession {
+	names := make([]string, 0, len(sessions))
+	for name := range sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]savedSession, 0, len(names))
+	for _, name := range names {
+		out = append(out, *sessions[name])
+	}
+	return out
+}
+
+// unescapeSessionName decodes the %XX escaping PuTTY and WinSCP use for session key names
+func unescapeSessionName(key string) string {
+	name, err := url.PathUnescape(key)
+	if err != nil {
+		return key
+	}
+	return name
+}
+
+// decodeRegText converts regedit's UTF-16LE output (with BOM) to a string
+func decodeRegText(data []byte) string {
+	if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+		data = data[2:]
+		u := make([]uint16, len(data)/2)
+		for i := range u {
+			u[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		}
+		return string(utf16.Decode(u))
+	}
+	return string(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF}))
+}
+
+// parseRegValue parses a `"Name"="string"` or `"Name"=dword:xxxxxxxx` line
+func parseRegValue(line string) (string, string, bool) {
+	name, rest, ok := readRegString(line)
+	if !ok || !strings.HasPrefix(rest, "=") {
+		return "", "", false
+	}
+	rest = strings.TrimSpace(rest[1:])
+	if strings.HasPrefix(rest, `"`) {
+		value, _, ok := readRegString(rest)
+		return name, value, ok
+	}
+	if strings.HasPrefix(rest, "dword:") {
+		return name, rest, true
+	}
+	return "", "", false
+}
+
+// readRegString reads a quoted, backslash-escaped string and returns the remainder
+func readRegString(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:], true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", false
+}
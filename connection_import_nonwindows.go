@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// readRegistrySessions is only available on Windows; elsewhere import an exported file instead
+func readRegistrySessions(keyPath string) ([]savedSession, error) {
+	return nil, fmt.Errorf("reading saved sessions from the registry is only supported on Windows; export them to a file and import that instead")
+}
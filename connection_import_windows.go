@@ -0,0 +1,50 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// readRegistrySessions reads every subkey of HKCU\<keyPath> as a saved session
+func readRegistrySessions(keyPath string) ([]savedSession, error) {
+	root, err := registry.OpenKey(registry.CURRENT_USER, keyPath, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil, fmt.Errorf("no saved sessions found in the registry: %w", err)
+	}
+	defer root.Close()
+
+	keys, err := root.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list saved sessions: %w", err)
+	}
+	sort.Strings(keys)
+
+	var sessions []savedSession
+	for _, key := range keys {
+		name := unescapeSessionName(key)
+		if name == "Default Settings" {
+			continue
+		}
+		k, err := registry.OpenKey(root, key, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		values := map[string]string{}
+		valueNames, _ := k.ReadValueNames(-1)
+		for _, vn := range valueNames {
+			if s, _, err := k.GetStringValue(vn); err == nil {
+				values[vn] = s
+			} else if n, _, err := k.GetIntegerValue(vn); err == nil {
+				values[vn] = strconv.FormatUint(n, 10)
+			}
+		}
+		k.Close()
+		sessions = append(sessions, savedSession{Name: name, Values: values})
+	}
+	return sessions, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"term/database"
+)
+
+// credentialProviderKey points a folder or session at an external secret manager
+// that supplies its credentials at connect time instead of the stored config.
+// Like any config it is inherited.
+const credentialProviderKey = "credential_provider"
+
+// credentialProviderTimeout bounds fetching a session's credentials
+const credentialProviderTimeout = 30 * time.Second
+
+// Config keys only credential providers set, on the config of one connection;
+// key material fetched for it is never stored
+const (
+	sshPrivateKeyKey  = "ssh_private_key" // PEM private key, used instead of ssh_key_path
+	sshCertificateKey = "ssh_certificate" // OpenSSH certificate of that key
+)
+
+// credentialProviderTypes are the values credential_provider accepts besides "none"
+var credentialProviderTypes = []string{"vault", "1password", "bitwarden"}
+
+// credentialProvider fetches credentials from an external secret manager
+type credentialProvider interface {
+	// Resolve returns the config values to use for a connection, e.g.
+	// ssh_password, given the session's decrypted config
+	Resolve(ctx context.Context, cfg map[string]string) (map[string]string, error)
+	// Forget drops cached secrets, e.g. when the vault locks
+	Forget()
+}
+
+// addCredentialProvider registers the provider selected by credential_provider = name
+func (s *SecretStore) addCredentialProvider(name string, p credentialProvider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.providers == nil {
+		s.providers = make(map[string]credentialProvider)
+	}
+	s.providers[name] = p
+}
+
+// resolveProvider fills in the credentials of a decrypted config from its
+// credential provider, if it has one. Fetched values replace stored ones.
+func (s *SecretStore) resolveProvider(cfg map[string]string) (map[string]string, error) {
+	name := strings.TrimSpace(cfg[credentialProviderKey])
+	if name == "" || name == "none" {
+		return cfg, nil
+	}
+	s.mu.RLock()
+	p := s.providers[name]
+	s.mu.RUnlock()
+	if p == nil {
+		return nil, fmt.Errorf("unknown credential provider %q", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), credentialProviderTimeout)
+	defer cancel()
+	values, err := p.Resolve(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for k, v := range values {
+		cfg[k] = v
+	}
+	return cfg, nil
+}
+
+// forgetProviderSecrets drops the secrets every provider keeps in memory
+func (s *SecretStore) forgetProviderSecrets() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.providers {
+		p.Forget()
+	}
+}
+
+// withoutProvidedCredentials drops the missing-credential errors of a config
+// whose credential provider only supplies them when connecting
+func withoutProvidedCredentials(errs []ConfigFieldError) []ConfigFieldError {
+	kept := errs[:0]
+	for _, fe := range errs {
+		if fe.Code == "required" && isProvidedCredentialKey(fe.Key) {
+			continue
+		}
+		kept = append(kept, fe)
+	}
+	return kept
+}
+
+func isProvidedCredentialKey(key string) bool {
+	if key == "ssh_key_path" {
+		return true
+	}
+	for _, t := range credentialTargets {
+		if key == t.username || key == t.password {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialProviderService configures the external secret managers that
+// sessions can take their credentials from
+type CredentialProviderService struct {
+	db        *database.DB
+	secrets   *SecretStore
+	vault     *hashicorpVault
+	bitwarden *bitwarden
+}
+
+// NewCredentialProviderService creates the providers and registers them with
+// the secret store
+func NewCredentialProviderService(db *database.DB, secrets *SecretStore) *CredentialProviderService {
+	s := &CredentialProviderService{
+		db:        db,
+		secrets:   secrets,
+		vault:     newHashiCorpVault(db, secrets),
+		bitwarden: &bitwarden{db: db},
+	}
+	secrets.addCredentialProvider("vault", s.vault)
+	secrets.addCredentialProvider("1password", &onePassword{db: db})
+	secrets.addCredentialProvider("bitwarden", s.bitwarden)
+	return s
+}
+
+// sealSecret encrypts a provider credential before it is stored; the masked
+// value keeps the stored one
+func (s *CredentialProviderService) sealSecret(value, previous string) (string, error) {
+	if value == maskedSecretValue {
+		return previous, nil
+	}
+	if value == "" {
+		return "", nil
+	}
+	return s.secrets.Encrypt(value)
+}
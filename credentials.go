@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"term/database"
+)
+
+// credentialIDKey is the config key that points a folder or session at a shared credential.
+// Like any config it is inherited, so setting it on a folder covers every session inside.
+const credentialIDKey = "credential_id"
+
+// credentialTargets maps each protocol's config keys onto the credential fields
+var credentialTargets = []struct {
+	username string // "" when the protocol has no username
+	password string
+}{
+	{"ssh_username", "ssh_password"},
+	{"rdp_username", "rdp_password"},
+	{"telnet_username", "telnet_password"},
+	{"", "vnc_password"},
+}
+
+// ListCredentials returns all credentials with their secrets masked
+func (s *SessionService) ListCredentials() ([]database.Credential, error) {
+	creds, err := s.db.ListCredentials()
+	if err != nil {
+		return nil, err
+	}
+	for i := range creds {
+		creds[i].Secret = maskCredentialSecret(creds[i].Secret)
+	}
+	return creds, nil
+}
+
+// CreateCredential stores a new credential; the secret is encrypted before it is saved
+func (s *SessionService) CreateCredential(cred database.Credential) (*database.Credential, error) {
+	cred.Name = strings.TrimSpace(cred.Name)
+	if cred.Name == "" {
+		return nil, fmt.Errorf("credential name is required")
+	}
+	sealed, err := s.sealCredentialSecret(cred.Secret, "")
+	if err != nil {
+		return nil, err
+	}
+	cred.ID = newNodeID("credential")
+	cred.Secret = sealed
+	if err := s.db.CreateCredential(&cred); err != nil {
+		return nil, err
+	}
+	created, err := s.db.GetCredential(cred.ID)
+	if err != nil {
+		return nil, err
+	}
+	created.Secret = maskCredentialSecret(created.Secret)
+	return created, nil
+}
+
+// UpdateCredential changes a credential. Every session referencing it picks up the change
+// on its next connect. A masked secret keeps the stored one.
+func (s *SessionService) UpdateCredential(cred database.Credential) error {
+	cred.Name = strings.TrimSpace(cred.Name)
+	if cred.Name == "" {
+		return fmt.Errorf("credential name is required")
+	}
+	existing, err := s.db.GetCredential(cred.ID)
+	if err != nil {
+		return err
+	}
+	if cred.Secret, err = s.sealCredentialSecret(cred.Secret, existing.Secret); err != nil {
+		return err
+	}
+	return s.db.UpdateCredential(&cred)
+}
+
+// DeleteCredential removes a credential that no folder or session references any more
+func (s *SessionService) DeleteCredential(id string) error {
+	users, err := s.db.ListCredentialUsers(id)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		names := make([]string, 0, len(users))
+		for _, u := range users {
+			names = append(names, u.Name)
+		}
+		return fmt.Errorf("credential is still used by %s", strings.Join(names, ", "))
+	}
+	return s.db.DeleteCredential(id)
+}
+
+// GetCredentialUsers lists the folders and sessions that reference a credential directly
+func (s *SessionService) GetCredentialUsers(id string) ([]database.SessionNode, error) {
+	return s.db.ListCredentialUsers(id)
+}
+
+func (s *SessionService) sealCredentialSecret(value, previous string) (string, error) {
+	if value == maskedSecretValue {
+		return previous, nil
+	}
+	if value == "" {
+		return "", nil
+	}
+	sealed, err := s.secrets.Encrypt(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt credential secret: %w", err)
+	}
+	return sealed, nil
+}
+
+func maskCredentialSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return maskedSecretValue
+}
+
+// expandCredential returns cfg with the credential named by credential_id filled into
+// every username/password key that cfg leaves empty, so values set directly on a session
+// still win. The secret stays sealed; ResolveConfig decrypts it like any other.
+func expandCredential(db *database.DB, cfg map[string]string) (map[string]string, error) {
+	id := strings.TrimSpace(cfg[credentialIDKey])
+	if id == "" {
+		return cfg, nil
+	}
+	cred, err := db.GetCredential(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("credential %s not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(cfg)+4)
+	for k, v := range cfg {
+		out[k] = v
+	}
+	fill := func(key, value string) {
+		if key != "" && value != "" && out[key] == "" {
+			out[key] = value
+		}
+	}
+	for _, t := range credentialTargets {
+		fill(t.username, cred.Username)
+		if t.password == "ssh_password" && cred.KeyPath != "" {
+			continue
+		}
+		fill(t.password, cred.Secret)
+	}
+	if cred.KeyPath != "" {
+		fill("ssh_auth_method", "key")
+		fill("ssh_key_path", cred.KeyPath)
+		fill("ssh_key_passphrase", cred.Secret)
+	}
+	return out, nil
+}
@@ -6,13 +6,31 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+
+	"term/logging"
 
 	_ "modernc.org/sqlite"
 )
 
+var logger = logging.For("database")
+
 type DB struct {
 	conn *sql.DB
 	path string
+
+	// At-rest encryption state (see encrypted.go)
+	encMu          sync.Mutex
+	encrypted      bool
+	locked         bool
+	pending        string
+	key, salt      []byte
+	stopFlush      chan struct{}
+	flushedChanges int64
+
+	// Called after every settings write (see OnSettingChanged)
+	settingHookMu sync.RWMutex
+	settingHook   func(key, value string)
 }
 
 // New creates a new database connection and initializes the schema
@@ -23,6 +41,11 @@ func New(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
+	// An encrypted database stays a locked in-memory placeholder until Unlock
+	if _, err := os.Stat(EncryptedPath(dbPath)); err == nil {
+		return openLocked(dbPath)
+	}
+
 	// Open database connection
 	conn, err := sql.Open("sqlite", dbPath)
 	if err != nil {
@@ -46,12 +69,25 @@ func New(dbPath string) (*DB, error) {
 		path: dbPath,
 	}
 
+	// A database without the sessions table was just created
+	var existing int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'sessions'`).Scan(&existing); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to inspect database: %w", err)
+	}
+
 	// Initialize schema
 	if err := db.initSchema(); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
+	// Upgrade tables created by older versions
+	if err := db.migrate(existing == 0); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
 	// Bootstrap default data if database is new
 	if err := db.bootstrap(); err != nil {
 		conn.Close()
@@ -202,9 +238,39 @@ func (db *DB) bootstrap() error {
 	return tx.Commit()
 }
 
-// Close closes the database connection
+// Close writes an encrypted database one last time and closes the connection
 func (db *DB) Close() error {
-	return db.conn.Close()
+	encErr := db.closeEncryption()
+	if err := db.conn.Close(); err != nil {
+		return err
+	}
+	if encErr != nil {
+		return fmt.Errorf("failed to write database: %w", encErr)
+	}
+	if db.EncryptionPending() == EncryptionPendingEnable {
+		removePlaintextFiles(db.path)
+	}
+	return nil
+}
+
+// IntegrityCheck runs SQLite's integrity check; it returns "ok" when the database
+// is sound, otherwise the problems found
+func (db *DB) IntegrityCheck() ([]string, error) {
+	rows, err := db.conn.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		result = append(result, line)
+	}
+	return result, rows.Err()
 }
 
 // Conn returns the underlying SQL connection
@@ -0,0 +1,501 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"modernc.org/sqlite"
+	"modernc.org/sqlite/vfs"
+)
+
+// At-rest encryption. The pure-Go SQLite driver has no SQLCipher support, so an
+// encrypted database is kept in memory and the whole file image is sealed with
+// AES-256-GCM (key derived from the master password with Argon2id) whenever it
+// changes. On disk only <db>.enc exists:
+//
+//	magic (8) | salt (16) | nonce (12) | ciphertext
+//
+// Switching encryption on or off takes effect when the database is closed, so the
+// running session never swaps connections underneath its callers.
+const (
+	encryptedSuffix    = ".enc"
+	encryptedMagic     = "TERMDB\x00\x01"
+	encryptedSaltLen   = 16
+	encryptedFlushTick = 3 * time.Second
+)
+
+// Encryption changes applied on Close
+const (
+	EncryptionPendingNone    = ""
+	EncryptionPendingEnable  = "enable"
+	EncryptionPendingDisable = "disable"
+)
+
+var (
+	ErrDatabaseLocked    = errors.New("database is locked")
+	ErrWrongDatabaseKey  = errors.New("wrong master password for encrypted database")
+	errNotEncryptedImage = errors.New("not an encrypted database file")
+)
+
+// EncryptedPath returns where the sealed copy of dbPath is stored
+func EncryptedPath(dbPath string) string {
+	return dbPath + encryptedSuffix
+}
+
+func deriveDatabaseKey(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, 3, 64*1024, 2, 32)
+}
+
+// openLocked opens a placeholder in-memory database for an encrypted file; the real
+// contents are loaded by Unlock. The placeholder is read-only, so writes made before
+// then fail instead of being lost with it.
+func openLocked(dbPath string) (*DB, error) {
+	conn, err := openMemoryConn()
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		conn:      conn,
+		path:      dbPath,
+		encrypted: true,
+		locked:    true,
+	}
+	if err := db.initSchema(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	}
+	if _, err := conn.Exec("PRAGMA query_only = ON"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to make database read-only: %w", err)
+	}
+	return db, nil
+}
+
+// openMemoryConn returns a pool pinned to one in-memory connection, since every
+// new connection to ":memory:" would otherwise be a separate empty database
+func openMemoryConn() (*sql.DB, error) {
+	conn, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(1)
+	conn.SetConnMaxLifetime(0)
+	conn.SetConnMaxIdleTime(0)
+	if _, err := conn.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
+	}
+	return conn, nil
+}
+
+// Encrypted reports whether the database is stored encrypted on disk
+func (db *DB) Encrypted() bool {
+	db.encMu.Lock()
+	defer db.encMu.Unlock()
+	return db.encrypted
+}
+
+// Locked reports whether an encrypted database is still waiting for the master password
+func (db *DB) Locked() bool {
+	db.encMu.Lock()
+	defer db.encMu.Unlock()
+	return db.locked
+}
+
+// EncryptionPending returns the encryption change that will be applied on Close
+func (db *DB) EncryptionPending() string {
+	db.encMu.Lock()
+	defer db.encMu.Unlock()
+	return db.pending
+}
+
+// Unlock decrypts an encrypted database into memory and runs pending migrations.
+// A plaintext file left next to the sealed one by an interrupted switch is newer
+// and wins; it is sealed and removed once loaded.
+func (db *DB) Unlock(password string) error {
+	db.encMu.Lock()
+	defer db.encMu.Unlock()
+	if !db.locked {
+		return nil
+	}
+
+	sealed, err := os.ReadFile(EncryptedPath(db.path))
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted database: %w", err)
+	}
+	salt, err := encryptedSalt(sealed)
+	if err != nil {
+		return err
+	}
+	key := deriveDatabaseKey(password, salt)
+	image, err := openImage(key, sealed)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.conn.Exec("PRAGMA query_only = OFF"); err != nil {
+		return fmt.Errorf("failed to make database writable: %w", err)
+	}
+	leftover := false
+	if _, err := os.Stat(db.path); err == nil {
+		logger.Warn("loading plaintext database left by an interrupted encryption switch", "path", db.path)
+		err = db.restoreFrom("file:" + db.path)
+		leftover = true
+	} else {
+		err = db.restoreImage(image)
+	}
+	if err != nil {
+		db.conn.Exec("PRAGMA query_only = ON")
+		return fmt.Errorf("failed to load encrypted database: %w", err)
+	}
+
+	db.key, db.salt = key, salt
+	db.locked = false
+	if err := db.initSchema(); err != nil {
+		return fmt.Errorf("failed to initialize schema: %w", err)
+	}
+	if err := db.migrate(false); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	if leftover {
+		if err := db.flushLocked(); err != nil {
+			return err
+		}
+		removePlaintextFiles(db.path)
+	}
+
+	db.stopFlush = make(chan struct{})
+	go db.flushLoop(db.stopFlush)
+	return nil
+}
+
+// EnableEncryption seals the database with the master password. A sealed snapshot
+// is written now; the plaintext file is replaced when the database is closed.
+func (db *DB) EnableEncryption(password string) error {
+	db.encMu.Lock()
+	defer db.encMu.Unlock()
+	if db.pending == EncryptionPendingDisable {
+		if subtle.ConstantTimeCompare(deriveDatabaseKey(password, db.salt), db.key) != 1 {
+			return ErrWrongDatabaseKey
+		}
+		db.pending = EncryptionPendingNone
+		return nil
+	}
+	if db.encrypted || db.pending == EncryptionPendingEnable {
+		return fmt.Errorf("database is already encrypted")
+	}
+	if err := db.setKey(password); err != nil {
+		return err
+	}
+	if err := db.flushLocked(); err != nil {
+		return err
+	}
+	db.pending = EncryptionPendingEnable
+	return nil
+}
+
+// DisableEncryption stores the database in plaintext again once it is closed
+func (db *DB) DisableEncryption(password string) error {
+	db.encMu.Lock()
+	defer db.encMu.Unlock()
+	switch {
+	case db.locked:
+		return ErrDatabaseLocked
+	case db.pending == EncryptionPendingEnable:
+		db.pending = EncryptionPendingNone
+		db.key, db.salt = nil, nil
+		return os.Remove(EncryptedPath(db.path))
+	case !db.encrypted:
+		return fmt.Errorf("database is not encrypted")
+	}
+	if subtle.ConstantTimeCompare(deriveDatabaseKey(password, db.salt), db.key) != 1 {
+		return ErrWrongDatabaseKey
+	}
+	db.pending = EncryptionPendingDisable
+	return nil
+}
+
+// RekeyEncryption re-seals the database under a new master password; a no-op when
+// the database is not encrypted
+func (db *DB) RekeyEncryption(password string) error {
+	db.encMu.Lock()
+	defer db.encMu.Unlock()
+	if !db.encrypted && db.pending != EncryptionPendingEnable {
+		return nil
+	}
+	if db.locked {
+		return ErrDatabaseLocked
+	}
+	if err := db.setKey(password); err != nil {
+		return err
+	}
+	return db.flushLocked()
+}
+
+func (db *DB) setKey(password string) error {
+	salt := make([]byte, encryptedSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	db.key, db.salt = deriveDatabaseKey(password, salt), salt
+	return nil
+}
+
+// flushLoop seals the in-memory database whenever it has changed
+func (db *DB) flushLoop(stop chan struct{}) {
+	ticker := time.NewTicker(encryptedFlushTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			var changes int64
+			if err := db.conn.QueryRow("SELECT total_changes()").Scan(&changes); err != nil {
+				continue
+			}
+			db.encMu.Lock()
+			if changes != db.flushedChanges {
+				if err := db.flushLocked(); err != nil {
+					logger.Error("failed to write encrypted database", "err", err)
+				} else {
+					db.flushedChanges = changes
+				}
+			}
+			db.encMu.Unlock()
+		}
+	}
+}
+
+// flushLocked writes the sealed database image; encMu must be held
+func (db *DB) flushLocked() error {
+	image, err := db.serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize database: %w", err)
+	}
+	sealed, err := sealImage(db.key, db.salt, image)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(EncryptedPath(db.path), sealed)
+}
+
+// closeEncryption writes the final image and applies a pending switch; called by Close
+func (db *DB) closeEncryption() error {
+	db.encMu.Lock()
+	defer db.encMu.Unlock()
+	if db.stopFlush != nil {
+		close(db.stopFlush)
+		db.stopFlush = nil
+	}
+	if db.locked {
+		return nil
+	}
+
+	switch {
+	case db.pending == EncryptionPendingDisable:
+		image, err := db.serialize()
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(db.path, image); err != nil {
+			return err
+		}
+		return os.Remove(EncryptedPath(db.path))
+	case db.encrypted || db.pending == EncryptionPendingEnable:
+		return db.flushLocked()
+	}
+	return nil
+}
+
+// serialize returns the database file image, switched to rollback-journal mode so it
+// can be opened read-only from memory
+func (db *DB) serialize() ([]byte, error) {
+	if !db.encrypted {
+		if _, err := db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := db.conn.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var image []byte
+	err = conn.Raw(func(driverConn any) error {
+		s, ok := driverConn.(interface{ Serialize() ([]byte, error) })
+		if !ok {
+			return fmt.Errorf("driver cannot serialize databases")
+		}
+		image, err = s.Serialize()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(image) < 100 {
+		return nil, fmt.Errorf("database image too short")
+	}
+	image[18], image[19] = 1, 1
+	return image, nil
+}
+
+// restoreImage replaces the in-memory database with a file image
+func (db *DB) restoreImage(image []byte) error {
+	name, fsys, err := vfs.New(imageFS(image))
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+	return db.restoreFrom("file:term.db?vfs=" + name)
+}
+
+// restoreFrom copies every page of the database at uri into the in-memory database
+func (db *DB) restoreFrom(uri string) error {
+	conn, err := db.conn.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		r, ok := driverConn.(interface {
+			NewRestore(string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return fmt.Errorf("driver cannot restore databases")
+		}
+		backup, err := r.NewRestore(uri)
+		if err != nil {
+			return err
+		}
+		for {
+			more, err := backup.Step(-1)
+			if err != nil {
+				backup.Finish()
+				return err
+			}
+			if !more {
+				break
+			}
+		}
+		return backup.Finish()
+	})
+}
+
+// imageFS exposes a database image as the single read-only file "term.db"
+type imageFS []byte
+
+func (f imageFS) Open(name string) (fs.File, error) {
+	if name != "term.db" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &imageFile{Reader: bytes.NewReader(f)}, nil
+}
+
+type imageFile struct{ *bytes.Reader }
+
+func (f *imageFile) Stat() (fs.FileInfo, error) { return imageInfo(f.Size()), nil }
+func (f *imageFile) Close() error               { return nil }
+
+type imageInfo int64
+
+func (i imageInfo) Name() string       { return "term.db" }
+func (i imageInfo) Size() int64        { return int64(i) }
+func (i imageInfo) Mode() fs.FileMode  { return 0400 }
+func (i imageInfo) ModTime() time.Time { return time.Time{} }
+func (i imageInfo) IsDir() bool        { return false }
+func (i imageInfo) Sys() any           { return nil }
+
+func encryptedSalt(sealed []byte) ([]byte, error) {
+	if len(sealed) < len(encryptedMagic)+encryptedSaltLen || !bytes.HasPrefix(sealed, []byte(encryptedMagic)) {
+		return nil, errNotEncryptedImage
+	}
+	return sealed[len(encryptedMagic) : len(encryptedMagic)+encryptedSaltLen], nil
+}
+
+func sealImage(key, salt, image []byte) ([]byte, error) {
+	aead, err := newDatabaseAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header := append([]byte(encryptedMagic), salt...)
+	out := append(append([]byte{}, header...), nonce...)
+	return aead.Seal(out, nonce, image, header), nil
+}
+
+func openImage(key, sealed []byte) ([]byte, error) {
+	aead, err := newDatabaseAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	headerLen := len(encryptedMagic) + encryptedSaltLen
+	if len(sealed) < headerLen+aead.NonceSize() {
+		return nil, errNotEncryptedImage
+	}
+	nonce := sealed[headerLen : headerLen+aead.NonceSize()]
+	image, err := aead.Open(nil, nonce, sealed[headerLen+aead.NonceSize():], sealed[:headerLen])
+	if err != nil {
+		return nil, ErrWrongDatabaseKey
+	}
+	return image, nil
+}
+
+func newDatabaseAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic replaces path with data via a synced temporary file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removePlaintextFiles deletes a plaintext database and its WAL side files
+func removePlaintextFiles(path string) {
+	for _, p := range []string{path, path + "-wal", path + "-shm"} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			logger.Warn("failed to remove file", "path", p, "err", err)
+		}
+	}
+}
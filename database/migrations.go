@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// migration is one versioned schema change. Steps run in order inside a
+// transaction and must tolerate tables that already have the change, since
+// schema.go creates new installs with the latest layout.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change in the order it was introduced.
+// Append new steps at the end; never renumber or edit a released one.
+var migrations = []migration{
+	{1, "user_keys default identity", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "user_keys", "is_default", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		// Older installs had a single local key; make it the default identity
+		_, err := tx.Exec(`
+			UPDATE user_keys SET is_default = 1
+			WHERE id = (SELECT id FROM user_keys WHERE is_local = 1 ORDER BY created_at, id LIMIT 1)
+			  AND NOT EXISTS (SELECT 1 FROM user_keys WHERE is_local = 1 AND is_default = 1)
+		`)
+		return err
+	}},
+	{2, "recipient_keys sender", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "recipient_keys", "sender_key_id", "INTEGER")
+	}},
+	{3, "secret config values", allowSecretConfigType},
+	{4, "known_hosts metadata", func(tx *sql.Tx) error {
+		for _, col := range []struct{ name, def string }{
+			{"note", "TEXT"},
+			{"alias", "TEXT"},
+			{"review_by", "DATETIME"},
+			{"verified_at", "DATETIME"},
+		} {
+			if err := addColumnIfMissing(tx, "known_hosts", col.name, col.def); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{5, "session templates", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "sessions", "is_template", "INTEGER NOT NULL DEFAULT 0")
+	}},
+	{6, "session trash", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "sessions", "deleted_at", "DATETIME")
+	}},
+	{7, "session icon and color", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "sessions", "icon", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "sessions", "color", "TEXT NOT NULL DEFAULT ''")
+	}},
+}
+
+// latestSchemaVersion is the version a fully migrated database reports
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the highest migration applied to the database
+func (db *DB) SchemaVersion() (int, error) {
+	var version int
+	err := db.conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// migrate brings the database up to the latest schema version. A fresh
+// database already has the latest layout and is only stamped; an existing
+// one is backed up before the first pending step runs.
+func (db *DB) migrate(fresh bool) error {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, latestSchemaVersion())
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if fresh {
+		_, err := db.conn.Exec("INSERT INTO schema_version (version, name) VALUES (?, 'initial schema')", latestSchemaVersion())
+		return err
+	}
+
+	backup, err := db.backup(fmt.Sprintf("pre-v%d", latestSchemaVersion()))
+	if err != nil {
+		return fmt.Errorf("failed to back up database before migrating: %w", err)
+	}
+	logger.Info("migrating database", "from", current, "to", latestSchemaVersion(), "backup", backup)
+
+	for _, m := range pending {
+		if err := db.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs one step and records it in the same transaction
+func (db *DB) applyMigration(m migration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backup writes a consistent copy of the database next to it and returns its path.
+// An encrypted database is backed up as a copy of its sealed file, which matches the
+// in-memory contents right after Unlock.
+func (db *DB) backup(label string) (string, error) {
+	source := db.path
+	if db.encrypted {
+		source = EncryptedPath(db.path)
+	}
+	path := fmt.Sprintf("%s.%s-%s.bak", source, label, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("backup %s already exists", path)
+	}
+	if db.encrypted {
+		sealed, err := os.ReadFile(source)
+		if err != nil {
+			return "", err
+		}
+		return path, writeFileAtomic(path, sealed)
+	}
+	if _, err := db.conn.Exec("VACUUM INTO ?", path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// allowSecretConfigType rebuilds the configs table of older installs whose
+// value_type CHECK constraint predates the 'secret' type
+func allowSecretConfigType(tx *sql.Tx) error {
+	var ddl string
+	err := tx.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'configs'`).Scan(&ddl)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(ddl, "'secret'") {
+		return nil
+	}
+
+	stmts := []string{
+		`CREATE TABLE configs_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT,
+			value_type TEXT NOT NULL CHECK(value_type IN ('string', 'int', 'bool', 'json', 'secret')),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE,
+			UNIQUE(session_id, key)
+		)`,
+		`INSERT INTO configs_new (id, session_id, key, value, value_type, created_at, updated_at)
+			SELECT id, session_id, key, value, value_type, created_at, updated_at FROM configs`,
+		`DROP TABLE configs`,
+		`ALTER TABLE configs_new RENAME TO configs`,
+		`CREATE INDEX IF NOT EXISTS idx_configs_session_id ON configs(session_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_configs_key ON configs(key)`,
+		`CREATE TRIGGER IF NOT EXISTS update_configs_timestamp
+			AFTER UPDATE ON configs
+			FOR EACH ROW
+		BEGIN
+			UPDATE configs SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+		END`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already present
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
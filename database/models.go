@@ -3,7 +3,9 @@ package database
 import (
     "database/sql"
     "encoding/json"
+    "errors"
     "fmt"
+    "strings"
     "time"
 )
 
@@ -15,8 +17,12 @@ type SessionNode struct {
 	Type        string     `json:"type"` // "folder" or "session"
 	SessionType *string    `json:"sessionType,omitempty"` // "ssh", "bash", etc.
 	Position    int        `json:"position"`
+	IsTemplate  bool       `json:"isTemplate"` // parameterised session used to create others
+	Icon        string     `json:"icon,omitempty"`  // icon name shown in the tree instead of the type's default
+	Color       string     `json:"color,omitempty"` // "#rrggbb" accent, e.g. to flag production hosts
 	CreatedAt   time.Time  `json:"createdAt"`
 	UpdatedAt   time.Time  `json:"updatedAt"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"` // set while the node is in the trash
 }
 
 // Config represents a configuration key-value pair for a session
@@ -25,11 +31,112 @@ type Config struct {
 	SessionID string    `json:"sessionId"`
 	Key       string    `json:"key"`
 	Value     string    `json:"value"`
-	ValueType string    `json:"valueType"` // "string", "int", "bool", "json"
+	ValueType string    `json:"valueType"` // "string", "int", "bool", "json", "secret"
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// Credential is a reusable username and secret referenced from configs by credential_id
+type Credential struct {
+    ID        string    `json:"id"`
+    Name      string    `json:"name"`
+    Username  string    `json:"username"`
+    Secret    string    `json:"secret"`  // password, or the passphrase of KeyPath
+    KeyPath   string    `json:"keyPath"` // private key file; empty for password credentials
+    CreatedAt time.Time `json:"createdAt"`
+    UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ScheduledCommand is a command run on an SSH session on a cron schedule
+type ScheduledCommand struct {
+    ID              int64     `json:"id"`
+    SessionID       string    `json:"sessionId"`
+    Name            string    `json:"name"`
+    Command         string    `json:"command"`
+    Cron            string    `json:"cron"`
+    TimeoutSeconds  int       `json:"timeoutSeconds"`
+    Enabled         bool      `json:"enabled"`
+    NotifyOnFailure bool      `json:"notifyOnFailure"`
+    CreatedAt       time.Time `json:"createdAt"`
+    UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// ScheduledCommandRun is the recorded outcome of one scheduled command run
+type ScheduledCommandRun struct {
+    ID         int64     `json:"id"`
+    ScheduleID int64     `json:"scheduleId"`
+    StartedAt  time.Time `json:"startedAt"`
+    DurationMs int64     `json:"durationMs"`
+    ExitCode   int       `json:"exitCode"`
+    Output     string    `json:"output"`
+    Error      string    `json:"error,omitempty"`
+}
+
+// Snippet is a named command from the snippet library. ${name} placeholders
+// in Command are filled in when it runs; FolderID limits it to the sessions of
+// a folder.
+type Snippet struct {
+    ID          int64     `json:"id"`
+    Name        string    `json:"name"`
+    Description string    `json:"description"`
+    Command     string    `json:"command"`
+    FolderID    *string   `json:"folderId"`
+    Tags        []string  `json:"tags"`
+    CreatedAt   time.Time `json:"createdAt"`
+    UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// ConnectionGroup is a named set of sessions connected with one action, e.g.
+// the servers of a tier. Input typed into a terminal of a broadcast group goes
+// to every member.
+type ConnectionGroup struct {
+    ID          int64     `json:"id"`
+    Name        string    `json:"name"`
+    Description string    `json:"description"`
+    Broadcast   bool      `json:"broadcast"`
+    SessionIDs  []string  `json:"sessionIds"` // members, in connect order
+    CreatedAt   time.Time `json:"createdAt"`
+    UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// CommandHistoryEntry is a command run in a terminal. Commands seen through
+// shell integration markers have an exit code and duration; typed ones do not.
+type CommandHistoryEntry struct {
+    ID          int64     `json:"id"`
+    SessionID   string    `json:"sessionId"`
+    SessionName string    `json:"sessionName"`
+    Host        string    `json:"host"`
+    Command     string    `json:"command"`
+    StartedAt   time.Time `json:"startedAt"`
+    DurationMs  *int64    `json:"durationMs"`
+    ExitCode    *int      `json:"exitCode"`
+    Source      string    `json:"source"` // "marker" or "input"
+}
+
+// TransferUsage is the data moved with a host on one day, by its terminals
+// and by SFTP. Read is what came from the host.
+type TransferUsage struct {
+    Host            string `json:"host"`
+    Day             string `json:"day"` // YYYY-MM-DD, local time
+    TerminalRead    int64  `json:"terminalRead"`
+    TerminalWritten int64  `json:"terminalWritten"`
+    SFTPRead        int64  `json:"sftpRead"`
+    SFTPWritten     int64  `json:"sftpWritten"`
+}
+
+// CommandHistoryQuery filters the command history. Every word of Text must
+// appear in the command; Host matches the host or session name.
+type CommandHistoryQuery struct {
+    Text       string     `json:"text"`
+    SessionID  string     `json:"sessionId"`
+    Host       string     `json:"host"`
+    Since      *time.Time `json:"since"`
+    Until      *time.Time `json:"until"`
+    FailedOnly bool       `json:"failedOnly"`
+    Limit      int        `json:"limit"`
+    Offset     int        `json:"offset"`
+}
+
 // Setting represents an application setting
 type Setting struct {
     Key       string    `json:"key"`
@@ -49,6 +156,32 @@ type KnownHost struct {
     PublicKey   []byte    `json:"publicKey"`
     FirstSeen   time.Time `json:"firstSeen"`
     LastSeen    time.Time `json:"lastSeen"`
+    Note        string     `json:"note"`
+    Alias       string     `json:"alias"`
+    ReviewBy    *time.Time `json:"reviewBy,omitempty"`
+    VerifiedAt  *time.Time `json:"verifiedAt,omitempty"` // last manual re-verification
+}
+
+// TrustedSince returns when the current key was last verified, falling back to first contact
+func (kh *KnownHost) TrustedSince() time.Time {
+    if kh.VerifiedAt != nil {
+        return *kh.VerifiedAt
+    }
+    return kh.FirstSeen
+}
+
+// HostKeyAuditEntry records a decision taken about a host key
+type HostKeyAuditEntry struct {
+    ID             int       `json:"id"`
+    Host           string    `json:"host"`
+    Port           int       `json:"port"`
+    KeyType        string    `json:"keyType"`
+    Fingerprint    string    `json:"fingerprint"`
+    OldFingerprint string    `json:"oldFingerprint,omitempty"`
+    Status         string    `json:"status"`
+    Decision       string    `json:"decision"`
+    Actor          string    `json:"actor"`
+    DecidedAt      time.Time `json:"decidedAt"`
 }
 
 // Recording represents a stored session recording metadata
@@ -84,7 +217,8 @@ type UserKey struct {
     PublicKey  string    `json:"publicKey"`   // PEM-encoded RSA public key
     PrivateKey string    `json:"privateKey"`  // PEM-encoded RSA private key (only for local user)
     CreatedAt  time.Time `json:"createdAt"`
-    IsLocal    bool      `json:"isLocal"`     // True if this is the current user's key
+    IsLocal    bool      `json:"isLocal"`     // True if this is one of the current user's identities
+    IsDefault  bool      `json:"isDefault"`   // True for the local identity used when none is chosen
 }
 
 // RecipientKey represents a wrapped file key for a recipient
@@ -93,14 +227,16 @@ type RecipientKey struct {
     RecordingID   int       `json:"recordingId"`
     RecipientName string    `json:"recipientName"` // Name of the recipient
     WrappedKey    string    `json:"wrappedKey"`    // Base64-encoded RSA-OAEP wrapped file key
+    SenderKeyID   *int      `json:"senderKeyId"`   // Local identity the share was made from
     CreatedAt     time.Time `json:"createdAt"`
 }
 
-// GetAllSessions retrieves all session nodes
+// GetAllSessions retrieves all session nodes that are not in the trash
 func (db *DB) GetAllSessions() ([]SessionNode, error) {
 	rows, err := db.conn.Query(`
-		SELECT id, parent_id, name, type, session_type, position, created_at, updated_at
+		SELECT id, parent_id, name, type, session_type, position, is_template, icon, color, created_at, updated_at
 		FROM sessions
+		WHERE deleted_at IS NULL
 		ORDER BY position, name
 	`)
 	if err != nil {
@@ -118,6 +254,9 @@ func (db *DB) GetAllSessions() ([]SessionNode, error) {
 			&session.Type,
 			&session.SessionType,
 			&session.Position,
+			&session.IsTemplate,
+			&session.Icon,
+			&session.Color,
 			&session.CreatedAt,
 			&session.UpdatedAt,
 		)
@@ -127,25 +266,16 @@ func (db *DB) GetAllSessions() ([]SessionNode, error) {
 		sessions = append(sessions, session)
 	}
 
-	fmt.Printf("BACKEND GetAllSessions: Retrieved %d sessions from DB\n", len(sessions))
-	for i, s := range sessions {
-		parentStr := "null"
-		if s.ParentID != nil {
-			parentStr = *s.ParentID
-		}
-		fmt.Printf("  [%d] %s: name=%s, parent=%s, pos=%d, type=%s\n", i, s.ID, s.Name, parentStr, s.Position, s.Type)
-	}
-
 	return sessions, rows.Err()
 }
 
-// GetSession retrieves a single session by ID
+// GetSession retrieves a single session by ID; nodes in the trash are not found
 func (db *DB) GetSession(id string) (*SessionNode, error) {
 	var session SessionNode
 	err := db.conn.QueryRow(`
-		SELECT id, parent_id, name, type, session_type, position, created_at, updated_at
+		SELECT id, parent_id, name, type, session_type, position, is_template, icon, color, created_at, updated_at
 		FROM sessions
-		WHERE id = ?
+		WHERE id = ? AND deleted_at IS NULL
 	`, id).Scan(
 		&session.ID,
 		&session.ParentID,
@@ -153,6 +283,9 @@ func (db *DB) GetSession(id string) (*SessionNode, error) {
 		&session.Type,
 		&session.SessionType,
 		&session.Position,
+		&session.IsTemplate,
+		&session.Icon,
+		&session.Color,
 		&session.CreatedAt,
 		&session.UpdatedAt,
 	)
@@ -165,9 +298,10 @@ func (db *DB) GetSession(id string) (*SessionNode, error) {
 // CreateSession creates a new session node
 func (db *DB) CreateSession(session *SessionNode) error {
 	_, err := db.conn.Exec(`
-		INSERT INTO sessions (id, parent_id, name, type, session_type, position)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, session.ID, session.ParentID, session.Name, session.Type, session.SessionType, session.Position)
+		INSERT INTO sessions (id, parent_id, name, type, session_type, position, is_template, icon, color)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, session.ID, session.ParentID, session.Name, session.Type, session.SessionType, session.Position, session.IsTemplate,
+		session.Icon, session.Color)
 	return err
 }
 
@@ -181,24 +315,195 @@ func (db *DB) UpdateSession(session *SessionNode) error {
 	return err
 }
 
-// DeleteSession deletes a session and optionally its children
+// SetSessionTemplate marks a session as a template (or back to a regular session).
+// UpdateSession leaves the flag alone so renames and moves keep it.
+func (db *DB) SetSessionTemplate(id string, isTemplate bool) error {
+	_, err := db.conn.Exec(`UPDATE sessions SET is_template = ? WHERE id = ? AND type = 'session'`, isTemplate, id)
+	return err
+}
+
+// SetSessionAppearance sets the icon and color shown for a node; empty strings
+// restore the defaults. Like the template flag, UpdateSession leaves them alone.
+func (db *DB) SetSessionAppearance(id, icon, color string) error {
+	_, err := db.conn.Exec(`UPDATE sessions SET icon = ?, color = ? WHERE id = ?`, icon, color, id)
+	return err
+}
+
+// trashTimeLayout formats deleted_at so that every node removed by one delete shares
+// the same value and values sort chronologically as text
+const trashTimeLayout = "2006-01-02 15:04:05.000"
+
+// DeleteSession moves a session to the trash. With cascade its children go along
+// and come back with it on restore; otherwise they move up to this node's parent.
 func (db *DB) DeleteSession(id string, cascade bool) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deletedAt := time.Now().UTC().Format(trashTimeLayout)
 	if !cascade {
 		// Reparent children to this node's parent
 		var parentID *string
-		err := db.conn.QueryRow("SELECT parent_id FROM sessions WHERE id = ?", id).Scan(&parentID)
+		err := tx.QueryRow("SELECT parent_id FROM sessions WHERE id = ?", id).Scan(&parentID)
 		if err != nil && err != sql.ErrNoRows {
 			return err
 		}
 
-		_, err = db.conn.Exec("UPDATE sessions SET parent_id = ? WHERE parent_id = ?", parentID, id)
+		_, err = tx.Exec("UPDATE sessions SET parent_id = ? WHERE parent_id = ? AND deleted_at IS NULL", parentID, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE sessions SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", deletedAt, id)
 		if err != nil {
 			return err
 		}
+		return tx.Commit()
 	}
 
-	_, err := db.conn.Exec("DELETE FROM sessions WHERE id = ?", id)
-	return err
+	_, err = tx.Exec(`
+		WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT s.id FROM sessions s JOIN subtree t ON s.parent_id = t.id WHERE s.deleted_at IS NULL
+		)
+		UPDATE sessions SET deleted_at = ? WHERE id IN subtree AND deleted_at IS NULL
+	`, id, deletedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListDeletedSessions returns the trash: every node deleted on its own, newest first.
+// Children removed together with a deleted folder are not listed separately.
+func (db *DB) ListDeletedSessions() ([]SessionNode, error) {
+	rows, err := db.conn.Query(`
+		SELECT s.id, s.parent_id, s.name, s.type, s.session_type, s.position, s.is_template,
+		       s.icon, s.color, s.created_at, s.updated_at, s.deleted_at
+		FROM sessions s
+		WHERE s.deleted_at IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM sessions p WHERE p.id = s.parent_id AND p.deleted_at = s.deleted_at)
+		ORDER BY s.deleted_at DESC, s.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []SessionNode{}
+	for rows.Next() {
+		var session SessionNode
+		var deletedAt sql.NullTime
+		err := rows.Scan(
+			&session.ID,
+			&session.ParentID,
+			&session.Name,
+			&session.Type,
+			&session.SessionType,
+			&session.Position,
+			&session.IsTemplate,
+			&session.Icon,
+			&session.Color,
+			&session.CreatedAt,
+			&session.UpdatedAt,
+			&deletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if deletedAt.Valid {
+			t := deletedAt.Time
+			session.DeletedAt = &t
+		}
+		result = append(result, session)
+	}
+	return result, rows.Err()
+}
+
+// RestoreSession takes a node and the children deleted with it out of the trash.
+// If its old parent is gone or still in the trash it is restored at the top level.
+func (db *DB) RestoreSession(id string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID *string
+	var deleted bool
+	err = tx.QueryRow("SELECT parent_id, deleted_at IS NOT NULL FROM sessions WHERE id = ?", id).Scan(&parentID, &deleted)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("%w: %s", ErrNotInTrash, id)
+	}
+
+	if parentID != nil {
+		var live int
+		err := tx.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ? AND deleted_at IS NULL", *parentID).Scan(&live)
+		if err != nil {
+			return err
+		}
+		if live == 0 {
+			parentID = nil
+		}
+	}
+
+	_, err = tx.Exec(`
+		WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT s.id FROM sessions s JOIN subtree t ON s.parent_id = t.id
+			WHERE s.deleted_at = (SELECT deleted_at FROM sessions WHERE id = ?)
+		)
+		UPDATE sessions SET deleted_at = NULL WHERE id IN subtree
+	`, id, id)
+	if err != nil {
+		return err
+	}
+
+	// Put it back at the end of its siblings
+	_, err = tx.Exec(`
+		UPDATE sessions SET parent_id = ?, position = (
+			SELECT COALESCE(MAX(position), -1) + 1 FROM sessions
+			WHERE parent_id IS ? AND deleted_at IS NULL AND id <> ?
+		)
+		WHERE id = ?
+	`, parentID, parentID, id, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ErrNotInTrash is returned for a session that is not in the trash
+var ErrNotInTrash = errors.New("session is not in the trash")
+
+// PurgeSession permanently removes a node in the trash together with its children
+func (db *DB) PurgeSession(id string) error {
+	result, err := db.conn.Exec("DELETE FROM sessions WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotInTrash, id)
+	}
+	return nil
+}
+
+// PurgeDeletedSessions permanently removes everything that went to the trash before cutoff
+func (db *DB) PurgeDeletedSessions(before time.Time) (int64, error) {
+	result, err := db.conn.Exec(
+		"DELETE FROM sessions WHERE deleted_at IS NOT NULL AND deleted_at < ?",
+		before.UTC().Format(trashTimeLayout),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 // GetSessionConfigs retrieves all configs for a session
@@ -222,10 +527,34 @@ func (db *DB) GetSessionConfigs(sessionID string) (map[string]string, error) {
 		configs[key] = value
 	}
 
-	fmt.Printf("DEBUG GetSessionConfigs: sessionID=%s, configs=%+v\n", sessionID, configs)
+	logger.Debug("loaded session configs", "session", sessionID, "count", len(configs))
 	return configs, rows.Err()
 }
 
+// GetSessionConfigEntries retrieves the direct configs of a session including their value types
+func (db *DB) GetSessionConfigEntries(sessionID string) ([]Config, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, session_id, key, COALESCE(value, ''), value_type, created_at, updated_at
+		FROM configs
+		WHERE session_id = ?
+		ORDER BY key
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Config
+	for rows.Next() {
+		var c Config
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.Key, &c.Value, &c.ValueType, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
 // GetEffectiveConfig gets the effective configuration for a session by merging parent configs
 func (db *DB) GetEffectiveConfig(sessionID string) (map[string]string, error) {
 	// Get the inheritance chain
@@ -265,14 +594,14 @@ func (db *DB) GetEffectiveConfig(sessionID string) (map[string]string, error) {
 
 // SetSessionConfig sets or updates a config value
 func (db *DB) SetSessionConfig(sessionID, key, value, valueType string) error {
-	fmt.Printf("DEBUG SetSessionConfig: sessionID=%s, key=%s, value=%s, valueType=%s\n", sessionID, key, value, valueType)
+	logger.Debug("set session config", "session", sessionID, "key", key, "type", valueType)
 	_, err := db.conn.Exec(`
 		INSERT INTO configs (session_id, key, value, value_type)
 		VALUES (?, ?, ?, ?)
 		ON CONFLICT(session_id, key) DO UPDATE SET value = ?, value_type = ?
 	`, sessionID, key, value, valueType, value, valueType)
 	if err != nil {
-		fmt.Printf("DEBUG SetSessionConfig ERROR: %v\n", err)
+		logger.Error("failed to set session config", "session", sessionID, "key", key, "err", err)
 	}
 	return err
 }
@@ -283,6 +612,146 @@ func (db *DB) DeleteSessionConfig(sessionID, key string) error {
 	return err
 }
 
+// ConfigChange is one config write applied by ApplyConfigChanges
+type ConfigChange struct {
+	SessionID string
+	Key       string
+	Value     string
+	ValueType string
+	Delete    bool
+}
+
+// ApplyConfigChanges sets or deletes config keys on many sessions in a single transaction
+func (db *DB) ApplyConfigChanges(changes []ConfigChange) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := applyConfigChanges(tx, changes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ImportSessions creates nodes, parents first, and sets config keys in a single
+// transaction, so that a failed import leaves nothing behind
+func (db *DB) ImportSessions(nodes []SessionNode, changes []ConfigChange) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, n := range nodes {
+		if _, err := tx.Exec(`
+			INSERT INTO sessions (id, parent_id, name, type, session_type, position, is_template, icon, color)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, n.ID, n.ParentID, n.Name, n.Type, n.SessionType, n.Position, n.IsTemplate, n.Icon, n.Color); err != nil {
+			return fmt.Errorf("failed to create %s: %w", n.Name, err)
+		}
+	}
+	if err := applyConfigChanges(tx, changes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func applyConfigChanges(tx *sql.Tx, changes []ConfigChange) error {
+	for _, c := range changes {
+		var err error
+		if c.Delete {
+			_, err = tx.Exec("DELETE FROM configs WHERE session_id = ? AND key = ?", c.SessionID, c.Key)
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO configs (session_id, key, value, value_type)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT(session_id, key) DO UPDATE SET value = excluded.value, value_type = excluded.value_type
+			`, c.SessionID, c.Key, c.Value, c.ValueType)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update %s on %s: %w", c.Key, c.SessionID, err)
+		}
+	}
+	return nil
+}
+
+// ListSecretConfigs returns config rows that are secret-typed or use one of the given keys
+func (db *DB) ListSecretConfigs(keys []string) ([]Config, error) {
+	query := `SELECT id, session_id, key, COALESCE(value, ''), value_type FROM configs WHERE value_type = 'secret'`
+	args := make([]interface{}, 0, len(keys))
+	if len(keys) > 0 {
+		query += ` OR key IN (?` + strings.Repeat(`, ?`, len(keys)-1) + `)`
+		for _, k := range keys {
+			args = append(args, k)
+		}
+	}
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Config
+	for rows.Next() {
+		var c Config
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.Key, &c.Value, &c.ValueType); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// UpdateConfigValue rewrites a single config row by id
+func (db *DB) UpdateConfigValue(id int, value, valueType string) error {
+	_, err := db.conn.Exec(`UPDATE configs SET value = ?, value_type = ? WHERE id = ?`, value, valueType, id)
+	return err
+}
+
+// SecretRewrite describes sealed values to replace in one transaction when the master key changes
+type SecretRewrite struct {
+	Configs     map[int]string    // config id -> sealed value
+	PrivateKeys map[int]string    // user key id -> sealed private key
+	Settings    map[string]string // setting key -> sealed value
+	Credentials map[string]string // credential id -> sealed secret
+}
+
+// RewriteSecrets atomically replaces sealed config values, private keys and settings
+func (db *DB) RewriteSecrets(rw SecretRewrite) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, value := range rw.Configs {
+		if _, err := tx.Exec(`UPDATE configs SET value = ?, value_type = 'secret' WHERE id = ?`, value, id); err != nil {
+			return err
+		}
+	}
+	for id, value := range rw.PrivateKeys {
+		if _, err := tx.Exec(`UPDATE user_keys SET private_key = ? WHERE id = ?`, value, id); err != nil {
+			return err
+		}
+	}
+	for id, value := range rw.Credentials {
+		if _, err := tx.Exec(`UPDATE credentials SET secret = ? WHERE id = ?`, value, id); err != nil {
+			return err
+		}
+	}
+	for key, value := range rw.Settings {
+		if _, err := tx.Exec(`
+			INSERT INTO settings (key, value, value_type) VALUES (?, ?, 'string')
+			ON CONFLICT(key) DO UPDATE SET value = excluded.value
+		`, key, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // GetSetting retrieves a setting value
 func (db *DB) GetSetting(key string) (*Setting, error) {
 	var setting Setting
@@ -317,6 +786,55 @@ func (db *DB) GetAllSettings() (map[string]string, error) {
 	return settings, rows.Err()
 }
 
+// ListSettings retrieves all settings with their types and timestamps
+func (db *DB) ListSettings() ([]Setting, error) {
+	rows, err := db.conn.Query(`
+		SELECT key, value, value_type, created_at, updated_at
+		FROM settings
+		ORDER BY key
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []Setting
+	for rows.Next() {
+		var s Setting
+		if err := rows.Scan(&s.Key, &s.Value, &s.ValueType, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+	return settings, rows.Err()
+}
+
+// OnSettingChanged sets fn to be called after every successful settings write,
+// with the new value ("" when the setting was deleted)
+func (db *DB) OnSettingChanged(fn func(key, value string)) {
+	db.settingHookMu.Lock()
+	db.settingHook = fn
+	db.settingHookMu.Unlock()
+}
+
+func (db *DB) settingChanged(key, value string) {
+	db.settingHookMu.RLock()
+	fn := db.settingHook
+	db.settingHookMu.RUnlock()
+	if fn != nil {
+		fn(key, value)
+	}
+}
+
+// DeleteSetting removes a setting
+func (db *DB) DeleteSetting(key string) error {
+	if _, err := db.conn.Exec("DELETE FROM settings WHERE key = ?", key); err != nil {
+		return err
+	}
+	db.settingChanged(key, "")
+	return nil
+}
+
 // SetSetting sets or updates a setting
 func (db *DB) SetSetting(key, value, valueType string) error {
 	_, err := db.conn.Exec(`
@@ -324,7 +842,37 @@ func (db *DB) SetSetting(key, value, valueType string) error {
 		VALUES (?, ?, ?)
 		ON CONFLICT(key) DO UPDATE SET value = ?, value_type = ?
 	`, key, value, valueType, value, valueType)
-	return err
+	if err != nil {
+		return err
+	}
+	db.settingChanged(key, value)
+	return nil
+}
+
+// SetSettings writes several settings in one transaction, so either all of them
+// change or none does
+func (db *DB) SetSettings(settings []Setting) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, st := range settings {
+		if _, err := tx.Exec(`
+			INSERT INTO settings (key, value, value_type)
+			VALUES (?, ?, ?)
+			ON CONFLICT(key) DO UPDATE SET value = ?, value_type = ?
+		`, st.Key, st.Value, st.ValueType, st.Value, st.ValueType); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, st := range settings {
+		db.settingChanged(st.Key, st.Value)
+	}
+	return nil
 }
 
 // SetSettingJSON sets a setting with a JSON value
@@ -372,8 +920,8 @@ func (db *DB) MoveSession(sessionID string, newParentID *string, newPosition int
 	if newParentID != nil {
 		newParentStr = *newParentID
 	}
-	fmt.Printf("BACKEND MoveSession: %s from parent=%s pos=%d to parent=%s pos=%d\n",
-		sessionID, oldParentStr, oldPosition, newParentStr, newPosition)
+	logger.Debug("move session", "session", sessionID, "from", oldParentStr, "fromPos", oldPosition,
+		"to", newParentStr, "toPos", newPosition)
 
 	// Update the session with new parent and position
 	_, err = tx.Exec("UPDATE sessions SET parent_id = ?, position = ? WHERE id = ?",
@@ -383,7 +931,6 @@ func (db *DB) MoveSession(sessionID string, newParentID *string, newPosition int
 	}
 
 	// Reorder siblings in the new parent
-	fmt.Printf("BACKEND Reordering siblings in new parent: %s\n", newParentStr)
 	if err := db.reorderSiblingsInTx(tx, newParentID); err != nil {
 		return err
 	}
@@ -392,13 +939,11 @@ func (db *DB) MoveSession(sessionID string, newParentID *string, newPosition int
 	if (oldParentID == nil && newParentID != nil) ||
 	   (oldParentID != nil && newParentID == nil) ||
 	   (oldParentID != nil && newParentID != nil && *oldParentID != *newParentID) {
-		fmt.Printf("BACKEND Reordering siblings in old parent: %s\n", oldParentStr)
 		if err := db.reorderSiblingsInTx(tx, oldParentID); err != nil {
 			return err
 		}
 	}
 
-	fmt.Printf("BACKEND MoveSession commit successful\n")
 	return tx.Commit()
 }
 
@@ -416,13 +961,13 @@ func (db *DB) reorderSiblingsInTx(tx *sql.Tx, parentID *string) error {
 	if parentID == nil {
 		rows, err = tx.Query(`
 			SELECT id FROM sessions
-			WHERE parent_id IS NULL
+			WHERE parent_id IS NULL AND deleted_at IS NULL
 			ORDER BY position, name
 		`)
 	} else {
 		rows, err = tx.Query(`
 			SELECT id FROM sessions
-			WHERE parent_id = ?
+			WHERE parent_id = ? AND deleted_at IS NULL
 			ORDER BY position, name
 		`, *parentID)
 	}
@@ -446,7 +991,7 @@ func (db *DB) reorderSiblingsInTx(tx *sql.Tx, parentID *string) error {
 		return err
 	}
 
-	fmt.Printf("BACKEND Reordering %d siblings in parent=%s: %v\n", len(ids), parentStr, ids)
+	logger.Debug("reorder siblings", "parent", parentStr, "count", len(ids))
 
 	// Update positions sequentially
 	for i, id := range ids {
@@ -461,18 +1006,36 @@ func (db *DB) reorderSiblingsInTx(tx *sql.Tx, parentID *string) error {
 
 // GetKnownHost looks up a known host by host and port
 func (db *DB) GetKnownHost(host string, port int) (*KnownHost, error) {
-    var kh KnownHost
-    err := db.conn.QueryRow(`
-        SELECT id, host, port, key_type, fingerprint, public_key, first_seen, last_seen
+    kh, err := scanKnownHost(db.conn.QueryRow(`
+        SELECT `+knownHostColumns+`
         FROM known_hosts WHERE host = ? AND port = ?
-    `, host, port).Scan(&kh.ID, &kh.Host, &kh.Port, &kh.KeyType, &kh.Fingerprint, &kh.PublicKey, &kh.FirstSeen, &kh.LastSeen)
+    `, host, port))
     if err != nil {
         if err == sql.ErrNoRows {
             return nil, nil
         }
         return nil, err
     }
-    return &kh, nil
+    return kh, nil
+}
+
+const knownHostColumns = `id, host, port, key_type, fingerprint, public_key, first_seen, last_seen,
+        COALESCE(note, ''), COALESCE(alias, ''), review_by, verified_at`
+
+func scanKnownHost(row interface{ Scan(...interface{}) error }) (*KnownHost, error) {
+    var kh KnownHost
+    var reviewBy, verifiedAt sql.NullTime
+    if err := row.Scan(&kh.ID, &kh.Host, &kh.Port, &kh.KeyType, &kh.Fingerprint, &kh.PublicKey, &kh.FirstSeen, &kh.LastSeen,
+        &kh.Note, &kh.Alias, &reviewBy, &verifiedAt); err != nil {
+        return nil, err
+    }
+    if reviewBy.Valid {
+        kh.ReviewBy = &reviewBy.Time
+    }
+    if verifiedAt.Valid {
+        kh.VerifiedAt = &verifiedAt.Time
+    }
+    return &kh, nil
 }
 
 // UpsertKnownHost inserts or updates a known host entry
@@ -480,7 +1043,9 @@ func (db *DB) UpsertKnownHost(host string, port int, keyType, fingerprint string
     _, err := db.conn.Exec(`
         INSERT INTO known_hosts (host, port, key_type, fingerprint, public_key)
         VALUES (?, ?, ?, ?, ?)
-        ON CONFLICT(host, port) DO UPDATE SET key_type = excluded.key_type, fingerprint = excluded.fingerprint, public_key = excluded.public_key, last_seen = CURRENT_TIMESTAMP
+        ON CONFLICT(host, port) DO UPDATE SET
+            verified_at = CASE WHEN known_hosts.fingerprint <> excluded.fingerprint THEN CURRENT_TIMESTAMP ELSE known_hosts.verified_at END,
+            key_type = excluded.key_type, fingerprint = excluded.fingerprint, public_key = excluded.public_key, last_seen = CURRENT_TIMESTAMP
     `, host, port, keyType, fingerprint, publicKey)
     return err
 }
@@ -488,7 +1053,7 @@ func (db *DB) UpsertKnownHost(host string, port int, keyType, fingerprint string
 // ListKnownHosts returns all known hosts
 func (db *DB) ListKnownHosts() ([]KnownHost, error) {
     rows, err := db.conn.Query(`
-        SELECT id, host, port, key_type, fingerprint, public_key, first_seen, last_seen
+        SELECT ` + knownHostColumns + `
         FROM known_hosts
         ORDER BY host, port
     `)
@@ -499,27 +1064,164 @@ func (db *DB) ListKnownHosts() ([]KnownHost, error) {
 
     var result []KnownHost
     for rows.Next() {
-        var kh KnownHost
-        if err := rows.Scan(&kh.ID, &kh.Host, &kh.Port, &kh.KeyType, &kh.Fingerprint, &kh.PublicKey, &kh.FirstSeen, &kh.LastSeen); err != nil {
+        kh, err := scanKnownHost(rows)
+        if err != nil {
             return nil, err
         }
-        result = append(result, kh)
+        result = append(result, *kh)
     }
     return result, rows.Err()
 }
 
-// DeleteKnownHost removes a known host by id
+// UpdateKnownHostDetails sets the user-maintained note, alias and review date of a known host
+func (db *DB) UpdateKnownHostDetails(id int, note, alias string, reviewBy *time.Time) error {
+    res, err := db.conn.Exec(`
+        UPDATE known_hosts SET note = ?, alias = ?, review_by = ? WHERE id = ?
+    `, nullIfEmpty(note), nullIfEmpty(alias), reviewBy, id)
+    if err != nil {
+        return err
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return fmt.Errorf("known host %d not found", id)
+    }
+    return nil
+}
+
+// MarkKnownHostVerified records a manual re-verification of the current key and sets the next review date
+func (db *DB) MarkKnownHostVerified(id int, nextReview *time.Time) error {
+    res, err := db.conn.Exec(`
+        UPDATE known_hosts SET verified_at = CURRENT_TIMESTAMP, review_by = ? WHERE id = ?
+    `, nextReview, id)
+    if err != nil {
+        return err
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return fmt.Errorf("known host %d not found", id)
+    }
+    return nil
+}
+
+func nullIfEmpty(s string) interface{} {
+    if s == "" {
+        return nil
+    }
+    return s
+}
+
+// DeleteKnownHost removes a known host by id, together with its alternate keys
 func (db *DB) DeleteKnownHost(id int) error {
-    _, err := db.conn.Exec(`DELETE FROM known_hosts WHERE id = ?`, id)
+    _, err := db.conn.Exec(`
+        DELETE FROM known_host_alt_keys
+        WHERE EXISTS (SELECT 1 FROM known_hosts kh WHERE kh.id = ? AND kh.host = known_host_alt_keys.host AND kh.port = known_host_alt_keys.port)
+    `, id)
+    if err != nil {
+        return err
+    }
+    _, err = db.conn.Exec(`DELETE FROM known_hosts WHERE id = ?`, id)
     return err
 }
 
-// DeleteKnownHostByHostPort removes a known host by host and port
+// DeleteKnownHostByHostPort removes a known host by host and port, together with its alternate keys
 func (db *DB) DeleteKnownHostByHostPort(host string, port int) error {
+    if _, err := db.conn.Exec(`DELETE FROM known_host_alt_keys WHERE host = ? AND port = ?`, host, port); err != nil {
+        return err
+    }
     _, err := db.conn.Exec(`DELETE FROM known_hosts WHERE host = ? AND port = ?`, host, port)
     return err
 }
 
+// GetKnownHostAltKey looks up a proven alternate host key by fingerprint
+func (db *DB) GetKnownHostAltKey(host string, port int, fingerprint string) (*KnownHost, error) {
+    var kh KnownHost
+    err := db.conn.QueryRow(`
+        SELECT id, host, port, key_type, fingerprint, public_key, first_seen, last_seen
+        FROM known_host_alt_keys WHERE host = ? AND port = ? AND fingerprint = ?
+    `, host, port, fingerprint).Scan(&kh.ID, &kh.Host, &kh.Port, &kh.KeyType, &kh.Fingerprint, &kh.PublicKey, &kh.FirstSeen, &kh.LastSeen)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &kh, nil
+}
+
+// ListKnownHostAltKeys returns the alternate keys recorded for a host
+func (db *DB) ListKnownHostAltKeys(host string, port int) ([]KnownHost, error) {
+    rows, err := db.conn.Query(`
+        SELECT id, host, port, key_type, fingerprint, public_key, first_seen, last_seen
+        FROM known_host_alt_keys WHERE host = ? AND port = ?
+        ORDER BY key_type, fingerprint
+    `, host, port)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var result []KnownHost
+    for rows.Next() {
+        var kh KnownHost
+        if err := rows.Scan(&kh.ID, &kh.Host, &kh.Port, &kh.KeyType, &kh.Fingerprint, &kh.PublicKey, &kh.FirstSeen, &kh.LastSeen); err != nil {
+            return nil, err
+        }
+        result = append(result, kh)
+    }
+    return result, rows.Err()
+}
+
+// ReplaceKnownHostAltKeys sets the full alternate key set for a host, returning the
+// fingerprints that were added and removed
+func (db *DB) ReplaceKnownHostAltKeys(host string, port int, keys []KnownHost) ([]string, []string, error) {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return nil, nil, err
+    }
+    defer tx.Rollback()
+
+    rows, err := tx.Query(`SELECT fingerprint FROM known_host_alt_keys WHERE host = ? AND port = ?`, host, port)
+    if err != nil {
+        return nil, nil, err
+    }
+    existing := make(map[string]bool)
+    for rows.Next() {
+        var fp string
+        if err := rows.Scan(&fp); err != nil {
+            rows.Close()
+            return nil, nil, err
+        }
+        existing[fp] = true
+    }
+    rows.Close()
+
+    wanted := make(map[string]bool, len(keys))
+    var added, removed []string
+    for _, k := range keys {
+        wanted[k.Fingerprint] = true
+        if !existing[k.Fingerprint] {
+            added = append(added, k.Fingerprint)
+        }
+        _, err := tx.Exec(`
+            INSERT INTO known_host_alt_keys (host, port, key_type, fingerprint, public_key)
+            VALUES (?, ?, ?, ?, ?)
+            ON CONFLICT(host, port, fingerprint) DO UPDATE SET last_seen = CURRENT_TIMESTAMP
+        `, host, port, k.KeyType, k.Fingerprint, k.PublicKey)
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+    for fp := range existing {
+        if wanted[fp] {
+            continue
+        }
+        removed = append(removed, fp)
+        if _, err := tx.Exec(`DELETE FROM known_host_alt_keys WHERE host = ? AND port = ? AND fingerprint = ?`, host, port, fp); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    return added, removed, tx.Commit()
+}
+
 // CreateRecording inserts a new recording row
 func (db *DB) CreateRecording(r *Recording) (int, error) {
     res, err := db.conn.Exec(`
@@ -541,6 +1243,14 @@ func (db *DB) FinishRecording(id int, size int64) error {
     return err
 }
 
+// EndRecordingAt ends a recording that was never finished, e.g. after a crash
+func (db *DB) EndRecordingAt(id int, size int64, endedAt time.Time) error {
+    _, err := db.conn.Exec(`
+        UPDATE recordings SET ended_at = ?, size = ? WHERE id = ?
+    `, endedAt.UTC().Format(time.DateTime), size, id) // as CURRENT_TIMESTAMP writes it
+    return err
+}
+
 // GetRecording returns a recording by id
 func (db *DB) GetRecording(id int) (*Recording, error) {
     var r Recording
@@ -619,9 +1329,9 @@ func (db *DB) GetRecordingKey(recordingID int) (*RecordingKey, error) {
 // SaveUserKey saves a user key to the database
 func (db *DB) SaveUserKey(key *UserKey) error {
     result, err := db.conn.Exec(`
-        INSERT INTO user_keys (name, public_key, private_key, created_at, is_local)
-        VALUES (?, ?, ?, ?, ?)
-    `, key.Name, key.PublicKey, key.PrivateKey, key.CreatedAt, boolToInt(key.IsLocal))
+        INSERT INTO user_keys (name, public_key, private_key, created_at, is_local, is_default)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, key.Name, key.PublicKey, key.PrivateKey, key.CreatedAt, boolToInt(key.IsLocal), boolToInt(key.IsLocal && key.IsDefault))
 
     if err != nil {
         return err
@@ -635,43 +1345,89 @@ func (db *DB) SaveUserKey(key *UserKey) error {
     return nil
 }
 
-// GetUserKey gets a user key by ID
-func (db *DB) GetUserKey(id int) (*UserKey, error) {
+// scanUserKey scans a user_keys row selected with userKeyColumns
+func scanUserKey(row interface{ Scan(...interface{}) error }) (*UserKey, error) {
     var key UserKey
-    var isLocal int
-    err := db.conn.QueryRow(`
-        SELECT id, name, public_key, private_key, created_at, is_local
-        FROM user_keys WHERE id = ?
-    `, id).Scan(&key.ID, &key.Name, &key.PublicKey, &key.PrivateKey, &key.CreatedAt, &isLocal)
-
-    if err != nil {
+    var isLocal, isDefault int
+    if err := row.Scan(&key.ID, &key.Name, &key.PublicKey, &key.PrivateKey, &key.CreatedAt, &isLocal, &isDefault); err != nil {
         return nil, err
     }
     key.IsLocal = isLocal != 0
+    key.IsDefault = isDefault != 0
     return &key, nil
 }
 
-// GetLocalUserKey gets the local user's key
+const userKeyColumns = `id, name, public_key, private_key, created_at, is_local, is_default`
+
+// GetUserKey gets a user key by ID
+func (db *DB) GetUserKey(id int) (*UserKey, error) {
+    return scanUserKey(db.conn.QueryRow(`
+        SELECT `+userKeyColumns+`
+        FROM user_keys WHERE id = ?
+    `, id))
+}
+
+// GetLocalUserKey gets the local user's default identity, falling back to the oldest local key
 func (db *DB) GetLocalUserKey() (*UserKey, error) {
-    var key UserKey
-    var isLocal int
-    err := db.conn.QueryRow(`
-        SELECT id, name, public_key, private_key, created_at, is_local
-        FROM user_keys WHERE is_local = 1 LIMIT 1
-    `).Scan(&key.ID, &key.Name, &key.PublicKey, &key.PrivateKey, &key.CreatedAt, &isLocal)
+    return scanUserKey(db.conn.QueryRow(`
+        SELECT `+userKeyColumns+`
+        FROM user_keys WHERE is_local = 1
+        ORDER BY is_default DESC, created_at ASC, id ASC LIMIT 1
+    `))
+}
 
+// ListLocalUserKeys lists all of the local user's identities
+func (db *DB) ListLocalUserKeys() ([]*UserKey, error) {
+    rows, err := db.conn.Query(`
+        SELECT ` + userKeyColumns + `
+        FROM user_keys WHERE is_local = 1 ORDER BY is_default DESC, name ASC
+    `)
     if err != nil {
         return nil, err
     }
-    key.IsLocal = isLocal != 0
-    return &key, nil
+    defer rows.Close()
+
+    var keys []*UserKey
+    for rows.Next() {
+        key, err := scanUserKey(rows)
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, key)
+    }
+    return keys, rows.Err()
+}
+
+// SetDefaultLocalUserKey marks a local identity as the default one
+func (db *DB) SetDefaultLocalUserKey(id int) error {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var isLocal int
+    if err := tx.QueryRow(`SELECT is_local FROM user_keys WHERE id = ?`, id).Scan(&isLocal); err != nil {
+        return err
+    }
+    if isLocal == 0 {
+        return fmt.Errorf("key %d is not a local identity", id)
+    }
+
+    if _, err := tx.Exec(`UPDATE user_keys SET is_default = 0 WHERE is_local = 1 AND id != ?`, id); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`UPDATE user_keys SET is_default = 1 WHERE id = ?`, id); err != nil {
+        return err
+    }
+    return tx.Commit()
 }
 
 // ListUserKeys lists all user keys (contacts)
 func (db *DB) ListUserKeys() ([]*UserKey, error) {
     rows, err := db.conn.Query(`
-        SELECT id, name, public_key, private_key, created_at, is_local
-        FROM user_keys ORDER BY is_local DESC, name ASC
+        SELECT ` + userKeyColumns + `
+        FROM user_keys ORDER BY is_local DESC, is_default DESC, name ASC
     `)
     if err != nil {
         return nil, err
@@ -680,29 +1436,49 @@ func (db *DB) ListUserKeys() ([]*UserKey, error) {
 
     var keys []*UserKey
     for rows.Next() {
-        var key UserKey
-        var isLocal int
-        if err := rows.Scan(&key.ID, &key.Name, &key.PublicKey, &key.PrivateKey, &key.CreatedAt, &isLocal); err != nil {
+        key, err := scanUserKey(rows)
+        if err != nil {
             return nil, err
         }
-        key.IsLocal = isLocal != 0
-        keys = append(keys, &key)
+        keys = append(keys, key)
     }
     return keys, nil
 }
 
-// DeleteUserKey deletes a user key
-func (db *DB) DeleteUserKey(id int) error {
-    _, err := db.conn.Exec(`DELETE FROM user_keys WHERE id = ?`, id)
+// UpdateUserKeyPrivateKey replaces the stored private key of a local identity
+func (db *DB) UpdateUserKeyPrivateKey(id int, privateKey string) error {
+    _, err := db.conn.Exec(`UPDATE user_keys SET private_key = ? WHERE id = ?`, privateKey, id)
     return err
 }
 
+// DeleteUserKey deletes a user key, promoting another local identity if the default was removed
+func (db *DB) DeleteUserKey(id int) error {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`DELETE FROM user_keys WHERE id = ?`, id); err != nil {
+        return err
+    }
+    _, err = tx.Exec(`
+        UPDATE user_keys SET is_default = 1
+        WHERE id = (SELECT id FROM user_keys WHERE is_local = 1 ORDER BY created_at, id LIMIT 1)
+          AND NOT EXISTS (SELECT 1 FROM user_keys WHERE is_local = 1 AND is_default = 1)
+    `)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
 // SaveRecipientKey saves a wrapped key for a recipient
 func (db *DB) SaveRecipientKey(rk *RecipientKey) error {
     result, err := db.conn.Exec(`
-        INSERT INTO recipient_keys (recording_id, recipient_name, wrapped_key, created_at)
-        VALUES (?, ?, ?, ?)
-    `, rk.RecordingID, rk.RecipientName, rk.WrappedKey, rk.CreatedAt)
+        INSERT INTO recipient_keys (recording_id, recipient_name, wrapped_key, sender_key_id, created_at)
+        VALUES (?, ?, ?, ?, ?)
+    `, rk.RecordingID, rk.RecipientName, rk.WrappedKey, rk.SenderKeyID, rk.CreatedAt)
 
     if err != nil {
         return err
@@ -719,7 +1495,7 @@ func (db *DB) SaveRecipientKey(rk *RecipientKey) error {
 // GetRecipientKeysForRecording gets all recipient keys for a recording
 func (db *DB) GetRecipientKeysForRecording(recordingID int) ([]*RecipientKey, error) {
     rows, err := db.conn.Query(`
-        SELECT id, recording_id, recipient_name, wrapped_key, created_at
+        SELECT id, recording_id, recipient_name, wrapped_key, sender_key_id, created_at
         FROM recipient_keys WHERE recording_id = ? ORDER BY created_at DESC
     `, recordingID)
     if err != nil {
@@ -730,7 +1506,7 @@ func (db *DB) GetRecipientKeysForRecording(recordingID int) ([]*RecipientKey, er
     var keys []*RecipientKey
     for rows.Next() {
         var key RecipientKey
-        if err := rows.Scan(&key.ID, &key.RecordingID, &key.RecipientName, &key.WrappedKey, &key.CreatedAt); err != nil {
+        if err := rows.Scan(&key.ID, &key.RecordingID, &key.RecipientName, &key.WrappedKey, &key.SenderKeyID, &key.CreatedAt); err != nil {
             return nil, err
         }
         keys = append(keys, &key)
@@ -743,3 +1519,979 @@ func (db *DB) DeleteRecipientKey(id int) error {
     _, err := db.conn.Exec(`DELETE FROM recipient_keys WHERE id = ?`, id)
     return err
 }
+
+// AddHostKeyAudit appends a host key decision to the audit trail
+func (db *DB) AddHostKeyAudit(entry *HostKeyAuditEntry) error {
+    _, err := db.conn.Exec(`
+        INSERT INTO host_key_audit (host, port, key_type, fingerprint, old_fingerprint, status, decision, actor)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, entry.Host, entry.Port, entry.KeyType, entry.Fingerprint, entry.OldFingerprint, entry.Status, entry.Decision, entry.Actor)
+    return err
+}
+
+// ListHostKeyAudit returns the most recent audit entries, optionally filtered by host (port 0 matches any)
+func (db *DB) ListHostKeyAudit(host string, port int, limit int) ([]HostKeyAuditEntry, error) {
+    if limit <= 0 {
+        limit = 200
+    }
+    rows, err := db.conn.Query(`
+        SELECT id, host, port, key_type, fingerprint, COALESCE(old_fingerprint, ''), status, decision, actor, decided_at
+        FROM host_key_audit
+        WHERE (? = '' OR host = ?) AND (? = 0 OR port = ?)
+        ORDER BY decided_at DESC, id DESC
+        LIMIT ?
+    `, host, host, port, port, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var result []HostKeyAuditEntry
+    for rows.Next() {
+        var e HostKeyAuditEntry
+        if err := rows.Scan(&e.ID, &e.Host, &e.Port, &e.KeyType, &e.Fingerprint, &e.OldFingerprint, &e.Status, &e.Decision, &e.Actor, &e.DecidedAt); err != nil {
+            return nil, err
+        }
+        result = append(result, e)
+    }
+    return result, rows.Err()
+}
+
+// TagCount is a tag with the number of nodes carrying it
+type TagCount struct {
+    Tag   string `json:"tag"`
+    Count int    `json:"count"`
+}
+
+// GetSessionTags returns the tags of a node in alphabetical order
+func (db *DB) GetSessionTags(sessionID string) ([]string, error) {
+    rows, err := db.conn.Query(`SELECT tag FROM session_tags WHERE session_id = ? ORDER BY tag`, sessionID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    tags := []string{}
+    for rows.Next() {
+        var tag string
+        if err := rows.Scan(&tag); err != nil {
+            return nil, err
+        }
+        tags = append(tags, tag)
+    }
+    return tags, rows.Err()
+}
+
+// SetSessionTags replaces the tags of a node
+func (db *DB) SetSessionTags(sessionID string, tags []string) error {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`DELETE FROM session_tags WHERE session_id = ?`, sessionID); err != nil {
+        return err
+    }
+    for _, tag := range tags {
+        if _, err := tx.Exec(`INSERT OR IGNORE INTO session_tags (session_id, tag) VALUES (?, ?)`, sessionID, tag); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// GetAllSessionTags returns the tags of every node, keyed by session id
+func (db *DB) GetAllSessionTags() (map[string][]string, error) {
+    rows, err := db.conn.Query(`SELECT session_id, tag FROM session_tags ORDER BY session_id, tag`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := make(map[string][]string)
+    for rows.Next() {
+        var id, tag string
+        if err := rows.Scan(&id, &tag); err != nil {
+            return nil, err
+        }
+        result[id] = append(result[id], tag)
+    }
+    return result, rows.Err()
+}
+
+// ListTags returns every tag in use with its usage count
+func (db *DB) ListTags() ([]TagCount, error) {
+    rows, err := db.conn.Query(`SELECT tag, COUNT(*) FROM session_tags GROUP BY tag ORDER BY tag`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []TagCount{}
+    for rows.Next() {
+        var t TagCount
+        if err := rows.Scan(&t.Tag, &t.Count); err != nil {
+            return nil, err
+        }
+        result = append(result, t)
+    }
+    return result, rows.Err()
+}
+
+// GetConfigValues returns the direct values of the given config keys for every node,
+// keyed by session id then config key
+func (db *DB) GetConfigValues(keys []string) (map[string]map[string]string, error) {
+    result := make(map[string]map[string]string)
+    if len(keys) == 0 {
+        return result, nil
+    }
+    args := make([]interface{}, len(keys))
+    for i, k := range keys {
+        args[i] = k
+    }
+    rows, err := db.conn.Query(`
+        SELECT session_id, key, COALESCE(value, '')
+        FROM configs
+        WHERE key IN (?`+strings.Repeat(`, ?`, len(keys)-1)+`)
+    `, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var id, key, value string
+        if err := rows.Scan(&id, &key, &value); err != nil {
+            return nil, err
+        }
+        if result[id] == nil {
+            result[id] = make(map[string]string)
+        }
+        result[id][key] = value
+    }
+    return result, rows.Err()
+}
+
+// SessionUsage is a session with its connection statistics
+type SessionUsage struct {
+    Session         SessionNode `json:"session"`
+    ConnectCount    int         `json:"connectCount"`
+    LastConnectedAt *time.Time  `json:"lastConnectedAt,omitempty"`
+    Favorite        bool        `json:"favorite"`
+}
+
+// RecordSessionConnect bumps the connect count and last-connected time of a session
+func (db *DB) RecordSessionConnect(sessionID string) error {
+    _, err := db.conn.Exec(`
+        INSERT INTO session_usage (session_id, connect_count, last_connected_at)
+        VALUES (?, 1, CURRENT_TIMESTAMP)
+        ON CONFLICT(session_id) DO UPDATE SET
+            connect_count = connect_count + 1,
+            last_connected_at = CURRENT_TIMESTAMP
+    `, sessionID)
+    return err
+}
+
+// SetSessionFavorite pins or unpins a session
+func (db *DB) SetSessionFavorite(sessionID string, favorite bool) error {
+    _, err := db.conn.Exec(`
+        INSERT INTO session_usage (session_id, favorite, favorited_at)
+        VALUES (?, ?, CASE WHEN ? THEN CURRENT_TIMESTAMP END)
+        ON CONFLICT(session_id) DO UPDATE SET
+            favorite = excluded.favorite,
+            favorited_at = excluded.favorited_at
+    `, sessionID, favorite, favorite)
+    return err
+}
+
+// GetSessionUsage returns the statistics of one session (zero values if never connected)
+func (db *DB) GetSessionUsage(sessionID string) (*SessionUsage, error) {
+    list, err := db.listSessionUsage(`s.id = ?`, "", 1, sessionID)
+    if err != nil {
+        return nil, err
+    }
+    if len(list) == 0 {
+        return nil, sql.ErrNoRows
+    }
+    return &list[0], nil
+}
+
+// ListFavoriteSessions returns pinned sessions in the order they were pinned
+func (db *DB) ListFavoriteSessions() ([]SessionUsage, error) {
+    return db.listSessionUsage(`u.favorite = 1`, `ORDER BY u.favorited_at, s.name`, -1)
+}
+
+// ListRecentSessions returns the most recently connected sessions
+func (db *DB) ListRecentSessions(limit int) ([]SessionUsage, error) {
+    return db.listSessionUsage(`u.last_connected_at IS NOT NULL`, `ORDER BY u.last_connected_at DESC, s.name`, limit)
+}
+
+// ListMostUsedSessions returns the sessions with the most connections
+func (db *DB) ListMostUsedSessions(limit int) ([]SessionUsage, error) {
+    return db.listSessionUsage(`u.connect_count > 0`, `ORDER BY u.connect_count DESC, u.last_connected_at DESC`, limit)
+}
+
+func (db *DB) listSessionUsage(where, order string, limit int, args ...interface{}) ([]SessionUsage, error) {
+    rows, err := db.conn.Query(`
+        SELECT s.id, s.parent_id, s.name, s.type, s.session_type, s.position, s.is_template, s.icon, s.color, s.created_at, s.updated_at,
+               COALESCE(u.connect_count, 0), u.last_connected_at, COALESCE(u.favorite, 0)
+        FROM sessions s
+        LEFT JOIN session_usage u ON u.session_id = s.id
+        WHERE s.deleted_at IS NULL AND `+where+`
+        `+order+`
+        LIMIT ?
+    `, append(args, limit)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []SessionUsage{}
+    for rows.Next() {
+        var u SessionUsage
+        var last sql.NullTime
+        err := rows.Scan(
+            &u.Session.ID, &u.Session.ParentID, &u.Session.Name, &u.Session.Type, &u.Session.SessionType,
+            &u.Session.Position, &u.Session.IsTemplate, &u.Session.Icon, &u.Session.Color, &u.Session.CreatedAt, &u.Session.UpdatedAt,
+            &u.ConnectCount, &last, &u.Favorite,
+        )
+        if err != nil {
+            return nil, err
+        }
+        if last.Valid {
+            t := last.Time
+            u.LastConnectedAt = &t
+        }
+        result = append(result, u)
+    }
+    return result, rows.Err()
+}
+
+// ListCredentials returns all credentials ordered by name
+func (db *DB) ListCredentials() ([]Credential, error) {
+    rows, err := db.conn.Query(`
+        SELECT id, name, username, secret, key_path, created_at, updated_at
+        FROM credentials
+        ORDER BY name COLLATE NOCASE
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []Credential{}
+    for rows.Next() {
+        var c Credential
+        if err := rows.Scan(&c.ID, &c.Name, &c.Username, &c.Secret, &c.KeyPath, &c.CreatedAt, &c.UpdatedAt); err != nil {
+            return nil, err
+        }
+        result = append(result, c)
+    }
+    return result, rows.Err()
+}
+
+// GetCredential retrieves a credential by ID
+func (db *DB) GetCredential(id string) (*Credential, error) {
+    var c Credential
+    err := db.conn.QueryRow(`
+        SELECT id, name, username, secret, key_path, created_at, updated_at
+        FROM credentials
+        WHERE id = ?
+    `, id).Scan(&c.ID, &c.Name, &c.Username, &c.Secret, &c.KeyPath, &c.CreatedAt, &c.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    return &c, nil
+}
+
+// CreateCredential stores a new credential; Secret must already be sealed
+func (db *DB) CreateCredential(c *Credential) error {
+    _, err := db.conn.Exec(`
+        INSERT INTO credentials (id, name, username, secret, key_path)
+        VALUES (?, ?, ?, ?, ?)
+    `, c.ID, c.Name, c.Username, c.Secret, c.KeyPath)
+    return err
+}
+
+// UpdateCredential replaces a credential's fields; Secret must already be sealed
+func (db *DB) UpdateCredential(c *Credential) error {
+    result, err := db.conn.Exec(`
+        UPDATE credentials
+        SET name = ?, username = ?, secret = ?, key_path = ?, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, c.Name, c.Username, c.Secret, c.KeyPath, c.ID)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return sql.ErrNoRows
+    }
+    return nil
+}
+
+// DeleteCredential removes a credential
+func (db *DB) DeleteCredential(id string) error {
+    _, err := db.conn.Exec("DELETE FROM credentials WHERE id = ?", id)
+    return err
+}
+
+// ListCredentialUsers returns the live sessions and folders whose own config references a credential
+func (db *DB) ListCredentialUsers(credentialID string) ([]SessionNode, error) {
+    rows, err := db.conn.Query(`
+        SELECT s.id, s.parent_id, s.name, s.type, s.session_type, s.position, s.is_template, s.icon, s.color, s.created_at, s.updated_at
+        FROM configs c
+        JOIN sessions s ON s.id = c.session_id
+        WHERE c.key = 'credential_id' AND c.value = ? AND s.deleted_at IS NULL
+        ORDER BY s.name
+    `, credentialID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []SessionNode{}
+    for rows.Next() {
+        var n SessionNode
+        err := rows.Scan(&n.ID, &n.ParentID, &n.Name, &n.Type, &n.SessionType, &n.Position, &n.IsTemplate, &n.Icon, &n.Color, &n.CreatedAt, &n.UpdatedAt)
+        if err != nil {
+            return nil, err
+        }
+        result = append(result, n)
+    }
+    return result, rows.Err()
+}
+
+// ListScheduledCommands returns all scheduled commands whose session is not in the trash
+func (db *DB) ListScheduledCommands() ([]ScheduledCommand, error) {
+    rows, err := db.conn.Query(`
+        SELECT c.id, c.session_id, c.name, c.command, c.cron, c.timeout_seconds, c.enabled, c.notify_on_failure,
+               c.created_at, c.updated_at
+        FROM scheduled_commands c
+        JOIN sessions s ON s.id = c.session_id
+        WHERE s.deleted_at IS NULL
+        ORDER BY c.name COLLATE NOCASE, c.id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []ScheduledCommand{}
+    for rows.Next() {
+        var c ScheduledCommand
+        err := rows.Scan(&c.ID, &c.SessionID, &c.Name, &c.Command, &c.Cron, &c.TimeoutSeconds, &c.Enabled, &c.NotifyOnFailure,
+            &c.CreatedAt, &c.UpdatedAt)
+        if err != nil {
+            return nil, err
+        }
+        result = append(result, c)
+    }
+    return result, rows.Err()
+}
+
+// GetScheduledCommand retrieves a scheduled command by ID
+func (db *DB) GetScheduledCommand(id int64) (*ScheduledCommand, error) {
+    var c ScheduledCommand
+    err := db.conn.QueryRow(`
+        SELECT id, session_id, name, command, cron, timeout_seconds, enabled, notify_on_failure, created_at, updated_at
+        FROM scheduled_commands
+        WHERE id = ?
+    `, id).Scan(&c.ID, &c.SessionID, &c.Name, &c.Command, &c.Cron, &c.TimeoutSeconds, &c.Enabled, &c.NotifyOnFailure,
+        &c.CreatedAt, &c.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    return &c, nil
+}
+
+// CreateScheduledCommand stores a new scheduled command and sets its ID
+func (db *DB) CreateScheduledCommand(c *ScheduledCommand) error {
+    result, err := db.conn.Exec(`
+        INSERT INTO scheduled_commands (session_id, name, command, cron, timeout_seconds, enabled, notify_on_failure)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, c.SessionID, c.Name, c.Command, c.Cron, c.TimeoutSeconds, c.Enabled, c.NotifyOnFailure)
+    if err != nil {
+        return err
+    }
+    c.ID, err = result.LastInsertId()
+    return err
+}
+
+// UpdateScheduledCommand replaces a scheduled command's settings
+func (db *DB) UpdateScheduledCommand(c *ScheduledCommand) error {
+    result, err := db.conn.Exec(`
+        UPDATE scheduled_commands
+        SET session_id = ?, name = ?, command = ?, cron = ?, timeout_seconds = ?, enabled = ?, notify_on_failure = ?,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, c.SessionID, c.Name, c.Command, c.Cron, c.TimeoutSeconds, c.Enabled, c.NotifyOnFailure, c.ID)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return sql.ErrNoRows
+    }
+    return nil
+}
+
+// DeleteScheduledCommand removes a scheduled command and its history
+func (db *DB) DeleteScheduledCommand(id int64) error {
+    _, err := db.conn.Exec("DELETE FROM scheduled_commands WHERE id = ?", id)
+    return err
+}
+
+// AddScheduledCommandRun records a run and keeps only the newest keep runs of that schedule
+func (db *DB) AddScheduledCommandRun(run *ScheduledCommandRun, keep int) error {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`
+        INSERT INTO scheduled_command_runs (schedule_id, started_at, duration_ms, exit_code, output, error)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, run.ScheduleID, run.StartedAt, run.DurationMs, run.ExitCode, run.Output, run.Error)
+    if err != nil {
+        return err
+    }
+    if run.ID, err = result.LastInsertId(); err != nil {
+        return err
+    }
+    _, err = tx.Exec(`
+        DELETE FROM scheduled_command_runs
+        WHERE schedule_id = ? AND id NOT IN (
+            SELECT id FROM scheduled_command_runs WHERE schedule_id = ? ORDER BY id DESC LIMIT ?
+        )
+    `, run.ScheduleID, run.ScheduleID, keep)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// ListScheduledCommandRuns returns the most recent runs of a scheduled command, newest first
+func (db *DB) ListScheduledCommandRuns(scheduleID int64, limit int) ([]ScheduledCommandRun, error) {
+    rows, err := db.conn.Query(`
+        SELECT id, schedule_id, started_at, duration_ms, exit_code, output, error
+        FROM scheduled_command_runs
+        WHERE schedule_id = ?
+        ORDER BY id DESC
+        LIMIT ?
+    `, scheduleID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []ScheduledCommandRun{}
+    for rows.Next() {
+        var r ScheduledCommandRun
+        if err := rows.Scan(&r.ID, &r.ScheduleID, &r.StartedAt, &r.DurationMs, &r.ExitCode, &r.Output, &r.Error); err != nil {
+            return nil, err
+        }
+        result = append(result, r)
+    }
+    return result, rows.Err()
+}
+
+// SessionChild is a node listed by ListChildSessions with the number of live
+// children it has, so a lazily loaded tree knows which nodes can be expanded
+type SessionChild struct {
+    SessionNode
+    ChildCount int `json:"childCount"`
+}
+
+// ListChildSessions returns one page of the live children of a folder (nil for the
+// top level) in tree order, plus the total number of children
+func (db *DB) ListChildSessions(parentID *string, offset, limit int) ([]SessionChild, int, error) {
+    where := "s.parent_id IS NULL"
+    args := []interface{}{}
+    if parentID != nil {
+        where = "s.parent_id = ?"
+        args = append(args, *parentID)
+    }
+
+    var total int
+    err := db.conn.QueryRow(`SELECT COUNT(*) FROM sessions s WHERE s.deleted_at IS NULL AND `+where, args...).Scan(&total)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    rows, err := db.conn.Query(`
+        SELECT s.id, s.parent_id, s.name, s.type, s.session_type, s.position, s.is_template, s.icon, s.color,
+               s.created_at, s.updated_at,
+               (SELECT COUNT(*) FROM sessions c WHERE c.parent_id = s.id AND c.deleted_at IS NULL)
+        FROM sessions s
+        WHERE s.deleted_at IS NULL AND `+where+`
+        ORDER BY s.position, s.name
+        LIMIT ? OFFSET ?
+    `, append(args, limit, offset)...)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    result := []SessionChild{}
+    for rows.Next() {
+        var c SessionChild
+        err := rows.Scan(
+            &c.ID, &c.ParentID, &c.Name, &c.Type, &c.SessionType, &c.Position, &c.IsTemplate, &c.Icon, &c.Color,
+            &c.CreatedAt, &c.UpdatedAt, &c.ChildCount,
+        )
+        if err != nil {
+            return nil, 0, err
+        }
+        result = append(result, c)
+    }
+    return result, total, rows.Err()
+}
+
+// ListSnippets returns every snippet whose folder is not in the trash, with its tags
+func (db *DB) ListSnippets() ([]Snippet, error) {
+    rows, err := db.conn.Query(`
+        SELECT n.id, n.name, n.description, n.command, n.folder_id, n.created_at, n.updated_at
+        FROM snippets n
+        LEFT JOIN sessions f ON f.id = n.folder_id
+        WHERE n.folder_id IS NULL OR f.deleted_at IS NULL
+        ORDER BY n.name COLLATE NOCASE, n.id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []Snippet{}
+    for rows.Next() {
+        var n Snippet
+        if err := rows.Scan(&n.ID, &n.Name, &n.Description, &n.Command, &n.FolderID, &n.CreatedAt, &n.UpdatedAt); err != nil {
+            return nil, err
+        }
+        n.Tags = []string{}
+        result = append(result, n)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    tags, err := db.snippetTags()
+    if err != nil {
+        return nil, err
+    }
+    for i := range result {
+        if t, ok := tags[result[i].ID]; ok {
+            result[i].Tags = t
+        }
+    }
+    return result, nil
+}
+
+func (db *DB) snippetTags() (map[int64][]string, error) {
+    rows, err := db.conn.Query("SELECT snippet_id, tag FROM snippet_tags ORDER BY tag COLLATE NOCASE")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    tags := make(map[int64][]string)
+    for rows.Next() {
+        var id int64
+        var tag string
+        if err := rows.Scan(&id, &tag); err != nil {
+            return nil, err
+        }
+        tags[id] = append(tags[id], tag)
+    }
+    return tags, rows.Err()
+}
+
+// GetSnippet retrieves a snippet by ID, with its tags
+func (db *DB) GetSnippet(id int64) (*Snippet, error) {
+    var n Snippet
+    err := db.conn.QueryRow(`
+        SELECT id, name, description, command, folder_id, created_at, updated_at
+        FROM snippets
+        WHERE id = ?
+    `, id).Scan(&n.ID, &n.Name, &n.Description, &n.Command, &n.FolderID, &n.CreatedAt, &n.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    rows, err := db.conn.Query("SELECT tag FROM snippet_tags WHERE snippet_id = ? ORDER BY tag COLLATE NOCASE", id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    n.Tags = []string{}
+    for rows.Next() {
+        var tag string
+        if err := rows.Scan(&tag); err != nil {
+            return nil, err
+        }
+        n.Tags = append(n.Tags, tag)
+    }
+    return &n, rows.Err()
+}
+
+// CreateSnippet stores a new snippet with its tags and sets its ID
+func (db *DB) CreateSnippet(n *Snippet) error {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`
+        INSERT INTO snippets (name, description, command, folder_id) VALUES (?, ?, ?, ?)
+    `, n.Name, n.Description, n.Command, n.FolderID)
+    if err != nil {
+        return err
+    }
+    if n.ID, err = result.LastInsertId(); err != nil {
+        return err
+    }
+    if err := setSnippetTags(tx, n.ID, n.Tags); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// UpdateSnippet replaces a snippet and its tags
+func (db *DB) UpdateSnippet(n *Snippet) error {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`
+        UPDATE snippets
+        SET name = ?, description = ?, command = ?, folder_id = ?, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, n.Name, n.Description, n.Command, n.FolderID, n.ID)
+    if err != nil {
+        return err
+    }
+    if count, _ := result.RowsAffected(); count == 0 {
+        return sql.ErrNoRows
+    }
+    if err := setSnippetTags(tx, n.ID, n.Tags); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func setSnippetTags(tx *sql.Tx, id int64, tags []string) error {
+    if _, err := tx.Exec("DELETE FROM snippet_tags WHERE snippet_id = ?", id); err != nil {
+        return err
+    }
+    for _, tag := range tags {
+        if _, err := tx.Exec("INSERT OR IGNORE INTO snippet_tags (snippet_id, tag) VALUES (?, ?)", id, tag); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// DeleteSnippet removes a snippet and its tags
+func (db *DB) DeleteSnippet(id int64) error {
+    _, err := db.conn.Exec("DELETE FROM snippets WHERE id = ?", id)
+    return err
+}
+
+// ListConnectionGroups returns every connection group with its members that
+// are not in the trash
+func (db *DB) ListConnectionGroups() ([]ConnectionGroup, error) {
+    rows, err := db.conn.Query(`
+        SELECT id, name, description, broadcast, created_at, updated_at
+        FROM connection_groups
+        ORDER BY name COLLATE NOCASE, id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []ConnectionGroup{}
+    for rows.Next() {
+        var g ConnectionGroup
+        if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.Broadcast, &g.CreatedAt, &g.UpdatedAt); err != nil {
+            return nil, err
+        }
+        g.SessionIDs = []string{}
+        result = append(result, g)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    members, err := db.connectionGroupMembers()
+    if err != nil {
+        return nil, err
+    }
+    for i := range result {
+        if m, ok := members[result[i].ID]; ok {
+            result[i].SessionIDs = m
+        }
+    }
+    return result, nil
+}
+
+func (db *DB) connectionGroupMembers() (map[int64][]string, error) {
+    rows, err := db.conn.Query(`
+        SELECT m.group_id, m.session_id
+        FROM connection_group_members m
+        JOIN sessions s ON s.id = m.session_id
+        WHERE s.deleted_at IS NULL
+        ORDER BY m.group_id, m.position
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    members := make(map[int64][]string)
+    for rows.Next() {
+        var id int64
+        var sessionID string
+        if err := rows.Scan(&id, &sessionID); err != nil {
+            return nil, err
+        }
+        members[id] = append(members[id], sessionID)
+    }
+    return members, rows.Err()
+}
+
+// GetConnectionGroup retrieves a connection group by ID, with its members that
+// are not in the trash
+func (db *DB) GetConnectionGroup(id int64) (*ConnectionGroup, error) {
+    var g ConnectionGroup
+    err := db.conn.QueryRow(`
+        SELECT id, name, description, broadcast, created_at, updated_at
+        FROM connection_groups
+        WHERE id = ?
+    `, id).Scan(&g.ID, &g.Name, &g.Description, &g.Broadcast, &g.CreatedAt, &g.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    rows, err := db.conn.Query(`
+        SELECT m.session_id
+        FROM connection_group_members m
+        JOIN sessions s ON s.id = m.session_id
+        WHERE m.group_id = ? AND s.deleted_at IS NULL
+        ORDER BY m.position
+    `, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    g.SessionIDs = []string{}
+    for rows.Next() {
+        var sessionID string
+        if err := rows.Scan(&sessionID); err != nil {
+            return nil, err
+        }
+        g.SessionIDs = append(g.SessionIDs, sessionID)
+    }
+    return &g, rows.Err()
+}
+
+// CreateConnectionGroup stores a new connection group with its members and
+// sets its ID
+func (db *DB) CreateConnectionGroup(g *ConnectionGroup) error {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`
+        INSERT INTO connection_groups (name, description, broadcast) VALUES (?, ?, ?)
+    `, g.Name, g.Description, g.Broadcast)
+    if err != nil {
+        return err
+    }
+    if g.ID, err = result.LastInsertId(); err != nil {
+        return err
+    }
+    if err := setConnectionGroupMembers(tx, g.ID, g.SessionIDs); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// UpdateConnectionGroup replaces a connection group and its members
+func (db *DB) UpdateConnectionGroup(g *ConnectionGroup) error {
+    tx, err := db.conn.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`
+        UPDATE connection_groups
+        SET name = ?, description = ?, broadcast = ?, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, g.Name, g.Description, g.Broadcast, g.ID)
+    if err != nil {
+        return err
+    }
+    if count, _ := result.RowsAffected(); count == 0 {
+        return sql.ErrNoRows
+    }
+    if err := setConnectionGroupMembers(tx, g.ID, g.SessionIDs); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func setConnectionGroupMembers(tx *sql.Tx, id int64, sessionIDs []string) error {
+    if _, err := tx.Exec("DELETE FROM connection_group_members WHERE group_id = ?", id); err != nil {
+        return err
+    }
+    for i, sessionID := range sessionIDs {
+        if _, err := tx.Exec(`
+            INSERT OR IGNORE INTO connection_group_members (group_id, session_id, position) VALUES (?, ?, ?)
+        `, id, sessionID, i); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// DeleteConnectionGroup removes a connection group; its sessions stay
+func (db *DB) DeleteConnectionGroup(id int64) error {
+    _, err := db.conn.Exec("DELETE FROM connection_groups WHERE id = ?", id)
+    return err
+}
+
+// AddCommandHistory records a command and sets its ID. Times are stored like
+// deleted_at, so that they compare as text in queries.
+func (db *DB) AddCommandHistory(e *CommandHistoryEntry) error {
+    result, err := db.conn.Exec(`
+        INSERT INTO command_history (session_id, session_name, host, command, started_at, duration_ms, exit_code, source)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, e.SessionID, e.SessionName, e.Host, e.Command, e.StartedAt.UTC().Format(trashTimeLayout), e.DurationMs, e.ExitCode, e.Source)
+    if err != nil {
+        return err
+    }
+    e.ID, err = result.LastInsertId()
+    return err
+}
+
+// SearchCommandHistory returns the commands matching q, newest first, and how
+// many match in total
+func (db *DB) SearchCommandHistory(q CommandHistoryQuery) ([]CommandHistoryEntry, int, error) {
+    where := []string{"1 = 1"}
+    var args []interface{}
+    for _, word := range strings.Fields(q.Text) {
+        where = append(where, "command LIKE ? ESCAPE '\\'")
+        args = append(args, "%"+escapeLike(word)+"%")
+    }
+    if q.SessionID != "" {
+        where = append(where, "session_id = ?")
+        args = append(args, q.SessionID)
+    }
+    if host := strings.TrimSpace(q.Host); host != "" {
+        where = append(where, "(host LIKE ? ESCAPE '\\' OR session_name LIKE ? ESCAPE '\\')")
+        args = append(args, "%"+escapeLike(host)+"%", "%"+escapeLike(host)+"%")
+    }
+    if q.Since != nil {
+        where = append(where, "started_at >= ?")
+        args = append(args, q.Since.UTC().Format(trashTimeLayout))
+    }
+    if q.Until != nil {
+        where = append(where, "started_at < ?")
+        args = append(args, q.Until.UTC().Format(trashTimeLayout))
+    }
+    if q.FailedOnly {
+        where = append(where, "exit_code IS NOT NULL AND exit_code != 0")
+    }
+    cond := strings.Join(where, " AND ")
+
+    var total int
+    if err := db.conn.QueryRow("SELECT COUNT(*) FROM command_history WHERE "+cond, args...).Scan(&total); err != nil {
+        return nil, 0, err
+    }
+
+    rows, err := db.conn.Query(`
+        SELECT id, session_id, session_name, host, command, started_at, duration_ms, exit_code, source
+        FROM command_history
+        WHERE `+cond+`
+        ORDER BY started_at DESC, id DESC
+        LIMIT ? OFFSET ?
+    `, append(args, q.Limit, q.Offset)...)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    result := []CommandHistoryEntry{}
+    for rows.Next() {
+        var e CommandHistoryEntry
+        if err := rows.Scan(&e.ID, &e.SessionID, &e.SessionName, &e.Host, &e.Command, &e.StartedAt, &e.DurationMs, &e.ExitCode, &e.Source); err != nil {
+            return nil, 0, err
+        }
+        result = append(result, e)
+    }
+    return result, total, rows.Err()
+}
+
+// DeleteCommandHistory removes one command from the history
+func (db *DB) DeleteCommandHistory(id int64) error {
+    _, err := db.conn.Exec("DELETE FROM command_history WHERE id = ?", id)
+    return err
+}
+
+// PurgeCommandHistory removes the commands started before a time and returns how many
+func (db *DB) PurgeCommandHistory(before time.Time) (int64, error) {
+    result, err := db.conn.Exec("DELETE FROM command_history WHERE started_at < ?", before.UTC().Format(trashTimeLayout))
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
+// AddTransferUsage adds bytes moved with a host to its total for a day
+func (db *DB) AddTransferUsage(u TransferUsage) error {
+    _, err := db.conn.Exec(`
+        INSERT INTO transfer_usage (host, day, terminal_read, terminal_written, sftp_read, sftp_written)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(host, day) DO UPDATE SET
+            terminal_read = terminal_read + excluded.terminal_read,
+            terminal_written = terminal_written + excluded.terminal_written,
+            sftp_read = sftp_read + excluded.sftp_read,
+            sftp_written = sftp_written + excluded.sftp_written
+    `, u.Host, u.Day, u.TerminalRead, u.TerminalWritten, u.SFTPRead, u.SFTPWritten)
+    return err
+}
+
+// ListTransferUsage returns the daily usage of every host since a day
+// (YYYY-MM-DD), newest day first and the busiest host first within a day
+func (db *DB) ListTransferUsage(since string) ([]TransferUsage, error) {
+    rows, err := db.conn.Query(`
+        SELECT host, day, terminal_read, terminal_written, sftp_read, sftp_written
+        FROM transfer_usage
+        WHERE day >= ?
+        ORDER BY day DESC, terminal_read + terminal_written + sftp_read + sftp_written DESC, host
+    `, since)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []TransferUsage{}
+    for rows.Next() {
+        var u TransferUsage
+        if err := rows.Scan(&u.Host, &u.Day, &u.TerminalRead, &u.TerminalWritten, &u.SFTPRead, &u.SFTPWritten); err != nil {
+            return nil, err
+        }
+        result = append(result, u)
+    }
+    return result, rows.Err()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, with \ as escape character
+func escapeLike(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -1,6 +1,14 @@
 package database
 
 const schema = `
+-- Applied migrations (see migrations.go). Indexes below must only reference
+-- columns that exist in every released version of their table.
+CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Sessions table: stores both folders and session nodes
 CREATE TABLE IF NOT EXISTS sessions (
     id TEXT PRIMARY KEY,
@@ -9,8 +17,12 @@ CREATE TABLE IF NOT EXISTS sessions (
     type TEXT NOT NULL CHECK(type IN ('folder', 'session')),
     session_type TEXT CHECK(session_type IN ('ssh', 'bash', 'zsh', 'fish', 'pwsh', 'git-bash', 'custom', 'rdp', 'vnc', 'telnet', 'powershell', 'cmd', 'serial')),
     position INTEGER NOT NULL DEFAULT 0,
+    is_template INTEGER NOT NULL DEFAULT 0,
+    icon TEXT NOT NULL DEFAULT '',
+    color TEXT NOT NULL DEFAULT '',
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    deleted_at DATETIME, -- set while the node is in the trash
     FOREIGN KEY (parent_id) REFERENCES sessions(id) ON DELETE CASCADE
 );
 
@@ -20,13 +32,143 @@ CREATE TABLE IF NOT EXISTS configs (
     session_id TEXT NOT NULL,
     key TEXT NOT NULL,
     value TEXT,
-    value_type TEXT NOT NULL CHECK(value_type IN ('string', 'int', 'bool', 'json')),
+    value_type TEXT NOT NULL CHECK(value_type IN ('string', 'int', 'bool', 'json', 'secret')),
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE,
     UNIQUE(session_id, key)
 );
 
+-- Session tags: free-form labels used for search and filtering
+CREATE TABLE IF NOT EXISTS session_tags (
+    session_id TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id, tag),
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+-- Session usage: connection statistics and favorites for quick launch
+CREATE TABLE IF NOT EXISTS session_usage (
+    session_id TEXT PRIMARY KEY,
+    connect_count INTEGER NOT NULL DEFAULT 0,
+    last_connected_at DATETIME,
+    favorite INTEGER NOT NULL DEFAULT 0,
+    favorited_at DATETIME,
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+-- Credentials: a username with a password or key file, shared by every
+-- folder or session whose config points at it with credential_id
+CREATE TABLE IF NOT EXISTS credentials (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    username TEXT NOT NULL DEFAULT '',
+    secret TEXT NOT NULL DEFAULT '',   -- sealed password, or the passphrase of key_path
+    key_path TEXT NOT NULL DEFAULT '', -- private key file; empty for password credentials
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Scheduled commands: run on an SSH session whenever the cron expression matches
+CREATE TABLE IF NOT EXISTS scheduled_commands (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    command TEXT NOT NULL,
+    cron TEXT NOT NULL,
+    timeout_seconds INTEGER NOT NULL DEFAULT 0, -- 0 uses the default timeout
+    enabled INTEGER NOT NULL DEFAULT 1,
+    notify_on_failure INTEGER NOT NULL DEFAULT 1,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+-- Scheduled command history: output and exit code of each run
+CREATE TABLE IF NOT EXISTS scheduled_command_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    schedule_id INTEGER NOT NULL,
+    started_at DATETIME NOT NULL,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    exit_code INTEGER NOT NULL,              -- -1 when the command could not run or timed out
+    output TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (schedule_id) REFERENCES scheduled_commands(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_command_runs_schedule ON scheduled_command_runs(schedule_id, started_at);
+
+-- Snippets: named commands with ${variable} placeholders, run on open terminals.
+-- A snippet with a folder is only offered for sessions in that folder.
+CREATE TABLE IF NOT EXISTS snippets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    command TEXT NOT NULL,
+    folder_id TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (folder_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS snippet_tags (
+    snippet_id INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (snippet_id, tag),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+-- Connection groups: named sets of sessions connected with one action. Members
+-- connect in position order; broadcast groups type input into every member.
+CREATE TABLE IF NOT EXISTS connection_groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    description TEXT NOT NULL DEFAULT '',
+    broadcast INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS connection_group_members (
+    group_id INTEGER NOT NULL,
+    session_id TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (group_id, session_id),
+    FOREIGN KEY (group_id) REFERENCES connection_groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+-- Command history: commands run in any terminal, from shell integration markers
+-- or from typed input. session_id is kept after the session is deleted.
+CREATE TABLE IF NOT EXISTS command_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id TEXT NOT NULL DEFAULT '', -- session tree node; empty for ad-hoc terminals
+    session_name TEXT NOT NULL DEFAULT '',
+    host TEXT NOT NULL DEFAULT '',
+    command TEXT NOT NULL,
+    started_at DATETIME NOT NULL,
+    duration_ms INTEGER,                 -- NULL when unknown
+    exit_code INTEGER,                   -- NULL without shell integration
+    source TEXT NOT NULL CHECK(source IN ('marker', 'input'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_history_started ON command_history(started_at);
+CREATE INDEX IF NOT EXISTS idx_command_history_session ON command_history(session_id, started_at);
+CREATE INDEX IF NOT EXISTS idx_command_history_host ON command_history(host, started_at);
+
+-- Transfer usage: bytes moved by the terminals and SFTP clients of each
+-- remote host, per local day
+CREATE TABLE IF NOT EXISTS transfer_usage (
+    host TEXT NOT NULL,
+    day TEXT NOT NULL,                   -- YYYY-MM-DD, local time
+    terminal_read INTEGER NOT NULL DEFAULT 0,
+    terminal_written INTEGER NOT NULL DEFAULT 0,
+    sftp_read INTEGER NOT NULL DEFAULT 0,
+    sftp_written INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (host, day)
+);
+
 -- Application settings: global app configuration
 CREATE TABLE IF NOT EXISTS settings (
     key TEXT PRIMARY KEY,
@@ -41,6 +183,7 @@ CREATE INDEX IF NOT EXISTS idx_sessions_parent_id ON sessions(parent_id);
 CREATE INDEX IF NOT EXISTS idx_sessions_type ON sessions(type);
 CREATE INDEX IF NOT EXISTS idx_configs_session_id ON configs(session_id);
 CREATE INDEX IF NOT EXISTS idx_configs_key ON configs(key);
+CREATE INDEX IF NOT EXISTS idx_session_tags_tag ON session_tags(tag);
 
 -- Triggers for updated_at timestamps
 CREATE TRIGGER IF NOT EXISTS update_sessions_timestamp
@@ -74,6 +217,10 @@ CREATE TABLE IF NOT EXISTS known_hosts (
     public_key BLOB,
     first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
     last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+    note TEXT,
+    alias TEXT,
+    review_by DATETIME,
+    verified_at DATETIME,
     UNIQUE(host, port)
 );
 
@@ -86,6 +233,38 @@ BEGIN
     UPDATE known_hosts SET last_seen = CURRENT_TIMESTAMP WHERE id = NEW.id;
 END;
 
+-- Additional host keys advertised by servers (hostkeys-00@openssh.com) and proven
+-- during a trusted connection; used to accept planned host key rotations
+CREATE TABLE IF NOT EXISTS known_host_alt_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    host TEXT NOT NULL,
+    port INTEGER NOT NULL DEFAULT 22,
+    key_type TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    public_key BLOB,
+    first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(host, port, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS idx_known_host_alt_keys_host_port ON known_host_alt_keys(host, port);
+
+-- Audit trail of host key decisions (prompts and automatic trust)
+CREATE TABLE IF NOT EXISTS host_key_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    host TEXT NOT NULL,
+    port INTEGER NOT NULL DEFAULT 22,
+    key_type TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    old_fingerprint TEXT,
+    status TEXT NOT NULL,      -- "unknown", "mismatch", "rotation"
+    decision TEXT NOT NULL,    -- "accept_once", "trust", "reject", "timeout"
+    actor TEXT NOT NULL,       -- OS user for prompts, "policy:<name>" or "rotation" for automatic decisions
+    decided_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_host_key_audit_host_port ON host_key_audit(host, port);
+
 -- Recordings metadata
 CREATE TABLE IF NOT EXISTS recordings (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -122,7 +301,8 @@ CREATE TABLE IF NOT EXISTS user_keys (
     public_key TEXT NOT NULL,        -- PEM-encoded RSA public key
     private_key TEXT,                -- PEM-encoded RSA private key (only for local user's key)
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    is_local INTEGER NOT NULL DEFAULT 0  -- 1 if this is the current user's key, 0 for recipient keys
+    is_local INTEGER NOT NULL DEFAULT 0, -- 1 if this is one of the current user's keys, 0 for recipient keys
+    is_default INTEGER NOT NULL DEFAULT 0 -- 1 for the local identity used when none is chosen explicitly
 );
 
 CREATE INDEX IF NOT EXISTS idx_user_keys_is_local ON user_keys(is_local);
@@ -133,6 +313,7 @@ CREATE TABLE IF NOT EXISTS recipient_keys (
     recording_id INTEGER NOT NULL,
     recipient_name TEXT NOT NULL,    -- Name of the recipient
     wrapped_key TEXT NOT NULL,       -- Base64-encoded RSA-OAEP wrapped file key
+    sender_key_id INTEGER,           -- Local identity the share was made from
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (recording_id) REFERENCES recordings(id) ON DELETE CASCADE
 );
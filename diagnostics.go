@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"term/database"
+	"term/logging"
+)
+
+// maxDiagnosticsLog bounds how much of each log file goes into a bundle; the
+// end of the file is kept
+const maxDiagnosticsLog = 2 << 20
+
+// diagnosticsSettings are the settings included in the bundle: options that
+// explain behaviour, but no hosts, paths, URLs, names or open tabs
+var diagnosticsSettings = []string{
+	"theme", "active_theme", settingThemeAutoLight, settingThemeAutoDark,
+	"font_family", "font_size", "cursor_style", "terminal_padding", "show_status_bar",
+	"auto_launch", "restore_tabs_on_startup", settingBackgroundMode, "confirm_tab_close", settingKeymap,
+	"recording_default_capture_input", "recording_default_encrypt",
+	settingCommandHistory, settingCommandHistoryRetention, settingTrashRetentionDays,
+	settingLogLevel, settingUpdateChannel, settingUpdateCheck, settingHealthCheckInterval,
+	settingStatsInterval, settingStatsCPU, settingStatsMemory, settingStatsDisk, settingStatsNetwork,
+	settingStatsLoad, settingStatsSensors, settingStatsProcesses, settingStatsDocker, settingStatsBattery,
+	settingSystemKnownHosts, settingHostKeyMaxAge, settingVaultAutoLock, settingSecretsKeySource,
+	settingHTTPPort, settingHTTPSocket, settingHTTPTLS,
+	guacdPortKey, settingGuacdMode,
+}
+
+// diagnosticsReport is diagnostics.json in the bundle. It describes the setup
+// without naming hosts, sessions or users.
+type diagnosticsReport struct {
+	CollectedAt time.Time           `json:"collectedAt"`
+	App         diagnosticsApp      `json:"app"`
+	Sessions    diagnosticsSessions `json:"sessions"`
+	Guacd       GuacdStatus         `json:"guacd"`
+	Database    diagnosticsDatabase `json:"database"`
+	Settings    map[string]string   `json:"settings"` // diagnosticsSettings only
+	ConfigFile  ConfigOverlayStatus `json:"configFile"`
+}
+
+type diagnosticsApp struct {
+	Version   string    `json:"version"`
+	GoVersion string    `json:"goVersion"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	CPUs      int       `json:"cpus"`
+	StartedAt time.Time `json:"startedAt"`
+	LogLevel  string    `json:"logLevel"`
+}
+
+type diagnosticsSessions struct {
+	Folders   int            `json:"folders"`
+	Sessions  int            `json:"sessions"`
+	Templates int            `json:"templates"`
+	ByType    map[string]int `json:"byType"`
+}
+
+type diagnosticsDatabase struct {
+	Integrity []string `json:"integrity"` // ["ok"] when sound
+	Error     string   `json:"error,omitempty"`
+	Encrypted bool     `json:"encrypted"`
+	SizeBytes int64    `json:"sizeBytes"`
+}
+
+// DiagnosticsService collects a bundle to attach to bug reports
+type DiagnosticsService struct {
+	db        *database.DB
+	dbPath    string
+	settings  *SettingsService
+	guacd     *GuacdManager
+	startedAt time.Time
+}
+
+// NewDiagnosticsService creates the diagnostics service
+func NewDiagnosticsService(db *database.DB, dbPath string, settings *SettingsService, guacd *GuacdManager) *DiagnosticsService {
+	return &DiagnosticsService{db: db, dbPath: dbPath, settings: settings, guacd: guacd, startedAt: time.Now()}
+}
+
+func (d *DiagnosticsService) report() diagnosticsReport {
+	r := diagnosticsReport{
+		CollectedAt: time.Now().UTC(),
+		App: diagnosticsApp{
+			Version:   appVersion,
+			GoVersion: runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			CPUs:      runtime.NumCPU(),
+			StartedAt: d.startedAt.UTC(),
+			LogLevel:  levelName(logging.Level()),
+		},
+		Sessions:   diagnosticsSessions{ByType: make(map[string]int)},
+		Guacd:      d.guacd.GetGuacdStatus(),
+		Settings:   make(map[string]string),
+		ConfigFile: d.settings.GetConfigOverlay(),
+	}
+
+	// Only counts: names, hosts and users stay out of the bundle
+	if nodes, err := d.db.GetAllSessions(); err == nil {
+		for _, n := range nodes {
+			switch {
+			case n.Type == "folder":
+				r.Sessions.Folders++
+			case n.IsTemplate:
+				r.Sessions.Templates++
+			default:
+				r.Sessions.Sessions++
+				if n.SessionType != nil {
+					r.Sessions.ByType[*n.SessionType]++
+				}
+			}
+		}
+	}
+
+	r.Database.Encrypted = d.db.Encrypted()
+	if info, err := os.Stat(d.dbPath); err == nil {
+		r.Database.SizeBytes = info.Size()
+	}
+	if result, err := d.db.IntegrityCheck(); err != nil {
+		r.Database.Error = err.Error()
+	} else {
+		r.Database.Integrity = result
+	}
+
+	for _, key := range diagnosticsSettings {
+		r.Settings[key] = settingValue(d.db, key)
+	}
+	return r
+}
+
+// CollectDiagnostics writes a zip with diagnostics.json and the end of the recent
+// log files. Passwords, tokens and keys in the logs are redacted.
+func (d *DiagnosticsService) CollectDiagnostics(destPath string) error {
+	f, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create diagnostics file: %w", err)
+	}
+	zw := zip.NewWriter(f)
+
+	err = d.writeBundle(zw)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(destPath)
+		return fmt.Errorf("failed to write diagnostics: %w", err)
+	}
+	return nil
+}
+
+func (d *DiagnosticsService) writeBundle(zw *zip.Writer) error {
+	data, err := json.MarshalIndent(d.report(), "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := zw.Create("diagnostics.json")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	dir := logging.Dir()
+	if dir == "" {
+		return nil
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, logging.FileName+"*"))
+	sort.Strings(files)
+	for _, path := range files {
+		text, err := readLogTail(path, maxDiagnosticsLog)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create("logs/" + filepath.Base(path))
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, logging.Redact(text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLogTail reads up to max bytes from the end of a log file, starting at a
+// line boundary
+func readLogTail(path string, max int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - max
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+	if offset > 0 {
+		for i, b := range data {
+			if b == '\n' {
+				data = data[i+1:]
+				break
+			}
+		}
+	}
+	return string(data), nil
+}
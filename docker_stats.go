@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContainerStats is the usage of one Docker container on an SSH host
+type ContainerStats struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	MemoryUsed    uint64  `json:"memoryUsed"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+}
+
+// remoteDockerCmd prints one JSON object per running container, or nothing when
+// Docker is missing or the user may not talk to the daemon. `{{json .}}` works with
+// Docker versions predating `--format json`.
+const remoteDockerCmd = `
+		if command -v docker >/dev/null 2>&1; then
+			docker stats --no-stream --format '{{json .}}' 2>/dev/null
+		fi
+`
+
+// dockerStatsLine is the subset of `docker stats` fields used, all strings such
+// as "12.5%" and "1.2GiB / 7.7GiB"
+type dockerStatsLine struct {
+	ID       string `json:"ID"`
+	Name     string `json:"Name"`
+	CPUPerc  string `json:"CPUPerc"`
+	MemPerc  string `json:"MemPerc"`
+	MemUsage string `json:"MemUsage"`
+}
+
+// parseDockerStats reads the output of remoteDockerCmd, busiest containers first
+func parseDockerStats(lines []string) []ContainerStats {
+	var containers []ContainerStats
+	for _, line := range lines {
+		var l dockerStatsLine
+		if json.Unmarshal([]byte(strings.TrimSpace(line)), &l) != nil || l.ID == "" {
+			continue
+		}
+		c := ContainerStats{ID: l.ID, Name: l.Name}
+		c.CPUPercent, _ = strconv.ParseFloat(strings.TrimSuffix(l.CPUPerc, "%"), 64)
+		c.MemoryPercent, _ = strconv.ParseFloat(strings.TrimSuffix(l.MemPerc, "%"), 64)
+		if used, limit, ok := strings.Cut(l.MemUsage, "/"); ok {
+			c.MemoryUsed = parseDockerSize(used)
+			c.MemoryLimit = parseDockerSize(limit)
+		}
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].CPUPercent > containers[j].CPUPercent })
+	return containers
+}
+
+// dockerSizeUnits are the suffixes docker prints, binary for memory and decimal
+// for I/O
+var dockerSizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	// Longest suffixes first so "MiB" is not taken for "B"
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseDockerSize converts a size such as "512MiB" to bytes; 0 when unreadable
+func parseDockerSize(s string) uint64 {
+	s = strings.TrimSpace(s)
+	for _, u := range dockerSizeUnits {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil {
+				return 0
+			}
+			return uint64(v * u.factor)
+		}
+	}
+	return 0
+}
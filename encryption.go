@@ -20,6 +20,22 @@ type Argon2Params struct {
 
 var defaultArgon2 = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 2, KeyLen: 32}
 
+// Bounds of the Argon2 parameters read from backups, export files and sync
+// snapshots, beyond which deriving a key would exhaust memory or hang the app
+const (
+    maxArgon2Memory = 1 << 20 // KiB, i.e. 1 GiB
+    maxArgon2Time   = 16
+)
+
+// checkArgon2Params rejects parameters that did not come from this app, on
+// which argon2.IDKey would panic or exhaust the machine
+func checkArgon2Params(p Argon2Params) error {
+    if p.Time < 1 || p.Time > maxArgon2Time || p.Threads < 1 || p.Memory > maxArgon2Memory || p.KeyLen != 32 {
+        return fmt.Errorf("invalid key derivation parameters (time %d, memory %d KiB, threads %d, key length %d)", p.Time, p.Memory, p.Threads, p.KeyLen)
+    }
+    return nil
+}
+
 func deriveKeyArgon2(passphrase, salt []byte, p Argon2Params) []byte {
     return argon2.IDKey(passphrase, salt, p.Time, p.Memory, p.Threads, p.KeyLen)
 }
@@ -50,6 +66,19 @@ func EncryptKeyGCM(masterKey, plain []byte) ([]byte, []byte, error) {
     return ct, nonce, nil
 }
 
+// DecryptKeyGCM is the counterpart of EncryptKeyGCM
+func DecryptKeyGCM(masterKey, ct, nonce []byte) ([]byte, error) {
+    block, err := aes.NewCipher(masterKey)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    return aead.Open(nil, nonce, ct, nil)
+}
+
 // ChunkedAEADWriter wraps an io.Writer and writes data as length+nonce+ciphertext chunks using AES-GCM
 type ChunkedAEADWriter struct {
     w     io.Writer
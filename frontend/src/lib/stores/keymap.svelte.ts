@@ -0,0 +1,68 @@
+// Keyboard shortcuts, as configured in the backend keymap
+import * as KeymapService from '$bindings/term/keymapservice';
+import { Events } from '@wailsio/runtime';
+
+// Chords the app ships with, used until the backend keymap has loaded
+const defaultKeymap: Record<string, string> = {
+  'tab.new': 'Ctrl+T',
+  'tab.close': 'Ctrl+W',
+  'tab.next': 'Ctrl+Tab',
+  'tab.previous': 'Ctrl+Shift+Tab',
+  'session.new': 'Ctrl+N',
+  'window.new': 'Ctrl+Shift+N',
+  'tab.move_to_new_window': '',
+  'font.increase': 'Ctrl+=',
+  'font.decrease': 'Ctrl+-',
+  'font.reset': 'Ctrl+0',
+  'terminal.copy': 'Ctrl+Shift+C',
+  'terminal.paste': 'Ctrl+Shift+V'
+};
+
+// Writes a key event as a chord in the backend's canonical form ("Ctrl+Shift+T").
+// Shift is left out for punctuation, which it is needed to type.
+export function chordFromEvent(e: KeyboardEvent): string {
+  let key = e.key;
+  if (key === '+') key = 'Plus';
+  else if (key === ' ') key = 'Space';
+  else if (key.length === 1) key = key.toUpperCase();
+  const punctuation = e.key.length === 1 && !/[a-z0-9]/i.test(e.key);
+  const mods = [
+    e.ctrlKey && 'Ctrl',
+    e.altKey && 'Alt',
+    e.shiftKey && !punctuation && 'Shift',
+    e.metaKey && 'Meta'
+  ].filter(Boolean);
+  return [...mods, key].join('+');
+}
+
+// "=" and "+" share a key on most layouts, so Ctrl+= also answers to Ctrl++
+function sameChord(a: string, b: string) {
+  const norm = (c: string) => c.replace(/\+Plus$/, '+=');
+  return norm(a) === norm(b);
+}
+
+class KeymapStore {
+  keymap = $state<Record<string, string>>({ ...defaultKeymap });
+
+  constructor() {
+    Events.On('keymap:changed', (event: any) => {
+      this.keymap = event.data || {};
+    });
+  }
+
+  async load() {
+    try {
+      this.keymap = (await KeymapService.GetKeymap()) || {};
+    } catch (error) {
+      console.error('Failed to load keymap:', error);
+    }
+  }
+
+  // Whether the event is the chord bound to action
+  matches(action: string, e: KeyboardEvent): boolean {
+    const chord = this.keymap[action];
+    return !!chord && sameChord(chordFromEvent(e), chord);
+  }
+}
+
+export const keymapStore = new KeymapStore();
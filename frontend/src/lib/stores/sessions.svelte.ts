@@ -1,6 +1,6 @@
 import type { SessionNode, TreeNode } from '../types';
 import * as SessionService from '$bindings/term/sessionservice';
-import { LoggingService } from '$bindings/term';
+import { log } from '$lib/utils/log';
 
 type sessionType = 'ssh' | 'bash' | 'zsh' | 'fish' | 'pwsh' | 'git-bash' | 'rdp' | 'vnc' | 'telnet' | 'custom' | 'powershell' | 'cmd' | 'serial' | undefined;
 
@@ -27,11 +27,11 @@ class SessionsStore {
       this.tree = (treeData || []).map(node => this.castTreeNode(node));
 
       // Log what we received
-      LoggingService.Log(`loadSessions: Got ${this.sessions.length} sessions, ${this.tree.length} root nodes`, "DEBUG");
+      log(`loadSessions: Got ${this.sessions.length} sessions, ${this.tree.length} root nodes`, "DEBUG");
       const sessionIds = this.sessions.map(s => `${s.id}(parent=${s.parentId},pos=${s.position},type=${s.type},sessionType=${s.sessionType})`);
-      LoggingService.Log(`loadSessions: Sessions: ${sessionIds.join(', ')}`, "DEBUG");
+      log(`loadSessions: Sessions: ${sessionIds.join(', ')}`, "DEBUG");
     } catch (error) {
-      LoggingService.Log(`Failed to load sessions: ${error}`, "ERROR");
+      log(`Failed to load sessions: ${error}`, "ERROR");
       this.sessions = [];
       this.tree = [];
     } finally {
@@ -55,7 +55,7 @@ class SessionsStore {
       await SessionService.CreateSession(session as any);
       await this.loadSessions();
     } catch (error) {
-      LoggingService.Log(`Failed to create session: ${error}`, "ERROR");
+      log(`Failed to create session: ${error}`, "ERROR");
       throw error;
     }
   }
@@ -65,7 +65,7 @@ class SessionsStore {
       await SessionService.UpdateSession(session as any);
       await this.loadSessions();
     } catch (error) {
-      LoggingService.Log(`Failed to update session: ${error}`, "ERROR");
+      log(`Failed to update session: ${error}`, "ERROR");
       throw error;
     }
   }
@@ -75,7 +75,7 @@ class SessionsStore {
       await SessionService.DeleteSession(id, cascade);
       await this.loadSessions();
     } catch (error) {
-      LoggingService.Log(`Failed to delete session: ${error}`, "ERROR");
+      log(`Failed to delete session: ${error}`, "ERROR");
       throw error;
     }
   }
@@ -84,7 +84,7 @@ class SessionsStore {
     try {
       return await SessionService.GetEffectiveConfig(sessionId);
     } catch (error) {
-      LoggingService.Log(`Failed to get effective config: ${error}`, "ERROR");
+      log(`Failed to get effective config: ${error}`, "ERROR");
       return {};
     }
   }
@@ -93,7 +93,7 @@ class SessionsStore {
     try {
       return await SessionService.GetSessionConfig(sessionId);
     } catch (error) {
-      LoggingService.Log(`Failed to get session config: ${error}`, "ERROR");
+      log(`Failed to get session config: ${error}`, "ERROR");
       return {};
     }
   }
@@ -109,11 +109,11 @@ class SessionsStore {
 
   async setSessionConfig(sessionId: string, key: string, value: string, valueType: string = 'string') {
     try {
-      LoggingService.Log(`[Frontend] setSessionConfig: sessionId=${sessionId}, key=${key}, value=${value}, valueType=${valueType}`, "DEBUG");
+      log(`[Frontend] setSessionConfig: sessionId=${sessionId}, key=${key}, value=${value}, valueType=${valueType}`, "DEBUG");
       await SessionService.SetSessionConfig(sessionId, key, value, valueType);
-      LoggingService.Log(`[Frontend] setSessionConfig SUCCESS: ${key}=${value}`, "DEBUG");
+      log(`[Frontend] setSessionConfig SUCCESS: ${key}=${value}`, "DEBUG");
     } catch (error) {
-      LoggingService.Log(`Failed to set session config: ${error}`, "ERROR");
+      log(`Failed to set session config: ${error}`, "ERROR");
       throw error;
     }
   }
@@ -123,7 +123,7 @@ class SessionsStore {
       await SessionService.MoveSession(sessionId, newParentId, newPosition);
       await this.loadSessions();
     } catch (error) {
-      LoggingService.Log(`Failed to move session: ${error}`, "ERROR");
+      log(`Failed to move session: ${error}`, "ERROR");
       throw error;
     }
   }
@@ -1,32 +1,92 @@
 // Settings store for app configuration
 import * as SettingsService from '$bindings/term/settingsservice';
+import * as SystemStatsService from '$bindings/term/systemstatsservice';
+import { Events } from '@wailsio/runtime';
 
 export interface AppSettings {
   theme: string;
   fontFamily: string;
   fontSize: number;
+  cursorStyle: 'block' | 'bar' | 'underline';
+  terminalPadding: number;
   autoLaunch: boolean;
   restoreTabsOnStartup: boolean;
   confirmTabClose: boolean;
+  backgroundMode: boolean;
   showStatusBar: boolean;
   recordingDefaultCaptureInput: boolean;
   recordingDefaultEncrypt: boolean;
 }
 
+// Stats interval and metric toggles, applied to local and SSH stats alike
+export interface StatsSettings {
+  intervalSeconds: number;
+  cpu: boolean;
+  memory: boolean;
+  disk: boolean;
+  network: boolean;
+  load: boolean;
+  sensors: boolean;
+  processes: boolean;
+  docker: boolean;
+  battery: boolean;
+}
+
+// Parses a settings:changed value into the AppSettings field it backs
+const settingFields: Record<string, (value: string) => Partial<AppSettings>> = {
+  theme: (v) => ({ theme: v || 'dark' }),
+  font_family: (v) => ({ fontFamily: v || 'monospace' }),
+  font_size: (v) => ({ fontSize: parseInt(v || '14') }),
+  cursor_style: (v) => ({ cursorStyle: (v || 'block') as AppSettings['cursorStyle'] }),
+  terminal_padding: (v) => ({ terminalPadding: parseInt(v || '0') }),
+  auto_launch: (v) => ({ autoLaunch: v === 'true' }),
+  restore_tabs_on_startup: (v) => ({ restoreTabsOnStartup: (v || 'true') === 'true' }),
+  confirm_tab_close: (v) => ({ confirmTabClose: v === 'true' }),
+  background_mode: (v) => ({ backgroundMode: v === 'true' }),
+  show_status_bar: (v) => ({ showStatusBar: (v || 'true') === 'true' }),
+  recording_default_capture_input: (v) => ({ recordingDefaultCaptureInput: v === 'true' }),
+  recording_default_encrypt: (v) => ({ recordingDefaultEncrypt: (v || 'true') === 'true' })
+};
+
 class SettingsStore {
   settings = $state<AppSettings>({
     theme: 'dark',
     fontFamily: 'monospace',
     fontSize: 14,
+    cursorStyle: 'block',
+    terminalPadding: 0,
     autoLaunch: true,
     restoreTabsOnStartup: true,
     confirmTabClose: false,
+    backgroundMode: false,
     showStatusBar: true,
     recordingDefaultCaptureInput: false,
     recordingDefaultEncrypt: true
   });
+  stats = $state<StatsSettings>({
+    intervalSeconds: 2,
+    cpu: true,
+    memory: true,
+    disk: true,
+    network: true,
+    load: true,
+    sensors: true,
+    processes: true,
+    docker: false,
+    battery: true
+  });
   loading = $state(false);
 
+  constructor() {
+    // Every settings write on the backend is announced, whoever made it (another
+    // window, sync, a bundle import), so the UI never shows stale values
+    Events.On('settings:changed', (event: any) => {
+      const { key, value } = event.data;
+      const field = settingFields[key];
+      if (field) this.settings = { ...this.settings, ...field(value) };
+    });
+  }
+
   async loadSettings() {
     this.loading = true;
     try {
@@ -38,14 +98,20 @@ class SettingsStore {
         theme: allSettings.theme || 'dark',
         fontFamily: allSettings.font_family || 'monospace',
         fontSize: parseInt(allSettings.font_size || '14'),
+        cursorStyle: (allSettings.cursor_style || 'block') as AppSettings['cursorStyle'],
+        terminalPadding: parseInt(allSettings.terminal_padding || '0'),
         autoLaunch: allSettings.auto_launch === 'true',
         restoreTabsOnStartup: (allSettings.restore_tabs_on_startup || 'true') === 'true',
         confirmTabClose: (allSettings.confirm_tab_close || 'false') === 'true',
+        backgroundMode: (allSettings.background_mode || 'false') === 'true',
         showStatusBar: (allSettings.show_status_bar || 'true') === 'true',
         recordingDefaultCaptureInput: (allSettings.recording_default_capture_input || 'false') === 'true',
         recordingDefaultEncrypt: (allSettings.recording_default_encrypt || 'true') === 'true'
       };
 
+      const stats = await SystemStatsService.GetStatsSettings();
+      if (stats) this.stats = stats;
+
       console.log('Parsed settings:', this.settings);
       console.log('=== STORE loadSettings END ===');
     } catch (error) {
@@ -75,6 +141,16 @@ class SettingsStore {
     }
   }
 
+  async setStatsSettings(stats: StatsSettings) {
+    try {
+      await SystemStatsService.SetStatsSettings(stats);
+      this.stats = await SystemStatsService.GetStatsSettings() ?? stats;
+    } catch (error) {
+      console.error('Failed to set stats settings:', error);
+      throw error;
+    }
+  }
+
   async setTheme(theme: string) {
     try {
       await SettingsService.SetTheme(theme);
@@ -102,6 +178,24 @@ class SettingsStore {
     }
   }
 
+  async setCursorStyle(cursorStyle: AppSettings['cursorStyle']) {
+    try {
+      await SettingsService.SetCursorStyle(cursorStyle);
+      this.settings.cursorStyle = cursorStyle;
+    } catch (error) {
+      console.error('Failed to set cursor style:', error);
+    }
+  }
+
+  async setTerminalPadding(padding: number) {
+    try {
+      await SettingsService.SetTerminalPadding(padding.toString());
+      this.settings.terminalPadding = padding;
+    } catch (error) {
+      console.error('Failed to set terminal padding:', error);
+    }
+  }
+
   async setAutoLaunch(autoLaunch: boolean) {
     try {
       await SettingsService.SetAutoLaunch(autoLaunch.toString());
@@ -135,6 +229,16 @@ class SettingsStore {
     }
   }
 
+  async setBackgroundMode(enabled: boolean) {
+    try {
+      await SettingsService.Set('background_mode', enabled.toString());
+      this.settings.backgroundMode = enabled;
+    } catch (error) {
+      console.error('Failed to set background mode:', error);
+      throw error;
+    }
+  }
+
   async setShowStatusBar(show: boolean) {
     try {
       await SettingsService.SetShowStatusBar(show.toString());
@@ -145,7 +249,7 @@ class SettingsStore {
     }
   }
 
-  async saveTabSnapshots(tabs: Array<{sessionId: string, sessionName: string, sessionType: string}>) {
+  async saveTabSnapshots(tabs: Array<{sessionId: string, sessionName: string, sessionType: string, title?: string}>) {
     try {
       await SettingsService.SaveTabSnapshots(JSON.stringify(tabs));
     } catch (error) {
@@ -153,7 +257,7 @@ class SettingsStore {
     }
   }
 
-  async getTabSnapshots(): Promise<Array<{sessionId: string, sessionName: string, sessionType: string}>> {
+  async getTabSnapshots(): Promise<Array<{sessionId: string, sessionName: string, sessionType: string, title?: string}>> {
     try {
       const snapshots = await SettingsService.GetTabSnapshots();
       return JSON.parse(snapshots);
@@ -2,10 +2,13 @@ import type { Terminal } from 'ghostty-web';
 import * as TerminalService from '$bindings/term/terminalservice';
 import * as RemoteStatsService from '$bindings/term/remotestatsservice';
 import * as SystemStatsService from '$bindings/term/systemstatsservice';
+import * as WindowService from '$bindings/term/windowservice';
 import { Events } from '@wailsio/runtime';
 import { settingsStore } from './settings.svelte';
-import * as LoggingService from '$bindings/term/loggingservice';
+import { log, tabContext } from '$lib/utils/log';
 import { alertsStore } from '$lib/stores/alerts.svelte';
+import { formatBytes } from '$lib/utils/format';
+import { windowId, isMainWindow, forThisWindow } from '$lib/utils/window';
 
 export interface TerminalTab {
   id: string;
@@ -18,6 +21,38 @@ export interface TerminalTab {
   exited: boolean;
   exitCode?: number;
   pinned?: boolean;
+  tunnelUrl?: string; // shared Guacamole connection joined on another machine, or one being resumed
+  config?: Record<string, string>; // connection of a tab opened without a saved session, e.g. by `term ssh`
+  reconnects?: number; // remote desktop views are recreated when this changes
+  reconnectFailures?: number; // reconnect attempts since the desktop was last connected
+  attached?: boolean; // backend session already running, e.g. moved from another window
+  tmux?: boolean; // pane of a tmux control mode connection, opened by the backend
+  broadcast?: boolean; // member of a connection group whose input goes to every member
+  title?: string; // set by the program running in the tab (OSC 0/2), e.g. "vim config.yaml"
+}
+
+// Latency and throughput peaks sent with terminal:exit for SSH sessions
+export interface SSHConnSummary {
+  peakLatencyMs: number;
+  peakBytesInPerSec: number;
+  peakBytesOutPerSec: number;
+  bytesIn: number;
+  bytesOut: number;
+}
+
+export interface TransferBytes {
+  read: number;
+  written: number;
+}
+
+// Data moved by a terminal and by SFTP over its connection, sent with terminal:exit
+export interface TransferStats {
+  id: string;
+  nodeId: string;
+  name: string;
+  host: string;
+  terminal: TransferBytes;
+  sftp: TransferBytes;
 }
 
 class TerminalsStore {
@@ -27,20 +62,50 @@ class TerminalsStore {
   constructor() {
     // Listen to terminal events from backend
     // Note: Events.On receives the full event object with structure: {name: string, data: {...}}
+    // Every window gets every terminal event; windowId says which one shows the tab
     Events.On('terminal:data', (event: any) => {
+      if (!forThisWindow(event.data)) return;
       const { id, data } = event.data;
       this.handleTerminalData(id, data);
     });
 
     Events.On('terminal:exit', (event: any) => {
-      const { id, exitCode } = event.data;
-      this.handleTerminalExit(id, exitCode);
+      if (!forThisWindow(event.data)) return;
+      const { id, exitCode, ssh, transfer } = event.data;
+      this.handleTerminalExit(id, exitCode, ssh, transfer);
+    });
+
+    Events.On('terminal:title', (event: any) => {
+      if (!forThisWindow(event.data)) return;
+      const { id, title } = event.data;
+      const tab = this.tabs.find(t => t.backendSessionId === id);
+      if (!tab || tab.title === title) return;
+      tab.title = title;
+      this.saveTabSnapshots();
     });
 
     Events.On('terminal:error', (event: any) => {
+      if (!forThisWindow(event.data)) return;
       const { id, error } = event.data;
       console.error('Terminal error:', id, error);
     });
+
+    // A tab moved between windows: the old window drops it, the new one attaches to it
+    Events.On('terminal:moved', (event: any) => {
+      const { id, windowId: to, fromWindowId, nodeId, name, sessionType, title } = event.data || {};
+      if (fromWindowId === windowId) {
+        this.detachTab(id);
+      } else if (to === windowId) {
+        this.attachTab(id, nodeId, name, sessionType, title);
+      }
+    });
+
+    // The backend opened a tab: a new tmux pane or a connection group member
+    Events.On('terminal:added', (event: any) => {
+      if (!forThisWindow(event.data)) return;
+      const { id, nodeId, name, sessionType, tmux, broadcast } = event.data;
+      this.attachTab(id, nodeId, name, sessionType, '', !!tmux, !!broadcast);
+    });
   }
 
   createTab(sessionId: string, sessionName: string, sessionType: string): TerminalTab {
@@ -64,6 +129,126 @@ class TerminalsStore {
     return tab;
   }
 
+  // Open a tab for a connection that is not saved in the tree
+  createAdHocTab(name: string, sessionType: string, config: Record<string, string>): TerminalTab {
+    const id = `tab-${Date.now()}-${Math.random().toString(36).substr(2, 9)}`;
+
+    const tab: TerminalTab = {
+      id,
+      sessionId: '',
+      backendSessionId: id,
+      sessionName: name,
+      sessionType,
+      terminal: null,
+      active: false,
+      exited: false,
+      config
+    };
+
+    this.tabs.push(tab);
+    this.setActiveTab(id);
+
+    return tab;
+  }
+
+  // Open a tab viewing a connection shared from another instance, e.g.
+  // ws://host:port/api/v1/guacamole/join/$id?token=... (its joinPath)
+  joinSharedTab(tunnelUrl: string, name: string, sessionType: string): TerminalTab {
+    const id = `tab-${Date.now()}-${Math.random().toString(36).substr(2, 9)}`;
+
+    const tab: TerminalTab = {
+      id,
+      sessionId: '',
+      backendSessionId: id,
+      sessionName: name,
+      sessionType,
+      terminal: null,
+      active: false,
+      exited: false,
+      tunnelUrl
+    };
+
+    this.tabs.push(tab);
+    this.setActiveTab(id);
+
+    return tab;
+  }
+
+  // Open a tab for a session another window started, e.g. one moved here,
+  // or one the backend opened for a tmux pane or a connection group
+  attachTab(backendSessionId: string, sessionId: string, sessionName: string, sessionType: string, title = '', tmux = false, broadcast = false) {
+    if (this.tabs.some(t => t.backendSessionId === backendSessionId)) return;
+
+    const tab: TerminalTab = {
+      id: backendSessionId,
+      sessionId,
+      backendSessionId,
+      sessionName: sessionName || sessionType,
+      sessionType,
+      terminal: null,
+      active: false,
+      exited: false,
+      attached: true,
+      tmux,
+      broadcast: broadcast || undefined,
+      title: title || undefined
+    };
+
+    this.tabs.push(tab);
+    this.setActiveTab(tab.id);
+    this.saveTabSnapshots();
+  }
+
+  // Remove a tab whose session moved to another window, leaving the session running
+  detachTab(backendSessionId: string) {
+    const tab = this.tabs.find(t => t.backendSessionId === backendSessionId);
+    if (!tab) return;
+    if (tab.terminal) {
+      try {
+        tab.terminal.dispose();
+      } catch (e) {
+        console.error('Error disposing terminal:', e);
+      }
+      tab.terminal = null;
+    }
+    this.tabs.splice(this.tabs.indexOf(tab), 1);
+    if (this.activeTabId === tab.id) {
+      if (this.tabs.length > 0) {
+        this.setActiveTab(this.tabs[0].id);
+      } else {
+        this.activeTabId = null;
+      }
+    }
+    this.saveTabSnapshots();
+  }
+
+  // Move a tab's running session to another window, or to a new one when
+  // targetWindowId is empty
+  async moveTab(id: string, targetWindowId = '') {
+    const tab = this.getTab(id);
+    if (!tab || tab.exited) return;
+    try {
+      const target = targetWindowId || await WindowService.OpenWindow();
+      await TerminalService.MoveSession(tab.backendSessionId, target, tab.sessionName);
+    } catch (error) {
+      log(`Failed to move tab: ${error}`, 'ERROR', tabContext(tab));
+      await alertsStore.alert(`Could not move tab "${tab.sessionName}": ${error}`, 'Move Tab');
+    }
+  }
+
+  // Attach to the sessions the backend shows in this window that have no tab
+  // yet, e.g. in a new window a tab was moved to while it was loading
+  async attachWindowSessions() {
+    try {
+      const sessions = await TerminalService.GetWindowSessions(windowId);
+      for (const s of sessions || []) {
+        this.attachTab(s.id, s.nodeId, s.name, s.sessionType, s.title);
+      }
+    } catch (error) {
+      log(`Failed to list window sessions: ${error}`, 'ERROR');
+    }
+  }
+
   setActiveTab(id: string) {
     this.tabs.forEach(tab => {
       tab.active = tab.id === id;
@@ -88,35 +273,36 @@ class TerminalsStore {
   }
 
   async closeTab(id: string, skipConfirmation: boolean = false) {
-    LoggingService.Log(`closeTab called: id=${id}, skipConfirmation=${skipConfirmation}`, "INFO");
+    log(`closeTab called: id=${id}, skipConfirmation=${skipConfirmation}`, "INFO");
     const tab = this.getTab(id);
     if (!tab) {
-      LoggingService.Log('Tab not found', "INFO");
+      log('Tab not found', "INFO");
       return;
     }
 
-    LoggingService.Log(`Tab found: ${tab.sessionName}, exited=${tab.exited}, pinned=${tab.pinned}`, "INFO");
+    const ctx = tabContext(tab);
+    log(`Tab found: ${tab.sessionName}, exited=${tab.exited}, pinned=${tab.pinned}`, "INFO", ctx);
 
     // Prevent closing pinned tabs unless explicitly skipping confirmation
     if (tab.pinned && !skipConfirmation) {
-      LoggingService.Log('Cannot close pinned tab', "INFO");
+      log('Cannot close pinned tab', "INFO", ctx);
       await alertsStore.alert(`Tab "${tab.sessionName}" is pinned. Unpin it first to close.`, 'Pinned Tab');
       return;
     }
 
-    LoggingService.Log(`confirmTabClose setting: ${settingsStore.settings.confirmTabClose}`, "INFO");
+    log(`confirmTabClose setting: ${settingsStore.settings.confirmTabClose}`, "INFO", ctx);
 
     // Check for confirmation if enabled and not exited
     if (!skipConfirmation && settingsStore.settings.confirmTabClose && !tab.exited) {
-      LoggingService.Log('Showing confirmation dialog', "INFO");
+      log('Showing confirmation dialog', "INFO", ctx);
       const ok = await alertsStore.confirm(`Close tab "${tab.sessionName}"?`, 'Close Tab');
       if (!ok) {
-        LoggingService.Log('User cancelled close', "INFO");
+        log('User cancelled close', "INFO", ctx);
         return;
       }
-      LoggingService.Log('User confirmed close', "INFO");
+      log('User confirmed close', "INFO", ctx);
     } else {
-      LoggingService.Log(`Skipping confirmation: skipConfirmation=${skipConfirmation}, setting=${settingsStore.settings.confirmTabClose}, exited=${tab.exited}`, "INFO");
+      log(`Skipping confirmation: skipConfirmation=${skipConfirmation}, setting=${settingsStore.settings.confirmTabClose}, exited=${tab.exited}`, "INFO", ctx);
     }
 
     // Close backend session
@@ -151,7 +337,7 @@ class TerminalsStore {
       SystemStatsService.SetActiveSession("");
     }
 
-    LoggingService.Log(`Tab closed successfully, ${this.tabs.length} tabs remaining`, "INFO");
+    log(`Tab closed successfully, ${this.tabs.length} tabs remaining`, "INFO", ctx);
     this.saveTabSnapshots();
   }
 
@@ -176,7 +362,7 @@ class TerminalsStore {
     const tab = this.getTab(id);
     if (tab) {
       tab.pinned = !tab.pinned;
-      LoggingService.Log(`Tab ${tab.sessionName} ${tab.pinned ? 'pinned' : 'unpinned'}`, "INFO");
+      log(`Tab ${tab.sessionName} ${tab.pinned ? 'pinned' : 'unpinned'}`, "INFO");
     }
   }
 
@@ -191,15 +377,25 @@ class TerminalsStore {
     }
   }
 
-  handleTerminalExit(backendSessionId: string, exitCode: number) {
+  handleTerminalExit(backendSessionId: string, exitCode: number, ssh?: SSHConnSummary, transfer?: TransferStats) {
     const tab = this.tabs.find(t => t.backendSessionId === backendSessionId);
     if (tab) {
+      log(`Tab exited with code ${exitCode}`, exitCode === 0 ? 'INFO' : 'WARN', tabContext(tab));
       tab.exited = true;
       tab.exitCode = exitCode;
 
       // Show exit message in terminal
       if (tab.terminal) {
-        const msg = `\r\n\r\n[Process exited with code ${exitCode}]\r\n`;
+        let msg = `\r\n\r\n[Process exited with code ${exitCode}]\r\n`;
+        if (ssh) {
+          // Peaks help explain a session that felt slow
+          msg += `[SSH peak latency ${Math.round(ssh.peakLatencyMs)} ms, ` +
+            `peak ↓ ${formatBytes(ssh.peakBytesInPerSec)}/s ↑ ${formatBytes(ssh.peakBytesOutPerSec)}/s, ` +
+            `total ↓ ${formatBytes(ssh.bytesIn)} ↑ ${formatBytes(ssh.bytesOut)}]\r\n`;
+        }
+        if (transfer && transfer.sftp.read + transfer.sftp.written > 0) {
+          msg += `[SFTP total ↓ ${formatBytes(transfer.sftp.read)} ↑ ${formatBytes(transfer.sftp.written)}]\r\n`;
+        }
         tab.terminal.write(msg);
       }
     }
@@ -210,11 +406,16 @@ class TerminalsStore {
     sessionType: string,
     config: Record<string, string>,
     cols: number,
-    rows: number
+    rows: number,
+    nodeId?: string
   ) {
     try {
+      const tab = this.tabs.find(t => t.backendSessionId === sessionId);
       await TerminalService.StartSession({
         id: sessionId,
+        nodeId,
+        windowId,
+        name: tab?.sessionName,
         sessionType,
         config,
         cols,
@@ -222,15 +423,22 @@ class TerminalsStore {
       } as any);
     } catch (error) {
       console.error('Failed to start session:', error);
+      log(`Failed to start session: ${error}`, 'ERROR', { tab: sessionId, session: nodeId, source: 'terminals' });
       throw error;
     }
   }
 
   async writeToSession(backendSessionId: string, data: string) {
     try {
-      await TerminalService.WriteToSession(backendSessionId, data);
+      const tab = this.tabs.find(t => t.backendSessionId === backendSessionId);
+      if (tab?.broadcast) {
+        await TerminalService.BroadcastInput(backendSessionId, data);
+      } else {
+        await TerminalService.WriteToSession(backendSessionId, data);
+      }
     } catch (error) {
       console.error('Failed to write to session:', error);
+      log(`Failed to write to session: ${error}`, 'ERROR', { tab: backendSessionId, source: 'terminals' });
     }
   }
 
@@ -239,6 +447,7 @@ class TerminalsStore {
       await TerminalService.ResizeSession(backendSessionId, cols, rows);
     } catch (error) {
       console.error('Failed to resize session:', error);
+      log(`Failed to resize session to ${cols}x${rows}: ${error}`, 'ERROR', { tab: backendSessionId, source: 'terminals' });
     }
   }
 
@@ -247,35 +456,42 @@ class TerminalsStore {
       await TerminalService.CloseSession(backendSessionId);
     } catch (error) {
       console.error('Failed to close session:', error);
+      log(`Failed to close session: ${error}`, 'ERROR', { tab: backendSessionId, source: 'terminals' });
     }
   }
 
   saveTabSnapshots() {
+    // Tabs of other windows are not restored, so only the main window saves them
+    if (!isMainWindow) return;
     // Save only non-exited tabs
     const snapshots = this.tabs
-      .filter(tab => !tab.exited)
+      .filter(tab => !tab.exited && !tab.tunnelUrl && !tab.config && !tab.tmux)
       .map(tab => ({
         sessionId: tab.sessionId,
         sessionName: tab.sessionName,
-        sessionType: tab.sessionType
+        sessionType: tab.sessionType,
+        title: tab.title
       }));
     settingsStore.saveTabSnapshots(snapshots);
   }
 
   async restoreTabs() {
-    LoggingService.Log(`restoreTabs called, restoreTabsOnStartup=${settingsStore.settings.restoreTabsOnStartup}`, "INFO");
+    log(`restoreTabs called, restoreTabsOnStartup=${settingsStore.settings.restoreTabsOnStartup}`, "INFO");
+    if (!isMainWindow) return;
     if (!settingsStore.settings.restoreTabsOnStartup) {
-      LoggingService.Log('Tab restoration disabled', "INFO");
+      log('Tab restoration disabled', "INFO");
       return;
     }
 
     const snapshots = await settingsStore.getTabSnapshots();
-    LoggingService.Log(`Found ${snapshots.length} tab snapshots to restore`, "INFO");
+    log(`Found ${snapshots.length} tab snapshots to restore`, "INFO");
     for (const snapshot of snapshots) {
-      LoggingService.Log(`Restoring tab: ${snapshot.sessionName} (${snapshot.sessionType})`, "INFO");
-      this.createTab(snapshot.sessionId, snapshot.sessionName, snapshot.sessionType);
+      log(`Restoring tab: ${snapshot.sessionName} (${snapshot.sessionType})`, "INFO");
+      const tab = this.createTab(snapshot.sessionId, snapshot.sessionName, snapshot.sessionType);
+      // Shown until the restarted program sets its own
+      if (snapshot.title) tab.title = snapshot.title;
     }
-    LoggingService.Log(`Tab restoration complete, ${this.tabs.length} tabs created`, "INFO");
+    log(`Tab restoration complete, ${this.tabs.length} tabs created`, "INFO");
   }
 }
 
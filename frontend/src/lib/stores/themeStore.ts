@@ -1,4 +1,5 @@
-import { writable } from 'svelte/store';
+import { writable, get } from 'svelte/store';
+import { Events } from '@wailsio/runtime';
 
 export interface ThemeColors {
   bg: {
@@ -57,22 +58,34 @@ export interface Theme {
   type: 'dark' | 'light';
   colors: ThemeColors;
   terminal: TerminalColors;
+  warnings?: string[]; // what the backend repaired, e.g. missing colors
+}
+
+// Themes used while the active theme follows the OS appearance
+export interface AutoThemes {
+  light: string;
+  dark: string;
 }
 
 interface ThemeStore {
   themes: Theme[];
   activeTheme: Theme | null;
   previewTheme?: Theme | null;
+  mode: string; // active theme ID, or 'auto' to follow the OS light/dark mode
+  autoThemes: AutoThemes;
 }
 
 function createThemeStore() {
-  const { subscribe, set, update } = writable<ThemeStore>({
+  const store = writable<ThemeStore>({
     themes: [],
     activeTheme: null,
-    previewTheme: null
+    previewTheme: null,
+    mode: 'dark',
+    autoThemes: { light: 'light', dark: 'dark' }
   });
+  const { subscribe, set, update } = store;
 
-  return {
+  const api = {
     subscribe,
 
     // Load all available themes
@@ -81,11 +94,15 @@ function createThemeStore() {
         const ThemeService = await import('$bindings/term/themeservice');
         const themes = await ThemeService.GetAllThemes();
         const activeTheme = await ThemeService.GetActiveTheme();
+        const mode = await ThemeService.GetActiveThemeMode();
+        const autoThemes = await ThemeService.GetAutoThemes();
 
         update(state => ({
           themes: themes || [],
           activeTheme: activeTheme || null,
-          previewTheme: null
+          previewTheme: null,
+          mode: mode || 'dark',
+          autoThemes: autoThemes || state.autoThemes
         }));
 
         // Apply the active theme
@@ -97,18 +114,19 @@ function createThemeStore() {
       }
     },
 
-    // Set the active theme
+    // Set the active theme, or 'auto' to follow the OS light/dark mode
     async setTheme(themeId: string) {
       try {
         const ThemeService = await import('$bindings/term/themeservice');
         await ThemeService.SetActiveTheme(themeId);
 
-        const theme = await ThemeService.GetTheme(themeId);
+        const theme = await ThemeService.GetActiveTheme();
 
         update(state => ({
           ...state,
           activeTheme: theme,
-          previewTheme: null
+          previewTheme: null,
+          mode: themeId
         }));
 
         this.applyTheme(theme);
@@ -117,6 +135,21 @@ function createThemeStore() {
       }
     },
 
+    // Create or update a user theme; returns it as saved, possibly under a new ID
+    async saveTheme(theme: Theme): Promise<Theme> {
+      const ThemeService = await import('$bindings/term/themeservice');
+      const saved = await ThemeService.SaveTheme(theme as any);
+      await this.loadThemes();
+      return saved as Theme;
+    },
+
+    // Set the themes auto mode switches between
+    async setAutoThemes(autoThemes: AutoThemes) {
+      const ThemeService = await import('$bindings/term/themeservice');
+      await ThemeService.SetAutoThemes(autoThemes);
+      update(state => ({ ...state, autoThemes }));
+    },
+
     // Set a temporary preview theme without persisting
     setPreviewTheme(theme: Theme | null) {
       update(state => ({
@@ -186,29 +219,55 @@ function createThemeStore() {
       root.style.setProperty('--term-bright-white', theme.terminal.brightWhite);
     },
 
-    // Import a theme from file
-    async importTheme(filePath: string) {
+    // Import a theme from file; other terminals' and editors' color schemes are
+    // converted, and the backend tells the formats apart
+    async importTheme(filePath: string): Promise<Theme[]> {
       try {
         const ThemeService = await import('$bindings/term/themeservice');
-        await ThemeService.ImportTheme(filePath);
+        const imported = await ThemeService.ImportThemeFile(filePath);
         await this.loadThemes();
+        return (imported || []) as Theme[];
       } catch (error) {
         console.error('Failed to import theme:', error);
         throw error;
       }
     },
 
-    // Export a theme to file
-    async exportTheme(themeId: string, destPath: string) {
+    // Export a theme to file; with includeProfile the font, cursor style and
+    // padding are exported along with it as a bundle
+    async exportTheme(themeId: string, destPath: string, includeProfile = false) {
       try {
         const ThemeService = await import('$bindings/term/themeservice');
-        await ThemeService.ExportTheme(themeId, destPath);
+        if (includeProfile) {
+          await ThemeService.ExportThemeBundle(themeId, destPath);
+        } else {
+          await ThemeService.ExportTheme(themeId, destPath);
+        }
       } catch (error) {
         console.error('Failed to export theme:', error);
         throw error;
       }
     }
   };
+
+  // Follow theme changes made by the backend: auto mode switching with the OS
+  // (theme:changed) and edits to the active theme's file (theme:updated)
+  const onThemeEvent = (event: any) => {
+    const theme = event.data as Theme;
+    if (!theme) return;
+    update(state => ({
+      ...state,
+      activeTheme: theme,
+      themes: state.themes.map(t => (t.id === theme.id ? theme : t))
+    }));
+    if (!get(store).previewTheme) {
+      api.applyTheme(theme);
+    }
+  };
+  Events.On('theme:changed', onThemeEvent);
+  Events.On('theme:updated', onThemeEvent);
+
+  return api;
 }
 
 export const themeStore = createThemeStore();
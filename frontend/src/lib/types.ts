@@ -5,6 +5,7 @@ export interface SessionNode {
   type: 'folder' | 'session';
   sessionType?: 'ssh' | 'bash' | 'zsh' | 'fish' | 'pwsh' | 'git-bash' | 'rdp' | 'vnc' | 'telnet' | 'custom' | 'powershell' | 'cmd' | 'serial';
   position: number;
+  isTemplate?: boolean;
   createdAt: string;
   updatedAt: string;
 }
@@ -0,0 +1,16 @@
+import { APIService } from '$bindings/term';
+
+let token: Promise<string> | null = null;
+let port: Promise<number> | null = null;
+
+// Bearer token the local HTTP server requires, fetched once per run
+export function apiToken(): Promise<string> {
+  token ??= APIService.GetAPIToken();
+  return token;
+}
+
+// WebSocket URL of a path on the local HTTP server, which picks its port at startup
+export async function apiWsUrl(path: string): Promise<string> {
+  port ??= APIService.GetAPIPort();
+  return `ws://127.0.0.1:${await port}${path}`;
+}
@@ -0,0 +1,52 @@
+// Forwards frontend log messages to the app log. Messages below the backend's
+// log level are dropped here instead of crossing to Go.
+import * as LoggingService from '$bindings/term/loggingservice';
+import { Events } from '@wailsio/runtime';
+
+export type LogLevel = 'DEBUG' | 'INFO' | 'WARN' | 'ERROR';
+
+// Where a message comes from. tab is the tab's backend session ID and session
+// its tree node, the keys backend records of the same tab carry.
+export interface LogContext {
+  tab?: string;
+  session?: string;
+  source?: string;
+  attrs?: Record<string, string>;
+}
+
+const levels: Record<string, number> = { DEBUG: 0, INFO: 1, WARN: 2, ERROR: 3 };
+
+let minLevel = levels.INFO;
+
+function setLevel(level: string | undefined) {
+  const n = levels[(level || '').toUpperCase()];
+  if (n !== undefined) minLevel = n;
+}
+
+LoggingService.GetLogLevel()
+  .then((status) => setLevel(status?.level))
+  .catch(() => {});
+Events.On('logging:level', (event: any) => setLevel(event.data?.level));
+
+export function log(message: string, level: LogLevel | string = 'INFO', context?: LogContext) {
+  const n = levels[level.toUpperCase()] ?? levels.INFO;
+  if (n < minLevel) return;
+  if (!context) {
+    LoggingService.Log(message, level).catch(() => {});
+    return;
+  }
+  LoggingService.LogEntry({ level, message, ...context } as any).catch(() => {});
+}
+
+// Log context of a terminal tab
+export function tabContext(tab: { backendSessionId: string; sessionId: string }, source = 'terminals'): LogContext {
+  return { tab: tab.backendSessionId, session: tab.sessionId, source };
+}
+
+// Uncaught errors end up in the app log too, so a frozen or blank tab leaves a trace
+window.addEventListener('error', (e) => {
+  log(`Uncaught error: ${e.message} at ${e.filename}:${e.lineno}`, 'ERROR', { source: 'window' });
+});
+window.addEventListener('unhandledrejection', (e) => {
+  log(`Unhandled rejection: ${e.reason}`, 'ERROR', { source: 'window' });
+});
@@ -0,0 +1,10 @@
+// The app window this frontend runs in: "main", or the ID WindowService.OpenWindow
+// gave a window opened later, which it passes as ?window=
+export const windowId = new URLSearchParams(window.location.search).get('window') || 'main';
+
+export const isMainWindow = windowId === 'main';
+
+// Whether an event is meant for this window; events without a windowId go to all
+export function forThisWindow(data: any): boolean {
+  return !data?.windowId || data.windowId === windowId;
+}
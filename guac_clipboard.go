@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// Clipboard directions a guacd session allows, set with the desktop_clipboard key
+const (
+	clipboardKey           = "desktop_clipboard"
+	clipboardBoth          = "both"
+	clipboardRemoteToLocal = "remote-to-local"
+	clipboardLocalToRemote = "local-to-remote"
+	clipboardNone          = "none"
+)
+
+// clipboardAllowed reports whether text copied on the remote desktop may reach the
+// local clipboard (toLocal) and local text may be pasted into it (toRemote)
+func clipboardAllowed(config map[string]string) (toLocal, toRemote bool) {
+	switch config[clipboardKey] {
+	case clipboardRemoteToLocal:
+		return true, false
+	case clipboardLocalToRemote:
+		return false, true
+	case clipboardNone:
+		return false, false
+	}
+	return true, true
+}
+
+// clipboardFilter drops clipboard streams travelling through the tunnel in one
+// direction. guacd is also told to disable them; the filter covers guacd versions
+// that ignore disable-copy/disable-paste. A clipboard stream is a "clipboard"
+// instruction opening a stream index followed by "blob" and "end" instructions
+// on that index.
+type clipboardFilter struct {
+	streams map[string]bool // stream indexes being dropped
+}
+
+func newClipboardFilter() *clipboardFilter {
+	return &clipboardFilter{streams: make(map[string]bool)}
+}
+
+// filter returns data without the instructions belonging to clipboard streams
+func (f *clipboardFilter) filter(data []byte) []byte {
+	return filterGuacInstructions(data, func(elements []string) bool {
+		if len(elements) < 2 {
+			return false
+		}
+		stream := elements[1]
+		switch elements[0] {
+		case "clipboard":
+			f.streams[stream] = true
+			return true
+		case "blob":
+			return f.streams[stream]
+		case "end":
+			drop := f.streams[stream]
+			delete(f.streams, stream)
+			return drop
+		}
+		return false
+	})
+}
+
+// filterGuacInstructions calls drop with the elements (opcode first) of every
+// instruction in data and returns data without those it dropped. Data that does not
+// parse as complete instructions is passed through unchanged.
+func filterGuacInstructions(data []byte, drop func(elements []string) bool) []byte {
+	var out []byte
+	kept := 0 // start of the data not yet copied to out
+	pos := 0
+	for pos < len(data) {
+		elements, end, ok := parseGuacInstruction(data[pos:])
+		if !ok {
+			break
+		}
+		if drop(elements) {
+			out = append(out, data[kept:pos]...)
+			kept = pos + end
+		}
+		pos += end
+	}
+	if kept == 0 {
+		return data
+	}
+	return append(out, data[kept:]...)
+}
+
+// parseGuacInstruction reads the elements of the first instruction in data and
+// returns them with the byte length of the instruction. Element lengths in the
+// Guacamole protocol count Unicode characters, not bytes.
+func parseGuacInstruction(data []byte) ([]string, int, bool) {
+	var elements []string
+	pos := 0
+	for {
+		dot := pos
+		for dot < len(data) && data[dot] != '.' {
+			dot++
+		}
+		if dot == len(data) {
+			return nil, 0, false
+		}
+		length, err := strconv.Atoi(string(data[pos:dot]))
+		if err != nil || length < 0 {
+			return nil, 0, false
+		}
+		start := dot + 1
+		end := start
+		for i := 0; i < length; i++ {
+			if end >= len(data) {
+				return nil, 0, false
+			}
+			_, size := utf8.DecodeRune(data[end:])
+			end += size
+		}
+		if end >= len(data) {
+			return nil, 0, false
+		}
+		elements = append(elements, string(data[start:end]))
+		switch data[end] {
+		case ';':
+			return elements, end + 1, true
+		case ',':
+			pos = end + 1
+		default:
+			return nil, 0, false
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// promptCredentialsKey makes RDP/VNC sessions ask for the password on every
+// connect instead of sending a stored one, e.g. on shared workstations
+const promptCredentialsKey = "desktop_prompt_credentials"
+
+// guacProtocolVersions are the Guacamole protocol versions guacd may offer as the
+// first "args" element. Echoing the offered version tells guacd this client
+// understands the "required" instruction, which guacd sends to ask for missing
+// credentials instead of failing the connection.
+var guacProtocolVersions = []string{"VERSION_1_1_0", "VERSION_1_3_0", "VERSION_1_5_0"}
+
+func promptCredentials(config map[string]string) bool {
+	prompt, _ := strconv.ParseBool(config[promptCredentialsKey])
+	return prompt
+}
+
+// credentialParams leaves the password out of the guacd parameters when the session
+// prompts for it, and declares the protocol versions that let guacd ask for it
+func credentialParams(params map[string]string, config map[string]string) {
+	for _, version := range guacProtocolVersions {
+		params[version] = version
+	}
+	if promptCredentials(config) {
+		delete(params, "password")
+	}
+}
+
+// credentialWatch reports guacd asking the client for credentials. guacd only asks
+// while connecting, so instructions are no longer inspected once the first frame
+// ("sync") arrived.
+type credentialWatch struct {
+	app       *application.App
+	sessionID string
+	done      bool
+}
+
+func (c *credentialWatch) observe(data []byte) {
+	if c.done {
+		return
+	}
+	filterGuacInstructions(data, func(elements []string) bool {
+		switch elements[0] {
+		case "required":
+			if c.app != nil {
+				c.app.Event.Emit("desktop:credentials", map[string]interface{}{
+					"sessionId":  c.sessionID,
+					"parameters": elements[1:],
+				})
+			}
+		case "sync":
+			c.done = true
+		}
+		return false
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// RDP drive redirection config keys
+const (
+	rdpDriveKey        = "rdp_drive"        // share a drive with the remote desktop
+	rdpDrivePathKey    = "rdp_drive_path"   // folder on the guacd host backing the drive
+	rdpDownloadDirKey  = "rdp_download_dir" // local folder receiving files from the drive's Download folder
+	defaultRDPDrive    = "/tmp/term-drive"
+	rdpDriveName       = "Term"
+	driveProgressEvery = 75 * time.Millisecond
+	guacStatusFailure  = "512" // SERVER_ERROR, reported for downloads that cannot be saved
+)
+
+// driveTransfers follows file streams through the tunnel of an RDP session with a
+// redirected drive. Files guacd sends (anything placed in the drive's Download
+// folder) are saved to the download folder here instead of reaching the browser;
+// files dropped onto the session are only observed. Both report progress with the
+// same events as SFTP transfers.
+type driveTransfers struct {
+	app       *application.App
+	progress  *UploadManager
+	sessionID string
+	dir       string
+	downloads map[string]*driveTransfer // guacd stream index -> file being saved
+	uploads   map[string]*driveTransfer // client stream index -> file being sent
+}
+
+type driveTransfer struct {
+	jobID       string
+	name        string
+	file        *os.File
+	transferred int64
+	lastEmit    time.Time
+}
+
+func newDriveTransfers(app *application.App, sessionID, dir string) *driveTransfers {
+	return &driveTransfers{
+		app:       app,
+		progress:  NewUploadManager(app),
+		sessionID: sessionID,
+		dir:       dir,
+		downloads: make(map[string]*driveTransfer),
+		uploads:   make(map[string]*driveTransfer),
+	}
+}
+
+// driveDownloadDir returns where downloads from the drive are saved
+func driveDownloadDir(config map[string]string) string {
+	home, err := os.UserHomeDir()
+	if dir := strings.TrimSpace(config[rdpDownloadDirKey]); dir != "" {
+		// Expand home directory if needed
+		if dir[0] == '~' && err == nil {
+			dir = home + dir[1:]
+		}
+		return dir
+	}
+	if err != nil {
+		return os.TempDir()
+	}
+	return filepath.Join(home, "Downloads")
+}
+
+// fromGuacd saves download streams and returns the data left for the client plus
+// the acknowledgements to send back to guacd
+func (d *driveTransfers) fromGuacd(data []byte) ([]byte, [][]byte) {
+	var replies [][]byte
+	data = filterGuacInstructions(data, func(elements []string) bool {
+		if len(elements) < 2 {
+			return false
+		}
+		stream := elements[1]
+		switch elements[0] {
+		case "file":
+			if len(elements) < 4 {
+				return false
+			}
+			if err := d.startDownload(stream, elements[3]); err != nil {
+				log.Printf("Cannot save download from session %s: %v", d.sessionID, err)
+				replies = append(replies, guacInstruction("ack", stream, err.Error(), guacStatusFailure))
+				return true
+			}
+			replies = append(replies, guacInstruction("ack", stream, "OK", "0"))
+			return true
+		case "blob":
+			t, ok := d.downloads[stream]
+			if !ok || len(elements) < 3 {
+				return false
+			}
+			chunk, err := base64.StdEncoding.DecodeString(elements[2])
+			if err == nil {
+				_, err = t.file.Write(chunk)
+			}
+			if err != nil {
+				d.finish(d.downloads, stream, err)
+				replies = append(replies, guacInstruction("ack", stream, err.Error(), guacStatusFailure))
+				return true
+			}
+			d.advance(t, int64(len(chunk)))
+			replies = append(replies, guacInstruction("ack", stream, "OK", "0"))
+			return true
+		case "end":
+			if _, ok := d.downloads[stream]; !ok {
+				return false
+			}
+			d.finish(d.downloads, stream, nil)
+			return true
+		}
+		return false
+	})
+	return data, replies
+}
+
+// fromClient reports progress of files the user drops onto the session; the data
+// itself goes to guacd unchanged
+func (d *driveTransfers) fromClient(data []byte) {
+	filterGuacInstructions(data, func(elements []string) bool {
+		if len(elements) < 2 {
+			return false
+		}
+		stream := elements[1]
+		switch elements[0] {
+		case "file":
+			if len(elements) >= 4 {
+				t := &driveTransfer{jobID: d.newJobID(), name: elements[3]}
+				d.uploads[stream] = t
+				d.emit("rdp:drive:upload", t, "")
+				d.progress.Publish(t.jobID, UploadProgress{})
+			}
+		case "blob":
+			if t, ok := d.uploads[stream]; ok && len(elements) >= 3 {
+				d.advance(t, int64(base64.StdEncoding.DecodedLen(len(elements[2]))))
+			}
+		case "end":
+			if _, ok := d.uploads[stream]; ok {
+				d.finish(d.uploads, stream, nil)
+			}
+		}
+		return false
+	})
+}
+
+// close ends transfers cut off by the tunnel closing
+func (d *driveTransfers) close() {
+	for stream := range d.downloads {
+		d.finish(d.downloads, stream, fmt.Errorf("connection closed"))
+	}
+	for stream := range d.uploads {
+		d.finish(d.uploads, stream, fmt.Errorf("connection closed"))
+	}
+}
+
+func (d *driveTransfers) startDownload(stream, name string) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." || name == "" {
+		name = "download"
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	path := filepath.Join(d.dir, name)
+	var file *os.File
+	var err error
+	for i := 2; ; i++ {
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+		path = filepath.Join(d.dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+	if err != nil {
+		return err
+	}
+	t := &driveTransfer{jobID: d.newJobID(), name: filepath.Base(path), file: file}
+	d.downloads[stream] = t
+	d.emit("rdp:drive:download", t, path)
+	d.progress.Publish(t.jobID, UploadProgress{})
+	return nil
+}
+
+func (d *driveTransfers) advance(t *driveTransfer, n int64) {
+	t.transferred += n
+	if now := time.Now(); now.Sub(t.lastEmit) > driveProgressEvery {
+		d.progress.Publish(t.jobID, UploadProgress{Transferred: t.transferred})
+		t.lastEmit = now
+	}
+}
+
+func (d *driveTransfers) finish(streams map[string]*driveTransfer, stream string, err error) {
+	t := streams[stream]
+	delete(streams, stream)
+	ev := UploadProgress{Total: t.transferred, Transferred: t.transferred, Done: true}
+	if t.file != nil {
+		if closeErr := t.file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(t.file.Name())
+		}
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	d.progress.Publish(t.jobID, ev)
+}
+
+func (d *driveTransfers) newJobID() string {
+	return "rdp-" + d.sessionID + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// emit announces a transfer so the UI can follow its progress events
+func (d *driveTransfers) emit(event string, t *driveTransfer, path string) {
+	d.app.Event.Emit(event, map[string]interface{}{
+		"sessionId": d.sessionID,
+		"jobId":     t.jobID,
+		"name":      t.name,
+		"path":      path,
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wwt/guac"
+)
+
+// Tunnel keepalive and reconnection
+const (
+	guacPingInterval = 15 * time.Second
+	guacPongWait     = 2 * guacPingInterval // a client silent this long is gone
+	guacWriteWait    = 10 * time.Second
+	guacResumeGrace  = 30 * time.Second // how long a dropped connection waits for its client
+
+	guacStatusUpstreamUnavailable = 520 // UPSTREAM_UNAVAILABLE, makes the client reconnect
+)
+
+// parkedTunnel keeps the guacd connection of a client that dropped alive, so the
+// client can resume it instead of starting a new desktop session. guacd only ends a
+// connection when its owner leaves, so the owner stays attached (answering guacd's
+// frame syncs) while resumed clients join the connection as new users and get the
+// full display state.
+type parkedTunnel struct {
+	sessionID string
+	resumers  int         // clients attached through resume
+	idle      *time.Timer // ends the parking when nobody resumed in time
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (p *parkedTunnel) end() {
+	p.closeOnce.Do(func() { close(p.done) })
+}
+
+// announceTunnel tells the client the guacd connection ID through the tunnel's
+// internal UUID instruction; the frontend resumes the connection by it
+func announceTunnel(connectionID string) []byte {
+	return guacInstruction("", connectionID)
+}
+
+// park holds the owner's guacd connection after its client went away until the
+// grace period passes without a resume, the last resumed client leaves for longer
+// than that, guacd closes the connection or ctx ends with the HTTP server
+func (g *GuacamoleService) park(ctx context.Context, connectionID, sessionID string, stream *guac.Stream) {
+	p := &parkedTunnel{sessionID: sessionID, done: make(chan struct{})}
+	p.idle = time.AfterFunc(guacResumeGrace, p.end)
+	defer context.AfterFunc(ctx, p.end)()
+	g.mu.Lock()
+	g.parked[connectionID] = p
+	g.mu.Unlock()
+	g.emitTunnel(sessionID, connectionID, "interrupted")
+	log.Printf("Guacamole client of session %s dropped; keeping the connection for %s", sessionID, guacResumeGrace)
+
+	go func() {
+		for {
+			data, err := stream.ReadSome()
+			if err != nil {
+				p.end()
+				return
+			}
+			// Acknowledge frames so guacd keeps the owner attached
+			filterGuacInstructions(data, func(elements []string) bool {
+				if elements[0] == "sync" && len(elements) > 1 {
+					stream.Write(guacInstruction("sync", elements[1]))
+					stream.Flush()
+				}
+				return false
+			})
+		}
+	}()
+
+	<-p.done
+	p.idle.Stop()
+	g.mu.Lock()
+	delete(g.parked, connectionID)
+	g.mu.Unlock()
+}
+
+// resumeParked attaches a client to a parked connection; false when there is none
+func (g *GuacamoleService) resumeParked(connectionID string) bool {
+	g.mu.Lock()
+	p, ok := g.parked[connectionID]
+	if ok {
+		p.resumers++
+		p.idle.Stop()
+	}
+	g.mu.Unlock()
+	if ok {
+		g.emitTunnel(p.sessionID, connectionID, "resumed")
+	}
+	return ok
+}
+
+// releaseParked detaches a resumed client; the grace period starts over when it
+// was the last one
+func (g *GuacamoleService) releaseParked(connectionID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if p, ok := g.parked[connectionID]; ok {
+		if p.resumers--; p.resumers == 0 {
+			p.idle.Reset(guacResumeGrace)
+		}
+	}
+}
+
+// emitTunnel reports tunnel state changes as guac:tunnel: "connected",
+// "interrupted" (the client dropped and may resume), "resumed" and "closed"
+func (g *GuacamoleService) emitTunnel(sessionID, connectionID, state string) {
+	if app := g.sessionService.app; app != nil {
+		app.Event.Emit("guac:tunnel", map[string]interface{}{
+			"sessionId":    sessionID,
+			"connectionId": connectionID,
+			"state":        state,
+		})
+	}
+}
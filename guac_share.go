@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"term/database"
+
+	"github.com/gorilla/websocket"
+	"github.com/wwt/guac"
+)
+
+const (
+	// sharingKey lets other clients join a session's open guacd connections
+	sharingKey = "desktop_sharing"
+	// sharingControlKey lets them control the desktop rather than only watch it
+	sharingControlKey = "desktop_sharing_control"
+)
+
+// SharedConnection is an open guacd connection other clients may join at JoinPath
+// on the HTTP server. JoinPath carries Token, a random secret of this share that
+// acts as the invitation; whether viewers get control is set on the session.
+type SharedConnection struct {
+	ConnectionID string    `json:"connectionId"`
+	SessionID    string    `json:"sessionId"`
+	SessionName  string    `json:"sessionName"`
+	Protocol     string    `json:"protocol"`
+	JoinPath     string    `json:"joinPath"`
+	Token        string    `json:"token"`
+	Control      bool      `json:"control"`
+	Viewers      int       `json:"viewers"`
+	Since        time.Time `json:"since"`
+
+	guacdAddr         string
+	toLocal, toRemote bool // clipboard directions of the session
+	shareable         bool // desktop_sharing is set; other connections are only resumed
+}
+
+func sharingEnabled(config map[string]string) bool {
+	enabled, _ := strconv.ParseBool(config[sharingKey])
+	return enabled
+}
+
+func sharingControl(config map[string]string) bool {
+	control, _ := strconv.ParseBool(config[sharingControlKey])
+	return control
+}
+
+// ListSharedConnections returns the open connections other clients can join
+func (g *GuacamoleService) ListSharedConnections() []SharedConnection {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	shared := make([]SharedConnection, 0, len(g.shared))
+	for _, c := range g.shared {
+		if c.shareable {
+			shared = append(shared, *c)
+		}
+	}
+	return shared
+}
+
+// registerConnection records the connection of a tunnel until unregisterConnection,
+// making it joinable when the session allows sharing and resumable after a drop
+func (g *GuacamoleService) registerConnection(connectionID string, session *database.SessionNode, sessionType, guacdAddr string, config map[string]string) {
+	toLocal, toRemote := clipboardAllowed(config)
+	c := &SharedConnection{
+		ConnectionID: connectionID,
+		SessionID:    session.ID,
+		SessionName:  session.Name,
+		Protocol:     sessionType,
+		Since:        time.Now(),
+		guacdAddr:    guacdAddr,
+		toLocal:      toLocal,
+		toRemote:     toRemote,
+	}
+	shareable := sharingEnabled(config)
+	if shareable {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			log.Printf("Failed to generate share token, connection of session %s is not shared: %v", session.ID, err)
+			shareable = false
+		} else {
+			c.Token = hex.EncodeToString(b)
+			c.JoinPath = apiPrefix + "/guacamole/join/" + connectionID + "?token=" + c.Token
+			c.Control = sharingControl(config)
+		}
+	}
+	c.shareable = shareable
+	g.mu.Lock()
+	g.shared[connectionID] = c
+	g.mu.Unlock()
+	if shareable {
+		g.emitShared(connectionID, session.ID, "shared", 0)
+	}
+}
+
+// unregisterConnection is called when the owner's tunnel closes; guacd ends the
+// connection for everyone who joined it
+func (g *GuacamoleService) unregisterConnection(connectionID string) {
+	g.mu.Lock()
+	c, ok := g.shared[connectionID]
+	delete(g.shared, connectionID)
+	g.mu.Unlock()
+	if ok && c.shareable {
+		g.emitShared(connectionID, c.SessionID, "closed", 0)
+	}
+}
+
+// viewerJoined adjusts the viewer count of a shared connection
+func (g *GuacamoleService) viewerJoined(connectionID string, delta int) {
+	g.mu.Lock()
+	c, ok := g.shared[connectionID]
+	viewers := 0
+	if ok {
+		c.Viewers += delta
+		viewers = c.Viewers
+	}
+	g.mu.Unlock()
+	if ok && c.shareable {
+		g.emitShared(connectionID, c.SessionID, "viewers", viewers)
+	}
+}
+
+// handleJoin attaches a WebSocket client to a shared connection. Viewers need the
+// share's token and are read-only unless the session allows control; the session's
+// clipboard directions apply to them as well. With resume the client, already
+// authenticated with the API token, takes back a connection it dropped, shared or not.
+func (g *GuacamoleService) handleJoin(w http.ResponseWriter, r *http.Request, connectionID string, resume bool) {
+	wsConn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade WebSocket: %v", err)
+		return
+	}
+	defer wsConn.Close()
+	defer closeOnShutdown(r, wsConn)()
+
+	g.mu.RLock()
+	shared, ok := g.shared[connectionID]
+	var c SharedConnection
+	if ok {
+		c = *shared
+	}
+	g.mu.RUnlock()
+	if ok && resume {
+		if ok = g.resumeParked(connectionID); ok {
+			defer g.releaseParked(connectionID)
+		}
+	}
+	// A wrong token gets the same answer as a connection that does not exist
+	invited := c.shareable && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(c.Token)) == 1
+	if !ok || (!resume && !invited) {
+		wsConn.WriteMessage(websocket.TextMessage, guacError("This connection is not shared or has ended", 404))
+		return
+	}
+	control := resume || c.Control
+
+	conn, err := net.DialTimeout("tcp", c.guacdAddr, guacdDialTimeout)
+	if err != nil {
+		log.Printf("Failed to connect to guacd on %s: %v", c.guacdAddr, err)
+		wsConn.WriteMessage(websocket.TextMessage, guacError(fmt.Sprintf("guacd is not reachable on %s", c.guacdAddr), 503))
+		return
+	}
+	defer conn.Close()
+
+	client := requestedClientInfo(r)
+	guacConfig := guac.NewGuacamoleConfiguration()
+	guacConfig.ConnectionID = connectionID
+	guacConfig.Protocol = c.Protocol
+	guacConfig.Parameters = map[string]string{}
+	credentialParams(guacConfig.Parameters, nil)
+	if !control {
+		guacConfig.Parameters["read-only"] = "true"
+	}
+	if client.width > 0 && client.height > 0 {
+		guacConfig.OptimalScreenWidth, guacConfig.OptimalScreenHeight = client.width, client.height
+	}
+	if client.dpi > 0 {
+		guacConfig.OptimalResolution = client.dpi
+	}
+	guacConfig.AudioMimetypes = client.audio
+
+	stream := guac.NewStream(conn, guac.SocketTimeout)
+	if err := stream.Handshake(guacConfig); err != nil {
+		log.Printf("Failed to join guacd connection %s: %v", connectionID, err)
+		wsConn.WriteMessage(websocket.TextMessage, guacError("Failed to join the connection: "+err.Error(), 500))
+		return
+	}
+
+	wsConn.WriteMessage(websocket.TextMessage, announceTunnel(connectionID))
+
+	if resume {
+		log.Printf("Client resumed the connection of session %s", c.SessionID)
+	} else {
+		mode := "view"
+		if control {
+			mode = "control"
+		}
+		log.Printf("Viewer joined shared connection of session %s (%s)", c.SessionID, mode)
+		g.viewerJoined(connectionID, 1)
+		defer g.viewerJoined(connectionID, -1)
+	}
+
+	var toGuacd, fromGuacd *clipboardFilter
+	if !c.toRemote || !control {
+		toGuacd = newClipboardFilter()
+	}
+	if !c.toLocal {
+		fromGuacd = newClipboardFilter()
+	}
+	g.relay(wsConn, stream, tunnelHooks{
+		toGuacd: func(data []byte) []byte {
+			if toGuacd != nil {
+				data = toGuacd.filter(data)
+			}
+			return data
+		},
+		fromGuacd: func(data []byte) ([]byte, [][]byte) {
+			if fromGuacd != nil {
+				data = fromGuacd.filter(data)
+			}
+			return data, nil
+		},
+	})
+	log.Printf("Viewer left shared connection of session %s", c.SessionID)
+}
+
+// emitShared announces changes to a shared connection as guac:shared
+func (g *GuacamoleService) emitShared(connectionID, sessionID, state string, viewers int) {
+	if app := g.sessionService.app; app != nil {
+		app.Event.Emit("guac:shared", map[string]interface{}{
+			"connectionId": connectionID,
+			"sessionId":    sessionID,
+			"state":        state,
+			"viewers":      viewers,
+		})
+	}
+}
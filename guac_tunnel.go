@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wwt/guac"
+)
+
+// tunnelHooks inspect and rewrite the instructions relayed through a tunnel; nil
+// hooks pass data through unchanged
+type tunnelHooks struct {
+	toGuacd   func(data []byte) []byte             // returns what to forward to guacd
+	fromGuacd func(data []byte) ([]byte, [][]byte) // returns what to forward to the client, plus replies for guacd
+}
+
+// closeOnShutdown closes the WebSocket as "going away" when the HTTP server shuts
+// down, which is the only time the request context of a hijacked connection ends.
+// The returned stop releases it once the handler is done.
+func closeOnShutdown(r *http.Request, wsConn *websocket.Conn) (stop func() bool) {
+	return context.AfterFunc(r.Context(), func() {
+		wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(guacWriteWait))
+		wsConn.Close()
+	})
+}
+
+// relay copies instructions between the WebSocket client and guacd until either
+// side closes. The client is pinged to notice dead links, and the tunnel's own
+// pings are answered here instead of reaching guacd. Returns true when the client
+// went away without closing the tunnel, e.g. on a network hiccup.
+func (g *GuacamoleService) relay(wsConn *websocket.Conn, stream *guac.Stream, hooks tunnelHooks) (clientLost bool) {
+	// Both directions write to guacd (replies come from the reader)
+	var writeMu sync.Mutex
+	writeGuacd := func(data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if _, err := stream.Write(data); err != nil {
+			return err
+		}
+		stream.Flush()
+		return nil
+	}
+
+	// Pings and ping replies write to the client besides the reader of guacd
+	var wsMu sync.Mutex
+	writeClient := func(data []byte) error {
+		wsMu.Lock()
+		defer wsMu.Unlock()
+		wsConn.SetWriteDeadline(time.Now().Add(guacWriteWait))
+		return wsConn.WriteMessage(websocket.TextMessage, data)
+	}
+	wsConn.SetReadDeadline(time.Now().Add(guacPongWait))
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(guacPongWait))
+	})
+
+	// Create channels for bidirectional communication
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var closeOnce sync.Once
+	wg.Add(3)
+
+	// Helper to safely close the done channel once
+	closeDone := func() {
+		closeOnce.Do(func() {
+			close(done)
+		})
+	}
+
+	// WebSocket -> Guacd
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_, message, err := wsConn.ReadMessage()
+				if err != nil {
+					if err != io.EOF && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+						log.Printf("WebSocket read error: %v", err)
+						select {
+						case <-done:
+						default:
+							clientLost = true
+						}
+					}
+					closeDone()
+					return
+				}
+				wsConn.SetReadDeadline(time.Now().Add(guacPongWait))
+
+				var pings [][]byte
+				if message, pings = tunnelPings(message); len(pings) > 0 {
+					for _, ping := range pings {
+						if err := writeClient(ping); err != nil {
+							closeDone()
+							return
+						}
+					}
+					if len(message) == 0 {
+						continue
+					}
+				}
+				if hooks.toGuacd != nil {
+					if message = hooks.toGuacd(message); len(message) == 0 {
+						continue
+					}
+				}
+
+				// Write to guacd stream
+				if err := writeGuacd(message); err != nil {
+					log.Printf("Failed to write to guacd: %v", err)
+					closeDone()
+					return
+				}
+			}
+		}
+	}()
+
+	// Guacd -> WebSocket
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				// Read instruction from guacd
+				data, err := stream.ReadSome()
+				if err != nil {
+					if err != io.EOF {
+						log.Printf("Failed to read from guacd: %v", err)
+						select {
+						case <-done:
+						default:
+							writeClient(guacError("Lost the connection to guacd", guacStatusUpstreamUnavailable))
+						}
+					}
+					closeDone()
+					return
+				}
+
+				if hooks.fromGuacd != nil {
+					var replies [][]byte
+					data, replies = hooks.fromGuacd(data)
+					for _, reply := range replies {
+						if err := writeGuacd(reply); err != nil {
+							log.Printf("Failed to write to guacd: %v", err)
+							closeDone()
+							return
+						}
+					}
+				}
+
+				if len(data) > 0 {
+					// Write to WebSocket
+					err = writeClient(data)
+					if err != nil {
+						log.Printf("WebSocket write error: %v", err)
+						closeDone()
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	// Keepalive: a client that stops answering pings hits the read deadline
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(guacPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := wsConn.WriteControl(websocket.PingMessage, nil, time.Now().Add(guacWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// Wait for all goroutines to finish
+	wg.Wait()
+	return clientLost
+}
+
+// tunnelPings removes the tunnel's internal instructions (empty opcode) from client
+// data and returns the replies to its pings, which echo them like the Guacamole web
+// application does
+func tunnelPings(data []byte) ([]byte, [][]byte) {
+	var replies [][]byte
+	data = filterGuacInstructions(data, func(elements []string) bool {
+		if elements[0] != "" {
+			return false
+		}
+		if len(elements) > 1 && elements[1] == "ping" {
+			replies = append(replies, guacInstruction("", elements[1:]...))
+		}
+		return true
+	})
+	return data, replies
+}
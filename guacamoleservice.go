@@ -2,32 +2,36 @@ package main
 
 import (
 	"fmt"
-	"io"
-	"log"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
-	"time"
+
+	"term/logging"
 
 	"github.com/gorilla/websocket"
 	"github.com/wwt/guac"
 )
 
-const (
-	guacdHost = "localhost"
-	guacdPort = "4822"
-)
+var guacLog = logging.For("guacamole")
 
 type GuacamoleService struct {
 	sessionService *SessionService
 	upgrader       websocket.Upgrader
 	mu             sync.RWMutex
+	listeners      map[string]*VNCListener      // reverse VNC tunnels by session ID
+	shared         map[string]*SharedConnection // open connections by guacd connection ID
+	parked         map[string]*parkedTunnel     // connections waiting for their client to resume
 }
 
 // NewGuacamoleService creates a new Guacamole service
 func NewGuacamoleService(sessionService *SessionService) *GuacamoleService {
 	return &GuacamoleService{
 		sessionService: sessionService,
+		listeners:      make(map[string]*VNCListener),
+		shared:         make(map[string]*SharedConnection),
+		parked:         make(map[string]*parkedTunnel),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  8192,
 			WriteBufferSize: 8192,
@@ -40,20 +44,21 @@ func NewGuacamoleService(sessionService *SessionService) *GuacamoleService {
 	}
 }
 
-// HandleWebSocket handles WebSocket connections for Guacamole tunnels
-func (g *GuacamoleService) HandleWebSocket(w http.ResponseWriter, r *http.Request, sessionID string) {
+// handleWebSocket handles WebSocket connections for Guacamole tunnels
+func (g *GuacamoleService) handleWebSocket(w http.ResponseWriter, r *http.Request, sessionID string) {
 	// Upgrade HTTP connection to WebSocket
 	wsConn, err := g.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Failed to upgrade WebSocket: %v", err)
+		guacLog.Error("failed to upgrade WebSocket", "err", err)
 		return
 	}
 	defer wsConn.Close()
+	defer closeOnShutdown(r, wsConn)()
 
 	// Get session configuration
 	session, err := g.sessionService.GetSession(sessionID)
 	if err != nil {
-		log.Printf("Failed to get session %s: %v", sessionID, err)
+		guacLog.Error("failed to get session", "session", sessionID, "err", err)
 		wsConn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("4.error,15.Session not found,3.404;")))
 		return
 	}
@@ -61,13 +66,13 @@ func (g *GuacamoleService) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	// Get configuration
 	config, err := g.sessionService.GetEffectiveConfig(sessionID)
 	if err != nil {
-		log.Printf("Failed to get config for session %s: %v", sessionID, err)
+		guacLog.Error("failed to get session config", "session", sessionID, "err", err)
 		wsConn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("4.error,21.Configuration not found,3.404;")))
 		return
 	}
 
 	// Log received configuration for debugging
-	log.Printf("Retrieved config for session %s: %+v", sessionID, config)
+	guacLog.Debug("loaded session config", "session", sessionID, "keys", len(config))
 
 	// Dereference session type pointer
 	sessionType := ""
@@ -75,20 +80,59 @@ func (g *GuacamoleService) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 		sessionType = *session.SessionType
 	}
 
+	// Decrypt stored credentials for the handshake
+	config, err = g.sessionService.resolveSecrets(config)
+	if err != nil {
+		guacLog.Error("failed to decrypt credentials", "session", sessionID, "err", err)
+		wsConn.WriteMessage(websocket.TextMessage, []byte("4.error,29.Stored credentials are locked,3.403;"))
+		return
+	}
+
+	if errs := validateSessionConfig(sessionType, config, true); len(errs) > 0 {
+		verr := &ConfigValidationError{SessionType: sessionType, Errors: errs}
+		guacLog.Warn("refusing to connect", "session", sessionID, "err", verr)
+		wsConn.WriteMessage(websocket.TextMessage, guacError(errs[0].Message, 400))
+		return
+	}
+
+	if err := autoWake(g.sessionService.app, sessionID, sessionType, config); err != nil {
+		guacLog.Warn("Wake-on-LAN failed", "session", sessionID, "err", err)
+		wsConn.WriteMessage(websocket.TextMessage, guacError(err.Error(), 504))
+		return
+	}
+
 	// Build Guacamole configuration based on session type
-	guacConfig := g.buildGuacConfig(sessionType, config)
+	guacConfig := g.buildGuacConfig(sessionType, config, requestedClientInfo(r))
+
+	// A reverse VNC session keeps guacd listening until the remote machine connects;
+	// closing the WebSocket ends the tunnel and the listening with it
+	var listener *VNCListener
+	if sessionType == "vnc" && vncReverse(config) {
+		if listener, err = g.startListening(sessionID, config, func() { wsConn.Close() }); err != nil {
+			wsConn.WriteMessage(websocket.TextMessage, guacError(err.Error(), 409))
+			return
+		}
+		defer g.endListening(listener)
+	}
 
 	// Log configuration for debugging
-	log.Printf("Guacamole config for session %s: protocol=%s, params=%+v", sessionID, guacConfig.Protocol, guacConfig.Parameters)
+	guacLog.Debug("connecting", "session", sessionID, "protocol", guacConfig.Protocol, "params", len(guacConfig.Parameters))
 
 	// Connect to guacd via TCP
-	guacAddr := fmt.Sprintf("%s:%s", guacdHost, guacdPort)
-	conn, err := net.DialTimeout("tcp", guacAddr, 10*time.Second)
+	guacAddr := guacdAddress(g.sessionService.db, config)
+	conn, err := net.DialTimeout("tcp", guacAddr, guacdDialTimeout)
 	if err != nil {
-		log.Printf("Failed to connect to guacd: %v", err)
+		guacLog.Error("failed to connect to guacd", "addr", guacAddr, "err", err)
+		if app := g.sessionService.app; app != nil {
+			app.Event.Emit("guacd:unreachable", map[string]interface{}{
+				"sessionId": sessionID,
+				"address":   guacAddr,
+				"error":     err.Error(),
+			})
+		}
 		// Send user-friendly error message
-		errorMsg := fmt.Sprintf("4.error,94.guacd is not running. Please start guacd (Apache Guacamole proxy daemon) on %s:%s,3.503;", guacdHost, guacdPort)
-		wsConn.WriteMessage(websocket.TextMessage, []byte(errorMsg))
+		msg := fmt.Sprintf("guacd is not reachable on %s. Start guacd (Apache Guacamole proxy daemon) or change the guacd_host and guacd_port settings", guacAddr)
+		wsConn.WriteMessage(websocket.TextMessage, guacError(msg, 503))
 		return
 	}
 	defer conn.Close()
@@ -99,115 +143,172 @@ func (g *GuacamoleService) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	// Send handshake to guacd
 	err = stream.Handshake(&guacConfig)
 	if err != nil {
-		log.Printf("Failed to complete guacd handshake: %v", err)
+		guacLog.Error("failed to complete guacd handshake", "session", sessionID, "err", err)
 		wsConn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("4.error,19.Handshake failed: %s,3.500;", err.Error())))
 		return
 	}
 
-	log.Printf("Guacamole tunnel established for session %s (type: %s)", sessionID, sessionType)
-
-	// Create channels for bidirectional communication
-	done := make(chan struct{})
-	var wg sync.WaitGroup
-	var closeOnce sync.Once
-	wg.Add(2)
-
-	// Helper to safely close the done channel once
-	closeDone := func() {
-		closeOnce.Do(func() {
-			close(done)
-		})
-	}
-
-	// WebSocket -> Guacd
-	go func() {
-		defer wg.Done()
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				_, message, err := wsConn.ReadMessage()
-				if err != nil {
-					if err != io.EOF && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-						log.Printf("WebSocket read error: %v", err)
-					}
-					closeDone()
-					return
-				}
-
-				// Write to guacd stream
-				_, err = stream.Write(message)
-				if err != nil {
-					log.Printf("Failed to write to guacd: %v", err)
-					closeDone()
-					return
-				}
-				stream.Flush()
+	guacLog.Info("tunnel established", "session", sessionID, "type", sessionType)
+	recordSessionConnect(g.sessionService.app, g.sessionService.db, sessionID)
+
+	// Drop clipboard streams in the directions the session does not allow
+	toLocal, toRemote := clipboardAllowed(config)
+	var toGuacd, fromGuacd *clipboardFilter
+	if !toRemote {
+		toGuacd = newClipboardFilter()
+	}
+	if !toLocal {
+		fromGuacd = newClipboardFilter()
+	}
+
+	// Report guacd asking for credentials the session does not store
+	credentials := &credentialWatch{app: g.sessionService.app, sessionID: sessionID}
+
+	// Save files sent from a redirected RDP drive and report transfer progress
+	var drive *driveTransfers
+	if enabled, _ := strconv.ParseBool(config[rdpDriveKey]); enabled && sessionType == "rdp" && g.sessionService.app != nil {
+		drive = newDriveTransfers(g.sessionService.app, sessionID, driveDownloadDir(config))
+		defer drive.close()
+	}
+
+	// Let other clients join while this tunnel is open, when the session allows it,
+	// and the client resume it after a drop
+	g.registerConnection(stream.ConnectionID, session, sessionType, guacAddr, config)
+	defer g.unregisterConnection(stream.ConnectionID)
+	wsConn.WriteMessage(websocket.TextMessage, announceTunnel(stream.ConnectionID))
+	g.emitTunnel(sessionID, stream.ConnectionID, "connected")
+	defer g.emitTunnel(sessionID, stream.ConnectionID, "closed")
+
+	clientLost := g.relay(wsConn, stream, tunnelHooks{
+		toGuacd: func(data []byte) []byte {
+			if toGuacd != nil {
+				data = toGuacd.filter(data)
 			}
-		}
-	}()
-
-	// Guacd -> WebSocket
-	go func() {
-		defer wg.Done()
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				// Read instruction from guacd
-				data, err := stream.ReadSome()
-				if err != nil {
-					if err != io.EOF {
-						log.Printf("Failed to read from guacd: %v", err)
-					}
-					closeDone()
-					return
-				}
-
-				if len(data) > 0 {
-					// Write to WebSocket
-					err = wsConn.WriteMessage(websocket.TextMessage, data)
-					if err != nil {
-						log.Printf("WebSocket write error: %v", err)
-						closeDone()
-						return
-					}
-				}
+			if drive != nil && len(data) > 0 {
+				drive.fromClient(data)
 			}
-		}
-	}()
+			return data
+		},
+		fromGuacd: func(data []byte) ([]byte, [][]byte) {
+			credentials.observe(data)
+			if listener != nil && g.listening(listener) {
+				g.watchListener(listener, data)
+			}
+			if fromGuacd != nil {
+				data = fromGuacd.filter(data)
+			}
+			var acks [][]byte
+			if drive != nil {
+				data, acks = drive.fromGuacd(data)
+			}
+			return data, acks
+		},
+	})
+	// Nothing will resume the connection when the app is shutting down
+	if clientLost && r.Context().Err() == nil {
+		g.park(r.Context(), stream.ConnectionID, sessionID, stream)
+	}
+	guacLog.Info("tunnel closed", "session", sessionID)
+}
+
+// Audio formats assumed when the client does not list its own; guacamole-common-js
+// plays raw PCM in every browser
+var defaultGuacAudioTypes = []string{"audio/L8", "audio/L16"}
+
+// clientInfo describes the client's display area and supported audio formats, sent
+// as width, height, dpi and audio query parameters on the tunnel URL; zero values
+// mean the client did not say
+type clientInfo struct {
+	width, height, dpi int
+	audio              []string
+}
 
-	// Wait for both goroutines to finish
-	wg.Wait()
-	log.Printf("Guacamole tunnel closed for session %s", sessionID)
+// requestedClientInfo reads the client's display size and audio formats from the tunnel request
+func requestedClientInfo(r *http.Request) clientInfo {
+	q := r.URL.Query()
+	value := func(key string, min, max int) int {
+		n, err := strconv.Atoi(q.Get(key))
+		if err != nil || n < min || n > max {
+			return 0
+		}
+		return n
+	}
+	info := clientInfo{
+		width:  value("width", 320, 8192),
+		height: value("height", 200, 8192),
+		dpi:    value("dpi", 48, 384),
+	}
+	for _, mimetype := range q["audio"] {
+		if strings.HasPrefix(mimetype, "audio/") && len(info.audio) < 16 {
+			info.audio = append(info.audio, mimetype)
+		}
+	}
+	return info
 }
 
-// buildGuacConfig builds Guacamole configuration from session config
-func (g *GuacamoleService) buildGuacConfig(sessionType string, config map[string]string) guac.Config {
+// buildGuacConfig builds Guacamole configuration from session config. Unless
+// desktop_resize is "none", the desktop opens at the client's size and RDP follows
+// later size instructions from the client through the tunnel. RDP sound is played
+// through the tunnel in the formats the client supports. Sessions with
+// desktop_prompt_credentials connect without their password and guacd asks the
+// client for it.
+func (g *GuacamoleService) buildGuacConfig(sessionType string, config map[string]string, client clientInfo) guac.Config {
 	guacConfig := guac.NewGuacamoleConfiguration()
 
+	width := g.getOrDefault(config, "desktop_width", "1920")
+	height := g.getOrDefault(config, "desktop_height", "1080")
+	resize := g.getOrDefault(config, "desktop_resize", "display-update")
+	if resize != "none" && client.width > 0 && client.height > 0 {
+		width, height = strconv.Itoa(client.width), strconv.Itoa(client.height)
+	}
+	guacConfig.OptimalScreenWidth, _ = strconv.Atoi(width)
+	guacConfig.OptimalScreenHeight, _ = strconv.Atoi(height)
+	if client.dpi > 0 {
+		guacConfig.OptimalResolution = client.dpi
+	}
+
 	switch sessionType {
 	case "rdp":
 		guacConfig.Protocol = "rdp"
 		guacConfig.Parameters = map[string]string{
-			"hostname":                   config["rdp_host"],
-			"port":                       g.getOrDefault(config, "rdp_port", "3389"),
-			"username":                   config["rdp_username"],
-			"password":                   config["rdp_password"],
-			"domain":                     config["rdp_domain"],
-			"security":                   g.getOrDefault(config, "rdp_security", "any"),
-			"ignore-cert":                "true",
-			"width":                      g.getOrDefault(config, "desktop_width", "1920"),
-			"height":                     g.getOrDefault(config, "desktop_height", "1080"),
-			"color-depth":                g.getOrDefault(config, "desktop_color_depth", "16"),
-			"enable-wallpaper":           "false",
-			"enable-theming":             "false",
-			"enable-font-smoothing":      "false",
-			"enable-full-window-drag":    "false",
-			"enable-desktop-composition": "false",
-			"enable-menu-animations":     "false",
+			"hostname":    config["rdp_host"],
+			"port":        g.getOrDefault(config, "rdp_port", "3389"),
+			"username":    config["rdp_username"],
+			"password":    config["rdp_password"],
+			"domain":      config["rdp_domain"],
+			"security":    g.getOrDefault(config, "rdp_security", "any"),
+			"ignore-cert": g.boolParam(config, "rdp_ignore_cert", false),
+			"console":     g.boolParam(config, "rdp_console", false),
+			"width":       width,
+			"height":      height,
+			"color-depth": g.getOrDefault(config, "desktop_color_depth", "16"),
+		}
+		if fingerprints := strings.TrimSpace(config["rdp_cert_fingerprints"]); fingerprints != "" {
+			guacConfig.Parameters["cert-fingerprints"] = fingerprints
+		}
+		for _, flag := range rdpPerformanceFlags {
+			guacConfig.Parameters[flag.param] = g.boolParam(config, flag.key, false)
+		}
+		if resize != "none" {
+			guacConfig.Parameters["resize-method"] = resize
+		}
+		if audio, err := strconv.ParseBool(g.getOrDefault(config, "rdp_audio", "true")); err == nil && !audio {
+			guacConfig.Parameters["disable-audio"] = "true"
+		} else {
+			guacConfig.AudioMimetypes = client.audio
+			if len(guacConfig.AudioMimetypes) == 0 {
+				guacConfig.AudioMimetypes = defaultGuacAudioTypes
+			}
+		}
+		if input, _ := strconv.ParseBool(config["rdp_audio_input"]); input {
+			guacConfig.Parameters["enable-audio-input"] = "true"
+		}
+		if drive, _ := strconv.ParseBool(config[rdpDriveKey]); drive {
+			// Files put in the drive's Download folder are sent through the tunnel
+			guacConfig.Parameters["enable-drive"] = "true"
+			guacConfig.Parameters["drive-name"] = rdpDriveName
+			guacConfig.Parameters["drive-path"] = g.getOrDefault(config, rdpDrivePathKey, defaultRDPDrive)
+			guacConfig.Parameters["create-drive-path"] = "true"
 		}
 
 	case "vnc":
@@ -216,10 +317,11 @@ func (g *GuacamoleService) buildGuacConfig(sessionType string, config map[string
 			"hostname":    config["vnc_host"],
 			"port":        g.getOrDefault(config, "vnc_port", "5900"),
 			"password":    config["vnc_password"],
-			"width":       g.getOrDefault(config, "desktop_width", "1920"),
-			"height":      g.getOrDefault(config, "desktop_height", "1080"),
+			"width":       width,
+			"height":      height,
 			"color-depth": g.getOrDefault(config, "desktop_color_depth", "16"),
 		}
+		vncConnectionParams(guacConfig.Parameters, config)
 
 	case "telnet":
 		guacConfig.Protocol = "telnet"
@@ -231,12 +333,42 @@ func (g *GuacamoleService) buildGuacConfig(sessionType string, config map[string
 		}
 
 	default:
-		log.Printf("Unknown session type for Guacamole: %s", sessionType)
+		guacLog.Warn("unknown session type", "type", sessionType)
+	}
+
+	credentialParams(guacConfig.Parameters, config)
+
+	toLocal, toRemote := clipboardAllowed(config)
+	if !toLocal {
+		guacConfig.Parameters["disable-copy"] = "true"
+	}
+	if !toRemote {
+		guacConfig.Parameters["disable-paste"] = "true"
 	}
 
 	return *guacConfig
 }
 
+// rdpPerformanceFlags map config keys to the guacd parameters enabling visual
+// effects; all are off by default to save bandwidth
+var rdpPerformanceFlags = []struct{ key, param, label string }{
+	{"rdp_enable_wallpaper", "enable-wallpaper", "Show wallpaper"},
+	{"rdp_enable_theming", "enable-theming", "Enable theming"},
+	{"rdp_enable_font_smoothing", "enable-font-smoothing", "Enable font smoothing"},
+	{"rdp_enable_full_window_drag", "enable-full-window-drag", "Show window contents while dragging"},
+	{"rdp_enable_desktop_composition", "enable-desktop-composition", "Enable desktop composition"},
+	{"rdp_enable_menu_animations", "enable-menu-animations", "Enable menu animations"},
+}
+
+// boolParam returns a boolean config value as "true" or "false" for guacd
+func (g *GuacamoleService) boolParam(config map[string]string, key string, defaultValue bool) string {
+	value, err := strconv.ParseBool(config[key])
+	if err != nil {
+		value = defaultValue
+	}
+	return strconv.FormatBool(value)
+}
+
 // getOrDefault returns config value or default if not present
 func (g *GuacamoleService) getOrDefault(config map[string]string, key, defaultValue string) string {
 	if val, ok := config[key]; ok && val != "" {
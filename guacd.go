@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"term/database"
+)
+
+// guacd endpoint: the settings give the default and the same keys in a session or
+// folder config override it, e.g. for hosts only reachable through a remote guacd
+const (
+	guacdHostKey       = "guacd_host"
+	guacdPortKey       = "guacd_port"
+	defaultGuacdHost   = "localhost"
+	defaultGuacdPort   = 4822
+	guacdDialTimeout   = 10 * time.Second
+	guacdProbeDeadline = 5 * time.Second
+)
+
+// guacdFields apply to every session type displayed through guacd
+var guacdFields = []ConfigField{
+	{Key: guacdHostKey, Label: "guacd host", Type: fieldString},
+	{Key: guacdPortKey, Label: "guacd port", Type: fieldInt, Min: intPtr(1), Max: intPtr(65535)},
+	{Key: clipboardKey, Label: "Clipboard", Type: fieldEnum, Default: clipboardBoth,
+		Allowed: []string{clipboardBoth, clipboardRemoteToLocal, clipboardLocalToRemote, clipboardNone}},
+}
+
+// GuacdTestResult is the outcome of TestGuacdConnection
+type GuacdTestResult struct {
+	Address   string `json:"address"`
+	Reachable bool   `json:"reachable"` // guacd answered the protocol handshake
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// guacdAddress returns the guacd host:port for a session config, falling back to
+// the guacd_host / guacd_port settings and then localhost:4822
+func guacdAddress(db *database.DB, config map[string]string) string {
+	host := strings.TrimSpace(config[guacdHostKey])
+	port := strings.TrimSpace(config[guacdPortKey])
+	if host == "" {
+		if setting, err := db.GetSetting(guacdHostKey); err == nil && setting != nil {
+			host = strings.TrimSpace(setting.Value)
+		}
+	}
+	if port == "" {
+		if setting, err := db.GetSetting(guacdPortKey); err == nil && setting != nil {
+			port = strings.TrimSpace(setting.Value)
+		}
+	}
+	if host == "" {
+		host = defaultGuacdHost
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		port = strconv.Itoa(defaultGuacdPort)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// TestGuacdConnection checks that guacd is listening and speaks the Guacamole
+// protocol. With a session ID the session's own guacd override is tested;
+// an empty ID tests the global settings.
+func (s *SessionService) TestGuacdConnection(sessionID string) (*GuacdTestResult, error) {
+	config := map[string]string{}
+	if sessionID != "" {
+		var err error
+		if config, err = s.db.GetEffectiveConfig(sessionID); err != nil {
+			return nil, err
+		}
+	}
+	result := &GuacdTestResult{Address: guacdAddress(s.db, config)}
+	start := time.Now()
+	if err := probeGuacd(result.Address); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Reachable = true
+	result.LatencyMs = time.Since(start).Milliseconds()
+	return result, nil
+}
+
+// probeGuacd asks guacd for the arguments of a protocol, which it answers with an
+// "args" instruction without connecting anywhere
+func probeGuacd(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, guacdDialTimeout)
+	if err != nil {
+		return fmt.Errorf("guacd is not reachable on %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(guacdProbeDeadline))
+
+	if _, err := conn.Write([]byte("6.select,3.vnc;")); err != nil {
+		return fmt.Errorf("failed to talk to guacd on %s: %w", addr, err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString(';')
+	if err != nil {
+		return fmt.Errorf("no answer from guacd on %s: %w", addr, err)
+	}
+	if !strings.HasPrefix(reply, "4.args,") {
+		return fmt.Errorf("%s did not answer like guacd", addr)
+	}
+	return nil
+}
+
+// guacError encodes an error instruction for the Guacamole client
+func guacError(msg string, status int) []byte {
+	return guacInstruction("error", msg, strconv.Itoa(status))
+}
+
+// guacInstruction encodes a Guacamole protocol instruction; element lengths count
+// Unicode characters
+func guacInstruction(opcode string, args ...string) []byte {
+	var b strings.Builder
+	for i, element := range append([]string{opcode}, args...) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%d.%s", utf8.RuneCountInString(element), element)
+	}
+	b.WriteByte(';')
+	return []byte(b.String())
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// Settings controlling the managed guacd
+const (
+	settingGuacdMode        = "guacd_mode"         // "external" (default), "binary" or "docker"
+	settingGuacdBinary      = "guacd_binary_path"  // empty looks next to the executable, then in PATH
+	settingGuacdDockerImage = "guacd_docker_image" // default guacamole/guacd
+	defaultGuacdImage       = "guacamole/guacd"
+	guacdContainerName      = "term-guacd"
+	guacdHealthInterval     = 30 * time.Second
+	guacdHealthFailures     = 3 // failed probes in a row before guacd is restarted
+	guacdStartTimeout       = 30 * time.Second
+	guacdMaxBackoff         = 30 * time.Second
+	guacdStableAfter        = time.Minute // a run this long resets the restart backoff
+)
+
+// GuacdStatus describes the guacd the app connects to
+type GuacdStatus struct {
+	Mode      string    `json:"mode"`  // "external", "binary" or "docker"
+	State     string    `json:"state"` // "stopped", "starting", "running", "crashed", "external" or "failed"
+	Address   string    `json:"address"`
+	PID       int       `json:"pid,omitempty"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"lastError,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+// GuacdManager launches guacd from a bundled binary or a Docker container, checks
+// that it keeps answering and restarts it when it crashes, so RDP/VNC/telnet work
+// without running the daemon by hand. In "external" mode it only reports whether
+// the configured guacd is reachable.
+type GuacdManager struct {
+	app    *application.App
+	db     *database.DB
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	status GuacdStatus
+	cmd    *exec.Cmd
+	exited chan struct{} // closed when cmd exits
+}
+
+// NewGuacdManager creates the manager; Start launches guacd when a managed mode is set
+func NewGuacdManager(db *database.DB) *GuacdManager {
+	return &GuacdManager{db: db, status: GuacdStatus{State: "stopped", Since: time.Now()}}
+}
+
+// SetApp sets the Wails application instance
+func (m *GuacdManager) SetApp(app *application.App) {
+	m.app = app
+}
+
+// Start launches and supervises guacd in the background
+func (m *GuacdManager) Start() {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.supervise(ctx)
+	}()
+}
+
+// Stop stops supervising and shuts down a guacd the manager started
+func (m *GuacdManager) Stop() {
+	m.mu.Lock()
+	cancel := m.cancel
+	m.cancel = nil
+	m.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	m.wg.Wait()
+	m.setState("stopped", 0, "")
+}
+
+// GetGuacdStatus returns the current guacd status
+func (m *GuacdManager) GetGuacdStatus() GuacdStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// StartGuacd starts supervising guacd after StopGuacd
+func (m *GuacdManager) StartGuacd() {
+	m.Start()
+}
+
+// StopGuacd stops the managed guacd until StartGuacd or the next launch
+func (m *GuacdManager) StopGuacd() {
+	m.Stop()
+}
+
+// RestartGuacd restarts guacd, picking up changed guacd_* settings
+func (m *GuacdManager) RestartGuacd() {
+	m.Stop()
+	m.mu.Lock()
+	m.status.Restarts = 0
+	m.mu.Unlock()
+	m.Start()
+}
+
+func (m *GuacdManager) mode() string {
+	return settingValue(m.db, settingGuacdMode)
+}
+
+// supervise runs guacd and restarts it with a growing delay until ctx is cancelled
+func (m *GuacdManager) supervise(ctx context.Context) {
+	mode := m.mode()
+	addr := guacdAddress(m.db, nil)
+	m.mu.Lock()
+	m.status.Mode = mode
+	m.status.Address = addr
+	m.mu.Unlock()
+
+	if mode == "external" {
+		m.watchExternal(ctx, addr)
+		return
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	if !isLoopbackHost(host) {
+		m.setState("failed", 0, fmt.Sprintf("guacd_host %s is not this machine; set it to localhost to let the app run guacd", host))
+		return
+	}
+	if probeGuacd(addr) == nil {
+		// Something (e.g. a system service) already serves this port
+		m.watchExternal(ctx, addr)
+		return
+	}
+
+	backoff := time.Second
+	for {
+		cmd, err := m.command(mode, port)
+		if err != nil {
+			// Missing binary or docker: retrying will not help until settings change
+			m.setState("failed", 0, err.Error())
+			return
+		}
+		started := time.Now()
+		err = m.runOnce(ctx, mode, cmd, addr)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) >= guacdStableAfter {
+			backoff = time.Second
+		}
+		msg := "guacd exited"
+		if err != nil {
+			msg = err.Error()
+		}
+		log.Printf("guacd (%s) stopped: %s; restarting in %s", mode, msg, backoff)
+		m.mu.Lock()
+		m.status.Restarts++
+		m.mu.Unlock()
+		m.setState("crashed", 0, msg)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > guacdMaxBackoff {
+			backoff = guacdMaxBackoff
+		}
+	}
+}
+
+// runOnce launches guacd, waits until it answers and then health-checks it until
+// it exits, stops answering, or ctx is cancelled
+func (m *GuacdManager) runOnce(ctx context.Context, mode string, cmd *exec.Cmd, addr string) error {
+	setCmdNoWindow(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to launch guacd: %w", err)
+	}
+	exited := make(chan struct{})
+	var waitErr error
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+	m.mu.Lock()
+	m.cmd, m.exited = cmd, exited
+	m.mu.Unlock()
+	defer func() {
+		m.kill(mode)
+		m.mu.Lock()
+		m.cmd, m.exited = nil, nil
+		m.mu.Unlock()
+	}()
+	m.setState("starting", cmd.Process.Pid, "")
+
+	// Docker may have to pull the image first, so allow for a slow start
+	deadline := time.Now().Add(guacdStartTimeout)
+	if mode == "docker" {
+		deadline = deadline.Add(4 * guacdStartTimeout)
+	}
+	for probeGuacd(addr) != nil {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("guacd did not start answering on %s", addr)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-exited:
+			return exitError(waitErr)
+		case <-time.After(time.Second):
+		}
+	}
+	m.setState("running", cmd.Process.Pid, "")
+
+	failures := 0
+	ticker := time.NewTicker(guacdHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-exited:
+			return exitError(waitErr)
+		case <-ticker.C:
+			if err := probeGuacd(addr); err != nil {
+				if failures++; failures >= guacdHealthFailures {
+					return fmt.Errorf("guacd stopped answering: %w", err)
+				}
+				continue
+			}
+			failures = 0
+		}
+	}
+}
+
+func exitError(err error) error {
+	if err == nil {
+		return fmt.Errorf("guacd exited")
+	}
+	return fmt.Errorf("guacd exited: %w", err)
+}
+
+// command builds the guacd process for a mode, listening on 127.0.0.1:port
+func (m *GuacdManager) command(mode, port string) (*exec.Cmd, error) {
+	if mode == "docker" {
+		docker, err := exec.LookPath("docker")
+		if err != nil {
+			return nil, fmt.Errorf("docker is not installed or not in PATH")
+		}
+		// A container left over from a previous run would block the name
+		rm := exec.Command(docker, "rm", "-f", guacdContainerName)
+		setCmdNoWindow(rm)
+		rm.Run()
+		image := settingValue(m.db, settingGuacdDockerImage)
+		return exec.Command(docker, "run", "--rm", "--name", guacdContainerName,
+			"-p", "127.0.0.1:"+port+":4822", image), nil
+	}
+
+	binary := settingValue(m.db, settingGuacdBinary)
+	if binary == "" {
+		binary = findGuacdBinary()
+	}
+	if binary == "" {
+		return nil, fmt.Errorf("guacd binary not found; set guacd_binary_path or install guacd")
+	}
+	// -f keeps guacd in the foreground so the manager notices when it exits
+	return exec.Command(binary, "-f", "-b", "127.0.0.1", "-l", port), nil
+}
+
+// findGuacdBinary looks for a guacd bundled next to the executable, then in PATH
+func findGuacdBinary() string {
+	name := "guacd"
+	if runtime.GOOS == "windows" {
+		name = "guacd.exe"
+	}
+	if exe, err := os.Executable(); err == nil {
+		dir := filepath.Dir(exe)
+		for _, candidate := range []string{filepath.Join(dir, name), filepath.Join(dir, "guacd", "sbin", name)} {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+	return ""
+}
+
+// kill stops the running guacd process (and its container)
+func (m *GuacdManager) kill(mode string) {
+	m.mu.Lock()
+	cmd, exited := m.cmd, m.exited
+	m.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	if mode == "docker" {
+		if docker, err := exec.LookPath("docker"); err == nil {
+			stop := exec.Command(docker, "stop", "-t", "5", guacdContainerName)
+			setCmdNoWindow(stop)
+			stop.Run()
+		}
+	}
+	cmd.Process.Kill()
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+	}
+}
+
+// watchExternal reports the reachability of a guacd the manager does not run
+func (m *GuacdManager) watchExternal(ctx context.Context, addr string) {
+	for {
+		if err := probeGuacd(addr); err != nil {
+			m.setState("failed", 0, err.Error())
+		} else {
+			m.setState("external", 0, "")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(guacdHealthInterval):
+		}
+	}
+}
+
+// setState records the state and emits guacd:status when it changed
+func (m *GuacdManager) setState(state string, pid int, lastError string) {
+	m.mu.Lock()
+	changed := m.status.State != state || m.status.PID != pid || m.status.LastError != lastError
+	if m.status.State != state {
+		m.status.Since = time.Now()
+	}
+	m.status.State = state
+	m.status.PID = pid
+	if lastError != "" || state == "running" || state == "external" {
+		m.status.LastError = lastError
+	}
+	st := m.status
+	m.mu.Unlock()
+
+	if changed && m.app != nil {
+		m.app.Event.Emit("guacd:status", map[string]interface{}{
+			"mode":      st.Mode,
+			"state":     st.State,
+			"address":   st.Address,
+			"pid":       st.PID,
+			"restarts":  st.Restarts,
+			"lastError": st.LastError,
+		})
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HashiCorp Vault config keys of a folder or session, used with
+// credential_provider = vault
+const (
+	vaultPathKey     = "vault_path"      // API path of a KV secret, e.g. secret/data/prod/db1 for KV v2
+	vaultSSHModeKey  = "vault_ssh_mode"  // "none", "otp" or "certificate"
+	vaultSSHMountKey = "vault_ssh_mount" // mount of the SSH secrets engine, default ssh
+	vaultSSHRoleKey  = "vault_ssh_role"
+)
+
+const (
+	settingHashiCorpVault = "hashicorp_vault_config"
+	defaultVaultSSHMount  = "ssh"
+	defaultVaultAuthMount = "approle"
+	vaultHTTPTimeout      = 20 * time.Second
+	vaultRenewMargin      = time.Minute // tokens and certificates are replaced this long before they expire
+)
+
+var vaultFields = []ConfigField{
+	{Key: vaultPathKey, Label: "Vault secret path", Type: fieldString},
+	{Key: vaultSSHModeKey, Label: "Vault SSH credentials", Type: fieldEnum, Default: "none", Allowed: []string{"none", "otp", "certificate"}},
+	{Key: vaultSSHMountKey, Label: "Vault SSH engine mount", Type: fieldString, Default: defaultVaultSSHMount},
+	{Key: vaultSSHRoleKey, Label: "Vault SSH role", Type: fieldString, RequiredWhen: map[string][]string{vaultSSHModeKey: {"otp", "certificate"}}},
+}
+
+// HashiCorpVaultConfig is how the app reaches Vault. Empty fields fall back to
+// the Vault CLI's environment: VAULT_ADDR, VAULT_NAMESPACE, VAULT_TOKEN and
+// ~/.vault-token.
+type HashiCorpVaultConfig struct {
+	Address      string `json:"address"`      // e.g. https://vault.example.com:8200
+	Namespace    string `json:"namespace"`    // Vault Enterprise namespace
+	AuthMethod   string `json:"authMethod"`   // "token" (default) or "approle"
+	Token        string `json:"token"`        // token auth
+	RoleID       string `json:"roleId"`       // AppRole
+	SecretID     string `json:"secretId"`     // AppRole
+	AuthMount    string `json:"authMount"`    // AppRole mount (default approle)
+	CACert       string `json:"caCert"`       // PEM file of the CA of Vault's certificate
+	CacheSeconds int    `json:"cacheSeconds"` // how long KV secrets are reused, 0 fetches them on every connect
+}
+
+// hashicorpVault is the credential provider for HashiCorp Vault. Sessions name
+// a KV secret with vault_path, whose username, password, private_key and
+// passphrase fields become their credentials. SSH sessions can instead get a
+// one-time password (vault_ssh_mode = otp) or a short-lived certificate signed
+// for a key generated on the spot (certificate) from the SSH secrets engine.
+type hashicorpVault struct {
+	db      *database.DB
+	secrets *SecretStore
+
+	mu          sync.Mutex
+	token       string // from an AppRole login
+	tokenExpiry time.Time
+	cache       map[string]vaultCacheEntry
+}
+
+type vaultCacheEntry struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// vaultClient makes requests to Vault with one token
+type vaultClient struct {
+	address   string
+	namespace string
+	token     string
+	http      *http.Client
+}
+
+type vaultResponse struct {
+	Data          map[string]interface{} `json:"data"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func newHashiCorpVault(db *database.DB, secrets *SecretStore) *hashicorpVault {
+	return &hashicorpVault{db: db, secrets: secrets, cache: make(map[string]vaultCacheEntry)}
+}
+
+// Resolve fetches the credentials a session config asks for
+func (v *hashicorpVault) Resolve(ctx context.Context, cfg map[string]string) (map[string]string, error) {
+	cfgVault, err := v.config()
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	if path := strings.Trim(strings.TrimSpace(cfg[vaultPathKey]), "/"); path != "" {
+		secret, err := v.readSecret(ctx, cfgVault, path)
+		if err != nil {
+			return nil, err
+		}
+		for k, val := range secret {
+			values[k] = val
+		}
+	}
+
+	mode := strings.TrimSpace(cfg[vaultSSHModeKey])
+	if mode == "" || mode == "none" {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%s or %s is required", vaultPathKey, vaultSSHModeKey)
+		}
+		return values, nil
+	}
+	mount := strings.Trim(strings.TrimSpace(cfg[vaultSSHMountKey]), "/")
+	if mount == "" {
+		mount = defaultVaultSSHMount
+	}
+	role := strings.TrimSpace(cfg[vaultSSHRoleKey])
+	if role == "" {
+		return nil, fmt.Errorf("%s is required for %s", vaultSSHRoleKey, mode)
+	}
+	username := values["ssh_username"]
+	if username == "" {
+		username = strings.TrimSpace(cfg["ssh_username"])
+	}
+
+	var creds map[string]string
+	switch mode {
+	case "otp":
+		creds, err = v.sshOTP(ctx, cfgVault, mount, role, cfg["ssh_host"], username)
+	case "certificate":
+		if username == "" {
+			return nil, fmt.Errorf("ssh_username is required for a Vault-signed certificate")
+		}
+		creds, err = v.sshCertificate(ctx, cfgVault, mount, role, username)
+	default:
+		return nil, fmt.Errorf("unknown %s %q", vaultSSHModeKey, mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k, val := range creds {
+		values[k] = val
+	}
+	return values, nil
+}
+
+// Forget drops the cached secrets and login token
+func (v *hashicorpVault) Forget() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cache = make(map[string]vaultCacheEntry)
+	v.token, v.tokenExpiry = "", time.Time{}
+}
+
+// readSecret reads a KV secret, from the cache while cacheSeconds allow it
+func (v *hashicorpVault) readSecret(ctx context.Context, cfg HashiCorpVaultConfig, path string) (map[string]string, error) {
+	cacheKey := "kv|" + cfg.Address + "|" + cfg.Namespace + "|" + path
+	if values := v.cached(cacheKey); values != nil {
+		return values, nil
+	}
+	client, err := v.client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	data := resp.Data
+	// KV v2 wraps the secret with its metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s holds no secret", path)
+	}
+	values := vaultCredentialValues(data)
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s has none of the fields username, password, private_key or passphrase", path)
+	}
+
+	if cfg.CacheSeconds > 0 {
+		ttl := time.Duration(cfg.CacheSeconds) * time.Second
+		if lease := time.Duration(resp.LeaseDuration) * time.Second; lease > 0 && lease < ttl {
+			ttl = lease
+		}
+		v.store(cacheKey, values, time.Now().Add(ttl))
+	}
+	return values, nil
+}
+
+// vaultCredentialValues maps the fields of a KV secret onto config keys
+func vaultCredentialValues(data map[string]interface{}) map[string]string {
+	field := func(name string) string {
+		if s, ok := data[name].(string); ok {
+			return s
+		}
+		return ""
+	}
+	values := map[string]string{}
+	for _, t := range credentialTargets {
+		if user := field("username"); t.username != "" && user != "" {
+			values[t.username] = user
+		}
+		if password := field("password"); password != "" {
+			values[t.password] = password
+		}
+	}
+	if key := field("private_key"); key != "" {
+		values["ssh_auth_method"] = "key"
+		values[sshPrivateKeyKey] = key
+	}
+	if passphrase := field("passphrase"); passphrase != "" {
+		values["ssh_key_passphrase"] = passphrase
+	}
+	return values
+}
+
+// sshOTP asks the SSH secrets engine for a one-time password, which is never
+// cached as it works once
+func (v *hashicorpVault) sshOTP(ctx context.Context, cfg HashiCorpVaultConfig, mount, role, host, username string) (map[string]string, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return nil, fmt.Errorf("ssh_host is required for a Vault one-time password")
+	}
+	// OTP roles are bound to IP addresses
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	client, err := v.client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]string{"ip": addrs[0].IP.String()}
+	if username != "" {
+		body["username"] = username
+	}
+	resp, err := client.do(ctx, http.MethodPost, mount+"/creds/"+url.PathEscape(role), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a one-time password: %w", err)
+	}
+	otp, _ := resp.Data["key"].(string)
+	if otp == "" {
+		return nil, fmt.Errorf("role %s did not return a one-time password; is its key_type otp?", role)
+	}
+	values := map[string]string{"ssh_auth_method": "password", "ssh_password": otp}
+	if username == "" {
+		if user, _ := resp.Data["username"].(string); user != "" {
+			values["ssh_username"] = user
+		}
+	}
+	return values, nil
+}
+
+// sshCertificate has the SSH secrets engine sign a key pair generated for the
+// purpose. The key never touches the disk and is reused until the certificate
+// is about to expire.
+func (v *hashicorpVault) sshCertificate(ctx context.Context, cfg HashiCorpVaultConfig, mount, role, username string) (map[string]string, error) {
+	cacheKey := "cert|" + cfg.Address + "|" + cfg.Namespace + "|" + mount + "|" + role + "|" + username
+	if values := v.cached(cacheKey); values != nil {
+		return values, nil
+	}
+	client, err := v.client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "term vault certificate")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.do(ctx, http.MethodPost, mount+"/sign/"+url.PathEscape(role), map[string]string{
+		"public_key":       string(ssh.MarshalAuthorizedKey(sshPub)),
+		"valid_principals": username,
+		"cert_type":        "user",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign an SSH certificate: %w", err)
+	}
+	signed, _ := resp.Data["signed_key"].(string)
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signed))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate from Vault: %w", err)
+	}
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("Vault did not return a certificate")
+	}
+
+	values := map[string]string{
+		"ssh_auth_method":    "key",
+		sshPrivateKeyKey:     string(pem.EncodeToMemory(block)),
+		sshCertificateKey:    signed,
+		"ssh_key_passphrase": "",
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		v.store(cacheKey, values, time.Unix(int64(cert.ValidBefore), 0).Add(-vaultRenewMargin))
+	}
+	return values, nil
+}
+
+func (v *hashicorpVault) cached(key string) map[string]string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.cache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(v.cache, key)
+		return nil
+	}
+	values := make(map[string]string, len(entry.values))
+	for k, val := range entry.values {
+		values[k] = val
+	}
+	return values
+}
+
+func (v *hashicorpVault) store(key string, values map[string]string, expires time.Time) {
+	if !time.Now().Before(expires) {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cache[key] = vaultCacheEntry{values: values, expires: expires}
+}
+
+// config loads the Vault connection settings with their secrets decrypted
+func (v *hashicorpVault) config() (HashiCorpVaultConfig, error) {
+	cfg, err := loadHashiCorpVaultConfig(v.db)
+	if err != nil {
+		return cfg, err
+	}
+	for _, value := range []*string{&cfg.Token, &cfg.SecretID} {
+		if *value, err = v.secrets.Decrypt(*value); err != nil {
+			return cfg, fmt.Errorf("failed to decrypt the Vault credentials: %w", err)
+		}
+	}
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	cfg.Address = strings.TrimRight(strings.TrimSpace(cfg.Address), "/")
+	if cfg.Address == "" {
+		return cfg, fmt.Errorf("no Vault address configured")
+	}
+	return cfg, nil
+}
+
+// client returns a client authenticated as configured, logging in with AppRole
+// when its token is missing or about to expire
+func (v *hashicorpVault) client(ctx context.Context, cfg HashiCorpVaultConfig) (*vaultClient, error) {
+	httpClient, err := vaultHTTPClient(cfg.CACert)
+	if err != nil {
+		return nil, err
+	}
+	c := &vaultClient{address: cfg.Address, namespace: cfg.Namespace, http: httpClient}
+
+	switch cfg.AuthMethod {
+	case "", "token":
+		c.token = cfg.Token
+		if c.token == "" {
+			c.token = os.Getenv("VAULT_TOKEN")
+		}
+		if c.token == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				if data, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+					c.token = strings.TrimSpace(string(data))
+				}
+			}
+		}
+		if c.token == "" {
+			return nil, fmt.Errorf("no Vault token configured")
+		}
+	case "approle":
+		v.mu.Lock()
+		token, expiry := v.token, v.tokenExpiry
+		v.mu.Unlock()
+		if token == "" || time.Now().After(expiry) {
+			if token, expiry, err = c.loginAppRole(ctx, cfg); err != nil {
+				return nil, err
+			}
+			v.mu.Lock()
+			v.token, v.tokenExpiry = token, expiry
+			v.mu.Unlock()
+		}
+		c.token = token
+	default:
+		return nil, fmt.Errorf("unknown Vault auth method %q", cfg.AuthMethod)
+	}
+	return c, nil
+}
+
+func (c *vaultClient) loginAppRole(ctx context.Context, cfg HashiCorpVaultConfig) (string, time.Time, error) {
+	if cfg.RoleID == "" {
+		return "", time.Time{}, fmt.Errorf("AppRole role ID is required")
+	}
+	mount := strings.Trim(cfg.AuthMount, "/")
+	if mount == "" {
+		mount = defaultVaultAuthMount
+	}
+	resp, err := c.do(ctx, http.MethodPost, "auth/"+mount+"/login", map[string]string{
+		"role_id":   cfg.RoleID,
+		"secret_id": cfg.SecretID,
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("AppRole login failed: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", time.Time{}, fmt.Errorf("AppRole login returned no token")
+	}
+	expiry := time.Now().Add(24 * time.Hour)
+	if resp.Auth.LeaseDuration > 0 {
+		expiry = time.Now().Add(time.Duration(resp.Auth.LeaseDuration)*time.Second - vaultRenewMargin)
+	}
+	return resp.Auth.ClientToken, expiry, nil
+}
+
+// do sends a request to the Vault API; path is relative to /v1/
+func (c *vaultClient) do(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.address+"/v1/"+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var resp vaultResponse
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &resp); err != nil && res.StatusCode < 300 {
+			return nil, fmt.Errorf("invalid response from Vault: %w", err)
+		}
+	}
+	if res.StatusCode == http.StatusNotFound && len(resp.Errors) == 0 {
+		return nil, fmt.Errorf("nothing found at %s", path)
+	}
+	if res.StatusCode >= 300 {
+		if len(resp.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %s", res.Status, strings.Join(resp.Errors, "; "))
+		}
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	return &resp, nil
+}
+
+// vaultHTTPClient trusts the system CAs, plus caCert when given
+func vaultHTTPClient(caCert string) (*http.Client, error) {
+	client := &http.Client{Timeout: vaultHTTPTimeout}
+	if caCert == "" {
+		return client, nil
+	}
+	data, err := os.ReadFile(caCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Vault CA certificate: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", caCert)
+	}
+	client.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+	}
+	return client, nil
+}
+
+func loadHashiCorpVaultConfig(db *database.DB) (HashiCorpVaultConfig, error) {
+	var cfg HashiCorpVaultConfig
+	setting, err := db.GetSetting(settingHashiCorpVault)
+	if err != nil || setting.Value == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(setting.Value), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid Vault configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// resealHashiCorpVaultConfig returns the stored Vault configuration with its
+// credentials passed through reseal, or "" when there is nothing to re-encrypt
+func resealHashiCorpVaultConfig(db *database.DB, reseal func(string) (string, error)) (string, error) {
+	cfg, err := loadHashiCorpVaultConfig(db)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Token == "" && cfg.SecretID == "" {
+		return "", nil
+	}
+	for _, value := range []*string{&cfg.Token, &cfg.SecretID} {
+		if *value == "" {
+			continue
+		}
+		if *value, err = reseal(*value); err != nil {
+			return "", err
+		}
+	}
+	data, err := json.Marshal(cfg)
+	return string(data), err
+}
+
+// GetVaultConfig returns how the app reaches HashiCorp Vault, with the token and
+// secret ID masked
+func (s *CredentialProviderService) GetVaultConfig() (HashiCorpVaultConfig, error) {
+	cfg, err := loadHashiCorpVaultConfig(s.db)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Token = maskCredentialSecret(cfg.Token)
+	cfg.SecretID = maskCredentialSecret(cfg.SecretID)
+	return cfg, nil
+}
+
+// SetVaultConfig stores how the app reaches HashiCorp Vault; masked credentials
+// keep their stored value
+func (s *CredentialProviderService) SetVaultConfig(cfg HashiCorpVaultConfig) error {
+	switch cfg.AuthMethod {
+	case "", "token", "approle":
+	default:
+		return fmt.Errorf("unknown Vault auth method %q", cfg.AuthMethod)
+	}
+	if cfg.CacheSeconds < 0 {
+		return fmt.Errorf("cache duration cannot be negative")
+	}
+	cfg.Address = strings.TrimSpace(cfg.Address)
+	if cfg.Address != "" {
+		if u, err := url.Parse(cfg.Address); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid Vault address %q", cfg.Address)
+		}
+	}
+
+	stored, _ := loadHashiCorpVaultConfig(s.db)
+	var err error
+	if cfg.Token, err = s.sealSecret(cfg.Token, stored.Token); err != nil {
+		return fmt.Errorf("failed to encrypt the Vault token: %w", err)
+	}
+	if cfg.SecretID, err = s.sealSecret(cfg.SecretID, stored.SecretID); err != nil {
+		return fmt.Errorf("failed to encrypt the AppRole secret ID: %w", err)
+	}
+	if err := s.db.SetSettingJSON(settingHashiCorpVault, cfg); err != nil {
+		return err
+	}
+	s.vault.Forget()
+	return nil
+}
+
+// TestVaultConfig checks that Vault can be reached and accepts the configured
+// credentials, returning the policies of its token
+func (s *CredentialProviderService) TestVaultConfig() ([]string, error) {
+	cfg, err := s.vault.config()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vaultHTTPTimeout)
+	defer cancel()
+	client, err := s.vault.client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.do(ctx, http.MethodGet, "auth/token/lookup-self", nil)
+	if err != nil {
+		return nil, err
+	}
+	policies := []string{}
+	if list, ok := resp.Data["policies"].([]interface{}); ok {
+		for _, p := range list {
+			if name, ok := p.(string); ok {
+				policies = append(policies, name)
+			}
+		}
+	}
+	return policies, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+const (
+	settingHealthCheckInterval = "health_check_interval_seconds" // 0 disables background checks
+	defaultHealthCheckInterval = 60
+	healthCheckKey             = "health_check" // config key; "false" opts a session or folder out
+	healthCheckTimeout         = 3 * time.Second
+	healthCheckWorkers         = 16
+)
+
+// healthCheckTypes are the session types that have a host:port to probe
+var healthCheckTypes = []string{"ssh", "rdp", "vnc", "telnet"}
+
+// HealthStatus is the reachability of one session's host
+type HealthStatus struct {
+	SessionID string    `json:"sessionId"`
+	Host      string    `json:"host"`
+	Port      int       `json:"port"`
+	Status    string    `json:"status"` // "up" or "down"
+	LatencyMs int64     `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Since     time.Time `json:"since"` // when the status last changed
+}
+
+// HealthCheckService periodically opens a TCP connection to the host:port of every
+// remote session so the tree can show which servers are reachable
+type HealthCheckService struct {
+	app    *application.App
+	db     *database.DB
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+
+	mu       sync.Mutex
+	statuses map[string]HealthStatus
+}
+
+// NewHealthCheckService creates a new health check service
+func NewHealthCheckService(db *database.DB) *HealthCheckService {
+	return &HealthCheckService{
+		db:       db,
+		wake:     make(chan struct{}, 1),
+		statuses: make(map[string]HealthStatus),
+	}
+}
+
+// SetApp sets the Wails application instance
+func (h *HealthCheckService) SetApp(app *application.App) {
+	h.app = app
+}
+
+// Start begins checking sessions in the background
+func (h *HealthCheckService) Start() {
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	go h.loop()
+}
+
+// Stop stops the background checks
+func (h *HealthCheckService) Stop() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+}
+
+// GetHealthStatuses returns the latest result for every checked session
+func (h *HealthCheckService) GetHealthStatuses() []HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]HealthStatus, 0, len(h.statuses))
+	for _, st := range h.statuses {
+		result = append(result, st)
+	}
+	return result
+}
+
+// CheckNow probes every session immediately instead of waiting for the next interval
+func (h *HealthCheckService) CheckNow() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// CheckSession probes one session and returns its status
+func (h *HealthCheckService) CheckSession(sessionID string) (*HealthStatus, error) {
+	node, err := h.db.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	target, ok, err := h.target(node)
+	if err != nil || !ok {
+		return nil, err
+	}
+	st := h.probe(context.Background(), target)
+	return &st, nil
+}
+
+func (h *HealthCheckService) interval() time.Duration {
+	return time.Duration(settingInt(h.db, settingHealthCheckInterval)) * time.Second
+}
+
+func (h *HealthCheckService) loop() {
+	for {
+		interval := h.interval()
+		if interval > 0 && !h.db.Locked() {
+			h.checkAll()
+		}
+		if interval <= 0 {
+			// Disabled; look at the setting again later
+			interval = time.Minute
+		}
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-h.wake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// healthTarget is a session's resolved host:port
+type healthTarget struct {
+	sessionID string
+	host      string
+	port      int
+}
+
+// target returns the endpoint to probe, or false when the session is not checked
+func (h *HealthCheckService) target(node *database.SessionNode) (healthTarget, bool, error) {
+	if node.Type != "session" || node.SessionType == nil || node.IsTemplate {
+		return healthTarget{}, false, nil
+	}
+	sessionType := *node.SessionType
+	checked := false
+	for _, t := range healthCheckTypes {
+		if t == sessionType {
+			checked = true
+		}
+	}
+	if !checked {
+		return healthTarget{}, false, nil
+	}
+	cfg, err := h.db.GetEffectiveConfig(node.ID)
+	if err != nil {
+		return healthTarget{}, false, err
+	}
+	if enabled, err := strconv.ParseBool(cfg[healthCheckKey]); err == nil && !enabled {
+		return healthTarget{}, false, nil
+	}
+	// A session behind a proxy may not be reachable directly, and a direct
+	// probe would also show the host's address to networks the proxy avoids
+	if sessionType == "ssh" && sshProxied(cfg) {
+		return healthTarget{}, false, nil
+	}
+	host, port, ok := sessionEndpoint(sessionType, cfg)
+	if !ok {
+		return healthTarget{}, false, nil
+	}
+	return healthTarget{sessionID: node.ID, host: host, port: port}, true, nil
+}
+
+// sessionEndpoint returns the host and port a remote session connects to, using the
+// schema's default port when none is configured. Reverse VNC sessions have none:
+// the remote machine connects to guacd instead.
+func sessionEndpoint(sessionType string, cfg map[string]string) (string, int, bool) {
+	if sessionType == "vnc" && vncReverse(cfg) {
+		return "", 0, false
+	}
+	host := strings.TrimSpace(cfg[sessionType+"_host"])
+	if host == "" || strings.Contains(host, "${") {
+		return "", 0, false
+	}
+	portValue := cfg[sessionType+"_port"]
+	if portValue == "" {
+		for _, f := range sessionConfigSchemas[sessionType] {
+			if f.Key == sessionType+"_port" {
+				portValue = f.Default
+			}
+		}
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(portValue))
+	if err != nil || port <= 0 {
+		return "", 0, false
+	}
+	return host, port, true
+}
+
+// checkAll probes every checked session with a bounded number of workers
+func (h *HealthCheckService) checkAll() {
+	nodes, err := h.db.GetAllSessions()
+	if err != nil {
+		return
+	}
+	var targets []healthTarget
+	live := make(map[string]bool)
+	for i := range nodes {
+		target, ok, err := h.target(&nodes[i])
+		if err != nil || !ok {
+			continue
+		}
+		targets = append(targets, target)
+		live[target.sessionID] = true
+	}
+
+	// Forget sessions that were deleted or opted out
+	h.mu.Lock()
+	for id := range h.statuses {
+		if !live[id] {
+			delete(h.statuses, id)
+		}
+	}
+	h.mu.Unlock()
+
+	jobs := make(chan healthTarget)
+	var wg sync.WaitGroup
+	for i := 0; i < healthCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				h.probe(h.ctx, target)
+			}
+		}()
+	}
+	for _, target := range targets {
+		select {
+		case jobs <- target:
+		case <-h.ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// probe connects to the target, records the result and emits health:status when it changed
+func (h *HealthCheckService) probe(ctx context.Context, target healthTarget) HealthStatus {
+	addr := net.JoinHostPort(target.host, strconv.Itoa(target.port))
+	dialer := net.Dialer{Timeout: healthCheckTimeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	st := HealthStatus{
+		SessionID: target.sessionID,
+		Host:      target.host,
+		Port:      target.port,
+		Status:    "up",
+		LatencyMs: time.Since(start).Milliseconds(),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		st.Status = "down"
+		st.LatencyMs = 0
+		st.Error = err.Error()
+	} else {
+		conn.Close()
+	}
+
+	h.mu.Lock()
+	prev, seen := h.statuses[target.sessionID]
+	st.Since = st.CheckedAt
+	if seen && prev.Status == st.Status {
+		st.Since = prev.Since
+	}
+	h.statuses[target.sessionID] = st
+	h.mu.Unlock()
+
+	if h.app != nil && (!seen || prev.Status != st.Status) {
+		h.app.Event.Emit("health:status", map[string]interface{}{
+			"sessionId": st.SessionID,
+			"status":    st.Status,
+			"host":      st.Host,
+			"port":      st.Port,
+			"latencyMs": st.LatencyMs,
+			"error":     st.Error,
+			"since":     st.Since.Format(time.RFC3339),
+		})
+	}
+	return st
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"term/database"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Randomart field dimensions, as in OpenSSH's sshkey_fingerprint_randomart
+const (
+	randomartBase = 8
+	randomartRows = randomartBase + 1
+	randomartCols = randomartBase*2 + 1
+)
+
+const randomartSymbols = " .o+=*BOX@%&#/^SE"
+
+// keyRandomart renders the OpenSSH "drunken bishop" visualisation of the key's SHA256 digest
+func keyRandomart(key ssh.PublicKey) string {
+	digest := sha256.Sum256(key.Marshal())
+
+	var field [randomartCols][randomartRows]int
+	top := len(randomartSymbols) - 1
+	x, y := randomartCols/2, randomartRows/2
+	for _, b := range digest {
+		input := b
+		for i := 0; i < 4; i++ {
+			if input&0x1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if input&0x2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = max(0, min(x, randomartCols-1))
+			y = max(0, min(y, randomartRows-1))
+			if field[x][y] < top-2 {
+				field[x][y]++
+			}
+			input >>= 2
+		}
+	}
+	field[randomartCols/2][randomartRows/2] = top - 1
+	field[x][y] = top
+
+	title := "[" + keyTypeLabel(key.Type())
+	if bits := keyBits(key); bits > 0 {
+		title += " " + strconv.Itoa(bits)
+	}
+	title += "]"
+
+	var sb strings.Builder
+	sb.WriteString(randomartBorder(title))
+	for row := 0; row < randomartRows; row++ {
+		sb.WriteByte('|')
+		for col := 0; col < randomartCols; col++ {
+			sb.WriteByte(randomartSymbols[min(field[col][row], top)])
+		}
+		sb.WriteString("|\n")
+	}
+	sb.WriteString(strings.TrimSuffix(randomartBorder("[SHA256]"), "\n"))
+	return sb.String()
+}
+
+func randomartBorder(label string) string {
+	if len(label) > randomartCols {
+		label = label[:randomartCols]
+	}
+	left := (randomartCols - len(label)) / 2
+	right := randomartCols - len(label) - left
+	return "+" + strings.Repeat("-", left) + label + strings.Repeat("-", right) + "+\n"
+}
+
+// keyTypeLabel maps an SSH key algorithm to the short label OpenSSH prints
+func keyTypeLabel(keyType string) string {
+	cert := strings.Contains(keyType, "-cert-")
+	var label string
+	switch {
+	case strings.HasPrefix(keyType, "sk-ssh-ed25519"):
+		label = "ED25519-SK"
+	case strings.HasPrefix(keyType, "sk-ecdsa"):
+		label = "ECDSA-SK"
+	case strings.HasPrefix(keyType, "ssh-ed25519"):
+		label = "ED25519"
+	case strings.HasPrefix(keyType, "ecdsa-"):
+		label = "ECDSA"
+	case strings.HasPrefix(keyType, "ssh-rsa"), strings.HasPrefix(keyType, "rsa-"):
+		label = "RSA"
+	case strings.HasPrefix(keyType, "ssh-dss"):
+		label = "DSA"
+	default:
+		label = strings.ToUpper(keyType)
+	}
+	if cert {
+		label += "-CERT"
+	}
+	return label
+}
+
+// keyBits reports the key size in bits, or 0 when it cannot be determined
+func keyBits(key ssh.PublicKey) int {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	if strings.HasPrefix(key.Type(), "sk-ssh-ed25519") {
+		return 256
+	}
+	cpk, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch k := cpk.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
+
+// sessionsForHost lists the SSH sessions whose effective config points at host:port
+func (h *HostKeyService) sessionsForHost(host string, port int) []map[string]interface{} {
+	items := make([]map[string]interface{}, 0)
+	sessions, err := h.db.GetAllSessions()
+	if err != nil {
+		return items
+	}
+	for _, s := range sessions {
+		if s.Type != "session" || s.SessionType == nil || *s.SessionType != "ssh" {
+			continue
+		}
+		cfg, err := h.db.GetEffectiveConfig(s.ID)
+		if err != nil || !strings.EqualFold(cfg["ssh_host"], host) {
+			continue
+		}
+		p, err := strconv.Atoi(cfg["ssh_port"])
+		if err != nil || p <= 0 {
+			p = 22
+		}
+		if p != port {
+			continue
+		}
+		items = append(items, map[string]interface{}{
+			"id":   s.ID,
+			"name": s.Name,
+		})
+	}
+	return items
+}
+
+// currentActor identifies the local user taking an interactive decision
+func currentActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// auditHostKey records a host key decision; failures are logged, never fatal
+func (h *HostKeyService) auditHostKey(host string, port int, keyType, fingerprint, oldFingerprint, status, decision, actor string) {
+	err := h.db.AddHostKeyAudit(&database.HostKeyAuditEntry{
+		Host:           host,
+		Port:           port,
+		KeyType:        keyType,
+		Fingerprint:    fingerprint,
+		OldFingerprint: oldFingerprint,
+		Status:         status,
+		Decision:       decision,
+		Actor:          actor,
+	})
+	if err != nil {
+		log.Printf("Failed to record host key audit for %s:%d: %v", host, port, err)
+	}
+}
+
+func (h *HostKeyService) emitHostKeyAudit(host string, port int, limit int) {
+	entries, err := h.db.ListHostKeyAudit(host, port, limit)
+	if err != nil {
+		h.app.Event.Emit("ssh:known_hosts:error", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if entries == nil {
+		entries = []database.HostKeyAuditEntry{}
+	}
+	h.app.Event.Emit("ssh:hostkey_audit:list", map[string]interface{}{
+		"items": entries,
+	})
+}
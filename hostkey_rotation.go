@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"term/database"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSSH host key rotation extension (PROTOCOL, section 2.5)
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// NewSSHClient runs the SSH handshake over an established connection, additionally
+// learning the host keys a server advertises after authentication so planned key
+// rotations are accepted later
+func (h *HostKeyService) NewSSHClient(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	// Remember the key this connection was verified with
+	var mu sync.Mutex
+	var presented ssh.PublicKey
+	cfg := *config
+	verify := config.HostKeyCallback
+	cfg.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := verify(hostname, remote, key); err != nil {
+			return err
+		}
+		mu.Lock()
+		presented = key
+		mu.Unlock()
+		return nil
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Intercept the host key advertisement, pass every other global request through
+	forwarded := make(chan *ssh.Request)
+	go func() {
+		defer close(forwarded)
+		for req := range reqs {
+			if req.Type != hostKeysRequest {
+				forwarded <- req
+				continue
+			}
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+			mu.Lock()
+			key := presented
+			mu.Unlock()
+			go h.handleHostKeysAdvert(c, addr, key, req.Payload)
+		}
+	}()
+
+	return ssh.NewClient(c, chans, forwarded), nil
+}
+
+// handleHostKeysAdvert proves the advertised keys and records them as alternates
+// of a host whose current key is already trusted
+func (h *HostKeyService) handleHostKeysAdvert(c ssh.Conn, addr string, presented ssh.PublicKey, payload []byte) {
+	if presented == nil {
+		return
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	// Only learn keys for hosts permanently trusted with the key in use
+	known, err := h.db.GetKnownHost(host, port)
+	if err != nil || known == nil || known.Fingerprint != ssh.FingerprintSHA256(presented) {
+		return
+	}
+
+	blobs, err := parseSSHStrings(payload)
+	if err != nil {
+		log.Printf("Ignoring malformed %s from %s: %v", hostKeysRequest, addr, err)
+		return
+	}
+	var candidates []ssh.PublicKey
+	for _, blob := range blobs {
+		key, err := ssh.ParsePublicKey(blob)
+		if err != nil {
+			// Unsupported key types are skipped, as OpenSSH does
+			continue
+		}
+		if ssh.FingerprintSHA256(key) == known.Fingerprint {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+
+	proven, err := proveHostKeys(c, candidates)
+	if err != nil {
+		log.Printf("Host key proof from %s failed: %v", addr, err)
+		return
+	}
+
+	alts := make([]database.KnownHost, 0, len(proven))
+	for _, key := range proven {
+		alts = append(alts, database.KnownHost{
+			Host:        host,
+			Port:        port,
+			KeyType:     key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			PublicKey:   key.Marshal(),
+		})
+	}
+	added, removed, err := h.db.ReplaceKnownHostAltKeys(host, port, alts)
+	if err != nil {
+		log.Printf("Failed to record host keys for %s: %v", addr, err)
+		return
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	h.app.Event.Emit("ssh:hostkeys:updated", map[string]interface{}{
+		"host":    host,
+		"port":    port,
+		"added":   added,
+		"removed": removed,
+	})
+}
+
+// proveHostKeys asks the server to sign the session identifier with each key and
+// returns the keys whose signatures verify
+func proveHostKeys(c ssh.Conn, keys []ssh.PublicKey) ([]ssh.PublicKey, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	var payload []byte
+	for _, key := range keys {
+		payload = appendSSHString(payload, key.Marshal())
+	}
+	ok, reply, err := c.SendRequest(hostKeysProveRequest, true, payload)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("server refused to prove host keys")
+	}
+
+	sigs, err := parseSSHStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+	if len(sigs) != len(keys) {
+		return nil, errors.New("host key proof has wrong number of signatures")
+	}
+
+	var proven []ssh.PublicKey
+	for i, key := range keys {
+		var sig ssh.Signature
+		if err := ssh.Unmarshal(sigs[i], &sig); err != nil {
+			continue
+		}
+		var data []byte
+		data = appendSSHString(data, []byte(hostKeysProveRequest))
+		data = appendSSHString(data, c.SessionID())
+		data = appendSSHString(data, key.Marshal())
+		if err := key.Verify(data, &sig); err != nil {
+			continue
+		}
+		proven = append(proven, key)
+	}
+	return proven, nil
+}
+
+// promoteAltHostKey accepts a previously proven alternate key as the host's new key
+func (h *HostKeyService) promoteAltHostKey(host string, port int, key ssh.PublicKey, oldFingerprint string) bool {
+	fingerprint := ssh.FingerprintSHA256(key)
+	alt, err := h.db.GetKnownHostAltKey(host, port, fingerprint)
+	if err != nil || alt == nil || alt.KeyType != key.Type() {
+		return false
+	}
+	if err := h.trustHostKey(host, port, key.Type(), fingerprint, key.Marshal()); err != nil {
+		return false
+	}
+	h.auditHostKey(host, port, key.Type(), fingerprint, oldFingerprint, "rotation", "trust", "rotation")
+	h.app.Event.Emit("ssh:hostkeys:updated", map[string]interface{}{
+		"host":           host,
+		"port":           port,
+		"rotated":        true,
+		"fingerprint":    fingerprint,
+		"oldFingerprint": oldFingerprint,
+	})
+	return true
+}
+
+func appendSSHString(buf, s []byte) []byte {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(s)))
+	return append(append(buf, l[:]...), s...)
+}
+
+func parseSSHStrings(data []byte) ([][]byte, error) {
+	var out [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("truncated string length")
+		}
+		n := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(n) > uint64(len(data)) {
+			return nil, errors.New("truncated string")
+		}
+		out = append(out, data[:n])
+		data = data[n:]
+	}
+	return out, nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	keyScanMaxHosts    = 1024
+	keyScanConcurrency = 16
+	keyScanTimeout     = 5 * time.Second
+	keyScanReviewTTL   = 30 * time.Minute // results not committed by then are dropped
+)
+
+// errKeyCaptured aborts the handshake once the host key has been collected
+var errKeyCaptured = errors.New("host key captured")
+
+type keyScanTarget struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type keyScanResult struct {
+	Host            string `json:"host"`
+	Port            int    `json:"port"`
+	KeyType         string `json:"keyType,omitempty"`
+	Fingerprint     string `json:"fingerprint,omitempty"`
+	PublicKeyBase64 string `json:"publicKeyBase64,omitempty"`
+	OldFingerprint  string `json:"oldFingerprint,omitempty"`
+	Status          string `json:"status"` // "new", "match", "mismatch", "error"
+	Error           string `json:"error,omitempty"`
+}
+
+func (h *HostKeyService) setupKeyScan() {
+	// Start a scan: {targets:["host[:port]"...], cidr:"10.0.0.0/24", folderId:"...", port:22}
+	h.app.Event.On("ssh:keyscan:start", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		if data == nil {
+			return
+		}
+		targets, err := h.keyScanTargets(data)
+		if err != nil {
+			h.app.Event.Emit("ssh:keyscan:error", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		scanID := fmt.Sprintf("scan-%d", time.Now().UnixNano())
+		go h.runKeyScan(scanID, targets)
+	})
+
+	// Commit reviewed results: {scanId, accept:[{host,port}...]}
+	h.app.Event.On("ssh:keyscan:commit", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		if data == nil {
+			return
+		}
+		scanID, _ := data["scanId"].(string)
+		accepted, _ := data["accept"].([]interface{})
+		saved, err := h.commitKeyScan(scanID, accepted)
+		if err != nil {
+			h.app.Event.Emit("ssh:keyscan:error", map[string]interface{}{
+				"scanId": scanID,
+				"error":  err.Error(),
+			})
+			return
+		}
+		h.app.Event.Emit("ssh:keyscan:committed", map[string]interface{}{
+			"scanId": scanID,
+			"saved":  saved,
+		})
+		h.emitKnownHostsList()
+	})
+}
+
+// keyScanTargets collects scan targets from explicit hosts, a CIDR range, and/or a session folder
+func (h *HostKeyService) keyScanTargets(data map[string]interface{}) ([]keyScanTarget, error) {
+	defaultPort := 22
+	if p := toInt(data["port"]); p > 0 {
+		defaultPort = p
+	}
+
+	seen := make(map[string]bool)
+	var targets []keyScanTarget
+	add := func(host string, port int) {
+		key := net.JoinHostPort(host, strconv.Itoa(port))
+		if host == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		targets = append(targets, keyScanTarget{Host: host, Port: port})
+	}
+
+	if list, ok := data["targets"].([]interface{}); ok {
+		for _, item := range list {
+			s, _ := item.(string)
+			s = strings.TrimSpace(s)
+			if host, p, err := net.SplitHostPort(s); err == nil {
+				port, _ := strconv.Atoi(p)
+				if port <= 0 {
+					port = defaultPort
+				}
+				add(host, port)
+			} else {
+				add(s, defaultPort)
+			}
+		}
+	}
+
+	if cidr, _ := data["cidr"].(string); cidr != "" {
+		hosts, err := expandCIDR(cidr, keyScanMaxHosts)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			add(host, defaultPort)
+		}
+	}
+
+	if folderID, _ := data["folderId"].(string); folderID != "" {
+		sessions, err := h.db.GetAllSessions()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load sessions: %w", err)
+		}
+		parents := make(map[string]string, len(sessions))
+		for _, s := range sessions {
+			if s.ParentID != nil {
+				parents[s.ID] = *s.ParentID
+			}
+		}
+		for _, s := range sessions {
+			if s.Type != "session" || s.SessionType == nil || *s.SessionType != "ssh" {
+				continue
+			}
+			if !isDescendantOf(s.ID, folderID, parents) {
+				continue
+			}
+			cfg, err := h.db.GetEffectiveConfig(s.ID)
+			if err != nil {
+				continue
+			}
+			port, err := strconv.Atoi(cfg["ssh_port"])
+			if err != nil || port <= 0 {
+				port = 22
+			}
+			add(cfg["ssh_host"], port)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no hosts to scan")
+	}
+	if len(targets) > keyScanMaxHosts {
+		return nil, fmt.Errorf("too many hosts to scan (%d, max %d)", len(targets), keyScanMaxHosts)
+	}
+	return targets, nil
+}
+
+// isDescendantOf reports whether id is ancestorID itself or lies below it
+func isDescendantOf(id, ancestorID string, parents map[string]string) bool {
+	for depth := 0; id != "" && depth < 1000; depth++ {
+		if id == ancestorID {
+			return true
+		}
+		id = parents[id]
+	}
+	return false
+}
+
+// expandCIDR lists the usable host addresses of an IPv4 or IPv6 range
+func expandCIDR(cidr string, limit int) ([]string, error) {
+	ip, ipnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 20 {
+		return nil, fmt.Errorf("CIDR %s is too large to scan", cidr)
+	}
+
+	var hosts []string
+	for cur := ip.Mask(ipnet.Mask); ipnet.Contains(cur); cur = nextIP(cur) {
+		hosts = append(hosts, cur.String())
+		if len(hosts) > limit+2 {
+			return nil, fmt.Errorf("CIDR %s exceeds %d hosts", cidr, limit)
+		}
+	}
+	// Drop network and broadcast addresses for ordinary IPv4 subnets
+	if ip.To4() != nil && bits-ones >= 2 && len(hosts) > 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// runKeyScan fetches host keys concurrently and emits the results for review
+func (h *HostKeyService) runKeyScan(scanID string, targets []keyScanTarget) {
+	results := make([]keyScanResult, len(targets))
+	sem := make(chan struct{}, keyScanConcurrency)
+	var wg sync.WaitGroup
+	var doneMu sync.Mutex
+	done := 0
+
+	h.app.Event.Emit("ssh:keyscan:started", map[string]interface{}{
+		"scanId": scanID,
+		"total":  len(targets),
+	})
+
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, target keyScanTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = h.scanHostKey(target)
+
+			doneMu.Lock()
+			done++
+			current := done
+			doneMu.Unlock()
+			h.app.Event.Emit("ssh:keyscan:progress", map[string]interface{}{
+				"scanId": scanID,
+				"done":   current,
+				"total":  len(targets),
+			})
+		}(i, target)
+	}
+	wg.Wait()
+
+	h.mu.Lock()
+	h.scans[scanID] = results
+	h.mu.Unlock()
+	// A review that is dismissed never commits, so its results expire
+	time.AfterFunc(keyScanReviewTTL, func() {
+		h.mu.Lock()
+		delete(h.scans, scanID)
+		h.mu.Unlock()
+	})
+
+	h.app.Event.Emit("ssh:keyscan:result", map[string]interface{}{
+		"scanId": scanID,
+		"items":  results,
+	})
+}
+
+// scanHostKey performs a key exchange only, capturing the server's host key
+func (h *HostKeyService) scanHostKey(target keyScanTarget) keyScanResult {
+	res := keyScanResult{Host: target.Host, Port: target.Port}
+
+	var captured ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "keyscan",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			captured = key
+			return errKeyCaptured
+		},
+		Timeout: keyScanTimeout,
+	}
+
+	addr := net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+	client, err := ssh.Dial("tcp", addr, config)
+	if client != nil {
+		client.Close()
+	}
+	if captured == nil {
+		res.Status = "error"
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Error = "no host key received"
+		}
+		return res
+	}
+
+	res.KeyType = captured.Type()
+	res.Fingerprint = ssh.FingerprintSHA256(captured)
+	res.PublicKeyBase64 = base64.StdEncoding.EncodeToString(captured.Marshal())
+
+	known, err := h.db.GetKnownHost(target.Host, target.Port)
+	switch {
+	case err != nil:
+		res.Status = "error"
+		res.Error = fmt.Sprintf("failed to lookup known host: %v", err)
+	case known == nil:
+		res.Status = "new"
+	case known.Fingerprint == res.Fingerprint && known.KeyType == res.KeyType:
+		res.Status = "match"
+	default:
+		res.Status = "mismatch"
+		res.OldFingerprint = known.Fingerprint
+	}
+	return res
+}
+
+// commitKeyScan trusts the reviewed subset of a scan's results
+func (h *HostKeyService) commitKeyScan(scanID string, accepted []interface{}) (int, error) {
+	h.mu.Lock()
+	results, ok := h.scans[scanID]
+	delete(h.scans, scanID)
+	h.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("scan %s not found or already committed", scanID)
+	}
+
+	want := make(map[string]bool, len(accepted))
+	for _, item := range accepted {
+		m, _ := item.(map[string]interface{})
+		host, _ := m["host"].(string)
+		port := toInt(m["port"])
+		if host != "" && port > 0 {
+			want[net.JoinHostPort(host, strconv.Itoa(port))] = true
+		}
+	}
+
+	saved := 0
+	for _, r := range results {
+		if r.Status == "error" || !want[net.JoinHostPort(r.Host, strconv.Itoa(r.Port))] {
+			continue
+		}
+		pub, err := base64.StdEncoding.DecodeString(r.PublicKeyBase64)
+		if err != nil {
+			continue
+		}
+		if err := h.trustHostKey(r.Host, r.Port, r.KeyType, r.Fingerprint, pub); err != nil {
+			return saved, fmt.Errorf("failed to save %s:%d: %w", r.Host, r.Port, err)
+		}
+		saved++
+	}
+	return saved, nil
+}
@@ -3,6 +3,7 @@ package main
 import (
     "encoding/base64"
     "fmt"
+    "log"
     "net"
     "strconv"
     "strings"
@@ -20,6 +21,26 @@ type HostKeyService struct {
     db       *database.DB
     mu       sync.Mutex
     pending  map[string]chan hostKeyDecision
+    scans    map[string][]keyScanResult // key: scan id, results awaiting review
+}
+
+// Host key checking policies (config key "ssh_host_key_policy", inheritable)
+const (
+    HostKeyPolicyStrict    = "strict"     // fail on unknown or changed keys without prompting
+    HostKeyPolicyAsk       = "ask"        // prompt the user (default)
+    HostKeyPolicyAcceptNew = "accept-new" // trust first-seen keys silently, fail on changed keys
+)
+
+// normalizeHostKeyPolicy maps a config value onto a known policy, defaulting to ask
+func normalizeHostKeyPolicy(policy string) string {
+    switch strings.ToLower(strings.TrimSpace(policy)) {
+    case HostKeyPolicyStrict, "yes":
+        return HostKeyPolicyStrict
+    case HostKeyPolicyAcceptNew, "accept_new":
+        return HostKeyPolicyAcceptNew
+    default:
+        return HostKeyPolicyAsk
+    }
 }
 
 type hostKeyDecision struct {
@@ -31,6 +52,7 @@ func NewHostKeyService(app *application.App, db *database.DB) *HostKeyService {
         app:     app,
         db:      db,
         pending: make(map[string]chan hostKeyDecision),
+        scans:   make(map[string][]keyScanResult),
     }
 
     // Listen for frontend responses to hostkey prompts
@@ -94,6 +116,19 @@ func NewHostKeyService(app *application.App, db *database.DB) *HostKeyService {
         h.emitKnownHostsList()
     })
 
+    // Host key decision audit trail: {host?, port?, limit?}
+    app.Event.On("ssh:hostkey_audit:list:request", func(e *application.CustomEvent) {
+        data, _ := e.Data.(map[string]interface{})
+        host, _ := data["host"].(string)
+        h.emitHostKeyAudit(host, toInt(data["port"]), toInt(data["limit"]))
+    })
+
+    // Bulk host key pre-scanning
+    h.setupKeyScan()
+
+    // Notes, aliases and review reminders
+    h.setupKnownHostReview()
+
     return h
 }
 
@@ -107,17 +142,28 @@ func (h *HostKeyService) emitKnownHostsList() {
         return
     }
     // Prepare serialisable list
+    maxAge := h.hostKeyMaxAge()
+    now := time.Now()
     items := make([]map[string]interface{}, 0, len(list))
-    for _, kh := range list {
-        items = append(items, map[string]interface{}{
-            "id":          kh.ID,
-            "host":        kh.Host,
-            "port":        kh.Port,
-            "keyType":     kh.KeyType,
-            "fingerprint": kh.Fingerprint,
-            "firstSeen":   kh.FirstSeen.Unix(),
-            "lastSeen":    kh.LastSeen.Unix(),
-        })
+    for i, kh := range list {
+        due, _ := knownHostReviewDue(&list[i], maxAge, now)
+        item := map[string]interface{}{
+            "id":           kh.ID,
+            "host":         kh.Host,
+            "port":         kh.Port,
+            "keyType":      kh.KeyType,
+            "fingerprint":  kh.Fingerprint,
+            "firstSeen":    kh.FirstSeen.Unix(),
+            "lastSeen":     kh.LastSeen.Unix(),
+            "note":         kh.Note,
+            "alias":        kh.Alias,
+            "trustedSince": kh.TrustedSince().Unix(),
+            "reviewDue":    due,
+        }
+        if kh.ReviewBy != nil {
+            item["reviewBy"] = kh.ReviewBy.Format("2006-01-02")
+        }
+        items = append(items, item)
     }
     h.app.Event.Emit("ssh:known_hosts:list", map[string]interface{}{
         "items": items,
@@ -126,6 +172,12 @@ func (h *HostKeyService) emitKnownHostsList() {
 
 // HostKeyCallback returns a function suitable for ssh.ClientConfig.HostKeyCallback
 func (h *HostKeyService) HostKeyCallback() ssh.HostKeyCallback {
+    return h.HostKeyCallbackWithPolicy(HostKeyPolicyAsk)
+}
+
+// HostKeyCallbackWithPolicy returns a host key callback enforcing the given checking policy
+func (h *HostKeyService) HostKeyCallbackWithPolicy(policy string) ssh.HostKeyCallback {
+    policy = normalizeHostKeyPolicy(policy)
     return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
         // Derive host and port
         host := hostname
@@ -169,23 +221,85 @@ func (h *HostKeyService) HostKeyCallback() ssh.HostKeyCallback {
             return fmt.Errorf("failed to lookup known host: %w", err)
         }
 
-        if known == nil {
+        // Consult ~/.ssh/known_hosts for hosts we have not seen ourselves
+        oldFingerprint := ""
+        if known == nil && h.systemKnownHostsEnabled() {
+            status, want := checkSystemKnownHosts(host, port, remote, key)
+            switch status {
+            case "match":
+                // Already trusted by OpenSSH: import and continue
+                _ = h.db.UpsertKnownHost(host, port, keyType, fingerprint, pub)
+                h.auditHostKey(host, port, keyType, fingerprint, "", "unknown", "trust", "system-known-hosts")
+                return nil
+            case "revoked":
+                return fmt.Errorf("host key for %s:%d is revoked in the system known_hosts file", host, port)
+            case "mismatch":
+                oldFingerprint = want
+            }
+        }
+
+        if known == nil && oldFingerprint == "" {
+            switch policy {
+            case HostKeyPolicyStrict:
+                h.auditHostKey(host, port, keyType, fingerprint, "", "unknown", "reject", "policy:"+policy)
+                return fmt.Errorf("host key for %s:%d is unknown and strict host key checking is enabled", host, port)
+            case HostKeyPolicyAcceptNew:
+                // First contact: trust silently
+                if err := h.trustHostKey(host, port, keyType, fingerprint, pub); err != nil {
+                    return fmt.Errorf("failed to save known host: %w", err)
+                }
+                h.auditHostKey(host, port, keyType, fingerprint, "", "unknown", "trust", "policy:"+policy)
+                return nil
+            }
             // Unknown host: prompt user
-            return h.promptUser(host, port, keyType, fingerprint, pubB64, "unknown", "")
+            return h.promptUser(host, port, key, pubB64, "unknown", "")
         }
 
-        if known.Fingerprint == fingerprint && known.KeyType == keyType {
-            // Match: update last_seen and continue
-            _ = h.db.UpsertKnownHost(host, port, keyType, fingerprint, pub)
-            return nil
+        if known != nil {
+            if known.Fingerprint == fingerprint && known.KeyType == keyType {
+                // Match: update last_seen and continue
+                _ = h.db.UpsertKnownHost(host, port, keyType, fingerprint, pub)
+                h.remindIfReviewDue(known)
+                return nil
+            }
+            oldFingerprint = known.Fingerprint
+
+            // Planned rotation: the server proved this key during an earlier trusted session
+            if h.promoteAltHostKey(host, port, key, oldFingerprint) {
+                return nil
+            }
+        }
+
+        // Mismatch: only the interactive policy may override a changed key
+        if policy != HostKeyPolicyAsk {
+            h.auditHostKey(host, port, keyType, fingerprint, oldFingerprint, "mismatch", "reject", "policy:"+policy)
+            return fmt.Errorf("host key for %s:%d has changed (expected %s, got %s); refusing to connect under %s policy", host, port, oldFingerprint, fingerprint, policy)
         }
+        return h.promptUser(host, port, key, pubB64, "mismatch", oldFingerprint)
+    }
+}
 
-        // Mismatch: prompt
-        return h.promptUser(host, port, keyType, fingerprint, pubB64, "mismatch", known.Fingerprint)
+// trustHostKey records a key as trusted, mirroring it to ~/.ssh/known_hosts when sync is enabled
+func (h *HostKeyService) trustHostKey(host string, port int, keyType, fingerprint string, pub []byte) error {
+    if err := h.db.UpsertKnownHost(host, port, keyType, fingerprint, pub); err != nil {
+        return err
+    }
+    if h.systemKnownHostsEnabled() {
+        key, err := ssh.ParsePublicKey(pub)
+        if err != nil {
+            return fmt.Errorf("failed to parse host key: %w", err)
+        }
+        if err := appendSystemKnownHost(host, port, key); err != nil {
+            log.Printf("Failed to update system known_hosts for %s:%d: %v", host, port, err)
+        }
     }
+    return nil
 }
 
-func (h *HostKeyService) promptUser(host string, port int, keyType, fingerprint, pubB64, status, oldFingerprint string) error {
+func (h *HostKeyService) promptUser(host string, port int, key ssh.PublicKey, pubB64, status, oldFingerprint string) error {
+    keyType := key.Type()
+    fingerprint := ssh.FingerprintSHA256(key)
+
     // Create a prompt id and channel
     pid := fmt.Sprintf("%d-%d", time.Now().UnixNano(), port)
     ch := make(chan hostKeyDecision, 1)
@@ -203,18 +317,22 @@ func (h *HostKeyService) promptUser(host string, port int, keyType, fingerprint,
         "publicKeyBase64": pubB64,
         "status":        status, // "unknown" or "mismatch"
         "oldFingerprint": oldFingerprint,
+        "bits":          keyBits(key),
+        "randomart":     keyRandomart(key),
+        "sessions":      h.sessionsForHost(host, port),
     })
 
     // Wait for user decision with timeout
     select {
     case decision := <-ch:
+        h.auditHostKey(host, port, keyType, fingerprint, oldFingerprint, status, decision.Action, currentActor())
         switch decision.Action {
         case "accept_once":
             return nil
         case "trust":
             // Save/update known host
             pubBytes, _ := base64.StdEncoding.DecodeString(pubB64)
-            _ = h.db.UpsertKnownHost(host, port, keyType, fingerprint, pubBytes)
+            _ = h.trustHostKey(host, port, keyType, fingerprint, pubBytes)
             return nil
         default:
             return fmt.Errorf("host key not accepted")
@@ -224,6 +342,7 @@ func (h *HostKeyService) promptUser(host string, port int, keyType, fingerprint,
         h.mu.Lock()
         delete(h.pending, pid)
         h.mu.Unlock()
+        h.auditHostKey(host, port, keyType, fingerprint, oldFingerprint, status, "timeout", currentActor())
         return fmt.Errorf("host key verification timed out")
     }
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"runtime"
+
+	"term/database"
+)
+
+// Settings for serving the API over a Unix domain socket (a named pipe on Windows)
+// instead of a TCP port
+const (
+	settingHTTPSocket     = "http_socket"      // "true" serves the API on the socket
+	settingHTTPSocketPath = "http_socket_path" // default api.sock in the config directory, \\.\pipe\term-api on Windows
+	defaultSocketName     = "api.sock"
+	defaultPipeName       = `\\.\pipe\term-api`
+)
+
+// httpSocketPath returns where to serve the API when http_socket is set, "" otherwise
+func httpSocketPath(db *database.DB, dir string) string {
+	if !settingBool(db, settingHTTPSocket) {
+		return ""
+	}
+	if p := settingValue(db, settingHTTPSocketPath); p != "" {
+		return p
+	}
+	if runtime.GOOS == "windows" {
+		return defaultPipeName
+	}
+	return filepath.Join(dir, defaultSocketName)
+}
+
+// Only the current user can connect to the socket, so requests arriving on it are
+// trusted without the bearer token. Its connections are marked through their context.
+type socketConnKey struct{}
+
+type socketConn struct{ net.Conn }
+
+type socketListener struct{ net.Listener }
+
+func (l socketListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return socketConn{c}, nil
+}
+
+// markSocketConn is the server's ConnContext
+func markSocketConn(ctx context.Context, c net.Conn) context.Context {
+	if _, ok := c.(socketConn); ok {
+		return context.WithValue(ctx, socketConnKey{}, true)
+	}
+	return ctx
+}
+
+func viaSocket(ctx context.Context) bool {
+	return ctx.Value(socketConnKey{}) != nil
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+)
+
+// listenSocket listens on a named pipe only the current user can connect to
+func listenSocket(path string) (net.Listener, error) {
+	token := windows.GetCurrentProcessToken()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the current user: %w", err)
+	}
+	return winio.ListenPipe(path, &winio.PipeConfig{
+		// Protected DACL granting the current user full access and nobody else any
+		SecurityDescriptor: fmt.Sprintf("D:P(A;;GA;;;%s)", user.User.Sid.String()),
+	})
+}
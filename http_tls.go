@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"term/database"
+)
+
+// Settings for serving the HTTP server over TLS
+const (
+	settingHTTPTLS     = "http_tls"      // "true" serves HTTPS/WSS
+	settingHTTPTLSCert = "http_tls_cert" // path of a PEM certificate file; empty uses a generated self-signed one
+	settingHTTPTLSKey  = "http_tls_key"  // path of the PEM private key file of http_tls_cert
+	selfSignedCertFile = "http-cert.pem"
+	selfSignedKeyFile  = "http-key.pem"
+	selfSignedValidity = 2 * 365 * 24 * time.Hour
+	selfSignedRenewal  = 30 * 24 * time.Hour // renew when expiring within this
+)
+
+// httpTLSConfig returns the TLS configuration when http_tls is set, with the
+// SHA-256 fingerprint of the certificate so clients can pin a self-signed one. The
+// self-signed certificate is kept in dir and reused until it nears expiry.
+func httpTLSConfig(db *database.DB, dir string) (*tls.Config, string, error) {
+	if !settingBool(db, settingHTTPTLS) {
+		return nil, "", nil
+	}
+
+	certFile, keyFile := settingValue(db, settingHTTPTLSCert), settingValue(db, settingHTTPTLSKey)
+	if certFile == "" {
+		certFile, keyFile = filepath.Join(dir, selfSignedCertFile), filepath.Join(dir, selfSignedKeyFile)
+		if err := ensureSelfSignedCert(certFile, keyFile); err != nil {
+			return nil, "", fmt.Errorf("failed to create self-signed certificate: %w", err)
+		}
+	} else if keyFile == "" {
+		return nil, "", fmt.Errorf("%s is set but %s is not", settingHTTPTLSCert, settingHTTPTLSKey)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, hex.EncodeToString(sum[:]), nil
+}
+
+// ensureSelfSignedCert creates certFile and keyFile unless they hold a certificate
+// valid for a while longer
+func ensureSelfSignedCert(certFile, keyFile string) error {
+	if data, err := os.ReadFile(certFile); err == nil {
+		if block, _ := pem.Decode(data); block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil && time.Until(cert.NotAfter) > selfSignedRenewal {
+				if _, err := os.Stat(keyFile); err == nil {
+					return nil
+				}
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "Terminal Manager"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -1,35 +1,111 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+	"term/logging"
 )
 
+var httpLog = logging.For("http")
+
+// Settings for the address the HTTP server listens on
+const (
+	settingHTTPBind    = "http_bind_address" // default 127.0.0.1; another address exposes the server beyond this machine
+	settingHTTPPort    = "http_port"         // default 0 picks a free port at each start
+	defaultHTTPAddress = "127.0.0.1"
+)
+
+// httpShutdownTimeout bounds how long Stop waits for requests and tunnels to finish
+const httpShutdownTimeout = 10 * time.Second
+
 type HTTPServer struct {
 	guacService *GuacamoleService
 	termService *TerminalService
+	sftpService *SftpService
 	server      *http.Server
+	token       string // bearer token required on every request, new each run
+	port        int    // port the app's frontend uses, known after Start
+	tls         *tls.Config
+	fingerprint string // SHA-256 of the TLS certificate
+	addr        string // address actually listened on
+	socketPath  string // Unix socket or named pipe serving the API instead of addr
+	discovery   string // file telling the CLI where the API is, see SetDiscoveryFile
+
+	// WebSocket tunnels are hijacked, so Shutdown does not wait for them; their
+	// request contexts derive from ctx, which Stop cancels to close them
+	ctx       context.Context
+	cancel    context.CancelFunc
+	tunnelsWG sync.WaitGroup
+
+	mu        sync.Mutex
+	terminals map[string]apiTerminal // terminals opened through the REST API
+	shares    map[string]*ShareLink  // live share links by token
+}
+
+// httpListenAddress returns the address from the http_bind_address and http_port settings
+func httpListenAddress(db *database.DB) string {
+	return net.JoinHostPort(settingValue(db, settingHTTPBind), strconv.Itoa(settingInt(db, settingHTTPPort)))
 }
 
 // NewHTTPServer creates a new HTTP server for handling WebSocket connections and API endpoints
-func NewHTTPServer(port int, guacService *GuacamoleService, termService *TerminalService) *HTTPServer {
+func NewHTTPServer(addr string, guacService *GuacamoleService, termService *TerminalService, sftpService *SftpService) *HTTPServer {
+	ctx, cancel := context.WithCancel(context.Background())
 	h := &HTTPServer{
+		ctx:         ctx,
+		cancel:      cancel,
 		guacService: guacService,
 		termService: termService,
+		sftpService: sftpService,
+		token:       newAPIToken(),
+		terminals:   make(map[string]apiTerminal),
+		shares:      make(map[string]*ShareLink),
 	}
+	termService.outputTap = h.shareOutput
+	termService.exitTap = h.forgetTerminal
 
 	mux := http.NewServeMux()
 
 	// Guacamole WebSocket endpoint
-	mux.HandleFunc("/api/guacamole/", h.handleGuacamole)
+	mux.HandleFunc(apiPrefix+"/guacamole/", h.requireToken(h.trackTunnel(h.handleGuacamole)))
+	// Viewers joining a shared connection carry the share's token instead
+	mux.HandleFunc(apiPrefix+"/guacamole/join/{connectionId}", h.trackTunnel(h.handleGuacamoleJoin))
+
+	// REST API for automation
+	h.registerRESTRoutes(mux)
+
+	// Remote file downloads for the browser
+	mux.HandleFunc("GET "+apiPrefix+"/sshfs/download", h.requireToken(h.handleSSHFSDownload))
+
+	// Read-only share links carry their own token
+	mux.HandleFunc("GET "+apiPrefix+"/share/{token}", h.trackTunnel(h.handleShareView))
+
+	// Anything else under /api, including unversioned paths, gets the error envelope
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "", fmt.Errorf("no endpoint %s %s", r.Method, r.URL.Path))
+	})
 
 	h.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Addr:        addr,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return h.ctx },
+		ConnContext: markSocketConn,
 	}
 
 	return h
@@ -42,26 +118,87 @@ func (h *HTTPServer) handleGuacamole(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	// Extract session ID from path: /api/guacamole/:sessionId
-	path := strings.TrimPrefix(r.URL.Path, "/api/guacamole/")
+	// Extract session ID from path: /guacamole/:sessionId
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix+"/guacamole/")
 	sessionID := strings.TrimSpace(path)
 
 	if sessionID == "" {
-		http.Error(w, "Session ID required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "missing_parameter", fmt.Errorf("session ID required"))
 		return
 	}
 
-	log.Printf("Guacamole WebSocket connection request for session: %s", sessionID)
+	httpLog.Debug("guacamole WebSocket request", "session", sessionID)
 
 	// Delegate to GuacamoleService
-	h.guacService.HandleWebSocket(w, r, sessionID)
+	h.guacService.handleWebSocket(w, r, sessionID)
+}
+
+// handleGuacamoleJoin attaches a viewer to a shared connection:
+// /guacamole/join/:connectionId. Resuming a dropped connection (resume=1) is for
+// the app's own client and needs the API token.
+func (h *HTTPServer) handleGuacamoleJoin(w http.ResponseWriter, r *http.Request) {
+	h.applyCORS(&w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	connectionID := r.PathValue("connectionId")
+	if r.URL.Query().Get("resume") == "1" {
+		h.requireToken(func(w http.ResponseWriter, r *http.Request) {
+			h.guacService.handleJoin(w, r, connectionID, true)
+		})(w, r)
+		return
+	}
+	h.guacService.handleJoin(w, r, connectionID, false)
+}
+
+// trackTunnel lets Stop wait for a WebSocket handler to finish
+func (h *HTTPServer) trackTunnel(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.tunnelsWG.Add(1)
+		defer h.tunnelsWG.Done()
+		next(w, r)
+	}
 }
 
 // set common CORS headers
 func (h *HTTPServer) applyCORS(w *http.ResponseWriter, r *http.Request) {
 	(*w).Header().Set("Access-Control-Allow-Origin", "*")
 	(*w).Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept")
+	(*w).Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, Authorization")
+}
+
+// newAPIToken generates the bearer token of this run
+func newAPIToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate API token: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requireToken rejects requests without the bearer token. It is read from the
+// Authorization header or, for WebSocket upgrades where browsers cannot set headers,
+// from the token query parameter. CORS preflight requests and requests over the
+// socket, which only the current user can reach, pass without it.
+func (h *HTTPServer) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || viaSocket(r.Context()) {
+			next(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			httpLog.Warn("rejected unauthenticated request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="term"`)
+			writeJSONError(w, http.StatusUnauthorized, "", fmt.Errorf("missing or invalid bearer token"))
+			return
+		}
+		next(w, r)
+	}
 }
 
 // POSIX-style join (always '/') regardless of OS building the app
@@ -73,20 +210,133 @@ func posixJoin(elem ...string) string {
 // fileBase returns the last element of a POSIX path
 func fileBase(p string) string { return filepath.Base(p) }
 
-// Start starts the HTTP server in a goroutine
+// SetTLS makes Start serve HTTPS/WSS with the given configuration
+func (h *HTTPServer) SetTLS(config *tls.Config, fingerprint string) {
+	h.tls = config
+	h.fingerprint = fingerprint
+}
+
+// SetSocket makes Start serve the API on a Unix socket or named pipe; the TCP address
+// is then only meant for the app's own frontend
+func (h *HTTPServer) SetSocket(path string) {
+	h.socketPath = path
+}
+
+// apiDiscovery is the discovery file's content: a loopback URL of the API, always
+// plain HTTP, and the token of this run
+type apiDiscovery struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	PID   int    `json:"pid"`
+}
+
+// SetDiscoveryFile makes Start write the API's URL and token to path, readable by
+// the current user only, for the term CLI; Stop removes it
+func (h *HTTPServer) SetDiscoveryFile(path string) {
+	h.discovery = path
+}
+
+func (h *HTTPServer) writeDiscovery() error {
+	data, err := json.Marshal(apiDiscovery{
+		URL:   "http://" + net.JoinHostPort(defaultHTTPAddress, strconv.Itoa(h.port)),
+		Token: h.token,
+		PID:   os.Getpid(),
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.discovery, data, 0600)
+}
+
+// Start listens and serves in a goroutine; with port 0 a free port is picked and
+// available from Port afterwards. With TLS the app's own frontend, whose webview
+// would not trust a self-signed certificate, gets a separate plain listener on a
+// free loopback port, as it does when http_bind_address is not reachable over
+// 127.0.0.1.
 func (h *HTTPServer) Start() error {
-	go func() {
-		log.Printf("HTTP server starting on %s", h.server.Addr)
-		if err := h.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server error: %v", err)
+	listener, err := net.Listen("tcp", h.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", h.server.Addr, err)
+	}
+	h.addr = listener.Addr().String()
+	h.port = listener.Addr().(*net.TCPAddr).Port
+	if h.socketPath != "" {
+		// The frontend keeps working over TCP when the socket is unavailable
+		if socket, err := listenSocket(h.socketPath); err != nil {
+			httpLog.Error("failed to listen on socket", "path", h.socketPath, "err", err)
+			h.socketPath = ""
+		} else {
+			go h.serve(socketListener{socket})
 		}
-	}()
+	}
+	if h.tls != nil || !servesLoopback(listener.Addr()) {
+		local, err := net.Listen("tcp", net.JoinHostPort(defaultHTTPAddress, "0"))
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to listen on %s: %w", defaultHTTPAddress, err)
+		}
+		h.port = local.Addr().(*net.TCPAddr).Port
+		go h.serve(local)
+	}
+	if h.tls != nil {
+		listener = tls.NewListener(listener, h.tls)
+		httpLog.Info("serving TLS", "addr", h.addr, "sha256", h.fingerprint)
+	}
+	go h.serve(listener)
+	if h.discovery != "" {
+		if err := h.writeDiscovery(); err != nil {
+			httpLog.Warn("failed to write discovery file; the CLI cannot reach the app", "path", h.discovery, "err", err)
+		}
+	}
 	return nil
 }
 
-// Stop gracefully stops the HTTP server
+// servesLoopback reports whether a listener on addr accepts connections to
+// defaultHTTPAddress, which the frontend and the discovery file use
+func servesLoopback(addr net.Addr) bool {
+	ip := addr.(*net.TCPAddr).IP
+	return ip.IsUnspecified() || ip.Equal(net.ParseIP(defaultHTTPAddress))
+}
+
+func (h *HTTPServer) serve(listener net.Listener) {
+	httpLog.Info("listening", "addr", listener.Addr().String())
+	if err := h.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		httpLog.Error("server error", "err", err)
+	}
+}
+
+// Port returns the port the app's frontend connects to, 0 before Start
+func (h *HTTPServer) Port() int {
+	return h.port
+}
+
+// Stop gracefully stops the HTTP server: it stops accepting connections, lets
+// requests in flight finish, then closes the WebSocket tunnels and waits for them to
+// wind down, forcing everything closed after httpShutdownTimeout
 func (h *HTTPServer) Stop() error {
-	if h.server != nil {
+	if h.server == nil {
+		return nil
+	}
+	if h.discovery != "" {
+		os.Remove(h.discovery)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	err := h.server.Shutdown(ctx)
+	h.cancel()
+	drained := make(chan struct{})
+	go func() {
+		h.tunnelsWG.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		httpLog.Warn("tunnels did not close in time", "timeout", httpShutdownTimeout)
+	}
+	if err != nil {
+		httpLog.Error("shutdown failed", "err", err)
 		return h.server.Close()
 	}
 	return nil
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"term/database"
+)
+
+const keyBackupVersion = 1
+
+// keyBackupFile is the on-disk envelope of an encrypted key backup
+type keyBackupFile struct {
+	Version    int          `json:"version"`
+	KDF        string       `json:"kdf"`
+	KDFParams  Argon2Params `json:"kdfParams"`
+	Salt       string       `json:"salt"`
+	Nonce      string       `json:"nonce"`
+	Ciphertext string       `json:"ciphertext"`
+	CreatedAt  time.Time    `json:"createdAt"`
+}
+
+// keyBackupPayload is the plaintext content sealed inside a backup
+type keyBackupPayload struct {
+	Keys []database.UserKey `json:"keys"`
+	// RecordingKDFSalt is needed to re-derive the master key of passphrase-encrypted recordings
+	RecordingKDFSalt string `json:"recordingKdfSalt,omitempty"`
+}
+
+// KeyRestoreResult summarises what a restore changed
+type KeyRestoreResult struct {
+	Imported     int  `json:"imported"`
+	Skipped      int  `json:"skipped"`
+	SaltRestored bool `json:"saltRestored"`
+	SaltConflict bool `json:"saltConflict"`
+}
+
+// ExportKeyBackup writes all user keys, including local private keys, to a passphrase-encrypted file
+func (kms *KeyManagementService) ExportKeyBackup(destPath, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("passphrase required")
+	}
+
+	keys, err := kms.db.ListUserKeys()
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	payload := keyBackupPayload{Keys: make([]database.UserKey, 0, len(keys))}
+	for _, k := range keys {
+		key := *k
+		// Backups carry plaintext keys under their own passphrase, independent of this vault
+		if kms.secrets != nil && key.PrivateKey != "" {
+			if key.PrivateKey, err = kms.secrets.Decrypt(key.PrivateKey); err != nil {
+				return fmt.Errorf("failed to decrypt private key %q: %w", k.Name, err)
+			}
+		}
+		payload.Keys = append(payload.Keys, key)
+	}
+	if s, err := kms.db.GetSetting("recording_kdf_salt"); err == nil && s != nil {
+		payload.RecordingKDFSalt = s.Value
+	}
+
+	plain, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode backup: %w", err)
+	}
+
+	salt, err := randBytes(16)
+	if err != nil {
+		return err
+	}
+	master := deriveKeyArgon2([]byte(passphrase), salt, defaultArgon2)
+	ct, nonce, err := EncryptKeyGCM(master, plain)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt backup: %w", err)
+	}
+
+	data, err := json.MarshalIndent(keyBackupFile{
+		Version:    keyBackupVersion,
+		KDF:        "argon2id",
+		KDFParams:  defaultArgon2,
+		Salt:       b64(salt),
+		Nonce:      b64(nonce),
+		Ciphertext: b64(ct),
+		CreatedAt:  time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup: %w", err)
+	}
+
+	if err := os.WriteFile(destPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+	return nil
+}
+
+// RestoreKeyBackup decrypts a backup file and merges its keys into the database.
+// Keys whose public key already exists are skipped.
+func (kms *KeyManagementService) RestoreKeyBackup(srcPath, passphrase string) (*KeyRestoreResult, error) {
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup file: %w", err)
+	}
+
+	var file keyBackupFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse backup file: %w", err)
+	}
+	if file.Version != keyBackupVersion || file.KDF != "argon2id" {
+		return nil, fmt.Errorf("unsupported backup format (version %d, kdf %s)", file.Version, file.KDF)
+	}
+
+	salt, err := decodeB64(file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := decodeB64(file.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := decodeB64(file.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkArgon2Params(file.KDFParams); err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	master := deriveKeyArgon2([]byte(passphrase), salt, file.KDFParams)
+	plain, err := unwrapFileKey(ct, nonce, master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup (wrong passphrase?)")
+	}
+
+	var payload keyBackupPayload
+	if err := json.Unmarshal(plain, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode backup contents: %w", err)
+	}
+
+	existing, err := kms.db.ListUserKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	known := make(map[string]bool, len(existing))
+	hasDefault := false
+	for _, k := range existing {
+		known[k.PublicKey] = true
+		if k.IsLocal && k.IsDefault {
+			hasDefault = true
+		}
+	}
+
+	result := &KeyRestoreResult{}
+	for _, k := range payload.Keys {
+		if known[k.PublicKey] {
+			result.Skipped++
+			continue
+		}
+		key := k
+		key.ID = 0
+		// Keep the current default identity if there already is one
+		key.IsDefault = key.IsLocal && key.IsDefault && !hasDefault
+		if err := kms.db.SaveUserKey(&key); err != nil {
+			return result, fmt.Errorf("failed to restore key %q: %w", k.Name, err)
+		}
+		if key.IsDefault {
+			hasDefault = true
+		}
+		known[k.PublicKey] = true
+		result.Imported++
+	}
+	kms.sealPrivateKeys()
+
+	if payload.RecordingKDFSalt != "" {
+		current, err := kms.db.GetSetting("recording_kdf_salt")
+		switch {
+		case err != nil || current == nil || current.Value == "":
+			if err := kms.db.SetSetting("recording_kdf_salt", payload.RecordingKDFSalt, "string"); err != nil {
+				return result, fmt.Errorf("failed to restore recording salt: %w", err)
+			}
+			result.SaltRestored = true
+		case current.Value != payload.RecordingKDFSalt:
+			// Recordings made on this install already depend on the current salt
+			result.SaltConflict = true
+		}
+	}
+
+	return result, nil
+}
+
+func (kms *KeyManagementService) handleExportBackup(data map[string]interface{}) {
+	path, _ := data["path"].(string)
+	passphrase, _ := data["passphrase"].(string)
+	if path == "" {
+		kms.app.Event.Emit("keys:error", map[string]interface{}{
+			"error": "invalid or missing path",
+		})
+		return
+	}
+
+	if err := kms.ExportKeyBackup(path, passphrase); err != nil {
+		kms.app.Event.Emit("keys:error", map[string]interface{}{
+			"error": fmt.Sprintf("failed to export backup: %v", err),
+		})
+		return
+	}
+
+	kms.app.Event.Emit("keys:backup:exported", map[string]interface{}{
+		"path": path,
+	})
+}
+
+func (kms *KeyManagementService) handleRestoreBackup(data map[string]interface{}) {
+	path, _ := data["path"].(string)
+	passphrase, _ := data["passphrase"].(string)
+	if path == "" {
+		kms.app.Event.Emit("keys:error", map[string]interface{}{
+			"error": "invalid or missing path",
+		})
+		return
+	}
+
+	result, err := kms.RestoreKeyBackup(path, passphrase)
+	if err != nil {
+		kms.app.Event.Emit("keys:error", map[string]interface{}{
+			"error": fmt.Sprintf("failed to restore backup: %v", err),
+		})
+		if result == nil || result.Imported == 0 {
+			return
+		}
+	}
+
+	kms.app.Event.Emit("keys:backup:restored", map[string]interface{}{
+		"imported":     result.Imported,
+		"skipped":      result.Skipped,
+		"saltRestored": result.SaltRestored,
+		"saltConflict": result.SaltConflict,
+	})
+
+	// Refresh list
+	kms.emitKeysList()
+}
@@ -0,0 +1,94 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const keychainService = "term"
+
+// Exit status of `security find-generic-password` when the item does not exist
+// (errSecItemNotFound)
+const securityItemNotFound = 44
+
+// keychainGet reads a secret from the macOS Keychain or the Secret Service (libsecret)
+func keychainGet(account string) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", account, "-w")
+	default:
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, fmt.Errorf("%w: secret-tool not found", errKeychainUnavailable)
+		}
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", account)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && keychainItemMissing(exitErr.ExitCode(), stderr.String()) {
+			return nil, errKeychainNotFound
+		}
+		return nil, fmt.Errorf("%w: keychain lookup failed: %v: %s", errKeychainUnavailable, err, strings.TrimSpace(stderr.String()))
+	}
+	secret := bytes.TrimRight(out, "\r\n")
+	if len(secret) == 0 {
+		return nil, errKeychainNotFound
+	}
+	return secret, nil
+}
+
+// keychainItemMissing reports whether a failed lookup means the item does not
+// exist, rather than the keychain failing. secret-tool exits with 1 and prints
+// nothing in that case; its other failures come with a message.
+func keychainItemMissing(code int, stderr string) bool {
+	if runtime.GOOS == "darwin" {
+		return code == securityItemNotFound
+	}
+	return code == 1 && strings.TrimSpace(stderr) == ""
+}
+
+// keychainSet stores a secret in the macOS Keychain or the Secret Service
+// (libsecret). The secret goes through stdin, never the command line, which
+// other processes can read.
+func keychainSet(account string, secret []byte) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// security reads the command from stdin in interactive mode
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %q\n", keychainService, account, secret))
+	default:
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return fmt.Errorf("%w: secret-tool not found", errKeychainUnavailable)
+		}
+		cmd = exec.Command("secret-tool", "store", "--label=Term "+account, "service", keychainService, "account", account)
+		cmd.Stdin = bytes.NewReader(secret)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: keychain store failed: %v: %s", errKeychainUnavailable, err, strings.TrimSpace(stderr.String()))
+	}
+	if runtime.GOOS == "darwin" {
+		// Interactive mode exits with 0 even when the command failed
+		stored, err := keychainGet(account)
+		if err != nil {
+			return fmt.Errorf("%w: keychain store failed: %s", errKeychainUnavailable, strings.TrimSpace(stderr.String()))
+		}
+		if !bytes.Equal(stored, secret) {
+			return fmt.Errorf("%w: keychain store failed: the stored secret differs", errKeychainUnavailable)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// keychainPath returns the DPAPI-protected secret file for an account
+func keychainPath(account string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "term", account+".dpapi"), nil
+}
+
+// keychainGet reads a secret protected with the current user's DPAPI key
+func keychainGet(account string) ([]byte, error) {
+	path, err := keychainPath(account)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errKeychainNotFound
+		}
+		return nil, err
+	}
+	if len(blob) == 0 {
+		return nil, errKeychainNotFound
+	}
+
+	in := windows.DataBlob{Size: uint32(len(blob)), Data: &blob[0]}
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	secret := make([]byte, out.Size)
+	copy(secret, unsafe.Slice(out.Data, out.Size))
+	return secret, nil
+}
+
+// keychainSet protects a secret with the current user's DPAPI key and stores it on disk
+func keychainSet(account string, secret []byte) error {
+	if len(secret) == 0 {
+		return errors.New("empty secret")
+	}
+	path, err := keychainPath(account)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	in := windows.DataBlob{Size: uint32(len(secret)), Data: &secret[0]}
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	blob := make([]byte, out.Size)
+	copy(blob, unsafe.Slice(out.Data, out.Size))
+	return os.WriteFile(path, blob, 0600)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
@@ -12,15 +13,28 @@ import (
 )
 
 type KeyManagementService struct {
-	db  *database.DB
-	app *application.App
-	mu  sync.Mutex
+	db      *database.DB
+	app     *application.App
+	mu      sync.Mutex
+	secrets *SecretStore
 }
 
-func NewKeyManagementService(db *database.DB, app *application.App) *KeyManagementService {
+func NewKeyManagementService(db *database.DB, app *application.App, secrets *SecretStore) *KeyManagementService {
 	return &KeyManagementService{
-		db:  db,
-		app: app,
+		db:      db,
+		app:     app,
+		secrets: secrets,
+	}
+}
+
+// sealPrivateKeys encrypts newly saved private keys when the vault is open;
+// otherwise they are sealed on the next unlock
+func (kms *KeyManagementService) sealPrivateKeys() {
+	if kms.secrets == nil || !kms.secrets.Unlocked() {
+		return
+	}
+	if _, err := kms.secrets.MigratePlaintext(); err != nil {
+		log.Printf("Failed to encrypt private keys: %v", err)
 	}
 }
 
@@ -48,10 +62,28 @@ func (kms *KeyManagementService) Setup() {
 			kms.handleDeleteKey(data)
 		}
 	})
+	kms.app.Event.On("keys:set_default", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		if data != nil {
+			kms.handleSetDefaultKey(data)
+		}
+	})
 	kms.app.Event.On("keys:export:public", func(e *application.CustomEvent) {
 		data, _ := e.Data.(map[string]interface{})
 		kms.handleExportPublicKey(data)
 	})
+	kms.app.Event.On("keys:backup:export", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		if data != nil {
+			kms.handleExportBackup(data)
+		}
+	})
+	kms.app.Event.On("keys:backup:restore", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		if data != nil {
+			kms.handleRestoreBackup(data)
+		}
+	})
 	kms.app.Event.On("recording:share", func(e *application.CustomEvent) {
 		data, _ := e.Data.(map[string]interface{})
 		if data != nil {
@@ -83,14 +115,22 @@ func (kms *KeyManagementService) handleGenerateKey(data map[string]interface{})
 		return
 	}
 
-	// Check if local key already exists
-	existingKey, err := kms.db.GetLocalUserKey()
-	if err == nil && existingKey != nil {
+	// Reject duplicate identity names so shares stay unambiguous
+	localKeys, err := kms.db.ListLocalUserKeys()
+	if err != nil {
 		kms.app.Event.Emit("keys:error", map[string]interface{}{
-			"error": "local key already exists, delete it first",
+			"error": fmt.Sprintf("failed to list local keys: %v", err),
 		})
 		return
 	}
+	for _, k := range localKeys {
+		if k.Name == name {
+			kms.app.Event.Emit("keys:error", map[string]interface{}{
+				"error": fmt.Sprintf("a local identity named %q already exists", name),
+			})
+			return
+		}
+	}
 
 	// Generate new key pair
 	key, err := GenerateKeyPair(name)
@@ -101,6 +141,10 @@ func (kms *KeyManagementService) handleGenerateKey(data map[string]interface{})
 		return
 	}
 
+	// The first identity becomes the default; later ones only on request
+	makeDefault, _ := data["makeDefault"].(bool)
+	key.IsDefault = len(localKeys) == 0 || makeDefault
+
 	// Save to database
 	if err := kms.db.SaveUserKey(key); err != nil {
 		kms.app.Event.Emit("keys:error", map[string]interface{}{
@@ -108,6 +152,14 @@ func (kms *KeyManagementService) handleGenerateKey(data map[string]interface{})
 		})
 		return
 	}
+	kms.sealPrivateKeys()
+	if key.IsDefault && len(localKeys) > 0 {
+		if err := kms.db.SetDefaultLocalUserKey(key.ID); err != nil {
+			kms.app.Event.Emit("keys:error", map[string]interface{}{
+				"error": fmt.Sprintf("failed to set default identity: %v", err),
+			})
+		}
+	}
 
 	// Emit success with public key only
 	kms.app.Event.Emit("keys:generated", map[string]interface{}{
@@ -115,6 +167,7 @@ func (kms *KeyManagementService) handleGenerateKey(data map[string]interface{})
 		"name":      key.Name,
 		"publicKey": key.PublicKey,
 		"createdAt": key.CreatedAt,
+		"isDefault": key.IsDefault,
 	})
 
 	// Refresh list
@@ -186,6 +239,7 @@ func (kms *KeyManagementService) emitKeysList() {
 			"publicKey": key.PublicKey,
 			"createdAt": key.CreatedAt,
 			"isLocal":   key.IsLocal,
+			"isDefault": key.IsDefault,
 		}
 		// Only include private key flag (not the actual key) for local keys
 		if key.IsLocal {
@@ -223,17 +277,61 @@ func (kms *KeyManagementService) handleDeleteKey(data map[string]interface{}) {
 	kms.emitKeysList()
 }
 
-func (kms *KeyManagementService) handleExportPublicKey(data map[string]interface{}) {
-	// Get local key
+func (kms *KeyManagementService) handleSetDefaultKey(data map[string]interface{}) {
+	id, ok := data["id"].(float64)
+	if !ok {
+		kms.app.Event.Emit("keys:error", map[string]interface{}{
+			"error": "invalid key id",
+		})
+		return
+	}
+
+	if err := kms.db.SetDefaultLocalUserKey(int(id)); err != nil {
+		kms.app.Event.Emit("keys:error", map[string]interface{}{
+			"error": fmt.Sprintf("failed to set default identity: %v", err),
+		})
+		return
+	}
+
+	kms.app.Event.Emit("keys:default_set", map[string]interface{}{
+		"id": int(id),
+	})
+
+	// Refresh list
+	kms.emitKeysList()
+}
+
+// resolveLocalKey returns the local identity referenced by data[field], or the default identity
+func (kms *KeyManagementService) resolveLocalKey(data map[string]interface{}, field string) (*database.UserKey, error) {
+	if id, ok := data[field].(float64); ok && id > 0 {
+		key, err := kms.db.GetUserKey(int(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get identity: %w", err)
+		}
+		if !key.IsLocal {
+			return nil, fmt.Errorf("key %q is not a local identity", key.Name)
+		}
+		return key, nil
+	}
 	key, err := kms.db.GetLocalUserKey()
+	if err != nil {
+		return nil, fmt.Errorf("no local key found, generate one first")
+	}
+	return key, nil
+}
+
+func (kms *KeyManagementService) handleExportPublicKey(data map[string]interface{}) {
+	// Get requested local identity (or the default one)
+	key, err := kms.resolveLocalKey(data, "id")
 	if err != nil {
 		kms.app.Event.Emit("keys:error", map[string]interface{}{
-			"error": "no local key found, generate one first",
+			"error": err.Error(),
 		})
 		return
 	}
 
 	kms.app.Event.Emit("keys:public_key", map[string]interface{}{
+		"id":        key.ID,
 		"publicKey": key.PublicKey,
 		"name":      key.Name,
 	})
@@ -256,14 +354,26 @@ func (kms *KeyManagementService) handleShareRecording(data map[string]interface{
 		return
 	}
 
-	passphrase, ok := data["passphrase"].(string)
-	if !ok || passphrase == "" {
+	passphrase, _ := data["passphrase"].(string)
+	if passphrase == "" && kms.secrets != nil {
+		passphrase = kms.secrets.RecordingPassphrase()
+	}
+	if passphrase == "" {
 		kms.app.Event.Emit("recording:share:error", map[string]interface{}{
 			"error": "passphrase required to unwrap file key",
 		})
 		return
 	}
 
+	// Identity the share is made from (explicit choice or the default)
+	sender, err := kms.resolveLocalKey(data, "identityKeyId")
+	if err != nil {
+		kms.app.Event.Emit("recording:share:error", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	// Get recording
 	rec, err := kms.db.GetRecording(int(recordingID))
 	if err != nil {
@@ -341,6 +451,7 @@ func (kms *KeyManagementService) handleShareRecording(data map[string]interface{
 		RecordingID:   int(recordingID),
 		RecipientName: recipientKey.Name,
 		WrappedKey:    wrappedKey,
+		SenderKeyID:   &sender.ID,
 		CreatedAt:     time.Now(),
 	}
 
@@ -354,6 +465,7 @@ func (kms *KeyManagementService) handleShareRecording(data map[string]interface{
 	kms.app.Event.Emit("recording:shared", map[string]interface{}{
 		"recordingId":   int(recordingID),
 		"recipientName": recipientKey.Name,
+		"senderName":    sender.Name,
 	})
 }
 
@@ -379,6 +491,7 @@ func (kms *KeyManagementService) handleListSharedWith(data map[string]interface{
 		keysList = append(keysList, map[string]interface{}{
 			"id":            key.ID,
 			"recipientName": key.RecipientName,
+			"senderKeyId":   key.SenderKeyID,
 			"createdAt":     key.CreatedAt,
 		})
 	}
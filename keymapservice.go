@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// settingKeymap holds the user's shortcut overrides as a JSON object of action ID
+// to chord; "" unbinds an action. Actions not listed use their default chord.
+const settingKeymap = "keymap"
+
+// KeyAction is a keyboard-triggerable action and its current chord
+type KeyAction struct {
+	ID           string `json:"id"`
+	Label        string `json:"label"`
+	Category     string `json:"category"`
+	DefaultChord string `json:"defaultChord"`
+	Chord        string `json:"chord"`  // "" when unbound
+	Custom       bool   `json:"custom"` // differs from the default
+}
+
+// KeyConflict is a chord bound to more than one action
+type KeyConflict struct {
+	Chord   string   `json:"chord"`
+	Actions []string `json:"actions"`
+}
+
+// defaultKeyActions are the actions the frontend handles, with their default chords
+var defaultKeyActions = []KeyAction{
+	{ID: "tab.new", Label: "New tab", Category: "Tabs", DefaultChord: "Ctrl+T"},
+	{ID: "tab.close", Label: "Close tab", Category: "Tabs", DefaultChord: "Ctrl+W"},
+	{ID: "tab.next", Label: "Next tab", Category: "Tabs", DefaultChord: "Ctrl+Tab"},
+	{ID: "tab.previous", Label: "Previous tab", Category: "Tabs", DefaultChord: "Ctrl+Shift+Tab"},
+	{ID: "session.new", Label: "New session", Category: "Sessions", DefaultChord: "Ctrl+N"},
+	{ID: "window.new", Label: "New window", Category: "Windows", DefaultChord: "Ctrl+Shift+N"},
+	{ID: "tab.move_to_new_window", Label: "Move tab to new window", Category: "Windows"},
+	{ID: "font.increase", Label: "Increase font size", Category: "View", DefaultChord: "Ctrl+="},
+	{ID: "font.decrease", Label: "Decrease font size", Category: "View", DefaultChord: "Ctrl+-"},
+	{ID: "font.reset", Label: "Reset font size", Category: "View", DefaultChord: "Ctrl+0"},
+	{ID: "terminal.copy", Label: "Copy selection", Category: "Terminal", DefaultChord: "Ctrl+Shift+C"},
+	{ID: "terminal.paste", Label: "Paste", Category: "Terminal", DefaultChord: "Ctrl+Shift+V"},
+}
+
+// Modifiers in the order chords are written
+var chordModifiers = []string{"Ctrl", "Alt", "Shift", "Meta"}
+
+var chordModifierAliases = map[string]string{
+	"ctrl": "Ctrl", "control": "Ctrl",
+	"alt": "Alt", "option": "Alt", "opt": "Alt",
+	"shift": "Shift",
+	"meta":  "Meta", "cmd": "Meta", "command": "Meta", "super": "Meta", "win": "Meta",
+}
+
+var chordKeyAliases = map[string]string{
+	"tab": "Tab", "enter": "Enter", "return": "Enter", "esc": "Escape", "escape": "Escape",
+	"space": "Space", "backspace": "Backspace", "delete": "Delete", "del": "Delete",
+	"insert": "Insert", "home": "Home", "end": "End", "pageup": "PageUp", "pagedown": "PageDown",
+	"up": "ArrowUp", "down": "ArrowDown", "left": "ArrowLeft", "right": "ArrowRight",
+	"arrowup": "ArrowUp", "arrowdown": "ArrowDown", "arrowleft": "ArrowLeft", "arrowright": "ArrowRight",
+	"plus": "Plus", "minus": "-",
+}
+
+// normalizeChord validates a chord such as "ctrl+shift+t" and writes it in the
+// canonical form "Ctrl+Shift+T". Apart from function keys, a chord needs Ctrl, Alt
+// or Meta so it cannot swallow plain typing.
+func normalizeChord(chord string) (string, error) {
+	chord = strings.TrimSpace(chord)
+	if chord == "" {
+		return "", nil
+	}
+	var parts []string
+	if strings.HasSuffix(chord, "++") {
+		parts = append(strings.Split(strings.TrimSuffix(chord, "++"), "+"), "Plus")
+	} else {
+		parts = strings.Split(chord, "+")
+	}
+
+	mods := make(map[string]bool)
+	key := ""
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		if mod, ok := chordModifierAliases[strings.ToLower(p)]; ok && i < len(parts)-1 {
+			mods[mod] = true
+			continue
+		}
+		if i != len(parts)-1 || p == "" {
+			return "", fmt.Errorf("invalid shortcut %q", chord)
+		}
+		key = p
+	}
+	functionKey := false
+	switch lower := strings.ToLower(key); {
+	case chordKeyAliases[lower] != "":
+		key = chordKeyAliases[lower]
+	case len(key) == 1:
+		key = strings.ToUpper(key)
+	case len(lower) <= 3 && lower[0] == 'f' && strings.Trim(lower[1:], "0123456789") == "":
+		key, functionKey = strings.ToUpper(key), true
+	default:
+		return "", fmt.Errorf("invalid shortcut %q: unknown key %q", chord, key)
+	}
+	if !mods["Ctrl"] && !mods["Alt"] && !mods["Meta"] && !functionKey {
+		return "", fmt.Errorf("shortcut %q needs Ctrl, Alt or Meta", chord)
+	}
+
+	out := make([]string, 0, len(mods)+1)
+	for _, m := range chordModifiers {
+		if mods[m] {
+			out = append(out, m)
+		}
+	}
+	return strings.Join(append(out, key), "+"), nil
+}
+
+func keyActionByID(id string) (KeyAction, bool) {
+	for _, a := range defaultKeyActions {
+		if a.ID == id {
+			return a, true
+		}
+	}
+	return KeyAction{}, false
+}
+
+// validateKeymap is the settings registry validator of the keymap setting
+func validateKeymap(value string) error {
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		return fmt.Errorf("must be a JSON object of action to shortcut")
+	}
+	for id, chord := range overrides {
+		if _, ok := keyActionByID(id); !ok {
+			return fmt.Errorf("unknown action %q", id)
+		}
+		if _, err := normalizeChord(chord); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeymapService stores the user's keyboard shortcuts, the source of truth for
+// the frontend's key handling
+type KeymapService struct {
+	settings *SettingsService
+	app      *application.App
+}
+
+// NewKeymapService creates the keymap service; keymap:changed is emitted on
+// every change of the keymap setting, including imports and sync
+func NewKeymapService(settings *SettingsService) *KeymapService {
+	k := &KeymapService{settings: settings}
+	settings.OnSettingChanged(func(key string) {
+		if key == settingKeymap && k.app != nil {
+			k.app.Event.Emit("keymap:changed", k.GetKeymap())
+		}
+	})
+	return k
+}
+
+// SetApp sets the Wails application instance
+func (k *KeymapService) SetApp(app *application.App) {
+	k.app = app
+}
+
+func (k *KeymapService) overrides() map[string]string {
+	overrides := make(map[string]string)
+	if value := k.settings.GetString(settingKeymap); value != "" {
+		_ = json.Unmarshal([]byte(value), &overrides)
+	}
+	return overrides
+}
+
+func (k *KeymapService) saveOverrides(overrides map[string]string) error {
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+	return k.settings.Set(settingKeymap, string(data))
+}
+
+// ListKeyActions lists every action with its default and current chord
+func (k *KeymapService) ListKeyActions() []KeyAction {
+	overrides := k.overrides()
+	actions := make([]KeyAction, len(defaultKeyActions))
+	for i, a := range defaultKeyActions {
+		a.Chord = a.DefaultChord
+		if chord, ok := overrides[a.ID]; ok {
+			a.Chord, _ = normalizeChord(chord)
+		}
+		a.Custom = a.Chord != a.DefaultChord
+		actions[i] = a
+	}
+	return actions
+}
+
+// GetKeymap returns the current chord of every bound action
+func (k *KeymapService) GetKeymap() map[string]string {
+	keymap := make(map[string]string)
+	for _, a := range k.ListKeyActions() {
+		if a.Chord != "" {
+			keymap[a.ID] = a.Chord
+		}
+	}
+	return keymap
+}
+
+// CheckKeyBinding returns the actions other than action that chord is bound to
+func (k *KeymapService) CheckKeyBinding(action, chord string) ([]string, error) {
+	chord, err := normalizeChord(chord)
+	if err != nil || chord == "" {
+		return nil, err
+	}
+	var conflicts []string
+	for _, a := range k.ListKeyActions() {
+		if a.ID != action && a.Chord == chord {
+			conflicts = append(conflicts, a.ID)
+		}
+	}
+	return conflicts, nil
+}
+
+// FindKeyConflicts lists chords bound to more than one action
+func (k *KeymapService) FindKeyConflicts() []KeyConflict {
+	byChord := make(map[string][]string)
+	for _, a := range k.ListKeyActions() {
+		if a.Chord != "" {
+			byChord[a.Chord] = append(byChord[a.Chord], a.ID)
+		}
+	}
+	conflicts := []KeyConflict{}
+	for chord, actions := range byChord {
+		if len(actions) > 1 {
+			conflicts = append(conflicts, KeyConflict{Chord: chord, Actions: actions})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Chord < conflicts[j].Chord })
+	return conflicts
+}
+
+// SetKeyBinding binds action to chord, or unbinds it when chord is empty. A chord
+// already used by another action is refused.
+func (k *KeymapService) SetKeyBinding(action, chord string) error {
+	def, ok := keyActionByID(action)
+	if !ok {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	chord, err := normalizeChord(chord)
+	if err != nil {
+		return err
+	}
+	conflicts, err := k.CheckKeyBinding(action, chord)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%s is already used by %s", chord, strings.Join(conflicts, ", "))
+	}
+
+	overrides := k.overrides()
+	if chord == def.DefaultChord {
+		delete(overrides, action)
+	} else {
+		overrides[action] = chord
+	}
+	return k.saveOverrides(overrides)
+}
+
+// ResetKeyBinding restores the default chord of an action
+func (k *KeymapService) ResetKeyBinding(action string) error {
+	if _, ok := keyActionByID(action); !ok {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	overrides := k.overrides()
+	delete(overrides, action)
+	return k.saveOverrides(overrides)
+}
+
+// ResetKeymap restores every default chord
+func (k *KeymapService) ResetKeymap() error {
+	return k.saveOverrides(map[string]string{})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// settingSystemKnownHosts enables reading from and appending to ~/.ssh/known_hosts
+const settingSystemKnownHosts = "ssh_system_known_hosts"
+
+// systemKnownHostsPath returns the OpenSSH user known_hosts file location
+func systemKnownHostsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// systemKnownHostsEnabled reports whether two-way sync with the system file is on
+func (h *HostKeyService) systemKnownHostsEnabled() bool {
+	return settingBool(h.db, settingSystemKnownHosts)
+}
+
+// checkSystemKnownHosts looks the key up in ~/.ssh/known_hosts.
+// Returns status "match", "unknown", "mismatch" or "revoked" and, for mismatches,
+// the fingerprint the file expects.
+func checkSystemKnownHosts(host string, port int, remote net.Addr, key ssh.PublicKey) (string, string) {
+	path, err := systemKnownHostsPath()
+	if err != nil {
+		return "unknown", ""
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "unknown", ""
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return "unknown", ""
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	if remote == nil {
+		remote = &net.TCPAddr{IP: net.ParseIP(host), Port: port}
+	}
+
+	err = callback(address, remote, key)
+	if err == nil {
+		return "match", ""
+	}
+
+	var revokedErr *knownhosts.RevokedError
+	if errors.As(err, &revokedErr) {
+		return "revoked", ""
+	}
+
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) {
+		// Only a recorded key of the same type counts as a conflict
+		for _, want := range keyErr.Want {
+			if want.Key.Type() == key.Type() {
+				return "mismatch", ssh.FingerprintSHA256(want.Key)
+			}
+		}
+	}
+	return "unknown", ""
+}
+
+// appendSystemKnownHost adds a trusted key to ~/.ssh/known_hosts unless it is already present
+func appendSystemKnownHost(host string, port int, key ssh.PublicKey) error {
+	if status, _ := checkSystemKnownHosts(host, port, nil, key); status == "match" {
+		return nil
+	}
+
+	path, err := systemKnownHostsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create ssh directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	// Make sure we start on a fresh line if the file lacks a trailing newline
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		if data, err := os.ReadFile(path); err == nil && !strings.HasSuffix(string(data), "\n") {
+			if _, err := f.WriteString("\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	address := knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))
+	_, err = f.WriteString(knownhosts.Line([]string{address}, key) + "\n")
+	return err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// settingHostKeyMaxAge is the number of days a trusted host key stays valid before
+// it should be re-verified; 0 or unset disables the age check
+const settingHostKeyMaxAge = "ssh_host_key_max_age_days"
+
+func (h *HostKeyService) setupKnownHostReview() {
+	// Edit note/alias/review date: {id, note, alias, reviewBy:"YYYY-MM-DD" or ""}
+	h.app.Event.On("ssh:known_hosts:update", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		if data == nil {
+			return
+		}
+		id := toInt(data["id"])
+		note, _ := data["note"].(string)
+		alias, _ := data["alias"].(string)
+		reviewBy, err := parseReviewDate(data["reviewBy"])
+		if err == nil {
+			err = h.db.UpdateKnownHostDetails(id, strings.TrimSpace(note), strings.TrimSpace(alias), reviewBy)
+		}
+		if err != nil {
+			h.app.Event.Emit("ssh:known_hosts:error", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		h.emitKnownHostsList()
+	})
+
+	// Confirm a key was re-verified out of band: {id, reviewBy?}
+	h.app.Event.On("ssh:known_hosts:mark_reviewed", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		if data == nil {
+			return
+		}
+		reviewBy, err := parseReviewDate(data["reviewBy"])
+		if err == nil {
+			err = h.db.MarkKnownHostVerified(toInt(data["id"]), reviewBy)
+		}
+		if err != nil {
+			h.app.Event.Emit("ssh:known_hosts:error", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		h.emitKnownHostsList()
+	})
+
+	// Check all known hosts for overdue reviews (e.g. when the frontend starts)
+	h.app.Event.On("ssh:known_hosts:review:check", func(e *application.CustomEvent) {
+		list, err := h.db.ListKnownHosts()
+		if err != nil {
+			h.app.Event.Emit("ssh:known_hosts:error", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		maxAge := h.hostKeyMaxAge()
+		items := make([]map[string]interface{}, 0)
+		for i := range list {
+			if due, reason := knownHostReviewDue(&list[i], maxAge, time.Now()); due {
+				items = append(items, knownHostReviewItem(&list[i], reason))
+			}
+		}
+		if len(items) > 0 {
+			h.app.Event.Emit("ssh:known_hosts:review_due", map[string]interface{}{
+				"items": items,
+			})
+		}
+	})
+}
+
+// hostKeyMaxAge returns the configured trust lifetime, or 0 when disabled
+func (h *HostKeyService) hostKeyMaxAge() time.Duration {
+	return time.Duration(settingInt(h.db, settingHostKeyMaxAge)) * 24 * time.Hour
+}
+
+// knownHostReviewDue reports whether a trusted key needs re-verification and why
+func knownHostReviewDue(kh *database.KnownHost, maxAge time.Duration, now time.Time) (bool, string) {
+	if kh.ReviewBy != nil && now.After(*kh.ReviewBy) {
+		return true, "review_date"
+	}
+	if maxAge > 0 && now.Sub(kh.TrustedSince()) > maxAge {
+		return true, "max_age"
+	}
+	return false, ""
+}
+
+func knownHostReviewItem(kh *database.KnownHost, reason string) map[string]interface{} {
+	item := map[string]interface{}{
+		"id":           kh.ID,
+		"host":         kh.Host,
+		"port":         kh.Port,
+		"alias":        kh.Alias,
+		"fingerprint":  kh.Fingerprint,
+		"trustedSince": kh.TrustedSince().Unix(),
+		"reason":       reason, // "review_date" or "max_age"
+	}
+	if kh.ReviewBy != nil {
+		item["reviewBy"] = kh.ReviewBy.Format("2006-01-02")
+	}
+	return item
+}
+
+// remindIfReviewDue emits a reminder when a host just used for a connection is due for review
+func (h *HostKeyService) remindIfReviewDue(kh *database.KnownHost) {
+	if due, reason := knownHostReviewDue(kh, h.hostKeyMaxAge(), time.Now()); due {
+		h.app.Event.Emit("ssh:known_hosts:review_due", map[string]interface{}{
+			"items": []map[string]interface{}{knownHostReviewItem(kh, reason)},
+		})
+	}
+}
+
+// parseReviewDate accepts "YYYY-MM-DD", RFC3339 or an empty value (no date)
+func parseReviewDate(v interface{}) (*time.Time, error) {
+	s, _ := v.(string)
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid review date %q", s)
+	}
+	return &t, nil
+}
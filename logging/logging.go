@@ -0,0 +1,126 @@
+// Package logging sets up the app's structured logger: leveled slog records,
+// tagged with the subsystem that wrote them, written to stderr and to a rotating
+// file in the config directory.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// FileName is the name of the current log file in the log directory
+const FileName = "term.log"
+
+var (
+	// level is shared by every logger, so it can be changed at runtime
+	level = new(slog.LevelVar)
+	// output is the handler records end up in; Init replaces it
+	output atomic.Pointer[slog.Handler]
+	file   *rotatingFile
+	dir    string
+)
+
+func init() {
+	setOutput(os.Stderr)
+}
+
+func setOutput(w io.Writer) {
+	var h slog.Handler = slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+	output.Store(&h)
+}
+
+// Init starts writing logs to logDir/term.log as well as stderr, and routes the
+// standard log package through slog, so existing log.Printf calls end up in the
+// file at info level.
+func Init(logDir string) error {
+	f, err := openRotatingFile(filepath.Join(logDir, FileName), maxFileSize, maxBackups)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	file, dir = f, logDir
+	setOutput(io.MultiWriter(os.Stderr, f))
+	slog.SetDefault(slog.New(&handler{}))
+	return nil
+}
+
+// For returns the logger of a subsystem; its records carry component=name.
+// Loggers can be created before Init, e.g. in package variables.
+func For(component string) *slog.Logger {
+	return slog.New(&handler{}).With("component", component)
+}
+
+// ParseLevel reads a level name: debug, info, warn or error, in any case
+func ParseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return l, nil
+}
+
+// SetLevel changes the minimum level of every logger
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Level returns the current minimum level
+func Level() slog.Level {
+	return level.Level()
+}
+
+// Dir returns the directory the log files are written to, "" before Init
+func Dir() string {
+	return dir
+}
+
+// Close closes the log file; later records only go to stderr
+func Close() error {
+	if file == nil {
+		return nil
+	}
+	setOutput(os.Stderr)
+	return file.Close()
+}
+
+// handler forwards records to the current output, so loggers created before Init
+// write to the file too. Attributes and groups are replayed on the output. Every
+// record passes through here, log.Printf included, so secrets are redacted on the
+// way (see redact.go).
+type handler struct {
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) current() slog.Handler {
+	out := *output.Load()
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	return out
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, redactRecord(r))
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := append(append([]func(slog.Handler) slog.Handler{}, h.ops...), op)
+	return &handler{ops: ops}
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	attrs = redactAttrs(attrs)
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+)
+
+// Redacted replaces secret values in log text
+const Redacted = "[REDACTED]"
+
+var (
+	// key=value and "key": "value" pairs whose key names a secret
+	secretPairPattern = regexp.MustCompile(`(?i)("?[\w.-]*(?:password|passwd|passphrase|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credential)[\w.-]*"?\s*[=:]\s*)(\[REDACTED\]|"[^"]*"|[^\s,;&}\]]+)`)
+	// Authorization header values
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`)
+	// user:password@ in URLs
+	urlCredentialsPattern = regexp.MustCompile(`://[^/\s:@]+:[^/\s@]+@`)
+	// Tokens recognizable by their format: AWS access key IDs, GitHub, GitLab,
+	// Slack and OpenAI-style API tokens, JWTs
+	tokenPattern = regexp.MustCompile(`\b(?:(?:AKIA|ASIA)[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,}|glpat-[A-Za-z0-9_-]{20,}|xox[abprs]-[A-Za-z0-9-]{10,}|sk-[A-Za-z0-9_-]{20,}|eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]+)`)
+	// PEM private key blocks
+	privateKeyPattern = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`)
+)
+
+// Redact masks passwords, tokens and private keys in s
+func Redact(s string) string {
+	s = privateKeyPattern.ReplaceAllString(s, Redacted)
+	s = bearerPattern.ReplaceAllString(s, "$1 "+Redacted)
+	s = urlCredentialsPattern.ReplaceAllString(s, "://"+Redacted+"@")
+	s = tokenPattern.ReplaceAllString(s, Redacted)
+	return secretPairPattern.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+// secretKeyPattern matches attribute keys whose value is a secret as a whole
+var secretKeyPattern = regexp.MustCompile(`(?i)(password|passwd|passphrase|secret|token|api[_-]?key|private[_-]?key|credential|authorization)`)
+
+// redactRecord returns r with its message and attributes redacted
+func redactRecord(r slog.Record) slog.Record {
+	out := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	out.AddAttrs(redactAttrs(attrs)...)
+	return out
+}
+
+func redactAttrs(attrs []slog.Attr) []slog.Attr {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(a)
+	}
+	return out
+}
+
+// redactAttr masks an attribute whose key names a secret, and secrets inside
+// string and formatted values such as maps logged whole. Errors and other
+// values are formatted first, as their text is what ends up in the log.
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redactAttrs(v.Group())...)}
+	case slog.KindString, slog.KindAny:
+		if secretKeyPattern.MatchString(a.Key) {
+			return slog.String(a.Key, Redacted)
+		}
+		text := v.String()
+		if v.Kind() == slog.KindAny {
+			text = fmt.Sprintf("%+v", v.Any())
+		}
+		if redacted := Redact(text); redacted != text || v.Kind() == slog.KindString {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
@@ -1,6 +1,16 @@
 package main
 
-import "log"
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"term/logging"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
 
 const (
 	LevelDebug = "DEBUG"
@@ -9,25 +19,127 @@ const (
 	LevelError = "ERROR"
 )
 
-var (
-	loggingLevel = LevelInfo
+const (
+	settingLogLevel = "log_level"
+	// logLevelEnv overrides the log_level setting when set, e.g. to debug startup
+	logLevelEnv = "TERM_LOG_LEVEL"
 )
 
-type LoggingService struct{}
+var frontendLog = logging.For("frontend")
+
+// LogLevelStatus is the effective log level and whether the environment sets it
+type LogLevelStatus struct {
+	Level   string `json:"level"`   // debug, info, warn or error
+	FromEnv bool   `json:"fromEnv"` // TERM_LOG_LEVEL is set; the setting has no effect
+}
+
+// LoggingService lets the frontend write to the app log and controls the level
+type LoggingService struct {
+	settings *SettingsService
+	app      *application.App
+	envLevel string
+}
+
+// NewLoggingService applies the log level of TERM_LOG_LEVEL, or else of the
+// log_level setting, and follows changes of the setting
+func NewLoggingService(settings *SettingsService) *LoggingService {
+	g := &LoggingService{settings: settings}
+	if env := strings.ToLower(strings.TrimSpace(os.Getenv(logLevelEnv))); env != "" {
+		if _, err := logging.ParseLevel(env); err != nil {
+			frontendLog.Warn("ignoring "+logLevelEnv, "err", err)
+		} else {
+			g.envLevel = env
+		}
+	}
+	g.applyLevel()
+	settings.OnSettingChanged(func(key string) {
+		if key == settingLogLevel {
+			g.applyLevel()
+		}
+	})
+	return g
+}
+
+// SetApp sets the Wails application instance
+func (g *LoggingService) SetApp(app *application.App) {
+	g.app = app
+}
+
+func (g *LoggingService) applyLevel() {
+	name := g.envLevel
+	if name == "" {
+		name = g.settings.GetString(settingLogLevel)
+	}
+	l, err := logging.ParseLevel(name)
+	if err != nil {
+		l = slog.LevelInfo
+	}
+	if l == logging.Level() {
+		return
+	}
+	logging.SetLevel(l)
+	if g.app != nil {
+		g.app.Event.Emit("logging:level", g.GetLogLevel())
+	}
+}
+
+// levelName writes a level the way the log_level setting stores it
+func levelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+// GetLogLevel returns the effective log level
+func (g *LoggingService) GetLogLevel() LogLevelStatus {
+	return LogLevelStatus{Level: levelName(logging.Level()), FromEnv: g.envLevel != ""}
+}
+
+// SetLogLevel stores the log level setting; it applies at once unless
+// TERM_LOG_LEVEL overrides it
+func (g *LoggingService) SetLogLevel(level string) error {
+	return g.settings.Set(settingLogLevel, strings.ToLower(strings.TrimSpace(level)))
+}
+
+// FrontendLogEntry is a frontend log message with the context it happened in.
+// Tab is the terminal session ID and Session the tree node, the same keys backend
+// records of that tab carry, so both sides of a problem line up in the log.
+type FrontendLogEntry struct {
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Tab     string            `json:"tab,omitempty"`
+	Session string            `json:"session,omitempty"`
+	Source  string            `json:"source,omitempty"` // component or store that logged
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+// LogEntry writes a frontend message with its tab, session and attributes
+func (g *LoggingService) LogEntry(entry FrontendLogEntry) {
+	l, err := logging.ParseLevel(entry.Level)
+	if err != nil {
+		l = slog.LevelInfo
+	}
+	attrs := make([]any, 0, 2*(3+len(entry.Attrs)))
+	for _, kv := range [][2]string{{"tab", entry.Tab}, {"session", entry.Session}, {"source", entry.Source}} {
+		if kv[1] != "" {
+			attrs = append(attrs, kv[0], kv[1])
+		}
+	}
+	keys := make([]string, 0, len(entry.Attrs))
+	for k := range entry.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, k, entry.Attrs[k])
+	}
+	frontendLog.Log(context.Background(), l, entry.Message, attrs...)
+}
 
+// Log writes a frontend message at the given level (DEBUG, INFO, WARN or ERROR);
+// messages below the log level are dropped
 func (g *LoggingService) Log(message string, level string) {
-	// levels := map[string]int{
-	// 	LevelInfo:  1,
-	// 	LevelDebug: 2,
-	// 	LevelWarn:  3,
-	// 	LevelError: 4,
-	// }
-
-	// if _, ok := levels[level]; !ok {
-	// 	return // Invalid level
-	// }
-
-	// if levels[level] >= levels[loggingLevel] {
-	log.Printf("[%s] %s", level, message)
-	// }
+	l, err := logging.ParseLevel(level)
+	if err != nil {
+		l = slog.LevelInfo
+	}
+	frontendLog.Log(context.Background(), l, message)
 }
@@ -4,12 +4,18 @@ import (
 	"embed"
 	_ "embed"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"term/database"
+	"term/logging"
 
 	"github.com/wailsapp/wails/v3/pkg/application"
+	"github.com/wailsapp/wails/v3/pkg/events"
+	"github.com/wailsapp/wails/v3/pkg/services/notifications"
 )
 
 //go:embed all:frontend/dist
@@ -19,10 +25,35 @@ func init() {
 	// Register terminal events
 	application.RegisterEvent[map[string]interface{}]("terminal:data")
 	application.RegisterEvent[map[string]interface{}]("terminal:exit")
+	application.RegisterEvent[map[string]interface{}]("terminal:share")
 	application.RegisterEvent[map[string]interface{}]("terminal:error")
+	application.RegisterEvent[map[string]interface{}]("terminal:title")
 
 	// Register system stats event
 	application.RegisterEvent[SystemStats]("system:stats")
+	application.RegisterEvent[SystemStats]("system:stats:session")
+	application.RegisterEvent[StatsAlert]("stats:alert")
+
+	// Any setting written, with its new value (see settings_registry.go)
+	application.RegisterEvent[SettingChange]("settings:changed")
+	application.RegisterEvent[LogLevelStatus]("logging:level")
+	// Tabs opened by the term CLI (see ui_open.go)
+	application.RegisterEvent[map[string]interface{}]("ui:open_session")
+	application.RegisterEvent[map[string]interface{}]("ui:open_adhoc")
+	application.RegisterEvent[map[string]interface{}]("ui:open_error")
+	application.RegisterEvent[map[string]interface{}]("terminal:moved")
+	application.RegisterEvent[map[string]interface{}]("terminal:added")
+	application.RegisterEvent[map[string]interface{}]("windows:changed")
+	application.RegisterEvent[map[string]interface{}]("update:available")
+	application.RegisterEvent[map[string]interface{}]("update:progress")
+	application.RegisterEvent[map[string]interface{}]("update:ready")
+	application.RegisterEvent[map[string]interface{}]("update:error")
+	application.RegisterEvent[map[string]string]("keymap:changed")
+	application.RegisterEvent[map[string]interface{}]("settings:profile")
+
+	// Theme in effect changed, e.g. when auto mode follows the OS appearance
+	application.RegisterEvent[Theme]("theme:changed")
+	application.RegisterEvent[Theme]("theme:updated")
 
 	// SSH host key verification events
 	application.RegisterEvent[map[string]interface{}]("ssh:hostkey_prompt")
@@ -30,6 +61,68 @@ func init() {
 	application.RegisterEvent[map[string]interface{}]("ssh:known_hosts:list:request")
 	application.RegisterEvent[map[string]interface{}]("ssh:known_hosts:list")
     application.RegisterEvent[map[string]interface{}]("ssh:known_hosts:delete")
+    application.RegisterEvent[map[string]interface{}]("ssh:keyscan:start")
+    application.RegisterEvent[map[string]interface{}]("ssh:keyscan:started")
+    application.RegisterEvent[map[string]interface{}]("ssh:keyscan:progress")
+    application.RegisterEvent[map[string]interface{}]("ssh:keyscan:result")
+    application.RegisterEvent[map[string]interface{}]("ssh:keyscan:commit")
+    application.RegisterEvent[map[string]interface{}]("ssh:keyscan:committed")
+    application.RegisterEvent[map[string]interface{}]("ssh:keyscan:error")
+    application.RegisterEvent[map[string]interface{}]("ssh:hostkeys:updated")
+    application.RegisterEvent[map[string]interface{}]("ssh:hostkey_audit:list:request")
+    application.RegisterEvent[map[string]interface{}]("ssh:hostkey_audit:list")
+    application.RegisterEvent[map[string]interface{}]("ssh:known_hosts:update")
+    application.RegisterEvent[map[string]interface{}]("ssh:known_hosts:mark_reviewed")
+    application.RegisterEvent[map[string]interface{}]("ssh:known_hosts:review:check")
+    application.RegisterEvent[map[string]interface{}]("ssh:known_hosts:review_due")
+    application.RegisterEvent[map[string]interface{}]("vault:status:request")
+    application.RegisterEvent[map[string]interface{}]("vault:status")
+    application.RegisterEvent[map[string]interface{}]("vault:unlock")
+    application.RegisterEvent[map[string]interface{}]("vault:unlocked")
+    application.RegisterEvent[map[string]interface{}]("vault:lock")
+    application.RegisterEvent[map[string]interface{}]("vault:locked")
+    application.RegisterEvent[map[string]interface{}]("vault:activity")
+    application.RegisterEvent[map[string]interface{}]("vault:setup")
+    application.RegisterEvent[map[string]interface{}]("vault:disable")
+    application.RegisterEvent[map[string]interface{}]("vault:recording_passphrase:set")
+    application.RegisterEvent[map[string]interface{}]("vault:database_encryption:set")
+    application.RegisterEvent[map[string]interface{}]("vault:unlock_required")
+    application.RegisterEvent[map[string]interface{}]("vault:setup_required")
+    application.RegisterEvent[map[string]interface{}]("vault:error")
+    application.RegisterEvent[map[string]interface{}]("database:unlocked")
+    application.RegisterEvent[map[string]interface{}]("startup:recovered")
+
+    // Session tree events
+    application.RegisterEvent[map[string]interface{}]("sessions:inventory:synced")
+    application.RegisterEvent[map[string]interface{}]("sessions:usage:updated")
+    application.RegisterEvent[map[string]interface{}]("snippets:changed")
+    application.RegisterEvent[map[string]interface{}]("groups:changed")
+    application.RegisterEvent[map[string]interface{}]("group:status")
+    application.RegisterEvent[map[string]interface{}]("group:done")
+    application.RegisterEvent[map[string]interface{}]("nettools:output")
+    application.RegisterEvent[map[string]interface{}]("nettools:done")
+    application.RegisterEvent[map[string]interface{}]("assistant:result")
+
+    // Health check events
+    application.RegisterEvent[map[string]interface{}]("health:status")
+    application.RegisterEvent[map[string]interface{}]("session:wol")
+
+    // Guacamole events
+    application.RegisterEvent[map[string]interface{}]("guacd:unreachable")
+    application.RegisterEvent[map[string]interface{}]("guacd:status")
+    application.RegisterEvent[map[string]interface{}]("rdp:drive:download")
+    application.RegisterEvent[map[string]interface{}]("rdp:drive:upload")
+    application.RegisterEvent[map[string]interface{}]("vnc:listen")
+    application.RegisterEvent[map[string]interface{}]("desktop:credentials")
+    application.RegisterEvent[map[string]interface{}]("guac:shared")
+    application.RegisterEvent[map[string]interface{}]("guac:tunnel")
+
+    // Scheduled command events
+    application.RegisterEvent[map[string]interface{}]("schedule:run")
+    application.RegisterEvent[map[string]interface{}]("schedule:failed")
+
+    // Sync events
+    application.RegisterEvent[map[string]interface{}]("sync:status")
 
     // Recording events
     application.RegisterEvent[map[string]interface{}]("recording:start")
@@ -45,6 +138,7 @@ func init() {
     application.RegisterEvent[map[string]interface{}]("recording:replay:header")
     application.RegisterEvent[map[string]interface{}]("recording:replay:output")
     application.RegisterEvent[map[string]interface{}]("recording:replay:resize")
+    application.RegisterEvent[map[string]interface{}]("recording:replay:marker")
     application.RegisterEvent[map[string]interface{}]("recording:replay:ended")
     application.RegisterEvent[map[string]interface{}]("recording:replay:meta")
     application.RegisterEvent[map[string]interface{}]("recording:replay:progress")
@@ -63,8 +157,14 @@ func init() {
     application.RegisterEvent[map[string]interface{}]("keys:list")
     application.RegisterEvent[map[string]interface{}]("keys:delete")
     application.RegisterEvent[map[string]interface{}]("keys:deleted")
+    application.RegisterEvent[map[string]interface{}]("keys:set_default")
+    application.RegisterEvent[map[string]interface{}]("keys:default_set")
     application.RegisterEvent[map[string]interface{}]("keys:export:public")
     application.RegisterEvent[map[string]interface{}]("keys:public_key")
+    application.RegisterEvent[map[string]interface{}]("keys:backup:export")
+    application.RegisterEvent[map[string]interface{}]("keys:backup:exported")
+    application.RegisterEvent[map[string]interface{}]("keys:backup:restore")
+    application.RegisterEvent[map[string]interface{}]("keys:backup:restored")
     application.RegisterEvent[map[string]interface{}]("keys:error")
     application.RegisterEvent[map[string]interface{}]("recording:share")
     application.RegisterEvent[map[string]interface{}]("recording:shared")
@@ -77,6 +177,20 @@ func init() {
 }
 
 func main() {
+	// `term <command>` talks to the running app instead of opening a window
+	if code, ok := runCLI(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
+	// After an update, the new version starts once everything below is closed
+	removeReplacedExecutable()
+	var restartAfterUpdate bool
+	defer func() {
+		if restartAfterUpdate {
+			restartApp()
+		}
+	}()
+
 	// Get data directory for database
 	dataDir, err := os.UserConfigDir()
 	if err != nil {
@@ -84,17 +198,43 @@ func main() {
 	}
 	dbPath := filepath.Join(dataDir, "term", "term.db")
 
+	// Structured logs go to a rotating file next to the database
+	if err := logging.Init(filepath.Join(dataDir, "term", "logs")); err != nil {
+		log.Printf("File logging disabled: %v", err)
+	}
+
+	// Stops the services in order on quit; the final steps close what they all use
+	shutdown := NewShutdownManager()
+	shutdown.AddFinal("logging", func() { _ = logging.Close() })
+	defer shutdown.Finish()
+
+	// WAL files of a database that is gone would be applied to a new one
+	danglingDB := removeDanglingDBFiles(dbPath)
+
 	// Initialize database
 	db, err := database.New(dbPath)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
-	defer db.Close()
+	shutdown.AddFinal("database", func() {
+		if err := db.Close(); err != nil {
+			shutdownLog.Error("failed to close database", "err", err)
+		}
+	})
+
+	// Encryption of credentials stored in session configs
+	secretStore := NewSecretStore(db)
+	// External secret managers sessions can take their credentials from
+	credentialProviders := NewCredentialProviderService(db, secretStore)
 
 	// Create services
-	sessionService := NewSessionService(db)
+	sessionService := NewSessionService(db, secretStore)
 	settingsService := NewSettingsService(db)
-	loggingService := &LoggingService{}
+	// Values of config.toml / config.json override the stored settings
+	settingsService.applyConfigOverlay(filepath.Join(dataDir, "term"))
+	loggingService := NewLoggingService(settingsService)
+	// ssh:// and sftp:// links opened from other apps
+	linkHandler := NewLinkHandler(sessionService)
 
 	// Create Wails application
 	app := application.New(application.Options{
@@ -102,8 +242,10 @@ func main() {
 		Description: "A desktop terminal manager with session management",
 		Services: []application.Service{
 			application.NewService(sessionService),
+			application.NewService(credentialProviders),
 			application.NewService(settingsService),
 			application.NewService(loggingService),
+			application.NewService(linkHandler),
 		},
 		Assets: application.AssetOptions{
 			Handler: application.AssetFileServerFS(assets),
@@ -113,70 +255,209 @@ func main() {
 		},
 		SingleInstance: &application.SingleInstanceOptions{
 			UniqueID:      "dd231868-8745-42a5-a173-4b2f7565b82c",
-			EncryptionKey: [32]byte{}, // TODO: set encryption key at build time
+			EncryptionKey: loadInstanceKey(filepath.Join(dataDir, "term")),
+			// Links clicked while the app runs start a second instance, which
+			// forwards its arguments here and exits; starting the app again
+			// brings back a window hidden to the tray
+			OnSecondInstanceLaunch: func(data application.SecondInstanceData) {
+				linkHandler.HandleArgs(data.Args)
+				linkHandler.focusWindow()
+			},
 		},
+		// Runs after the services were shut down, also on macOS, where Run never returns
+		PostShutdown: shutdown.Finish,
 	})
 
+    secretStore.SetApp(app)
+    settingsService.SetApp(app)
+    sessionService.SetApp(app)
+    loggingService.SetApp(app)
+    linkHandler.SetApp(app)
+    linkHandler.HandleArgs(os.Args[1:])
+    // macOS passes links through an event instead of the arguments
+    app.Event.OnApplicationEvent(events.Common.ApplicationLaunchedWithUrl, func(e *application.ApplicationEvent) {
+        linkHandler.HandleURL(e.Context().URL())
+    })
+    sessionService.StartInventoryWatch()
+    sessionService.StartTrashPurge()
+
+    // Clean up after a crash of the previous run; this is the only instance now
+    startupCleanup := NewStartupCleanup(app, db, dbPath, filepath.Join(dataDir, "term", "run"), danglingDB)
+    runJournal := startupCleanup.Run()
+    shutdown.AddFinal("run journal", runJournal.Close)
+    app.RegisterService(application.NewService(startupCleanup))
+
     // Host key service for SSH verification
     hostKeyService := NewHostKeyService(app, db)
 
     // Recording service for binary terminal recordings
-    recordingService := NewRecordingService(app, db)
+    recordingService := NewRecordingService(app, db, secretStore)
     app.RegisterService(application.NewService(recordingService))
 
     // Key management service for secure recording sharing
-    keyMgmtService := NewKeyManagementService(db, app)
+    keyMgmtService := NewKeyManagementService(db, app, secretStore)
     keyMgmtService.Setup()
     app.RegisterService(application.NewService(keyMgmtService))
 
     // Create terminal service (needs app instance for events and host key verification and recorder)
-    terminalService := NewTerminalService(app, hostKeyService, recordingService)
+    terminalService := NewTerminalService(app, db, hostKeyService, recordingService, secretStore)
     app.RegisterService(application.NewService(terminalService))
+    terminalService.runJournal = runJournal
+    terminalService.history = newCommandHistory(db)
+    terminalService.StartTransferAccounting()
+
+	// Scheduler for commands run on SSH sessions on a cron schedule
+	schedulerService := NewSchedulerService(app, db, terminalService)
+	app.RegisterService(application.NewService(schedulerService))
+	schedulerService.Start()
+
+	// Snippet library, typed into one terminal or a broadcast group
+	app.RegisterService(application.NewService(NewSnippetService(app, db, terminalService)))
+
+	// Connection groups, connecting a set of sessions with one action
+	app.RegisterService(application.NewService(NewConnectionGroupService(app, db, terminalService)))
+
+	// Searchable history of the commands run in every terminal
+	historyService := NewHistoryService(db)
+	app.RegisterService(application.NewService(historyService))
+	historyService.StartPurge()
+
+	// Ping, traceroute, DNS and port checks for connectivity triage
+	app.RegisterService(application.NewService(NewNetworkToolsService(app, db)))
+
+	// Command suggestions and error explanations from a language model
+	app.RegisterService(application.NewService(NewAssistantService(app, db, secretStore, terminalService)))
 
 	sftpService := NewSFTPService(app, terminalService)
+	sftpService.runJournal = runJournal
 	app.RegisterService(application.NewService(sftpService))
 
     // Create theme service (needs app context)
     themeService := NewThemeService(app.Context(), settingsService)
+    themeService.SetApp(app)
     app.RegisterService(application.NewService(themeService))
 
+	// User-customizable keyboard shortcuts
+	keymapService := NewKeymapService(settingsService)
+	keymapService.SetApp(app)
+	app.RegisterService(application.NewService(keymapService))
+
+	// Sync of sessions, settings and user themes across devices
+	syncService := NewSyncService(app, db, secretStore, filepath.Join(dataDir, "term"), filepath.Join(dataDir, "term", "themes"))
+	app.RegisterService(application.NewService(syncService))
+	syncService.StartSyncLoop()
+
+	// Stats interval, metric toggles and panel visibility, shared by both stats services
+	statsConfig := newStatsConfig(db)
+
+	// Alert thresholds on collected stats, reported with desktop notifications
+	notifier := notifications.New()
+	app.RegisterService(application.NewService(notifier))
+	statsAlertService := NewStatsAlertService(db, terminalService, recordingService, notifier)
+	statsAlertService.SetApp(app)
+	settingsService.OnSettingChanged(func(key string) {
+		switch {
+		case key == settingStatsAlertRules:
+			statsAlertService.reloadRules()
+		case strings.HasPrefix(key, "stats_"):
+			statsConfig.reload()
+		}
+	})
+	app.RegisterService(application.NewService(statsAlertService))
+
 	// Create and start system stats service (needs terminal service to check session types)
-	systemStatsService := NewSystemStatsService(terminalService)
+	systemStatsService := NewSystemStatsService(terminalService, statsConfig)
 	systemStatsService.SetApp(app)
+	systemStatsService.SetAlerts(statsAlertService)
 	app.RegisterService(application.NewService(systemStatsService))
 	systemStatsService.Start()
 
 	// Create and start remote stats service (for monitoring SSH remote machines)
-	remoteStatsService := NewRemoteStatsService(terminalService)
+	remoteStatsService := NewRemoteStatsService(terminalService, statsConfig)
 	remoteStatsService.SetApp(app)
+	remoteStatsService.SetAlerts(statsAlertService)
 	app.RegisterService(application.NewService(remoteStatsService))
 	remoteStatsService.Start()
 
+	// Create and start the health check service (reachability of remote sessions)
+	healthCheckService := NewHealthCheckService(db)
+	healthCheckService.SetApp(app)
+	app.RegisterService(application.NewService(healthCheckService))
+	healthCheckService.Start()
+
+	// Create the guacd manager (launches and supervises guacd when guacd_mode asks for it)
+	guacdManager := NewGuacdManager(db)
+	guacdManager.SetApp(app)
+	app.RegisterService(application.NewService(guacdManager))
+	guacdManager.Start()
+
+	// Updates from the release feed, installed when the app shuts down
+	updateService := NewUpdateService(db, filepath.Join(dataDir, "term", "updates"))
+	updateService.SetApp(app)
+	app.RegisterService(application.NewService(updateService))
+	updateService.Start()
+
+	// Diagnostics bundle for bug reports
+	app.RegisterService(application.NewService(NewDiagnosticsService(db, dbPath, settingsService, guacdManager)))
+
 	// Create Guacamole service and HTTP server
 	guacService := NewGuacamoleService(sessionService)
-	httpServer := NewHTTPServer(3000, guacService, terminalService)
+	app.RegisterService(application.NewService(guacService))
+	httpAddr := httpListenAddress(db)
+	socketPath := httpSocketPath(db, filepath.Join(dataDir, "term"))
+	tlsConfig, fingerprint, err := httpTLSConfig(db, filepath.Join(dataDir, "term"))
+	if socketPath != "" {
+		// Other clients use the socket; TCP only serves the app's window on loopback
+		httpAddr, tlsConfig = net.JoinHostPort(defaultHTTPAddress, "0"), nil
+	} else if err != nil {
+		// Never serve beyond this machine in cleartext when TLS was asked for
+		log.Printf("HTTP server TLS disabled, listening on loopback only: %v", err)
+		_, port, _ := net.SplitHostPort(httpAddr)
+		httpAddr = net.JoinHostPort(defaultHTTPAddress, port)
+	}
+	httpServer := NewHTTPServer(httpAddr, guacService, terminalService, sftpService)
+	if tlsConfig != nil {
+		httpServer.SetTLS(tlsConfig, fingerprint)
+	}
+	if socketPath != "" {
+		httpServer.SetSocket(socketPath)
+	}
+	httpServer.SetDiscoveryFile(filepath.Join(dataDir, "term", cliDiscoveryFile))
+	app.RegisterService(application.NewService(NewAPIService(httpServer)))
 	if err := httpServer.Start(); err != nil {
 		log.Printf("Failed to start HTTP server: %v", err)
 	}
-	defer httpServer.Stop()
-
-	// Create main window
-	app.Window.NewWithOptions(application.WebviewWindowOptions{
-		Title: "Terminal Manager",
-		Mac: application.MacWindow{
-			InvisibleTitleBarHeight: 50,
-			Backdrop:                application.MacBackdropTranslucent,
-			TitleBar:                application.MacTitleBarDefault,
-		},
-		BackgroundColour: application.NewRGB(27, 38, 54),
-		URL:              "/",
-		Width:            1200,
-		Height:           800,
+
+	// Create main window; WindowService opens more
+	windowService := NewWindowService(app, db, terminalService)
+	app.RegisterService(application.NewService(windowService))
+	mainWindow := windowService.NewMainWindow()
+
+	// Tray menu of favorite and recent sessions; keeps the app running in background mode
+	NewTray(app, db, sessionService, mainWindow)
+
+	// On quit: stop taking requests and background work, let transfers finish,
+	// finish recordings, then end the sessions they use. Added last, this runs
+	// after the windows saved their state.
+	shutdown.Add("http server", 10*time.Second, func() { _ = httpServer.Stop() })
+	shutdown.Add("background tasks", 10*time.Second, func() {
+		systemStatsService.Stop()
+		remoteStatsService.Stop()
+		healthCheckService.Stop()
+		schedulerService.Stop()
+		updateService.Stop()
+		syncService.Stop()
 	})
+	shutdown.Add("transfers", 0, sftpService.shutdown) // bounded by sftpDrainTimeout
+	shutdown.Add("recordings", 5*time.Second, recordingService.stopAll)
+	shutdown.Add("sessions", sessionCloseGrace+5*time.Second, func() { terminalService.closeAll(sessionCloseGrace) })
+	shutdown.Add("guacd", 10*time.Second, guacdManager.Stop)
+	app.OnShutdown(shutdown.Run)
 
 	// Run the application
 	err = app.Run()
 	if err != nil {
 		log.Fatal(err)
 	}
+	restartAfterUpdate = updateService.FinishUpdate()
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// mRemoteNG encrypts passwords with this password unless the user set their own
+const mremotengDefaultPassword = "mR3m"
+
+type mremotengFile struct {
+	Protected          string          `xml:"Protected,attr"`
+	EncryptionEngine   string          `xml:"EncryptionEngine,attr"`
+	BlockCipherMode    string          `xml:"BlockCipherMode,attr"`
+	KdfIterations      string          `xml:"KdfIterations,attr"`
+	FullFileEncryption string          `xml:"FullFileEncryption,attr"`
+	Nodes              []mremotengNode `xml:"Node"`
+	Content            string          `xml:",chardata"`
+}
+
+type mremotengNode struct {
+	Name     string          `xml:"Name,attr"`
+	Type     string          `xml:"Type,attr"` // "Container" or "Connection"
+	Protocol string          `xml:"Protocol,attr"`
+	Hostname string          `xml:"Hostname,attr"`
+	Port     string          `xml:"Port,attr"`
+	Username string          `xml:"Username,attr"`
+	Password string          `xml:"Password,attr"`
+	Domain   string          `xml:"Domain,attr"`
+	Nodes    []mremotengNode `xml:"Node"`
+}
+
+// ImportMRemoteNG creates sessions from an mRemoteNG confCons.xml, keeping its folder
+// structure below an "mRemoteNG" folder. SSH, RDP, VNC and telnet connections are
+// imported; opts.Password is needed when the file is protected with a custom password.
+func (s *SessionService) ImportMRemoteNG(opts ConnectionImportOptions) (*SessionImportResult, error) {
+	data, err := os.ReadFile(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read confCons.xml: %w", err)
+	}
+	var file mremotengFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse confCons.xml: %w", err)
+	}
+
+	password := opts.Password
+	if password == "" {
+		password = mremotengDefaultPassword
+	}
+	decrypt := func(value string) (string, error) {
+		return mremotengDecrypt(value, password, file.KdfIterations)
+	}
+	if file.Protected != "" {
+		if _, err := decrypt(file.Protected); err != nil {
+			if opts.Password == "" {
+				return nil, fmt.Errorf("confCons.xml is protected with a password")
+			}
+			return nil, fmt.Errorf("wrong password for confCons.xml")
+		}
+	}
+
+	if strings.EqualFold(file.FullFileEncryption, "true") {
+		plain, err := decrypt(strings.TrimSpace(file.Content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt confCons.xml: %w", err)
+		}
+		var inner struct {
+			Nodes []mremotengNode `xml:"Node"`
+		}
+		if err := xml.Unmarshal([]byte("<Nodes>"+plain+"</Nodes>"), &inner); err != nil {
+			return nil, fmt.Errorf("failed to parse decrypted confCons.xml: %w", err)
+		}
+		file.Nodes = inner.Nodes
+	}
+
+	result := &SessionImportResult{}
+	nodes := mremotengNodes(file.Nodes, decrypt, result)
+	err = s.importIntoFolder("mRemoteNG", nodes, opts, result)
+	return result, err
+}
+
+func mremotengNodes(in []mremotengNode, decrypt func(string) (string, error), result *SessionImportResult) []SessionExportNode {
+	var out []SessionExportNode
+	for _, n := range in {
+		if strings.EqualFold(n.Type, "Container") {
+			out = append(out, SessionExportNode{
+				Name:     n.Name,
+				Type:     "folder",
+				Children: mremotengNodes(n.Nodes, decrypt, result),
+			})
+			continue
+		}
+		node, ok := mremotengSessionNode(n, decrypt)
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		out = append(out, node)
+	}
+	return out
+}
+
+// mremotengSessionNode maps an mRemoteNG connection onto the equivalent session config
+func mremotengSessionNode(n mremotengNode, decrypt func(string) (string, error)) (SessionExportNode, bool) {
+	node := SessionExportNode{Name: n.Name, Type: "session", Config: map[string]string{}}
+	if n.Hostname == "" {
+		return node, false
+	}
+	password := ""
+	if n.Password != "" {
+		if plain, err := decrypt(n.Password); err == nil {
+			password = plain
+		} else {
+			log.Printf("Could not decrypt mRemoteNG password for %q: %v", n.Name, err)
+		}
+	}
+
+	set := func(key, value string) {
+		if value != "" {
+			node.Config[key] = value
+		}
+	}
+	switch strings.ToUpper(n.Protocol) {
+	case "SSH1", "SSH2":
+		node.SessionType = "ssh"
+		set("ssh_host", n.Hostname)
+		set("ssh_port", orDefault(n.Port, "22"))
+		set("ssh_username", n.Username)
+		set("ssh_auth_method", "password")
+		set("ssh_password", password)
+	case "RDP":
+		node.SessionType = "rdp"
+		set("rdp_host", n.Hostname)
+		set("rdp_port", orDefault(n.Port, "3389"))
+		set("rdp_username", n.Username)
+		set("rdp_password", password)
+		set("rdp_domain", n.Domain)
+	case "VNC":
+		node.SessionType = "vnc"
+		set("vnc_host", n.Hostname)
+		set("vnc_port", orDefault(n.Port, "5900"))
+		set("vnc_password", password)
+	case "TELNET":
+		node.SessionType = "telnet"
+		set("telnet_host", n.Hostname)
+		set("telnet_port", orDefault(n.Port, "23"))
+		set("telnet_username", n.Username)
+		set("telnet_password", password)
+	default:
+		log.Printf("Skipping mRemoteNG connection %q: unsupported protocol %s", n.Name, n.Protocol)
+		return node, false
+	}
+	return node, true
+}
+
+// mremotengDecrypt opens a value written by mRemoteNG's AES-GCM provider:
+// base64(salt[16] | nonce[16] | ciphertext+tag), key = PBKDF2-SHA1(password, salt)
+func mremotengDecrypt(value, password, iterations string) (string, error) {
+	raw, err := decodeB64(value)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < 16+16+16 {
+		return "", fmt.Errorf("encrypted value too short")
+	}
+	iter, err := strconv.Atoi(iterations)
+	if err != nil || iter <= 0 {
+		iter = 1000
+	}
+	salt, nonce, ct := raw[:16], raw[16:32], raw[32:]
+
+	key := pbkdf2.Key([]byte(password), salt, iter, 32, sha1.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return "", err
+	}
+	plain, err := gcm.Open(nil, nonce, ct, salt)
+	if err != nil {
+		return "", fmt.Errorf("decryption failed (wrong password or unsupported cipher)")
+	}
+	return string(plain), nil
+}
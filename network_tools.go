@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+	"term/logging"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+var netToolsLog = logging.For("nettools")
+
+const (
+	netToolsMaxJobs     = 8
+	netToolsTimeout     = 2 * time.Minute // longest a ping or traceroute runs
+	netToolsPingMax     = 100
+	netToolsPortTimeout = 3 * time.Second
+	netToolsMaxPorts    = 1024
+)
+
+// dnsRecordTypes are the record types DNSLookup resolves
+var dnsRecordTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT", "NS", "PTR", "SRV"}
+
+// NetworkTarget is what a network tool runs against: a session's host, or any
+// host. Port defaults to the session's port for port checks.
+type NetworkTarget struct {
+	SessionID string `json:"sessionId,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Port      int    `json:"port,omitempty"`
+}
+
+// NetworkToolsService runs ping, traceroute, DNS lookups and TCP port checks
+// from this machine, for connectivity triage without a local shell. Each run is
+// a job: its results stream as nettools:output events and it ends with
+// nettools:done.
+type NetworkToolsService struct {
+	app *application.App
+	db  *database.DB
+
+	mu   sync.Mutex
+	next int
+	jobs map[string]context.CancelFunc
+}
+
+// NewNetworkToolsService creates the network tools service
+func NewNetworkToolsService(app *application.App, db *database.DB) *NetworkToolsService {
+	return &NetworkToolsService{app: app, db: db, jobs: make(map[string]context.CancelFunc)}
+}
+
+// Ping pings a target count times with the system ping and returns the job ID
+func (s *NetworkToolsService) Ping(target NetworkTarget, count int) (string, error) {
+	host, _, err := s.resolveTarget(target)
+	if err != nil {
+		return "", err
+	}
+	count = min(max(count, 1), netToolsPingMax)
+	name, args := "ping", []string{"-c", strconv.Itoa(count), host}
+	if runtime.GOOS == "windows" {
+		args = []string{"-n", strconv.Itoa(count), host}
+	}
+	return s.start("ping", host, func(ctx context.Context, job string) error {
+		return s.runCommand(ctx, job, "ping", name, args...)
+	})
+}
+
+// Traceroute traces the route to a target with the system traceroute and returns
+// the job ID
+func (s *NetworkToolsService) Traceroute(target NetworkTarget) (string, error) {
+	host, _, err := s.resolveTarget(target)
+	if err != nil {
+		return "", err
+	}
+	name, args := "traceroute", []string{"-n", host}
+	switch {
+	case runtime.GOOS == "windows":
+		name, args = "tracert", []string{"-d", host}
+	case runtime.GOOS == "linux":
+		// traceroute is often not installed; tracepath needs no privileges
+		if _, err := exec.LookPath("traceroute"); err != nil {
+			name, args = "tracepath", []string{"-n", host}
+		}
+	}
+	return s.start("traceroute", host, func(ctx context.Context, job string) error {
+		return s.runCommand(ctx, job, "traceroute", name, args...)
+	})
+}
+
+// DNSLookup resolves a name's records of one type (A, AAAA, CNAME, MX, TXT, NS,
+// PTR or SRV) and returns the job ID. A target with a session looks up its host.
+func (s *NetworkToolsService) DNSLookup(target NetworkTarget, recordType string) (string, error) {
+	host, _, err := s.resolveTarget(target)
+	if err != nil {
+		return "", err
+	}
+	recordType = strings.ToUpper(strings.TrimSpace(recordType))
+	if recordType == "" {
+		recordType = "A"
+	}
+	valid := false
+	for _, t := range dnsRecordTypes {
+		valid = valid || t == recordType
+	}
+	if !valid {
+		return "", fmt.Errorf("unsupported record type %q", recordType)
+	}
+	return s.start("dns", host, func(ctx context.Context, job string) error {
+		records, err := lookupRecords(ctx, host, recordType)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			s.emitLine(job, "dns", fmt.Sprintf("no %s records for %s", recordType, host))
+		}
+		for _, record := range records {
+			s.emitLine(job, "dns", recordType+"\t"+record)
+		}
+		return nil
+	})
+}
+
+// CheckPorts tries a TCP connection to each port of a target and returns the job
+// ID; without ports the session's port is checked
+func (s *NetworkToolsService) CheckPorts(target NetworkTarget, ports []int) (string, error) {
+	host, port, err := s.resolveTarget(target)
+	if err != nil {
+		return "", err
+	}
+	if len(ports) == 0 && port > 0 {
+		ports = []int{port}
+	}
+	if len(ports) == 0 {
+		return "", fmt.Errorf("no port to check")
+	}
+	if len(ports) > netToolsMaxPorts {
+		return "", fmt.Errorf("too many ports (%d, max %d)", len(ports), netToolsMaxPorts)
+	}
+	for _, p := range ports {
+		if p < 1 || p > 65535 {
+			return "", fmt.Errorf("invalid port %d", p)
+		}
+	}
+	return s.start("ports", host, func(ctx context.Context, job string) error {
+		dialer := net.Dialer{Timeout: netToolsPortTimeout}
+		for _, p := range ports {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			start := time.Now()
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(p)))
+			result := map[string]interface{}{
+				"jobId": job,
+				"tool":  "ports",
+				"port":  p,
+				"open":  err == nil,
+			}
+			if err == nil {
+				conn.Close()
+				latency := time.Since(start).Milliseconds()
+				result["latencyMs"] = latency
+				result["line"] = fmt.Sprintf("%d/tcp open (%d ms)", p, latency)
+			} else {
+				result["error"] = err.Error()
+				result["line"] = fmt.Sprintf("%d/tcp closed: %v", p, err)
+			}
+			s.app.Event.Emit("nettools:output", result)
+		}
+		return nil
+	})
+}
+
+// CancelNetworkTool stops a running job
+func (s *NetworkToolsService) CancelNetworkTool(job string) {
+	s.mu.Lock()
+	cancel := s.jobs[job]
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// ServiceShutdown stops the running jobs
+func (s *NetworkToolsService) ServiceShutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.jobs {
+		cancel()
+	}
+	return nil
+}
+
+// resolveTarget returns the host and port to run a tool against. Hosts are
+// passed to system tools as arguments, so anything but a host name or an IP
+// address is refused.
+func (s *NetworkToolsService) resolveTarget(target NetworkTarget) (string, int, error) {
+	host := strings.TrimSpace(target.Host)
+	port := target.Port
+	if host == "" && target.SessionID != "" {
+		node, err := s.db.GetSession(target.SessionID)
+		if err != nil {
+			return "", 0, fmt.Errorf("session not found: %w", err)
+		}
+		cfg, err := s.db.GetEffectiveConfig(node.ID)
+		if err != nil {
+			return "", 0, err
+		}
+		sessionType := ""
+		if node.SessionType != nil {
+			sessionType = *node.SessionType
+		}
+		h, p, ok := sessionEndpoint(sessionType, cfg)
+		if !ok {
+			return "", 0, fmt.Errorf("%s has no host", node.Name)
+		}
+		host = h
+		if port == 0 {
+			port = p
+		}
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("host is required")
+	}
+	if !validNetworkHost(host) {
+		return "", 0, fmt.Errorf("invalid host %q", host)
+	}
+	return host, port, nil
+}
+
+var (
+	// hostNamePattern matches DNS names: dot-separated labels of letters, digits,
+	// underscores and inner hyphens
+	hostNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?)*\.?$`)
+	// ipZonePattern matches the zone of a link-local IPv6 address, e.g. eth0
+	ipZonePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// validNetworkHost reports whether host is a host name or an IP address. Nothing
+// else is accepted, as ping and traceroute read arguments starting with "-", and
+// their Windows counterparts also "/", as options.
+func validNetworkHost(host string) bool {
+	addr, zone, hasZone := strings.Cut(host, "%")
+	if net.ParseIP(addr) != nil {
+		return !hasZone || ipZonePattern.MatchString(zone)
+	}
+	return len(host) <= 253 && hostNamePattern.MatchString(host)
+}
+
+// start runs a job in the background and returns its ID
+func (s *NetworkToolsService) start(tool, host string, run func(ctx context.Context, job string) error) (string, error) {
+	s.mu.Lock()
+	if len(s.jobs) >= netToolsMaxJobs {
+		s.mu.Unlock()
+		return "", fmt.Errorf("too many network tools running (max %d)", netToolsMaxJobs)
+	}
+	s.next++
+	job := fmt.Sprintf("net-%d", s.next)
+	ctx, cancel := context.WithTimeout(context.Background(), netToolsTimeout)
+	s.jobs[job] = cancel
+	s.mu.Unlock()
+
+	netToolsLog.Info("network tool started", "job", job, "tool", tool, "host", host)
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.jobs, job)
+			s.mu.Unlock()
+			cancel()
+		}()
+		err := run(ctx, job)
+		done := map[string]interface{}{"jobId": job, "tool": tool, "host": host}
+		switch {
+		case ctx.Err() == context.Canceled:
+			done["error"] = "cancelled"
+		case ctx.Err() == context.DeadlineExceeded:
+			done["error"] = fmt.Sprintf("timed out after %s", netToolsTimeout)
+		case err != nil:
+			done["error"] = err.Error()
+		}
+		s.app.Event.Emit("nettools:done", done)
+	}()
+	return job, nil
+}
+
+// runCommand runs a system tool and streams its output line by line
+func (s *NetworkToolsService) runCommand(ctx context.Context, job, tool, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setCmdNoWindow(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s is not available: %w", name, err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			s.emitLine(job, tool, line)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		// ping exits non-zero when replies are lost; the output tells how many
+		if exitErr, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+			return fmt.Errorf("%s exited with code %d", name, exitErr.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *NetworkToolsService) emitLine(job, tool, line string) {
+	s.app.Event.Emit("nettools:output", map[string]interface{}{
+		"jobId": job,
+		"tool":  tool,
+		"line":  line,
+	})
+}
+
+// lookupRecords resolves the records of one type with the system resolver
+func lookupRecords(ctx context.Context, name, recordType string) ([]string, error) {
+	var r net.Resolver
+	var records []string
+	switch recordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			records = append(records, ip.String())
+		}
+	case "CNAME":
+		cname, err := r.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, cname)
+	case "MX":
+		mxs, err := r.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			records = append(records, fmt.Sprintf("%d %s", mx.Pref, mx.Host))
+		}
+	case "TXT":
+		return r.LookupTXT(ctx, name)
+	case "NS":
+		nss, err := r.LookupNS(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nss {
+			records = append(records, ns.Host)
+		}
+	case "PTR":
+		return r.LookupAddr(ctx, name)
+	case "SRV":
+		_, srvs, err := r.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			records = append(records, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target))
+		}
+	}
+	return records, nil
+}
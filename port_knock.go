@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"term/logging"
+)
+
+var knockLog = logging.For("knock")
+
+// Port knocking config keys, for SSH hosts whose port a knock daemon such as
+// knockd only opens after a sequence of connection attempts
+const (
+	sshKnockKey       = "ssh_knock_sequence"
+	sshKnockDelayKey  = "ssh_knock_delay_ms" // between knocks, unless a step sets its own
+	defaultKnockDelay = 200
+	knockTCPTimeout   = 500 * time.Millisecond
+	knockMaxDelay     = 60000
+	knockMaxSteps     = 32
+)
+
+var knockFields = []ConfigField{
+	{Key: sshKnockKey, Label: "Port knock sequence", Type: fieldString, check: checkKnockSequence},
+	{Key: sshKnockDelayKey, Label: "Delay between knocks (ms)", Type: fieldInt, Default: strconv.Itoa(defaultKnockDelay), Min: intPtr(0), Max: intPtr(knockMaxDelay)},
+}
+
+// knockStep is one knock: a port, its protocol, and how long to wait after it
+type knockStep struct {
+	port    int
+	network string // tcp or udp
+	delay   time.Duration
+}
+
+// parseKnockSequence parses ssh_knock_sequence: comma-separated knocks in order,
+// each port[/tcp|/udp][:delay in ms], e.g. "7000,8000/udp,9000/tcp:500". Knocks
+// without a delay wait defaultDelay before the next one and before connecting.
+func parseKnockSequence(sequence string, defaultDelay time.Duration) ([]knockStep, error) {
+	var steps []knockStep
+	for _, part := range strings.Split(sequence, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		step := knockStep{network: "tcp", delay: defaultDelay}
+		spec, delay, hasDelay := strings.Cut(part, ":")
+		if hasDelay {
+			ms, err := strconv.Atoi(strings.TrimSpace(delay))
+			if err != nil || ms < 0 || ms > knockMaxDelay {
+				return nil, fmt.Errorf("invalid delay in knock %q", part)
+			}
+			step.delay = time.Duration(ms) * time.Millisecond
+		}
+		port, network, hasNetwork := strings.Cut(spec, "/")
+		if hasNetwork {
+			step.network = strings.ToLower(strings.TrimSpace(network))
+			if step.network != "tcp" && step.network != "udp" {
+				return nil, fmt.Errorf("knock %q: protocol must be tcp or udp", part)
+			}
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(port))
+		if err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in knock %q", part)
+		}
+		step.port = n
+		steps = append(steps, step)
+	}
+	if len(steps) > knockMaxSteps {
+		return nil, fmt.Errorf("knock sequence is too long (%d knocks, max %d)", len(steps), knockMaxSteps)
+	}
+	return steps, nil
+}
+
+func checkKnockSequence(value string) error {
+	_, err := parseKnockSequence(value, 0)
+	return err
+}
+
+// knockPorts runs the session's knock sequence against host, if it has one, and
+// returns the address to connect to. Knocks are sent from this machine even when
+// the SSH connection goes through a proxy. The host is resolved once so that every
+// knock and the connection after them reach the same address.
+func knockPorts(host string, cfg map[string]string) (string, error) {
+	sequence := strings.TrimSpace(cfg[sshKnockKey])
+	if sequence == "" {
+		return host, nil
+	}
+	delay := defaultKnockDelay
+	if v := strings.TrimSpace(cfg[sshKnockDelayKey]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("invalid %s: %q", sshKnockDelayKey, v)
+		}
+		delay = n
+	}
+	steps, err := parseKnockSequence(sequence, time.Duration(delay)*time.Millisecond)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", sshKnockKey, err)
+	}
+	if len(steps) == 0 {
+		return host, nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return "", fmt.Errorf("failed to resolve %s for port knocking: %w", host, err)
+	}
+	ip := ips[0].String()
+	knockLog.Info("knocking", "host", host, "addr", ip, "knocks", len(steps))
+	for _, step := range steps {
+		if err := knock(ip, step); err != nil {
+			return "", fmt.Errorf("knock on %d/%s failed: %w", step.port, step.network, err)
+		}
+		time.Sleep(step.delay)
+	}
+	return ip, nil
+}
+
+// knock sends one knock. A TCP knock is a connection attempt, usually refused
+// or dropped, which is all the knock daemon needs to see; a UDP knock is a
+// single datagram.
+func knock(ip string, step knockStep) error {
+	addr := net.JoinHostPort(ip, strconv.Itoa(step.port))
+	if step.network == "udp" {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Write([]byte{0})
+		return err
+	}
+	if conn, err := net.DialTimeout("tcp", addr, knockTCPTimeout); err == nil {
+		conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// Process list settings
+const (
+	topProcessCount    = 10               // processes included with each stats tick
+	killConfirmTimeout = 30 * time.Second // how long a kill request waits for its confirmation
+)
+
+// ProcessInfo is one entry of the process list in SystemStats
+type ProcessInfo struct {
+	PID           int32   `json:"pid"`
+	Name          string  `json:"name"`
+	User          string  `json:"user"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	MemoryRSS     uint64  `json:"memoryRss"`
+}
+
+// sortProcesses orders processes by "memory" or, by default, "cpu", the other metric
+// breaking ties, and keeps the first n
+func sortProcesses(procs []ProcessInfo, by string, n int) []ProcessInfo {
+	sort.Slice(procs, func(i, j int) bool {
+		a, b := procs[i], procs[j]
+		if by == "memory" {
+			if a.MemoryRSS != b.MemoryRSS {
+				return a.MemoryRSS > b.MemoryRSS
+			}
+			return a.CPUPercent > b.CPUPercent
+		}
+		if a.CPUPercent != b.CPUPercent {
+			return a.CPUPercent > b.CPUPercent
+		}
+		return a.MemoryRSS > b.MemoryRSS
+	})
+	if len(procs) > n {
+		procs = procs[:n]
+	}
+	return procs
+}
+
+// processSampler lists the local processes. CPU usage is measured between two
+// calls, so the gopsutil handles are kept from one tick to the next.
+type processSampler struct {
+	mu    sync.Mutex
+	procs map[int32]*process.Process
+}
+
+func (s *processSampler) top(by string, n int) []ProcessInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pids, err := process.Pids()
+	if err != nil {
+		return nil
+	}
+	if s.procs == nil {
+		s.procs = make(map[int32]*process.Process)
+	}
+	alive := make(map[int32]*process.Process, len(pids))
+	list := make([]ProcessInfo, 0, len(pids))
+	for _, pid := range pids {
+		p, ok := s.procs[pid]
+		if !ok {
+			if p, err = process.NewProcess(pid); err != nil {
+				continue
+			}
+		}
+		alive[pid] = p
+		info := ProcessInfo{PID: pid}
+		info.CPUPercent, _ = p.Percent(0)
+		if m, err := p.MemoryInfo(); err == nil {
+			info.MemoryRSS = m.RSS
+		}
+		list = append(list, info)
+	}
+	s.procs = alive
+
+	// Names and owners are only looked up for the processes shown
+	list = sortProcesses(list, by, n)
+	for i := range list {
+		p := alive[list[i].PID]
+		list[i].Name, _ = p.Name()
+		list[i].User, _ = p.Username()
+		if pct, err := p.MemoryPercent(); err == nil {
+			list[i].MemoryPercent = float64(pct)
+		}
+	}
+	return list
+}
+
+// parsePSProcesses parses `ps -eo pid=,user=,pcpu=,pmem=,rss=,comm=` lines; rss is
+// in KiB and the command name may contain spaces
+func parsePSProcesses(lines []string) []ProcessInfo {
+	var procs []ProcessInfo
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		pid, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		info := ProcessInfo{PID: int32(pid), User: fields[1], Name: strings.Join(fields[5:], " ")}
+		info.CPUPercent, _ = strconv.ParseFloat(fields[2], 64)
+		info.MemoryPercent, _ = strconv.ParseFloat(fields[3], 64)
+		rss, _ := strconv.ParseUint(fields[4], 10, 64)
+		info.MemoryRSS = rss * 1024
+		procs = append(procs, info)
+	}
+	return procs
+}
+
+// KillRequest describes a process about to be killed. Nothing happens until
+// KillProcess is called with its Token, which expires after killConfirmTimeout.
+type KillRequest struct {
+	Token     string    `json:"token"`
+	SessionID string    `json:"sessionId,omitempty"` // empty for local processes
+	PID       int32     `json:"pid"`
+	Name      string    `json:"name"`
+	User      string    `json:"user"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// killConfirmations holds kill requests until they are confirmed or expire, so a
+// process is never killed by a single call, e.g. a stray click
+type killConfirmations struct {
+	mu      sync.Mutex
+	pending map[string]KillRequest
+}
+
+func (k *killConfirmations) request(sessionID string, pid int32, name, user string) (KillRequest, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return KillRequest{}, err
+	}
+	req := KillRequest{
+		Token:     hex.EncodeToString(b),
+		SessionID: sessionID,
+		PID:       pid,
+		Name:      name,
+		User:      user,
+		ExpiresAt: time.Now().Add(killConfirmTimeout),
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.pending == nil {
+		k.pending = make(map[string]KillRequest)
+	}
+	for token, r := range k.pending {
+		if time.Now().After(r.ExpiresAt) {
+			delete(k.pending, token)
+		}
+	}
+	k.pending[req.Token] = req
+	return req, nil
+}
+
+// confirm consumes a kill request
+func (k *killConfirmations) confirm(token string) (KillRequest, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	req, ok := k.pending[token]
+	delete(k.pending, token)
+	if !ok || time.Now().After(req.ExpiresAt) {
+		return KillRequest{}, fmt.Errorf("kill request not found or expired; request it again")
+	}
+	return req, nil
+}
@@ -4,7 +4,9 @@ package main
 
 import (
     "io"
+    "os"
     "os/exec"
+    "syscall"
 
     ptylib "github.com/creack/pty"
 )
@@ -25,3 +27,9 @@ func startPTY(cmd *exec.Cmd, cols, rows uint16) (io.ReadWriteCloser, func(uint16
     }
     return f, resize, nil, nil, func(){}, nil
 }
+
+// hangupProcess sends SIGHUP, as a terminal does when its window closes, so
+// that the shell can save its history and end its jobs
+func hangupProcess(p *os.Process) error {
+    return p.Signal(syscall.SIGHUP)
+}
@@ -324,3 +324,9 @@ func defaultRows(r uint16) uint16 {
 	}
 	return r
 }
+
+// hangupProcess is not supported on Windows: console processes have no hangup
+// signal, and ConPTY shells end when their console is closed
+func hangupProcess(p *os.Process) error {
+	return errors.New("hangup not supported on windows")
+}
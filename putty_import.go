@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// PuTTY keeps saved sessions below this registry key, one subkey per session
+const puttySessionsKey = `Software\SimonTatham\PuTTY\Sessions`
+
+// ImportPuTTY creates SSH, telnet and serial sessions from PuTTY saved sessions,
+// placed in a "PuTTY" folder below opts.ParentID
+func (s *SessionService) ImportPuTTY(opts ConnectionImportOptions) (*SessionImportResult, error) {
+	var sessions []savedSession
+	var err error
+	if opts.Path != "" {
+		data, readErr := os.ReadFile(opts.Path)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read .reg file: %w", readErr)
+		}
+		sessions, err = parseRegSessions(data, puttySessionsKey)
+	} else {
+		sessions, err = readRegistrySessions(puttySessionsKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SessionImportResult{}
+	var nodes []SessionExportNode
+	for _, ps := range sessions {
+		node, ok := puttySessionNode(ps)
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	err = s.importIntoFolder("PuTTY", nodes, opts, result)
+	return result, err
+}
+
+// puttySessionNode maps a PuTTY session onto the equivalent session config
+func puttySessionNode(ps savedSession) (SessionExportNode, bool) {
+	v := ps.Values
+	node := SessionExportNode{Name: ps.Name, Type: "session", Config: map[string]string{}}
+
+	host := strings.TrimSpace(v["HostName"])
+	user := v["UserName"]
+	// PuTTY accepts "user@host" in the host name field
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		if user == "" {
+			user = host[:at]
+		}
+		host = host[at+1:]
+	}
+	port := regDword(v["PortNumber"])
+
+	protocol := strings.ToLower(v["Protocol"])
+	if protocol == "" {
+		protocol = "ssh"
+	}
+	switch protocol {
+	case "ssh":
+		if host == "" {
+			return node, false
+		}
+		node.SessionType = "ssh"
+		node.Config["ssh_host"] = host
+		node.Config["ssh_port"] = orDefault(port, "22")
+		if user != "" {
+			node.Config["ssh_username"] = user
+		}
+		if keyFile := v["PublicKeyFile"]; keyFile != "" {
+			node.Config["ssh_auth_method"] = "key"
+			node.Config["ssh_key_path"] = keyFile
+		} else {
+			node.Config["ssh_auth_method"] = "password"
+		}
+		puttyProxyConfig(v, node.Config)
+	case "telnet":
+		if host == "" {
+			return node, false
+		}
+		node.SessionType = "telnet"
+		node.Config["telnet_host"] = host
+		node.Config["telnet_port"] = orDefault(port, "23")
+		if user != "" {
+			node.Config["telnet_username"] = user
+		}
+	case "serial":
+		line := v["SerialLine"]
+		if line == "" {
+			return node, false
+		}
+		node.SessionType = "serial"
+		node.Config["serial_line"] = line
+		node.Config["serial_speed"] = orDefault(regDword(v["SerialSpeed"]), "9600")
+	default:
+		// raw, rlogin and bare connections have no equivalent
+		log.Printf("Skipping PuTTY session %q: unsupported protocol %s", ps.Name, protocol)
+		return node, false
+	}
+	return node, true
+}
+
+// puttyProxyConfig translates PuTTY's proxy settings into ssh_proxy_* config keys
+func puttyProxyConfig(v map[string]string, config map[string]string) {
+	var proxyType string
+	switch regDword(v["ProxyMethod"]) {
+	case "1":
+		proxyType = "socks4"
+	case "2":
+		proxyType = "socks5"
+	case "3":
+		proxyType = "http"
+	case "", "0":
+		return
+	default:
+		// telnet and local command proxies are not supported
+		log.Printf("Ignoring unsupported PuTTY proxy method %s", v["ProxyMethod"])
+		return
+	}
+	config["ssh_proxy_type"] = proxyType
+	config["ssh_proxy_host"] = v["ProxyHost"]
+	if port := regDword(v["ProxyPort"]); port != "" {
+		config["ssh_proxy_port"] = port
+	}
+	if v["ProxyUsername"] != "" {
+		config["ssh_proxy_username"] = v["ProxyUsername"]
+	}
+	if v["ProxyPassword"] != "" {
+		config["ssh_proxy_password"] = v["ProxyPassword"]
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+
+	"term/database"
+)
+
+// Offline processing of .trm recordings, for the `term recording` commands and
+// anything else that reads recordings outside of a replay
+
+// unwrapRecordingKey returns the file key of an encrypted recording, unwrapped
+// with the key derived from the recording passphrase and the KDF salt
+func unwrapRecordingKey(db *database.DB, recID int, salt []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	rk, err := db.GetRecordingKey(recID)
+	if err != nil {
+		return nil, fmt.Errorf("no key for recording %d: %w", recID, err)
+	}
+	master := deriveKeyArgon2([]byte(passphrase), salt, defaultArgon2)
+	fileKey, err := DecryptKeyGCM(master, rk.EncKey, rk.EncKeyNonce)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or damaged key")
+	}
+	return fileKey, nil
+}
+
+// isPlainTermrec reports whether a recording file starts with the termrec magic;
+// encrypted recordings start with their first GCM chunk instead
+func isPlainTermrec(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(termrecMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(magic, termrecMagic), nil
+}
+
+// recordingEvent is one event of a recording, at its time from the start
+type recordingEvent struct {
+	At      time.Duration
+	Type    byte // 'O' output, 'I' input, 'R' resize, 'M' marker
+	Payload []byte
+}
+
+// errRecordingTruncated is returned when a recording ends inside an event or an
+// encrypted chunk, as it does when the app was killed while recording
+var errRecordingTruncated = errors.New("recording is truncated")
+
+// walkRecording calls fn for every event of a termrec stream. A clean end of
+// the stream returns nil; a damaged chunk or event returns an error, after fn
+// has seen every event before it.
+func walkRecording(tr *TermrecReader, fn func(ev recordingEvent) error) error {
+	var at time.Duration
+	buf := make([]byte, 64*1024)
+	for {
+		delta, typ, payload, err := tr.ReadEvent(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return errRecordingTruncated
+		}
+		if err != nil {
+			return err
+		}
+		at += time.Duration(delta)
+		if err := fn(recordingEvent{At: at, Type: typ, Payload: payload}); err != nil {
+			return err
+		}
+	}
+}
+
+// recordingMarker is a labelled point in a recording
+type recordingMarker struct {
+	At    string `json:"at"`
+	Label string `json:"label"`
+}
+
+// recordingInfo summarizes a recording for `term recording info`
+type recordingInfo struct {
+	Path         string            `json:"path"`
+	SizeBytes    int64             `json:"sizeBytes"`
+	Encrypted    bool              `json:"encrypted"`
+	StartedAt    time.Time         `json:"startedAt"`
+	Cols         uint16            `json:"cols"`
+	Rows         uint16            `json:"rows"`
+	CaptureInput bool              `json:"captureInput"`
+	Duration     string            `json:"duration"`
+	Events       int               `json:"events"`
+	OutputBytes  int64             `json:"outputBytes"`
+	InputBytes   int64             `json:"inputBytes"`
+	Resizes      int               `json:"resizes"`
+	Markers      []recordingMarker `json:"markers,omitempty"`
+	Error        string            `json:"error,omitempty"` // e.g. truncated
+}
+
+// summarizeRecording reads a whole recording; the error of a damaged stream is
+// kept in the summary of the events read before it
+func summarizeRecording(path string, encrypted bool, hdr *TermrecHeaderRead, tr *TermrecReader) recordingInfo {
+	info := recordingInfo{
+		Path:         path,
+		Encrypted:    encrypted,
+		StartedAt:    time.Unix(0, hdr.StartUnixNano).UTC(),
+		Cols:         hdr.Cols,
+		Rows:         hdr.Rows,
+		CaptureInput: hdr.Flags&1 != 0,
+	}
+	var end time.Duration
+	err := walkRecording(tr, func(ev recordingEvent) error {
+		info.Events++
+		end = ev.At
+		switch ev.Type {
+		case 'O':
+			info.OutputBytes += int64(len(ev.Payload))
+		case 'I':
+			info.InputBytes += int64(len(ev.Payload))
+		case 'R':
+			info.Resizes++
+		case 'M':
+			info.Markers = append(info.Markers, recordingMarker{At: ev.At.Round(time.Millisecond).String(), Label: string(ev.Payload)})
+		}
+		return nil
+	})
+	info.Duration = end.Round(time.Millisecond).String()
+	if err != nil {
+		info.Error = err.Error()
+	}
+	return info
+}
+
+// asciicastWriter converts events to asciicast v2, the format of asciinema:
+// a JSON header line, then one [time, code, data] line per event
+type asciicastWriter struct {
+	w       *bufio.Writer
+	input   bool
+	pending map[byte][]byte // incomplete UTF-8 sequence at the end of the last chunk
+}
+
+func newAsciicastWriter(w io.Writer, hdr *TermrecHeaderRead, input bool) (*asciicastWriter, error) {
+	aw := &asciicastWriter{w: bufio.NewWriter(w), input: input, pending: make(map[byte][]byte)}
+	header := map[string]interface{}{
+		"version":   2,
+		"width":     hdr.Cols,
+		"height":    hdr.Rows,
+		"timestamp": time.Unix(0, hdr.StartUnixNano).Unix(),
+	}
+	return aw, aw.line(header)
+}
+
+func (aw *asciicastWriter) line(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	aw.w.Write(data)
+	return aw.w.WriteByte('\n')
+}
+
+func (aw *asciicastWriter) event(ev recordingEvent) error {
+	t := ev.At.Seconds()
+	switch ev.Type {
+	case 'O', 'I':
+		if ev.Type == 'I' && !aw.input {
+			return nil
+		}
+		// Chunks can end inside a multi-byte character; JSON strings need whole ones
+		data := append(aw.pending[ev.Type], ev.Payload...)
+		cut := incompleteUTF8Suffix(data)
+		aw.pending[ev.Type] = append([]byte(nil), data[len(data)-cut:]...)
+		data = data[:len(data)-cut]
+		if len(data) == 0 {
+			return nil
+		}
+		return aw.line([]interface{}{t, string(bytes.ToLower([]byte{ev.Type})), string(data)})
+	case 'R':
+		if len(ev.Payload) < 4 {
+			return nil
+		}
+		cols := uint16(ev.Payload[0]) | uint16(ev.Payload[1])<<8
+		rows := uint16(ev.Payload[2]) | uint16(ev.Payload[3])<<8
+		return aw.line([]interface{}{t, "r", fmt.Sprintf("%dx%d", cols, rows)})
+	case 'M':
+		return aw.line([]interface{}{t, "m", string(ev.Payload)})
+	}
+	return nil
+}
+
+func (aw *asciicastWriter) Flush() error {
+	return aw.w.Flush()
+}
+
+// incompleteUTF8Suffix returns the length of a multi-byte character cut off at
+// the end of p, 0 when p ends on a character boundary
+func incompleteUTF8Suffix(p []byte) int {
+	for n := 1; n <= utf8.UTFMax-1 && n <= len(p); n++ {
+		b := p[len(p)-n]
+		if utf8.RuneStart(b) {
+			if !utf8.FullRune(p[len(p)-n:]) {
+				return n
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// textWriter writes the output of a recording as plain text: escape sequences
+// are dropped and line endings normalized. Cursor movement is not replayed, so
+// full-screen programs come out garbled; shell sessions read fine.
+type textWriter struct {
+	w     *bufio.Writer
+	state int // textPlain, or inside an escape sequence
+	cr    bool
+}
+
+const (
+	textPlain = iota
+	textEscape
+	textCSI
+	textOSC
+	textOSCEscape
+)
+
+func newTextWriter(w io.Writer) *textWriter {
+	return &textWriter{w: bufio.NewWriter(w)}
+}
+
+func (tw *textWriter) event(ev recordingEvent) error {
+	if ev.Type != 'O' {
+		return nil
+	}
+	for _, b := range ev.Payload {
+		switch tw.state {
+		case textEscape:
+			switch b {
+			case '[':
+				tw.state = textCSI
+			case ']':
+				tw.state = textOSC
+			default:
+				tw.state = textPlain
+			}
+			continue
+		case textCSI:
+			if b >= 0x40 && b <= 0x7e {
+				tw.state = textPlain
+			}
+			continue
+		case textOSC:
+			if b == 0x07 {
+				tw.state = textPlain
+			} else if b == 0x1b {
+				tw.state = textOSCEscape
+			}
+			continue
+		case textOSCEscape:
+			tw.state = textPlain
+			continue
+		}
+
+		switch {
+		case b == 0x1b:
+			tw.state = textEscape
+			continue
+		case b == '\r':
+			tw.cr = true
+			continue
+		case b == '\n' || b == '\t' || b >= 0x20 && b != 0x7f:
+			if tw.cr && b != '\n' {
+				// A lone carriage return redraws the line, e.g. a progress bar
+				tw.w.WriteByte('\n')
+			}
+			tw.w.WriteByte(b)
+		}
+		tw.cr = false
+	}
+	return nil
+}
+
+func (tw *textWriter) Flush() error {
+	return tw.w.Flush()
+}
+
+// termrecCopier writes events to a plain termrec stream with their original
+// timing, e.g. to decrypt a recording
+type termrecCopier struct {
+	w    *bufio.Writer
+	last time.Duration
+}
+
+func newTermrecCopier(w io.Writer) *termrecCopier {
+	return &termrecCopier{w: bufio.NewWriter(w)}
+}
+
+func (c *termrecCopier) writeHeader(hdr *TermrecHeaderRead) error {
+	if _, err := c.w.Write(termrecMagic); err != nil {
+		return err
+	}
+	for _, v := range []interface{}{hdr.StartUnixNano, hdr.Cols, hdr.Rows, hdr.Flags} {
+		if err := binary.Write(c.w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *termrecCopier) event(ev recordingEvent) error {
+	delta := ev.At - c.last
+	c.last = ev.At
+	if err := writeUvarint(c.w, uint64(delta)); err != nil {
+		return err
+	}
+	if err := c.w.WriteByte(ev.Type); err != nil {
+		return err
+	}
+	if err := writeUvarint(c.w, uint64(len(ev.Payload))); err != nil {
+		return err
+	}
+	_, err := c.w.Write(ev.Payload)
+	return err
+}
+
+func (c *termrecCopier) Flush() error {
+	return c.w.Flush()
+}
+
+// findRecordingRow returns the database row of a recording file: the row with its
+// path, or else the only one with its file name, for a copy of the file
+func findRecordingRow(db *database.DB, path string) (*database.Recording, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := db.ListRecordings()
+	if err != nil {
+		return nil, err
+	}
+	var byName []database.Recording
+	for _, r := range recs {
+		if r.Path == abs {
+			return &r, nil
+		}
+		if filepath.Base(r.Path) == filepath.Base(abs) {
+			byName = append(byName, r)
+		}
+	}
+	if len(byName) == 1 {
+		return &byName[0], nil
+	}
+	return nil, fmt.Errorf("%s is not in the recordings database", filepath.Base(path))
+}
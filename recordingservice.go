@@ -1,8 +1,6 @@
 package main
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -12,12 +10,13 @@ import (
 	"time"
 
 	"term/database"
-
-	"log"
+	"term/logging"
 
 	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
+var recLog = logging.For("recording")
+
 type RecordingOptions struct {
 	SessionID    string
 	SessionName  string
@@ -46,6 +45,7 @@ type RecordingService struct {
 	mu      sync.Mutex
 	active  map[string]*activeRecording  // key: backend session id
 	replays map[string]*replayController // key: replayId -> controller
+	secrets *SecretStore
 }
 
 type replayController struct {
@@ -59,8 +59,8 @@ type replayCmd struct {
 	u64val uint64  // for seek target (nanoseconds)
 }
 
-func NewRecordingService(app *application.App, db *database.DB) *RecordingService {
-	rs := &RecordingService{app: app, db: db, active: make(map[string]*activeRecording), replays: make(map[string]*replayController)}
+func NewRecordingService(app *application.App, db *database.DB, secrets *SecretStore) *RecordingService {
+	rs := &RecordingService{app: app, db: db, active: make(map[string]*activeRecording), replays: make(map[string]*replayController), secrets: secrets}
 
 	// Event-based API for frontend without codegen
 	app.Event.On("recording:start", func(e *application.CustomEvent) {
@@ -76,6 +76,9 @@ func NewRecordingService(app *application.App, db *database.DB) *RecordingServic
 		capIn := toBool(data["captureInput"])
 		encrypt := toBool(data["encrypt"])
 		pass, _ := data["passphrase"].(string)
+		if encrypt && pass == "" {
+			pass = rs.vaultPassphrase()
+		}
 		_ = rs.Start(RecordingOptions{
 			SessionID: sid, SessionName: sname, SessionType: stype,
 			Cols: cols, Rows: rows, CaptureInput: capIn, Encrypt: encrypt, Passphrase: pass,
@@ -125,8 +128,11 @@ func NewRecordingService(app *application.App, db *database.DB) *RecordingServic
 			speed = v
 		}
 		pass, _ := data["passphrase"].(string)
+		if pass == "" {
+			pass = rs.vaultPassphrase()
+		}
 		replayId := fmt.Sprintf("replay-%d-%d", id, time.Now().UnixNano())
-		log.Printf("[REPLAY] start id=%d speed=%.2f encPass=%t replayId=%s", id, speed, pass != "", replayId)
+		recLog.Info("replay started", "id", id, "speed", speed, "passphrase", pass != "", "replay", replayId)
 		go rs.replay(replayId, id, speed, pass)
 	})
 
@@ -182,19 +188,19 @@ func (rs *RecordingService) Start(opts RecordingOptions) error {
 	defer rs.mu.Unlock()
 
 	if _, ok := rs.active[opts.SessionID]; ok {
-		log.Printf("[REC] already active for session=%s", opts.SessionID)
+		recLog.Warn("recording already active", "session", opts.SessionID)
 		return nil // already recording
 	}
 
 	// Ensure log dir
 	baseDir, err := os.UserConfigDir()
 	if err != nil {
-		log.Printf("[REC] user config dir error: %v", err)
+		recLog.Error("user config dir error", "err", err)
 		return err
 	}
 	logDir := filepath.Join(baseDir, "term", "logs")
 	if err := os.MkdirAll(logDir, 0700); err != nil {
-		log.Printf("[REC] mkdir logs failed: %v", err)
+		recLog.Error("mkdir logs failed", "err", err)
 		return err
 	}
 
@@ -204,7 +210,7 @@ func (rs *RecordingService) Start(opts RecordingOptions) error {
 	fpath := filepath.Join(logDir, fname)
 	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Printf("[REC] open file failed: %v", err)
+		recLog.Error("open file failed", "err", err)
 		return err
 	}
 
@@ -221,7 +227,7 @@ func (rs *RecordingService) Start(opts RecordingOptions) error {
 	if err != nil {
 		f.Close()
 		os.Remove(fpath)
-		log.Printf("[REC] db CreateRecording failed: %v", err)
+		recLog.Error("db CreateRecording failed", "err", err)
 		return err
 	}
 
@@ -234,14 +240,14 @@ func (rs *RecordingService) Start(opts RecordingOptions) error {
 		if err != nil {
 			f.Close()
 			os.Remove(fpath)
-			log.Printf("[REC] rand file key failed: %v", err)
+			recLog.Error("rand file key failed", "err", err)
 			return err
 		}
 		enc, err = NewChunkedAEADWriter(f, fileKey)
 		if err != nil {
 			f.Close()
 			os.Remove(fpath)
-			log.Printf("[REC] create AEAD writer failed: %v", err)
+			recLog.Error("create AEAD writer failed", "err", err)
 			return err
 		}
 		writer = enc
@@ -262,7 +268,7 @@ func (rs *RecordingService) Start(opts RecordingOptions) error {
 			if err != nil {
 				f.Close()
 				os.Remove(fpath)
-				log.Printf("[REC] ensure salt failed: %v", err)
+				recLog.Error("ensure salt failed", "err", err)
 				return err
 			}
 			master := deriveKeyArgon2([]byte(opts.Passphrase), salt, defaultArgon2)
@@ -271,14 +277,14 @@ func (rs *RecordingService) Start(opts RecordingOptions) error {
 			if err != nil {
 				f.Close()
 				os.Remove(fpath)
-				log.Printf("[REC] encrypt file key failed: %v", err)
+				recLog.Error("encrypt file key failed", "err", err)
 				return err
 			}
 			// Save wrapped key
 			if err := rs.db.SaveRecordingKey(recID, encKey, nonce, "AES-256-GCM", "argon2id"); err != nil {
 				f.Close()
 				os.Remove(fpath)
-				log.Printf("[REC] save recording key failed: %v", err)
+				recLog.Error("save recording key failed", "err", err)
 				return err
 			}
 		}
@@ -289,7 +295,7 @@ func (rs *RecordingService) Start(opts RecordingOptions) error {
 	if err != nil {
 		f.Close()
 		os.Remove(fpath)
-		log.Printf("[REC] create writer failed: %v", err)
+		recLog.Error("create writer failed", "err", err)
 		return err
 	}
 
@@ -297,7 +303,7 @@ func (rs *RecordingService) Start(opts RecordingOptions) error {
 		id: recID, file: f, writer: tr, encWriter: enc, size: 0, fileKey: fileKey, encrypted: opts.Encrypt, captureIn: opts.CaptureInput,
 	}
 
-	log.Printf("[REC] started id=%d path=%s enc=%t input=%t cols=%d rows=%d", recID, fpath, opts.Encrypt, opts.CaptureInput, opts.Cols, opts.Rows)
+	recLog.Info("recording started", "id", recID, "path", fpath, "encrypted", opts.Encrypt, "input", opts.CaptureInput, "cols", opts.Cols, "rows", opts.Rows)
 	rs.app.Event.Emit("recording:started", map[string]interface{}{
 		"sessionId": opts.SessionID, "id": recID, "path": fpath, "format": rec.Format,
 	})
@@ -312,13 +318,14 @@ func (rs *RecordingService) Stop(sessionID string) error {
 	if ar == nil {
 		return nil
 	}
-	// Close and finalize
+	// Flush, close and finalize
+	_ = ar.file.Sync()
 	fi, _ := ar.file.Stat()
 	size := fi.Size()
 	_ = rs.db.FinishRecording(ar.id, size)
 	ar.file.Close()
 	delete(rs.active, sessionID)
-	log.Printf("[REC] stopped id=%d size=%d", ar.id, size)
+	recLog.Info("recording stopped", "id", ar.id, "size", size)
 	rs.app.Event.Emit("recording:stopped", map[string]interface{}{
 		"sessionId": sessionID, "id": ar.id, "path": fi.Name(), "size": size,
 	})
@@ -327,6 +334,19 @@ func (rs *RecordingService) Stop(sessionID string) error {
 	return nil
 }
 
+// stopAll finishes every active recording, e.g. when the app quits
+func (rs *RecordingService) stopAll() {
+	rs.mu.Lock()
+	ids := make([]string, 0, len(rs.active))
+	for id := range rs.active {
+		ids = append(ids, id)
+	}
+	rs.mu.Unlock()
+	for _, id := range ids {
+		_ = rs.Stop(id)
+	}
+}
+
 func (rs *RecordingService) AppendOutput(sessionID string, data []byte) {
 	rs.mu.Lock()
 	ar := rs.active[sessionID]
@@ -335,7 +355,7 @@ func (rs *RecordingService) AppendOutput(sessionID string, data []byte) {
 		return
 	}
 	if err := ar.writer.WriteOutput(data); err != nil {
-		log.Printf("[REC] write output error: %v", err)
+		recLog.Error("write output error", "err", err)
 	}
 }
 
@@ -347,7 +367,7 @@ func (rs *RecordingService) AppendInput(sessionID string, data []byte) {
 		return
 	}
 	if err := ar.writer.WriteInput(data); err != nil {
-		log.Printf("[REC] write input error: %v", err)
+		recLog.Error("write input error", "err", err)
 	}
 }
 
@@ -359,7 +379,20 @@ func (rs *RecordingService) AppendResize(sessionID string, cols, rows uint16) {
 		return
 	}
 	if err := ar.writer.WriteResize(cols, rows); err != nil {
-		log.Printf("[REC] write resize error: %v", err)
+		recLog.Error("write resize error", "err", err)
+	}
+}
+
+// AppendMarker labels the current moment of a session's active recording
+func (rs *RecordingService) AppendMarker(sessionID string, label string) {
+	rs.mu.Lock()
+	ar := rs.active[sessionID]
+	rs.mu.Unlock()
+	if ar == nil {
+		return
+	}
+	if err := ar.writer.WriteMarker(label); err != nil {
+		recLog.Error("write marker error", "err", err)
 	}
 }
 
@@ -446,7 +479,7 @@ func (rs *RecordingService) emitList() {
 func (rs *RecordingService) replay(replayId string, recId int, speed float64, passphrase string) {
 	rec, err := rs.db.GetRecording(recId)
 	if err != nil || rec == nil {
-		log.Printf("[REPLAY] recording not found id=%d err=%v", recId, err)
+		recLog.Error("replay: recording not found", "id", recId, "err", err)
 		return
 	}
 	// Total duration
@@ -489,7 +522,7 @@ func (rs *RecordingService) replay(replayId string, recId int, speed float64, pa
 		for {
 			deltaNs, et, payload, err := tr.ReadEvent(buf)
 			if err != nil {
-				log.Printf("[REPLAY] read event err=%v after %d events", err, count)
+				recLog.Error("replay: read event failed", "events", count, "err", err)
 				return
 			}
 			wait := time.Duration(float64(deltaNs)) * time.Nanosecond
@@ -497,7 +530,7 @@ func (rs *RecordingService) replay(replayId string, recId int, speed float64, pa
 				wait = time.Duration(float64(wait) / curSpeed)
 			}
 			if count < 3 {
-				log.Printf("[REPLAY] evt #%d dt=%s type=%c size=%d", count+1, wait, et, len(payload))
+				recLog.Debug("replay event", "n", count+1, "wait", wait, "type", string(et), "size", len(payload))
 			}
 			// Handle pause/stop/rewind/speed
 			for {
@@ -674,6 +707,12 @@ func (rs *RecordingService) replay(replayId string, recId int, speed float64, pa
 						"rows":     rows,
 					})
 				}
+			case 'M':
+				rs.app.Event.Emit("recording:replay:marker", map[string]interface{}{
+					"replayId":  replayId,
+					"label":     string(payload),
+					"elapsedNs": elapsedNs + deltaNs,
+				})
 			}
 			elapsedNs += deltaNs
 			rs.app.Event.Emit("recording:replay:progress", map[string]interface{}{
@@ -709,52 +748,27 @@ func (rs *RecordingService) sendCtrl(replayId string, cmd replayCmd) {
 func (rs *RecordingService) openTermrec(rec *database.Recording, passphrase string) (*os.File, io.Reader, *TermrecReader, *TermrecHeaderRead, error) {
 	f, err := os.Open(rec.Path)
 	if err != nil {
-		log.Printf("[REPLAY] open file failed: %v", err)
+		recLog.Error("replay: open file failed", "err", err)
 		return nil, nil, nil, nil, err
 	}
 	var reader io.Reader = f
 	if rec.Encrypted {
-		row := rs.db.Conn().QueryRow(`SELECT enc_key, enc_key_nonce FROM recording_keys WHERE recording_id = ? LIMIT 1`, rec.ID)
-		var encKey, nonce []byte
-		if err := row.Scan(&encKey, &nonce); err != nil {
-			_ = f.Close()
-			log.Printf("[REPLAY] load wrapped key failed: %v", err)
-			return nil, nil, nil, nil, err
-		}
 		salt, err := rs.ensureMasterSalt()
 		if err != nil {
 			_ = f.Close()
-			log.Printf("[REPLAY] ensure salt failed: %v", err)
-			return nil, nil, nil, nil, err
-		}
-		if passphrase == "" {
-			_ = f.Close()
-			log.Printf("[REPLAY] empty passphrase for encrypted recording")
-			return nil, nil, nil, nil, fmt.Errorf("empty passphrase")
-		}
-		master := deriveKeyArgon2([]byte(passphrase), salt, defaultArgon2)
-		block, err := aes.NewCipher(master)
-		if err != nil {
-			_ = f.Close()
-			log.Printf("[REPLAY] new cipher failed: %v", err)
+			recLog.Error("replay: ensure salt failed", "err", err)
 			return nil, nil, nil, nil, err
 		}
-		aead, err := cipher.NewGCM(block)
+		fileKey, err := unwrapRecordingKey(rs.db, rec.ID, salt, passphrase)
 		if err != nil {
 			_ = f.Close()
-			log.Printf("[REPLAY] new gcm failed: %v", err)
-			return nil, nil, nil, nil, err
-		}
-		fileKey, err := aead.Open(nil, nonce, encKey, nil)
-		if err != nil {
-			_ = f.Close()
-			log.Printf("[REPLAY] unwrap key failed: %v", err)
+			recLog.Error("replay: unwrap key failed", "err", err)
 			return nil, nil, nil, nil, err
 		}
 		cr, err := NewChunkedAEADReader(f, fileKey)
 		if err != nil {
 			_ = f.Close()
-			log.Printf("[REPLAY] create AEAD reader failed: %v", err)
+			recLog.Error("replay: create AEAD reader failed", "err", err)
 			return nil, nil, nil, nil, err
 		}
 		reader = cr
@@ -762,13 +776,13 @@ func (rs *RecordingService) openTermrec(rec *database.Recording, passphrase stri
 	tr, err := NewTermrecReader(reader)
 	if err != nil {
 		_ = f.Close()
-		log.Printf("[REPLAY] new termrec reader failed: %v", err)
+		recLog.Error("replay: new termrec reader failed", "err", err)
 		return nil, nil, nil, nil, err
 	}
 	hdr, err := tr.ReadHeader()
 	if err != nil {
 		_ = f.Close()
-		log.Printf("[REPLAY] read header failed: %v", err)
+		recLog.Error("replay: read header failed", "err", err)
 		return nil, nil, nil, nil, err
 	}
 	return f, reader, tr, hdr, nil
@@ -791,3 +805,11 @@ func (rs *RecordingService) computeTotalNs(rec *database.Recording, passphrase s
 	}
 	return total
 }
+
+// vaultPassphrase falls back to the recording passphrase remembered in the vault
+func (rs *RecordingService) vaultPassphrase() string {
+	if rs.secrets == nil {
+		return ""
+	}
+	return rs.secrets.RecordingPassphrase()
+}
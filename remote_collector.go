@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Remote collector settings
+const (
+	remoteCollectorCheckInterval = 10 * time.Second // how often ended sessions and collectors are noticed
+	remoteCollectorRetryDelay    = 30 * time.Second // before restarting a collector that stopped
+	remoteSampleEndMarker        = "@@end"          // ends each sample in the collector output
+)
+
+// remoteCollector is one long-lived SSH exec session running a shell loop that
+// prints a sample every interval, so a monitored host is not sent a new exec
+// (and an auth log entry) on every tick
+type remoteCollector struct {
+	key     string // settings and process sort it was started with
+	started time.Time
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// remoteCollectorScript wraps the stats script in a loop printing one sample,
+// followed by the end marker, every interval
+func remoteCollectorScript(settings StatsSettings) string {
+	return "while :; do\n" +
+		remoteStatsScript(settings) +
+		"echo " + remoteSampleEndMarker + "\n" +
+		fmt.Sprintf("sleep %d\n", settings.IntervalSeconds) +
+		"done\n"
+}
+
+// collectStats keeps a collector running for the active session and every
+// subscribed one while there is something to show, and none otherwise
+func (s *RemoteStatsService) collectStats() {
+	defer s.stopCollectors()
+	for {
+		settings, visible, changed := s.config.current()
+		s.reconcileCollectors(settings, visible)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-changed:
+		case <-s.wake:
+		case <-time.After(remoteCollectorCheckInterval):
+		}
+	}
+}
+
+// wakeCollectors makes collectStats reconcile the collectors right away
+func (s *RemoteStatsService) wakeCollectors() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reconcileCollectors starts, restarts and stops collectors to match the monitored
+// sessions and the current settings
+func (s *RemoteStatsService) reconcileCollectors(settings StatsSettings, visible bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := make(map[string]bool)
+	for id := range s.subscribed {
+		// Subscriptions end with their session
+		if session := s.terminalService.GetSession(id); session == nil || !session.IsSSH || session.SSHClient == nil {
+			delete(s.subscribed, id)
+			continue
+		}
+		wanted[id] = true
+	}
+	// The active session is only monitored for the status bar
+	if s.activeSessionID != "" && visible {
+		wanted[s.activeSessionID] = true
+	}
+
+	key := fmt.Sprintf("%+v/%s", settings, s.processSort)
+	for id, c := range s.collectors {
+		stopped := false
+		select {
+		case <-c.done:
+			stopped = true
+		default:
+		}
+		switch {
+		case !wanted[id] || c.key != key:
+			c.cancel()
+			delete(s.collectors, id)
+		case stopped && time.Since(c.started) >= remoteCollectorRetryDelay:
+			delete(s.collectors, id)
+		}
+	}
+	for id := range wanted {
+		if _, running := s.collectors[id]; running {
+			continue
+		}
+		session := s.terminalService.GetSession(id)
+		if session == nil || !session.IsSSH || session.SSHClient == nil {
+			continue
+		}
+		ctx, cancel := context.WithCancel(s.ctx)
+		c := &remoteCollector{key: key, started: time.Now(), cancel: cancel, done: make(chan struct{})}
+		s.collectors[id] = c
+		// Network deltas start over with each collector
+		delete(s.lastNetworkStats, id)
+		processSort := s.processSort
+		go func() {
+			defer close(c.done)
+			if err := s.runCollector(ctx, session.SSHClient, id, settings, processSort); err != nil && ctx.Err() == nil {
+				log.Printf("Remote stats collector for %s stopped: %v", id, err)
+			}
+		}()
+	}
+}
+
+// stopCollectors ends every collector
+func (s *RemoteStatsService) stopCollectors() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.collectors {
+		c.cancel()
+		delete(s.collectors, id)
+	}
+}
+
+// runCollector streams samples from one host until ctx is cancelled or the
+// connection ends, emitting each as it completes
+func (s *RemoteStatsService) runCollector(ctx context.Context, client *ssh.Client, sessionID string, settings StatsSettings, processSort string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start(remoteCollectorScript(settings)); err != nil {
+		return err
+	}
+	// Closing the channel ends the loop on the host too: its next write fails
+	stop := context.AfterFunc(ctx, func() {
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+	})
+	defer stop()
+
+	var sample []string
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // sensors -j prints long JSON
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) != remoteSampleEndMarker {
+			sample = append(sample, line)
+			continue
+		}
+		stats, err := s.parseRemoteStats(sessionID, sample, settings, processSort)
+		sample = sample[:0]
+		if err != nil {
+			// Skip a sample the host could not produce, e.g. while a disk hangs
+			continue
+		}
+		s.emitRemoteStats(sessionID, stats)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return session.Wait()
+}
+
+// emitRemoteStats reports one host's sample: system:stats:session always, and
+// system:stats when it is the active session
+func (s *RemoteStatsService) emitRemoteStats(sessionID string, stats SystemStats) {
+	if session := s.terminalService.GetSession(sessionID); session != nil && session.metrics != nil {
+		conn := session.metrics.stats()
+		stats.SSH = &conn
+	}
+	if s.alerts != nil {
+		s.alerts.Observe(sessionID, stats)
+	}
+	if s.app == nil {
+		return
+	}
+	s.mu.Lock()
+	active := s.activeSessionID == sessionID
+	s.mu.Unlock()
+	if active {
+		s.app.Event.Emit("system:stats", stats)
+	}
+	s.app.Event.Emit("system:stats:session", stats)
+}
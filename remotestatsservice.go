@@ -3,9 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/wailsapp/wails/v3/pkg/application"
 	"golang.org/x/crypto/ssh"
@@ -13,21 +14,37 @@ import (
 
 // RemoteStatsService monitors remote SSH server statistics
 type RemoteStatsService struct {
-	app              *application.App
-	terminalService  *TerminalService
-	ctx              context.Context
-	cancel           context.CancelFunc
-	updateInterval   time.Duration
+	app             *application.App
+	terminalService *TerminalService
+	ctx             context.Context
+	cancel          context.CancelFunc
+	config          *statsConfig
+	kills           killConfirmations
+	alerts          *StatsAlertService
+
+	wake             chan struct{}
+	mu               sync.Mutex
 	activeSessionID  string
-	lastNetworkStats map[string]uint64 // Store last network stats for delta calculation
+	processSort      string                       // "cpu" or "memory"
+	subscribed       map[string]bool              // sessions monitored besides the active one
+	collectors       map[string]*remoteCollector  // by session
+	lastNetworkStats map[string]remoteNetCounters // by session, for delta calculation
+}
+
+// remoteNetCounters are a host's total bytes received and sent
+type remoteNetCounters struct {
+	recv, sent uint64
 }
 
 // NewRemoteStatsService creates a new remote stats service
-func NewRemoteStatsService(terminalService *TerminalService) *RemoteStatsService {
+func NewRemoteStatsService(terminalService *TerminalService, config *statsConfig) *RemoteStatsService {
 	return &RemoteStatsService{
 		terminalService:  terminalService,
-		updateInterval:   2 * time.Second,
-		lastNetworkStats: make(map[string]uint64),
+		config:           config,
+		wake:             make(chan struct{}, 1),
+		subscribed:       make(map[string]bool),
+		collectors:       make(map[string]*remoteCollector),
+		lastNetworkStats: make(map[string]remoteNetCounters),
 	}
 }
 
@@ -36,6 +53,11 @@ func (s *RemoteStatsService) SetApp(app *application.App) {
 	s.app = app
 }
 
+// SetAlerts sets the service checking collected stats against the alert rules
+func (s *RemoteStatsService) SetAlerts(alerts *StatsAlertService) {
+	s.alerts = alerts
+}
+
 // Start begins collecting remote stats
 func (s *RemoteStatsService) Start() {
 	s.ctx, s.cancel = context.WithCancel(context.Background())
@@ -51,59 +73,73 @@ func (s *RemoteStatsService) Stop() {
 
 // SetActiveSession sets which session to monitor (called from frontend)
 func (s *RemoteStatsService) SetActiveSession(sessionID string) {
-	// Clear network stats when switching sessions
-	if s.activeSessionID != sessionID {
-		s.lastNetworkStats = make(map[string]uint64)
-	}
+	s.mu.Lock()
 	s.activeSessionID = sessionID
+	s.mu.Unlock()
+	s.wakeCollectors()
 }
 
-// collectStats periodically collects and emits remote system statistics
-func (s *RemoteStatsService) collectStats() {
-	ticker := time.NewTicker(s.updateInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-ticker.C:
-			if s.activeSessionID == "" {
-				continue
-			}
-
-			stats, err := s.getRemoteStats(s.activeSessionID)
-			if err != nil {
-				// If we can't get remote stats, continue silently
-				continue
-			}
-
-			if s.app != nil {
-				s.app.Event.Emit("system:stats", stats)
-			}
-		}
+// Subscribe monitors an SSH session in addition to the active one. Its stats are
+// emitted as system:stats:session, keyed by sessionId, until Unsubscribe or until
+// the session ends; stats keep being collected while the status bar is hidden.
+func (s *RemoteStatsService) Subscribe(sessionID string) error {
+	session := s.terminalService.GetSession(sessionID)
+	if session == nil || !session.IsSSH || session.SSHClient == nil {
+		return fmt.Errorf("not an active SSH session")
 	}
+	s.mu.Lock()
+	s.subscribed[sessionID] = true
+	s.mu.Unlock()
+	s.wakeCollectors()
+	return nil
 }
 
-// getRemoteStats collects statistics from a remote SSH session
-func (s *RemoteStatsService) getRemoteStats(sessionID string) (SystemStats, error) {
-	stats := SystemStats{}
+// Unsubscribe stops monitoring a session subscribed with Subscribe
+func (s *RemoteStatsService) Unsubscribe(sessionID string) {
+	s.mu.Lock()
+	delete(s.subscribed, sessionID)
+	s.mu.Unlock()
+	s.wakeCollectors()
+}
 
-	session := s.terminalService.GetSession(sessionID)
-	if session == nil || !session.IsSSH || session.SSHClient == nil {
-		return stats, fmt.Errorf("not an active SSH session")
+// GetSubscribedSessions returns the sessions monitored besides the active one
+func (s *RemoteStatsService) GetSubscribedSessions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.subscribed))
+	for id := range s.subscribed {
+		ids = append(ids, id)
 	}
+	sort.Strings(ids)
+	return ids
+}
 
-	// Execute a single command that gathers all stats at once
-	// This reduces the number of SSH sessions we need to create
-	cmd := `
+// remoteStatsScript prints one sample: the basic metrics on one line, then the
+// enabled optional sections. It runs in remoteCollectorScript's loop, so the CPU
+// usage is measured since the previous sample, or since boot for the first one.
+func remoteStatsScript(settings StatsSettings) string {
+	// Disabled basic metrics are not measured; they print as 0 to keep the line's layout
+	cmd := ""
+	if settings.CPU {
+		cmd += `
 		# CPU usage (from /proc/stat)
 		cpu_line=$(head -1 /proc/stat)
 		cpu_vals=($cpu_line)
 		total=$((${cpu_vals[1]} + ${cpu_vals[2]} + ${cpu_vals[3]} + ${cpu_vals[4]} + ${cpu_vals[5]} + ${cpu_vals[6]} + ${cpu_vals[7]}))
 		idle=${cpu_vals[4]}
-		cpu_pct=$(awk "BEGIN {printf \"%.2f\", (1 - $idle / $total) * 100}")
-
+		if [ -n "$prev_total" ] && [ "$total" -gt "$prev_total" ]; then
+			cpu_pct=$(awk "BEGIN {printf \"%.2f\", (1 - ($idle - $prev_idle) / ($total - $prev_total)) * 100}")
+		else
+			cpu_pct=$(awk "BEGIN {printf \"%.2f\", (1 - $idle / $total) * 100}")
+		fi
+		prev_total=$total
+		prev_idle=$idle
+`
+	} else {
+		cmd += "cpu_pct=0\n"
+	}
+	if settings.Memory {
+		cmd += `
 		# Memory usage
 		mem_total=$(awk '/MemTotal/ {print $2}' /proc/meminfo)
 		mem_free=$(awk '/MemFree/ {print $2}' /proc/meminfo)
@@ -111,30 +147,91 @@ func (s *RemoteStatsService) getRemoteStats(sessionID string) (SystemStats, erro
 		mem_cached=$(awk '/^Cached/ {print $2}' /proc/meminfo)
 		mem_used=$((mem_total - mem_free - mem_buffers - mem_cached))
 		mem_pct=$(awk "BEGIN {printf \"%.2f\", ($mem_used / $mem_total) * 100}")
-
+`
+	} else {
+		cmd += "mem_pct=0 mem_used=0 mem_total=0\n"
+	}
+	if settings.Disk {
+		cmd += `
 		# Disk usage (root partition)
 		disk_info=$(df / | tail -1)
 		disk_used=$(echo $disk_info | awk '{print $3}')
 		disk_total=$(echo $disk_info | awk '{print $2}')
 		disk_pct=$(echo $disk_info | awk '{print $5}' | tr -d '%')
-
+`
+	} else {
+		cmd += "disk_pct=0 disk_used=0 disk_total=0\n"
+	}
+	if settings.Network {
+		cmd += `
 		# Network stats (sum all interfaces)
 		net_stats=$(awk '/^ *[^ ]+:/ {sum_recv += $2; sum_sent += $10} END {print sum_recv, sum_sent}' /proc/net/dev)
-
+`
+	} else {
+		cmd += "net_stats='0 0'\n"
+	}
+	if settings.Load {
+		cmd += `
 		# Load average
 		load_avg=$(cat /proc/loadavg | awk '{print $1, $2, $3}')
-
+`
+	} else {
+		cmd += "load_avg='0 0 0'\n"
+	}
+	cmd += `
 		# Output all stats on one line
 		echo "$cpu_pct $mem_pct $mem_used $mem_total $disk_pct $disk_used $disk_total $net_stats $load_avg"
-	`
-
-	output, err := s.executeCommand(session.SSHClient, cmd)
-	if err != nil {
-		return stats, err
+`
+	// Sensors, the top processes one per line and Docker containers, each section
+	// after a marker line and only when enabled
+	if settings.Sensors {
+		cmd += "echo " + remoteSensorsMarker + remoteSensorsCmd
+	}
+	if settings.Processes {
+		// Every process: ps --sort is GNU-only, so they are sorted on this side
+		cmd += "echo " + remoteProcessesMarker + "\n"
+		cmd += "ps -eo pid=,user=,pcpu=,pmem=,rss=,comm= 2>/dev/null\n"
+	}
+	if settings.Docker {
+		cmd += "echo " + remoteDockerMarker + remoteDockerCmd
 	}
+	return cmd
+}
 
-	// Parse the output
-	parts := strings.Fields(output)
+// parseRemoteStats parses one sample printed by remoteStatsScript, keeping the
+// top processes by processSort
+func (s *RemoteStatsService) parseRemoteStats(sessionID string, lines []string, settings StatsSettings, processSort string) (SystemStats, error) {
+	stats := SystemStats{SessionID: sessionID}
+
+	var statsLine string
+	var sensorLines, processLines, dockerLines []string
+	section := "stats"
+	for _, line := range lines {
+		switch strings.TrimSpace(line) {
+		case remoteSensorsMarker:
+			section = "sensors"
+			continue
+		case remoteProcessesMarker:
+			section = "processes"
+			continue
+		case remoteDockerMarker:
+			section = "docker"
+			continue
+		}
+		switch section {
+		case "stats":
+			if statsLine == "" {
+				statsLine = line
+			}
+		case "sensors":
+			sensorLines = append(sensorLines, line)
+		case "processes":
+			processLines = append(processLines, line)
+		case "docker":
+			dockerLines = append(dockerLines, line)
+		}
+	}
+	parts := strings.Fields(statsLine)
 	if len(parts) < 12 {
 		return stats, fmt.Errorf("invalid stats output")
 	}
@@ -155,24 +252,94 @@ func (s *RemoteStatsService) getRemoteStats(sessionID string) (SystemStats, erro
 	netRecv, _ := strconv.ParseUint(parts[7], 10, 64)
 	netSent, _ := strconv.ParseUint(parts[8], 10, 64)
 
-	lastRecv := s.lastNetworkStats["recv"]
-	lastSent := s.lastNetworkStats["sent"]
+	s.mu.Lock()
+	last, ok := s.lastNetworkStats[sessionID]
+	s.lastNetworkStats[sessionID] = remoteNetCounters{recv: netRecv, sent: netSent}
+	s.mu.Unlock()
 
-	if lastRecv > 0 && lastSent > 0 {
-		stats.NetworkRecv = netRecv - lastRecv
-		stats.NetworkSent = netSent - lastSent
+	if ok && netRecv >= last.recv && netSent >= last.sent {
+		stats.NetworkRecv = netRecv - last.recv
+		stats.NetworkSent = netSent - last.sent
 	}
 
-	s.lastNetworkStats["recv"] = netRecv
-	s.lastNetworkStats["sent"] = netSent
-
 	stats.LoadAvg1, _ = strconv.ParseFloat(parts[9], 64)
 	stats.LoadAvg5, _ = strconv.ParseFloat(parts[10], 64)
 	stats.LoadAvg15, _ = strconv.ParseFloat(parts[11], 64)
 
+	if settings.Sensors {
+		stats.CPUTemperature, stats.Fans = parseRemoteSensors(sensorLines)
+	}
+	if settings.Processes {
+		stats.Processes = sortProcesses(parsePSProcesses(processLines), processSort, topProcessCount)
+	}
+	if settings.Docker {
+		stats.Containers = parseDockerStats(dockerLines)
+	}
+
 	return stats, nil
 }
 
+// Marker lines separating the sections of the stats command output
+const (
+	remoteSensorsMarker   = "@@sensors"
+	remoteProcessesMarker = "@@processes"
+	remoteDockerMarker    = "@@docker"
+)
+
+// SetProcessSort orders the process list by "cpu" (default) or "memory"
+func (s *RemoteStatsService) SetProcessSort(by string) {
+	s.mu.Lock()
+	s.processSort = by
+	s.mu.Unlock()
+	s.wakeCollectors()
+}
+
+// remoteProcess returns the owner and command name of a process on the remote
+func (s *RemoteStatsService) remoteProcess(client *ssh.Client, pid int32) (user, name string, err error) {
+	output, err := s.executeCommand(client, fmt.Sprintf("ps -o user=,comm= -p %d", pid))
+	fields := strings.Fields(output)
+	if err != nil || len(fields) < 2 {
+		return "", "", fmt.Errorf("process %d not found", pid)
+	}
+	return fields[0], strings.Join(fields[1:], " "), nil
+}
+
+// RequestKillProcess asks to kill a process on an SSH session's host; KillProcess
+// with the returned token, once the user confirmed, does it
+func (s *RemoteStatsService) RequestKillProcess(sessionID string, pid int32) (KillRequest, error) {
+	session := s.terminalService.GetSession(sessionID)
+	if session == nil || !session.IsSSH || session.SSHClient == nil {
+		return KillRequest{}, fmt.Errorf("not an active SSH session")
+	}
+	user, name, err := s.remoteProcess(session.SSHClient, pid)
+	if err != nil {
+		return KillRequest{}, err
+	}
+	return s.kills.request(sessionID, pid, name, user)
+}
+
+// KillProcess sends SIGTERM to the process of a confirmed kill request, unless its
+// PID now belongs to another process
+func (s *RemoteStatsService) KillProcess(token string) error {
+	req, err := s.kills.confirm(token)
+	if err != nil {
+		return err
+	}
+	session := s.terminalService.GetSession(req.SessionID)
+	if session == nil || !session.IsSSH || session.SSHClient == nil {
+		return fmt.Errorf("not an active SSH session")
+	}
+	if _, name, err := s.remoteProcess(session.SSHClient, req.PID); err != nil {
+		return fmt.Errorf("process %d already exited", req.PID)
+	} else if name != req.Name {
+		return fmt.Errorf("process %d is no longer %s", req.PID, req.Name)
+	}
+	if _, err := s.executeCommand(session.SSHClient, fmt.Sprintf("kill %d", req.PID)); err != nil {
+		return fmt.Errorf("failed to kill process %d: %w", req.PID, err)
+	}
+	return nil
+}
+
 // executeCommand executes a command on the remote SSH server
 func (s *RemoteStatsService) executeCommand(client *ssh.Client, cmd string) (string, error) {
 	session, err := client.NewSession()
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+const (
+	schedulerTick       = 15 * time.Second
+	scheduledRunsKept   = 50 // history entries kept per scheduled command
+	defaultRunsListSize = 20
+)
+
+// ScheduledCommandInfo is a scheduled command with the time it runs next
+type ScheduledCommandInfo struct {
+	database.ScheduledCommand
+	NextRun *time.Time                    `json:"nextRun,omitempty"`
+	LastRun *database.ScheduledCommandRun `json:"lastRun,omitempty"`
+}
+
+// SchedulerService runs commands on SSH sessions on cron schedules and keeps their
+// output and exit codes. Failed runs emit schedule:failed so the UI can notify.
+type SchedulerService struct {
+	app      *application.App
+	db       *database.DB
+	terminal *TerminalService
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu      sync.Mutex
+	next    map[int64]time.Time
+	running map[int64]bool
+}
+
+// NewSchedulerService creates the scheduler; Start begins running commands
+func NewSchedulerService(app *application.App, db *database.DB, terminal *TerminalService) *SchedulerService {
+	return &SchedulerService{
+		app:      app,
+		db:       db,
+		terminal: terminal,
+		next:     make(map[int64]time.Time),
+		running:  make(map[int64]bool),
+	}
+}
+
+// Start runs due commands in the background. Runs missed while the app was closed are skipped.
+func (s *SchedulerService) Start() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(schedulerTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.runDue(time.Now())
+			}
+		}
+	}()
+}
+
+// Stop stops scheduling new runs
+func (s *SchedulerService) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// ListScheduledCommands returns every scheduled command with its next and last run
+func (s *SchedulerService) ListScheduledCommands() ([]ScheduledCommandInfo, error) {
+	commands, err := s.db.ListScheduledCommands()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ScheduledCommandInfo, 0, len(commands))
+	for _, c := range commands {
+		info := ScheduledCommandInfo{ScheduledCommand: c}
+		if c.Enabled {
+			if sched, err := parseCron(c.Cron); err == nil {
+				if next := sched.Next(time.Now()); !next.IsZero() {
+					info.NextRun = &next
+				}
+			}
+		}
+		if runs, err := s.db.ListScheduledCommandRuns(c.ID, 1); err == nil && len(runs) > 0 {
+			info.LastRun = &runs[0]
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// CreateScheduledCommand adds a scheduled command
+func (s *SchedulerService) CreateScheduledCommand(c database.ScheduledCommand) (*database.ScheduledCommand, error) {
+	if err := s.validate(&c); err != nil {
+		return nil, err
+	}
+	if err := s.db.CreateScheduledCommand(&c); err != nil {
+		return nil, err
+	}
+	return s.db.GetScheduledCommand(c.ID)
+}
+
+// UpdateScheduledCommand changes a scheduled command; the new schedule applies from now
+func (s *SchedulerService) UpdateScheduledCommand(c database.ScheduledCommand) error {
+	if err := s.validate(&c); err != nil {
+		return err
+	}
+	if err := s.db.UpdateScheduledCommand(&c); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.next, c.ID)
+	s.mu.Unlock()
+	return nil
+}
+
+// DeleteScheduledCommand removes a scheduled command and its history
+func (s *SchedulerService) DeleteScheduledCommand(id int64) error {
+	s.mu.Lock()
+	delete(s.next, id)
+	s.mu.Unlock()
+	return s.db.DeleteScheduledCommand(id)
+}
+
+// RunScheduledCommandNow runs a scheduled command immediately and returns the recorded run
+func (s *SchedulerService) RunScheduledCommandNow(id int64) (*database.ScheduledCommandRun, error) {
+	c, err := s.db.GetScheduledCommand(id)
+	if err != nil {
+		return nil, err
+	}
+	if !s.claim(id) {
+		return nil, fmt.Errorf("%s is already running", c.Name)
+	}
+	defer s.release(id)
+	return s.run(c)
+}
+
+// GetScheduledCommandRuns returns the most recent runs of a scheduled command, newest first
+func (s *SchedulerService) GetScheduledCommandRuns(id int64, limit int) ([]database.ScheduledCommandRun, error) {
+	if limit <= 0 {
+		limit = defaultRunsListSize
+	}
+	return s.db.ListScheduledCommandRuns(id, limit)
+}
+
+func (s *SchedulerService) validate(c *database.ScheduledCommand) error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Command = strings.TrimSpace(c.Command)
+	if c.Command == "" {
+		return fmt.Errorf("command is required")
+	}
+	if c.Name == "" {
+		c.Name = c.Command
+	}
+	if _, err := parseCron(c.Cron); err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+	if c.TimeoutSeconds < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	node, err := s.db.GetSession(c.SessionID)
+	if err != nil {
+		return fmt.Errorf("session not found: %w", err)
+	}
+	if node.Type != "session" || node.SessionType == nil || *node.SessionType != "ssh" {
+		return fmt.Errorf("commands can only be scheduled on SSH sessions")
+	}
+	return nil
+}
+
+// runDue starts every enabled command whose next run time has passed
+func (s *SchedulerService) runDue(now time.Time) {
+	if s.db.Locked() {
+		return
+	}
+	commands, err := s.db.ListScheduledCommands()
+	if err != nil {
+		return
+	}
+	for i := range commands {
+		c := commands[i]
+		if !c.Enabled {
+			continue
+		}
+		sched, err := parseCron(c.Cron)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		next, known := s.next[c.ID]
+		if !known {
+			// First sight of this command: schedule its next run from now
+			s.next[c.ID] = sched.Next(now)
+			s.mu.Unlock()
+			continue
+		}
+		due := !next.IsZero() && !now.Before(next)
+		if due {
+			s.next[c.ID] = sched.Next(now)
+		}
+		s.mu.Unlock()
+
+		if !due || !s.claim(c.ID) {
+			continue
+		}
+		go func() {
+			defer s.release(c.ID)
+			if _, err := s.run(&c); err != nil {
+				log.Printf("Scheduled command %q could not be recorded: %v", c.Name, err)
+			}
+		}()
+	}
+}
+
+func (s *SchedulerService) claim(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[id] {
+		return false
+	}
+	s.running[id] = true
+	return true
+}
+
+func (s *SchedulerService) release(id int64) {
+	s.mu.Lock()
+	delete(s.running, id)
+	s.mu.Unlock()
+}
+
+// run executes a command, stores the outcome and emits schedule:run (and schedule:failed)
+func (s *SchedulerService) run(c *database.ScheduledCommand) (*database.ScheduledCommandRun, error) {
+	run := &database.ScheduledCommandRun{ScheduleID: c.ID, StartedAt: time.Now(), ExitCode: -1}
+	result, err := s.terminal.ExecCommand(c.SessionID, c.Command, c.TimeoutSeconds)
+	if result != nil {
+		run.StartedAt = result.StartedAt
+		run.DurationMs = result.DurationMs
+		run.ExitCode = result.ExitCode
+		run.Output = result.Output
+		if result.Truncated {
+			run.Output += "\n[output truncated]"
+		}
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if err := s.db.AddScheduledCommandRun(run, scheduledRunsKept); err != nil {
+		return nil, err
+	}
+
+	failed := run.Error != "" || run.ExitCode != 0
+	data := map[string]interface{}{
+		"scheduleId": c.ID,
+		"runId":      run.ID,
+		"name":       c.Name,
+		"sessionId":  c.SessionID,
+		"exitCode":   run.ExitCode,
+		"error":      run.Error,
+		"failed":     failed,
+	}
+	if s.app != nil {
+		s.app.Event.Emit("schedule:run", data)
+		if failed && c.NotifyOnFailure {
+			s.app.Event.Emit("schedule:failed", data)
+		}
+	}
+	if failed {
+		log.Printf("Scheduled command %q failed (exit %d): %s", c.Name, run.ExitCode, run.Error)
+	}
+	return run, nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// Secret config values are stored as "enc:v1:<nonce>:<ciphertext>" (base64, AES-256-GCM)
+const secretValuePrefix = "enc:v1:"
+
+// Settings controlling where the config secrets master key comes from
+const (
+	settingSecretsKeySource = "secrets_key_source" // "keychain" (default) or "passphrase"
+	settingSecretsKDFSalt   = "secrets_kdf_salt"
+	settingSecretsKeyCheck  = "secrets_key_check" // encrypted marker used to verify the passphrase
+)
+
+const (
+	secretsSourceKeychain   = "keychain"
+	secretsSourcePassphrase = "passphrase"
+	secretsKeychainAccount  = "config-secrets"
+	secretsKeyCheckPlain    = "term-secrets-ok"
+)
+
+var (
+	errKeychainNotFound    = errors.New("keychain item not found")
+	errKeychainUnavailable = errors.New("no usable keychain")
+	errSecretsLocked       = errors.New("secrets are locked")
+)
+
+// secretConfigKeys are always stored encrypted, regardless of the value type the caller passes
+var secretConfigKeys = []string{
+	"ssh_password",
+	"ssh_key_passphrase",
+	"rdp_password",
+	"vnc_password",
+	"telnet_password",
+	"ssh_proxy_password",
+	totpSecretKey,
+}
+
+// isSecretConfigKey reports whether a config key holds a credential
+func isSecretConfigKey(key string) bool {
+	for _, k := range secretConfigKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// isEncryptedSecret reports whether a stored value is already sealed
+func isEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretValuePrefix)
+}
+
+// SecretStore seals sensitive session config values with a master key held
+// in the OS keychain or derived from a passphrase
+type SecretStore struct {
+	db       *database.DB
+	app      *application.App
+	mu       sync.RWMutex
+	key      []byte // nil while locked
+	lastUsed time.Time
+
+	// keychainErr is why the keychain could not hold the master key, e.g. no
+	// Secret Service running. The user is asked for a master password instead.
+	keychainErr error
+
+	providers map[string]credentialProvider // by credential_provider value
+}
+
+// NewSecretStore loads the master key from the keychain when that source is configured;
+// with a master password the store starts locked until the vault is unlocked
+func NewSecretStore(db *database.DB) *SecretStore {
+	s := &SecretStore{db: db}
+	if s.Source() == secretsSourceKeychain {
+		if err := s.loadKeychainKey(); err != nil {
+			log.Printf("Config secrets unavailable from keychain: %v", err)
+			if errors.Is(err, errKeychainUnavailable) {
+				s.keychainErr = err
+			}
+			return s
+		}
+		if n, err := s.MigratePlaintext(); err != nil {
+			log.Printf("Failed to encrypt plaintext config secrets: %v", err)
+		} else if n > 0 {
+			log.Printf("Encrypted %d plaintext config secrets", n)
+		}
+	}
+	return s
+}
+
+// Source returns the configured master key source; an encrypted database always
+// uses the master password
+func (s *SecretStore) Source() string {
+	if s.db.Encrypted() {
+		return secretsSourcePassphrase
+	}
+	setting, err := s.db.GetSetting(settingSecretsKeySource)
+	if err != nil || setting == nil || setting.Value != secretsSourcePassphrase {
+		return secretsSourceKeychain
+	}
+	return secretsSourcePassphrase
+}
+
+// Unlocked reports whether the master key is available
+func (s *SecretStore) Unlocked() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.key != nil
+}
+
+// Lock forgets the in-memory master key
+func (s *SecretStore) Lock() {
+	s.mu.Lock()
+	s.key = nil
+	s.mu.Unlock()
+	s.forgetProviderSecrets()
+}
+
+// loadKeychainKey fetches the master key from the keychain, creating one on first use
+func (s *SecretStore) loadKeychainKey() error {
+	stored, err := keychainGet(secretsKeychainAccount)
+	var key []byte
+	switch {
+	case err == nil:
+		key, err = decodeB64(string(stored))
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("invalid master key in keychain")
+		}
+	case errors.Is(err, errKeychainNotFound):
+		if s.hasEncryptedSecrets() {
+			return fmt.Errorf("master key missing from keychain but encrypted secrets exist")
+		}
+		key, err = randBytes(32)
+		if err != nil {
+			return err
+		}
+		if err := keychainSet(secretsKeychainAccount, []byte(b64(key))); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	s.mu.Lock()
+	s.key = key
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Unlock derives the master key from the master password (passphrase source only)
+func (s *SecretStore) Unlock(passphrase string) error {
+	if s.Source() != secretsSourcePassphrase {
+		return fmt.Errorf("secrets use the %s key source", s.Source())
+	}
+	if passphrase == "" {
+		return fmt.Errorf("passphrase required")
+	}
+	// The settings below live inside an encrypted database
+	if err := s.db.Unlock(passphrase); err != nil {
+		return err
+	}
+
+	salt, err := s.settingBytes(settingSecretsKDFSalt)
+	if err != nil {
+		return fmt.Errorf("master password is not set up: %w", err)
+	}
+	check, err := s.db.GetSetting(settingSecretsKeyCheck)
+	if err != nil || check == nil || check.Value == "" {
+		return fmt.Errorf("master password is not set up")
+	}
+	key := deriveKeyArgon2([]byte(passphrase), salt, defaultArgon2)
+	if plain, err := openSecret(key, check.Value); err != nil || plain != secretsKeyCheckPlain {
+		return fmt.Errorf("wrong passphrase")
+	}
+
+	s.mu.Lock()
+	s.key = key
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+
+	if _, err := s.MigratePlaintext(); err != nil {
+		log.Printf("Failed to encrypt plaintext secrets: %v", err)
+	}
+	return nil
+}
+
+func (s *SecretStore) settingBytes(key string) ([]byte, error) {
+	setting, err := s.db.GetSetting(key)
+	if err != nil {
+		return nil, err
+	}
+	if setting == nil || setting.Value == "" {
+		return nil, fmt.Errorf("%s missing", key)
+	}
+	return decodeB64(setting.Value)
+}
+
+// Encrypt seals a config value; already sealed values are returned unchanged
+func (s *SecretStore) Encrypt(plain string) (string, error) {
+	if isEncryptedSecret(plain) {
+		return plain, nil
+	}
+	s.mu.RLock()
+	key := s.key
+	s.mu.RUnlock()
+	if key == nil {
+		s.requestUnlock("encrypt")
+		return "", errSecretsLocked
+	}
+	s.touch()
+	return sealSecret(key, plain)
+}
+
+// Decrypt opens a sealed config value; plaintext values are returned unchanged
+func (s *SecretStore) Decrypt(value string) (string, error) {
+	if !isEncryptedSecret(value) {
+		return value, nil
+	}
+	s.mu.RLock()
+	key := s.key
+	s.mu.RUnlock()
+	if key == nil {
+		s.requestUnlock("decrypt")
+		return "", errSecretsLocked
+	}
+	s.touch()
+	return openSecret(key, value)
+}
+
+// ResolveConfig returns a copy of cfg with every sealed value decrypted and the
+// credentials of its credential provider filled in, for use at connect time
+func (s *SecretStore) ResolveConfig(cfg map[string]string) (map[string]string, error) {
+	cfg, err := expandCredential(s.db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(cfg))
+	for k, v := range cfg {
+		plain, err := s.Decrypt(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", k, err)
+		}
+		out[k] = plain
+	}
+	return s.resolveProvider(out)
+}
+
+// touch records secret activity for the auto-lock timer
+func (s *SecretStore) touch() {
+	s.mu.Lock()
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+}
+
+// MigratePlaintext encrypts credential config values and local private keys still stored in plaintext
+func (s *SecretStore) MigratePlaintext() (int, error) {
+	if !s.Unlocked() {
+		return 0, errSecretsLocked
+	}
+	configs, err := s.db.ListSecretConfigs(secretConfigKeys)
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, c := range configs {
+		if c.Value == "" || isEncryptedSecret(c.Value) {
+			continue
+		}
+		sealed, err := s.Encrypt(c.Value)
+		if err != nil {
+			return migrated, err
+		}
+		if err := s.db.UpdateConfigValue(c.ID, sealed, "secret"); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	keys, err := s.db.ListLocalUserKeys()
+	if err != nil {
+		return migrated, err
+	}
+	for _, k := range keys {
+		if k.PrivateKey == "" || isEncryptedSecret(k.PrivateKey) {
+			continue
+		}
+		sealed, err := s.Encrypt(k.PrivateKey)
+		if err != nil {
+			return migrated, err
+		}
+		if err := s.db.UpdateUserKeyPrivateKey(k.ID, sealed); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// hasEncryptedSecrets reports whether anything is sealed with the current
+// master key: session configs, private keys, credentials and secret settings.
+// It errs on the side of yes, as a new key would make those unreadable.
+func (s *SecretStore) hasEncryptedSecrets() bool {
+	configs, err := s.db.ListSecretConfigs(secretConfigKeys)
+	if err != nil {
+		return true
+	}
+	for _, c := range configs {
+		if isEncryptedSecret(c.Value) {
+			return true
+		}
+	}
+	keys, err := s.db.ListLocalUserKeys()
+	if err != nil {
+		return true
+	}
+	for _, k := range keys {
+		if isEncryptedSecret(k.PrivateKey) {
+			return true
+		}
+	}
+	creds, err := s.db.ListCredentials()
+	if err != nil {
+		return true
+	}
+	for _, c := range creds {
+		if isEncryptedSecret(c.Secret) {
+			return true
+		}
+	}
+	// Secret settings hold a sealed value or JSON with sealed fields
+	for key, def := range settingDefs {
+		if !def.Secret {
+			continue
+		}
+		setting, err := s.db.GetSetting(key)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return true
+		}
+		if setting != nil && strings.Contains(setting.Value, secretValuePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func sealSecret(key []byte, plain string) (string, error) {
+	ct, nonce, err := EncryptKeyGCM(key, []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return secretValuePrefix + b64(nonce) + ":" + b64(ct), nil
+}
+
+func openSecret(key []byte, value string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, secretValuePrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("malformed secret value")
+	}
+	nonce, err := decodeB64(parts[0])
+	if err != nil {
+		return "", err
+	}
+	ct, err := decodeB64(parts[1])
+	if err != nil {
+		return "", err
+	}
+	plain, err := unwrapFileKey(ct, nonce, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret (wrong key?)")
+	}
+	return string(plain), nil
+}
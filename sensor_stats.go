@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/sensors"
+)
+
+// FanReading is the speed of one fan in SystemStats
+type FanReading struct {
+	Name string  `json:"name"`
+	RPM  float64 `json:"rpm"`
+}
+
+// cpuSensorHints identify CPU temperature sensors among chip, feature and thermal
+// zone names (Intel coretemp/x86_pkg_temp, AMD k10temp/zenpower Tctl/Tdie, SoCs)
+var cpuSensorHints = []string{"coretemp", "k10temp", "zenpower", "cpu", "pkg", "package", "tctl", "tdie", "soc"}
+
+func isCPUSensor(name string) bool {
+	name = strings.ToLower(name)
+	for _, hint := range cpuSensorHints {
+		if strings.Contains(name, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// cpuTemperature picks the hottest CPU sensor, or the hottest sensor when none is
+// recognisable as the CPU; 0 when there are no readings
+func cpuTemperature(temps map[string]float64) float64 {
+	var cpu, any float64
+	for name, t := range temps {
+		if t <= 0 || t > 150 { // unplugged probes report nonsense
+			continue
+		}
+		any = max(any, t)
+		if isCPUSensor(name) {
+			cpu = max(cpu, t)
+		}
+	}
+	if cpu > 0 {
+		return cpu
+	}
+	return any
+}
+
+// localSensors reads this machine's temperatures through gopsutil and, on Linux,
+// its fans from hwmon. Platforms without sensor support simply report nothing.
+func localSensors() (float64, []FanReading) {
+	temps := map[string]float64{}
+	// Readings that worked are returned along with warnings for those that did not
+	stats, _ := sensors.SensorsTemperatures()
+	for _, t := range stats {
+		temps[t.SensorKey] = t.Temperature
+	}
+
+	var fans []FanReading
+	inputs, _ := filepath.Glob("/sys/class/hwmon/hwmon*/fan*_input")
+	for _, input := range inputs {
+		raw, err := os.ReadFile(input)
+		if err != nil {
+			continue
+		}
+		rpm, err := strconv.ParseFloat(strings.TrimSpace(string(raw)), 64)
+		if err != nil {
+			continue
+		}
+		fans = append(fans, FanReading{Name: hwmonFanName(input), RPM: rpm})
+	}
+	return cpuTemperature(temps), fans
+}
+
+// hwmonFanName names a hwmon fan input by its label, or chip and fan number
+func hwmonFanName(input string) string {
+	dir, fan := filepath.Dir(input), strings.TrimSuffix(filepath.Base(input), "_input")
+	if label, err := os.ReadFile(filepath.Join(dir, fan+"_label")); err == nil {
+		return strings.TrimSpace(string(label))
+	}
+	if chip, err := os.ReadFile(filepath.Join(dir, "name")); err == nil {
+		return strings.TrimSpace(string(chip)) + " " + fan
+	}
+	return fan
+}
+
+// remoteSensorsCmd prints `sensors -j` output when lm-sensors is installed and
+// otherwise "temp <zone> <millidegrees>" and "fan <name> <rpm>" lines from sysfs
+const remoteSensorsCmd = `
+		if command -v sensors >/dev/null 2>&1; then
+			sensors -j 2>/dev/null
+		else
+			for z in /sys/class/thermal/thermal_zone*; do
+				[ -r "$z/temp" ] && echo "temp $(cat "$z/type") $(cat "$z/temp")"
+			done
+			for f in /sys/class/hwmon/hwmon*/fan*_input; do
+				[ -r "$f" ] && echo "fan $(cat "${f%/*}/name" 2>/dev/null)-$(basename "$f" _input) $(cat "$f")"
+			done
+		fi
+`
+
+// parseRemoteSensors reads the output of remoteSensorsCmd
+func parseRemoteSensors(lines []string) (float64, []FanReading) {
+	temps := map[string]float64{}
+	var fans []FanReading
+
+	output := strings.TrimSpace(strings.Join(lines, "\n"))
+	if strings.HasPrefix(output, "{") {
+		// sensors -j: chip -> feature -> subfeature -> value, plus "Adapter" strings
+		var chips map[string]map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(output), &chips); err != nil {
+			return 0, nil
+		}
+		for chip, features := range chips {
+			for feature, raw := range features {
+				var values map[string]float64
+				if json.Unmarshal(raw, &values) != nil {
+					continue
+				}
+				for sub, v := range values {
+					switch {
+					case strings.HasPrefix(sub, "temp") && strings.HasSuffix(sub, "_input"):
+						temps[chip+" "+feature] = v
+					case strings.HasPrefix(sub, "fan") && strings.HasSuffix(sub, "_input"):
+						fans = append(fans, FanReading{Name: feature, RPM: v})
+					}
+				}
+			}
+		}
+	} else {
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				continue
+			}
+			switch fields[0] {
+			case "temp":
+				temps[fields[1]] = v / 1000
+			case "fan":
+				fans = append(fans, FanReading{Name: fields[1], RPM: v})
+			}
+		}
+	}
+
+	sort.Slice(fans, func(i, j int) bool { return fans[i].Name < fans[j].Name })
+	return cpuTemperature(temps), fans
+}
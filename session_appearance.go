@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	sessionIconPattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+	sessionColorPattern = regexp.MustCompile(`^#(?:[0-9a-f]{3}|[0-9a-f]{6})$`)
+)
+
+// SetSessionAppearance sets the icon and color a session or folder is shown with in
+// the tree. The icon is a name such as "kubernetes" or "database" and the color a
+// "#rrggbb" value; empty strings go back to the defaults.
+func (s *SessionService) SetSessionAppearance(sessionID, icon, color string) error {
+	if _, err := s.db.GetSession(sessionID); err != nil {
+		return err
+	}
+	icon, color, err := normalizeAppearance(icon, color)
+	if err != nil {
+		return err
+	}
+	return s.db.SetSessionAppearance(sessionID, icon, color)
+}
+
+// normalizeAppearance validates an icon name and color, lowercasing both and
+// expanding "#abc" to "#aabbcc"
+func normalizeAppearance(icon, color string) (string, string, error) {
+	icon = strings.ToLower(strings.TrimSpace(icon))
+	if icon != "" && !sessionIconPattern.MatchString(icon) {
+		return "", "", fmt.Errorf("invalid icon name %q: use lowercase letters, digits and dashes", icon)
+	}
+	color = strings.ToLower(strings.TrimSpace(color))
+	if color != "" && !sessionColorPattern.MatchString(color) {
+		return "", "", fmt.Errorf("invalid color %q: use #rgb or #rrggbb", color)
+	}
+	if len(color) == 4 {
+		color = string([]byte{'#', color[1], color[1], color[2], color[2], color[3], color[3]})
+	}
+	return icon, color, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"term/database"
+)
+
+// Placeholder shown in bulk edit diffs instead of credential values
+const maskedSecretValue = "••••••"
+
+// BulkConfigChange sets or deletes one config key
+type BulkConfigChange struct {
+	Op        string `json:"op"` // "set" or "delete"
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	ValueType string `json:"valueType"` // defaults to "string"
+}
+
+// BulkConfigEditRequest applies changes to a selection of sessions and/or a folder subtree
+type BulkConfigEditRequest struct {
+	SessionIDs     []string           `json:"sessionIds"`
+	FolderID       string             `json:"folderId"`       // every session below this folder
+	IncludeFolders bool               `json:"includeFolders"` // also edit the folders themselves (inherited by their children)
+	Changes        []BulkConfigChange `json:"changes"`
+	DryRun         bool               `json:"dryRun"`
+}
+
+// BulkConfigDiff is one config value that changes (or would change, in a dry run)
+type BulkConfigDiff struct {
+	SessionID   string `json:"sessionId"`
+	SessionName string `json:"sessionName"`
+	Key         string `json:"key"`
+	Action      string `json:"action"` // "add", "change" or "delete"
+	OldValue    string `json:"oldValue,omitempty"`
+	NewValue    string `json:"newValue,omitempty"`
+}
+
+// BulkConfigEditResult lists the differences and whether they were written
+type BulkConfigEditResult struct {
+	Targets int              `json:"targets"`
+	Diff    []BulkConfigDiff `json:"diff"`
+	Applied bool             `json:"applied"`
+}
+
+// BulkEditConfig sets or deletes config keys across many sessions in one transaction.
+// With DryRun the diff is computed without writing anything. Credential values are
+// masked in the diff and encrypted before they are stored.
+func (s *SessionService) BulkEditConfig(req BulkConfigEditRequest) (*BulkConfigEditResult, error) {
+	if len(req.Changes) == 0 {
+		return nil, fmt.Errorf("no changes given")
+	}
+	for _, c := range req.Changes {
+		if c.Key == "" {
+			return nil, fmt.Errorf("config key is required")
+		}
+		if c.Op != "set" && c.Op != "delete" {
+			return nil, fmt.Errorf("unknown operation %q for %s", c.Op, c.Key)
+		}
+	}
+
+	targets, err := s.bulkEditTargets(req)
+	if err != nil {
+		return nil, err
+	}
+	result := &BulkConfigEditResult{Targets: len(targets), Diff: []BulkConfigDiff{}}
+
+	var writes []database.ConfigChange
+	for _, node := range targets {
+		current, err := s.db.GetSessionConfigs(node.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range req.Changes {
+			old, exists := current[c.Key]
+			secret := isSecretConfigKey(c.Key) || c.ValueType == "secret" || isEncryptedSecret(old)
+			diff := BulkConfigDiff{SessionID: node.ID, SessionName: node.Name, Key: c.Key, OldValue: old}
+
+			if c.Op == "delete" {
+				if !exists {
+					continue
+				}
+				diff.Action = "delete"
+				writes = append(writes, database.ConfigChange{SessionID: node.ID, Key: c.Key, Delete: true})
+			} else {
+				if exists && !secret && old == c.Value {
+					continue
+				}
+				if !node.IsTemplate {
+					if verr := validateConfigKey(node.SessionType, c.Key, c.Value); verr != nil {
+						return nil, fmt.Errorf("%s: %w", node.Name, verr)
+					}
+				}
+				diff.Action = "change"
+				if !exists {
+					diff.Action = "add"
+				}
+				diff.NewValue = c.Value
+				valueType := c.ValueType
+				if valueType == "" {
+					valueType = "string"
+				}
+				writes = append(writes, database.ConfigChange{SessionID: node.ID, Key: c.Key, Value: c.Value, ValueType: valueType})
+			}
+
+			if secret {
+				if diff.OldValue != "" {
+					diff.OldValue = maskedSecretValue
+				}
+				if diff.NewValue != "" {
+					diff.NewValue = maskedSecretValue
+				}
+			}
+			result.Diff = append(result.Diff, diff)
+		}
+	}
+
+	if req.DryRun || len(writes) == 0 {
+		return result, nil
+	}
+
+	// Seal credentials up front so the transaction only does database work
+	sealed := map[string]string{}
+	for i := range writes {
+		w := &writes[i]
+		if w.Delete || !(w.ValueType == "secret" || isSecretConfigKey(w.Key)) {
+			continue
+		}
+		w.ValueType = "secret"
+		if w.Value == "" {
+			continue
+		}
+		if v, ok := sealed[w.Value]; ok {
+			w.Value = v
+			continue
+		}
+		v, err := s.secrets.Encrypt(w.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %w", w.Key, err)
+		}
+		sealed[w.Value] = v
+		w.Value = v
+	}
+
+	if err := s.db.ApplyConfigChanges(writes); err != nil {
+		return nil, err
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// bulkEditTargets resolves the selected sessions plus the folder subtree, without duplicates
+func (s *SessionService) bulkEditTargets(req BulkConfigEditRequest) ([]database.SessionNode, error) {
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]database.SessionNode, len(all))
+	children := map[string][]string{}
+	for _, node := range all {
+		byID[node.ID] = node
+		if node.ParentID != nil {
+			children[*node.ParentID] = append(children[*node.ParentID], node.ID)
+		}
+	}
+
+	selected := map[string]bool{}
+	for _, id := range req.SessionIDs {
+		if _, ok := byID[id]; !ok {
+			return nil, fmt.Errorf("session %s not found", id)
+		}
+		selected[id] = true
+	}
+	if req.FolderID != "" {
+		folder, ok := byID[req.FolderID]
+		if !ok || folder.Type != "folder" {
+			return nil, fmt.Errorf("folder %s not found", req.FolderID)
+		}
+		queue := []string{req.FolderID}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			node := byID[id]
+			if node.Type == "session" || req.IncludeFolders {
+				selected[id] = true
+			}
+			queue = append(queue, children[id]...)
+		}
+	}
+
+	targets := make([]database.SessionNode, 0, len(selected))
+	for id := range selected {
+		targets = append(targets, byID[id])
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Name != targets[j].Name {
+			return targets[i].Name < targets[j].Name
+		}
+		return targets[i].ID < targets[j].ID
+	})
+	return targets, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"term/database"
+)
+
+// DuplicateTreeRequest copies a folder with all its descendants, or a single session
+type DuplicateTreeRequest struct {
+	SourceID    string  `json:"sourceId"`
+	ParentID    *string `json:"parentId"`    // nil places the copy next to the source
+	Name        string  `json:"name"`        // empty uses "<source name> (copy)"
+	HostPattern string  `json:"hostPattern"` // optional regular expression applied to host configs
+	HostReplace string  `json:"hostReplace"` // replacement for HostPattern matches; may use $1
+}
+
+// DuplicateTree copies a node and everything below it with new IDs, keeping the
+// structure, positions, configs, tags and appearance. When HostPattern is set, every
+// *_host value in the copy is rewritten, e.g. "^(.*)\.staging\.example$" to
+// "$1.prod.example" to clone an environment. Returns the new top node.
+func (s *SessionService) DuplicateTree(req DuplicateTreeRequest) (*database.SessionNode, error) {
+	source, err := s.db.GetSession(req.SourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original session: %w", err)
+	}
+	var hostPattern *regexp.Regexp
+	if req.HostPattern != "" {
+		if hostPattern, err = regexp.Compile(req.HostPattern); err != nil {
+			return nil, fmt.Errorf("invalid host pattern: %w", err)
+		}
+	}
+
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string][]database.SessionNode)
+	for _, node := range all {
+		if node.ParentID != nil {
+			children[*node.ParentID] = append(children[*node.ParentID], node)
+		}
+	}
+
+	parentID := req.ParentID
+	if parentID == nil {
+		parentID = source.ParentID
+	} else {
+		parent, err := s.db.GetSession(*parentID)
+		if err != nil {
+			return nil, fmt.Errorf("target folder not found: %w", err)
+		}
+		if parent.Type != "folder" {
+			return nil, fmt.Errorf("%s is not a folder", parent.Name)
+		}
+	}
+	position := 0
+	for _, node := range all {
+		sameParent := (parentID == nil && node.ParentID == nil) || (parentID != nil && node.ParentID != nil && *node.ParentID == *parentID)
+		if sameParent && node.Position >= position {
+			position = node.Position + 1
+		}
+	}
+
+	root := *source
+	root.ParentID = parentID
+	root.Position = position
+	root.Name = strings.TrimSpace(req.Name)
+	if root.Name == "" {
+		root.Name = source.Name + " (copy)"
+	}
+
+	d := &treeDuplicator{s: s, children: children, hostPattern: hostPattern, hostReplace: req.HostReplace}
+	copied, err := d.copyNode(*source, root)
+	if err != nil {
+		// Leave nothing half-copied behind
+		if copied != nil {
+			if s.db.DeleteSession(copied.ID, true) == nil {
+				s.db.PurgeSession(copied.ID)
+			}
+		}
+		return nil, err
+	}
+	return copied, nil
+}
+
+// treeDuplicator copies a subtree taken from a snapshot of the tree, so copying a
+// folder into itself cannot recurse into the copy
+type treeDuplicator struct {
+	s           *SessionService
+	children    map[string][]database.SessionNode
+	hostPattern *regexp.Regexp
+	hostReplace string
+}
+
+// copyNode creates dup (a copy of src with its placement already set) and then the
+// copies of src's children. The created node is returned even on error for cleanup.
+func (d *treeDuplicator) copyNode(src, dup database.SessionNode) (*database.SessionNode, error) {
+	dup.ID = newNodeID(src.Type)
+	if err := d.s.db.CreateSession(&dup); err != nil {
+		return nil, fmt.Errorf("failed to create copy of %s: %w", src.Name, err)
+	}
+
+	entries, err := d.s.db.GetSessionConfigEntries(src.ID)
+	if err != nil {
+		return &dup, fmt.Errorf("failed to get configs of %s: %w", src.Name, err)
+	}
+	for _, c := range entries {
+		value := c.Value
+		if d.hostPattern != nil && strings.HasSuffix(c.Key, "_host") && c.ValueType != "secret" {
+			value = d.hostPattern.ReplaceAllString(value, d.hostReplace)
+		}
+		// Sealed values are copied as they are; they stay valid under the same key
+		if err := d.s.db.SetSessionConfig(dup.ID, c.Key, value, c.ValueType); err != nil {
+			return &dup, fmt.Errorf("failed to copy config of %s: %w", src.Name, err)
+		}
+	}
+
+	tags, err := d.s.db.GetSessionTags(src.ID)
+	if err != nil {
+		return &dup, fmt.Errorf("failed to get tags of %s: %w", src.Name, err)
+	}
+	if err := d.s.db.SetSessionTags(dup.ID, tags); err != nil {
+		return &dup, fmt.Errorf("failed to copy tags of %s: %w", src.Name, err)
+	}
+
+	for _, child := range d.children[src.ID] {
+		childCopy := child
+		childCopy.ParentID = &dup.ID
+		if _, err := d.copyNode(child, childCopy); err != nil {
+			return &dup, err
+		}
+	}
+	return &dup, nil
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"term/database"
+
+	"gopkg.in/yaml.v3"
+)
+
+const sessionExportVersion = 1
+
+// SessionExportOptions controls ExportTree
+type SessionExportOptions struct {
+	Path       string `json:"path"`
+	Format     string `json:"format"`     // "json" or "yaml"; inferred from the extension when empty
+	RootID     string `json:"rootId"`     // export only this subtree; empty exports the whole tree
+	Secrets    string `json:"secrets"`    // "exclude" (default) or "encrypt"
+	Passphrase string `json:"passphrase"` // protects exported secrets when Secrets is "encrypt"
+}
+
+// SessionImportOptions controls ImportTree
+type SessionImportOptions struct {
+	Path       string `json:"path"`
+	Format     string `json:"format"`     // "json" or "yaml"; inferred from the extension when empty
+	ParentID   string `json:"parentId"`   // folder to import into; empty imports at the root
+	Conflict   string `json:"conflict"`   // existing session with the same name: "merge" (default), "skip" or "rename"
+	Passphrase string `json:"passphrase"` // needed to import encrypted secrets
+}
+
+// SessionImportResult summarises what an import changed
+type SessionImportResult struct {
+	Created        int `json:"created"`
+	Updated        int `json:"updated"`
+	Skipped        int `json:"skipped"`
+	SecretsSkipped int `json:"secretsSkipped"`
+	Removed        int `json:"removed"` // stale nodes deleted by an inventory re-sync
+}
+
+// SessionExportNode is one folder or session in a portable tree
+type SessionExportNode struct {
+	Name        string              `json:"name" yaml:"name"`
+	Type        string              `json:"type" yaml:"type"` // "folder" or "session"
+	SessionType string              `json:"sessionType,omitempty" yaml:"sessionType,omitempty"`
+	Template    bool                `json:"template,omitempty" yaml:"template,omitempty"`
+	Icon        string              `json:"icon,omitempty" yaml:"icon,omitempty"`
+	Color       string              `json:"color,omitempty" yaml:"color,omitempty"`
+	Config      map[string]string   `json:"config,omitempty" yaml:"config,omitempty"`
+	ConfigTypes map[string]string   `json:"configTypes,omitempty" yaml:"configTypes,omitempty"` // value types other than "string"
+	Secrets     map[string]string   `json:"secrets,omitempty" yaml:"secrets,omitempty"`         // sealed with the export passphrase
+	Children    []SessionExportNode `json:"children,omitempty" yaml:"children,omitempty"`
+}
+
+type sessionExportFile struct {
+	Version    int                 `json:"version" yaml:"version"`
+	ExportedAt time.Time           `json:"exportedAt" yaml:"exportedAt"`
+	KDF        *sessionExportKDF   `json:"kdf,omitempty" yaml:"kdf,omitempty"`
+	Nodes      []SessionExportNode `json:"nodes" yaml:"nodes"`
+}
+
+type sessionExportKDF struct {
+	Name   string       `json:"name" yaml:"name"`
+	Params Argon2Params `json:"params" yaml:"params"`
+	Salt   string       `json:"salt" yaml:"salt"`
+	Check  string       `json:"check" yaml:"check"` // sealed marker to verify the passphrase
+}
+
+var nodeIDCounter uint64
+
+// newNodeID returns a unique id in the "<type>-<timestamp>" style used by the frontend
+func newNodeID(nodeType string) string {
+	return fmt.Sprintf("%s-%d-%d", nodeType, time.Now().UnixNano(), atomic.AddUint64(&nodeIDCounter, 1))
+}
+
+func exportFormat(format, path string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			format = "yaml"
+		default:
+			format = "json"
+		}
+	}
+	return format
+}
+
+// ExportTree writes folders, sessions and their configs to a portable JSON or YAML file
+func (s *SessionService) ExportTree(opts SessionExportOptions) error {
+	if opts.Path == "" {
+		return fmt.Errorf("export path required")
+	}
+	format := exportFormat(opts.Format, opts.Path)
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("unsupported export format %q", opts.Format)
+	}
+
+	file := sessionExportFile{Version: sessionExportVersion, ExportedAt: time.Now()}
+
+	var exportKey []byte
+	if opts.Secrets == "encrypt" {
+		if opts.Passphrase == "" {
+			return fmt.Errorf("passphrase required to export secrets")
+		}
+		salt, err := randBytes(16)
+		if err != nil {
+			return err
+		}
+		exportKey = deriveKeyArgon2([]byte(opts.Passphrase), salt, defaultArgon2)
+		check, err := sealSecret(exportKey, secretsKeyCheckPlain)
+		if err != nil {
+			return err
+		}
+		file.KDF = &sessionExportKDF{Name: "argon2id", Params: defaultArgon2, Salt: b64(salt), Check: check}
+	}
+
+	sessions, err := s.db.GetAllSessions()
+	if err != nil {
+		return fmt.Errorf("failed to load sessions: %w", err)
+	}
+	children := make(map[string][]database.SessionNode)
+	var roots []database.SessionNode
+	for _, node := range sessions {
+		switch {
+		case opts.RootID != "" && node.ID == opts.RootID:
+			roots = append(roots, node)
+		case node.ParentID != nil:
+			children[*node.ParentID] = append(children[*node.ParentID], node)
+		case opts.RootID == "":
+			roots = append(roots, node)
+		}
+	}
+	if opts.RootID != "" && len(roots) == 0 {
+		return fmt.Errorf("session %s not found", opts.RootID)
+	}
+
+	var build func(nodes []database.SessionNode) ([]SessionExportNode, error)
+	build = func(nodes []database.SessionNode) ([]SessionExportNode, error) {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Position < nodes[j].Position })
+		out := make([]SessionExportNode, 0, len(nodes))
+		for _, node := range nodes {
+			exp, err := s.exportNode(node, exportKey)
+			if err != nil {
+				return nil, err
+			}
+			if exp.Children, err = build(children[node.ID]); err != nil {
+				return nil, err
+			}
+			out = append(out, exp)
+		}
+		return out, nil
+	}
+	if file.Nodes, err = build(roots); err != nil {
+		return err
+	}
+
+	var data []byte
+	if format == "yaml" {
+		data, err = yaml.Marshal(file)
+	} else {
+		data, err = json.MarshalIndent(file, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode export: %w", err)
+	}
+	if err := os.WriteFile(opts.Path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	return nil
+}
+
+// exportNode converts one node; secrets are sealed with exportKey or dropped when it is nil
+func (s *SessionService) exportNode(node database.SessionNode, exportKey []byte) (SessionExportNode, error) {
+	exp := SessionExportNode{Name: node.Name, Type: node.Type, Template: node.IsTemplate, Icon: node.Icon, Color: node.Color}
+	if node.SessionType != nil {
+		exp.SessionType = *node.SessionType
+	}
+
+	entries, err := s.db.GetSessionConfigEntries(node.ID)
+	if err != nil {
+		return exp, fmt.Errorf("failed to load config of %s: %w", node.Name, err)
+	}
+	for _, c := range entries {
+		secret := c.ValueType == "secret" || isSecretConfigKey(c.Key) || isEncryptedSecret(c.Value)
+		if !secret {
+			if exp.Config == nil {
+				exp.Config = make(map[string]string)
+			}
+			exp.Config[c.Key] = c.Value
+			if c.ValueType != "" && c.ValueType != "string" {
+				if exp.ConfigTypes == nil {
+					exp.ConfigTypes = make(map[string]string)
+				}
+				exp.ConfigTypes[c.Key] = c.ValueType
+			}
+			continue
+		}
+		if exportKey == nil || c.Value == "" {
+			continue
+		}
+		plain, err := s.secrets.Decrypt(c.Value)
+		if err != nil {
+			return exp, fmt.Errorf("failed to decrypt %s of %s: %w", c.Key, node.Name, err)
+		}
+		sealed, err := sealSecret(exportKey, plain)
+		if err != nil {
+			return exp, err
+		}
+		if exp.Secrets == nil {
+			exp.Secrets = make(map[string]string)
+		}
+		exp.Secrets[c.Key] = sealed
+	}
+	return exp, nil
+}
+
+// ImportTree reads a file produced by ExportTree and merges it into the session tree
+func (s *SessionService) ImportTree(opts SessionImportOptions) (*SessionImportResult, error) {
+	data, err := os.ReadFile(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import file: %w", err)
+	}
+
+	var file sessionExportFile
+	if exportFormat(opts.Format, opts.Path) == "yaml" {
+		err = yaml.Unmarshal(data, &file)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse import file: %w", err)
+	}
+	if file.Version != sessionExportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", file.Version)
+	}
+
+	var importKey []byte
+	if file.KDF != nil && opts.Passphrase != "" {
+		salt, err := decodeB64(file.KDF.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid export salt: %w", err)
+		}
+		if err := checkArgon2Params(file.KDF.Params); err != nil {
+			return nil, fmt.Errorf("invalid export file: %w", err)
+		}
+		importKey = deriveKeyArgon2([]byte(opts.Passphrase), salt, file.KDF.Params)
+		if plain, err := openSecret(importKey, file.KDF.Check); err != nil || plain != secretsKeyCheckPlain {
+			return nil, fmt.Errorf("wrong passphrase for exported secrets")
+		}
+	}
+
+	var parentID *string
+	if opts.ParentID != "" {
+		parentID = &opts.ParentID
+	}
+	result := &SessionImportResult{}
+	err = s.importNodes(parentID, file.Nodes, opts.Conflict, importKey, result)
+	return result, err
+}
+
+// sessionImport is what an import writes, in one transaction so that a failure
+// leaves no partial import
+type sessionImport struct {
+	nodes   []database.SessionNode
+	configs []database.ConfigChange
+}
+
+// importNodes merges nodes below parentID. Folders with the same name are merged into;
+// sessions with the same name follow the conflict strategy. Importers reuse this.
+func (s *SessionService) importNodes(parentID *string, nodes []SessionExportNode, conflict string, importKey []byte, result *SessionImportResult) error {
+	plan := &sessionImport{}
+	counts := *result
+	if err := s.planImport(plan, parentID, nodes, conflict, importKey, &counts); err != nil {
+		return err
+	}
+	if err := s.db.ImportSessions(plan.nodes, plan.configs); err != nil {
+		return fmt.Errorf("failed to import sessions: %w", err)
+	}
+	*result = counts
+	return nil
+}
+
+// planImport adds the writes of an import below parentID to plan
+func (s *SessionService) planImport(plan *sessionImport, parentID *string, nodes []SessionExportNode, conflict string, importKey []byte, result *SessionImportResult) error {
+	if conflict == "" {
+		conflict = "merge"
+	}
+	if conflict != "merge" && conflict != "skip" && conflict != "rename" {
+		return fmt.Errorf("unknown conflict strategy %q", conflict)
+	}
+
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return fmt.Errorf("failed to load sessions: %w", err)
+	}
+	var siblings []database.SessionNode
+	nextPos := 0
+	for _, node := range all {
+		if (parentID == nil && node.ParentID == nil) || (parentID != nil && node.ParentID != nil && *node.ParentID == *parentID) {
+			siblings = append(siblings, node)
+			if node.Position >= nextPos {
+				nextPos = node.Position + 1
+			}
+		}
+	}
+	findSibling := func(n SessionExportNode) *database.SessionNode {
+		for i := range siblings {
+			sib := &siblings[i]
+			if sib.Name != n.Name || sib.Type != n.Type {
+				continue
+			}
+			if n.Type == "session" && (sib.SessionType == nil || *sib.SessionType != n.SessionType) {
+				continue
+			}
+			return sib
+		}
+		return nil
+	}
+	uniqueName := func(name string) string {
+		taken := func(candidate string) bool {
+			for _, sib := range siblings {
+				if sib.Name == candidate {
+					return true
+				}
+			}
+			return false
+		}
+		for i := 2; taken(name); i++ {
+			if !taken(fmt.Sprintf("%s (%d)", name, i)) {
+				return fmt.Sprintf("%s (%d)", name, i)
+			}
+		}
+		return name
+	}
+
+	for _, n := range nodes {
+		if n.Name == "" || (n.Type != "folder" && n.Type != "session") {
+			result.Skipped++
+			continue
+		}
+
+		target := findSibling(n)
+		switch {
+		case target != nil && n.Type == "folder" && conflict != "rename":
+			// Merge into the existing folder
+		case target != nil && conflict == "skip":
+			result.Skipped++
+			continue
+		case target != nil && conflict == "merge":
+			result.Updated++
+		default:
+			node := database.SessionNode{
+				ID:       newNodeID(n.Type),
+				ParentID: parentID,
+				Name:     n.Name,
+				Type:     n.Type,
+				Position: nextPos,
+			}
+			if n.Type == "session" {
+				node.IsTemplate = n.Template
+			}
+			if icon, color, err := normalizeAppearance(n.Icon, n.Color); err == nil {
+				node.Icon, node.Color = icon, color
+			}
+			if target != nil {
+				node.Name = uniqueName(n.Name)
+			}
+			if n.Type == "session" && n.SessionType != "" {
+				sessionType := n.SessionType
+				node.SessionType = &sessionType
+			}
+			plan.nodes = append(plan.nodes, node)
+			nextPos++
+			siblings = append(siblings, node)
+			target = &siblings[len(siblings)-1]
+			result.Created++
+		}
+
+		if err := s.planConfig(plan, target, n, importKey, result); err != nil {
+			return err
+		}
+		if len(n.Children) > 0 {
+			id := target.ID
+			if err := s.planImport(plan, &id, n.Children, conflict, importKey, result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// planConfig adds the config of an imported node to plan, keeping the value
+// types the export recorded
+func (s *SessionService) planConfig(plan *sessionImport, node *database.SessionNode, n SessionExportNode, importKey []byte, result *SessionImportResult) error {
+	set := func(key, value, valueType string) error {
+		value, valueType, err := s.storedConfig(node, key, value, valueType)
+		if err != nil {
+			return fmt.Errorf("failed to set %s on %s: %w", key, n.Name, err)
+		}
+		plan.configs = append(plan.configs, database.ConfigChange{SessionID: node.ID, Key: key, Value: value, ValueType: valueType})
+		return nil
+	}
+	for key, value := range n.Config {
+		valueType := n.ConfigTypes[key]
+		if valueType != "int" && valueType != "bool" && valueType != "json" {
+			valueType = "string"
+		}
+		if err := set(key, value, valueType); err != nil {
+			return err
+		}
+	}
+	for key, sealed := range n.Secrets {
+		if importKey == nil {
+			result.SecretsSkipped++
+			continue
+		}
+		plain, err := openSecret(importKey, sealed)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s of %s: %w", key, n.Name, err)
+		}
+		if err := set(key, plain, "secret"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
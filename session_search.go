@@ -0,0 +1,199 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"term/database"
+)
+
+const maxSearchResults = 100
+
+// Config keys holding the host and user name of each session type
+var (
+	searchHostKeys = []string{"ssh_host", "rdp_host", "vnc_host", "telnet_host"}
+	searchUserKeys = []string{"ssh_username", "rdp_username", "telnet_username"}
+)
+
+// SessionSearchResult is one ranked match for a search query
+type SessionSearchResult struct {
+	Session  database.SessionNode `json:"session"`
+	Path     []string             `json:"path"` // names of the parent folders, outermost first
+	Host     string               `json:"host,omitempty"`
+	Username string               `json:"username,omitempty"`
+	Tags     []string             `json:"tags"`
+	Score    int                  `json:"score"`
+}
+
+// GetSessionTags returns the tags of a session or folder
+func (s *SessionService) GetSessionTags(sessionID string) ([]string, error) {
+	return s.db.GetSessionTags(sessionID)
+}
+
+// SetSessionTags replaces the tags of a session or folder
+func (s *SessionService) SetSessionTags(sessionID string, tags []string) error {
+	return s.db.SetSessionTags(sessionID, cleanTags(tags))
+}
+
+// cleanTags trims, lower-cases and de-duplicates tags
+func cleanTags(tags []string) []string {
+	seen := map[string]bool{}
+	clean := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		clean = append(clean, tag)
+	}
+	return clean
+}
+
+// ListTags returns every tag in use with its usage count
+func (s *SessionService) ListTags() ([]database.TagCount, error) {
+	return s.db.ListTags()
+}
+
+// Search matches sessions by name, hostname, username and tags across the whole tree.
+// Every word of the query must match; "tag:<name>" only matches tags. Folder tags and
+// inherited hosts/usernames count for the sessions below them. Results are ranked with
+// exact and prefix matches on the name first.
+func (s *SessionService) Search(query string) ([]SessionSearchResult, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return []SessionSearchResult{}, nil
+	}
+
+	nodes, err := s.db.GetAllSessions()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.db.GetAllSessionTags()
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.db.GetConfigValues(append(append([]string{}, searchHostKeys...), searchUserKeys...))
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*database.SessionNode, len(nodes))
+	for i := range nodes {
+		byID[nodes[i].ID] = &nodes[i]
+	}
+	// chain returns the node followed by its ancestors
+	chain := func(n *database.SessionNode) []*database.SessionNode {
+		out := []*database.SessionNode{n}
+		seen := map[string]bool{n.ID: true}
+		for n.ParentID != nil {
+			parent, ok := byID[*n.ParentID]
+			if !ok || seen[parent.ID] {
+				break
+			}
+			seen[parent.ID] = true
+			out = append(out, parent)
+			n = parent
+		}
+		return out
+	}
+	inherited := func(nodes []*database.SessionNode, keys []string) string {
+		for _, n := range nodes {
+			for _, k := range keys {
+				if v := values[n.ID][k]; v != "" {
+					return v
+				}
+			}
+		}
+		return ""
+	}
+
+	results := []SessionSearchResult{}
+	for i := range nodes {
+		n := &nodes[i]
+		if n.Type != "session" || n.IsTemplate {
+			continue
+		}
+		ancestry := chain(n)
+		host := inherited(ancestry, searchHostKeys)
+		user := inherited(ancestry, searchUserKeys)
+		var nodeTags []string
+		for _, a := range ancestry {
+			nodeTags = append(nodeTags, tags[a.ID]...)
+		}
+
+		total := 0
+		for _, term := range terms {
+			score := searchTermScore(term, n.Name, host, user, nodeTags)
+			if score == 0 {
+				total = 0
+				break
+			}
+			total += score
+		}
+		if total == 0 {
+			continue
+		}
+
+		path := make([]string, 0, len(ancestry)-1)
+		for j := len(ancestry) - 1; j > 0; j-- {
+			path = append(path, ancestry[j].Name)
+		}
+		ownTags := tags[n.ID]
+		if ownTags == nil {
+			ownTags = []string{}
+		}
+		results = append(results, SessionSearchResult{
+			Session:  *n,
+			Path:     path,
+			Host:     host,
+			Username: user,
+			Tags:     ownTags,
+			Score:    total,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return strings.ToLower(results[i].Session.Name) < strings.ToLower(results[j].Session.Name)
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	return results, nil
+}
+
+// searchTermScore rates how well a single query word matches a session (0 = no match)
+func searchTermScore(term, name, host, user string, tags []string) int {
+	if tag, ok := strings.CutPrefix(term, "tag:"); ok {
+		best := 0
+		for _, t := range tags {
+			best = max(best, matchScore(tag, t, 50, 30, 0))
+		}
+		return best
+	}
+
+	best := matchScore(term, strings.ToLower(name), 100, 60, 40)
+	best = max(best, matchScore(term, strings.ToLower(host), 45, 25, 15))
+	best = max(best, matchScore(term, strings.ToLower(user), 30, 15, 10))
+	for _, t := range tags {
+		best = max(best, matchScore(term, t, 50, 30, 10))
+	}
+	return best
+}
+
+func matchScore(term, value string, exact, prefix, contains int) int {
+	switch {
+	case value == "":
+		return 0
+	case value == term:
+		return exact
+	case strings.HasPrefix(value, term):
+		return prefix
+	case strings.Contains(value, term):
+		return contains
+	}
+	return 0
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"term/database"
+)
+
+// Template placeholders: ${name} or ${name:-default}
+var templateVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// TemplateVariable is a placeholder used by a template's name or configs
+type TemplateVariable struct {
+	Name    string   `json:"name"`
+	Default string   `json:"default,omitempty"`
+	UsedBy  []string `json:"usedBy"` // config keys (or "name") containing the placeholder
+}
+
+// CreateFromTemplateRequest materialises a template into a regular session
+type CreateFromTemplateRequest struct {
+	TemplateID string            `json:"templateId"`
+	ParentID   *string           `json:"parentId"` // nil places the session next to the template
+	Name       string            `json:"name"`     // empty uses the template name with variables filled in
+	Variables  map[string]string `json:"variables"`
+}
+
+// SetSessionTemplate turns a session into a template, or a template back into a session
+func (s *SessionService) SetSessionTemplate(sessionID string, isTemplate bool) error {
+	node, err := s.db.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if node.Type != "session" {
+		return fmt.Errorf("only sessions can be templates")
+	}
+	return s.db.SetSessionTemplate(sessionID, isTemplate)
+}
+
+// ListTemplates returns every template node
+func (s *SessionService) ListTemplates() ([]database.SessionNode, error) {
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return nil, err
+	}
+	templates := []database.SessionNode{}
+	for _, node := range all {
+		if node.IsTemplate {
+			templates = append(templates, node)
+		}
+	}
+	return templates, nil
+}
+
+// GetTemplateVariables lists the placeholders the frontend must prompt for
+func (s *SessionService) GetTemplateVariables(templateID string) ([]TemplateVariable, error) {
+	tmpl, err := s.getTemplate(templateID)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := s.db.GetSessionConfigs(templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]*TemplateVariable{}
+	collect := func(source, text string) {
+		for _, m := range templateVarPattern.FindAllStringSubmatch(text, -1) {
+			v, ok := vars[m[1]]
+			if !ok {
+				v = &TemplateVariable{Name: m[1]}
+				vars[m[1]] = v
+			}
+			if v.Default == "" {
+				v.Default = m[2]
+			}
+			if len(v.UsedBy) == 0 || v.UsedBy[len(v.UsedBy)-1] != source {
+				v.UsedBy = append(v.UsedBy, source)
+			}
+		}
+	}
+	collect("name", tmpl.Name)
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !isEncryptedSecret(configs[key]) {
+			collect(key, configs[key])
+		}
+	}
+
+	result := make([]TemplateVariable, 0, len(vars))
+	for _, v := range vars {
+		result = append(result, *v)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// CreateFromTemplate creates a session from a template, substituting the variables in
+// its name and configs. Variables without a value or default are reported as an error.
+func (s *SessionService) CreateFromTemplate(req CreateFromTemplateRequest) (*database.SessionNode, error) {
+	tmpl, err := s.getTemplate(req.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.db.GetSessionConfigEntries(req.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	expand := func(text string) string {
+		return templateVarPattern.ReplaceAllStringFunc(text, func(match string) string {
+			m := templateVarPattern.FindStringSubmatch(match)
+			if v, ok := req.Variables[m[1]]; ok && v != "" {
+				return v
+			}
+			if m[2] != "" {
+				return m[2]
+			}
+			if !containsString(missing, m[1]) {
+				missing = append(missing, m[1])
+			}
+			return match
+		})
+	}
+
+	name := req.Name
+	if name == "" {
+		name = expand(tmpl.Name)
+	}
+	values := make(map[string]string, len(entries))
+	for _, c := range entries {
+		if isEncryptedSecret(c.Value) {
+			values[c.Key] = c.Value
+			continue
+		}
+		values[c.Key] = expand(c.Value)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing template variables: %s", strings.Join(missing, ", "))
+	}
+
+	parentID := req.ParentID
+	if parentID == nil {
+		parentID = tmpl.ParentID
+	}
+	all, err := s.db.GetAllSessions()
+	if err != nil {
+		return nil, err
+	}
+	position := 0
+	for _, node := range all {
+		sameParent := (parentID == nil && node.ParentID == nil) || (parentID != nil && node.ParentID != nil && *node.ParentID == *parentID)
+		if sameParent && node.Position >= position {
+			position = node.Position + 1
+		}
+	}
+
+	node := database.SessionNode{
+		ID:          newNodeID("session"),
+		ParentID:    parentID,
+		Name:        name,
+		Type:        "session",
+		SessionType: tmpl.SessionType,
+		Position:    position,
+		Icon:        tmpl.Icon,
+		Color:       tmpl.Color,
+	}
+	if err := s.db.CreateSession(&node); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	for _, c := range entries {
+		if err := s.SetSessionConfig(node.ID, c.Key, values[c.Key], c.ValueType); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %w", c.Key, err)
+		}
+	}
+	tags, err := s.db.GetSessionTags(req.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.SetSessionTags(node.ID, tags); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (s *SessionService) getTemplate(id string) (*database.SessionNode, error) {
+	node, err := s.db.GetSession(id)
+	if err != nil {
+		return nil, fmt.Errorf("template not found: %w", err)
+	}
+	if !node.IsTemplate {
+		return nil, fmt.Errorf("%s is not a template", node.Name)
+	}
+	return node, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"term/database"
+)
+
+const (
+	settingTrashRetentionDays = "trash_retention_days" // 0 keeps deleted sessions until the trash is emptied
+	defaultTrashRetentionDays = 30
+	trashPurgeInterval        = time.Hour
+)
+
+// ListTrash returns the deleted sessions and folders that can still be restored
+func (s *SessionService) ListTrash() ([]database.SessionNode, error) {
+	return s.db.ListDeletedSessions()
+}
+
+// RestoreSession brings a deleted node back, including the children deleted with it
+func (s *SessionService) RestoreSession(id string) error {
+	return s.db.RestoreSession(id)
+}
+
+// PurgeSession permanently deletes a node from the trash
+func (s *SessionService) PurgeSession(id string) error {
+	return s.db.PurgeSession(id)
+}
+
+// EmptyTrash permanently deletes everything in the trash and returns how many nodes were removed
+func (s *SessionService) EmptyTrash() (int64, error) {
+	return s.db.PurgeDeletedSessions(time.Now().Add(time.Minute))
+}
+
+// StartTrashPurge periodically removes nodes that have been in the trash longer than
+// trash_retention_days
+func (s *SessionService) StartTrashPurge() {
+	go func() {
+		s.purgeExpiredTrash()
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.purgeExpiredTrash()
+		}
+	}()
+}
+
+func (s *SessionService) purgeExpiredTrash() {
+	days := settingInt(s.db, settingTrashRetentionDays)
+	if days == 0 || s.db.Locked() {
+		return
+	}
+	n, err := s.db.PurgeDeletedSessions(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.Printf("Failed to purge expired trash: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Purged %d session(s) deleted more than %d days ago", n, days)
+	}
+}
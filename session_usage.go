@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+const defaultUsageListLimit = 10
+
+// recordSessionConnect counts a successful connection to a session tree node
+func recordSessionConnect(app *application.App, db *database.DB, nodeID string) {
+	if db == nil || nodeID == "" {
+		return
+	}
+	if err := db.RecordSessionConnect(nodeID); err != nil {
+		log.Printf("Failed to record connection to %s: %v", nodeID, err)
+		return
+	}
+	if app != nil {
+		app.Event.Emit("sessions:usage:updated", map[string]interface{}{
+			"sessionId": nodeID,
+		})
+	}
+}
+
+// SetFavorite pins or unpins a session for quick launch
+func (s *SessionService) SetFavorite(sessionID string, favorite bool) error {
+	if err := s.db.SetSessionFavorite(sessionID, favorite); err != nil {
+		return err
+	}
+	if s.app != nil {
+		s.app.Event.Emit("sessions:usage:updated", map[string]interface{}{
+			"sessionId": sessionID,
+		})
+	}
+	return nil
+}
+
+// GetSessionUsage returns the connect count, last connection and favorite flag of a session
+func (s *SessionService) GetSessionUsage(sessionID string) (*database.SessionUsage, error) {
+	return s.db.GetSessionUsage(sessionID)
+}
+
+// GetFavorites returns pinned sessions in the order they were pinned
+func (s *SessionService) GetFavorites() ([]database.SessionUsage, error) {
+	return s.db.ListFavoriteSessions()
+}
+
+// GetRecentSessions returns the most recently connected sessions (limit <= 0 uses the default)
+func (s *SessionService) GetRecentSessions(limit int) ([]database.SessionUsage, error) {
+	if limit <= 0 {
+		limit = defaultUsageListLimit
+	}
+	return s.db.ListRecentSessions(limit)
+}
+
+// GetMostUsedSessions returns the sessions connected to most often (limit <= 0 uses the default)
+func (s *SessionService) GetMostUsedSessions(limit int) ([]database.SessionUsage, error) {
+	if limit <= 0 {
+		limit = defaultUsageListLimit
+	}
+	return s.db.ListMostUsedSessions(limit)
+}
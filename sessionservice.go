@@ -5,15 +5,24 @@ import (
 	"sort"
 
 	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
 type SessionService struct {
-	db *database.DB
+	db      *database.DB
+	secrets *SecretStore
+	app     *application.App
 }
 
 // NewSessionService creates a new session service
-func NewSessionService(db *database.DB) *SessionService {
-	return &SessionService{db: db}
+func NewSessionService(db *database.DB, secrets *SecretStore) *SessionService {
+	return &SessionService{db: db, secrets: secrets}
+}
+
+// SetApp lets the session service emit events once the application exists
+func (s *SessionService) SetApp(app *application.App) {
+	s.app = app
 }
 
 // GetAllSessions retrieves all session nodes
@@ -36,7 +45,7 @@ func (s *SessionService) UpdateSession(session database.SessionNode) error {
 	return s.db.UpdateSession(&session)
 }
 
-// DeleteSession deletes a session
+// DeleteSession moves a session (and with cascade everything inside it) to the trash
 func (s *SessionService) DeleteSession(id string, cascade bool) error {
 	return s.db.DeleteSession(id, cascade)
 }
@@ -51,11 +60,48 @@ func (s *SessionService) GetEffectiveConfig(sessionID string) (map[string]string
 	return s.db.GetEffectiveConfig(sessionID)
 }
 
-// SetSessionConfig sets a config value for a session
+// SetSessionConfig sets a config value for a session.
+// The value is checked against the session type's schema and credentials
+// are encrypted before they reach the database.
 func (s *SessionService) SetSessionConfig(sessionID, key, value, valueType string) error {
+	node, err := s.db.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	value, valueType, err = s.storedConfig(node, key, value, valueType)
+	if err != nil {
+		return err
+	}
 	return s.db.SetSessionConfig(sessionID, key, value, valueType)
 }
 
+// storedConfig checks a config value against the node's schema and seals
+// credentials, returning the value and type to store
+func (s *SessionService) storedConfig(node *database.SessionNode, key, value, valueType string) (string, string, error) {
+	if !node.IsTemplate {
+		if verr := validateConfigKey(node.SessionType, key, value); verr != nil {
+			return "", "", verr
+		}
+	}
+	if valueType == "secret" || isSecretConfigKey(key) || isEncryptedSecret(value) {
+		sealed := value
+		if value != "" {
+			var err error
+			if sealed, err = s.secrets.Encrypt(value); err != nil {
+				return "", "", fmt.Errorf("failed to encrypt %s: %w", key, err)
+			}
+		}
+		return sealed, "secret", nil
+	}
+	return value, valueType, nil
+}
+
+// resolveSecrets decrypts the sealed values of a config map for the connect paths.
+// It is unexported so that the frontend cannot read the plain secrets.
+func (s *SessionService) resolveSecrets(config map[string]string) (map[string]string, error) {
+	return s.secrets.ResolveConfig(config)
+}
+
 // DeleteSessionConfig deletes a config key
 func (s *SessionService) DeleteSessionConfig(sessionID, key string) error {
 	return s.db.DeleteSessionConfig(sessionID, key)
@@ -100,15 +146,6 @@ func (s *SessionService) GetSessionTree() ([]TreeNode, error) {
 	// Sort children by position at all levels
 	sortTreeByPosition(&rootNodes)
 
-	// Log the tree structure
-	fmt.Printf("BACKEND GetSessionTree: %d root nodes\n", len(rootNodes))
-	for i, root := range rootNodes {
-		fmt.Printf("  Root[%d]: %s (pos=%d, children=%d)\n", i, root.Session.ID, root.Session.Position, len(root.Children))
-		for j, child := range root.Children {
-			fmt.Printf("    Child[%d]: %s (parent=%s, pos=%d)\n", j, child.Session.ID, *child.Session.ParentID, child.Session.Position)
-		}
-	}
-
 	return rootNodes, nil
 }
 
@@ -153,6 +190,39 @@ type TreeNode struct {
 	Children []TreeNode           `json:"children"`
 }
 
+// Page sizes for GetSessionChildren
+const (
+	defaultChildPageSize = 200
+	maxChildPageSize     = 1000
+)
+
+// SessionChildrenPage is one page of a folder's children
+type SessionChildrenPage struct {
+	Nodes  []database.SessionChild `json:"nodes"`
+	Offset int                     `json:"offset"`
+	Total  int                     `json:"total"` // children in the folder, across all pages
+}
+
+// GetSessionChildren returns the children of a folder (nil for the top level) a page
+// at a time, so large trees can be loaded as folders are expanded instead of all at
+// once with GetSessionTree. A limit of 0 uses the default page size.
+func (s *SessionService) GetSessionChildren(parentID *string, offset, limit int) (*SessionChildrenPage, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultChildPageSize
+	}
+	if limit > maxChildPageSize {
+		limit = maxChildPageSize
+	}
+	nodes, total, err := s.db.ListChildSessions(parentID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionChildrenPage{Nodes: nodes, Offset: offset, Total: total}, nil
+}
+
 // DuplicateSession creates a copy of a session with a new ID
 func (s *SessionService) DuplicateSession(id string, newID string, newName string) error {
 	// Get original session
@@ -178,11 +248,24 @@ func (s *SessionService) DuplicateSession(id string, newID string, newName strin
 
 	for key, value := range configs {
 		// Assume string type for now; in production, store type in DB
-		if err := s.db.SetSessionConfig(newID, key, value, "string"); err != nil {
+		valueType := "string"
+		if isEncryptedSecret(value) {
+			valueType = "secret"
+		}
+		if err := s.db.SetSessionConfig(newID, key, value, valueType); err != nil {
 			return fmt.Errorf("failed to copy config: %w", err)
 		}
 	}
 
+	// Copy tags
+	tags, err := s.db.GetSessionTags(id)
+	if err != nil {
+		return fmt.Errorf("failed to get session tags: %w", err)
+	}
+	if err := s.db.SetSessionTags(newID, tags); err != nil {
+		return fmt.Errorf("failed to copy tags: %w", err)
+	}
+
 	return nil
 }
 
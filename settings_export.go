@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const settingsExportVersion = 1
+
+// settingsExportFile is the on-disk format of exported settings. Values keep
+// their type: strings, numbers, booleans, and JSON settings embedded as JSON.
+type settingsExportFile struct {
+	Version    int                        `json:"version"`
+	ExportedAt time.Time                  `json:"exportedAt"`
+	Settings   map[string]json.RawMessage `json:"settings"`
+}
+
+// SettingsImportResult summarises what an import changed
+type SettingsImportResult struct {
+	Applied   []string `json:"applied"`   // keys whose value changed
+	Unchanged int      `json:"unchanged"` // keys that already had the imported value
+	Skipped   []string `json:"skipped"`   // unknown, secret and device-specific keys
+}
+
+// isExportableSetting reports whether a setting is a preference that can move to
+// another machine, the same ones that are synced
+func isExportableSetting(key string) bool {
+	def, ok := settingDefs[key]
+	return ok && !def.Secret && syncedSettings[key]
+}
+
+// encodeSettingValue turns a stored value into its typed JSON form
+func encodeSettingValue(def SettingDef, value string) (json.RawMessage, error) {
+	switch def.Type {
+	case "int":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(n)
+	case "bool":
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(on)
+	case "json":
+		if value == "" {
+			return json.RawMessage("null"), nil
+		}
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("not valid JSON")
+		}
+		return json.RawMessage(value), nil
+	}
+	return json.Marshal(value)
+}
+
+// decodeSettingValue turns a typed JSON value back into the stored form. Numbers
+// and booleans written as strings are accepted too, as hand-edited files have them.
+func decodeSettingValue(def SettingDef, raw json.RawMessage) (string, error) {
+	if def.Type == "json" {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("must be a %s", def.Type)
+}
+
+// ExportSettings writes every preference to a JSON file that ImportSettings can
+// apply on another machine. Secrets and device-specific state (sync, vault, open
+// tabs) are left out.
+func (s *SettingsService) ExportSettings(destPath string) error {
+	out := settingsExportFile{
+		Version:    settingsExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Settings:   make(map[string]json.RawMessage),
+	}
+	for key, def := range settingDefs {
+		if !isExportableSetting(key) {
+			continue
+		}
+		value, err := encodeSettingValue(def, settingValue(s.db, key))
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %w", key, err)
+		}
+		out.Settings[key] = value
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+	if err := os.WriteFile(destPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write settings file: %w", err)
+	}
+	return nil
+}
+
+// ImportSettings merges the settings of a file written by ExportSettings into the
+// current ones: settings in the file are overwritten, others are kept. Every value
+// is validated first, and nothing is written when any is invalid.
+func (s *SettingsService) ImportSettings(sourcePath string) (*SettingsImportResult, error) {
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings file: %w", err)
+	}
+	var in settingsExportFile
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("failed to parse settings file: %w", err)
+	}
+	if in.Version > settingsExportVersion {
+		return nil, fmt.Errorf("settings file version %d is newer than this app supports", in.Version)
+	}
+	if in.Settings == nil {
+		return nil, fmt.Errorf("not a settings file: no settings found")
+	}
+
+	result := &SettingsImportResult{Applied: []string{}, Skipped: []string{}}
+	values := make(map[string]string)
+	var invalid []string
+	for key, raw := range in.Settings {
+		if !isExportableSetting(key) {
+			result.Skipped = append(result.Skipped, key)
+			continue
+		}
+		def := settingDefs[key]
+		if string(raw) == "null" {
+			continue
+		}
+		value, err := decodeSettingValue(def, raw)
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %w", key, err)
+		} else {
+			err = validateSetting(def, value)
+		}
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		values[key] = value
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return nil, fmt.Errorf("invalid settings, nothing was imported:\n%s", strings.Join(invalid, "\n"))
+	}
+
+	for key, value := range values {
+		if current, err := s.db.GetSetting(key); err == nil && current.Value == value {
+			result.Unchanged++
+			continue
+		}
+		if err := setSettingValue(s.db, key, value); err != nil {
+			return result, fmt.Errorf("failed to import %s: %w", key, err)
+		}
+		result.Applied = append(result.Applied, key)
+	}
+	sort.Strings(result.Applied)
+	sort.Strings(result.Skipped)
+	return result, nil
+}
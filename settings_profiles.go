@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"term/database"
+)
+
+// Settings profiles are stored as a JSON list; the active one is remembered so the
+// UI can show it
+const (
+	settingSettingsProfiles      = "settings_profiles"
+	settingSettingsProfileActive = "settings_profile_active"
+	maxSettingsProfileName       = 64
+)
+
+// SettingsProfile is a named set of setting values, e.g. "presentation" with a
+// larger font, applied all at once
+type SettingsProfile struct {
+	Name      string            `json:"name"`
+	Settings  map[string]string `json:"settings"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// isProfileSetting reports whether a setting can be part of a profile: any
+// exportable preference except the profiles themselves
+func isProfileSetting(key string) bool {
+	return isExportableSetting(key) && key != settingSettingsProfiles && key != settingSettingsProfileActive
+}
+
+// validateSettingsProfiles is the settings registry validator of the profile list
+func validateSettingsProfiles(value string) error {
+	var profiles []SettingsProfile
+	if err := json.Unmarshal([]byte(value), &profiles); err != nil {
+		return fmt.Errorf("must be a JSON list of profiles")
+	}
+	return nil
+}
+
+// validateProfile checks a profile's name and that every value is valid for its
+// setting
+func validateProfile(p *SettingsProfile) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" || len(p.Name) > maxSettingsProfileName {
+		return fmt.Errorf("profile name must be 1-%d characters", maxSettingsProfileName)
+	}
+	if len(p.Settings) == 0 {
+		return fmt.Errorf("profile %s has no settings", p.Name)
+	}
+	for key, value := range p.Settings {
+		if !isProfileSetting(key) {
+			return fmt.Errorf("setting %s cannot be part of a profile", key)
+		}
+		if err := validateSetting(settingDefs[key], value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSettingsProfiles lists the saved profiles, sorted by name
+func (s *SettingsService) ListSettingsProfiles() ([]SettingsProfile, error) {
+	profiles := []SettingsProfile{}
+	if value := s.GetString(settingSettingsProfiles); value != "" {
+		if err := json.Unmarshal([]byte(value), &profiles); err != nil {
+			return nil, fmt.Errorf("failed to read settings profiles: %w", err)
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool { return strings.ToLower(profiles[i].Name) < strings.ToLower(profiles[j].Name) })
+	return profiles, nil
+}
+
+func (s *SettingsService) saveProfiles(profiles []SettingsProfile) error {
+	data, err := json.Marshal(profiles)
+	if err != nil {
+		return err
+	}
+	return s.Set(settingSettingsProfiles, string(data))
+}
+
+// GetActiveSettingsProfile returns the name of the profile applied last, or ""
+func (s *SettingsService) GetActiveSettingsProfile() string {
+	return s.GetString(settingSettingsProfileActive)
+}
+
+// SaveSettingsProfile creates or replaces the profile with the same name
+// (case-insensitive)
+func (s *SettingsService) SaveSettingsProfile(profile SettingsProfile) (*SettingsProfile, error) {
+	if err := validateProfile(&profile); err != nil {
+		return nil, err
+	}
+	profile.UpdatedAt = time.Now().UTC()
+
+	profiles, err := s.ListSettingsProfiles()
+	if err != nil {
+		return nil, err
+	}
+	replaced := false
+	for i := range profiles {
+		if strings.EqualFold(profiles[i].Name, profile.Name) {
+			profiles[i], replaced = profile, true
+		}
+	}
+	if !replaced {
+		profiles = append(profiles, profile)
+	}
+	if err := s.saveProfiles(profiles); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// CaptureSettingsProfile saves the current values of keys as a profile. Without
+// keys, every setting that can be part of a profile is captured.
+func (s *SettingsService) CaptureSettingsProfile(name string, keys []string) (*SettingsProfile, error) {
+	if len(keys) == 0 {
+		for key := range settingDefs {
+			if isProfileSetting(key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	profile := SettingsProfile{Name: name, Settings: make(map[string]string, len(keys))}
+	for _, key := range keys {
+		if !isProfileSetting(key) {
+			return nil, fmt.Errorf("setting %s cannot be part of a profile", key)
+		}
+		if value := settingValue(s.db, key); value != "" {
+			profile.Settings[key] = value
+		}
+	}
+	return s.SaveSettingsProfile(profile)
+}
+
+// DeleteSettingsProfile removes a profile; the settings it applied are kept
+func (s *SettingsService) DeleteSettingsProfile(name string) error {
+	profiles, err := s.ListSettingsProfiles()
+	if err != nil {
+		return err
+	}
+	kept := profiles[:0]
+	for _, p := range profiles {
+		if !strings.EqualFold(p.Name, name) {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(profiles) {
+		return fmt.Errorf("settings profile not found: %s", name)
+	}
+	if err := s.saveProfiles(kept); err != nil {
+		return err
+	}
+	if strings.EqualFold(s.GetActiveSettingsProfile(), name) {
+		return s.db.DeleteSetting(settingSettingsProfileActive)
+	}
+	return nil
+}
+
+// ApplySettingsProfile switches to a profile: all of its settings are validated,
+// then written in one transaction so the app never runs with half a profile.
+// settings:changed is emitted for every setting, then settings:profile with the
+// profile name.
+func (s *SettingsService) ApplySettingsProfile(name string) error {
+	profiles, err := s.ListSettingsProfiles()
+	if err != nil {
+		return err
+	}
+	var profile *SettingsProfile
+	for i := range profiles {
+		if strings.EqualFold(profiles[i].Name, name) {
+			profile = &profiles[i]
+		}
+	}
+	if profile == nil {
+		return fmt.Errorf("settings profile not found: %s", name)
+	}
+	if err := validateProfile(profile); err != nil {
+		return fmt.Errorf("cannot apply profile: %w", err)
+	}
+
+	settings := make([]database.Setting, 0, len(profile.Settings)+1)
+	for key, value := range profile.Settings {
+		settings = append(settings, database.Setting{Key: key, Value: value, ValueType: settingDefs[key].Type})
+	}
+	settings = append(settings, database.Setting{Key: settingSettingsProfileActive, Value: profile.Name, ValueType: "string"})
+	if err := s.db.SetSettings(settings); err != nil {
+		return fmt.Errorf("failed to apply profile: %w", err)
+	}
+
+	s.mu.Lock()
+	app := s.app
+	s.mu.Unlock()
+	if app != nil {
+		app.Event.Emit("settings:profile", map[string]interface{}{"name": profile.Name})
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// SettingDef declares an app setting: its type ("string", "int", "bool" or
+// "json"), default and the values it accepts
+type SettingDef struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	Default     string `json:"default"`
+	Description string `json:"description"`
+	Secret      bool   `json:"secret,omitempty"` // never sent in events
+
+	validate func(value string) error
+}
+
+// SettingChange is the payload of settings:changed, emitted on every write
+type SettingChange struct {
+	Key   string `json:"key"`
+	Value string `json:"value"` // empty for secret settings and deletions
+}
+
+// settingDefs is the registry of known settings. Keys not listed here can still
+// be stored with SetSetting, unvalidated.
+var settingDefs = map[string]SettingDef{}
+
+func init() {
+	for _, def := range []SettingDef{
+		// Appearance
+		{Key: "theme", Type: "string", Default: "dark", Description: "Legacy theme name"},
+		{Key: "active_theme", Type: "string", Default: "dark", Description: "Active theme ID, or auto to follow the OS appearance", validate: notEmpty},
+		{Key: settingThemeAutoLight, Type: "string", Default: "light", Description: "Theme used in auto mode for a light OS appearance", validate: notEmpty},
+		{Key: settingThemeAutoDark, Type: "string", Default: "dark", Description: "Theme used in auto mode for a dark OS appearance", validate: notEmpty},
+		{Key: settingThemeGalleryURL, Type: "string", Default: defaultThemeGalleryURL, Description: "HTTPS URL of the theme gallery index", validate: httpsURL},
+		{Key: "font_family", Type: "string", Default: "monospace", Description: "Terminal font family", validate: notEmpty},
+		{Key: "font_size", Type: "int", Default: "14", Description: "Terminal font size in pixels", validate: intRange(6, 72)},
+		{Key: "cursor_style", Type: "string", Default: "block", Description: "Terminal cursor style", validate: oneOf("block", "bar", "underline")},
+		{Key: "terminal_padding", Type: "int", Default: "0", Description: "Space around the terminal text in pixels", validate: intRange(0, 64)},
+		{Key: "show_status_bar", Type: "bool", Default: "true", Description: "Show the status bar"},
+
+		// Behavior
+		{Key: "auto_launch", Type: "bool", Default: "true", Description: "Open auto-launch sessions at startup"},
+		{Key: "restore_tabs_on_startup", Type: "bool", Default: "true", Description: "Reopen the previous tabs at startup"},
+		{Key: settingBackgroundMode, Type: "bool", Default: "false", Description: "Keep running in the tray, with connections open, when the window is closed"},
+		{Key: "confirm_tab_close", Type: "bool", Default: "false", Description: "Ask before closing a tab"},
+		{Key: settingKeymap, Type: "json", Default: "{}", Description: "Keyboard shortcut overrides, action to chord", validate: validateKeymap},
+		{Key: settingSettingsProfiles, Type: "json", Default: "[]", Description: "Named sets of settings that can be switched at once", validate: validateSettingsProfiles},
+		{Key: settingSettingsProfileActive, Type: "string", Description: "Settings profile applied last"},
+		{Key: "last_selected_node", Type: "string", Description: "Session selected in the tree at the last shutdown"},
+		{Key: "tab_snapshots", Type: "json", Default: "[]", Description: "Tabs open at the last shutdown"},
+		{Key: settingWindowGeometry, Type: "json", Default: "{}", Description: "Size, position and monitor of each window when it was last closed"},
+		{Key: "recording_default_capture_input", Type: "bool", Default: "false", Description: "Record typed input by default"},
+		{Key: "recording_default_encrypt", Type: "bool", Default: "true", Description: "Encrypt recordings by default"},
+		{Key: settingCommandHistory, Type: "bool", Default: "true", Description: "Record the commands run in terminals in the searchable command history"},
+		{Key: settingCommandHistoryRetention, Type: "int", Default: strconv.Itoa(defaultHistoryRetentionDays), Description: "Days commands stay in the command history, 0 keeps them", validate: intRange(0, 36500)},
+		{Key: settingTrashRetentionDays, Type: "int", Default: strconv.Itoa(defaultTrashRetentionDays), Description: "Days deleted sessions stay in the trash, 0 keeps them", validate: intRange(0, 3650)},
+		{Key: settingLogLevel, Type: "string", Default: "info", Description: "Minimum level of log records, overridden by TERM_LOG_LEVEL", validate: oneOf("debug", "info", "warn", "error")},
+		{Key: settingUpdateChannel, Type: "string", Default: "stable", Description: "Release channel updates come from", validate: oneOf("stable", "beta")},
+		{Key: settingUpdateCheck, Type: "bool", Default: "true", Description: "Check for updates daily"},
+		{Key: settingUpdateFeedURL, Type: "string", Default: updateFeedURL, Description: "HTTPS URL of the release feed, empty for the one of this build", validate: httpsURL},
+		{Key: settingHealthCheckInterval, Type: "int", Default: strconv.Itoa(defaultHealthCheckInterval), Description: "Seconds between host health checks, 0 disables them", validate: intRange(0, 86400)},
+
+		// System stats
+		{Key: settingStatsInterval, Type: "int", Default: strconv.Itoa(defaultStatsInterval), Description: "Seconds between stats updates", validate: intRange(1, maxStatsInterval)},
+		{Key: settingStatsCPU, Type: "bool", Default: "true", Description: "Collect CPU usage"},
+		{Key: settingStatsMemory, Type: "bool", Default: "true", Description: "Collect memory usage"},
+		{Key: settingStatsDisk, Type: "bool", Default: "true", Description: "Collect disk usage"},
+		{Key: settingStatsNetwork, Type: "bool", Default: "true", Description: "Collect network throughput"},
+		{Key: settingStatsLoad, Type: "bool", Default: "true", Description: "Collect load average"},
+		{Key: settingStatsSensors, Type: "bool", Default: "true", Description: "Collect temperatures"},
+		{Key: settingStatsProcesses, Type: "bool", Default: "true", Description: "Collect top processes"},
+		{Key: settingStatsDocker, Type: "bool", Default: "false", Description: "Collect Docker containers"},
+		{Key: settingStatsBattery, Type: "bool", Default: "true", Description: "Collect battery state"},
+		{Key: settingStatsAlertRules, Type: "json", Default: "", Description: "Stats alert rules"},
+
+		// Security
+		{Key: settingSystemKnownHosts, Type: "bool", Default: "false", Description: "Sync trusted host keys with ~/.ssh/known_hosts"},
+		{Key: settingHostKeyMaxAge, Type: "int", Default: "0", Description: "Days before a trusted host key is due for review, 0 disables reviews", validate: intRange(0, 36500)},
+		{Key: settingVaultAutoLock, Type: "int", Default: strconv.Itoa(defaultVaultAutoLockMinutes), Description: "Minutes of inactivity before the vault locks, 0 disables auto-lock", validate: intRange(0, 1440)},
+		{Key: settingVaultRecordingPassphrase, Type: "string", Secret: true, Description: "Recording passphrase sealed by the vault"},
+		{Key: settingSecretsKeySource, Type: "string", Default: "keychain", Description: "Where the secrets key comes from", validate: oneOf("keychain", "passphrase")},
+		{Key: settingSecretsKDFSalt, Type: "string", Secret: true, Description: "Salt of the secrets passphrase"},
+		{Key: settingSecretsKeyCheck, Type: "string", Secret: true, Description: "Marker used to verify the secrets passphrase"},
+		{Key: "recording_kdf_salt", Type: "string", Secret: true, Description: "Salt of the recording passphrase"},
+		{Key: settingHashiCorpVault, Type: "json", Secret: true, Description: "HashiCorp Vault address and credentials"},
+		{Key: settingAssistant, Type: "json", Secret: true, Description: "Command assistant provider, model and API key"},
+		{Key: settingOnePasswordCLI, Type: "string", Description: "1Password CLI (op) executable, empty looks in PATH"},
+		{Key: settingBitwardenCLI, Type: "string", Description: "Bitwarden CLI (bw) executable, empty looks in PATH"},
+
+		// HTTP API
+		{Key: settingHTTPBind, Type: "string", Default: defaultHTTPAddress, Description: "Address the HTTP server listens on", validate: notEmpty},
+		{Key: settingHTTPPort, Type: "int", Default: "0", Description: "HTTP server port, 0 picks a free port at each start", validate: intRange(0, 65535)},
+		{Key: settingHTTPSocket, Type: "bool", Default: "false", Description: "Serve the API on a Unix socket or named pipe"},
+		{Key: settingHTTPSocketPath, Type: "string", Description: "Socket or pipe path, empty for the default"},
+		{Key: settingHTTPTLS, Type: "bool", Default: "false", Description: "Serve HTTPS and WSS"},
+		{Key: settingHTTPTLSCert, Type: "string", Description: "Path of the PEM certificate file, empty for a self-signed one"},
+		{Key: settingHTTPTLSKey, Type: "string", Description: "Path of the PEM private key file of the certificate"},
+
+		// Remote desktop
+		{Key: guacdHostKey, Type: "string", Default: defaultGuacdHost, Description: "guacd host"},
+		{Key: guacdPortKey, Type: "int", Default: strconv.Itoa(defaultGuacdPort), Description: "guacd port", validate: intRange(1, 65535)},
+		{Key: settingGuacdMode, Type: "string", Default: "external", Description: "How guacd is run", validate: oneOf("external", "binary", "docker")},
+		{Key: settingGuacdBinary, Type: "string", Description: "guacd executable, empty looks next to the app and in PATH"},
+		{Key: settingGuacdDockerImage, Type: "string", Default: defaultGuacdImage, Description: "guacd Docker image"},
+
+		// Sync
+		{Key: settingSyncConfig, Type: "json", Secret: true, Description: "Sync target and credentials"},
+		{Key: settingSyncBase, Type: "json", Description: "Last snapshot both sync sides agreed on"},
+		{Key: settingSyncDeviceID, Type: "string", Description: "This device's sync ID"},
+		{Key: settingSyncLast, Type: "string", Description: "Time of the last sync"},
+	} {
+		settingDefs[def.Key] = def
+	}
+}
+
+// Validators
+
+func notEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+func oneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func intRange(lo, hi int) func(string) error {
+	return func(value string) error {
+		if n, err := strconv.Atoi(value); err != nil || n < lo || n > hi {
+			return fmt.Errorf("must be a whole number from %d to %d", lo, hi)
+		}
+		return nil
+	}
+}
+
+func httpsURL(value string) error {
+	if u, err := url.Parse(value); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("must be an https:// URL")
+	}
+	return nil
+}
+
+// validateSetting checks a value against its definition: the type, then the
+// setting's own validator
+func validateSetting(def SettingDef, value string) error {
+	var err error
+	switch def.Type {
+	case "int":
+		if _, e := strconv.Atoi(value); e != nil {
+			err = fmt.Errorf("must be a whole number")
+		}
+	case "bool":
+		if _, e := strconv.ParseBool(value); e != nil {
+			err = fmt.Errorf("must be true or false")
+		}
+	}
+	if err == nil && def.validate != nil {
+		err = def.validate(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", def.Key, err)
+	}
+	return nil
+}
+
+// settingValue returns a registered setting's stored value, or its default when
+// unset or invalid
+func settingValue(db *database.DB, key string) string {
+	def, ok := settingDefs[key]
+	if !ok {
+		settingsLog.Warn("setting is not registered", "key", key)
+	}
+	if s, err := db.GetSetting(key); err == nil && s != nil {
+		value := strings.TrimSpace(s.Value)
+		if value != "" && (!ok || validateSetting(def, value) == nil) {
+			return value
+		}
+	}
+	return def.Default
+}
+
+// settingInt returns a registered int setting
+func settingInt(db *database.DB, key string) int {
+	n, err := strconv.Atoi(settingValue(db, key))
+	if err != nil {
+		n, _ = strconv.Atoi(settingDefs[key].Default)
+	}
+	return n
+}
+
+// settingBool returns a registered bool setting
+func settingBool(db *database.DB, key string) bool {
+	on, _ := strconv.ParseBool(settingValue(db, key))
+	return on
+}
+
+// setSettingValue validates and stores a setting. Unregistered keys are stored as
+// strings.
+func setSettingValue(db *database.DB, key, value string) error {
+	def, ok := settingDefs[key]
+	if !ok {
+		return db.SetSetting(key, value, "string")
+	}
+	if err := validateSetting(def, value); err != nil {
+		return err
+	}
+	return db.SetSetting(key, value, def.Type)
+}
+
+// SetApp sets the Wails application instance; from then on every settings write
+// emits settings:changed
+func (s *SettingsService) SetApp(app *application.App) {
+	s.mu.Lock()
+	s.app = app
+	s.mu.Unlock()
+
+	app.Event.On("database:unlocked", func(e *application.CustomEvent) {
+		s.mu.Lock()
+		dir := s.overlayDir
+		s.overlayDir = ""
+		s.mu.Unlock()
+		if dir != "" {
+			s.applyConfigOverlay(dir)
+		}
+	})
+}
+
+// OnSettingChanged registers fn to be called with the key of every setting
+// written, for services that keep settings in memory
+func (s *SettingsService) OnSettingChanged(fn func(key string)) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, fn)
+	s.mu.Unlock()
+}
+
+func (s *SettingsService) settingChanged(key, value string) {
+	s.mu.Lock()
+	app, listeners := s.app, s.listeners
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(key)
+	}
+	if app != nil {
+		if settingDefs[key].Secret {
+			value = ""
+		}
+		app.Event.Emit("settings:changed", SettingChange{Key: key, Value: value})
+	}
+}
+
+// GetSettingDefinitions lists the registered settings, sorted by key
+func (s *SettingsService) GetSettingDefinitions() []SettingDef {
+	defs := make([]SettingDef, 0, len(settingDefs))
+	for _, def := range settingDefs {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Key < defs[j].Key })
+	return defs
+}
+
+// GetString returns a registered setting, or its default
+func (s *SettingsService) GetString(key string) string {
+	return settingValue(s.db, key)
+}
+
+// GetInt returns a registered int setting, or its default
+func (s *SettingsService) GetInt(key string) int {
+	return settingInt(s.db, key)
+}
+
+// GetBool returns a registered bool setting, or its default
+func (s *SettingsService) GetBool(key string) bool {
+	return settingBool(s.db, key)
+}
+
+// Set validates and stores a setting
+func (s *SettingsService) Set(key, value string) error {
+	return setSettingValue(s.db, key, value)
+}
@@ -1,17 +1,31 @@
 package main
 
 import (
-	"fmt"
+	"sync"
 	"term/database"
+	"term/logging"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
+var settingsLog = logging.For("settings")
+
 type SettingsService struct {
 	db *database.DB
+
+	mu        sync.Mutex
+	app       *application.App
+	listeners []func(key string)
+	overlay   ConfigOverlayStatus
+	// Config file directory waiting for the encrypted database to be unlocked
+	overlayDir string
 }
 
 // NewSettingsService creates a new settings service
 func NewSettingsService(db *database.DB) *SettingsService {
-	return &SettingsService{db: db}
+	s := &SettingsService{db: db}
+	db.OnSettingChanged(s.settingChanged)
+	return s
 }
 
 // GetSetting retrieves a single setting
@@ -23,140 +37,149 @@ func (s *SettingsService) GetSetting(key string) (*database.Setting, error) {
 func (s *SettingsService) GetAllSettings() (map[string]string, error) {
 	settings, err := s.db.GetAllSettings()
 	if err != nil {
-		fmt.Printf("BACKEND GetAllSettings error: %v\n", err)
 		return nil, err
 	}
-	fmt.Printf("BACKEND GetAllSettings result: %+v\n", settings)
 	return settings, nil
 }
 
-// SetSetting sets or updates a setting
+// SetSetting sets or updates a setting. Registered settings are validated and
+// stored with their declared type.
 func (s *SettingsService) SetSetting(key, value, valueType string) error {
+	if _, ok := settingDefs[key]; ok {
+		return setSettingValue(s.db, key, value)
+	}
 	return s.db.SetSetting(key, value, valueType)
 }
 
-// GetTheme retrieves the current theme setting
+// The per-setting methods below are kept for the frontend bindings; they read
+// and write through the settings registry (settings_registry.go).
+
+// GetTheme retrieves the theme setting
 func (s *SettingsService) GetTheme() (string, error) {
-	setting, err := s.db.GetSetting("theme")
-	if err != nil {
-		return "dark", err // default to dark
-	}
-	return setting.Value, nil
+	return s.GetString("theme"), nil
 }
 
 // SetTheme updates the theme setting
 func (s *SettingsService) SetTheme(theme string) error {
-	return s.db.SetSetting("theme", theme, "string")
+	return s.Set("theme", theme)
 }
 
 // GetFontFamily retrieves the font family setting
 func (s *SettingsService) GetFontFamily() (string, error) {
-	setting, err := s.db.GetSetting("font_family")
-	if err != nil {
-		return "monospace", err
-	}
-	return setting.Value, nil
+	return s.GetString("font_family"), nil
 }
 
 // SetFontFamily updates the font family setting
 func (s *SettingsService) SetFontFamily(fontFamily string) error {
-	return s.db.SetSetting("font_family", fontFamily, "string")
+	return s.Set("font_family", fontFamily)
 }
 
 // GetFontSize retrieves the font size setting
 func (s *SettingsService) GetFontSize() (string, error) {
-	setting, err := s.db.GetSetting("font_size")
-	if err != nil {
-		return "14", err
-	}
-	return setting.Value, nil
+	return s.GetString("font_size"), nil
 }
 
 // SetFontSize updates the font size setting
 func (s *SettingsService) SetFontSize(fontSize string) error {
-	return s.db.SetSetting("font_size", fontSize, "int")
+	return s.Set("font_size", fontSize)
 }
 
 // GetAutoLaunch retrieves the auto-launch setting
 func (s *SettingsService) GetAutoLaunch() (string, error) {
-	setting, err := s.db.GetSetting("auto_launch")
-	if err != nil {
-		return "true", err
-	}
-	return setting.Value, nil
+	return s.GetString("auto_launch"), nil
 }
 
 // SetAutoLaunch updates the auto-launch setting
 func (s *SettingsService) SetAutoLaunch(autoLaunch string) error {
-	return s.db.SetSetting("auto_launch", autoLaunch, "bool")
-}
-
-// SaveTabSnapshots saves the current tab snapshots
-func (s *SettingsService) SaveTabSnapshots(snapshots string) error {
-	return s.db.SetSetting("tab_snapshots", snapshots, "json")
-}
-
-// GetTabSnapshots retrieves the saved tab snapshots
-func (s *SettingsService) GetTabSnapshots() (string, error) {
-	setting, err := s.db.GetSetting("tab_snapshots")
-	if err != nil {
-		return "[]", nil // return empty array if not found
-	}
-	return setting.Value, nil
+	return s.Set("auto_launch", autoLaunch)
 }
 
 // GetRestoreTabsOnStartup retrieves the restore tabs on startup setting
 func (s *SettingsService) GetRestoreTabsOnStartup() (string, error) {
-	setting, err := s.db.GetSetting("restore_tabs_on_startup")
-	if err != nil {
-		return "true", nil // default to true
-	}
-	return setting.Value, nil
+	return s.GetString("restore_tabs_on_startup"), nil
 }
 
 // SetRestoreTabsOnStartup updates the restore tabs on startup setting
 func (s *SettingsService) SetRestoreTabsOnStartup(restore string) error {
-	fmt.Printf("BACKEND SetRestoreTabsOnStartup called with value: %s\n", restore)
-	err := s.db.SetSetting("restore_tabs_on_startup", restore, "bool")
-	if err != nil {
-		fmt.Printf("BACKEND SetRestoreTabsOnStartup error: %v\n", err)
-		return err
-	}
-	fmt.Printf("BACKEND SetRestoreTabsOnStartup saved successfully\n")
-	return nil
+	return s.Set("restore_tabs_on_startup", restore)
 }
 
 // GetConfirmTabClose retrieves the confirm tab close setting
 func (s *SettingsService) GetConfirmTabClose() (string, error) {
-	setting, err := s.db.GetSetting("confirm_tab_close")
-	if err != nil {
-		return "false", nil // default to false
-	}
-	return setting.Value, nil
+	return s.GetString("confirm_tab_close"), nil
 }
 
 // SetConfirmTabClose updates the confirm tab close setting
 func (s *SettingsService) SetConfirmTabClose(confirm string) error {
-	fmt.Printf("BACKEND SetConfirmTabClose called with value: %s\n", confirm)
-	err := s.db.SetSetting("confirm_tab_close", confirm, "bool")
-	if err != nil {
-		fmt.Printf("BACKEND SetConfirmTabClose error: %v\n", err)
-		return err
-	}
-	fmt.Printf("BACKEND SetConfirmTabClose saved successfully\n")
-	return nil
+	return s.Set("confirm_tab_close", confirm)
+}
+
+// SaveTabSnapshots saves the current tab snapshots
+func (s *SettingsService) SaveTabSnapshots(snapshots string) error {
+	return s.Set("tab_snapshots", snapshots)
+}
+
+// GetTabSnapshots retrieves the saved tab snapshots
+func (s *SettingsService) GetTabSnapshots() (string, error) {
+	return s.GetString("tab_snapshots"), nil
+}
+
+// GetCursorStyle retrieves the terminal cursor style: block, bar or underline
+func (s *SettingsService) GetCursorStyle() (string, error) {
+	return s.GetString("cursor_style"), nil
+}
+
+// SetCursorStyle updates the terminal cursor style
+func (s *SettingsService) SetCursorStyle(style string) error {
+	return s.Set("cursor_style", style)
+}
+
+// GetTerminalPadding retrieves the space around the terminal text, in pixels
+func (s *SettingsService) GetTerminalPadding() (string, error) {
+	return s.GetString("terminal_padding"), nil
+}
+
+// SetTerminalPadding updates the terminal padding (0-64 pixels)
+func (s *SettingsService) SetTerminalPadding(padding string) error {
+	return s.Set("terminal_padding", padding)
 }
 
 // GetShowStatusBar retrieves the show status bar setting
 func (s *SettingsService) GetShowStatusBar() (string, error) {
-	setting, err := s.db.GetSetting("show_status_bar")
-	if err != nil {
-		return "true", nil // default to true
-	}
-	return setting.Value, nil
+	return s.GetString("show_status_bar"), nil
 }
 
 // SetShowStatusBar updates the show status bar setting
 func (s *SettingsService) SetShowStatusBar(show string) error {
-	return s.db.SetSetting("show_status_bar", show, "bool")
+	return s.Set("show_status_bar", show)
+}
+
+// GetSystemKnownHostsSync retrieves whether ~/.ssh/known_hosts is read and updated
+func (s *SettingsService) GetSystemKnownHostsSync() (string, error) {
+	return s.GetString(settingSystemKnownHosts), nil
+}
+
+// SetSystemKnownHostsSync updates the system known_hosts sync setting
+func (s *SettingsService) SetSystemKnownHostsSync(enabled string) error {
+	return s.Set(settingSystemKnownHosts, enabled)
+}
+
+// GetHostKeyMaxAgeDays retrieves how many days a trusted host key is valid before review
+func (s *SettingsService) GetHostKeyMaxAgeDays() (string, error) {
+	return s.GetString(settingHostKeyMaxAge), nil
+}
+
+// SetHostKeyMaxAgeDays updates the host key review age (0 disables reminders)
+func (s *SettingsService) SetHostKeyMaxAgeDays(days string) error {
+	return s.Set(settingHostKeyMaxAge, days)
+}
+
+// GetVaultAutoLockMinutes retrieves the vault inactivity timeout in minutes
+func (s *SettingsService) GetVaultAutoLockMinutes() (string, error) {
+	return s.GetString(settingVaultAutoLock), nil
+}
+
+// SetVaultAutoLockMinutes updates the vault inactivity timeout (0 disables auto-lock)
+func (s *SettingsService) SetVaultAutoLockMinutes(minutes string) error {
+	return s.Set(settingVaultAutoLock, minutes)
 }
@@ -10,15 +10,16 @@ import (
 
 // sftpClientAdapter wraps github.com/pkg/sftp.Client to keep httpserver decoupled
 type sftpClientAdapter struct {
-	c *sftp.Client
+	c        *sftp.Client
+	transfer *transferCounter // file data read and written
 }
 
-func newSFTPClientAdapter(client *ssh.Client) (*sftpClientAdapter, error) {
+func newSFTPClientAdapter(client *ssh.Client, transfer *transferCounter) (*sftpClientAdapter, error) {
 	c, err := sftp.NewClient(client, sftp.UseConcurrentWrites(true))
 	if err != nil {
 		return nil, err
 	}
-	return &sftpClientAdapter{c: c}, nil
+	return &sftpClientAdapter{c: c, transfer: transfer}, nil
 }
 
 func (a *sftpClientAdapter) Close() error { return a.c.Close() }
@@ -27,9 +28,48 @@ func (a *sftpClientAdapter) RealPath(p string) (string, error) { return a.c.Real
 
 func (a *sftpClientAdapter) ReadDir(p string) ([]os.FileInfo, error) { return a.c.ReadDir(p) }
 
-func (a *sftpClientAdapter) Open(p string) (io.ReadCloser, error) { return a.c.Open(p) }
+func (a *sftpClientAdapter) Open(p string) (io.ReadCloser, error) {
+	f, err := a.c.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{ReadCloser: f, counter: a.transfer}, nil
+}
+
+// OpenFile opens a remote file for reading at arbitrary offsets
+func (a *sftpClientAdapter) OpenFile(p string) (*sftpFile, error) {
+	f, err := a.c.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{File: f, counter: a.transfer}, nil
+}
 
-func (a *sftpClientAdapter) Create(p string) (io.WriteCloser, error) { return a.c.Create(p) }
+func (a *sftpClientAdapter) Create(p string) (io.WriteCloser, error) {
+	f, err := a.c.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	return &countingWriteCloser{WriteCloser: f, counter: a.transfer}, nil
+}
+
+// sftpFile is a remote file whose reads are counted
+type sftpFile struct {
+	*sftp.File
+	counter *transferCounter
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.counter.read.Add(uint64(n))
+	return n, err
+}
+
+func (f *sftpFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.counter.read.Add(uint64(n))
+	return n, err
+}
 
 func sftpMkdirAll(a *sftpClientAdapter, p string) error { return a.c.MkdirAll(p) }
 
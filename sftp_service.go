@@ -2,32 +2,68 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/wailsapp/wails/v3/pkg/application"
-	"golang.org/x/crypto/ssh"
 )
 
+// Shutdown waits this long for transfers in flight before cancelling them
+const sftpDrainTimeout = 30 * time.Second
+
 type SftpService struct {
 	terminalService   *TerminalService
 	uploadMgr         *UploadManager
-	sftpSessionsCache map[string]*sftpClientAdapter
+	cacheMu           sync.Mutex
+	sftpSessionsCache map[string]*sftpClientAdapter // SFTP client of each terminal, guarded by cacheMu
+
+	// Transfers in flight; shutdown waits for them, then cancels ctx
+	ctx       context.Context
+	cancel    context.CancelFunc
+	transfers sync.WaitGroup
+
+	// runJournal records the local files of downloads in flight, which are
+	// removed after a crash so that an interrupted download does not look complete
+	runJournal *runJournal
 }
 
 func NewSFTPService(app *application.App, ts *TerminalService) *SftpService {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &SftpService{
 		terminalService:   ts,
 		uploadMgr:         NewUploadManager(app),
 		sftpSessionsCache: make(map[string]*sftpClientAdapter),
+		ctx:               ctx,
+		cancel:            cancel,
 	}
 }
 
+// cachedClient returns the SFTP client of a terminal, opening one over its SSH
+// connection the first time
+func (s *SftpService) cachedClient(sessionID string, session *TerminalSession) (*sftpClientAdapter, error) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if c := s.sftpSessionsCache[sessionID]; c != nil {
+		return c, nil
+	}
+	c, err := sftpNewClient(session)
+	if err != nil {
+		return nil, err
+	}
+	s.sftpSessionsCache[sessionID] = c
+	return c, nil
+}
+
 type FileList struct {
 	RemotePath string      `json:"remote_path"`
 	Files      []FileEntry `json:"files"`
@@ -57,18 +93,11 @@ func (s *SftpService) HandleSSHFSList(sessionID string, remotePath string) (File
 		}, fmt.Errorf("ssh session not found")
 	}
 
-	var err error
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return FileList{
-				RemotePath: remotePath,
-			}, fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return FileList{
+			RemotePath: remotePath,
+		}, fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	remotePath = strings.TrimSpace(remotePath)
@@ -108,7 +137,7 @@ func (s *SftpService) HandleSSHFSList(sessionID string, remotePath string) (File
 	return res, nil
 }
 
-func (s *SftpService) HandleSSHFSDownload(sessionID string, remotePath string, dest string) error {
+func (s *SftpService) HandleSSHFSDownload(ctx context.Context, sessionID string, remotePath string, dest string) error {
 	sessionID = strings.TrimSpace(sessionID)
 	if sessionID == "" {
 		return fmt.Errorf("session ID required")
@@ -123,16 +152,9 @@ func (s *SftpService) HandleSSHFSDownload(sessionID string, remotePath string, d
 		return fmt.Errorf("ssh session not found")
 	}
 
-	var err error
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	f, err := sftpClient.Open(remotePath)
@@ -146,9 +168,15 @@ func (s *SftpService) HandleSSHFSDownload(sessionID string, remotePath string, d
 		return fmt.Errorf("failed to create local file: %v", err)
 	}
 	defer w.Close()
+	s.runJournal.addFile(dest)
+	defer s.runJournal.removeFile(dest)
 
-	if _, err := io.Copy(w, f); err != nil {
-		return fmt.Errorf("failed to download file: %v", err)
+	ctx, done := s.beginTransfer(ctx, session)
+	defer done()
+	if _, err := copyContext(ctx, w, f); err != nil {
+		w.Close()
+		os.Remove(dest)
+		return transferError("download file", err)
 	}
 
 	return nil
@@ -169,16 +197,9 @@ func (s *SftpService) HandleSSHFSMkdir(sessionID string, remotePath string, recu
 		return fmt.Errorf("ssh session not found")
 	}
 
-	var err error
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	var mkErr error
@@ -194,7 +215,7 @@ func (s *SftpService) HandleSSHFSMkdir(sessionID string, remotePath string, recu
 	return nil
 }
 
-func (s *SftpService) HandleSSHFSUpload(sessionID, localPath, destDir, jobID string) error {
+func (s *SftpService) HandleSSHFSUpload(ctx context.Context, sessionID, localPath, destDir, jobID string) error {
 	if sessionID == "" {
 		return fmt.Errorf("session ID required")
 	}
@@ -231,15 +252,9 @@ func (s *SftpService) HandleSSHFSUpload(sessionID, localPath, destDir, jobID str
 	// Use local filename as remote filename
 	remotePath := posixJoin(destDir, fileBase(localPath))
 
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	// Ensure directory exists (best-effort)
@@ -259,19 +274,29 @@ func (s *SftpService) HandleSSHFSUpload(sessionID, localPath, destDir, jobID str
 	}
 	defer dst.Close()
 
+	ctx, done := s.beginTransfer(ctx, session)
+	defer done()
+	// A partial upload is removed rather than left looking complete
+	failed := func(err error) error {
+		dst.Close()
+		_ = sftpClient.Remove(remotePath)
+		return transferError("upload file", err)
+	}
+
 	// Progress-enabled copy
 	if jobID != "" && s.uploadMgr != nil {
 		// Publish initial state
 		s.uploadMgr.Publish(jobID, UploadProgress{Total: lfi.Size(), Transferred: 0, Done: false, Error: ""})
 		pr := &progressReader{r: src, total: lfi.Size(), jobID: jobID, mgr: s.uploadMgr}
-		if _, err := io.Copy(dst, pr); err != nil {
+		if _, err := copyContext(ctx, dst, pr); err != nil {
+			err = failed(err)
 			s.uploadMgr.Publish(jobID, UploadProgress{Total: lfi.Size(), Transferred: pr.transferred, Done: true, Error: err.Error()})
-			return fmt.Errorf("failed to upload file: %v", err)
+			return err
 		}
 		s.uploadMgr.Publish(jobID, UploadProgress{Total: lfi.Size(), Transferred: lfi.Size(), Done: true, Error: ""})
 	} else {
-		if _, err := io.Copy(dst, src); err != nil {
-			return fmt.Errorf("failed to upload file: %v", err)
+		if _, err := copyContext(ctx, dst, src); err != nil {
+			return failed(err)
 		}
 	}
 
@@ -293,16 +318,9 @@ func (s *SftpService) HandleSSHFSRename(sessionID, oldPath, newPath string) erro
 		return fmt.Errorf("ssh session not found")
 	}
 
-	var err error
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	if err := sftpClient.Rename(oldPath, newPath); err != nil {
@@ -327,16 +345,9 @@ func (s *SftpService) HandleSSHFSDelete(sessionID string, path string) error {
 		return fmt.Errorf("ssh session not found")
 	}
 
-	var err error
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	if err := sftpRemoveAll(sftpClient, path); err != nil {
@@ -345,7 +356,7 @@ func (s *SftpService) HandleSSHFSDelete(sessionID string, path string) error {
 	return nil
 }
 
-func (s *SftpService) HandleSSHFSDownloadDir(sessionID string, remotePath string, localPath string) error {
+func (s *SftpService) HandleSSHFSDownloadDir(ctx context.Context, sessionID string, remotePath string, localPath string) error {
 	sessionID = strings.TrimSpace(sessionID)
 	remotePath = strings.TrimSpace(remotePath)
 	if sessionID == "" || remotePath == "" {
@@ -357,16 +368,9 @@ func (s *SftpService) HandleSSHFSDownloadDir(sessionID string, remotePath string
 		return fmt.Errorf("ssh session not found")
 	}
 
-	var err error
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	base := fileBase(remotePath)
@@ -385,15 +389,21 @@ func (s *SftpService) HandleSSHFSDownloadDir(sessionID string, remotePath string
 		return fmt.Errorf("failed to create local zip file: %v", err)
 	}
 	defer w.Close()
+	s.runJournal.addFile(zipFileName)
+	defer s.runJournal.removeFile(zipFileName)
 
-	if err := sftpZipDirToWriter(sftpClient, remotePath, w); err != nil {
-		return fmt.Errorf("failed to zip directory: %v", err)
+	ctx, done := s.beginTransfer(ctx, session)
+	defer done()
+	if err := sftpZipDirToWriter(ctx, sftpClient, remotePath, w); err != nil {
+		w.Close()
+		os.Remove(zipFileName)
+		return transferError("zip directory", err)
 	}
 
 	return nil
 }
 
-func (s *SftpService) HandleSSHFSSaveDir(sessionID string, remotePath string, dest string) error {
+func (s *SftpService) HandleSSHFSSaveDir(ctx context.Context, sessionID string, remotePath string, dest string) error {
 	sessionID = strings.TrimSpace(sessionID)
 
 	if sessionID == "" || remotePath == "" || dest == "" {
@@ -405,16 +415,9 @@ func (s *SftpService) HandleSSHFSSaveDir(sessionID string, remotePath string, de
 		return fmt.Errorf("ssh session not found")
 	}
 
-	var err error
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
@@ -426,9 +429,15 @@ func (s *SftpService) HandleSSHFSSaveDir(sessionID string, remotePath string, de
 		return fmt.Errorf("failed to create destination file: %v", err)
 	}
 	defer f.Close()
+	s.runJournal.addFile(dest)
+	defer s.runJournal.removeFile(dest)
 
-	if err := sftpZipDirToWriter(sftpClient, remotePath, f); err != nil {
-		return fmt.Errorf("failed to zip directory: %v", err)
+	ctx, done := s.beginTransfer(ctx, session)
+	defer done()
+	if err := sftpZipDirToWriter(ctx, sftpClient, remotePath, f); err != nil {
+		f.Close()
+		os.Remove(dest)
+		return transferError("zip directory", err)
 	}
 
 	return nil
@@ -463,7 +472,7 @@ func sftpRemoveAll(c *sftpClientAdapter, p string) error {
 	return c.RemoveDirectory(p)
 }
 
-func sftpZipDirToWriter(c *sftpClientAdapter, root string, w io.Writer) error {
+func sftpZipDirToWriter(ctx context.Context, c *sftpClientAdapter, root string, w io.Writer) error {
 	zw := zip.NewWriter(w)
 	defer zw.Close()
 
@@ -514,7 +523,7 @@ func sftpZipDirToWriter(c *sftpClientAdapter, root string, w io.Writer) error {
 				if err != nil {
 					return err
 				}
-				if _, err := io.Copy(fw, rc); err != nil {
+				if _, err := copyContext(ctx, fw, rc); err != nil {
 					rc.Close()
 					return err
 				}
@@ -538,7 +547,7 @@ func sftpZipDirToWriter(c *sftpClientAdapter, root string, w io.Writer) error {
 	return walk(root, base)
 }
 
-func (s *SftpService) HandleSSHFSSave(sessionID string, remotePath string, destPath string) error {
+func (s *SftpService) HandleSSHFSSave(ctx context.Context, sessionID string, remotePath string, destPath string) error {
 	sessionID = strings.TrimSpace(sessionID)
 	if sessionID == "" {
 		return fmt.Errorf("session ID required")
@@ -553,16 +562,9 @@ func (s *SftpService) HandleSSHFSSave(sessionID string, remotePath string, destP
 		return fmt.Errorf("ssh session not found")
 	}
 
-	var err error
-	var sftpClient *sftpClientAdapter
-	if s.sftpSessionsCache != nil && s.sftpSessionsCache[sessionID] != nil {
-		sftpClient = s.sftpSessionsCache[sessionID]
-	} else {
-		sftpClient, err = sftpNewClient(session.SSHClient)
-		if err != nil {
-			return fmt.Errorf("failed to create sftp client: %v", err)
-		}
-		s.sftpSessionsCache[sessionID] = sftpClient
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
 	}
 
 	src, err := sftpClient.Open(remotePath)
@@ -580,23 +582,96 @@ func (s *SftpService) HandleSSHFSSave(sessionID string, remotePath string, destP
 		return fmt.Errorf("failed to create destination file: %v", err)
 	}
 	defer dst.Close()
+	s.runJournal.addFile(destPath)
+	defer s.runJournal.removeFile(destPath)
 
-	if _, err := io.Copy(dst, src); err != nil {
-		return fmt.Errorf("failed to save file: %v", err)
+	ctx, done := s.beginTransfer(ctx, session)
+	defer done()
+	if _, err := copyContext(ctx, dst, src); err != nil {
+		dst.Close()
+		os.Remove(destPath)
+		return transferError("save file", err)
 	}
 
 	return nil
 }
 
-func sftpNewClient(client *ssh.Client) (*sftpClientAdapter, error) {
-	return newSFTPClientAdapter(client)
+// sftpNewClient opens an SFTP client over a terminal's SSH connection, counting
+// the file data it moves with the terminal's transfer stats
+func sftpNewClient(session *TerminalSession) (*sftpClientAdapter, error) {
+	return newSFTPClientAdapter(session.SSHClient, &session.sftpTransfer)
 }
 
-func (s *SftpService) ServiceShutdown() error {
-	if s.sftpSessionsCache != nil {
-		for _, c := range s.sftpSessionsCache {
-			_ = c.Close()
-		}
+// beginTransfer tracks a transfer over a terminal's connection until done is
+// called. Its context ends when the frontend cancels the call or shutdown gives
+// up waiting for transfers. done adds the data moved to the daily usage, as the
+// terminal may have been closed, and no longer be recorded, while it ran.
+func (s *SftpService) beginTransfer(ctx context.Context, session *TerminalSession) (context.Context, func()) {
+	s.transfers.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		s.terminalService.recordTransfer(session)
+		s.transfers.Done()
 	}
-	return nil
+}
+
+// transferError describes a failed transfer, telling an interrupted one apart so
+// the user knows the file was not completed
+func transferError(op string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("failed to %s: interrupted before it completed, the partial file was removed", op)
+	}
+	return fmt.Errorf("failed to %s: %v", op, err)
+}
+
+// copyContext is io.Copy that stops with the context's error once it ends
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return io.Copy(dst, &contextReader{ctx: ctx, r: src})
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// waitTransfers reports whether the transfers in flight finished within timeout
+func (s *SftpService) waitTransfers(timeout time.Duration) bool {
+	drained := make(chan struct{})
+	go func() {
+		s.transfers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shutdown lets transfers in flight finish, cancelling those still running
+// after sftpDrainTimeout, before closing the SFTP clients. It runs on quit
+// before the SSH connections they use are closed.
+func (s *SftpService) shutdown() {
+	if !s.waitTransfers(sftpDrainTimeout) {
+		log.Printf("SFTP transfers still running after %s; cancelling them", sftpDrainTimeout)
+		s.cancel()
+		s.waitTransfers(5 * time.Second)
+	}
+	s.cancel()
+	s.cacheMu.Lock()
+	for _, c := range s.sftpSessionsCache {
+		_ = c.Close()
+	}
+	s.cacheMu.Unlock()
 }
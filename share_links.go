@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Live share links give read-only access to a terminal's output stream
+const (
+	defaultShareLinkTTL = time.Hour
+	maxShareLinkTTL     = 24 * time.Hour
+	shareViewerBuffer   = 256 // output chunks queued per viewer before it is dropped as too slow
+)
+
+// ShareLink lets whoever holds Token watch the output of one terminal at Path on the
+// HTTP server until ExpiresAt or until it is revoked. Viewers see output from the
+// moment they connect and cannot send input.
+type ShareLink struct {
+	Token      string    `json:"token"`
+	TerminalID string    `json:"terminalId"`
+	Path       string    `json:"path"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Viewers    int       `json:"viewers"`
+
+	viewers map[*shareViewer]struct{}
+	expiry  *time.Timer
+}
+
+// shareViewer is a WebSocket client watching a share link
+type shareViewer struct {
+	output    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (v *shareViewer) close() {
+	v.closeOnce.Do(func() { close(v.done) })
+}
+
+// CreateShareLink creates a read-only share link for a running terminal, valid for
+// the given number of minutes (default 60, at most 24 hours)
+func (a *APIService) CreateShareLink(terminalID string, minutes int) (ShareLink, error) {
+	return a.server.createShareLink(terminalID, time.Duration(minutes)*time.Minute)
+}
+
+// ListShareLinks returns the share links that have not expired or been revoked
+func (a *APIService) ListShareLinks() []ShareLink {
+	return a.server.listShareLinks()
+}
+
+// RevokeShareLink ends a share link and disconnects its viewers
+func (a *APIService) RevokeShareLink(token string) error {
+	return a.server.revokeShareLink(token, "revoked")
+}
+
+func (h *HTTPServer) createShareLink(terminalID string, ttl time.Duration) (ShareLink, error) {
+	if !h.termService.IsSessionRunning(terminalID) {
+		return ShareLink{}, fmt.Errorf("terminal %s is not running", terminalID)
+	}
+	if ttl <= 0 {
+		ttl = defaultShareLinkTTL
+	}
+	if ttl > maxShareLinkTTL {
+		ttl = maxShareLinkTTL
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return ShareLink{}, fmt.Errorf("failed to generate share token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	link := &ShareLink{
+		Token:      token,
+		TerminalID: terminalID,
+		Path:       apiPrefix + "/share/" + token,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+		viewers:    make(map[*shareViewer]struct{}),
+	}
+	link.expiry = time.AfterFunc(ttl, func() { h.revokeShareLink(token, "expired") })
+
+	h.mu.Lock()
+	h.shares[token] = link
+	snapshot := *link
+	h.mu.Unlock()
+	log.Printf("Created share link for terminal %s, expires %s", terminalID, link.ExpiresAt.Format(time.RFC3339))
+	h.emitShare(snapshot, "created")
+	return snapshot, nil
+}
+
+func (h *HTTPServer) listShareLinks() []ShareLink {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	links := make([]ShareLink, 0, len(h.shares))
+	for _, link := range h.shares {
+		links = append(links, *link)
+	}
+	return links
+}
+
+// revokeShareLink removes a share link and disconnects its viewers; state is the
+// reason reported with terminal:share ("revoked" or "expired")
+func (h *HTTPServer) revokeShareLink(token, state string) error {
+	h.mu.Lock()
+	link, ok := h.shares[token]
+	if !ok {
+		h.mu.Unlock()
+		return fmt.Errorf("share link not found")
+	}
+	delete(h.shares, token)
+	link.expiry.Stop()
+	for v := range link.viewers {
+		v.close()
+	}
+	link.Viewers = 0
+	snapshot := *link
+	h.mu.Unlock()
+	log.Printf("Share link for terminal %s %s", link.TerminalID, state)
+	h.emitShare(snapshot, state)
+	return nil
+}
+
+// shareOutput is the terminal service's output tap, fanning output out to the
+// viewers of the terminal's share links
+func (h *HTTPServer) shareOutput(terminalID string, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.shares) == 0 {
+		return
+	}
+	var chunk []byte
+	for _, link := range h.shares {
+		if link.TerminalID != terminalID {
+			continue
+		}
+		for v := range link.viewers {
+			if chunk == nil {
+				chunk = append([]byte(nil), data...)
+			}
+			select {
+			case v.output <- chunk:
+			default:
+				v.close() // too slow to keep up; it would block the terminal otherwise
+			}
+		}
+	}
+}
+
+// handleShareView streams a shared terminal's output to a read-only viewer. The share
+// token in the path authorizes the request instead of the API bearer token.
+func (h *HTTPServer) handleShareView(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	viewer := &shareViewer{output: make(chan []byte, shareViewerBuffer), done: make(chan struct{})}
+
+	h.mu.Lock()
+	link, ok := h.shares[token]
+	var snapshot ShareLink
+	if ok {
+		link.viewers[viewer] = struct{}{}
+		link.Viewers = len(link.viewers)
+		snapshot = *link
+	}
+	h.mu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "share_link_not_found", fmt.Errorf("share link not found or expired"))
+		return
+	}
+	h.emitShare(snapshot, "viewers")
+	defer h.removeShareViewer(token, viewer)
+
+	wsConn, err := h.guacService.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade WebSocket: %v", err)
+		return
+	}
+	defer wsConn.Close()
+	defer closeOnShutdown(r, wsConn)()
+	log.Printf("Viewer %s is watching terminal %s", r.RemoteAddr, link.TerminalID)
+
+	// Input is not accepted; reading only notices the viewer leaving and answers pings
+	wsConn.SetReadLimit(512)
+	wsConn.SetReadDeadline(time.Now().Add(guacPongWait))
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(guacPongWait))
+	})
+	go func() {
+		defer viewer.close()
+		for {
+			if _, _, err := wsConn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(guacPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case data := <-viewer.output:
+			wsConn.SetWriteDeadline(time.Now().Add(guacWriteWait))
+			if err := wsConn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if !h.termService.IsSessionRunning(link.TerminalID) {
+				wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "terminal closed"), time.Now().Add(guacWriteWait))
+				return
+			}
+			if err := wsConn.WriteControl(websocket.PingMessage, nil, time.Now().Add(guacWriteWait)); err != nil {
+				return
+			}
+		case <-viewer.done:
+			wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "share ended"), time.Now().Add(guacWriteWait))
+			return
+		}
+	}
+}
+
+// removeShareViewer forgets a viewer that left and reports the new viewer count
+func (h *HTTPServer) removeShareViewer(token string, viewer *shareViewer) {
+	viewer.close()
+	h.mu.Lock()
+	link, ok := h.shares[token]
+	var snapshot ShareLink
+	if ok {
+		delete(link.viewers, viewer)
+		link.Viewers = len(link.viewers)
+		snapshot = *link
+	}
+	h.mu.Unlock()
+	if ok {
+		h.emitShare(snapshot, "viewers")
+	}
+}
+
+// handleCreateShareLink serves POST /terminals/{id}/share with an optional
+// {"minutes"} body
+func (h *HTTPServer) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Minutes int `json:"minutes"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_body", fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	link, err := h.createShareLink(r.PathValue("id"), time.Duration(body.Minutes)*time.Minute)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "terminal_not_running", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, link)
+}
+
+func (h *HTTPServer) handleRevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	if err := h.revokeShareLink(r.PathValue("token"), "revoked"); err != nil {
+		writeJSONError(w, http.StatusNotFound, "share_link_not_found", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// emitShare reports share link changes as terminal:share: "created", "viewers"
+// (someone started or stopped watching), "revoked" and "expired"
+func (h *HTTPServer) emitShare(link ShareLink, state string) {
+	if app := h.termService.app; app != nil {
+		app.Event.Emit("terminal:share", map[string]interface{}{
+			"id":        link.TerminalID,
+			"token":     link.Token,
+			"state":     state,
+			"viewers":   link.Viewers,
+			"expiresAt": link.ExpiresAt,
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"term/logging"
+)
+
+var shutdownLog = logging.For("shutdown")
+
+// sessionCloseGrace is how long local shells get to exit after being hung up on
+// quit, before they are killed
+const sessionCloseGrace = 3 * time.Second
+
+// ShutdownManager stops the app in order when it quits, instead of letting the
+// process exit under goroutines that are still writing. Steps run one after
+// another, each for at most its timeout; final steps run last, after the
+// services were shut down, in the reverse order they were added like defers.
+type ShutdownManager struct {
+	mu    sync.Mutex
+	steps []shutdownStep
+	final []shutdownStep
+
+	runOnce    sync.Once
+	finishOnce sync.Once
+}
+
+type shutdownStep struct {
+	name    string
+	timeout time.Duration
+	fn      func()
+}
+
+func NewShutdownManager() *ShutdownManager {
+	return &ShutdownManager{}
+}
+
+// Add adds a step run when the app starts to quit
+func (m *ShutdownManager) Add(name string, timeout time.Duration, fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.steps = append(m.steps, shutdownStep{name: name, timeout: timeout, fn: fn})
+}
+
+// AddFinal adds a step run after everything else, e.g. closing the database
+func (m *ShutdownManager) AddFinal(name string, fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.final = append(m.final, shutdownStep{name: name, fn: fn})
+}
+
+// Run runs the steps in the order they were added
+func (m *ShutdownManager) Run() {
+	m.runOnce.Do(func() {
+		m.mu.Lock()
+		steps := append([]shutdownStep(nil), m.steps...)
+		m.mu.Unlock()
+		started := time.Now()
+		shutdownLog.Info("shutting down", "steps", len(steps))
+		for _, step := range steps {
+			runShutdownStep(step)
+		}
+		shutdownLog.Info("services stopped", "took", time.Since(started).Round(time.Millisecond))
+	})
+}
+
+// Finish runs the final steps. It runs once, whether from the app's last
+// shutdown hook or when main returns.
+func (m *ShutdownManager) Finish() {
+	m.Run()
+	m.finishOnce.Do(func() {
+		m.mu.Lock()
+		final := append([]shutdownStep(nil), m.final...)
+		m.mu.Unlock()
+		for i := len(final) - 1; i >= 0; i-- {
+			final[i].fn()
+		}
+	})
+}
+
+// runShutdownStep runs a step, leaving it behind when it takes longer than its
+// timeout so that one stuck service does not keep the app from quitting
+func runShutdownStep(step shutdownStep) {
+	done := make(chan struct{})
+	started := time.Now()
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				shutdownLog.Error("shutdown step panicked", "step", step.name, "panic", r)
+			}
+		}()
+		step.fn()
+	}()
+	if step.timeout <= 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+		shutdownLog.Debug("shutdown step done", "step", step.name, "took", time.Since(started).Round(time.Millisecond))
+	case <-time.After(step.timeout):
+		shutdownLog.Warn("shutdown step timed out", "step", step.name, "timeout", step.timeout)
+	}
+}
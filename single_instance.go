@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// instanceKeyFile holds the key that encrypts what a second instance forwards
+// to the running one (links and CLI arguments). Only this user can read it, so
+// other local users cannot send the app messages it accepts.
+const instanceKeyFile = "instance.key"
+
+// loadInstanceKey returns the single-instance key kept in dir, generating it on
+// first run. Without a key every instance would use its own random one: links
+// still open, just not in the running instance.
+func loadInstanceKey(dir string) [32]byte {
+	key, err := readOrCreateInstanceKey(filepath.Join(dir, instanceKeyFile))
+	if err == nil {
+		return key
+	}
+	log.Printf("Single-instance key unavailable, using a one-off key: %v", err)
+	if _, err := rand.Read(key[:]); err != nil {
+		log.Fatal("Failed to generate single-instance key:", err)
+	}
+	return key
+}
+
+func readOrCreateInstanceKey(path string) ([32]byte, error) {
+	var key [32]byte
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return key, err
+	}
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if len(data) != len(key) {
+				return key, fmt.Errorf("%s: invalid key of %d bytes", path, len(data))
+			}
+			if instanceKeyExposed(path) {
+				// Others may have read it: replace it rather than keep using it
+				if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return key, err
+				}
+				continue
+			}
+			copy(key[:], data)
+			return key, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return key, err
+		}
+
+		if _, err := rand.Read(key[:]); err != nil {
+			return key, err
+		}
+		// O_EXCL: of two instances started at once, the second reads the first's key
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return key, err
+		}
+		_, err = f.Write(key[:])
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			return key, err
+		}
+		return key, nil
+	}
+}
+
+// instanceKeyExposed reports whether a key file is readable by other users,
+// e.g. after it was copied with the config directory. On Windows the profile's
+// ACLs already keep it private.
+func instanceKeyExposed(path string) bool {
+	if runtime.GOOS == "windows" {
+		return false
+	}
+	st, err := os.Stat(path)
+	if err != nil || st.Mode().Perm()&0077 == 0 {
+		return false
+	}
+	log.Printf("Single-instance key %s was readable by other users, generating a new one", path)
+	return true
+}
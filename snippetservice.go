@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// snippetVarPattern matches ${name} and ${name:default} placeholders; $${...}
+// is an escape for a literal ${...}, e.g. a shell variable
+var snippetVarPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?\}`)
+
+// SnippetVariable is a placeholder of a snippet, prompted for before it runs
+type SnippetVariable struct {
+	Name    string `json:"name"`
+	Default string `json:"default"`
+}
+
+// SnippetRunResult is the outcome of running a snippet on one terminal
+type SnippetRunResult struct {
+	TabID string `json:"tabId"`
+	Error string `json:"error,omitempty"`
+}
+
+// SnippetService is the snippet library: named commands with descriptions, tags
+// and ${variable} placeholders, typed into the current terminal or into several
+// at once. A snippet can be scoped to a folder of the session tree.
+type SnippetService struct {
+	app      *application.App
+	db       *database.DB
+	terminal *TerminalService
+}
+
+// NewSnippetService creates the snippet service
+func NewSnippetService(app *application.App, db *database.DB, terminal *TerminalService) *SnippetService {
+	return &SnippetService{app: app, db: db, terminal: terminal}
+}
+
+// ListSnippets returns every snippet
+func (s *SnippetService) ListSnippets() ([]database.Snippet, error) {
+	return s.db.ListSnippets()
+}
+
+// ListSnippetsFor returns the snippets offered for a session tree node: those
+// without a folder and those of the folders it is in
+func (s *SnippetService) ListSnippetsFor(nodeID string) ([]database.Snippet, error) {
+	snippets, err := s.db.ListSnippets()
+	if err != nil {
+		return nil, err
+	}
+	parents, err := s.sessionParents()
+	if err != nil {
+		return nil, err
+	}
+	result := []database.Snippet{}
+	for _, n := range snippets {
+		if snippetInScope(n, nodeID, parents) {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+// CreateSnippet adds a snippet to the library
+func (s *SnippetService) CreateSnippet(n database.Snippet) (*database.Snippet, error) {
+	if err := s.validate(&n); err != nil {
+		return nil, err
+	}
+	if err := s.db.CreateSnippet(&n); err != nil {
+		return nil, err
+	}
+	s.emitChanged()
+	return s.db.GetSnippet(n.ID)
+}
+
+// UpdateSnippet changes a snippet
+func (s *SnippetService) UpdateSnippet(n database.Snippet) error {
+	if err := s.validate(&n); err != nil {
+		return err
+	}
+	if err := s.db.UpdateSnippet(&n); err != nil {
+		return err
+	}
+	s.emitChanged()
+	return nil
+}
+
+// DeleteSnippet removes a snippet
+func (s *SnippetService) DeleteSnippet(id int64) error {
+	if err := s.db.DeleteSnippet(id); err != nil {
+		return err
+	}
+	s.emitChanged()
+	return nil
+}
+
+// GetSnippetVariables returns the placeholders of a snippet in the order they
+// first appear, for the frontend to prompt for
+func (s *SnippetService) GetSnippetVariables(id int64) ([]SnippetVariable, error) {
+	n, err := s.db.GetSnippet(id)
+	if err != nil {
+		return nil, err
+	}
+	return snippetVariables(n.Command), nil
+}
+
+// RunSnippet types a snippet into each of the given terminals, the current one
+// or a broadcast group, followed by Enter. values fill its placeholders; a
+// placeholder without a value and without a default is an error. Terminals
+// opened from outside the snippet's folder are skipped.
+func (s *SnippetService) RunSnippet(id int64, tabIDs []string, values map[string]string) ([]SnippetRunResult, error) {
+	n, err := s.db.GetSnippet(id)
+	if err != nil {
+		return nil, fmt.Errorf("snippet not found: %w", err)
+	}
+	if len(tabIDs) == 0 {
+		return nil, fmt.Errorf("no terminal to run %s on", n.Name)
+	}
+	command, err := expandSnippet(n.Command, values)
+	if err != nil {
+		return nil, err
+	}
+	var parents map[string]string
+	if n.FolderID != nil {
+		if parents, err = s.sessionParents(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]SnippetRunResult, 0, len(tabIDs))
+	for _, tab := range tabIDs {
+		result := SnippetRunResult{TabID: tab}
+		if session := s.terminal.GetSession(tab); session == nil {
+			result.Error = "terminal not found"
+		} else if !snippetInScope(*n, session.NodeID, parents) {
+			result.Error = "terminal is not in the snippet's folder"
+		} else if err := s.terminal.WriteToSession(tab, command+"\n"); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *SnippetService) validate(n *database.Snippet) error {
+	n.Name = strings.TrimSpace(n.Name)
+	n.Description = strings.TrimSpace(n.Description)
+	n.Command = strings.TrimRight(n.Command, " \t\r\n")
+	if strings.TrimSpace(n.Command) == "" {
+		return fmt.Errorf("command is required")
+	}
+	if n.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	n.Tags = cleanTags(n.Tags)
+	if n.FolderID != nil && *n.FolderID == "" {
+		n.FolderID = nil
+	}
+	if n.FolderID != nil {
+		node, err := s.db.GetSession(*n.FolderID)
+		if err != nil {
+			return fmt.Errorf("folder not found: %w", err)
+		}
+		if node.Type != "folder" {
+			return fmt.Errorf("snippets can only be scoped to folders")
+		}
+	}
+	return nil
+}
+
+func (s *SnippetService) sessionParents() (map[string]string, error) {
+	sessions, err := s.db.GetAllSessions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+	parents := make(map[string]string, len(sessions))
+	for _, node := range sessions {
+		if node.ParentID != nil {
+			parents[node.ID] = *node.ParentID
+		}
+	}
+	return parents, nil
+}
+
+func (s *SnippetService) emitChanged() {
+	if s.app != nil {
+		s.app.Event.Emit("snippets:changed", map[string]interface{}{})
+	}
+}
+
+// snippetInScope reports whether a snippet is offered for a node: snippets
+// without a folder are offered everywhere, also in ad-hoc terminals
+func snippetInScope(n database.Snippet, nodeID string, parents map[string]string) bool {
+	return n.FolderID == nil || (nodeID != "" && isDescendantOf(nodeID, *n.FolderID, parents))
+}
+
+// snippetVariables lists the placeholders of a command once each, in order
+func snippetVariables(command string) []SnippetVariable {
+	vars := []SnippetVariable{}
+	seen := map[string]bool{}
+	for _, m := range snippetVarPattern.FindAllStringSubmatch(command, -1) {
+		if strings.HasPrefix(m[0], "$$") || seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		vars = append(vars, SnippetVariable{Name: m[1], Default: m[2]})
+	}
+	return vars
+}
+
+// expandSnippet fills in the placeholders of a command
+func expandSnippet(command string, values map[string]string) (string, error) {
+	var missing []string
+	expanded := snippetVarPattern.ReplaceAllStringFunc(command, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		m := snippetVarPattern.FindStringSubmatch(match)
+		if value, ok := values[m[1]]; ok {
+			return value
+		}
+		if strings.Contains(match, ":") {
+			return m[2]
+		}
+		missing = append(missing, m[1])
+		return match
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
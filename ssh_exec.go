@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	execOutputLimit    = 64 * 1024 // bytes of output kept per command
+	defaultExecTimeout = 5 * time.Minute
+)
+
+// ExecResult is the outcome of a command run with ExecCommand
+type ExecResult struct {
+	Output     string    `json:"output"`   // stdout and stderr interleaved
+	ExitCode   int       `json:"exitCode"` // -1 when the command did not finish
+	Truncated  bool      `json:"truncated"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// ExecCommand runs a single command on an SSH session without opening a terminal.
+// The session's effective config is used, with credentials decrypted only for the
+// connection. A non-zero exit code is reported in the result, not as an error.
+func (t *TerminalService) ExecCommand(sessionID, command string, timeoutSeconds int) (*ExecResult, error) {
+	node, err := t.db.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if node.Type != "session" || node.SessionType == nil || *node.SessionType != "ssh" {
+		return nil, fmt.Errorf("commands can only be run on SSH sessions")
+	}
+	cfg, err := t.db.GetEffectiveConfig(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if t.secrets != nil {
+		if cfg, err = t.secrets.ResolveConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if errs := validateSessionConfig("ssh", cfg, true); len(errs) > 0 {
+		return nil, &ConfigValidationError{SessionType: "ssh", Errors: errs}
+	}
+
+	timeout := defaultExecTimeout
+	if timeoutSeconds > 0 {
+		timeout = time.Duration(timeoutSeconds) * time.Second
+	}
+
+	if err := autoWake(t.app, sessionID, "ssh", cfg); err != nil {
+		return nil, err
+	}
+	client, err := t.connectSSH(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	sshSession, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer sshSession.Close()
+
+	out := &limitedBuffer{limit: execOutputLimit}
+	sshSession.Stdout = out
+	sshSession.Stderr = out
+
+	result := &ExecResult{ExitCode: -1, StartedAt: time.Now()}
+	if err := sshSession.Start(command); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- sshSession.Wait() }()
+
+	var runErr error
+	select {
+	case runErr = <-done:
+	case <-time.After(timeout):
+		sshSession.Signal(ssh.SIGKILL)
+		client.Close()
+		runErr = fmt.Errorf("command timed out after %s", timeout)
+	}
+
+	result.DurationMs = time.Since(result.StartedAt).Milliseconds()
+	result.Output, result.Truncated = out.String()
+
+	var exitErr *ssh.ExitError
+	switch {
+	case runErr == nil:
+		result.ExitCode = 0
+	case errors.As(runErr, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+	default:
+		return result, runErr
+	}
+	return result, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it from several writers
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String(), b.truncated
+}
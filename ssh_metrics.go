@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH connection metric settings
+const (
+	sshRateInterval      = time.Second      // throughput is averaged over this
+	sshKeepaliveInterval = 5 * time.Second  // between latency probes
+	sshKeepaliveTimeout  = 15 * time.Second // a probe taking longer is reported as this
+)
+
+// SSHConnStats is the latency and terminal throughput of one SSH session
+type SSHConnStats struct {
+	LatencyMs      float64 `json:"latencyMs"` // last keepalive round trip
+	BytesInPerSec  float64 `json:"bytesInPerSec"`
+	BytesOutPerSec float64 `json:"bytesOutPerSec"`
+}
+
+// SSHConnSummary is added to terminal:exit for SSH sessions, so a slow session
+// can be diagnosed after the fact
+type SSHConnSummary struct {
+	PeakLatencyMs      float64 `json:"peakLatencyMs"`
+	PeakBytesInPerSec  float64 `json:"peakBytesInPerSec"`
+	PeakBytesOutPerSec float64 `json:"peakBytesOutPerSec"`
+	BytesIn            uint64  `json:"bytesIn"`
+	BytesOut           uint64  `json:"bytesOut"`
+}
+
+// sshConnMetrics counts the bytes of an SSH terminal and times keepalive round
+// trips to its server
+type sshConnMetrics struct {
+	bytesIn  atomic.Uint64 // output received
+	bytesOut atomic.Uint64 // input sent
+
+	mu      sync.Mutex
+	current SSHConnStats
+	peak    SSHConnStats
+}
+
+// run samples the connection until ctx is cancelled
+func (m *sshConnMetrics) run(ctx context.Context, client *ssh.Client) {
+	rate := time.NewTicker(sshRateInterval)
+	defer rate.Stop()
+	keepalive := time.NewTicker(sshKeepaliveInterval)
+	defer keepalive.Stop()
+
+	go m.probe(ctx, client)
+	lastIn, lastOut, last := m.bytesIn.Load(), m.bytesOut.Load(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-rate.C:
+			in, out := m.bytesIn.Load(), m.bytesOut.Load()
+			secs := now.Sub(last).Seconds()
+			m.mu.Lock()
+			m.current.BytesInPerSec = float64(in-lastIn) / secs
+			m.current.BytesOutPerSec = float64(out-lastOut) / secs
+			m.peak.BytesInPerSec = max(m.peak.BytesInPerSec, m.current.BytesInPerSec)
+			m.peak.BytesOutPerSec = max(m.peak.BytesOutPerSec, m.current.BytesOutPerSec)
+			m.mu.Unlock()
+			lastIn, lastOut, last = in, out, now
+		case <-keepalive.C:
+			go m.probe(ctx, client)
+		}
+	}
+}
+
+// probe times one keepalive request; a server that does not know it still replies
+// with a failure, which is just as good for timing
+func (m *sshConnMetrics) probe(ctx context.Context, client *ssh.Client) {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+
+	var rtt time.Duration
+	select {
+	case <-ctx.Done():
+		return
+	case err := <-done:
+		if err != nil {
+			// The connection is gone
+			return
+		}
+		rtt = time.Since(start)
+	case <-time.After(sshKeepaliveTimeout):
+		rtt = sshKeepaliveTimeout
+	}
+
+	ms := float64(rtt.Microseconds()) / 1000
+	m.mu.Lock()
+	m.current.LatencyMs = ms
+	m.peak.LatencyMs = max(m.peak.LatencyMs, ms)
+	m.mu.Unlock()
+}
+
+// stats returns the latest sample
+func (m *sshConnMetrics) stats() SSHConnStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// summary returns the peaks and totals so far
+func (m *sshConnMetrics) summary() SSHConnSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return SSHConnSummary{
+		PeakLatencyMs:      m.peak.LatencyMs,
+		PeakBytesInPerSec:  m.peak.BytesInPerSec,
+		PeakBytesOutPerSec: m.peak.BytesOutPerSec,
+		BytesIn:            m.bytesIn.Load(),
+		BytesOut:           m.bytesOut.Load(),
+	}
+}
+
+// GetSSHConnStats returns the latency and throughput of an SSH session
+func (t *TerminalService) GetSSHConnStats(id string) (SSHConnStats, error) {
+	session := t.GetSession(id)
+	if session == nil || session.metrics == nil {
+		return SSHConnStats{}, fmt.Errorf("SSH session %s not found", id)
+	}
+	return session.metrics.stats(), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const sshProxyTimeout = 15 * time.Second
+
+// sshProxied reports whether an SSH session connects through a proxy
+func sshProxied(config map[string]string) bool {
+	proxyType := strings.ToLower(strings.TrimSpace(config["ssh_proxy_type"]))
+	return proxyType != "" && proxyType != "none"
+}
+
+// sshProxyDial returns a dial function honouring the session's proxy settings
+// (ssh_proxy_type: socks4, socks5 or http; ssh_proxy_host, ssh_proxy_port,
+// ssh_proxy_username, ssh_proxy_password), or nil when no proxy is configured.
+func sshProxyDial(config map[string]string) (func(network, addr string) (net.Conn, error), error) {
+	if !sshProxied(config) {
+		return nil, nil
+	}
+	proxyType := strings.ToLower(strings.TrimSpace(config["ssh_proxy_type"]))
+	host := strings.TrimSpace(config["ssh_proxy_host"])
+	if host == "" {
+		return nil, fmt.Errorf("proxy type %s requires ssh_proxy_host", proxyType)
+	}
+	port := strings.TrimSpace(config["ssh_proxy_port"])
+	if port == "" {
+		switch proxyType {
+		case "http":
+			port = "8080"
+		default:
+			port = "1080"
+		}
+	}
+	proxyAddr := net.JoinHostPort(host, port)
+	user := config["ssh_proxy_username"]
+	password := config["ssh_proxy_password"]
+
+	switch proxyType {
+	case "socks5":
+		var auth *proxy.Auth
+		if user != "" {
+			auth = &proxy.Auth{User: user, Password: password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, &net.Dialer{Timeout: sshProxyTimeout})
+		if err != nil {
+			return nil, err
+		}
+		return dialer.Dial, nil
+	case "socks4":
+		return func(network, addr string) (net.Conn, error) {
+			return dialSOCKS4(proxyAddr, addr, user)
+		}, nil
+	case "http":
+		return func(network, addr string) (net.Conn, error) {
+			return dialHTTPConnect(proxyAddr, addr, user, password)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy type %q", proxyType)
+	}
+}
+
+// dialSOCKS4 connects through a SOCKS4a proxy, letting the proxy resolve hostnames
+func dialSOCKS4(proxyAddr, addr, user string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	conn, err := net.DialTimeout("tcp", proxyAddr, sshProxyTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach proxy: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(sshProxyTimeout))
+
+	req := []byte{0x04, 0x01, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(port))
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		// SOCKS4a: 0.0.0.x signals that the hostname follows the user id
+		ip = net.IPv4(0, 0, 0, 1).To4()
+	}
+	req = append(req, ip...)
+	req = append(req, []byte(user)...)
+	req = append(req, 0)
+	if net.ParseIP(host).To4() == nil {
+		req = append(req, []byte(host)...)
+		req = append(req, 0)
+	}
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp := make([]byte, 8)
+	if err := readFull(conn, resp); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy handshake failed: %w", err)
+	}
+	if resp[1] != 0x5a {
+		conn.Close()
+		return nil, fmt.Errorf("proxy rejected connection (code 0x%02x)", resp[1])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// dialHTTPConnect opens a tunnel through an HTTP proxy using CONNECT
+func dialHTTPConnect(proxyAddr, addr, user, password string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxyAddr, sshProxyTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach proxy: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(sshProxyTimeout))
+
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if user != "" {
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)) + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy handshake failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT: %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("proxy sent unexpected data after CONNECT")
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
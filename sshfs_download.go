@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// handleSSHFSDownload serves GET /sshfs/download?sessionId=&path= so the browser
+// can download a remote file directly. Range requests let it resume an interrupted
+// download of a large file; the ETag, made of the file's size and modification time,
+// makes sure the rest comes from the same version of the file (If-Range).
+func (h *HTTPServer) handleSSHFSDownload(w http.ResponseWriter, r *http.Request) {
+	sessionID := strings.TrimSpace(r.URL.Query().Get("sessionId"))
+	remotePath := strings.TrimSpace(r.URL.Query().Get("path"))
+	if sessionID == "" || remotePath == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing_parameter", fmt.Errorf("sessionId and path query params required"))
+		return
+	}
+
+	session := h.termService.GetSession(sessionID)
+	if session == nil || !session.IsSSH || session.SSHClient == nil {
+		writeJSONError(w, http.StatusNotFound, "ssh_session_not_found", fmt.Errorf("ssh session not found"))
+		return
+	}
+
+	s := h.sftpService
+	sftpClient, err := s.cachedClient(sessionID, session)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "sftp_unavailable", fmt.Errorf("failed to create sftp client: %v", err))
+		return
+	}
+
+	fi, err := sftpClient.Stat(remotePath)
+	if err != nil {
+		status, code := http.StatusBadGateway, "remote_stat_failed"
+		if os.IsNotExist(err) {
+			status, code = http.StatusNotFound, "remote_not_found"
+		}
+		writeJSONError(w, status, code, fmt.Errorf("failed to stat remote file: %v", err))
+		return
+	}
+	if fi.IsDir() {
+		writeJSONError(w, http.StatusBadRequest, "is_directory", fmt.Errorf("%s is a directory", remotePath))
+		return
+	}
+
+	f, err := sftpClient.OpenFile(remotePath)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "remote_open_failed", fmt.Errorf("failed to open remote file: %v", err))
+		return
+	}
+	defer f.Close()
+
+	// Shutdown waits for the download like for other transfers, and closing the file
+	// ends it when the client goes away or shutdown gives up
+	ctx, done := s.beginTransfer(r.Context(), session)
+	defer done()
+	defer context.AfterFunc(ctx, func() { f.Close() })()
+
+	name := path.Base(remotePath)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, fi.Size(), fi.ModTime().UnixNano()))
+	// The size from Stat sets Content-Length and bounds ranges without another round trip
+	http.ServeContent(w, r, name, fi.ModTime(), io.NewSectionReader(f, 0, fi.Size()))
+}
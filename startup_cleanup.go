@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"term/database"
+	"term/logging"
+
+	"github.com/shirou/gopsutil/v4/process"
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+var cleanupLog = logging.For("cleanup")
+
+// runJournal records what this run leaves behind if it crashes: the local shells
+// it started and the local files of transfers in progress. It is written on every
+// change and removed on a clean exit, so a journal found at startup is the one of
+// a run that crashed.
+type runJournal struct {
+	path string
+
+	mu     sync.Mutex
+	state  journalState
+	closed bool // sessions ending after a clean exit are not recorded again
+}
+
+type journalState struct {
+	PID    int                     `json:"pid"`
+	Shells map[string]journalShell `json:"shells"` // by tab
+	Files  map[string]bool         `json:"files"`  // partial files of unfinished downloads
+}
+
+// journalShell identifies a shell process; the start time tells it apart from a
+// later process that got the same PID
+type journalShell struct {
+	PID       int    `json:"pid"`
+	CreatedAt int64  `json:"createdAt"` // Unix milliseconds
+	Name      string `json:"name"`
+}
+
+func newRunJournal(path string) *runJournal {
+	return &runJournal{path: path, state: journalState{
+		PID:    os.Getpid(),
+		Shells: make(map[string]journalShell),
+		Files:  make(map[string]bool),
+	}}
+}
+
+// readRunJournal reads the journal of the previous run, if it crashed
+func readRunJournal(path string) (*journalState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state journalState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// The methods below do nothing on a nil journal, so services work without one
+
+func (j *runJournal) addShell(tab string, pid int) {
+	if j == nil {
+		return
+	}
+	shell := journalShell{PID: pid}
+	if p, err := process.NewProcess(int32(pid)); err == nil {
+		shell.CreatedAt, _ = p.CreateTime()
+		shell.Name, _ = p.Name()
+	}
+	j.update(func(s *journalState) { s.Shells[tab] = shell })
+}
+
+func (j *runJournal) removeShell(tab string) {
+	if j == nil {
+		return
+	}
+	j.update(func(s *journalState) { delete(s.Shells, tab) })
+}
+
+func (j *runJournal) addFile(path string) {
+	if j == nil {
+		return
+	}
+	j.update(func(s *journalState) { s.Files[path] = true })
+}
+
+func (j *runJournal) removeFile(path string) {
+	if j == nil {
+		return
+	}
+	j.update(func(s *journalState) { delete(s.Files, path) })
+}
+
+func (j *runJournal) update(fn func(s *journalState)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return
+	}
+	fn(&j.state)
+	data, err := json.Marshal(j.state)
+	if err != nil {
+		return
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		cleanupLog.Warn("failed to write run journal", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		cleanupLog.Warn("failed to write run journal", "err", err)
+	}
+}
+
+// Close removes the journal on a clean exit
+func (j *runJournal) Close() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.closed = true
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		cleanupLog.Warn("failed to remove run journal", "err", err)
+	}
+}
+
+// RecoveryReport lists what was cleaned up after a crash of the previous run
+type RecoveryReport struct {
+	Shells        []string `json:"shells"`        // orphaned shell processes that were ended
+	DatabaseFiles []string `json:"databaseFiles"` // dangling WAL and temporary database files
+	Recordings    []string `json:"recordings"`    // recordings left unfinished, now closed or removed
+	Transfers     []string `json:"transfers"`     // partial files of interrupted downloads
+}
+
+// Empty reports whether nothing needed cleaning up
+func (r RecoveryReport) Empty() bool {
+	return len(r.Shells) == 0 && len(r.DatabaseFiles) == 0 && len(r.Recordings) == 0 && len(r.Transfers) == 0
+}
+
+// StartupCleanup cleans up what a crashed run left behind. It runs once this is
+// the only instance, so nothing it removes is still in use.
+type StartupCleanup struct {
+	app    *application.App
+	db     *database.DB
+	dbPath string
+	runDir string
+
+	mu     sync.Mutex
+	report RecoveryReport
+}
+
+// NewStartupCleanup creates the cleanup; danglingDB are the database files
+// removed before the database was opened
+func NewStartupCleanup(app *application.App, db *database.DB, dbPath, runDir string, danglingDB []string) *StartupCleanup {
+	return &StartupCleanup{
+		app:    app,
+		db:     db,
+		dbPath: dbPath,
+		runDir: runDir,
+		report: RecoveryReport{DatabaseFiles: danglingDB},
+	}
+}
+
+// Run cleans up after the previous run and returns the journal of this one
+func (c *StartupCleanup) Run() *runJournal {
+	path := filepath.Join(c.runDir, "journal.json")
+	if err := os.MkdirAll(c.runDir, 0700); err != nil {
+		cleanupLog.Warn("run journal disabled", "err", err)
+		return nil
+	}
+
+	if previous, err := readRunJournal(path); err == nil {
+		cleanupLog.Warn("previous run did not exit cleanly", "pid", previous.PID)
+		c.endOrphanedShells(previous.Shells)
+		c.removePartialFiles(previous.Files)
+	} else if !os.IsNotExist(err) {
+		cleanupLog.Warn("ignoring unreadable run journal", "err", err)
+	}
+	c.removeTempDBFiles()
+
+	// An encrypted database can only be checked once it is unlocked
+	if c.db.Locked() {
+		var off func()
+		off = c.app.Event.On("database:unlocked", func(*application.CustomEvent) {
+			off()
+			if recovered := c.closeUnfinishedRecordings(); len(recovered) > 0 {
+				c.app.Event.Emit("startup:recovered", map[string]interface{}{
+					"report": RecoveryReport{Recordings: recovered},
+				})
+			}
+		})
+	} else {
+		c.closeUnfinishedRecordings()
+	}
+
+	report := c.GetRecoveryReport()
+	if !report.Empty() {
+		cleanupLog.Info("recovered from previous run", "shells", len(report.Shells), "databaseFiles", len(report.DatabaseFiles),
+			"recordings", len(report.Recordings), "transfers", len(report.Transfers))
+	}
+	return newRunJournal(path)
+}
+
+// GetRecoveryReport returns what was cleaned up at startup
+func (c *StartupCleanup) GetRecoveryReport() RecoveryReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report
+}
+
+func (c *StartupCleanup) add(fn func(r *RecoveryReport)) {
+	c.mu.Lock()
+	fn(&c.report)
+	c.mu.Unlock()
+}
+
+// endOrphanedShells kills the shells of the crashed run that still run, with
+// everything they started
+func (c *StartupCleanup) endOrphanedShells(shells map[string]journalShell) {
+	for _, shell := range shells {
+		p, err := process.NewProcess(int32(shell.PID))
+		if err != nil {
+			continue // exited on its own
+		}
+		// A process started at another time only reuses the PID
+		if created, err := p.CreateTime(); err != nil || abs64(created-shell.CreatedAt) > 1000 {
+			continue
+		}
+		killProcessTree(p)
+		what := fmt.Sprintf("%s (pid %d)", shell.Name, shell.PID)
+		cleanupLog.Info("ended orphaned shell", "pid", shell.PID, "name", shell.Name)
+		c.add(func(r *RecoveryReport) { r.Shells = append(r.Shells, what) })
+	}
+}
+
+// killProcessTree kills a process after its children, so none is left orphaned
+func killProcessTree(p *process.Process) {
+	if children, err := p.Children(); err == nil {
+		for _, child := range children {
+			killProcessTree(child)
+		}
+	}
+	if err := p.Kill(); err != nil {
+		cleanupLog.Warn("failed to kill process", "pid", p.Pid, "err", err)
+	}
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// removePartialFiles removes the local files of downloads the crash interrupted,
+// which would otherwise look complete
+func (c *StartupCleanup) removePartialFiles(files map[string]bool) {
+	for path := range files {
+		if err := os.Remove(path); err != nil {
+			if !os.IsNotExist(err) {
+				cleanupLog.Warn("failed to remove partial download", "path", path, "err", err)
+			}
+			continue
+		}
+		cleanupLog.Info("removed partial download", "path", path)
+		c.add(func(r *RecoveryReport) { r.Transfers = append(r.Transfers, path) })
+	}
+}
+
+// removeTempDBFiles removes the temporary files of database snapshots a crash
+// interrupted; the database itself was not replaced yet
+func (c *StartupCleanup) removeTempDBFiles() {
+	for _, path := range []string{c.dbPath + ".tmp", database.EncryptedPath(c.dbPath) + ".tmp"} {
+		if err := os.Remove(path); err == nil {
+			cleanupLog.Info("removed temporary database file", "path", path)
+			c.add(func(r *RecoveryReport) { r.DatabaseFiles = append(r.DatabaseFiles, path) })
+		}
+	}
+}
+
+// closeUnfinishedRecordings ends the recordings the crash left open, at the time
+// their file was last written. Recordings without data are removed.
+func (c *StartupCleanup) closeUnfinishedRecordings() []string {
+	recs, err := c.db.ListRecordings()
+	if err != nil {
+		cleanupLog.Warn("failed to list recordings", "err", err)
+		return nil
+	}
+	var recovered []string
+	for _, rec := range recs {
+		if rec.EndedAt != nil {
+			continue
+		}
+		st, err := os.Stat(rec.Path)
+		if err != nil || st.Size() == 0 {
+			if err == nil {
+				os.Remove(rec.Path)
+			}
+			if err := c.db.DeleteRecording(rec.ID); err != nil {
+				cleanupLog.Warn("failed to remove empty recording", "recording", rec.ID, "err", err)
+				continue
+			}
+			recovered = append(recovered, fmt.Sprintf("%s: removed, no data was recorded", rec.SessionName))
+			continue
+		}
+		if err := c.db.EndRecordingAt(rec.ID, st.Size(), st.ModTime()); err != nil {
+			cleanupLog.Warn("failed to close recording", "recording", rec.ID, "err", err)
+			continue
+		}
+		recovered = append(recovered, fmt.Sprintf("%s: closed at %s, its last event may be cut off", rec.SessionName, st.ModTime().Format(time.DateTime)))
+	}
+	if len(recovered) > 0 {
+		cleanupLog.Info("closed unfinished recordings", "count", len(recovered))
+		c.add(func(r *RecoveryReport) { r.Recordings = append(r.Recordings, recovered...) })
+	}
+	return recovered
+}
+
+// removeDanglingDBFiles removes WAL side files without their database, which
+// SQLite would otherwise apply to a new database of the same name. It runs
+// before the database is opened.
+func removeDanglingDBFiles(dbPath string) []string {
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil // SQLite recovers from its own WAL
+	}
+	var removed []string
+	for _, path := range []string{dbPath + "-wal", dbPath + "-shm"} {
+		if err := os.Remove(path); err == nil {
+			cleanupLog.Info("removed dangling database file", "path", path)
+			removed = append(removed, path)
+		}
+	}
+	return removed
+}
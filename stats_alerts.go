@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"github.com/wailsapp/wails/v3/pkg/services/notifications"
+)
+
+const settingStatsAlertRules = "stats_alert_rules" // JSON list of StatsAlertRule
+
+// Metrics a StatsAlertRule can watch
+const (
+	alertMetricCPU       = "cpu"        // CPU %
+	alertMetricMemory    = "memory"     // memory %
+	alertMetricDisk      = "disk"       // root disk %
+	alertMetricLoad      = "load"       // 1-minute load average
+	alertMetricLoadSpike = "load_spike" // 1-minute load over the 15-minute load
+)
+
+// Scope of a StatsAlertRule whose NodeID is not a session tree node
+const alertScopeLocal = "local"
+
+// StatsAlertRule fires when a metric stays above its threshold for DurationSeconds.
+// NodeID limits the rule to one SSH session of the tree, or to this machine with
+// "local"; empty applies it to every host stats are collected from.
+type StatsAlertRule struct {
+	ID              string  `json:"id"`
+	NodeID          string  `json:"nodeId,omitempty"`
+	Metric          string  `json:"metric"`
+	Threshold       float64 `json:"threshold"`
+	DurationSeconds int     `json:"durationSeconds"`
+	MarkRecording   bool    `json:"markRecording"` // add a marker to the session's active recording
+	Enabled         bool    `json:"enabled"`
+}
+
+// defaultStatsAlertRules are used until the rules are first saved
+var defaultStatsAlertRules = []StatsAlertRule{
+	{ID: "cpu-high", Metric: alertMetricCPU, Threshold: 90, DurationSeconds: 300, Enabled: true},
+	{ID: "disk-full", Metric: alertMetricDisk, Threshold: 95, Enabled: true},
+	{ID: "load-spike", Metric: alertMetricLoadSpike, Threshold: 3, DurationSeconds: 60, Enabled: true},
+}
+
+// StatsAlert is the payload of stats:alert, sent when a rule starts firing and
+// when it recovers
+type StatsAlert struct {
+	RuleID    string    `json:"ruleId"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	SessionID string    `json:"sessionId,omitempty"` // terminal the stats come from
+	NodeID    string    `json:"nodeId,omitempty"`
+	Host      string    `json:"host"`  // "local" or the SSH session's node id
+	State     string    `json:"state"` // "firing" or "resolved"
+	Since     time.Time `json:"since"` // when the metric crossed the threshold
+}
+
+// alertState tracks one rule on one host
+type alertState struct {
+	since  time.Time // zero while below the threshold
+	firing bool
+}
+
+// StatsAlertService checks the stats collected by the local and remote stats
+// services against the alert rules, emits stats:alert and shows a desktop
+// notification, so a struggling server is noticed from any tab
+type StatsAlertService struct {
+	app             *application.App
+	db              *database.DB
+	terminalService *TerminalService
+	recorder        *RecordingService
+	notifier        *notifications.NotificationService
+
+	mu     sync.Mutex
+	rules  []StatsAlertRule
+	states map[string]*alertState // host + "/" + rule id
+}
+
+// NewStatsAlertService creates the alert service and loads its rules
+func NewStatsAlertService(db *database.DB, terminalService *TerminalService, recorder *RecordingService, notifier *notifications.NotificationService) *StatsAlertService {
+	s := &StatsAlertService{
+		db:              db,
+		terminalService: terminalService,
+		recorder:        recorder,
+		notifier:        notifier,
+		states:          make(map[string]*alertState),
+	}
+	s.rules = defaultStatsAlertRules
+	var rules []StatsAlertRule
+	if err := db.GetSettingJSON(settingStatsAlertRules, &rules); err == nil && rules != nil {
+		s.rules = rules
+	}
+	return s
+}
+
+// SetApp sets the Wails application instance
+func (s *StatsAlertService) SetApp(app *application.App) {
+	s.app = app
+}
+
+// GetAlertRules returns the stats alert rules
+func (s *StatsAlertService) GetAlertRules() []StatsAlertRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StatsAlertRule(nil), s.rules...)
+}
+
+// SetAlertRules validates and saves the stats alert rules; rules without an ID get one
+func (s *StatsAlertService) SetAlertRules(rules []StatsAlertRule) error {
+	for i := range rules {
+		r := &rules[i]
+		switch r.Metric {
+		case alertMetricCPU, alertMetricMemory, alertMetricDisk, alertMetricLoad, alertMetricLoadSpike:
+		default:
+			return fmt.Errorf("unknown metric %q", r.Metric)
+		}
+		if r.Threshold <= 0 {
+			return fmt.Errorf("threshold of the %s rule must be positive", r.Metric)
+		}
+		if r.DurationSeconds < 0 {
+			return fmt.Errorf("duration of the %s rule cannot be negative", r.Metric)
+		}
+		if r.ID == "" {
+			b := make([]byte, 6)
+			if _, err := rand.Read(b); err != nil {
+				return err
+			}
+			r.ID = hex.EncodeToString(b)
+		}
+	}
+	if err := s.db.SetSettingJSON(settingStatsAlertRules, rules); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+	// Rules may have changed meaning, so every one starts over
+	s.states = make(map[string]*alertState)
+	return nil
+}
+
+// reloadRules picks up rules written to the settings by something else than
+// SetAlertRules, such as a settings import or sync
+func (s *StatsAlertService) reloadRules() {
+	var rules []StatsAlertRule
+	if err := s.db.GetSettingJSON(settingStatsAlertRules, &rules); err != nil || rules == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !reflect.DeepEqual(rules, s.rules) {
+		s.rules = rules
+		s.states = make(map[string]*alertState)
+	}
+}
+
+// alertMetricValue reads a rule's metric from stats; false when it was not collected
+func alertMetricValue(stats SystemStats, metric string) (float64, bool) {
+	switch metric {
+	case alertMetricCPU:
+		return stats.CPUPercent, true
+	case alertMetricMemory:
+		return stats.MemoryPercent, stats.MemoryTotal > 0
+	case alertMetricDisk:
+		return stats.DiskPercent, stats.DiskTotal > 0
+	case alertMetricLoad:
+		return stats.LoadAvg1, true
+	case alertMetricLoadSpike:
+		// Ignore idle machines, where any activity is a "spike"
+		return stats.LoadAvg1 / max(stats.LoadAvg15, 0.5), true
+	}
+	return 0, false
+}
+
+// Observe checks one stats sample. terminalID is the terminal the stats belong to:
+// the SSH session for remote stats, or the active local tab, if any, for this machine.
+func (s *StatsAlertService) Observe(terminalID string, stats SystemStats) {
+	host, nodeID := alertScopeLocal, ""
+	if stats.SessionID != "" {
+		host = stats.SessionID
+		if session := s.terminalService.GetSession(stats.SessionID); session != nil {
+			nodeID = session.NodeID
+			if nodeID != "" {
+				host = nodeID
+			}
+		}
+	}
+
+	now := time.Now()
+	var alerts []StatsAlert
+	var marks []StatsAlertRule
+	s.mu.Lock()
+	for _, rule := range s.rules {
+		if !rule.Enabled {
+			continue
+		}
+		if rule.NodeID != "" && rule.NodeID != nodeID && !(rule.NodeID == alertScopeLocal && stats.SessionID == "") {
+			continue
+		}
+		value, ok := alertMetricValue(stats, rule.Metric)
+		if !ok {
+			continue
+		}
+		key := host + "/" + rule.ID
+		st := s.states[key]
+		if st == nil {
+			st = &alertState{}
+			s.states[key] = st
+		}
+		alert := StatsAlert{
+			RuleID:    rule.ID,
+			Metric:    rule.Metric,
+			Value:     value,
+			Threshold: rule.Threshold,
+			SessionID: stats.SessionID,
+			NodeID:    nodeID,
+			Host:      host,
+		}
+		if value <= rule.Threshold {
+			if st.firing {
+				alert.State, alert.Since = "resolved", st.since
+				alerts = append(alerts, alert)
+			}
+			*st = alertState{}
+			continue
+		}
+		if st.since.IsZero() {
+			st.since = now
+		}
+		if !st.firing && now.Sub(st.since) >= time.Duration(rule.DurationSeconds)*time.Second {
+			st.firing = true
+			alert.State, alert.Since = "firing", st.since
+			alerts = append(alerts, alert)
+			if rule.MarkRecording {
+				marks = append(marks, rule)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, alert := range alerts {
+		s.notify(alert)
+	}
+	if terminalID != "" && s.recorder != nil {
+		for _, rule := range marks {
+			s.recorder.AppendMarker(terminalID, fmt.Sprintf("%s above %g", rule.Metric, rule.Threshold))
+		}
+	}
+}
+
+// notify emits stats:alert and shows a desktop notification for firing alerts
+func (s *StatsAlertService) notify(alert StatsAlert) {
+	if s.app != nil {
+		s.app.Event.Emit("stats:alert", alert)
+	}
+	if alert.State != "firing" {
+		return
+	}
+	where := "this machine"
+	if alert.Host != alertScopeLocal {
+		where = alert.Host
+		if node, err := s.db.GetSession(alert.NodeID); err == nil && node != nil {
+			where = node.Name
+		}
+	}
+	log.Printf("Stats alert on %s: %s is %.1f (threshold %g)", where, alert.Metric, alert.Value, alert.Threshold)
+	if s.notifier == nil {
+		return
+	}
+	err := s.notifier.SendNotification(notifications.NotificationOptions{
+		ID:    "stats-" + alert.Host + "-" + alert.RuleID,
+		Title: "Term: " + where,
+		Body:  fmt.Sprintf("%s is %.1f, above the %g threshold", alertMetricLabel(alert.Metric), alert.Value, alert.Threshold),
+	})
+	if err != nil {
+		log.Printf("Failed to show stats alert notification: %v", err)
+	}
+}
+
+func alertMetricLabel(metric string) string {
+	switch metric {
+	case alertMetricCPU:
+		return "CPU usage (%)"
+	case alertMetricMemory:
+		return "Memory usage (%)"
+	case alertMetricDisk:
+		return "Disk usage (%)"
+	case alertMetricLoad:
+		return "Load average"
+	case alertMetricLoadSpike:
+		return "Load spike (1 min / 15 min)"
+	}
+	return metric
+}
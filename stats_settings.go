@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"term/database"
+)
+
+// Settings controlling what the stats services collect and how often
+const (
+	settingStatsInterval  = "stats_interval_seconds" // default 2
+	settingStatsCPU       = "stats_cpu"
+	settingStatsMemory    = "stats_memory"
+	settingStatsDisk      = "stats_disk"
+	settingStatsNetwork   = "stats_network"
+	settingStatsLoad      = "stats_load"
+	settingStatsSensors   = "stats_sensors"
+	settingStatsProcesses = "stats_processes"
+	settingStatsDocker    = "stats_docker" // default false
+	settingStatsBattery   = "stats_battery"
+
+	defaultStatsInterval = 2
+	maxStatsInterval     = 60
+)
+
+// StatsSettings are the collection interval and the metrics collected on each tick;
+// disabled metrics are neither measured locally nor queried on SSH hosts
+type StatsSettings struct {
+	IntervalSeconds int  `json:"intervalSeconds"`
+	CPU             bool `json:"cpu"`
+	Memory          bool `json:"memory"`
+	Disk            bool `json:"disk"`
+	Network         bool `json:"network"`
+	Load            bool `json:"load"`
+	Sensors         bool `json:"sensors"`
+	Processes       bool `json:"processes"`
+	Docker          bool `json:"docker"`  // containers on SSH hosts running Docker
+	Battery         bool `json:"battery"` // this machine's battery
+}
+
+func (s StatsSettings) interval() time.Duration {
+	return time.Duration(s.IntervalSeconds) * time.Second
+}
+
+// statsConfig is shared by the local and remote stats services. Both stop
+// collecting while the stats panel is hidden and pick up changes right away.
+type statsConfig struct {
+	db       *database.DB
+	mu       sync.Mutex
+	settings StatsSettings
+	visible  bool
+	changed  chan struct{} // closed and replaced on every change
+}
+
+func newStatsConfig(db *database.DB) *statsConfig {
+	c := &statsConfig{db: db, visible: true, changed: make(chan struct{})}
+	c.settings = c.load()
+	return c
+}
+
+func (c *statsConfig) load() StatsSettings {
+	return StatsSettings{
+		IntervalSeconds: settingInt(c.db, settingStatsInterval),
+		CPU:             settingBool(c.db, settingStatsCPU),
+		Memory:          settingBool(c.db, settingStatsMemory),
+		Disk:            settingBool(c.db, settingStatsDisk),
+		Network:         settingBool(c.db, settingStatsNetwork),
+		Load:            settingBool(c.db, settingStatsLoad),
+		Sensors:         settingBool(c.db, settingStatsSensors),
+		Processes:       settingBool(c.db, settingStatsProcesses),
+		Docker:          settingBool(c.db, settingStatsDocker),
+		Battery:         settingBool(c.db, settingStatsBattery),
+	}
+}
+
+// reload picks up stats settings written by something else than save, such as a
+// settings import or sync
+func (c *statsConfig) reload() {
+	settings := c.load()
+	c.mu.Lock()
+	same := settings == c.settings
+	c.mu.Unlock()
+	if !same {
+		c.update(func() { c.settings = settings })
+	}
+}
+
+// current returns the settings, whether to collect at all, and a channel closed on
+// the next change
+func (c *statsConfig) current() (StatsSettings, bool, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.settings, c.visible, c.changed
+}
+
+func (c *statsConfig) update(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *statsConfig) save(settings StatsSettings) error {
+	settings.IntervalSeconds = max(1, min(settings.IntervalSeconds, maxStatsInterval))
+	values := map[string]bool{
+		settingStatsCPU:       settings.CPU,
+		settingStatsMemory:    settings.Memory,
+		settingStatsDisk:      settings.Disk,
+		settingStatsNetwork:   settings.Network,
+		settingStatsLoad:      settings.Load,
+		settingStatsSensors:   settings.Sensors,
+		settingStatsProcesses: settings.Processes,
+		settingStatsDocker:    settings.Docker,
+		settingStatsBattery:   settings.Battery,
+	}
+	for key, on := range values {
+		if err := setSettingValue(c.db, key, strconv.FormatBool(on)); err != nil {
+			return err
+		}
+	}
+	if err := setSettingValue(c.db, settingStatsInterval, strconv.Itoa(settings.IntervalSeconds)); err != nil {
+		return err
+	}
+	c.update(func() { c.settings = settings })
+	return nil
+}
+
+// statsTicks calls collect every interval while the stats panel is visible, until
+// done is closed. A change of settings or visibility takes effect immediately, and
+// the first tick after it is marked restarted so deltas are not taken across it.
+func (c *statsConfig) statsTicks(done <-chan struct{}, collect func(settings StatsSettings, restarted bool)) {
+	restarted := true
+	for {
+		settings, visible, changed := c.current()
+		var tick <-chan time.Time
+		var timer *time.Timer
+		if visible {
+			timer = time.NewTimer(settings.interval())
+			tick = timer.C
+		}
+		select {
+		case <-done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+			restarted = true
+		case <-tick:
+			collect(settings, restarted)
+			restarted = false
+		}
+	}
+}
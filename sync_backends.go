@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSyncFile      = "term-sync.json"
+	defaultSyncGitBranch = "main"
+	syncHTTPTimeout      = 30 * time.Second
+)
+
+// errSyncRemoteChanged is returned by Store when the remote copy moved since it was fetched
+var errSyncRemoteChanged = errors.New("remote sync data changed")
+
+// syncBackend stores the sync snapshot somewhere every device can reach. Revisions are
+// opaque (ETag or commit id) and let Store refuse to overwrite someone else's push.
+type syncBackend interface {
+	// Fetch returns the stored snapshot and its revision; nil data means nothing is stored yet
+	Fetch(ctx context.Context) ([]byte, string, error)
+	// Store writes data if the remote is still at rev ("" = must not exist yet)
+	Store(ctx context.Context, data []byte, rev string) (string, error)
+}
+
+// newSyncBackend builds the backend described by cfg
+func newSyncBackend(cfg SyncConfig, dataDir string) (syncBackend, error) {
+	file := cfg.Path
+	if file == "" {
+		file = defaultSyncFile
+	}
+	switch cfg.Backend {
+	case "webdav":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("WebDAV URL required")
+		}
+		return &webdavSyncBackend{url: cfg.URL, username: cfg.Username, password: cfg.Password}, nil
+	case "s3":
+		if cfg.Bucket == "" || cfg.Username == "" || cfg.Password == "" {
+			return nil, fmt.Errorf("S3 bucket, access key and secret key required")
+		}
+		region := cfg.Region
+		if region == "" {
+			region = "us-east-1"
+		}
+		endpoint := cfg.URL
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+		return &s3SyncBackend{endpoint: strings.TrimRight(endpoint, "/"), bucket: cfg.Bucket, key: file,
+			region: region, accessKey: cfg.Username, secretKey: cfg.Password}, nil
+	case "git":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("git remote URL required")
+		}
+		branch := cfg.Branch
+		if branch == "" {
+			branch = defaultSyncGitBranch
+		}
+		// Credentials go to git through a credential helper, never into the remote
+		// URL, which is stored in the working copy's config
+		remote, username, password := cfg.URL, cfg.Username, cfg.Password
+		if u, err := url.Parse(cfg.URL); err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.User != nil {
+			if username == "" {
+				username = u.User.Username()
+				password, _ = u.User.Password()
+			}
+			u.User = nil
+			remote = u.String()
+		}
+		return &gitSyncBackend{remote: remote, username: username, password: password, branch: branch, file: file,
+			dir: filepath.Join(dataDir, "sync-git")}, nil
+	case "":
+		return nil, fmt.Errorf("sync is not configured")
+	}
+	return nil, fmt.Errorf("unknown sync backend %q", cfg.Backend)
+}
+
+// webdavSyncBackend keeps the snapshot as one file on a WebDAV server, using ETags
+// for optimistic concurrency
+type webdavSyncBackend struct {
+	url      string
+	username string
+	password string
+}
+
+func (b *webdavSyncBackend) request(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+	return req, nil
+}
+
+func (b *webdavSyncBackend) Fetch(ctx context.Context) ([]byte, string, error) {
+	req, err := b.request(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return doSyncGet(req)
+}
+
+func (b *webdavSyncBackend) Store(ctx context.Context, data []byte, rev string) (string, error) {
+	req, err := b.request(ctx, http.MethodPut, data)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setSyncPrecondition(req, rev)
+	return doSyncPut(req)
+}
+
+// s3SyncBackend keeps the snapshot as one object in an S3-compatible bucket
+// (path-style requests, SigV4 signing, conditional writes)
+type s3SyncBackend struct {
+	endpoint  string
+	bucket    string
+	key       string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func (b *s3SyncBackend) Fetch(ctx context.Context) ([]byte, string, error) {
+	req, err := b.request(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return doSyncGet(req)
+}
+
+func (b *s3SyncBackend) Store(ctx context.Context, data []byte, rev string) (string, error) {
+	req, err := b.request(ctx, http.MethodPut, data)
+	if err != nil {
+		return "", err
+	}
+	setSyncPrecondition(req, rev)
+	return doSyncPut(req)
+}
+
+func (b *s3SyncBackend) request(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	segments := []string{url.PathEscape(b.bucket)}
+	for _, part := range strings.Split(b.key, "/") {
+		segments = append(segments, url.PathEscape(part))
+	}
+	path := "/" + strings.Join(segments, "/")
+	req, err := http.NewRequestWithContext(ctx, method, b.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{method, req.URL.EscapedPath(), "", canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, b.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+b.secretKey), day)
+	signingKey = hmacSHA256(signingKey, b.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signedHeaders, signature))
+	return req, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// setSyncPrecondition makes a PUT fail with 412 if the remote moved since rev
+func setSyncPrecondition(req *http.Request, rev string) {
+	if rev == "" {
+		req.Header.Set("If-None-Match", "*")
+	} else {
+		req.Header.Set("If-Match", rev)
+	}
+}
+
+func doSyncGet(req *http.Request) ([]byte, string, error) {
+	client := &http.Client{Timeout: syncHTTPTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetching sync data failed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+func doSyncPut(req *http.Request) (string, error) {
+	client := &http.Client{Timeout: syncHTTPTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return "", errSyncRemoteChanged
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return "", fmt.Errorf("storing sync data failed: %s", resp.Status)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// gitSyncBackend commits the snapshot to a branch of a git repository through the git
+// CLI, so existing SSH keys and credential helpers keep working
+type gitSyncBackend struct {
+	remote   string
+	username string
+	password string
+	branch   string
+	file     string
+	dir      string // local working copy
+}
+
+// gitCredentialHelper answers git's credential requests from the environment, so
+// the password is on neither the command line nor disk
+const gitCredentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$TERM_SYNC_GIT_USERNAME" "$TERM_SYNC_GIT_PASSWORD"; }; f`
+
+func (b *gitSyncBackend) git(ctx context.Context, args ...string) (string, error) {
+	name := args[0]
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if b.username != "" {
+		// The empty helper drops configured helpers, which would otherwise be asked first
+		args = append([]string{"-c", "credential.helper=", "-c", "credential.helper=" + gitCredentialHelper}, args...)
+		env = append(env, "TERM_SYNC_GIT_USERNAME="+b.username, "TERM_SYNC_GIT_PASSWORD="+b.password)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = b.dir
+	cmd.Env = env
+	setCmdNoWindow(cmd)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git %s: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (b *gitSyncBackend) prepare(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Join(b.dir, ".git")); err != nil {
+		if err := os.MkdirAll(b.dir, 0700); err != nil {
+			return err
+		}
+		if _, err := b.git(ctx, "init", "--quiet"); err != nil {
+			return err
+		}
+		_, err := b.git(ctx, "remote", "add", "origin", b.remote)
+		return err
+	}
+	_, err := b.git(ctx, "remote", "set-url", "origin", b.remote)
+	return err
+}
+
+func (b *gitSyncBackend) Fetch(ctx context.Context) ([]byte, string, error) {
+	if err := b.prepare(ctx); err != nil {
+		return nil, "", err
+	}
+	if _, err := b.git(ctx, "fetch", "--quiet", "origin"); err != nil {
+		return nil, "", err
+	}
+	rev, err := b.git(ctx, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+b.branch)
+	if err != nil || rev == "" {
+		// Empty repository or branch not created yet
+		if _, err := b.git(ctx, "symbolic-ref", "HEAD", "refs/heads/"+b.branch); err != nil {
+			return nil, "", err
+		}
+		return nil, "", nil
+	}
+	if _, err := b.git(ctx, "checkout", "--quiet", "-f", "-B", b.branch, "refs/remotes/origin/"+b.branch); err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(filepath.Join(b.dir, b.file))
+	if os.IsNotExist(err) {
+		return nil, rev, nil
+	}
+	return data, rev, err
+}
+
+func (b *gitSyncBackend) Store(ctx context.Context, data []byte, rev string) (string, error) {
+	path := filepath.Join(b.dir, b.file)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	if _, err := b.git(ctx, "add", "--", b.file); err != nil {
+		return "", err
+	}
+	if _, err := b.git(ctx, "-c", "user.name=Term", "-c", "user.email=term@localhost",
+		"commit", "--quiet", "-m", "Sync "+time.Now().UTC().Format(time.RFC3339)); err != nil {
+		if status, _ := b.git(ctx, "status", "--porcelain", "--", b.file); status == "" {
+			return rev, nil // nothing changed
+		}
+		return "", err
+	}
+	if out, err := b.git(ctx, "push", "--quiet", "origin", "HEAD:refs/heads/"+b.branch); err != nil {
+		if strings.Contains(out, "rejected") || strings.Contains(out, "fetch first") || strings.Contains(out, "non-fast-forward") {
+			return "", errSyncRemoteChanged
+		}
+		return "", err
+	}
+	return b.git(ctx, "rev-parse", "HEAD")
+}
@@ -0,0 +1,915 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+const (
+	syncSnapshotVersion = 1
+	settingSyncConfig   = "sync_config"
+	settingSyncBase     = "sync_base" // last snapshot both sides agreed on
+	settingSyncDeviceID = "sync_device_id"
+	settingSyncLast     = "sync_last_at"
+	syncStoreAttempts   = 3
+	syncLoopTick        = time.Minute
+)
+
+// syncedSettings lists the preferences that are synced and exported. Settings
+// that describe this device, hold credentials, or name executables, hosts and
+// URLs the app runs or connects to stay local, as do settings added later until
+// they are listed here.
+var syncedSettings = map[string]bool{
+	"theme":                           true,
+	"active_theme":                    true,
+	settingThemeAutoLight:             true,
+	settingThemeAutoDark:              true,
+	"font_family":                     true,
+	"font_size":                       true,
+	"cursor_style":                    true,
+	"terminal_padding":                true,
+	"show_status_bar":                 true,
+	"auto_launch":                     true,
+	"restore_tabs_on_startup":         true,
+	settingBackgroundMode:             true,
+	"confirm_tab_close":               true,
+	settingKeymap:                     true,
+	settingSettingsProfiles:           true,
+	"recording_default_capture_input": true,
+	"recording_default_encrypt":       true,
+	settingCommandHistory:             true,
+	settingCommandHistoryRetention:    true,
+	settingTrashRetentionDays:         true,
+	settingLogLevel:                   true,
+	settingUpdateChannel:              true,
+	settingUpdateCheck:                true,
+	settingHealthCheckInterval:        true,
+	settingStatsInterval:              true,
+	settingStatsCPU:                   true,
+	settingStatsMemory:                true,
+	settingStatsDisk:                  true,
+	settingStatsNetwork:               true,
+	settingStatsLoad:                  true,
+	settingStatsSensors:               true,
+	settingStatsProcesses:             true,
+	settingStatsDocker:                true,
+	settingStatsBattery:               true,
+	settingStatsAlertRules:            true,
+	settingHostKeyMaxAge:              true,
+}
+
+// SyncConfig selects where the session tree, settings and themes are synced to
+type SyncConfig struct {
+	Backend         string `json:"backend"`         // "git", "webdav" or "s3"; empty disables sync
+	URL             string `json:"url"`             // git remote, WebDAV file URL or S3 endpoint (optional for AWS)
+	Path            string `json:"path"`            // file in the git repo or S3 object key (default term-sync.json)
+	Branch          string `json:"branch"`          // git branch (default main)
+	Bucket          string `json:"bucket"`          // S3
+	Region          string `json:"region"`          // S3 (default us-east-1)
+	Username        string `json:"username"`        // WebDAV/git user or S3 access key id
+	Password        string `json:"password"`        // WebDAV/git password or S3 secret key
+	Passphrase      string `json:"passphrase"`      // end-to-end key for session secrets; empty leaves secrets out
+	IntervalMinutes int    `json:"intervalMinutes"` // 0 syncs only on demand
+	Conflict        string `json:"conflict"`        // "newest" (default), "local" or "remote"
+}
+
+// SyncConflict is an item changed on both sides since the last sync
+type SyncConflict struct {
+	Kind string `json:"kind"` // "session", "setting" or "theme"
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kept string `json:"kept"` // "local" or "remote"
+}
+
+// SyncResult summarises one sync run
+type SyncResult struct {
+	Pulled    int            `json:"pulled"` // local items changed from the remote
+	Pushed    bool           `json:"pushed"`
+	Conflicts []SyncConflict `json:"conflicts"`
+	Revision  string         `json:"revision"`
+}
+
+// SyncStatus is reported with the sync:status event
+type SyncStatus struct {
+	State      string      `json:"state"` // "disabled", "idle", "syncing" or "error"
+	LastSync   *time.Time  `json:"lastSync,omitempty"`
+	LastError  string      `json:"lastError,omitempty"`
+	LastResult *SyncResult `json:"lastResult,omitempty"`
+}
+
+// syncSnapshot is the document stored by the backend
+type syncSnapshot struct {
+	Version   int                    `json:"version"`
+	DeviceID  string                 `json:"deviceId"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+	KDF       *sessionExportKDF      `json:"kdf,omitempty"` // derives the key sealing node secrets
+	Nodes     map[string]syncNode    `json:"nodes"`
+	Settings  map[string]syncSetting `json:"settings"`
+	Themes    map[string]syncTheme   `json:"themes"` // by file name
+}
+
+type syncNode struct {
+	ParentID    string            `json:"parentId,omitempty"`
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	SessionType string            `json:"sessionType,omitempty"`
+	Position    int               `json:"position"`
+	Template    bool              `json:"template,omitempty"`
+	Icon        string            `json:"icon,omitempty"`
+	Color       string            `json:"color,omitempty"`
+	Config      map[string]string `json:"config,omitempty"`
+	Secrets     map[string]string `json:"secrets,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	ModifiedAt  time.Time         `json:"modifiedAt"`
+}
+
+type syncSetting struct {
+	Value      string    `json:"value"`
+	ValueType  string    `json:"valueType"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+type syncTheme struct {
+	Content    string    `json:"content"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+// SyncService replicates the session tree, settings and themes through a shared backend
+type SyncService struct {
+	app      *application.App
+	db       *database.DB
+	secrets  *SecretStore
+	dataDir  string
+	themeDir string
+
+	run    sync.Mutex // one sync at a time
+	mu     sync.Mutex
+	status SyncStatus
+
+	stop     chan struct{} // ends the sync loop
+	stopOnce sync.Once
+}
+
+// NewSyncService creates the sync service; dataDir holds the git working copy
+func NewSyncService(app *application.App, db *database.DB, secrets *SecretStore, dataDir, themeDir string) *SyncService {
+	s := &SyncService{app: app, db: db, secrets: secrets, dataDir: dataDir, themeDir: themeDir, stop: make(chan struct{})}
+	s.status.State = "disabled"
+	if cfg, err := s.loadConfig(); err == nil && cfg.Backend != "" {
+		s.status.State = "idle"
+	}
+	if setting, err := db.GetSetting(settingSyncLast); err == nil && setting.Value != "" {
+		if t, err := time.Parse(time.RFC3339, setting.Value); err == nil {
+			s.status.LastSync = &t
+		}
+	}
+	return s
+}
+
+// GetSyncConfig returns the sync configuration with credentials masked
+func (s *SyncService) GetSyncConfig() (SyncConfig, error) {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Password != "" {
+		cfg.Password = maskedSecretValue
+	}
+	if cfg.Passphrase != "" {
+		cfg.Passphrase = maskedSecretValue
+	}
+	return cfg, nil
+}
+
+// SetSyncConfig stores the sync configuration; masked credentials keep their stored value
+func (s *SyncService) SetSyncConfig(cfg SyncConfig) error {
+	switch cfg.Backend {
+	case "", "git", "webdav", "s3":
+	default:
+		return fmt.Errorf("unknown sync backend %q", cfg.Backend)
+	}
+	switch cfg.Conflict {
+	case "", "newest", "local", "remote":
+	default:
+		return fmt.Errorf("unknown conflict strategy %q", cfg.Conflict)
+	}
+	if cfg.IntervalMinutes < 0 {
+		return fmt.Errorf("sync interval cannot be negative")
+	}
+
+	stored := SyncConfig{}
+	if setting, err := s.db.GetSetting(settingSyncConfig); err == nil && setting.Value != "" {
+		json.Unmarshal([]byte(setting.Value), &stored)
+	}
+	seal := func(value, previous string) (string, error) {
+		if value == maskedSecretValue {
+			return previous, nil
+		}
+		if value == "" {
+			return "", nil
+		}
+		return s.secrets.Encrypt(value)
+	}
+	var err error
+	if cfg.Password, err = seal(cfg.Password, stored.Password); err != nil {
+		return fmt.Errorf("failed to encrypt sync password: %w", err)
+	}
+	if cfg.Passphrase, err = seal(cfg.Passphrase, stored.Passphrase); err != nil {
+		return fmt.Errorf("failed to encrypt sync passphrase: %w", err)
+	}
+	if cfg.Passphrase != stored.Passphrase {
+		// Secrets sealed with the old passphrase cannot be compared any more
+		s.db.DeleteSetting(settingSyncBase)
+	}
+	if err := s.db.SetSettingJSON(settingSyncConfig, cfg); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if cfg.Backend == "" {
+		s.status.State = "disabled"
+	} else if s.status.State == "disabled" {
+		s.status.State = "idle"
+	}
+	s.mu.Unlock()
+	s.emitStatus()
+	return nil
+}
+
+// GetSyncStatus returns the state of the last sync
+func (s *SyncService) GetSyncStatus() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// StartSyncLoop syncs in the background at the configured interval
+func (s *SyncService) StartSyncLoop() {
+	go func() {
+		ticker := time.NewTicker(syncLoopTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+			cfg, err := s.loadConfig()
+			if err != nil || cfg.Backend == "" || cfg.IntervalMinutes <= 0 || s.db.Locked() {
+				continue
+			}
+			last := s.GetSyncStatus().LastSync
+			if last != nil && time.Since(*last) < time.Duration(cfg.IntervalMinutes)*time.Minute {
+				continue
+			}
+			if _, err := s.SyncNow(); err != nil {
+				log.Printf("Background sync failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Stop ends background syncing and waits for a sync in progress, so that quitting
+// does not leave the working copy half written
+func (s *SyncService) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.run.Lock()
+	s.run.Unlock()
+}
+
+// SyncNow merges local and remote changes since the last sync and pushes the result.
+// Items changed on both sides are resolved with the configured conflict strategy.
+func (s *SyncService) SyncNow() (*SyncResult, error) {
+	s.run.Lock()
+	defer s.run.Unlock()
+	select {
+	case <-s.stop:
+		return nil, errors.New("sync stopped: the app is quitting")
+	default:
+	}
+
+	s.setState("syncing", "", nil)
+	result, err := s.syncOnce()
+	if err != nil {
+		s.setState("error", err.Error(), nil)
+		return nil, err
+	}
+	now := time.Now()
+	s.db.SetSetting(settingSyncLast, now.Format(time.RFC3339), "string")
+	s.mu.Lock()
+	s.status.LastSync = &now
+	s.mu.Unlock()
+	s.setState("idle", "", result)
+	return result, nil
+}
+
+func (s *SyncService) syncOnce() (*SyncResult, error) {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Password, err = s.secrets.Decrypt(cfg.Password); err != nil {
+		return nil, fmt.Errorf("failed to decrypt sync password: %w", err)
+	}
+	if cfg.Passphrase, err = s.secrets.Decrypt(cfg.Passphrase); err != nil {
+		return nil, fmt.Errorf("failed to decrypt sync passphrase: %w", err)
+	}
+	backend, err := newSyncBackend(cfg, s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+
+	base := s.loadBase()
+	for attempt := 1; ; attempt++ {
+		data, rev, err := backend.Fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var remote *syncSnapshot
+		if data != nil {
+			remote = &syncSnapshot{}
+			if err := json.Unmarshal(data, remote); err != nil {
+				return nil, fmt.Errorf("failed to parse remote sync data: %w", err)
+			}
+			if remote.Version != syncSnapshotVersion {
+				return nil, fmt.Errorf("unsupported sync data version %d", remote.Version)
+			}
+			// Older versions synced more settings; those stay untouched here
+			for key := range remote.Settings {
+				if !syncedSettings[key] {
+					delete(remote.Settings, key)
+				}
+			}
+		}
+
+		key, kdf, err := s.syncKey(cfg.Passphrase, remote, base)
+		if err != nil {
+			return nil, err
+		}
+		local, err := s.buildLocalSnapshot(key, base)
+		if err != nil {
+			return nil, err
+		}
+		local.KDF = kdf
+
+		remoteNodes, remoteSettings, remoteThemes := local.Nodes, local.Settings, local.Themes
+		if remote != nil {
+			remoteNodes, remoteSettings, remoteThemes = remote.Nodes, remote.Settings, remote.Themes
+		}
+		result := &SyncResult{Conflicts: []SyncConflict{}, Revision: rev}
+		strategy := cfg.Conflict
+		merged := &syncSnapshot{Version: syncSnapshotVersion, DeviceID: local.DeviceID, UpdatedAt: time.Now().UTC(), KDF: kdf}
+		merged.Nodes = mergeSyncItems(base.Nodes, local.Nodes, remoteNodes, strategy,
+			func(n syncNode) time.Time { return n.ModifiedAt },
+			func(id string, n syncNode, kept string) {
+				result.Conflicts = append(result.Conflicts, SyncConflict{Kind: "session", ID: id, Name: n.Name, Kept: kept})
+			})
+		merged.Settings = mergeSyncItems(base.Settings, local.Settings, remoteSettings, strategy,
+			func(v syncSetting) time.Time { return v.ModifiedAt },
+			func(id string, _ syncSetting, kept string) {
+				result.Conflicts = append(result.Conflicts, SyncConflict{Kind: "setting", ID: id, Name: id, Kept: kept})
+			})
+		merged.Themes = mergeSyncItems(base.Themes, local.Themes, remoteThemes, strategy,
+			func(t syncTheme) time.Time { return t.ModifiedAt },
+			func(id string, _ syncTheme, kept string) {
+				result.Conflicts = append(result.Conflicts, SyncConflict{Kind: "theme", ID: id, Name: id, Kept: kept})
+			})
+
+		if result.Pulled, err = s.applySnapshot(local, merged, key); err != nil {
+			return nil, fmt.Errorf("failed to apply synced changes: %w", err)
+		}
+
+		if remote == nil || !sameSyncContent(remote, merged) {
+			encoded, err := json.MarshalIndent(merged, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+			newRev, err := backend.Store(ctx, encoded, rev)
+			if errors.Is(err, errSyncRemoteChanged) && attempt < syncStoreAttempts {
+				// Someone pushed on top of what we fetched; merge their change next
+				if remote != nil {
+					base = remote
+				}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			result.Pushed = true
+			result.Revision = newRev
+		}
+		if err := s.db.SetSettingJSON(settingSyncBase, merged); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+}
+
+// syncKey derives the key sealing node secrets. The salt travels with the snapshot so
+// every device derives the same key from the same passphrase.
+func (s *SyncService) syncKey(passphrase string, remote, base *syncSnapshot) ([]byte, *sessionExportKDF, error) {
+	if passphrase == "" {
+		return nil, nil, nil
+	}
+	kdf := base.KDF
+	if remote != nil && remote.KDF != nil {
+		kdf = remote.KDF
+	}
+	if kdf == nil {
+		salt, err := randBytes(16)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := deriveKeyArgon2([]byte(passphrase), salt, defaultArgon2)
+		check, err := sealSecret(key, secretsKeyCheckPlain)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &sessionExportKDF{Name: "argon2id", Params: defaultArgon2, Salt: b64(salt), Check: check}, nil
+	}
+	salt, err := decodeB64(kdf.Salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid sync salt: %w", err)
+	}
+	if err := checkArgon2Params(kdf.Params); err != nil {
+		return nil, nil, fmt.Errorf("invalid sync snapshot: %w", err)
+	}
+	key := deriveKeyArgon2([]byte(passphrase), salt, kdf.Params)
+	if plain, err := openSecret(key, kdf.Check); err != nil || plain != secretsKeyCheckPlain {
+		return nil, nil, fmt.Errorf("wrong sync passphrase")
+	}
+	return key, kdf, nil
+}
+
+// buildLocalSnapshot captures this device's state. Secrets whose value did not change
+// reuse the sealed text from base so they compare equal.
+func (s *SyncService) buildLocalSnapshot(key []byte, base *syncSnapshot) (*syncSnapshot, error) {
+	snap := &syncSnapshot{
+		Version:  syncSnapshotVersion,
+		DeviceID: s.deviceID(),
+		Nodes:    map[string]syncNode{},
+		Settings: map[string]syncSetting{},
+		Themes:   map[string]syncTheme{},
+	}
+
+	nodes, err := s.db.GetAllSessions()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.db.GetAllSessionTags()
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		n := syncNode{Name: node.Name, Type: node.Type, Position: node.Position, Template: node.IsTemplate,
+			Icon: node.Icon, Color: node.Color, Tags: tags[node.ID], ModifiedAt: node.UpdatedAt.UTC()}
+		if node.ParentID != nil {
+			n.ParentID = *node.ParentID
+		}
+		if node.SessionType != nil {
+			n.SessionType = *node.SessionType
+		}
+		entries, err := s.db.GetSessionConfigEntries(node.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range entries {
+			// Credential sets are not synced, so a reference to one would dangle
+			// on other devices; each device keeps its own
+			if c.Key == credentialIDKey {
+				continue
+			}
+			if c.UpdatedAt.After(n.ModifiedAt) {
+				n.ModifiedAt = c.UpdatedAt.UTC()
+			}
+			if !(c.ValueType == "secret" || isSecretConfigKey(c.Key) || isEncryptedSecret(c.Value)) {
+				if n.Config == nil {
+					n.Config = map[string]string{}
+				}
+				n.Config[c.Key] = c.Value
+				continue
+			}
+			if key == nil || c.Value == "" {
+				continue
+			}
+			plain, err := s.secrets.Decrypt(c.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s of %s: %w", c.Key, node.Name, err)
+			}
+			sealed := base.Nodes[node.ID].Secrets[c.Key]
+			if prev, err := openSecret(key, sealed); sealed == "" || err != nil || prev != plain {
+				if sealed, err = sealSecret(key, plain); err != nil {
+					return nil, err
+				}
+			}
+			if n.Secrets == nil {
+				n.Secrets = map[string]string{}
+			}
+			n.Secrets[c.Key] = sealed
+		}
+		snap.Nodes[node.ID] = n
+	}
+
+	settings, err := s.db.ListSettings()
+	if err != nil {
+		return nil, err
+	}
+	for _, setting := range settings {
+		if !syncedSettings[setting.Key] {
+			continue
+		}
+		snap.Settings[setting.Key] = syncSetting{Value: setting.Value, ValueType: setting.ValueType, ModifiedAt: setting.UpdatedAt.UTC()}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(s.themeDir, "*.json"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		snap.Themes[filepath.Base(file)] = syncTheme{Content: string(data), ModifiedAt: info.ModTime().UTC()}
+	}
+	return snap, nil
+}
+
+// applySnapshot makes the local state match merged and returns how many items changed
+func (s *SyncService) applySnapshot(local, merged *syncSnapshot, key []byte) (int, error) {
+	changed := 0
+
+	// Parents before children so foreign keys hold
+	ids := make([]string, 0, len(merged.Nodes))
+	for id := range merged.Nodes {
+		ids = append(ids, id)
+	}
+	depth := func(id string) int {
+		d := 0
+		for seen := map[string]bool{}; merged.Nodes[id].ParentID != "" && !seen[id]; d++ {
+			seen[id] = true
+			id = merged.Nodes[id].ParentID
+		}
+		return d
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		di, dj := depth(ids[i]), depth(ids[j])
+		if di != dj {
+			return di < dj
+		}
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		want := merged.Nodes[id]
+		have, exists := local.Nodes[id]
+		if exists && syncEqual(have, want) {
+			continue
+		}
+		if err := s.applyNode(id, want, have, exists, merged, key); err != nil {
+			return changed, fmt.Errorf("%s: %w", want.Name, err)
+		}
+		changed++
+	}
+	for id := range local.Nodes {
+		if _, keep := merged.Nodes[id]; keep {
+			continue
+		}
+		if err := s.db.DeleteSession(id, false); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	for key, want := range merged.Settings {
+		if have, ok := local.Settings[key]; ok && have.Value == want.Value && have.ValueType == want.ValueType {
+			continue
+		}
+		if err := s.db.SetSetting(key, want.Value, want.ValueType); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	for key := range local.Settings {
+		if _, keep := merged.Settings[key]; !keep {
+			if err := s.db.DeleteSetting(key); err != nil {
+				return changed, err
+			}
+			changed++
+		}
+	}
+
+	if len(merged.Themes) > 0 || len(local.Themes) > 0 {
+		if err := os.MkdirAll(s.themeDir, 0755); err != nil {
+			return changed, err
+		}
+	}
+	for name, want := range merged.Themes {
+		if have, ok := local.Themes[name]; ok && have.Content == want.Content {
+			continue
+		}
+		if filepath.Base(name) != name || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(s.themeDir, name), []byte(want.Content), 0644); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	for name := range local.Themes {
+		if _, keep := merged.Themes[name]; !keep {
+			os.Remove(filepath.Join(s.themeDir, name))
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+func (s *SyncService) applyNode(id string, want, have syncNode, exists bool, merged *syncSnapshot, key []byte) error {
+	node := database.SessionNode{ID: id, Name: want.Name, Type: want.Type, Position: want.Position, IsTemplate: want.Template,
+		Icon: want.Icon, Color: want.Color}
+	if _, ok := merged.Nodes[want.ParentID]; ok {
+		parent := want.ParentID
+		node.ParentID = &parent
+	}
+	if want.SessionType != "" {
+		sessionType := want.SessionType
+		node.SessionType = &sessionType
+	}
+	if exists {
+		if err := s.db.UpdateSession(&node); err != nil {
+			return err
+		}
+		if have.Template != want.Template {
+			if err := s.db.SetSessionTemplate(id, want.Template); err != nil {
+				return err
+			}
+		}
+		if have.Icon != want.Icon || have.Color != want.Color {
+			if err := s.db.SetSessionAppearance(id, want.Icon, want.Color); err != nil {
+				return err
+			}
+		}
+	} else {
+		// A copy deleted here still holds the id in the trash; the synced one wins
+		if err := s.db.PurgeSession(id); err != nil && !errors.Is(err, database.ErrNotInTrash) {
+			return err
+		}
+		if err := s.db.CreateSession(&node); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range want.Config {
+		if k == credentialIDKey {
+			continue
+		}
+		if have.Config[k] != v || !exists {
+			if err := s.db.SetSessionConfig(id, k, v, "string"); err != nil {
+				return err
+			}
+		}
+	}
+	for k := range have.Config {
+		if _, keep := want.Config[k]; !keep {
+			if err := s.db.DeleteSessionConfig(id, k); err != nil {
+				return err
+			}
+		}
+	}
+	// Without the passphrase neither side carries secrets, so local ones are left alone
+	if key != nil {
+		for k, sealed := range want.Secrets {
+			if have.Secrets[k] == sealed {
+				continue
+			}
+			plain, err := openSecret(key, sealed)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt synced %s: %w", k, err)
+			}
+			local, err := s.secrets.Encrypt(plain)
+			if err != nil {
+				return err
+			}
+			if err := s.db.SetSessionConfig(id, k, local, "secret"); err != nil {
+				return err
+			}
+		}
+		for k := range have.Secrets {
+			if _, keep := want.Secrets[k]; !keep {
+				if err := s.db.DeleteSessionConfig(id, k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s.db.SetSessionTags(id, want.Tags)
+}
+
+// mergeSyncItems is a three-way merge by id. Items changed on one side only take that
+// side; items changed differently on both sides are conflicts resolved by strategy.
+func mergeSyncItems[T any](base, local, remote map[string]T, strategy string, modified func(T) time.Time, conflict func(id string, item T, kept string)) map[string]T {
+	ids := map[string]bool{}
+	for _, m := range []map[string]T{base, local, remote} {
+		for id := range m {
+			ids[id] = true
+		}
+	}
+	merged := make(map[string]T, len(ids))
+	for id := range ids {
+		b, inBase := base[id]
+		l, inLocal := local[id]
+		r, inRemote := remote[id]
+		localChanged := inLocal != inBase || (inLocal && !syncEqual(l, b))
+		remoteChanged := inRemote != inBase || (inRemote && !syncEqual(r, b))
+
+		keepLocal := true
+		switch {
+		case !remoteChanged:
+		case !localChanged:
+			keepLocal = false
+		case inLocal == inRemote && (!inLocal || syncEqual(l, r)):
+			// Same change on both sides
+		default:
+			switch strategy {
+			case "local":
+			case "remote":
+				keepLocal = false
+			default:
+				// Prefer an edit over a delete, otherwise the newer edit
+				keepLocal = inLocal && (!inRemote || !modified(r).After(modified(l)))
+			}
+			kept, item := "local", l
+			if !keepLocal {
+				kept, item = "remote", r
+			}
+			if !inLocal {
+				item = r
+			} else if !inRemote {
+				item = l
+			}
+			conflict(id, item, kept)
+		}
+
+		if keepLocal && inLocal {
+			merged[id] = l
+		} else if !keepLocal && inRemote {
+			merged[id] = r
+		}
+	}
+	return merged
+}
+
+// syncEqual compares two items ignoring their modification time
+func syncEqual[T any](a, b T) bool {
+	ja, _ := json.Marshal(withoutModified(a))
+	jb, _ := json.Marshal(withoutModified(b))
+	return string(ja) == string(jb)
+}
+
+func withoutModified(v any) any {
+	switch item := v.(type) {
+	case syncNode:
+		item.ModifiedAt = time.Time{}
+		if len(item.Tags) == 0 {
+			item.Tags = nil
+		}
+		return item
+	case syncSetting:
+		item.ModifiedAt = time.Time{}
+		return item
+	case syncTheme:
+		item.ModifiedAt = time.Time{}
+		return item
+	}
+	return v
+}
+
+func sameSyncContent(a, b *syncSnapshot) bool {
+	if len(a.Nodes) != len(b.Nodes) || len(a.Settings) != len(b.Settings) || len(a.Themes) != len(b.Themes) {
+		return false
+	}
+	for id, n := range a.Nodes {
+		if other, ok := b.Nodes[id]; !ok || !syncEqual(n, other) {
+			return false
+		}
+	}
+	for k, v := range a.Settings {
+		if other, ok := b.Settings[k]; !ok || !syncEqual(v, other) {
+			return false
+		}
+	}
+	for k, v := range a.Themes {
+		if other, ok := b.Themes[k]; !ok || !syncEqual(v, other) {
+			return false
+		}
+	}
+	return (a.KDF == nil) == (b.KDF == nil)
+}
+
+func (s *SyncService) loadConfig() (SyncConfig, error) {
+	var cfg SyncConfig
+	setting, err := s.db.GetSetting(settingSyncConfig)
+	if err != nil || setting.Value == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(setting.Value), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid sync configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// resealSyncConfig returns the stored sync configuration with its credentials passed
+// through reseal, or "" when there is nothing to re-encrypt
+func resealSyncConfig(db *database.DB, reseal func(string) (string, error)) (string, error) {
+	setting, err := db.GetSetting(settingSyncConfig)
+	if err != nil || setting.Value == "" {
+		return "", nil
+	}
+	var cfg SyncConfig
+	if err := json.Unmarshal([]byte(setting.Value), &cfg); err != nil {
+		return "", err
+	}
+	if cfg.Password == "" && cfg.Passphrase == "" {
+		return "", nil
+	}
+	for _, value := range []*string{&cfg.Password, &cfg.Passphrase} {
+		if *value == "" {
+			continue
+		}
+		if *value, err = reseal(*value); err != nil {
+			return "", err
+		}
+	}
+	data, err := json.Marshal(cfg)
+	return string(data), err
+}
+
+func (s *SyncService) loadBase() *syncSnapshot {
+	base := &syncSnapshot{}
+	if setting, err := s.db.GetSetting(settingSyncBase); err == nil && setting.Value != "" {
+		if err := json.Unmarshal([]byte(setting.Value), base); err != nil {
+			log.Printf("Ignoring unreadable sync base: %v", err)
+			base = &syncSnapshot{}
+		}
+	}
+	return base
+}
+
+// deviceID identifies this installation in pushed snapshots
+func (s *SyncService) deviceID() string {
+	if setting, err := s.db.GetSetting(settingSyncDeviceID); err == nil && setting.Value != "" {
+		return setting.Value
+	}
+	id := newNodeID("device")
+	if host, err := os.Hostname(); err == nil && host != "" {
+		id = host + "-" + id
+	}
+	s.db.SetSetting(settingSyncDeviceID, id, "string")
+	return id
+}
+
+func (s *SyncService) setState(state, errMsg string, result *SyncResult) {
+	s.mu.Lock()
+	s.status.State = state
+	s.status.LastError = errMsg
+	if result != nil {
+		s.status.LastResult = result
+	}
+	s.mu.Unlock()
+	s.emitStatus()
+}
+
+func (s *SyncService) emitStatus() {
+	if s.app == nil {
+		return
+	}
+	status := s.GetSyncStatus()
+	data := map[string]interface{}{
+		"state":     status.State,
+		"lastError": status.LastError,
+	}
+	if status.LastSync != nil {
+		data["lastSync"] = status.LastSync.Format(time.RFC3339)
+	}
+	if status.LastResult != nil {
+		data["pulled"] = status.LastResult.Pulled
+		data["pushed"] = status.LastResult.Pushed
+		data["conflicts"] = status.LastResult.Conflicts
+	}
+	s.app.Event.Emit("sync:status", data)
+}
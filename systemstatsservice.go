@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
-	"time"
+	"fmt"
+	"os"
 
 	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/shirou/gopsutil/v4/disk"
 	"github.com/shirou/gopsutil/v4/load"
 	"github.com/shirou/gopsutil/v4/mem"
 	"github.com/shirou/gopsutil/v4/net"
+	"github.com/shirou/gopsutil/v4/process"
 	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
@@ -26,6 +28,17 @@ type SystemStats struct {
 	LoadAvg1      float64 `json:"loadAvg1"`
 	LoadAvg5      float64 `json:"loadAvg5"`
 	LoadAvg15     float64 `json:"loadAvg15"`
+
+	CPUTemperature float64      `json:"cpuTemperature,omitempty"` // °C; absent where sensors are not supported
+	Fans           []FanReading `json:"fans,omitempty"`
+
+	Battery *BatteryStatus `json:"battery,omitempty"` // this machine only, when it has one
+
+	SSH *SSHConnStats `json:"ssh,omitempty"` // latency and throughput of the SSH session, SSH hosts only
+
+	Processes  []ProcessInfo    `json:"processes,omitempty"`  // top processes by processSort
+	Containers []ContainerStats `json:"containers,omitempty"` // Docker containers, SSH hosts only
+	SessionID  string           `json:"sessionId,omitempty"`  // SSH session the stats come from; empty for this machine
 }
 
 // SystemStatsService provides system resource monitoring
@@ -34,16 +47,20 @@ type SystemStatsService struct {
 	terminalService *TerminalService
 	ctx             context.Context
 	cancel          context.CancelFunc
-	updateInterval  time.Duration
+	config          *statsConfig
 	lastNetworkStat *net.IOCountersStat
 	activeSessionID string
+	processes       processSampler
+	processSort     string // "cpu" or "memory"
+	kills           killConfirmations
+	alerts          *StatsAlertService
 }
 
 // NewSystemStatsService creates a new system stats service
-func NewSystemStatsService(terminalService *TerminalService) *SystemStatsService {
+func NewSystemStatsService(terminalService *TerminalService, config *statsConfig) *SystemStatsService {
 	return &SystemStatsService{
 		terminalService: terminalService,
-		updateInterval:  2 * time.Second, // Update every 2 seconds
+		config:          config,
 	}
 }
 
@@ -52,6 +69,11 @@ func (s *SystemStatsService) SetApp(app *application.App) {
 	s.app = app
 }
 
+// SetAlerts sets the service checking collected stats against the alert rules
+func (s *SystemStatsService) SetAlerts(alerts *StatsAlertService) {
+	s.alerts = alerts
+}
+
 // SetActiveSession sets which session is currently active
 func (s *SystemStatsService) SetActiveSession(sessionID string) {
 	s.activeSessionID = sessionID
@@ -73,86 +95,165 @@ func (s *SystemStatsService) Stop() {
 
 // collectStats periodically collects and emits system statistics
 func (s *SystemStatsService) collectStats() {
-	ticker := time.NewTicker(s.updateInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-ticker.C:
-			// Only emit local stats if the active session is not SSH
-			// (remote stats service handles SSH sessions)
-			shouldEmit := true
-			if s.activeSessionID != "" && s.terminalService != nil {
-				session := s.terminalService.GetSession(s.activeSessionID)
-				if session != nil && session.IsSSH {
-					shouldEmit = false
-				}
+	s.config.statsTicks(s.ctx.Done(), func(settings StatsSettings, restarted bool) {
+		// Only emit local stats if the active session is not SSH
+		// (remote stats service handles SSH sessions)
+		if s.activeSessionID != "" && s.terminalService != nil {
+			session := s.terminalService.GetSession(s.activeSessionID)
+			if session != nil && session.IsSSH {
+				return
 			}
+		}
 
-			if shouldEmit {
-				stats := s.getSystemStats()
-				if s.app != nil {
-					s.app.Event.Emit("system:stats", stats)
-				}
-			}
+		// A network delta spanning a pause or another interval would be misleading
+		if restarted {
+			s.lastNetworkStat = nil
 		}
-	}
+		stats := s.getSystemStats(settings)
+		if s.alerts != nil {
+			s.alerts.Observe(s.activeSessionID, stats)
+		}
+		if s.app != nil {
+			s.app.Event.Emit("system:stats", stats)
+		}
+	})
 }
 
-// getSystemStats collects current system statistics
-func (s *SystemStatsService) getSystemStats() SystemStats {
+// getSystemStats collects the enabled system statistics
+func (s *SystemStatsService) getSystemStats(settings StatsSettings) SystemStats {
 	stats := SystemStats{}
 
 	// CPU Usage
-	cpuPercents, err := cpu.Percent(0, false)
-	if err == nil && len(cpuPercents) > 0 {
-		stats.CPUPercent = cpuPercents[0]
+	if settings.CPU {
+		cpuPercents, err := cpu.Percent(0, false)
+		if err == nil && len(cpuPercents) > 0 {
+			stats.CPUPercent = cpuPercents[0]
+		}
 	}
 
 	// Memory Usage
-	memInfo, err := mem.VirtualMemory()
-	if err == nil {
-		stats.MemoryPercent = memInfo.UsedPercent
-		stats.MemoryUsed = memInfo.Used
-		stats.MemoryTotal = memInfo.Total
+	if settings.Memory {
+		memInfo, err := mem.VirtualMemory()
+		if err == nil {
+			stats.MemoryPercent = memInfo.UsedPercent
+			stats.MemoryUsed = memInfo.Used
+			stats.MemoryTotal = memInfo.Total
+		}
 	}
 
 	// Disk Usage (root partition)
-	diskInfo, err := disk.Usage("/")
-	if err == nil {
-		stats.DiskPercent = diskInfo.UsedPercent
-		stats.DiskUsed = diskInfo.Used
-		stats.DiskTotal = diskInfo.Total
+	if settings.Disk {
+		diskInfo, err := disk.Usage("/")
+		if err == nil {
+			stats.DiskPercent = diskInfo.UsedPercent
+			stats.DiskUsed = diskInfo.Used
+			stats.DiskTotal = diskInfo.Total
+		}
 	}
 
 	// Network I/O
-	netStats, err := net.IOCounters(false)
-	if err == nil && len(netStats) > 0 {
-		currentStat := &netStats[0]
-
-		// Calculate delta if we have previous stats
-		if s.lastNetworkStat != nil {
-			stats.NetworkSent = currentStat.BytesSent - s.lastNetworkStat.BytesSent
-			stats.NetworkRecv = currentStat.BytesRecv - s.lastNetworkStat.BytesRecv
-		}
+	if settings.Network {
+		netStats, err := net.IOCounters(false)
+		if err == nil && len(netStats) > 0 {
+			currentStat := &netStats[0]
+
+			// Calculate delta if we have previous stats
+			if s.lastNetworkStat != nil {
+				stats.NetworkSent = currentStat.BytesSent - s.lastNetworkStat.BytesSent
+				stats.NetworkRecv = currentStat.BytesRecv - s.lastNetworkStat.BytesRecv
+			}
 
-		s.lastNetworkStat = currentStat
+			s.lastNetworkStat = currentStat
+		}
 	}
 
 	// Load Average
-	loadInfo, err := load.Avg()
-	if err == nil {
-		stats.LoadAvg1 = loadInfo.Load1
-		stats.LoadAvg5 = loadInfo.Load5
-		stats.LoadAvg15 = loadInfo.Load15
+	if settings.Load {
+		loadInfo, err := load.Avg()
+		if err == nil {
+			stats.LoadAvg1 = loadInfo.Load1
+			stats.LoadAvg5 = loadInfo.Load5
+			stats.LoadAvg15 = loadInfo.Load15
+		}
+	}
+
+	// Temperature and fans
+	if settings.Sensors {
+		stats.CPUTemperature, stats.Fans = localSensors()
+	}
+
+	// Battery
+	if settings.Battery {
+		stats.Battery = localBattery()
+	}
+
+	// Top processes
+	if settings.Processes {
+		stats.Processes = s.processes.top(s.processSort, topProcessCount)
 	}
 
 	return stats
 }
 
+// GetStatsSettings returns the stats interval and which metrics are collected
+func (s *SystemStatsService) GetStatsSettings() StatsSettings {
+	settings, _, _ := s.config.current()
+	return settings
+}
+
+// SetStatsSettings saves the stats interval and metric toggles; local and remote
+// collection both apply them from the next tick
+func (s *SystemStatsService) SetStatsSettings(settings StatsSettings) error {
+	return s.config.save(settings)
+}
+
+// SetStatsVisible is called by the frontend when the stats panel is shown or
+// hidden; nothing is collected, locally or over SSH, while it is hidden
+func (s *SystemStatsService) SetStatsVisible(visible bool) {
+	s.config.update(func() { s.config.visible = visible })
+}
+
+// SetProcessSort orders the process list by "cpu" (default) or "memory"
+func (s *SystemStatsService) SetProcessSort(by string) {
+	s.processSort = by
+}
+
+// RequestKillProcess asks to kill a local process; KillProcess with the returned
+// token, once the user confirmed, does it
+func (s *SystemStatsService) RequestKillProcess(pid int32) (KillRequest, error) {
+	if int(pid) == os.Getpid() {
+		return KillRequest{}, fmt.Errorf("refusing to kill the app itself")
+	}
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return KillRequest{}, fmt.Errorf("process %d not found", pid)
+	}
+	name, _ := p.Name()
+	user, _ := p.Username()
+	return s.kills.request("", pid, name, user)
+}
+
+// KillProcess terminates the process of a confirmed kill request, unless its PID
+// now belongs to another process
+func (s *SystemStatsService) KillProcess(token string) error {
+	req, err := s.kills.confirm(token)
+	if err != nil {
+		return err
+	}
+	p, err := process.NewProcess(req.PID)
+	if err != nil {
+		return fmt.Errorf("process %d already exited", req.PID)
+	}
+	if name, _ := p.Name(); name != req.Name {
+		return fmt.Errorf("process %d is no longer %s", req.PID, req.Name)
+	}
+	if err := p.Terminate(); err != nil {
+		return fmt.Errorf("failed to kill process %d: %w", req.PID, err)
+	}
+	return nil
+}
+
 // GetCurrentStats returns the current system stats (for on-demand requests)
 func (s *SystemStatsService) GetCurrentStats() SystemStats {
-	return s.getSystemStats()
+	return s.getSystemStats(s.GetStatsSettings())
 }
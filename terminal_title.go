@@ -0,0 +1,78 @@
+package main
+
+import "strings"
+
+// maxTitleLength bounds the titles kept; longer ones are cut
+const maxTitleLength = 256
+
+// titleScanner follows a terminal's output for the titles programs set with
+// OSC 0 or OSC 2, e.g. shells showing the running command and directory. It
+// keeps its state between reads, as a sequence can be split across them.
+type titleScanner struct {
+	osc      []byte // OSC sequence being received
+	inOSC    bool
+	afterEsc bool
+}
+
+// scan returns the last title set in data, if any
+func (s *titleScanner) scan(data []byte) (title string, ok bool) {
+	for _, b := range data {
+		if s.inOSC {
+			switch {
+			case b == 0x07:
+				title, ok = s.finish(title, ok)
+			case b == 0x1b:
+				s.afterEsc = true
+			case s.afterEsc && b == '\\':
+				title, ok = s.finish(title, ok)
+			case s.afterEsc:
+				// Another sequence interrupted the OSC
+				s.inOSC, s.afterEsc, s.osc = b == ']', false, s.osc[:0]
+			case len(s.osc) < maxTitleLength+2: // "0;" and the title
+				s.osc = append(s.osc, b)
+			}
+			continue
+		}
+		if s.afterEsc {
+			s.afterEsc = false
+			if b == ']' {
+				s.inOSC, s.osc = true, s.osc[:0]
+				continue
+			}
+		}
+		if b == 0x1b {
+			s.afterEsc = true
+		}
+	}
+	return title, ok
+}
+
+// finish ends the OSC sequence, returning its title if it sets one
+func (s *titleScanner) finish(title string, ok bool) (string, bool) {
+	seq := string(s.osc)
+	s.inOSC, s.afterEsc, s.osc = false, false, s.osc[:0]
+	if kind, text, found := strings.Cut(seq, ";"); found && (kind == "0" || kind == "2") {
+		// A cut title can end inside a character
+		return strings.ToValidUTF8(text, ""), true
+	}
+	return title, ok
+}
+
+// trackTitle emits terminal:title when a terminal's output changes its title
+func (t *TerminalService) trackTitle(session *TerminalSession, data []byte) {
+	title, ok := session.titles.scan(data)
+	if !ok || title == session.Title() {
+		return
+	}
+	session.title.Store(title)
+	t.emit(session, "terminal:title", map[string]interface{}{
+		"id":    session.ID,
+		"title": title,
+	})
+}
+
+// Title returns the title the program running in the session last set
+func (s *TerminalSession) Title() string {
+	title, _ := s.title.Load().(string)
+	return title
+}
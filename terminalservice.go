@@ -1,31 +1,65 @@
 package main
 
 import (
+    "context"
+    "errors"
     "fmt"
     "io"
+    "net"
     "os"
     "os/exec"
     "runtime"
+    "strconv"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
 
+    "term/database"
+    "term/logging"
+
     "github.com/creack/pty"
     "github.com/wailsapp/wails/v3/pkg/application"
     "golang.org/x/crypto/ssh"
 )
 
+// termLog records tab lifecycles; tab is the terminal session ID the frontend
+// also logs with, session the tree node
+var termLog = logging.For("terminal")
+
 type TerminalService struct {
     app      *application.App
+    db       *database.DB
     sessions map[string]*TerminalSession
     mu       sync.RWMutex
     hostKeys *HostKeyService
     recorder *RecordingService
+    secrets  *SecretStore
+
+    // outputTap receives a copy of every terminal's output, e.g. for share link viewers
+    outputTap func(id string, data []byte)
+    // exitTap is called when a terminal's process has ended, e.g. to forget
+    // terminals opened through the REST API
+    exitTap func(id string)
+    // runJournal records the local shells, for the cleanup after a crash
+    runJournal *runJournal
+    // history records the commands run, for the command history
+    history *commandHistory
+    // held keeps the events of terminals opened by the backend until their tab
+    // attaches (tab ID -> *heldOutput)
+    held sync.Map
+    // broadcasts are the broadcast groups of terminals (tab ID -> *broadcastSet)
+    broadcasts sync.Map
 }
 
 type TerminalSession struct {
-	ID      string
-	PTY     *os.File
+	ID          string
+	NodeID      string // session tree node the terminal was opened from, if any
+	Name        string // tab title, for the window a session moves to
+	SessionType string
+	Host        string // machine the terminal runs on, for the command history
+	window      atomic.Value // ID of the window showing the tab; events go to it
+	PTY         *os.File
 	Cmd     *exec.Cmd
 	Running bool
 	mu      sync.Mutex
@@ -36,6 +70,17 @@ type TerminalSession struct {
 	SSHStdin   io.WriteCloser
 	IsSSH      bool
 
+	metrics     *sshConnMetrics // SSH latency and throughput, sampled until stopMetrics
+	stopMetrics context.CancelFunc
+	tmux        *tmuxPane // pane shown when the connection runs tmux in control mode
+
+	titles titleScanner // read by the output stream only
+	title  atomic.Value // last title set with OSC 0 or 2
+	tail   outputTail   // recent output, the command assistant's context
+
+	transfer     transferCounter // terminal input and output
+	sftpTransfer transferCounter // file data moved by SFTP over the connection
+
 	// Windows/Pipe fallback fields (non-PTY local sessions on Windows)
 	Stdin  io.WriteCloser
 	Stdout io.Reader
@@ -52,6 +97,9 @@ type TerminalSession struct {
 // StartSessionRequest represents the parameters for starting a new terminal session
 type StartSessionRequest struct {
 	ID          string            `json:"id"`
+	NodeID      string            `json:"nodeId"` // session tree node being opened; used for usage tracking
+	WindowID    string            `json:"windowId"` // window showing the tab; the main window when empty
+	Name        string            `json:"name"`
 	SessionType string            `json:"sessionType"` // bash, zsh, fish, pwsh, git-bash, custom
 	Config      map[string]string `json:"config"`
 	Cols        uint16            `json:"cols"`
@@ -59,25 +107,63 @@ type StartSessionRequest struct {
 }
 
 // NewTerminalService creates a new terminal service
-func NewTerminalService(app *application.App, hostKeys *HostKeyService, recorder *RecordingService) *TerminalService {
+func NewTerminalService(app *application.App, db *database.DB, hostKeys *HostKeyService, recorder *RecordingService, secrets *SecretStore) *TerminalService {
     return &TerminalService{
         app:      app,
+        db:       db,
         sessions: make(map[string]*TerminalSession),
         hostKeys: hostKeys,
         recorder: recorder,
+        secrets:  secrets,
     }
 }
 
 // StartSession starts a new terminal session
-func (t *TerminalService) StartSession(req StartSessionRequest) error {
+func (t *TerminalService) StartSession(req StartSessionRequest) (err error) {
+	// Waking a sleeping host can take a while, so do it before taking the lock
+	if err := autoWake(t.app, req.NodeID, req.SessionType, req.Config); err != nil {
+		return err
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	defer func() {
+		if err == nil {
+			session := t.sessions[req.ID]
+			session.NodeID = req.NodeID
+			session.Name = req.Name
+			session.SessionType = req.SessionType
+			session.Host = terminalHost(req.SessionType, req.Config)
+			recordSessionConnect(t.app, t.db, req.NodeID)
+			termLog.Info("session started", "tab", req.ID, "session", req.NodeID, "type", req.SessionType)
+		} else {
+			termLog.Warn("session failed to start", "tab", req.ID, "session", req.NodeID, "type", req.SessionType, "err", err)
+		}
+	}()
+
+	if req.WindowID == "" {
+		req.WindowID = mainWindowName
+	}
 
 	// Check if session already exists
 	if _, exists := t.sessions[req.ID]; exists {
 		return fmt.Errorf("session %s already exists", req.ID)
 	}
 
+	// Decrypt stored credentials only now that we are connecting
+	if t.secrets != nil {
+		config, err := t.secrets.ResolveConfig(req.Config)
+		if err != nil {
+			return err
+		}
+		req.Config = config
+	}
+
+	// Reject configs that cannot work before spawning or dialing anything
+	if errs := validateSessionConfig(req.SessionType, req.Config, true); len(errs) > 0 {
+		return &ConfigValidationError{SessionType: req.SessionType, Errors: errs}
+	}
+
 	// Handle SSH sessions separately
 	if req.SessionType == "ssh" {
 		return t.startSSHSession(req)
@@ -151,7 +237,9 @@ func (t *TerminalService) StartSession(req StartSessionRequest) error {
 			Kill:      killFn,
 			ClosePTY:  closeFn,
 		}
+		session.window.Store(req.WindowID)
 		t.sessions[req.ID] = session
+		t.journalShell(session)
 
 		// Stream from PTY (single stream)
 		go t.streamPipeOutput(session)
@@ -184,7 +272,9 @@ func (t *TerminalService) StartSession(req StartSessionRequest) error {
 			Stdout:  stdout,
 			Stderr:  stderr,
 		}
+		session.window.Store(req.WindowID)
 		t.sessions[req.ID] = session
+		t.journalShell(session)
 		go t.streamPipeOutput(session)
 		go t.monitorExit(session)
 	}
@@ -259,15 +349,43 @@ func (t *TerminalService) getShellCommand(sessionType string, config map[string]
 	}
 }
 
-// getHostKeyCallback returns the configured host key verification callback
-func (t *TerminalService) getHostKeyCallback() ssh.HostKeyCallback {
+// getHostKeyCallback returns the host key verification callback for the session's policy
+func (t *TerminalService) getHostKeyCallback(policy string) ssh.HostKeyCallback {
     if t.hostKeys != nil {
-        return t.hostKeys.HostKeyCallback()
+        return t.hostKeys.HostKeyCallbackWithPolicy(policy)
     }
     // Fallback: insecure (should not happen)
     return ssh.InsecureIgnoreHostKey()
 }
 
+// dialSSH connects to dialAddr, through the session's proxy (if any), and verifies
+// the server as addr with the host key service so advertised host key rotations are
+// learned
+func (t *TerminalService) dialSSH(network, addr, dialAddr string, config *ssh.ClientConfig, sessionConfig map[string]string) (*ssh.Client, error) {
+    proxyDial, err := sshProxyDial(sessionConfig)
+    if err != nil {
+        return nil, err
+    }
+    var conn net.Conn
+    if proxyDial == nil {
+        conn, err = net.DialTimeout(network, dialAddr, config.Timeout)
+    } else if conn, err = proxyDial(network, dialAddr); err != nil {
+        err = fmt.Errorf("proxy connection failed: %w", err)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if t.hostKeys != nil {
+        return t.hostKeys.NewSSHClient(conn, addr, config)
+    }
+    c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return ssh.NewClient(c, chans, reqs), nil
+}
+
 // findShell tries to find a shell executable from a list of paths
 func (t *TerminalService) findShell(paths []string, args []string) (string, []string, error) {
 	for _, path := range paths {
@@ -304,25 +422,26 @@ func (t *TerminalService) parseCommands(commands string) []string {
 	return result
 }
 
-// startSSHSession starts an SSH session
-func (t *TerminalService) startSSHSession(req StartSessionRequest) error {
-	// Get SSH config from request
-	host, ok := req.Config["ssh_host"]
+// connectSSH authenticates to the host described by an SSH session config, going
+// through its proxy and host key policy
+func (t *TerminalService) connectSSH(cfg map[string]string) (*ssh.Client, error) {
+	// Get SSH config from the session config
+	host, ok := cfg["ssh_host"]
 	if !ok || host == "" {
-		return fmt.Errorf("ssh_host is required for SSH sessions")
+		return nil, fmt.Errorf("ssh_host is required for SSH sessions")
 	}
 
-	port := req.Config["ssh_port"]
+	port := cfg["ssh_port"]
 	if port == "" {
 		port = "22"
 	}
 
-	username, ok := req.Config["ssh_username"]
+	username, ok := cfg["ssh_username"]
 	if !ok || username == "" {
-		return fmt.Errorf("ssh_username is required for SSH sessions")
+		return nil, fmt.Errorf("ssh_username is required for SSH sessions")
 	}
 
-	authMethod := req.Config["ssh_auth_method"]
+	authMethod := cfg["ssh_auth_method"]
 	if authMethod == "" {
 		authMethod = "password"
 	}
@@ -331,55 +450,101 @@ func (t *TerminalService) startSSHSession(req StartSessionRequest) error {
 	var auth []ssh.AuthMethod
 
 	if authMethod == "password" {
-		password, ok := req.Config["ssh_password"]
+		password, ok := cfg["ssh_password"]
 		if !ok || password == "" {
-			return fmt.Errorf("ssh_password is required for password authentication")
+			return nil, fmt.Errorf("ssh_password is required for password authentication")
 		}
 		auth = append(auth, ssh.Password(password))
 	} else if authMethod == "key" {
-		keyPath, ok := req.Config["ssh_key_path"]
-		if !ok || keyPath == "" {
-			return fmt.Errorf("ssh_key_path is required for key authentication")
-		}
+		// A credential provider hands over the key itself
+		keyData := []byte(cfg[sshPrivateKeyKey])
+		if len(keyData) == 0 {
+			keyPath, ok := cfg["ssh_key_path"]
+			if !ok || keyPath == "" {
+				return nil, fmt.Errorf("ssh_key_path is required for key authentication")
+			}
 
-		// Expand home directory if needed
-		if keyPath[0] == '~' {
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				return fmt.Errorf("failed to get home directory: %w", err)
+			// Expand home directory if needed
+			if keyPath[0] == '~' {
+				homeDir, err := os.UserHomeDir()
+				if err != nil {
+					return nil, fmt.Errorf("failed to get home directory: %w", err)
+				}
+				keyPath = homeDir + keyPath[1:]
 			}
-			keyPath = homeDir + keyPath[1:]
-		}
 
-		// Read private key file
-		keyData, err := os.ReadFile(keyPath)
-		if err != nil {
-			return fmt.Errorf("failed to read SSH key file: %w", err)
+			// Read private key file
+			var err error
+			keyData, err = os.ReadFile(keyPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read SSH key file: %w", err)
+			}
 		}
 
 		// Parse private key
 		signer, err := ssh.ParsePrivateKey(keyData)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && cfg["ssh_key_passphrase"] != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(cfg["ssh_key_passphrase"]))
+		}
 		if err != nil {
-			return fmt.Errorf("failed to parse SSH private key: %w", err)
+			return nil, fmt.Errorf("failed to parse SSH private key: %w", err)
+		}
+
+		if cert := cfg[sshCertificateKey]; cert != "" {
+			pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cert))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse SSH certificate: %w", err)
+			}
+			c, ok := pub.(*ssh.Certificate)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a certificate", sshCertificateKey)
+			}
+			if signer, err = ssh.NewCertSigner(c, signer); err != nil {
+				return nil, fmt.Errorf("certificate does not match the key: %w", err)
+			}
 		}
 
 		auth = append(auth, ssh.PublicKeys(signer))
 	} else {
-		return fmt.Errorf("unsupported SSH auth method: %s", authMethod)
+		return nil, fmt.Errorf("unsupported SSH auth method: %s", authMethod)
+	}
+
+	// Servers asking for a 2FA code after the password or key
+	totpAuth, err := sshTOTPAuth(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if totpAuth != nil {
+		auth = append(auth, totpAuth)
 	}
 
     // Create SSH client config
     config := &ssh.ClientConfig{
         User:            username,
         Auth:            auth,
-        HostKeyCallback: t.getHostKeyCallback(),
+        HostKeyCallback: t.getHostKeyCallback(cfg["ssh_host_key_policy"]),
     }
 
+	dialHost, err := knockPorts(host, cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	// Connect to SSH server
     addr := fmt.Sprintf("%s:%s", host, port)
-    client, err := ssh.Dial("tcp", addr, config)
+    client, err := t.dialSSH("tcp", addr, net.JoinHostPort(dialHost, port), config, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+	}
+	return client, nil
+}
+
+// startSSHSession starts an SSH session
+func (t *TerminalService) startSSHSession(req StartSessionRequest) error {
+	client, err := t.connectSSH(req.Config)
 	if err != nil {
-		return fmt.Errorf("failed to connect to SSH server: %w", err)
+		return err
 	}
 
 	// Create SSH session
@@ -424,6 +589,16 @@ func (t *TerminalService) startSSHSession(req StartSessionRequest) error {
 		return fmt.Errorf("failed to get stderr pipe: %w", err)
 	}
 
+	// tmux control mode shows the panes of a remote tmux session instead
+	if tmux, _ := strconv.ParseBool(req.Config[sshTmuxControlKey]); tmux {
+		if err := t.startTmuxControl(req, client, sshSession, stdin, stdout); err != nil {
+			sshSession.Close()
+			client.Close()
+			return err
+		}
+		return nil
+	}
+
 	// Start shell
 	if err := sshSession.Shell(); err != nil {
 		sshSession.Close()
@@ -439,8 +614,13 @@ func (t *TerminalService) startSSHSession(req StartSessionRequest) error {
 		SSHClient:  client,
 		SSHSession: sshSession,
 		SSHStdin:   stdin,
+		metrics:    &sshConnMetrics{},
 	}
+	metricsCtx, stopMetrics := context.WithCancel(context.Background())
+	session.stopMetrics = stopMetrics
+	go session.metrics.run(metricsCtx, client)
 
+	session.window.Store(req.WindowID)
 	t.sessions[req.ID] = session
 
 	// Start output streaming in background
@@ -489,6 +669,13 @@ func (t *TerminalService) startSSHSession(req StartSessionRequest) error {
 	return nil
 }
 
+// followOutput follows a terminal's output for its title and keeps its last
+// lines for the command assistant
+func (t *TerminalService) followOutput(session *TerminalSession, data []byte) {
+	t.trackTitle(session, data)
+	session.tail.add(data)
+}
+
 // streamOutput streams PTY output to the frontend
 func (t *TerminalService) streamOutput(session *TerminalSession) {
 	buf := make([]byte, 8192)
@@ -497,7 +684,7 @@ func (t *TerminalService) streamOutput(session *TerminalSession) {
 		if err != nil {
 			if err != io.EOF {
 				// Emit error event
-				t.app.Event.Emit("terminal:error", map[string]interface{}{
+				t.emit(session, "terminal:error", map[string]interface{}{
 					"id":    session.ID,
 					"error": err.Error(),
 				})
@@ -506,11 +693,17 @@ func (t *TerminalService) streamOutput(session *TerminalSession) {
 		}
 
 		if n > 0 {
+			session.transfer.read.Add(uint64(n))
+			if t.outputTap != nil {
+				t.outputTap(session.ID, buf[:n])
+			}
+			t.history.output(session, buf[:n])
 			// Emit data event
-			t.app.Event.Emit("terminal:data", map[string]interface{}{
+			t.emit(session, "terminal:data", map[string]interface{}{
 				"id":   session.ID,
 				"data": string(buf[:n]),
 			})
+			t.followOutput(session, buf[:n])
 		}
 	}
 }
@@ -525,7 +718,7 @@ func (t *TerminalService) streamPipeOutput(session *TerminalSession) {
 				n, err := session.Stdout.Read(buf)
 				if err != nil {
 					if err != io.EOF {
-						t.app.Event.Emit("terminal:error", map[string]interface{}{
+						t.emit(session, "terminal:error", map[string]interface{}{
 							"id":    session.ID,
 							"error": err.Error(),
 						})
@@ -533,6 +726,7 @@ func (t *TerminalService) streamPipeOutput(session *TerminalSession) {
 					break
 				}
 				if n > 0 {
+					session.transfer.read.Add(uint64(n))
 					data := string(buf[:n])
 					if runtime.GOOS == "windows" && !session.IsSSH {
 						data = normalizeWindowsOutput(data)
@@ -541,10 +735,15 @@ func (t *TerminalService) streamPipeOutput(session *TerminalSession) {
                 if t.recorder != nil {
                     t.recorder.AppendOutput(session.ID, []byte(data))
                 }
-                t.app.Event.Emit("terminal:data", map[string]interface{}{
+                if t.outputTap != nil {
+                    t.outputTap(session.ID, []byte(data))
+                }
+                t.history.output(session, []byte(data))
+                t.emit(session, "terminal:data", map[string]interface{}{
                     "id":   session.ID,
                     "data": data,
                 })
+                t.followOutput(session, []byte(data))
 				}
 			}
 		}()
@@ -558,7 +757,7 @@ func (t *TerminalService) streamPipeOutput(session *TerminalSession) {
 				n, err := session.Stderr.Read(buf)
 				if err != nil {
 					if err != io.EOF {
-						t.app.Event.Emit("terminal:error", map[string]interface{}{
+						t.emit(session, "terminal:error", map[string]interface{}{
 							"id":    session.ID,
 							"error": err.Error(),
 						})
@@ -566,11 +765,12 @@ func (t *TerminalService) streamPipeOutput(session *TerminalSession) {
 					break
 				}
 				if n > 0 {
+					session.transfer.read.Add(uint64(n))
 					data := string(buf[:n])
 					if runtime.GOOS == "windows" && !session.IsSSH {
 						data = normalizeWindowsOutput(data)
 					}
-					t.app.Event.Emit("terminal:data", map[string]interface{}{
+					t.emit(session, "terminal:data", map[string]interface{}{
 						"id":   session.ID,
 						"data": data,
 					})
@@ -589,7 +789,7 @@ func (t *TerminalService) streamSSHOutput(session *TerminalSession, stdout, stde
 			n, err := stdout.Read(buf)
 			if err != nil {
 				if err != io.EOF {
-					t.app.Event.Emit("terminal:error", map[string]interface{}{
+					t.emit(session, "terminal:error", map[string]interface{}{
 						"id":    session.ID,
 						"error": err.Error(),
 					})
@@ -598,13 +798,20 @@ func (t *TerminalService) streamSSHOutput(session *TerminalSession, stdout, stde
 			}
 
             if n > 0 {
+                session.metrics.bytesIn.Add(uint64(n))
+                session.transfer.read.Add(uint64(n))
                 if t.recorder != nil {
                     t.recorder.AppendOutput(session.ID, buf[:n])
                 }
-                t.app.Event.Emit("terminal:data", map[string]interface{}{
+                if t.outputTap != nil {
+                    t.outputTap(session.ID, buf[:n])
+                }
+                t.history.output(session, buf[:n])
+                t.emit(session, "terminal:data", map[string]interface{}{
                     "id":   session.ID,
                     "data": string(buf[:n]),
                 })
+                t.followOutput(session, buf[:n])
             }
 		}
 	}()
@@ -616,7 +823,7 @@ func (t *TerminalService) streamSSHOutput(session *TerminalSession, stdout, stde
 			n, err := stderr.Read(buf)
 			if err != nil {
 				if err != io.EOF {
-					t.app.Event.Emit("terminal:error", map[string]interface{}{
+					t.emit(session, "terminal:error", map[string]interface{}{
 						"id":    session.ID,
 						"error": err.Error(),
 					})
@@ -625,10 +832,15 @@ func (t *TerminalService) streamSSHOutput(session *TerminalSession, stdout, stde
 			}
 
             if n > 0 {
+                session.metrics.bytesIn.Add(uint64(n))
+                session.transfer.read.Add(uint64(n))
                 if t.recorder != nil {
                     t.recorder.AppendOutput(session.ID, buf[:n])
                 }
-                t.app.Event.Emit("terminal:data", map[string]interface{}{
+                if t.outputTap != nil {
+                    t.outputTap(session.ID, buf[:n])
+                }
+                t.emit(session, "terminal:data", map[string]interface{}{
                     "id":   session.ID,
                     "data": string(buf[:n]),
                 })
@@ -637,6 +849,15 @@ func (t *TerminalService) streamSSHOutput(session *TerminalSession, stdout, stde
     }()
 }
 
+// journalShell records a local shell until it exits, so that it can be ended
+// when the app crashes and leaves it running. ConPTY shells end with their
+// console and are not recorded.
+func (t *TerminalService) journalShell(session *TerminalSession) {
+	if session.Cmd != nil && session.Cmd.Process != nil {
+		t.runJournal.addShell(session.ID, session.Cmd.Process.Pid)
+	}
+}
+
 // monitorExit monitors when the process exits
 func (t *TerminalService) monitorExit(session *TerminalSession) {
 	var err error
@@ -660,15 +881,23 @@ func (t *TerminalService) monitorExit(session *TerminalSession) {
 	session.mu.Lock()
 	session.Running = false
 	session.mu.Unlock()
+	termLog.Info("session exited", "tab", session.ID, "session", session.NodeID, "code", exitCode)
+	t.runJournal.removeShell(session.ID)
+	t.history.forget(session.ID)
+	t.recordTransfer(session)
 
     // Emit exit event
-    t.app.Event.Emit("terminal:exit", map[string]interface{}{
+    t.emit(session, "terminal:exit", map[string]interface{}{
         "id":       session.ID,
         "exitCode": exitCode,
+        "transfer": t.transferStats(session),
     })
     if t.recorder != nil {
         _ = t.recorder.Stop(session.ID)
     }
+    if t.exitTap != nil {
+        t.exitTap(session.ID)
+    }
 }
 
 // monitorSSHExit monitors when the SSH session exits
@@ -691,14 +920,27 @@ func (t *TerminalService) monitorSSHExit(session *TerminalSession) {
 		session.SSHStdin.Close()
 	}
 
+	t.history.forget(session.ID)
+	session.stopMetrics()
+	summary := session.metrics.summary()
+	termLog.Info("SSH session ended", "tab", session.ID, "session", session.NodeID, "code", exitCode,
+		"peakLatencyMs", summary.PeakLatencyMs, "peakInBps", summary.PeakBytesInPerSec, "peakOutBps", summary.PeakBytesOutPerSec)
+
+    t.recordTransfer(session)
+
     // Emit exit event
-    t.app.Event.Emit("terminal:exit", map[string]interface{}{
+    t.emit(session, "terminal:exit", map[string]interface{}{
         "id":       session.ID,
         "exitCode": exitCode,
+        "ssh":      summary,
+        "transfer": t.transferStats(session),
     })
     if t.recorder != nil {
         _ = t.recorder.Stop(session.ID)
     }
+    if t.exitTap != nil {
+        t.exitTap(session.ID)
+    }
 }
 
 // WriteToSession writes data to a terminal session
@@ -717,7 +959,18 @@ func (t *TerminalService) WriteToSession(id string, data string) error {
 	if !session.Running {
 		return fmt.Errorf("session %s is not running", id)
 	}
+	t.history.input(session, data)
 
+	if session.tmux != nil {
+		if t.recorder != nil {
+			t.recorder.AppendInput(id, []byte(data))
+		}
+		if err := session.tmux.write(data); err != nil {
+			return err
+		}
+		session.transfer.written.Add(uint64(len(data)))
+		return nil
+	}
     if session.IsSSH {
         // Write to SSH session stdin
         if session.SSHStdin == nil {
@@ -726,7 +979,9 @@ func (t *TerminalService) WriteToSession(id string, data string) error {
         if t.recorder != nil {
             t.recorder.AppendInput(id, []byte(data))
         }
-        _, err := session.SSHStdin.Write([]byte(data))
+        n, err := session.SSHStdin.Write([]byte(data))
+        session.metrics.bytesOut.Add(uint64(n))
+        session.transfer.written.Add(uint64(n))
         return err
     }
 
@@ -738,14 +993,16 @@ func (t *TerminalService) WriteToSession(id string, data string) error {
         if t.recorder != nil {
             t.recorder.AppendInput(id, []byte(data))
         }
-        _, err := session.PTY.Write([]byte(data))
+        n, err := session.PTY.Write([]byte(data))
+        session.transfer.written.Add(uint64(n))
         return err
     }
     if session.Stdin != nil {
         if t.recorder != nil {
             t.recorder.AppendInput(id, []byte(data))
         }
-        _, err := session.Stdin.Write([]byte(data))
+        n, err := session.Stdin.Write([]byte(data))
+        session.transfer.written.Add(uint64(n))
         return err
     }
     return fmt.Errorf("no writer available for session %s", id)
@@ -802,6 +1059,8 @@ func (t *TerminalService) ResizeSession(id string, cols uint16, rows uint16) err
 	if !exists {
 		return fmt.Errorf("session %s not found", id)
 	}
+	// The tab of a terminal opened by the backend is showing now
+	t.release(session)
 
 	session.mu.Lock()
 	defer session.mu.Unlock()
@@ -810,6 +1069,13 @@ func (t *TerminalService) ResizeSession(id string, cols uint16, rows uint16) err
 		return fmt.Errorf("session %s is not running", id)
 	}
 
+	if session.tmux != nil {
+		err := session.tmux.resize(session, cols, rows)
+		if err == nil && t.recorder != nil {
+			t.recorder.AppendResize(id, cols, rows)
+		}
+		return err
+	}
     if session.IsSSH {
         // Send window change request for SSH session
         err := session.SSHSession.WindowChange(int(rows), int(cols))
@@ -843,18 +1109,32 @@ func (t *TerminalService) ResizeSession(id string, cols uint16, rows uint16) err
 
 // CloseSession closes a terminal session
 func (t *TerminalService) CloseSession(id string) error {
+	session, err := t.closeSession(id)
+	if err != nil {
+		return err
+	}
+	// Output read while the terminal closed, and SFTP transfers since it exited;
+	// the database write happens outside t.mu
+	t.recordTransfer(session)
+	return nil
+}
+
+func (t *TerminalService) closeSession(id string) (*TerminalSession, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	session, exists := t.sessions[id]
 	if !exists {
-		return fmt.Errorf("session %s not found", id)
+		return nil, fmt.Errorf("session %s not found", id)
 	}
 
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
-	if session.IsSSH {
+	if session.tmux != nil {
+		// The connection is shared by the tabs of a tmux session
+		session.tmux.close()
+	} else if session.IsSSH {
 		// Close SSH session
 		if session.SSHStdin != nil {
 			session.SSHStdin.Close()
@@ -889,8 +1169,48 @@ func (t *TerminalService) CloseSession(id string) error {
 
 	session.Running = false
 	delete(t.sessions, id)
+	t.held.Delete(id)
+	t.LeaveBroadcast(id)
+	termLog.Info("session closed", "tab", id, "session", session.NodeID)
 
-	return nil
+	return session, nil
+}
+
+// closeAll ends every session when the app quits. Local shells are hung up as
+// when a terminal window closes and get grace to exit before they are killed;
+// SSH connections are closed, detaching from tmux first so its panes live on.
+func (t *TerminalService) closeAll(grace time.Duration) {
+	t.mu.RLock()
+	sessions := make([]*TerminalSession, 0, len(t.sessions))
+	for _, session := range t.sessions {
+		sessions = append(sessions, session)
+	}
+	t.mu.RUnlock()
+
+	var hungUp []string
+	for _, session := range sessions {
+		if session.tmux != nil {
+			session.tmux.ctl.detach()
+		}
+		session.mu.Lock()
+		if session.Running && !session.IsSSH && session.Kill == nil && session.Cmd != nil && session.Cmd.Process != nil {
+			if hangupProcess(session.Cmd.Process) == nil {
+				hungUp = append(hungUp, session.ID)
+			}
+		}
+		session.mu.Unlock()
+	}
+	deadline := time.Now().Add(grace)
+	for _, id := range hungUp {
+		for t.IsSessionRunning(id) && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	for _, session := range sessions {
+		_ = t.CloseSession(session.ID)
+	}
+	termLog.Info("sessions closed on quit", "count", len(sessions), "hungUp", len(hungUp))
 }
 
 // IsSessionRunning checks if a session is still running
@@ -927,3 +1247,112 @@ func (t *TerminalService) GetSession(id string) *TerminalSession {
 	defer t.mu.RUnlock()
 	return t.sessions[id]
 }
+
+// emit sends a terminal event, with the ID of the window showing the tab so
+// other windows ignore it
+func (t *TerminalService) emit(session *TerminalSession, name string, data map[string]interface{}) {
+	data["windowId"] = session.Window()
+	if t.hold(session.ID, name, data) {
+		return
+	}
+	t.app.Event.Emit(name, data)
+}
+
+// Window returns the ID of the window showing the session's tab
+func (s *TerminalSession) Window() string {
+	if id, ok := s.window.Load().(string); ok {
+		return id
+	}
+	return mainWindowName
+}
+
+// WindowSession is a running session shown in a window, for the window to
+// open a tab for it
+type WindowSession struct {
+	ID          string `json:"id"`
+	NodeID      string `json:"nodeId"`
+	Name        string `json:"name"`
+	SessionType string `json:"sessionType"`
+	Title       string `json:"title"` // last title set by the program running in it
+}
+
+// MoveSession moves a running session's tab to another window. The window's
+// tab gets the session's output from now on; name is the tab title to keep,
+// e.g. after a rename. Emits terminal:moved, on which the old window drops its
+// tab and the new one opens it.
+func (t *TerminalService) MoveSession(id string, windowID string, name string) error {
+	if _, ok := t.app.Window.GetByName(windowID); !ok {
+		return fmt.Errorf("window %s not found", windowID)
+	}
+	t.mu.RLock()
+	session, exists := t.sessions[id]
+	t.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("session %s not found", id)
+	}
+	session.mu.Lock()
+	running := session.Running
+	if name != "" {
+		session.Name = name
+	}
+	session.mu.Unlock()
+	if !running {
+		return fmt.Errorf("session %s is not running", id)
+	}
+	t.moveSession(session, windowID)
+	return nil
+}
+
+func (t *TerminalService) moveSession(session *TerminalSession, windowID string) {
+	from := session.Window()
+	if from == windowID {
+		return
+	}
+	session.window.Store(windowID)
+	termLog.Info("session moved", "tab", session.ID, "session", session.NodeID, "from", from, "window", windowID)
+	session.mu.Lock()
+	name := session.Name
+	session.mu.Unlock()
+	t.app.Event.Emit("terminal:moved", map[string]interface{}{
+		"id":           session.ID,
+		"windowId":     windowID,
+		"fromWindowId": from,
+		"nodeId":       session.NodeID,
+		"name":         name,
+		"sessionType":  session.SessionType,
+		"title":        session.Title(),
+	})
+}
+
+// moveWindowSessions moves the running sessions of a closed window to another
+func (t *TerminalService) moveWindowSessions(from, to string) {
+	t.mu.RLock()
+	var moving []*TerminalSession
+	for _, session := range t.sessions {
+		if session.Window() == from {
+			moving = append(moving, session)
+		}
+	}
+	t.mu.RUnlock()
+	for _, session := range moving {
+		t.moveSession(session, to)
+	}
+}
+
+// GetWindowSessions returns the running sessions shown in a window. A window
+// opens tabs for the ones it has none for when it loads, e.g. a new window a
+// tab was moved to before its frontend was listening.
+func (t *TerminalService) GetWindowSessions(windowID string) []WindowSession {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	list := []WindowSession{}
+	for _, session := range t.sessions {
+		session.mu.Lock()
+		running, name := session.Running, session.Name
+		session.mu.Unlock()
+		if running && session.Window() == windowID {
+			list = append(list, WindowSession{ID: session.ID, NodeID: session.NodeID, Name: name, SessionType: session.SessionType, Title: session.Title()})
+		}
+	}
+	return list
+}
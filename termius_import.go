@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// termiusExport is the JSON shape of a Termius data export; hosts reference groups by id
+type termiusExport struct {
+	Groups []termiusGroup `json:"groups"`
+	Hosts  []termiusHost  `json:"hosts"`
+}
+
+type termiusGroup struct {
+	ID          json.RawMessage `json:"id"`
+	Label       string          `json:"label"`
+	ParentGroup json.RawMessage `json:"parent_group"`
+}
+
+type termiusHost struct {
+	Label     string            `json:"label"`
+	Address   string            `json:"address"`
+	Group     json.RawMessage   `json:"group"`
+	Port      json.Number       `json:"port"`
+	Username  string            `json:"username"`
+	Password  string            `json:"password"`
+	SSHConfig *termiusSSHConfig `json:"ssh_config"`
+	Identity  *termiusIdentity  `json:"identity"`
+}
+
+type termiusSSHConfig struct {
+	Port     json.Number      `json:"port"`
+	Identity *termiusIdentity `json:"identity"`
+}
+
+type termiusIdentity struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ImportTermius creates SSH sessions from a Termius export: either the JSON data export
+// (groups become folders) or the hosts CSV ("Groups,Label,Tags,Hostname/IP,Protocol,Port,...").
+// Imported hosts are placed in a "Termius" folder.
+func (s *SessionService) ImportTermius(opts ConnectionImportOptions) (*SessionImportResult, error) {
+	data, err := os.ReadFile(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Termius export: %w", err)
+	}
+
+	result := &SessionImportResult{}
+	var nodes []SessionExportNode
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		nodes, err = termiusJSONNodes(trimmed, result)
+	} else {
+		nodes, err = termiusCSVNodes(data, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = s.importIntoFolder("Termius", nodes, opts, result)
+	return result, err
+}
+
+func termiusJSONNodes(data []byte, result *SessionImportResult) ([]SessionExportNode, error) {
+	var export termiusExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("failed to parse Termius export: %w", err)
+	}
+
+	groups := make(map[string]termiusGroup, len(export.Groups))
+	for _, g := range export.Groups {
+		groups[termiusRef(g.ID)] = g
+	}
+	// groupPath walks parent groups up to the root, guarding against cycles
+	groupPath := func(id string) []string {
+		var path []string
+		seen := map[string]bool{}
+		for id != "" && !seen[id] {
+			seen[id] = true
+			g, ok := groups[id]
+			if !ok {
+				break
+			}
+			path = append([]string{g.Label}, path...)
+			id = termiusRef(g.ParentGroup)
+		}
+		return path
+	}
+
+	var nodes []SessionExportNode
+	for _, h := range export.Hosts {
+		port := h.Port.String()
+		user, password := h.Username, h.Password
+		identity := h.Identity
+		if h.SSHConfig != nil {
+			if p := h.SSHConfig.Port.String(); p != "" {
+				port = p
+			}
+			if h.SSHConfig.Identity != nil {
+				identity = h.SSHConfig.Identity
+			}
+		}
+		if identity != nil {
+			if identity.Username != "" {
+				user = identity.Username
+			}
+			if identity.Password != "" {
+				password = identity.Password
+			}
+		}
+		node, ok := termiusSessionNode(h.Label, h.Address, port, user, password)
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		nodes = insertAtPath(nodes, groupPath(termiusRef(h.Group)), node)
+	}
+	return nodes, nil
+}
+
+func termiusCSVNodes(data []byte, result *SessionImportResult) ([]SessionExportNode, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Termius export: %w", err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := col["hostname/ip"]; !ok {
+		return nil, fmt.Errorf("not a Termius hosts export (missing Hostname/IP column)")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := col[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var nodes []SessionExportNode
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Termius export: %w", err)
+		}
+		if proto := strings.ToLower(field(record, "protocol")); proto != "" && proto != "ssh" {
+			result.Skipped++
+			continue
+		}
+		node, ok := termiusSessionNode(field(record, "label"), field(record, "hostname/ip"), field(record, "port"),
+			field(record, "username"), field(record, "password"))
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		var path []string
+		if groups := field(record, "groups"); groups != "" {
+			path = strings.Split(groups, "/")
+		}
+		nodes = insertAtPath(nodes, path, node)
+	}
+	return nodes, nil
+}
+
+func termiusSessionNode(label, address, port, user, password string) (SessionExportNode, bool) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return SessionExportNode{}, false
+	}
+	if label == "" {
+		label = address
+	}
+	node := SessionExportNode{Name: label, Type: "session", SessionType: "ssh", Config: map[string]string{
+		"ssh_host":        address,
+		"ssh_port":        orDefault(port, "22"),
+		"ssh_auth_method": "password",
+	}}
+	if user != "" {
+		node.Config["ssh_username"] = user
+	}
+	if password != "" {
+		node.Config["ssh_password"] = password
+	}
+	return node, true
+}
+
+// termiusRef turns a numeric or string id reference into a map key ("" for null)
+func termiusRef(raw json.RawMessage) string {
+	ref := strings.Trim(strings.TrimSpace(string(raw)), `"`)
+	if ref == "null" {
+		return ""
+	}
+	return ref
+}
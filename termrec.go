@@ -3,13 +3,16 @@ package main
 import (
     "encoding/binary"
     "io"
+    "sync"
     "time"
 )
 
 var termrecMagic = []byte{'T','E','R','M','R','E','C',1}
 
-// TermrecWriter writes a binary terminal recording stream to w
+// TermrecWriter writes a binary terminal recording stream to w. Output, input and
+// markers come from different goroutines, so events are written under mu.
 type TermrecWriter struct {
+    mu     sync.Mutex
     w      io.Writer
     start  time.Time
     lastTs time.Time
@@ -44,9 +47,11 @@ func NewTermrecWriter(w io.Writer, cols, rows uint16, captureInput bool) (*Termr
     return &TermrecWriter{w: w, start: now, lastTs: now}, nil
 }
 
-// Event format: varint(delta_ns), 1 byte type ('O','I','R'), varint len, payload
+// Event format: varint(delta_ns), 1 byte type ('O','I','R','M'), varint len, payload
 
 func (tw *TermrecWriter) writeEvent(t byte, payload []byte) error {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
     now := time.Now()
     delta := now.Sub(tw.lastTs)
     tw.lastTs = now
@@ -61,6 +66,9 @@ func (tw *TermrecWriter) writeEvent(t byte, payload []byte) error {
 
 func (tw *TermrecWriter) WriteOutput(p []byte) error { return tw.writeEvent('O', p) }
 func (tw *TermrecWriter) WriteInput(p []byte) error  { return tw.writeEvent('I', p) }
+// WriteMarker adds a labelled point in time, e.g. a stats alert; players that
+// do not know markers skip them
+func (tw *TermrecWriter) WriteMarker(label string) error { return tw.writeEvent('M', []byte(label)) }
 func (tw *TermrecWriter) WriteResize(cols, rows uint16) error {
     var buf [4]byte
     binary.LittleEndian.PutUint16(buf[:2], cols)
@@ -40,10 +40,11 @@ func (tr *TermrecReader) ReadHeader() (*TermrecHeaderRead, error) {
 func (tr *TermrecReader) ReadEvent(buf []byte) (uint64, byte, []byte, error) {
     delta, err := readUvarint(tr.r)
     if err != nil { return 0, 0, nil, err }
+    // Past the first byte, the end of the stream means a cut-off event
     tb := make([]byte, 1)
-    if _, err := io.ReadFull(tr.r, tb); err != nil { return 0, 0, nil, err }
+    if _, err := io.ReadFull(tr.r, tb); err != nil { return 0, 0, nil, unexpectedEOF(err) }
     ln, err := readUvarint(tr.r)
-    if err != nil { return 0, 0, nil, err }
+    if err != nil { return 0, 0, nil, unexpectedEOF(err) }
     if int(ln) > cap(buf) {
         buf = make([]byte, ln)
     } else {
@@ -58,7 +59,10 @@ func readUvarint(r io.Reader) (uint64, error) {
     var s uint
     for i := 0; i < 10; i++ {
         var b [1]byte
-        if _, err := r.Read(b[:]); err != nil { return 0, err }
+        if _, err := r.Read(b[:]); err != nil {
+            if i > 0 { err = unexpectedEOF(err) }
+            return 0, err
+        }
         if b[0] < 0x80 {
             if i == 9 && b[0] > 1 { return 0, fmt.Errorf("varint overflow") }
             return x | uint64(b[0])<<s, nil
@@ -69,3 +73,8 @@ func readUvarint(r io.Reader) (uint64, error) {
     return 0, fmt.Errorf("varint too long")
 }
 
+
+func unexpectedEOF(err error) error {
+    if err == io.EOF { return io.ErrUnexpectedEOF }
+    return err
+}
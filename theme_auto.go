@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"github.com/wailsapp/wails/v3/pkg/events"
+)
+
+// themeModeAuto as the active theme follows the OS appearance
+const themeModeAuto = "auto"
+
+// Themes used in auto mode
+const (
+	settingThemeAutoLight = "theme_auto_light"
+	settingThemeAutoDark  = "theme_auto_dark"
+)
+
+// AutoThemes are the themes auto mode switches between
+type AutoThemes struct {
+	Light string `json:"light"`
+	Dark  string `json:"dark"`
+}
+
+// SetApp sets the Wails application instance and starts following OS appearance
+// changes for auto mode, edits to the active theme's file and writes to the theme
+// settings, whether by SetActiveTheme, a settings profile or an import
+func (s *ThemeService) SetApp(app *application.App) {
+	s.app = app
+	go s.watchThemes()
+	s.settingsSvc.OnSettingChanged(func(key string) {
+		switch key {
+		case "active_theme":
+			s.emitThemeChanged()
+		case settingThemeAutoLight, settingThemeAutoDark:
+			if s.GetActiveThemeMode() == themeModeAuto {
+				s.emitThemeChanged()
+			}
+		}
+	})
+	app.Event.OnApplicationEvent(events.Common.ThemeChanged, func(*application.ApplicationEvent) {
+		if s.GetActiveThemeMode() == themeModeAuto {
+			s.emitThemeChanged()
+		}
+	})
+}
+
+// GetActiveThemeMode returns the active theme setting: a theme ID, or "auto"
+func (s *ThemeService) GetActiveThemeMode() string {
+	return s.settingsSvc.GetString("active_theme")
+}
+
+// GetAutoThemes returns the themes used for light and dark OS appearance
+func (s *ThemeService) GetAutoThemes() AutoThemes {
+	return AutoThemes{
+		Light: s.settingsSvc.GetString(settingThemeAutoLight),
+		Dark:  s.settingsSvc.GetString(settingThemeAutoDark),
+	}
+}
+
+// SetAutoThemes sets the themes used for light and dark OS appearance
+func (s *ThemeService) SetAutoThemes(themes AutoThemes) error {
+	for _, id := range []string{themes.Light, themes.Dark} {
+		if _, err := s.GetTheme(id); err != nil {
+			return err
+		}
+	}
+	if err := s.settingsSvc.Set(settingThemeAutoLight, themes.Light); err != nil {
+		return err
+	}
+	return s.settingsSvc.Set(settingThemeAutoDark, themes.Dark)
+}
+
+// autoThemeID picks the auto mode theme for the current OS appearance
+func (s *ThemeService) autoThemeID() string {
+	themes := s.GetAutoThemes()
+	if s.app != nil && !s.app.Env.IsDarkMode() {
+		return themes.Light
+	}
+	return themes.Dark
+}
+
+// emitThemeChanged sends theme:changed with the theme now in effect, so open
+// windows and terminals restyle
+func (s *ThemeService) emitThemeChanged() {
+	if s.app == nil {
+		return
+	}
+	theme, err := s.GetActiveTheme()
+	if err != nil {
+		log.Printf("Failed to resolve active theme: %v", err)
+		return
+	}
+	s.app.Event.Emit("theme:changed", *theme)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// base16Scheme is a base16 or base24 scheme, in either the original format with
+// top-level base00-base0F keys or the current one with a palette map
+type base16Scheme struct {
+	Scheme  string            `yaml:"scheme"` // original format
+	Name    string            `yaml:"name"`
+	System  string            `yaml:"system"`  // "base16" or "base24"
+	Variant string            `yaml:"variant"` // "dark" or "light", when given
+	Palette map[string]string `yaml:"palette"`
+	Flat    map[string]any    `yaml:",inline"`
+}
+
+// color returns base<key> as "#rrggbb", or "" when the scheme lacks it
+func (b base16Scheme) color(key string) string {
+	var v string
+	for _, k := range []string{"base" + key, "base" + strings.ToLower(key)} {
+		if v = b.Palette[k]; v != "" {
+			break
+		}
+		switch f := b.Flat[k].(type) {
+		case string:
+			v = f
+		case int:
+			// An unquoted all-digit color such as 282828
+			v = fmt.Sprintf("%06d", f)
+		}
+		if v != "" {
+			break
+		}
+	}
+	c, ok := parseHexColor(v)
+	if !ok {
+		return ""
+	}
+	return c.hex()
+}
+
+// terminalColors applies the base16 terminal mapping; base24 schemes have their
+// own bright colors in base12-base17
+func (b base16Scheme) terminalColors() TerminalColors {
+	c := b.color
+	t := TerminalColors{
+		Background:          c("00"),
+		Foreground:          c("05"),
+		Cursor:              c("05"),
+		SelectionBackground: c("02"),
+		Black:               c("00"),
+		Red:                 c("08"),
+		Green:               c("0B"),
+		Yellow:              c("0A"),
+		Blue:                c("0D"),
+		Magenta:             c("0E"),
+		Cyan:                c("0C"),
+		White:               c("05"),
+		BrightBlack:         c("03"),
+		BrightRed:           c("08"),
+		BrightGreen:         c("0B"),
+		BrightYellow:        c("0A"),
+		BrightBlue:          c("0D"),
+		BrightMagenta:       c("0E"),
+		BrightCyan:          c("0C"),
+		BrightWhite:         c("07"),
+	}
+	for key, field := range map[string]*string{
+		"12": &t.BrightRed, "13": &t.BrightYellow, "14": &t.BrightGreen,
+		"15": &t.BrightCyan, "16": &t.BrightBlue, "17": &t.BrightMagenta,
+	} {
+		if v := c(key); v != "" {
+			*field = v
+		}
+	}
+	return t
+}
+
+// themeColors applies the base16 styling guidelines to the UI: base00-base03 are
+// the background shades, base03-base05 the text and base08-base0F the accents
+func (b base16Scheme) themeColors() ThemeColors {
+	c := b.color
+	var tc ThemeColors
+	tc.Bg.Primary = c("00")
+	tc.Bg.Secondary = c("01")
+	if darker := c("10"); darker != "" {
+		// base24 has a darker background for side panels
+		tc.Bg.Secondary = darker
+	}
+	tc.Bg.Tertiary = c("02")
+	tc.Bg.Quaternary = c("03")
+	tc.Text.Primary = c("05")
+	tc.Text.Secondary = c("04")
+	tc.Text.Muted = c("03")
+	tc.Accent.Red = c("08")
+	tc.Accent.Orange = c("09")
+	tc.Accent.Yellow = c("0A")
+	tc.Accent.Green = c("0B")
+	tc.Accent.Cyan = c("0C")
+	tc.Accent.Blue = c("0D")
+	tc.Accent.Purple = c("0E")
+	tc.Accent.Pink = c("0F")
+	tc.Border = c("02")
+	tc.Hover = c("02")
+	tc.Active = c("03")
+	tc.Selection = c("02")
+	return tc
+}
+
+// ImportBase16 converts a base16 or base24 YAML scheme into a new user theme,
+// generating both the terminal palette and the UI colors from the base colors
+func (s *ThemeService) ImportBase16(sourcePath string) (*Theme, error) {
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read theme file: %w", err)
+	}
+	var scheme base16Scheme
+	if err := yaml.Unmarshal(data, &scheme); err != nil {
+		return nil, fmt.Errorf("failed to parse base16 scheme: %w", err)
+	}
+	for i := 0; i < 16; i++ {
+		key := fmt.Sprintf("%02X", i)
+		if scheme.color(key) == "" {
+			return nil, fmt.Errorf("invalid base16 scheme: base%s is missing", key)
+		}
+	}
+
+	name := scheme.Name
+	if name == "" {
+		name = scheme.Scheme
+	}
+	if name == "" {
+		name = themeNameFromPath(sourcePath)
+	}
+	term := scheme.terminalColors()
+	theme := Theme{
+		Name:     name,
+		ID:       themeIDFromName(name),
+		Type:     themeTypeOf(term),
+		Colors:   scheme.themeColors(),
+		Terminal: term,
+	}
+	if v := strings.ToLower(scheme.Variant); v == "dark" || v == "light" {
+		theme.Type = v
+	}
+	if err := s.installTheme(theme); err != nil {
+		return nil, err
+	}
+	return &theme, nil
+}
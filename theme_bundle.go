@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ThemeProfile is the rest of a terminal's look, carried by theme bundles. Unset
+// fields leave the importing machine's settings alone.
+type ThemeProfile struct {
+	FontFamily  string `json:"fontFamily,omitempty"`
+	FontSize    int    `json:"fontSize,omitempty"`
+	CursorStyle string `json:"cursorStyle,omitempty"` // block, bar or underline
+	Padding     *int   `json:"padding,omitempty"`     // pixels around the terminal text
+}
+
+// ThemeBundle is a theme file with an optional profile. Since the theme fields
+// stay at the top level, a bundle is still a valid theme file.
+type ThemeBundle struct {
+	Theme
+	Profile *ThemeProfile `json:"profile,omitempty"`
+}
+
+// currentThemeProfile reads the profile settings in effect
+func (s *ThemeService) currentThemeProfile() *ThemeProfile {
+	padding := s.settingsSvc.GetInt("terminal_padding")
+	return &ThemeProfile{
+		FontFamily:  s.settingsSvc.GetString("font_family"),
+		FontSize:    s.settingsSvc.GetInt("font_size"),
+		CursorStyle: s.settingsSvc.GetString("cursor_style"),
+		Padding:     &padding,
+	}
+}
+
+// applyThemeProfile saves the settings a profile carries, validating them all
+// before writing any
+func (s *ThemeService) applyThemeProfile(p *ThemeProfile) error {
+	values := make(map[string]string)
+	if p.FontFamily != "" {
+		values["font_family"] = p.FontFamily
+	}
+	if p.FontSize != 0 {
+		values["font_size"] = strconv.Itoa(p.FontSize)
+	}
+	if p.CursorStyle != "" {
+		values["cursor_style"] = p.CursorStyle
+	}
+	if p.Padding != nil {
+		values["terminal_padding"] = strconv.Itoa(*p.Padding)
+	}
+	for key, value := range values {
+		if err := validateSetting(settingDefs[key], value); err != nil {
+			return fmt.Errorf("invalid bundle: %w", err)
+		}
+	}
+	for key, value := range values {
+		if err := s.settingsSvc.Set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportThemeBundle exports a theme together with the current font, cursor style
+// and padding, so a complete look can be moved to another machine as one file
+func (s *ThemeService) ExportThemeBundle(id string, destPath string) error {
+	theme, err := s.GetTheme(id)
+	if err != nil {
+		return err
+	}
+	theme.Warnings = nil
+
+	bundle := ThemeBundle{Theme: *theme, Profile: s.currentThemeProfile()}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal theme: %w", err)
+	}
+	if err := os.WriteFile(destPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write theme file: %w", err)
+	}
+	return nil
+}
+
+// ImportThemeBundle imports a bundle's theme, applies its profile settings and
+// makes the theme active. A theme already present with the same ID and name is
+// reused, so a bundle can be applied again after its theme was installed.
+func (s *ThemeService) ImportThemeBundle(sourcePath string) (*Theme, error) {
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read theme file: %w", err)
+	}
+	var bundle ThemeBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse theme: %w", err)
+	}
+	theme := bundle.Theme
+	if theme.ID == "" || theme.Name == "" {
+		return nil, fmt.Errorf("invalid theme: missing ID or name")
+	}
+	if err := validateThemeID(theme.ID); err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.GetTheme(theme.ID); err != nil || existing.Name != theme.Name {
+		if err := s.checkThemeUnique(theme); err != nil {
+			return nil, err
+		}
+		completeTheme(&theme)
+		if err := s.writeUserTheme(theme); err != nil {
+			return nil, err
+		}
+	}
+	if bundle.Profile != nil {
+		if err := s.applyThemeProfile(bundle.Profile); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.SetActiveTheme(theme.ID); err != nil {
+		return nil, err
+	}
+	return s.GetTheme(theme.ID)
+}
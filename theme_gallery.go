@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Theme gallery settings
+const (
+	settingThemeGalleryURL  = "theme_gallery_url"
+	defaultThemeGalleryURL  = "https://raw.githubusercontent.com/Gogh-Co/Gogh/master/data/themes.json"
+	themeGalleryCacheFile   = "theme-gallery.json"
+	themeGalleryMaxAge      = 24 * time.Hour // the cached index is refreshed after this
+	themeGalleryHTTPTimeout = 30 * time.Second
+	themeGalleryMaxSize     = 16 << 20
+)
+
+// goghTheme is one entry of a Gogh-format theme index: color_01-color_16 are the
+// ANSI colors in order
+type goghTheme struct {
+	Name       string `json:"name"`
+	Background string `json:"background"`
+	Foreground string `json:"foreground"`
+	Cursor     string `json:"cursor"`
+	Color01    string `json:"color_01"`
+	Color02    string `json:"color_02"`
+	Color03    string `json:"color_03"`
+	Color04    string `json:"color_04"`
+	Color05    string `json:"color_05"`
+	Color06    string `json:"color_06"`
+	Color07    string `json:"color_07"`
+	Color08    string `json:"color_08"`
+	Color09    string `json:"color_09"`
+	Color10    string `json:"color_10"`
+	Color11    string `json:"color_11"`
+	Color12    string `json:"color_12"`
+	Color13    string `json:"color_13"`
+	Color14    string `json:"color_14"`
+	Color15    string `json:"color_15"`
+	Color16    string `json:"color_16"`
+}
+
+func (g goghTheme) terminalColors() TerminalColors {
+	return TerminalColors{
+		Background:    g.Background,
+		Foreground:    g.Foreground,
+		Cursor:        g.Cursor,
+		Black:         g.Color01,
+		Red:           g.Color02,
+		Green:         g.Color03,
+		Yellow:        g.Color04,
+		Blue:          g.Color05,
+		Magenta:       g.Color06,
+		Cyan:          g.Color07,
+		White:         g.Color08,
+		BrightBlack:   g.Color09,
+		BrightRed:     g.Color10,
+		BrightGreen:   g.Color11,
+		BrightYellow:  g.Color12,
+		BrightBlue:    g.Color13,
+		BrightMagenta: g.Color14,
+		BrightCyan:    g.Color15,
+		BrightWhite:   g.Color16,
+	}
+}
+
+// GalleryTheme is a theme offered by the gallery; its terminal palette doubles
+// as the preview
+type GalleryTheme struct {
+	Name      string         `json:"name"`
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Terminal  TerminalColors `json:"terminal"`
+	Installed bool           `json:"installed"`
+}
+
+// ThemeGallery is the list of themes available to install
+type ThemeGallery struct {
+	Source    string         `json:"source"`
+	FetchedAt time.Time      `json:"fetchedAt"`
+	Offline   bool           `json:"offline"`         // the index could not be fetched, so the cached copy is shown
+	Error     string         `json:"error,omitempty"` // why it could not be fetched
+	Themes    []GalleryTheme `json:"themes"`
+}
+
+// themeGalleryCache is the last index fetched, kept so the gallery works offline
+type themeGalleryCache struct {
+	URL       string          `json:"url"`
+	FetchedAt time.Time       `json:"fetchedAt"`
+	Index     json.RawMessage `json:"index"`
+}
+
+// GetThemeGallery lists the themes of the gallery index (theme_gallery_url, the
+// Gogh collection by default). The index is cached for a day; refresh fetches it
+// again, and the cached copy is used whenever the download fails.
+func (s *ThemeService) GetThemeGallery(refresh bool) (*ThemeGallery, error) {
+	url := s.settingsSvc.GetString(settingThemeGalleryURL)
+
+	cachePath := filepath.Join(filepath.Dir(s.userThemePath), themeGalleryCacheFile)
+	var cache themeGalleryCache
+	if data, err := os.ReadFile(cachePath); err == nil {
+		if json.Unmarshal(data, &cache) != nil || cache.URL != url {
+			cache = themeGalleryCache{}
+		}
+	}
+
+	gallery := &ThemeGallery{Source: url}
+	if refresh || cache.Index == nil || time.Since(cache.FetchedAt) > themeGalleryMaxAge {
+		index, err := s.fetchThemeGalleryIndex(url)
+		switch {
+		case err == nil:
+			cache = themeGalleryCache{URL: url, FetchedAt: time.Now(), Index: index}
+			if data, err := json.Marshal(cache); err == nil {
+				_ = os.WriteFile(cachePath, data, 0644)
+			}
+		case cache.Index == nil:
+			return nil, fmt.Errorf("failed to fetch theme gallery: %w", err)
+		default:
+			gallery.Offline, gallery.Error = true, err.Error()
+		}
+	}
+	gallery.FetchedAt = cache.FetchedAt
+
+	var entries []goghTheme
+	if err := json.Unmarshal(cache.Index, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse theme gallery: %w", err)
+	}
+	installed := make(map[string]bool)
+	if themes, err := s.GetAllThemes(); err == nil {
+		for _, t := range themes {
+			installed[strings.ToLower(t.ID)] = true
+		}
+	}
+	for _, e := range entries {
+		theme, err := newThemeFromTerminal(e.Name, e.terminalColors())
+		if err != nil || e.Name == "" {
+			continue
+		}
+		gallery.Themes = append(gallery.Themes, GalleryTheme{
+			Name:      theme.Name,
+			ID:        theme.ID,
+			Type:      theme.Type,
+			Terminal:  theme.Terminal,
+			Installed: installed[theme.ID],
+		})
+	}
+	sort.Slice(gallery.Themes, func(i, j int) bool {
+		return strings.ToLower(gallery.Themes[i].Name) < strings.ToLower(gallery.Themes[j].Name)
+	})
+	return gallery, nil
+}
+
+// InstallGalleryTheme installs a gallery theme, found by ID, as a user theme
+func (s *ThemeService) InstallGalleryTheme(id string) (*Theme, error) {
+	gallery, err := s.GetThemeGallery(false)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gallery.Themes {
+		if g.ID != id {
+			continue
+		}
+		theme, err := newThemeFromTerminal(g.Name, g.Terminal)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.installTheme(theme); err != nil {
+			return nil, err
+		}
+		return &theme, nil
+	}
+	return nil, fmt.Errorf("theme not found in gallery: %s", id)
+}
+
+// fetchThemeGalleryIndex downloads the index JSON
+func (s *ThemeService) fetchThemeGalleryIndex(url string) ([]byte, error) {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, themeGalleryHTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching theme gallery failed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, themeGalleryMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("theme gallery index is not valid JSON")
+	}
+	return data, nil
+}
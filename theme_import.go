@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rgbColor is a color with 0-1 components, for deriving UI colors from a palette
+type rgbColor struct {
+	r, g, b float64
+}
+
+// parseHexColor reads "#rrggbb", "#rgb" or the same without '#'
+func parseHexColor(s string) (rgbColor, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return rgbColor{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return rgbColor{}, false
+	}
+	return rgbColor{float64(v>>16&0xff) / 255, float64(v>>8&0xff) / 255, float64(v&0xff) / 255}, true
+}
+
+// hex formats c as "#rrggbb"
+func (c rgbColor) hex() string {
+	channel := func(v float64) int { return int(math.Round(math.Max(0, math.Min(1, v)) * 255)) }
+	return fmt.Sprintf("#%02x%02x%02x", channel(c.r), channel(c.g), channel(c.b))
+}
+
+// mix blends c toward o by t (0 is c, 1 is o)
+func (c rgbColor) mix(o rgbColor, t float64) rgbColor {
+	return rgbColor{c.r + (o.r-c.r)*t, c.g + (o.g-c.g)*t, c.b + (o.b-c.b)*t}
+}
+
+// luminance is the relative luminance of c
+func (c rgbColor) luminance() float64 {
+	linear := func(v float64) float64 {
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(c.r) + 0.7152*linear(c.g) + 0.0722*linear(c.b)
+}
+
+// mixHex blends two hex colors, returning a when either cannot be read
+func mixHex(a, b string, t float64) string {
+	ca, ok := parseHexColor(a)
+	if !ok {
+		return a
+	}
+	cb, ok := parseHexColor(b)
+	if !ok {
+		return a
+	}
+	return ca.mix(cb, t).hex()
+}
+
+// completeTerminalColors fills the colors schemes commonly leave out: the cursor
+// follows the foreground, bright colors their normal ones
+func completeTerminalColors(t *TerminalColors) {
+	orDefault := func(v *string, def string) {
+		if *v == "" {
+			*v = def
+		}
+	}
+	orDefault(&t.Cursor, t.Foreground)
+	orDefault(&t.SelectionBackground, mixHex(t.Background, t.Foreground, 0.25))
+	orDefault(&t.BrightBlack, mixHex(t.Black, t.White, 0.4))
+	orDefault(&t.BrightRed, t.Red)
+	orDefault(&t.BrightGreen, t.Green)
+	orDefault(&t.BrightYellow, t.Yellow)
+	orDefault(&t.BrightBlue, t.Blue)
+	orDefault(&t.BrightMagenta, t.Magenta)
+	orDefault(&t.BrightCyan, t.Cyan)
+	orDefault(&t.BrightWhite, t.White)
+}
+
+// deriveThemeColors builds the UI colors from a terminal palette: surfaces step from
+// the terminal background toward its foreground, accents are the ANSI colors
+func deriveThemeColors(t TerminalColors) ThemeColors {
+	var c ThemeColors
+	bg, fg := t.Background, t.Foreground
+	c.Bg.Primary = bg
+	c.Bg.Secondary = mixHex(bg, "#000000", 0.25)
+	if themeTypeOf(t) == "light" {
+		c.Bg.Secondary = mixHex(bg, fg, 0.04)
+	}
+	c.Bg.Tertiary = mixHex(bg, fg, 0.12)
+	c.Bg.Quaternary = mixHex(bg, fg, 0.22)
+	c.Text.Primary = fg
+	c.Text.Secondary = mixHex(fg, bg, 0.2)
+	c.Text.Muted = mixHex(fg, bg, 0.4)
+	c.Accent.Blue = t.Blue
+	c.Accent.Green = t.Green
+	c.Accent.Red = t.Red
+	c.Accent.Yellow = t.Yellow
+	c.Accent.Purple = t.Magenta
+	c.Accent.Pink = t.BrightMagenta
+	c.Accent.Cyan = t.Cyan
+	c.Accent.Orange = mixHex(t.Red, t.Yellow, 0.5)
+	c.Border = c.Bg.Quaternary
+	c.Hover = c.Bg.Quaternary
+	c.Active = mixHex(bg, fg, 0.32)
+	c.Selection = t.SelectionBackground
+	return c
+}
+
+// themeTypeOf is "light" when the terminal background is light, else "dark"
+func themeTypeOf(t TerminalColors) string {
+	if bg, ok := parseHexColor(t.Background); ok && bg.luminance() > 0.4 {
+		return "light"
+	}
+	return "dark"
+}
+
+// newThemeFromTerminal makes a complete theme from an imported terminal palette
+func newThemeFromTerminal(name string, t TerminalColors) (Theme, error) {
+	if _, ok := parseHexColor(t.Background); !ok {
+		return Theme{}, fmt.Errorf("scheme has no background color")
+	}
+	if _, ok := parseHexColor(t.Foreground); !ok {
+		return Theme{}, fmt.Errorf("scheme has no foreground color")
+	}
+	completeTerminalColors(&t)
+	theme := Theme{
+		Name:     name,
+		ID:       themeIDFromName(name),
+		Type:     themeTypeOf(t),
+		Colors:   deriveThemeColors(t),
+		Terminal: t,
+	}
+	completeTheme(&theme)
+	return theme, nil
+}
+
+// themeIDFromName turns a display name into a theme ID such as "solarized-dark"
+func themeIDFromName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "imported"
+	}
+	return b.String()
+}
+
+// themeNameFromPath names an imported scheme after its file, e.g. "Solarized Dark"
+// for "Solarized Dark.itermcolors"
+func themeNameFromPath(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSpace(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// installTheme writes a converted theme to the user theme directory
+func (s *ThemeService) installTheme(theme Theme) error {
+	if err := s.checkThemeUnique(theme); err != nil {
+		return err
+	}
+	return s.writeUserTheme(theme)
+}
+
+// writeUserTheme writes theme to <id>.json in the user theme directory
+func (s *ThemeService) writeUserTheme(theme Theme) error {
+	if err := validateThemeID(theme.ID); err != nil {
+		return err
+	}
+	theme.Warnings = nil
+	data, err := json.MarshalIndent(theme, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal theme: %w", err)
+	}
+	destPath := filepath.Join(s.userThemePath, theme.ID+".json")
+	if err := os.WriteFile(destPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save theme: %w", err)
+	}
+	return nil
+}
+
+// ImportThemeFile imports a theme file of any supported format, telling them
+// apart by content: Term theme JSON, iTerm2 .itermcolors, Windows Terminal
+// settings or schemes, VS Code color themes and base16/base24 YAML
+func (s *ThemeService) ImportThemeFile(sourcePath string) ([]Theme, error) {
+	single := func(theme *Theme, err error) ([]Theme, error) {
+		if err != nil {
+			return nil, err
+		}
+		return []Theme{*theme}, nil
+	}
+	switch strings.ToLower(filepath.Ext(sourcePath)) {
+	case ".itermcolors":
+		return single(s.ImportITerm(sourcePath))
+	case ".yaml", ".yml":
+		return single(s.ImportBase16(sourcePath))
+	}
+
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read theme file: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(stripJSONC(data), &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse theme: %w", err)
+	}
+	_, hasSchemes := fields["schemes"]
+	_, hasPurple := fields["purple"]
+	if hasSchemes || hasPurple {
+		return s.ImportWindowsTerminal(sourcePath)
+	}
+	// VS Code colors are a flat map of dotted keys, a Term theme's are nested
+	var flat map[string]string
+	if raw, ok := fields["colors"]; ok && json.Unmarshal(raw, &flat) == nil {
+		return single(s.ImportVSCode(sourcePath))
+	}
+	if _, ok := fields["profile"]; ok {
+		return single(s.ImportThemeBundle(sourcePath))
+	}
+
+	if err := s.ImportTheme(sourcePath); err != nil {
+		return nil, err
+	}
+	var theme Theme
+	_ = json.Unmarshal(data, &theme)
+	return single(s.GetTheme(theme.ID))
+}
+
+// stripJSONC removes the comments and trailing commas that Windows Terminal and
+// VS Code accept in their JSON files
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		case c == '}' || c == ']':
+			// Drop a comma left before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && (out[j] == ' ' || out[j] == '\t' || out[j] == '\n' || out[j] == '\r') {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// itermColorKeys maps .itermcolors entries onto the terminal palette
+var itermColorKeys = map[string]func(*TerminalColors) *string{
+	"Background Color": func(t *TerminalColors) *string { return &t.Background },
+	"Foreground Color": func(t *TerminalColors) *string { return &t.Foreground },
+	"Cursor Color":     func(t *TerminalColors) *string { return &t.Cursor },
+	"Selection Color":  func(t *TerminalColors) *string { return &t.SelectionBackground },
+	"Ansi 0 Color":     func(t *TerminalColors) *string { return &t.Black },
+	"Ansi 1 Color":     func(t *TerminalColors) *string { return &t.Red },
+	"Ansi 2 Color":     func(t *TerminalColors) *string { return &t.Green },
+	"Ansi 3 Color":     func(t *TerminalColors) *string { return &t.Yellow },
+	"Ansi 4 Color":     func(t *TerminalColors) *string { return &t.Blue },
+	"Ansi 5 Color":     func(t *TerminalColors) *string { return &t.Magenta },
+	"Ansi 6 Color":     func(t *TerminalColors) *string { return &t.Cyan },
+	"Ansi 7 Color":     func(t *TerminalColors) *string { return &t.White },
+	"Ansi 8 Color":     func(t *TerminalColors) *string { return &t.BrightBlack },
+	"Ansi 9 Color":     func(t *TerminalColors) *string { return &t.BrightRed },
+	"Ansi 10 Color":    func(t *TerminalColors) *string { return &t.BrightGreen },
+	"Ansi 11 Color":    func(t *TerminalColors) *string { return &t.BrightYellow },
+	"Ansi 12 Color":    func(t *TerminalColors) *string { return &t.BrightBlue },
+	"Ansi 13 Color":    func(t *TerminalColors) *string { return &t.BrightMagenta },
+	"Ansi 14 Color":    func(t *TerminalColors) *string { return &t.BrightCyan },
+	"Ansi 15 Color":    func(t *TerminalColors) *string { return &t.BrightWhite },
+}
+
+// ImportITerm converts an iTerm2 .itermcolors scheme into a new user theme named
+// after the file, deriving the UI colors from the terminal palette
+func (s *ThemeService) ImportITerm(sourcePath string) (*Theme, error) {
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read theme file: %w", err)
+	}
+	defer f.Close()
+
+	term, err := parseITermColors(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse iTerm2 scheme: %w", err)
+	}
+	theme, err := newThemeFromTerminal(themeNameFromPath(sourcePath), term)
+	if err != nil {
+		return nil, fmt.Errorf("invalid iTerm2 scheme: %w", err)
+	}
+	if err := s.installTheme(theme); err != nil {
+		return nil, err
+	}
+	return &theme, nil
+}
+
+// parseITermColors reads the palette of an .itermcolors property list
+func parseITermColors(r io.Reader) (TerminalColors, error) {
+	var term TerminalColors
+	root, err := decodePlist(r)
+	if err != nil {
+		return term, err
+	}
+	dict, ok := root.(map[string]any)
+	if !ok {
+		return term, fmt.Errorf("not a color scheme property list")
+	}
+	for key, field := range itermColorKeys {
+		if color, ok := dict[key].(map[string]any); ok {
+			*field(&term) = itermColorHex(color)
+		}
+	}
+	return term, nil
+}
+
+// itermColorHex converts an iTerm2 color dictionary, whose components are 0-1
+// reals, to "#rrggbb". The color space is ignored: the difference between sRGB
+// and calibrated RGB is not visible in a terminal.
+func itermColorHex(color map[string]any) string {
+	component := func(key string) float64 {
+		v, _ := color[key].(float64)
+		return v
+	}
+	return rgbColor{component("Red Component"), component("Green Component"), component("Blue Component")}.hex()
+}
+
+// decodePlist reads an XML property list into maps, slices, strings, float64s
+// and bools
+func decodePlist(r io.Reader) (any, error) {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return decodePlistValue(dec, start)
+		}
+	}
+}
+
+// decodePlistValue reads the value started by start, up to its end element
+func decodePlistValue(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]any)
+		var key string
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := dec.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				v, err := decodePlistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = v
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		var list []any
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				v, err := decodePlistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			case xml.EndElement:
+				return list, nil
+			}
+		}
+	case "true", "false":
+		if err := dec.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := dec.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "real", "integer":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	}
+	// string, date and data are kept as text
+	return text, nil
+}
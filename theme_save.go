@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// themeColorPattern accepts the color syntaxes themes are applied with: hex and
+// rgb()/rgba()
+var themeColorPattern = regexp.MustCompile(`^(#([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})|rgba?\([0-9.,%\s]+\))$`)
+
+// forEachThemeColor calls fn with the JSON path (e.g. "colors.bg.primary") and
+// address of every color of t
+func forEachThemeColor(t *Theme, fn func(path string, color *string)) {
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+			field := v.Field(i)
+			switch field.Kind() {
+			case reflect.Struct:
+				walk(field, prefix+name+".")
+			case reflect.String:
+				fn(prefix+name, field.Addr().Interface().(*string))
+			}
+		}
+	}
+	walk(reflect.ValueOf(&t.Colors).Elem(), "colors.")
+	walk(reflect.ValueOf(&t.Terminal).Elem(), "terminal.")
+}
+
+// validateThemeID checks that a theme ID names a file in the themes directory:
+// not empty, without path separators or ".."
+func validateThemeID(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\:`) || strings.Contains(id, "..") || filepath.Base(id) != id {
+		return fmt.Errorf("invalid theme ID %q", id)
+	}
+	return nil
+}
+
+// validateTheme checks the name, type and that every color is set and readable
+func validateTheme(t *Theme) error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("invalid theme: missing name")
+	}
+	if t.Type != "dark" && t.Type != "light" {
+		return fmt.Errorf("invalid theme: type must be dark or light, not %q", t.Type)
+	}
+	var bad []string
+	forEachThemeColor(t, func(path string, color *string) {
+		*color = strings.TrimSpace(*color)
+		if !themeColorPattern.MatchString(*color) {
+			bad = append(bad, path)
+		}
+	})
+	if len(bad) > 0 {
+		return fmt.Errorf("invalid theme: missing or invalid colors: %s", strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// isBuiltInTheme reports whether id belongs to a theme shipped with the app
+func (s *ThemeService) isBuiltInTheme(id string) bool {
+	entries, _ := fs.ReadDir(embeddedThemesFS, "themes")
+	for _, e := range entries {
+		if strings.EqualFold(strings.TrimSuffix(e.Name(), ".json"), id) {
+			return true
+		}
+	}
+	builtIn, _ := s.loadThemesFromDirectory(s.builtInPath)
+	for _, t := range builtIn {
+		if strings.EqualFold(t.ID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveTheme validates and writes a user theme, for the in-app theme editor. A
+// theme with the same ID and name is updated in place; when the ID belongs to a
+// differently named theme, the new one is saved as "<id>-2", "<id>-3" and so on.
+// Built-in themes cannot be edited, only saved under a new name. Returns the
+// theme as saved.
+func (s *ThemeService) SaveTheme(theme Theme) (*Theme, error) {
+	theme.Name = strings.TrimSpace(theme.Name)
+	if err := validateTheme(&theme); err != nil {
+		return nil, err
+	}
+	theme.ID = strings.TrimSpace(theme.ID)
+	if theme.ID == "" {
+		theme.ID = themeIDFromName(theme.Name)
+	}
+	if err := validateThemeID(theme.ID); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.GetAllThemes()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Theme)
+	for _, t := range existing {
+		byID[strings.ToLower(t.ID)] = t
+		if strings.EqualFold(t.Name, theme.Name) && !strings.EqualFold(t.ID, theme.ID) {
+			return nil, fmt.Errorf("a theme with the same name already exists: %s", theme.Name)
+		}
+	}
+
+	if current, ok := byID[strings.ToLower(theme.ID)]; ok {
+		switch {
+		case !strings.EqualFold(current.Name, theme.Name):
+			base := theme.ID
+			for n := 2; ; n++ {
+				theme.ID = fmt.Sprintf("%s-%d", base, n)
+				if _, taken := byID[strings.ToLower(theme.ID)]; !taken {
+					break
+				}
+			}
+		case s.isBuiltInTheme(theme.ID):
+			return nil, fmt.Errorf("built-in theme %s cannot be edited; save it under a new name", current.Name)
+		}
+	}
+
+	if err := s.writeUserTheme(theme); err != nil {
+		return nil, err
+	}
+	return &theme, nil
+}
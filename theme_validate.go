@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+var (
+	baseThemesOnce sync.Once
+	baseThemes     map[string]Theme // embedded "dark" and "light", by type
+)
+
+// baseTheme returns the embedded dark or light theme that missing colors are
+// taken from
+func baseTheme(themeType string) Theme {
+	baseThemesOnce.Do(func() {
+		baseThemes = make(map[string]Theme)
+		for _, id := range []string{"dark", "light"} {
+			var t Theme
+			if data, err := embeddedThemesFS.ReadFile("themes/" + id + ".json"); err == nil && json.Unmarshal(data, &t) == nil {
+				baseThemes[id] = t
+			}
+		}
+	})
+	if themeType == "light" {
+		return baseThemes["light"]
+	}
+	return baseThemes["dark"]
+}
+
+// completeTheme repairs a theme so it can be applied: an unknown type is guessed
+// from the terminal background, and missing or unreadable colors are taken from
+// the base dark or light theme instead of rendering invisible text. What was
+// repaired is listed in t.Warnings.
+func completeTheme(t *Theme) {
+	t.Warnings = nil
+	if t.Type != "dark" && t.Type != "light" {
+		guessed := themeTypeOf(t.Terminal)
+		t.Warnings = append(t.Warnings, fmt.Sprintf("type %q is not dark or light; using %s", t.Type, guessed))
+		t.Type = guessed
+	}
+
+	base := baseTheme(t.Type)
+	baseColors := make(map[string]string)
+	forEachThemeColor(&base, func(path string, color *string) {
+		baseColors[path] = *color
+	})
+	var missing, invalid []string
+	forEachThemeColor(t, func(path string, color *string) {
+		switch v := strings.TrimSpace(*color); {
+		case v == "":
+			missing = append(missing, path)
+		case !themeColorPattern.MatchString(v):
+			invalid = append(invalid, path)
+		default:
+			return
+		}
+		*color = baseColors[path]
+	})
+	if len(missing) > 0 {
+		t.Warnings = append(t.Warnings, fmt.Sprintf("missing colors taken from the %s theme: %s", base.Name, strings.Join(missing, ", ")))
+	}
+	if len(invalid) > 0 {
+		t.Warnings = append(t.Warnings, fmt.Sprintf("invalid colors replaced from the %s theme: %s", base.Name, strings.Join(invalid, ", ")))
+	}
+}
+
+// logThemeWarnings logs what was repaired in a theme file, once per change of the
+// warnings since themes are loaded often
+func (s *ThemeService) logThemeWarnings(path string, t Theme) {
+	joined := strings.Join(t.Warnings, "; ")
+	s.warnedMu.Lock()
+	defer s.warnedMu.Unlock()
+	if s.warned == nil {
+		s.warned = make(map[string]string)
+	}
+	if s.warned[path] == joined {
+		return
+	}
+	s.warned[path] = joined
+	if joined != "" {
+		log.Printf("Theme %s (%s): %s", t.ID, path, joined)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// vscodeTerminalKeys maps VS Code theme color keys onto the terminal palette
+var vscodeTerminalKeys = map[string]func(*TerminalColors) *string{
+	"terminal.background":          func(t *TerminalColors) *string { return &t.Background },
+	"terminal.foreground":          func(t *TerminalColors) *string { return &t.Foreground },
+	"terminalCursor.foreground":    func(t *TerminalColors) *string { return &t.Cursor },
+	"terminal.selectionBackground": func(t *TerminalColors) *string { return &t.SelectionBackground },
+	"terminal.ansiBlack":           func(t *TerminalColors) *string { return &t.Black },
+	"terminal.ansiRed":             func(t *TerminalColors) *string { return &t.Red },
+	"terminal.ansiGreen":           func(t *TerminalColors) *string { return &t.Green },
+	"terminal.ansiYellow":          func(t *TerminalColors) *string { return &t.Yellow },
+	"terminal.ansiBlue":            func(t *TerminalColors) *string { return &t.Blue },
+	"terminal.ansiMagenta":         func(t *TerminalColors) *string { return &t.Magenta },
+	"terminal.ansiCyan":            func(t *TerminalColors) *string { return &t.Cyan },
+	"terminal.ansiWhite":           func(t *TerminalColors) *string { return &t.White },
+	"terminal.ansiBrightBlack":     func(t *TerminalColors) *string { return &t.BrightBlack },
+	"terminal.ansiBrightRed":       func(t *TerminalColors) *string { return &t.BrightRed },
+	"terminal.ansiBrightGreen":     func(t *TerminalColors) *string { return &t.BrightGreen },
+	"terminal.ansiBrightYellow":    func(t *TerminalColors) *string { return &t.BrightYellow },
+	"terminal.ansiBrightBlue":      func(t *TerminalColors) *string { return &t.BrightBlue },
+	"terminal.ansiBrightMagenta":   func(t *TerminalColors) *string { return &t.BrightMagenta },
+	"terminal.ansiBrightCyan":      func(t *TerminalColors) *string { return &t.BrightCyan },
+	"terminal.ansiBrightWhite":     func(t *TerminalColors) *string { return &t.BrightWhite },
+}
+
+// VS Code's own terminal palettes, used for the ANSI colors a theme leaves to
+// the editor defaults
+var (
+	vscodeDarkTerminal = TerminalColors{
+		Black: "#000000", Red: "#cd3131", Green: "#0dbc79", Yellow: "#e5e510",
+		Blue: "#2472c8", Magenta: "#bc3fbc", Cyan: "#11a8cd", White: "#e5e5e5",
+		BrightBlack: "#666666", BrightRed: "#f14c4c", BrightGreen: "#23d18b", BrightYellow: "#f5f543",
+		BrightBlue: "#3b8eea", BrightMagenta: "#d670d6", BrightCyan: "#29b8db", BrightWhite: "#e5e5e5",
+	}
+	vscodeLightTerminal = TerminalColors{
+		Black: "#000000", Red: "#cd3131", Green: "#00bc00", Yellow: "#949800",
+		Blue: "#0451a5", Magenta: "#bc05bc", Cyan: "#0598bc", White: "#555555",
+		BrightBlack: "#666666", BrightRed: "#cd3131", BrightGreen: "#14ce14", BrightYellow: "#b5ba00",
+		BrightBlue: "#0451a5", BrightMagenta: "#bc05bc", BrightCyan: "#0598bc", BrightWhite: "#a5a5a5",
+	}
+)
+
+// vscodeTheme is the part of a VS Code color theme file that is used; tokenColors
+// only apply to editors
+type vscodeTheme struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"` // "dark", "light", "hc" or "hcLight"
+	Colors map[string]string `json:"colors"`
+}
+
+// ImportVSCode converts a VS Code color theme into a new user theme, taking the
+// terminal palette from its terminal.* colors and the UI from its workbench colors
+func (s *ThemeService) ImportVSCode(sourcePath string) (*Theme, error) {
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read theme file: %w", err)
+	}
+	var vt vscodeTheme
+	if err := json.Unmarshal(stripJSONC(data), &vt); err != nil {
+		return nil, fmt.Errorf("failed to parse VS Code theme: %w", err)
+	}
+	if len(vt.Colors) == 0 {
+		return nil, fmt.Errorf("invalid VS Code theme: no workbench colors")
+	}
+	if vt.Name == "" {
+		vt.Name = themeNameFromPath(sourcePath)
+	}
+
+	theme, err := newThemeFromTerminal(vt.Name, vt.terminalColors())
+	if err != nil {
+		return nil, fmt.Errorf("invalid VS Code theme: %w", err)
+	}
+	vt.applyWorkbenchColors(&theme.Colors, theme.Terminal.Background)
+	if err := s.installTheme(theme); err != nil {
+		return nil, err
+	}
+	return &theme, nil
+}
+
+// color returns the first of keys the theme sets, with any alpha blended onto bg
+func (vt vscodeTheme) color(bg string, keys ...string) string {
+	for _, key := range keys {
+		if v := vt.Colors[key]; v != "" {
+			return blendAlpha(v, bg)
+		}
+	}
+	return ""
+}
+
+// terminalColors builds the palette, falling back to the editor colors and VS
+// Code's default ANSI colors as VS Code itself does
+func (vt vscodeTheme) terminalColors() TerminalColors {
+	bg := vt.color("", "terminal.background", "panel.background", "editor.background")
+	t := vscodeDarkTerminal
+	switch strings.ToLower(vt.Type) {
+	case "light", "hclight", "vs":
+		t = vscodeLightTerminal
+	case "":
+		if themeTypeOf(TerminalColors{Background: bg}) == "light" {
+			t = vscodeLightTerminal
+		}
+	}
+	t.Background = bg
+	t.Foreground = vt.color(bg, "terminal.foreground", "editor.foreground", "foreground")
+	for key, field := range vscodeTerminalKeys {
+		if v := vt.color(t.Background, key); v != "" {
+			*field(&t) = v
+		}
+	}
+	return t
+}
+
+// applyWorkbenchColors overrides the derived UI colors with the theme's own
+// workbench colors where it has them
+func (vt vscodeTheme) applyWorkbenchColors(c *ThemeColors, bg string) {
+	set := func(dst *string, keys ...string) {
+		if v := vt.color(bg, keys...); v != "" {
+			*dst = v
+		}
+	}
+	set(&c.Bg.Primary, "editor.background")
+	set(&c.Bg.Secondary, "sideBar.background", "activityBar.background")
+	set(&c.Bg.Tertiary, "input.background", "editorWidget.background")
+	set(&c.Bg.Quaternary, "button.secondaryBackground", "list.inactiveSelectionBackground")
+	set(&c.Text.Primary, "editor.foreground", "foreground")
+	set(&c.Text.Secondary, "sideBar.foreground")
+	set(&c.Text.Muted, "descriptionForeground")
+	set(&c.Border, "panel.border", "contrastBorder", "editorGroup.border")
+	set(&c.Hover, "list.hoverBackground")
+	set(&c.Active, "list.activeSelectionBackground")
+	set(&c.Selection, "editor.selectionBackground")
+}
+
+// blendAlpha turns "#rrggbbaa" and "#rgba" into an opaque color over bg; other
+// colors are returned as they are
+func blendAlpha(color, bg string) string {
+	hex := strings.TrimPrefix(color, "#")
+	switch len(hex) {
+	case 4:
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2], hex[3], hex[3]})
+	case 8:
+	default:
+		return color
+	}
+	c, ok := parseHexColor(hex[:6])
+	if !ok {
+		return color
+	}
+	var alpha uint8
+	if _, err := fmt.Sscanf(hex[6:], "%02x", &alpha); err != nil {
+		return c.hex()
+	}
+	base, ok := parseHexColor(bg)
+	if !ok {
+		return c.hex()
+	}
+	return base.mix(c, float64(alpha)/255).hex()
+}
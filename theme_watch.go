@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// themeReloadDelay lets an editor finish writing a theme file before it is read
+const themeReloadDelay = 200 * time.Millisecond
+
+// watchThemes emits theme:updated whenever the active theme's file in the user
+// theme directory changes, so theme authors see their edits live
+func (s *ThemeService) watchThemes() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Theme hot reload unavailable: %v", err)
+		return
+	}
+	defer watcher.Close()
+	// Watch the directory rather than the files: editors often save by replacing them
+	if err := watcher.Add(s.userThemePath); err != nil {
+		log.Printf("Theme hot reload unavailable: %v", err)
+		return
+	}
+
+	var done <-chan struct{}
+	if s.ctx != nil {
+		done = s.ctx.Done()
+	}
+	pending := make(map[string]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-done:
+			return
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !strings.EqualFold(filepath.Ext(ev.Name), ".json") || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				continue
+			}
+			pending[ev.Name] = true
+			timer = time.After(themeReloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Theme watcher error: %v", err)
+		case <-timer:
+			for path := range pending {
+				s.reloadThemeFile(path)
+			}
+			pending = make(map[string]bool)
+			timer = nil
+		}
+	}
+}
+
+// reloadThemeFile emits theme:updated if path holds the active theme
+func (s *ThemeService) reloadThemeFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Renamed away or deleted
+		return
+	}
+	var theme Theme
+	if err := json.Unmarshal(data, &theme); err != nil {
+		log.Printf("Ignoring change to theme %s: %v", filepath.Base(path), err)
+		return
+	}
+	active, err := s.GetActiveTheme()
+	if err != nil || !strings.EqualFold(active.ID, theme.ID) {
+		return
+	}
+	if s.app != nil {
+		s.app.Event.Emit("theme:updated", *active)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// wtScheme is a Windows Terminal color scheme, as found in the "schemes" list of
+// its settings.json or on its own in a scheme file
+type wtScheme struct {
+	Name                string `json:"name"`
+	Background          string `json:"background"`
+	Foreground          string `json:"foreground"`
+	CursorColor         string `json:"cursorColor"`
+	SelectionBackground string `json:"selectionBackground"`
+	Black               string `json:"black"`
+	Red                 string `json:"red"`
+	Green               string `json:"green"`
+	Yellow              string `json:"yellow"`
+	Blue                string `json:"blue"`
+	Purple              string `json:"purple"`
+	Cyan                string `json:"cyan"`
+	White               string `json:"white"`
+	BrightBlack         string `json:"brightBlack"`
+	BrightRed           string `json:"brightRed"`
+	BrightGreen         string `json:"brightGreen"`
+	BrightYellow        string `json:"brightYellow"`
+	BrightBlue          string `json:"brightBlue"`
+	BrightPurple        string `json:"brightPurple"`
+	BrightCyan          string `json:"brightCyan"`
+	BrightWhite         string `json:"brightWhite"`
+}
+
+func (w wtScheme) terminalColors() TerminalColors {
+	return TerminalColors{
+		Background:          w.Background,
+		Foreground:          w.Foreground,
+		Cursor:              w.CursorColor,
+		SelectionBackground: w.SelectionBackground,
+		Black:               w.Black,
+		Red:                 w.Red,
+		Green:               w.Green,
+		Yellow:              w.Yellow,
+		Blue:                w.Blue,
+		Magenta:             w.Purple,
+		Cyan:                w.Cyan,
+		White:               w.White,
+		BrightBlack:         w.BrightBlack,
+		BrightRed:           w.BrightRed,
+		BrightGreen:         w.BrightGreen,
+		BrightYellow:        w.BrightYellow,
+		BrightBlue:          w.BrightBlue,
+		BrightMagenta:       w.BrightPurple,
+		BrightCyan:          w.BrightCyan,
+		BrightWhite:         w.BrightWhite,
+	}
+}
+
+// ImportWindowsTerminal converts the color schemes of a Windows Terminal
+// settings.json, or a file holding a single scheme, into new user themes. Schemes
+// whose name is already taken are skipped; it fails only when none was imported.
+func (s *ThemeService) ImportWindowsTerminal(sourcePath string) ([]Theme, error) {
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read theme file: %w", err)
+	}
+	schemes, err := parseWTSchemes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Windows Terminal settings: %w", err)
+	}
+
+	var imported []Theme
+	var firstErr error
+	for _, scheme := range schemes {
+		theme, err := newThemeFromTerminal(scheme.Name, scheme.terminalColors())
+		if err == nil {
+			err = s.installTheme(theme)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("scheme %q: %w", scheme.Name, err)
+			}
+			continue
+		}
+		imported = append(imported, theme)
+	}
+	if len(imported) == 0 {
+		return nil, firstErr
+	}
+	return imported, nil
+}
+
+// parseWTSchemes reads the schemes of a settings.json, which may have comments
+// and trailing commas, or of a lone scheme object
+func parseWTSchemes(data []byte) ([]wtScheme, error) {
+	data = stripJSONC(data)
+	var settings struct {
+		Schemes []wtScheme `json:"schemes"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	if len(settings.Schemes) == 0 {
+		var scheme wtScheme
+		if err := json.Unmarshal(data, &scheme); err != nil {
+			return nil, err
+		}
+		if scheme.Background == "" {
+			return nil, fmt.Errorf("no color schemes found")
+		}
+		settings.Schemes = []wtScheme{scheme}
+	}
+	for _, scheme := range settings.Schemes {
+		if scheme.Name == "" {
+			return nil, fmt.Errorf("found a color scheme without a name")
+		}
+	}
+	return settings.Schemes, nil
+}
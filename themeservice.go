@@ -9,6 +9,9 @@ import (
     "path/filepath"
     "io/fs"
     "strings"
+    "sync"
+
+    "github.com/wailsapp/wails/v3/pkg/application"
 )
 
 type ThemeColors struct {
@@ -68,13 +71,20 @@ type Theme struct {
 	Type     string         `json:"type"` // "dark" or "light"
 	Colors   ThemeColors    `json:"colors"`
 	Terminal TerminalColors `json:"terminal"`
+
+	// Warnings lists what was repaired when the theme was loaded or imported; never saved
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 type ThemeService struct {
+	app           *application.App
 	ctx           context.Context
 	settingsSvc   *SettingsService
 	builtInPath   string
 	userThemePath string
+
+	warnedMu sync.Mutex
+	warned   map[string]string // theme file -> warnings last logged
 }
 
 func NewThemeService(ctx context.Context, settingsSvc *SettingsService) *ThemeService {
@@ -154,10 +164,10 @@ func (s *ThemeService) GetTheme(id string) (*Theme, error) {
 // GetActiveTheme returns the currently active theme
 func (s *ThemeService) GetActiveTheme() (*Theme, error) {
 	// Get active theme ID from settings
-	setting, err := s.settingsSvc.GetSetting("active_theme")
-	themeID := "dark" // default
-	if err == nil && setting.Value != "" {
-		themeID = setting.Value
+	themeID := s.settingsSvc.GetString("active_theme")
+	auto := themeID == themeModeAuto
+	if auto {
+		themeID = s.autoThemeID()
 	}
 
 	// Ensure built-in defaults exist if nothing is available yet
@@ -169,20 +179,24 @@ func (s *ThemeService) GetActiveTheme() (*Theme, error) {
 	if getErr == nil {
 		return th, nil
 	}
-	// If requested theme is missing, fall back to dark and persist
+	// If requested theme is missing, fall back to dark and persist (auto mode is kept)
 	if _, derr := s.GetTheme("dark"); derr == nil {
-		_ = s.settingsSvc.SetSetting("active_theme", "dark", "string")
+		if !auto {
+			_ = s.settingsSvc.SetSetting("active_theme", "dark", "string")
+		}
 		return s.GetTheme("dark")
 	}
 	return nil, getErr
 }
 
-// SetActiveTheme sets the active theme
+// SetActiveTheme sets the active theme; "auto" follows the OS light/dark mode
 func (s *ThemeService) SetActiveTheme(id string) error {
 	// Verify theme exists
-	_, err := s.GetTheme(id)
-	if err != nil {
-		return err
+	if id != themeModeAuto {
+		_, err := s.GetTheme(id)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Save to settings
@@ -207,8 +221,25 @@ func (s *ThemeService) ImportTheme(sourcePath string) error {
     if theme.ID == "" || theme.Name == "" {
         return fmt.Errorf("invalid theme: missing ID or name")
     }
+    if err := validateThemeID(theme.ID); err != nil {
+        return err
+    }
+
+    if err := s.checkThemeUnique(theme); err != nil {
+        return err
+    }
 
-    // Enforce uniqueness by ID and Name (case-insensitive) across all themes
+	// Copy to user themes directory
+	destPath := filepath.Join(s.userThemePath, theme.ID+".json")
+	if err := os.WriteFile(destPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save theme: %w", err)
+	}
+
+	return nil
+}
+
+// checkThemeUnique enforces uniqueness by ID and Name (case-insensitive) across all themes
+func (s *ThemeService) checkThemeUnique(theme Theme) error {
     existing, _ := s.GetAllThemes()
     idLower := strings.ToLower(strings.TrimSpace(theme.ID))
     nameLower := strings.ToLower(strings.TrimSpace(theme.Name))
@@ -220,14 +251,7 @@ func (s *ThemeService) ImportTheme(sourcePath string) error {
             return fmt.Errorf("a theme with the same name already exists: %s", theme.Name)
         }
     }
-
-	// Copy to user themes directory
-	destPath := filepath.Join(s.userThemePath, theme.ID+".json")
-	if err := os.WriteFile(destPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to save theme: %w", err)
-	}
-
-	return nil
+    return nil
 }
 
 // ExportTheme exports a theme to a JSON file
@@ -236,6 +260,7 @@ func (s *ThemeService) ExportTheme(id string, destPath string) error {
 	if err != nil {
 		return err
 	}
+	theme.Warnings = nil
 
 	data, err := json.MarshalIndent(theme, "", "  ")
 	if err != nil {
@@ -274,6 +299,8 @@ func (s *ThemeService) loadThemesFromDirectory(dir string) ([]Theme, error) {
 		if err := json.Unmarshal(data, &theme); err != nil {
 			continue
 		}
+		completeTheme(&theme)
+		s.logThemeWarnings(file, theme)
 
 		themes = append(themes, theme)
 	}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// tmux control mode config keys of SSH sessions. With it on, the connection
+// attaches to a tmux session on the host with `tmux -CC` and shows each of
+// its panes in a tab, instead of starting a shell.
+const (
+	sshTmuxControlKey  = "ssh_tmux_control"
+	sshTmuxSessionKey  = "ssh_tmux_session" // created when it does not exist
+	defaultTmuxSession = "term"
+)
+
+var tmuxFields = []ConfigField{
+	{Key: sshTmuxControlKey, Label: "Attach to tmux (control mode)", Type: fieldBool, Default: "false"},
+	{Key: sshTmuxSessionKey, Label: "tmux session", Type: fieldString, Default: defaultTmuxSession, check: checkTmuxSessionName},
+}
+
+const (
+	// tmuxControlStart starts the control mode output, a DCS sequence
+	tmuxControlStart = "\x1bP1000p"
+	// tmuxSendKeysChunk bounds the input bytes sent by one send-keys command
+	tmuxSendKeysChunk = 256
+	// tmuxPreambleLimit bounds the output kept from before control mode
+	// starts, shown when tmux fails to, e.g. when it is not installed
+	tmuxPreambleLimit   = 4096
+	tmuxListPanesFormat = "#{pane_id} #{window_id} #{window_name}"
+)
+
+// tmuxControl is an SSH connection running tmux in control mode. tmux reports
+// pane output and layout changes as notifications and answers commands with
+// %begin/%end blocks, in the order they were sent.
+type tmuxControl struct {
+	t           *TerminalService
+	client      *ssh.Client
+	session     *ssh.Session
+	stdin       io.WriteCloser
+	metrics     *sshConnMetrics
+	stopMetrics context.CancelFunc
+
+	// tab of the connection, which shows the first pane
+	tabID       string
+	nodeID      string
+	name        string
+	sessionType string
+	host        string
+
+	mu       sync.Mutex
+	first    *TerminalSession            // the connection's tab until a pane is bound to it
+	panes    map[string]*TerminalSession // tabs by pane ID
+	pending  []func(lines []string, err error)
+	detached bool // panes of closed tabs stay open in tmux
+	started  bool // control mode output began
+	preamble strings.Builder
+	ended    sync.Once
+}
+
+// tmuxPane is the tmux pane a tab shows
+type tmuxPane struct {
+	ctl        *tmuxControl
+	id         string // %N; empty until the connection's tab is bound
+	window     string // @N
+	cols, rows uint16 // size of the tab, guarded by the connection
+	synced     bool   // the pane's screen was sent to the tab
+}
+
+func checkTmuxSessionName(value string) error {
+	if strings.ContainsAny(value, ":.'") {
+		return fmt.Errorf("tmux session names cannot contain ':', '.' or quotes")
+	}
+	return nil
+}
+
+// tmuxCommand is the remote command attaching to, or creating, the session
+func tmuxCommand(cfg map[string]string) string {
+	name := strings.TrimSpace(cfg[sshTmuxSessionKey])
+	if name == "" {
+		name = defaultTmuxSession
+	}
+	cmd := "tmux -CC new-session -A -s " + shellQuote(name)
+	if dir := cfg["working_directory"]; dir != "" {
+		cmd += " -c " + shellQuote(dir)
+	}
+	return cmd
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// startTmuxControl runs tmux in control mode on a connected SSH session with
+// a PTY, showing its first pane in the tab of req
+func (t *TerminalService) startTmuxControl(req StartSessionRequest, client *ssh.Client, sshSession *ssh.Session, stdin io.WriteCloser, stdout io.Reader) error {
+	if err := sshSession.Start(tmuxCommand(req.Config)); err != nil {
+		return fmt.Errorf("failed to start tmux: %w", err)
+	}
+	c := &tmuxControl{
+		t:           t,
+		client:      client,
+		session:     sshSession,
+		stdin:       stdin,
+		metrics:     &sshConnMetrics{},
+		tabID:       req.ID,
+		nodeID:      req.NodeID,
+		name:        req.Name,
+		sessionType: req.SessionType,
+		host:        terminalHost(req.SessionType, req.Config),
+		panes:       make(map[string]*TerminalSession),
+	}
+	metricsCtx, stopMetrics := context.WithCancel(context.Background())
+	c.stopMetrics = stopMetrics
+	go c.metrics.run(metricsCtx, client)
+
+	session := &TerminalSession{
+		ID:        req.ID,
+		Running:   true,
+		IsSSH:     true,
+		SSHClient: client,
+		metrics:   c.metrics,
+		tmux:      &tmuxPane{ctl: c, cols: req.Cols, rows: req.Rows},
+	}
+	session.window.Store(req.WindowID)
+	c.first = session
+	t.sessions[req.ID] = session
+
+	go c.read(stdout)
+	go func() {
+		_ = sshSession.Wait()
+		c.end("connection closed")
+	}()
+	c.refresh()
+	return nil
+}
+
+// command sends a tmux command; done, if any, gets its output lines
+func (c *tmuxControl) command(cmd string, done func(lines []string, err error)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, done)
+	n, err := io.WriteString(c.stdin, cmd+"\n")
+	c.metrics.bytesOut.Add(uint64(n))
+	return err
+}
+
+// read parses the control mode output until tmux exits
+func (c *tmuxControl) read(stdout io.Reader) {
+	r := bufio.NewReader(stdout)
+	var block []string
+	var guard string // time and number of the open %begin
+	inBlock := false
+	for {
+		line, err := r.ReadString('\n')
+		c.metrics.bytesIn.Add(uint64(len(line)))
+		if err != nil {
+			c.end("connection closed")
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if !c.started {
+			i := strings.Index(line, tmuxControlStart)
+			if i < 0 {
+				c.mu.Lock()
+				if c.preamble.Len() < tmuxPreambleLimit {
+					c.preamble.WriteString(line + "\r\n")
+				}
+				c.mu.Unlock()
+				continue
+			}
+			c.mu.Lock()
+			c.started = true
+			c.mu.Unlock()
+			line = line[i+len(tmuxControlStart):]
+		}
+
+		if inBlock {
+			if fields := strings.Fields(line); len(fields) == 4 && (fields[0] == "%end" || fields[0] == "%error") && fields[1]+" "+fields[2] == guard {
+				inBlock = false
+				// Flags 1 marks the commands this client sent; the others
+				// ran from the command line
+				if fields[3] == "1" {
+					var err error
+					if fields[0] == "%error" {
+						err = fmt.Errorf("tmux: %s", strings.Join(block, "; "))
+					}
+					c.finish(block, err)
+				}
+				continue
+			}
+			block = append(block, line)
+			continue
+		}
+
+		name, args, _ := strings.Cut(line, " ")
+		switch name {
+		case "%begin":
+			fields := strings.Fields(args)
+			if len(fields) >= 2 {
+				inBlock, guard, block = true, fields[0]+" "+fields[1], nil
+			}
+		case "%output":
+			pane, data, _ := strings.Cut(args, " ")
+			c.output(pane, unescapeTmuxOutput(data))
+		case "%window-add", "%window-close", "%unlinked-window-close", "%layout-change", "%session-changed":
+			c.refresh()
+		case "%exit":
+			c.end(strings.TrimSpace(args))
+			return
+		}
+	}
+}
+
+// finish hands a command's output to the oldest waiting command
+func (c *tmuxControl) finish(lines []string, err error) {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	done := c.pending[0]
+	c.pending = c.pending[1:]
+	c.mu.Unlock()
+	if done != nil {
+		done(lines, err)
+	}
+}
+
+// unescapeTmuxOutput decodes %output data, where tmux writes control
+// characters and backslashes as octal escapes
+func unescapeTmuxOutput(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				out = append(out, byte(n))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return out
+}
+
+// output shows pane output in the pane's tab
+func (c *tmuxControl) output(pane string, data []byte) {
+	c.mu.Lock()
+	session := c.panes[pane]
+	c.mu.Unlock()
+	if session == nil || len(data) == 0 {
+		return
+	}
+	session.transfer.read.Add(uint64(len(data)))
+	t := c.t
+	if t.recorder != nil {
+		t.recorder.AppendOutput(session.ID, data)
+	}
+	if t.outputTap != nil {
+		t.outputTap(session.ID, data)
+	}
+	t.history.output(session, data)
+	t.emit(session, "terminal:data", map[string]interface{}{
+		"id":   session.ID,
+		"data": string(data),
+	})
+	t.followOutput(session, data)
+}
+
+// refresh lists the session's panes, opening tabs for new ones and ending
+// the tabs of closed ones
+func (c *tmuxControl) refresh() {
+	_ = c.command("list-panes -s -F '"+tmuxListPanesFormat+"'", func(lines []string, err error) {
+		if err != nil {
+			termLog.Warn("tmux list-panes failed", "tab", c.tabID, "err", err)
+			return
+		}
+		seen := make(map[string]bool, len(lines))
+		for _, line := range lines {
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) < 2 {
+				continue
+			}
+			seen[fields[0]] = true
+			c.mu.Lock()
+			_, known := c.panes[fields[0]]
+			c.mu.Unlock()
+			if !known {
+				windowName := ""
+				if len(fields) == 3 {
+					windowName = fields[2]
+				}
+				c.addPane(fields[0], fields[1], windowName)
+			}
+		}
+
+		c.mu.Lock()
+		var gone []*TerminalSession
+		for id, session := range c.panes {
+			if !seen[id] {
+				gone = append(gone, session)
+				delete(c.panes, id)
+			}
+		}
+		c.mu.Unlock()
+		for _, session := range gone {
+			c.t.endSession(session, 0)
+		}
+	})
+}
+
+// addPane shows a pane in the connection's tab if no pane is yet, or else in
+// a new tab of the same window, announced with terminal:added
+func (c *tmuxControl) addPane(id, window, windowName string) {
+	c.mu.Lock()
+	first := c.first
+	c.first = nil
+	c.mu.Unlock()
+
+	if first != nil {
+		first.mu.Lock()
+		first.tmux.id, first.tmux.window = id, window
+		// The tab is already showing, so it can take the pane's screen now
+		first.tmux.synced = true
+		first.mu.Unlock()
+		c.mu.Lock()
+		c.panes[id] = first
+		c.mu.Unlock()
+		_ = c.sizeClient()
+		c.sync(first, id)
+		return
+	}
+
+	name := c.name
+	if windowName != "" {
+		name = c.name + ": " + windowName
+	}
+	session := &TerminalSession{
+		ID:          c.tabID + "-tmux-" + strings.TrimPrefix(id, "%"),
+		NodeID:      c.nodeID,
+		Name:        name,
+		SessionType: c.sessionType,
+		Host:        c.host,
+		Running:     true,
+		IsSSH:       true,
+		SSHClient:   c.client,
+		metrics:     c.metrics,
+		tmux:        &tmuxPane{ctl: c, id: id, window: window},
+	}
+	windowID := mainWindowName
+	if tab := c.t.GetSession(c.tabID); tab != nil {
+		windowID = tab.Window()
+	}
+	session.window.Store(windowID)
+
+	c.t.mu.Lock()
+	c.t.sessions[session.ID] = session
+	c.t.mu.Unlock()
+	c.mu.Lock()
+	c.panes[id] = session
+	c.mu.Unlock()
+	termLog.Info("tmux pane opened", "tab", session.ID, "session", c.nodeID, "pane", id)
+	c.t.emit(session, "terminal:added", map[string]interface{}{
+		"id":          session.ID,
+		"nodeId":      session.NodeID,
+		"name":        name,
+		"sessionType": session.SessionType,
+		"tmux":        true,
+	})
+}
+
+// sync sends a pane's screen to its tab, which starts out blank
+func (c *tmuxControl) sync(session *TerminalSession, pane string) {
+	_ = c.command("capture-pane -peq -t "+pane, func(screen []string, err error) {
+		if err != nil {
+			return
+		}
+		_ = c.command("display-message -p -t "+pane+" '#{cursor_x} #{cursor_y}'", func(pos []string, err error) {
+			var x, y int
+			if err == nil && len(pos) > 0 {
+				fmt.Sscanf(pos[0], "%d %d", &x, &y)
+			}
+			c.t.emit(session, "terminal:data", map[string]interface{}{
+				"id":   session.ID,
+				"data": "\x1b[H\x1b[2J" + strings.Join(screen, "\r\n") + fmt.Sprintf("\x1b[%d;%dH", y+1, x+1),
+			})
+		})
+	})
+}
+
+// write types input into the pane. Called with the session locked.
+func (p *tmuxPane) write(data string) error {
+	if p.id == "" {
+		return nil
+	}
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > tmuxSendKeysChunk {
+			chunk = chunk[:tmuxSendKeysChunk]
+		}
+		data = data[len(chunk):]
+		var cmd strings.Builder
+		cmd.WriteString("send-keys -t " + p.id + " -H")
+		for i := 0; i < len(chunk); i++ {
+			fmt.Fprintf(&cmd, " %02x", chunk[i])
+		}
+		if err := p.ctl.command(cmd.String(), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resize sizes the pane to its tab. Called with the session locked.
+func (p *tmuxPane) resize(session *TerminalSession, cols, rows uint16) error {
+	c := p.ctl
+	c.mu.Lock()
+	p.cols, p.rows = cols, rows
+	c.mu.Unlock()
+	if p.id == "" {
+		return nil
+	}
+	if err := c.sizeClient(); err != nil {
+		return err
+	}
+	if err := c.command(fmt.Sprintf("resize-pane -t %s -x %d -y %d", p.id, cols, rows), nil); err != nil {
+		return err
+	}
+	// A new tab shows once it has a size, so its screen is sent then
+	if !p.synced {
+		p.synced = true
+		c.sync(session, p.id)
+	}
+	return nil
+}
+
+// sizeClient sizes the client to the largest tab; tmux sizes windows to it
+func (c *tmuxControl) sizeClient() error {
+	c.mu.Lock()
+	var width, height uint16
+	tabs := make([]*TerminalSession, 0, len(c.panes)+1)
+	for _, session := range c.panes {
+		tabs = append(tabs, session)
+	}
+	if c.first != nil {
+		tabs = append(tabs, c.first)
+	}
+	for _, session := range tabs {
+		width, height = max(width, session.tmux.cols), max(height, session.tmux.rows)
+	}
+	c.mu.Unlock()
+	if width == 0 || height == 0 {
+		return nil
+	}
+	return c.command(fmt.Sprintf("refresh-client -C %dx%d", width, height), nil)
+}
+
+// close ends the pane of a closed tab, unless the connection is detaching,
+// and the connection with its last tab. Called with the session locked.
+func (p *tmuxPane) close() {
+	c := p.ctl
+	c.mu.Lock()
+	if c.first != nil && c.first.tmux == p {
+		c.first = nil
+	}
+	if p.id != "" {
+		delete(c.panes, p.id)
+	}
+	last := len(c.panes) == 0 && c.first == nil
+	detached := c.detached
+	c.mu.Unlock()
+
+	switch {
+	case p.id != "" && !detached && last:
+		// Closing the pane ends the connection once tmux did so
+		if c.command("kill-pane -t "+p.id, func([]string, error) { c.shutdown() }) != nil {
+			c.shutdown()
+		}
+	case p.id != "" && !detached:
+		_ = c.command("kill-pane -t "+p.id, nil)
+	case last:
+		c.shutdown()
+	}
+}
+
+// detach leaves tmux running on the host, e.g. when the app quits
+func (c *tmuxControl) detach() {
+	c.mu.Lock()
+	detached := c.detached
+	c.detached = true
+	c.mu.Unlock()
+	if !detached {
+		_ = c.command("detach-client", nil)
+	}
+}
+
+// end ends the tabs of the connection when tmux exits or the connection drops
+func (c *tmuxControl) end(reason string) {
+	c.ended.Do(func() {
+		c.mu.Lock()
+		sessions := make([]*TerminalSession, 0, len(c.panes)+1)
+		for _, session := range c.panes {
+			sessions = append(sessions, session)
+		}
+		first, started := c.first, c.started
+		preamble := c.preamble.String()
+		c.panes = map[string]*TerminalSession{}
+		c.first = nil
+		c.mu.Unlock()
+
+		termLog.Info("tmux control mode ended", "tab", c.tabID, "session", c.nodeID, "reason", reason)
+		if first != nil {
+			// tmux never attached; its output says why, e.g. not installed
+			if !started && preamble != "" {
+				c.t.emit(first, "terminal:data", map[string]interface{}{
+					"id":   first.ID,
+					"data": preamble,
+				})
+			}
+			sessions = append(sessions, first)
+		}
+		code := 0
+		if !started {
+			code = 1
+		}
+		for _, session := range sessions {
+			c.t.endSession(session, code)
+		}
+		c.shutdown()
+	})
+}
+
+// shutdown closes the SSH connection
+func (c *tmuxControl) shutdown() {
+	c.stopMetrics()
+	_ = c.stdin.Close()
+	_ = c.session.Close()
+	_ = c.client.Close()
+}
+
+// endSession marks a session whose process is gone as exited
+func (t *TerminalService) endSession(session *TerminalSession, exitCode int) {
+	session.mu.Lock()
+	running := session.Running
+	session.Running = false
+	session.mu.Unlock()
+	if !running {
+		return
+	}
+	t.history.forget(session.ID)
+	t.recordTransfer(session)
+	t.emit(session, "terminal:exit", map[string]interface{}{
+		"id":       session.ID,
+		"exitCode": exitCode,
+		"transfer": t.transferStats(session),
+	})
+	if t.recorder != nil {
+		_ = t.recorder.Stop(session.ID)
+	}
+	if t.exitTap != nil {
+		t.exitTap(session.ID)
+	}
+}
+
+// tmuxSession returns the tmux pane tab with the given ID
+func (t *TerminalService) tmuxSession(id string) (*TerminalSession, error) {
+	session := t.GetSession(id)
+	if session == nil {
+		return nil, fmt.Errorf("session %s not found", id)
+	}
+	if session.tmux == nil {
+		return nil, fmt.Errorf("session %s is not attached to tmux", id)
+	}
+	return session, nil
+}
+
+// NewTmuxWindow creates a tmux window on the connection of a tmux tab; it
+// opens in a new tab
+func (t *TerminalService) NewTmuxWindow(id string) error {
+	session, err := t.tmuxSession(id)
+	if err != nil {
+		return err
+	}
+	return session.tmux.ctl.command("new-window", nil)
+}
+
+// SplitTmuxPane splits the pane of a tmux tab, side by side when horizontal;
+// the new pane opens in a new tab
+func (t *TerminalService) SplitTmuxPane(id string, horizontal bool) error {
+	session, err := t.tmuxSession(id)
+	if err != nil {
+		return err
+	}
+	session.mu.Lock()
+	pane := session.tmux.id
+	session.mu.Unlock()
+	if pane == "" {
+		return fmt.Errorf("session %s has no tmux pane yet", id)
+	}
+	flag := "-v"
+	if horizontal {
+		flag = "-h"
+	}
+	return session.tmux.ctl.command("split-window "+flag+" -t "+pane, nil)
+}
+
+// DetachTmux detaches the connection of a tmux tab, leaving its panes running
+// on the host; every tab of the connection ends
+func (t *TerminalService) DetachTmux(id string) error {
+	session, err := t.tmuxSession(id)
+	if err != nil {
+		return err
+	}
+	session.tmux.ctl.detach()
+	return nil
+}
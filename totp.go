@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TOTP config keys. The seed is stored encrypted like a password; an
+// otpauth:// URI, as encoded in enrollment QR codes, is accepted too and its
+// parameters apply unless the keys below are set.
+const (
+	totpSecretKey      = "totp_secret"
+	totpDigitsKey      = "totp_digits"
+	totpPeriodKey      = "totp_period"
+	totpAlgorithmKey   = "totp_algorithm"
+	sshTOTPAutofillKey = "ssh_totp_autofill" // answer keyboard-interactive code prompts
+	defaultTOTPDigits  = 6
+	defaultTOTPPeriod  = 30
+)
+
+var totpFields = []ConfigField{
+	{Key: totpSecretKey, Label: "TOTP secret", Type: fieldSecret, check: checkTOTPSecret},
+	{Key: totpDigitsKey, Label: "TOTP digits", Type: fieldInt, Default: strconv.Itoa(defaultTOTPDigits), Min: intPtr(6), Max: intPtr(8)},
+	{Key: totpPeriodKey, Label: "TOTP period (seconds)", Type: fieldInt, Default: strconv.Itoa(defaultTOTPPeriod), Min: intPtr(10), Max: intPtr(300)},
+	{Key: totpAlgorithmKey, Label: "TOTP algorithm", Type: fieldEnum, Default: "SHA1", Allowed: []string{"SHA1", "SHA256", "SHA512"}},
+}
+
+// totpPromptWords mark keyboard-interactive prompts that ask for a code
+var totpPromptWords = []string{"verification code", "one-time", "one time", "otp", "2fa", "two-factor", "authenticator", "token", "code"}
+
+// TOTPCode is the current code of a session
+type TOTPCode struct {
+	Code      string `json:"code"`
+	Remaining int    `json:"remaining"` // seconds the code stays valid
+	Period    int    `json:"period"`
+}
+
+// totpGenerator produces the RFC 6238 codes of one seed
+type totpGenerator struct {
+	key       []byte
+	digits    int
+	period    int
+	algorithm string
+}
+
+// GetTOTPCode returns the current TOTP code of a session, e.g. to paste into a
+// 2FA prompt
+func (s *SessionService) GetTOTPCode(sessionID string) (*TOTPCode, error) {
+	cfg, err := s.db.GetEffectiveConfig(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if cfg[totpSecretKey] == "" {
+		return nil, fmt.Errorf("session has no %s", totpSecretKey)
+	}
+	secret, err := s.secrets.Decrypt(cfg[totpSecretKey])
+	if err != nil {
+		return nil, err
+	}
+	resolved := make(map[string]string, len(cfg))
+	for k, v := range cfg {
+		resolved[k] = v
+	}
+	resolved[totpSecretKey] = secret
+	gen, err := newTOTPGenerator(resolved)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &TOTPCode{
+		Code:      gen.code(now),
+		Remaining: gen.period - int(now.Unix()%int64(gen.period)),
+		Period:    gen.period,
+	}, nil
+}
+
+// newTOTPGenerator reads the TOTP settings of a decrypted config
+func newTOTPGenerator(cfg map[string]string) (*totpGenerator, error) {
+	gen := &totpGenerator{digits: defaultTOTPDigits, period: defaultTOTPPeriod, algorithm: "SHA1"}
+	secret := strings.TrimSpace(cfg[totpSecretKey])
+	if strings.HasPrefix(strings.ToLower(secret), "otpauth://") {
+		u, err := url.Parse(secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otpauth URI: %w", err)
+		}
+		q := u.Query()
+		secret = q.Get("secret")
+		if n, err := strconv.Atoi(q.Get("digits")); err == nil {
+			gen.digits = n
+		}
+		if n, err := strconv.Atoi(q.Get("period")); err == nil {
+			gen.period = n
+		}
+		if a := q.Get("algorithm"); a != "" {
+			gen.algorithm = strings.ToUpper(a)
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(cfg[totpDigitsKey])); err == nil {
+		gen.digits = n
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(cfg[totpPeriodKey])); err == nil {
+		gen.period = n
+	}
+	if a := strings.TrimSpace(cfg[totpAlgorithmKey]); a != "" {
+		gen.algorithm = strings.ToUpper(a)
+	}
+
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	gen.key = key
+	if gen.digits < 6 || gen.digits > 8 {
+		return nil, fmt.Errorf("TOTP digits must be between 6 and 8")
+	}
+	if gen.period <= 0 {
+		return nil, fmt.Errorf("TOTP period must be positive")
+	}
+	switch gen.algorithm {
+	case "SHA1", "SHA256", "SHA512":
+	default:
+		return nil, fmt.Errorf("unsupported TOTP algorithm %q", gen.algorithm)
+	}
+	return gen, nil
+}
+
+// decodeTOTPSecret decodes a base32 seed, which authenticator apps show in
+// groups, lower case and without padding
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(secret))
+	secret = strings.TrimRight(secret, "=")
+	if secret == "" {
+		return nil, fmt.Errorf("TOTP secret is empty")
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("TOTP secret is not valid base32")
+	}
+	return key, nil
+}
+
+func checkTOTPSecret(value string) error {
+	_, err := newTOTPGenerator(map[string]string{totpSecretKey: value})
+	return err
+}
+
+// code returns the code for the time step containing t
+func (g *totpGenerator) code(t time.Time) string {
+	var h func() hash.Hash
+	switch g.algorithm {
+	case "SHA256":
+		h = sha256.New
+	case "SHA512":
+		h = sha512.New
+	default:
+		h = sha1.New
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(g.period)))
+	mac := hmac.New(h, g.key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < g.digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", g.digits, value%mod)
+}
+
+// sshTOTPAuth answers keyboard-interactive prompts for servers that ask for a
+// verification code, often after the password (PAM with an authenticator
+// module): code prompts get the current TOTP code, password prompts the
+// session's password. Nil when the session has no TOTP secret or autofill is
+// off.
+func sshTOTPAuth(cfg map[string]string) (ssh.AuthMethod, error) {
+	if cfg[totpSecretKey] == "" {
+		return nil, nil
+	}
+	if autofill, err := strconv.ParseBool(cfg[sshTOTPAutofillKey]); err == nil && !autofill {
+		return nil, nil
+	}
+	gen, err := newTOTPGenerator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	password := cfg["ssh_password"]
+	return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, q := range questions {
+			prompt := strings.ToLower(q)
+			switch {
+			case isTOTPPrompt(prompt):
+				answers[i] = gen.code(time.Now())
+			case strings.Contains(prompt, "password") && password != "":
+				answers[i] = password
+			default:
+				return nil, fmt.Errorf("cannot answer the server's prompt %q", strings.TrimSpace(q))
+			}
+		}
+		return answers, nil
+	}), nil
+}
+
+func isTOTPPrompt(prompt string) bool {
+	for _, word := range totpPromptWords {
+		if strings.Contains(prompt, word) {
+			return true
+		}
+	}
+	return false
+}
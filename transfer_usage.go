@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"term/database"
+)
+
+const (
+	transferRecordInterval   = time.Minute // between additions to the daily usage
+	defaultTransferUsageDays = 30
+)
+
+// TransferBytes is the data a terminal or SFTP client moved. Read is what came
+// from the host, written what went to it.
+type TransferBytes struct {
+	Read    uint64 `json:"read"`
+	Written uint64 `json:"written"`
+}
+
+// TransferStats is the data moved by a terminal and by SFTP over its
+// connection. It is added to terminal:exit.
+type TransferStats struct {
+	ID       string        `json:"id"`
+	NodeID   string        `json:"nodeId"`
+	Name     string        `json:"name"`
+	Host     string        `json:"host"`
+	Terminal TransferBytes `json:"terminal"`
+	SFTP     TransferBytes `json:"sftp"`
+}
+
+func (s TransferStats) total() uint64 {
+	return s.Terminal.Read + s.Terminal.Written + s.SFTP.Read + s.SFTP.Written
+}
+
+// transferCounter counts the bytes of a terminal or SFTP client, and what of
+// them was already added to the daily usage in the database
+type transferCounter struct {
+	read    atomic.Uint64
+	written atomic.Uint64
+
+	mu       sync.Mutex
+	recorded TransferBytes
+}
+
+func (c *transferCounter) bytes() TransferBytes {
+	return TransferBytes{Read: c.read.Load(), Written: c.written.Load()}
+}
+
+// unrecorded returns the bytes counted so far and those not yet added to the
+// daily usage; c.mu must be held
+func (c *transferCounter) unrecorded() (total, delta TransferBytes) {
+	total = c.bytes()
+	return total, TransferBytes{Read: total.Read - c.recorded.Read, Written: total.Written - c.recorded.Written}
+}
+
+// countingReadCloser counts the bytes read through it as read from the host
+type countingReadCloser struct {
+	io.ReadCloser
+	counter *transferCounter
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.counter.read.Add(uint64(n))
+	return n, err
+}
+
+// countingWriteCloser counts the bytes written through it as written to the host
+type countingWriteCloser struct {
+	io.WriteCloser
+	counter *transferCounter
+}
+
+func (c *countingWriteCloser) Write(p []byte) (int, error) {
+	n, err := c.WriteCloser.Write(p)
+	c.counter.written.Add(uint64(n))
+	return n, err
+}
+
+// ReadFrom keeps the concurrent writes of an SFTP file, which io.Copy uses
+func (c *countingWriteCloser) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := c.WriteCloser.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{c}, r)
+	}
+	n, err := rf.ReadFrom(r)
+	c.counter.written.Add(uint64(n))
+	return n, err
+}
+
+// transferStats returns the data a terminal and its SFTP client moved
+func (t *TerminalService) transferStats(session *TerminalSession) TransferStats {
+	return TransferStats{
+		ID:       session.ID,
+		NodeID:   session.NodeID,
+		Name:     session.Name,
+		Host:     session.Host,
+		Terminal: session.transfer.bytes(),
+		SFTP:     session.sftpTransfer.bytes(),
+	}
+}
+
+// GetTransferStats returns the data moved by every open terminal and its SFTP
+// client, the busiest first
+func (t *TerminalService) GetTransferStats() []TransferStats {
+	t.mu.RLock()
+	stats := make([]TransferStats, 0, len(t.sessions))
+	for _, session := range t.sessions {
+		stats = append(stats, t.transferStats(session))
+	}
+	t.mu.RUnlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].total() > stats[j].total() })
+	return stats
+}
+
+// GetSessionTransferStats returns the data moved by a terminal and its SFTP client
+func (t *TerminalService) GetSessionTransferStats(id string) (TransferStats, error) {
+	session := t.GetSession(id)
+	if session == nil {
+		return TransferStats{}, fmt.Errorf("session %s not found", id)
+	}
+	return t.transferStats(session), nil
+}
+
+// GetTransferUsage returns the data moved with each remote host per day over
+// the last days (0 for the default of 30), counting open terminals up to the
+// last minute
+func (t *TerminalService) GetTransferUsage(days int) ([]database.TransferUsage, error) {
+	if days <= 0 {
+		days = defaultTransferUsageDays
+	}
+	since := time.Now().AddDate(0, 0, -(days - 1)).Format(time.DateOnly)
+	return t.db.ListTransferUsage(since)
+}
+
+// meteredHost returns the remote host whose usage a terminal counts towards,
+// or "" for local shells and serial ports
+func meteredHost(session *TerminalSession) string {
+	if session.IsSSH || session.SessionType == "telnet" {
+		return session.Host
+	}
+	return ""
+}
+
+// recordTransfer adds what a terminal and its SFTP client moved since the last
+// call to its host's usage for today. Bytes that fail to be added are kept for
+// the next call.
+func (t *TerminalService) recordTransfer(session *TerminalSession) {
+	host := meteredHost(session)
+	if host == "" || t.db == nil || t.db.Locked() {
+		return
+	}
+	session.transfer.mu.Lock()
+	defer session.transfer.mu.Unlock()
+	session.sftpTransfer.mu.Lock()
+	defer session.sftpTransfer.mu.Unlock()
+
+	terminal, terminalDelta := session.transfer.unrecorded()
+	sftp, sftpDelta := session.sftpTransfer.unrecorded()
+	if terminalDelta == (TransferBytes{}) && sftpDelta == (TransferBytes{}) {
+		return
+	}
+	err := t.db.AddTransferUsage(database.TransferUsage{
+		Host:            host,
+		Day:             time.Now().Format(time.DateOnly),
+		TerminalRead:    int64(terminalDelta.Read),
+		TerminalWritten: int64(terminalDelta.Written),
+		SFTPRead:        int64(sftpDelta.Read),
+		SFTPWritten:     int64(sftpDelta.Written),
+	})
+	if err != nil {
+		termLog.Warn("failed to record transfer usage", "tab", session.ID, "host", host, "err", err)
+		return
+	}
+	session.transfer.recorded, session.sftpTransfer.recorded = terminal, sftp
+}
+
+// StartTransferAccounting adds the data moved by open terminals to the daily
+// usage every minute; closing a terminal adds the rest
+func (t *TerminalService) StartTransferAccounting() {
+	go func() {
+		ticker := time.NewTicker(transferRecordInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			t.mu.RLock()
+			sessions := make([]*TerminalSession, 0, len(t.sessions))
+			for _, session := range t.sessions {
+				sessions = append(sessions, session)
+			}
+			t.mu.RUnlock()
+			for _, session := range sessions {
+				t.recordTransfer(session)
+			}
+		}
+	}()
+}
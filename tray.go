@@ -0,0 +1,109 @@
+package main
+
+import (
+	_ "embed"
+	"strconv"
+	"sync"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"github.com/wailsapp/wails/v3/pkg/events"
+)
+
+//go:embed build/appicon.png
+var trayIcon []byte
+
+const (
+	settingBackgroundMode = "background_mode" // closing the window hides it to the tray
+	trayRecentLimit       = 8
+)
+
+// Tray is the system tray icon: a menu of favorite and recent sessions, and with
+// background mode, what keeps the app and its connections running while the
+// window is closed
+type Tray struct {
+	app      *application.App
+	db       *database.DB
+	sessions *SessionService
+	window   *application.WebviewWindow
+	tray     *application.SystemTray
+	menu     *application.Menu
+
+	mu       sync.Mutex
+	quitting bool
+}
+
+// NewTray adds the tray icon for the main window
+func NewTray(app *application.App, db *database.DB, sessions *SessionService, window *application.WebviewWindow) *Tray {
+	t := &Tray{app: app, db: db, sessions: sessions, window: window}
+	t.menu = application.NewMenu()
+	t.tray = app.SystemTray.New()
+	t.tray.SetIcon(trayIcon)
+	t.tray.SetTooltip("Terminal Manager")
+	t.tray.OnClick(t.ShowWindow)
+	t.rebuild()
+	t.tray.SetMenu(t.menu)
+
+	// Closing the window only hides it in background mode; quitting closes it for real
+	app.OnShutdown(func() {
+		t.mu.Lock()
+		t.quitting = true
+		t.mu.Unlock()
+	})
+	window.RegisterHook(events.Common.WindowClosing, func(e *application.WindowEvent) {
+		t.mu.Lock()
+		quitting := t.quitting
+		t.mu.Unlock()
+		if !quitting && settingBool(db, settingBackgroundMode) {
+			e.Cancel()
+			window.Hide()
+		}
+	})
+
+	app.Event.On("sessions:usage:updated", func(*application.CustomEvent) { t.rebuild() })
+	return t
+}
+
+// ShowWindow brings the main window back, also when it was hidden to the tray
+func (t *Tray) ShowWindow() {
+	showUIWindow(t.app)
+}
+
+// rebuild fills the menu with the current favorites and recent sessions
+func (t *Tray) rebuild() {
+	t.menu.Clear()
+	t.menu.Add("Show Terminal Manager").OnClick(func(*application.Context) { t.ShowWindow() })
+	t.menu.AddSeparator()
+
+	favorites, _ := t.db.ListFavoriteSessions()
+	recent, _ := t.db.ListRecentSessions(trayRecentLimit)
+	t.addSessions("Favorites", favorites)
+	t.addSessions("Recent", recent)
+
+	t.menu.AddSeparator()
+	background := t.menu.AddCheckbox("Keep running when the window is closed", settingBool(t.db, settingBackgroundMode))
+	background.OnClick(func(ctx *application.Context) {
+		on := ctx.ClickedMenuItem().Checked()
+		if err := setSettingValue(t.db, settingBackgroundMode, strconv.FormatBool(on)); err != nil {
+			settingsLog.Error("failed to save background mode", "err", err)
+		}
+	})
+	t.menu.Add("Quit").OnClick(func(*application.Context) { t.app.Quit() })
+	t.menu.Update()
+}
+
+func (t *Tray) addSessions(title string, list []database.SessionUsage) {
+	sub := t.menu.AddSubmenu(title)
+	if len(list) == 0 {
+		sub.Add("None").SetEnabled(false)
+		return
+	}
+	for _, u := range list {
+		node := u.Session
+		sub.Add(node.Name).OnClick(func(*application.Context) {
+			t.ShowWindow()
+			openSessionTab(t.app, &node)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// Keys tried, in order, for an SSH target without a saved session or a key path
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// sshTarget is a host to open an SSH tab to, e.g. from `term ssh user@host`
+type sshTarget struct {
+	User    string `json:"user"`
+	Host    string `json:"host"`
+	Port    int    `json:"port"`    // 0 for any, or 22 for a new session
+	KeyPath string `json:"keyPath"` // private key of a new session
+}
+
+// UIOpenResult tells what was opened in the app window
+type UIOpenResult struct {
+	Mode      string `json:"mode"`                // "saved" or "adhoc"
+	SessionID string `json:"sessionId,omitempty"` // the saved session
+	Name      string `json:"name"`
+}
+
+// sessionPath writes a node as its folder path, e.g. "Servers/web-1"
+func sessionPath(byID map[string]database.SessionNode, node database.SessionNode) string {
+	parts := []string{node.Name}
+	for p := node.ParentID; p != nil; {
+		parent, ok := byID[*p]
+		if !ok {
+			break
+		}
+		parts = append([]string{parent.Name}, parts...)
+		p = parent.ParentID
+	}
+	return strings.Join(parts, "/")
+}
+
+// findSessionNode resolves a session by ID, by folder path or by name, ignoring
+// case. A name shared by several sessions is refused as ambiguous.
+func findSessionNode(db *database.DB, ref string) (*database.SessionNode, error) {
+	nodes, err := db.GetAllSessions()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]database.SessionNode, len(nodes))
+	for _, n := range nodes {
+		byID[n.ID] = n
+	}
+	if n, ok := byID[ref]; ok && n.Type != "folder" {
+		return &n, nil
+	}
+	var byName []database.SessionNode
+	for _, n := range nodes {
+		if n.Type == "folder" || n.IsTemplate {
+			continue
+		}
+		if strings.EqualFold(sessionPath(byID, n), ref) {
+			return &n, nil
+		}
+		if strings.EqualFold(n.Name, ref) {
+			byName = append(byName, n)
+		}
+	}
+	switch len(byName) {
+	case 0:
+		return nil, fmt.Errorf("no session named %q", ref)
+	case 1:
+		return &byName[0], nil
+	}
+	paths := make([]string, len(byName))
+	for i, n := range byName {
+		paths[i] = sessionPath(byID, n)
+	}
+	return nil, fmt.Errorf("%q is ambiguous: %s", ref, strings.Join(paths, ", "))
+}
+
+// findSSHSession returns the saved SSH session for a target's host, user and
+// port, or nil. The user and port only narrow the match when the target has them.
+func findSSHSession(sessions *SessionService, t sshTarget) *database.SessionNode {
+	nodes, err := sessions.db.GetAllSessions()
+	if err != nil {
+		return nil
+	}
+	for _, n := range nodes {
+		if n.IsTemplate || n.SessionType == nil || *n.SessionType != "ssh" {
+			continue
+		}
+		config, err := sessions.GetEffectiveConfig(n.ID)
+		if err != nil || !strings.EqualFold(config["ssh_host"], t.Host) {
+			continue
+		}
+		if t.User != "" && config["ssh_username"] != t.User {
+			continue
+		}
+		port := config["ssh_port"]
+		if port == "" {
+			port = "22"
+		}
+		if t.Port != 0 && port != strconv.Itoa(t.Port) {
+			continue
+		}
+		node := n
+		return &node
+	}
+	return nil
+}
+
+// openSessionTab asks the frontend to open a saved session in a new tab
+func openSessionTab(app *application.App, node *database.SessionNode) UIOpenResult {
+	sessionType := ""
+	if node.SessionType != nil {
+		sessionType = *node.SessionType
+	}
+	app.Event.Emit("ui:open_session", map[string]interface{}{
+		"windowId":    uiWindow(app, ""),
+		"id":          node.ID,
+		"name":        node.Name,
+		"sessionType": sessionType,
+	})
+	return UIOpenResult{Mode: "saved", SessionID: node.ID, Name: node.Name}
+}
+
+// openSSHTarget opens a tab to an SSH host: the matching saved session when
+// there is one, so its credentials and settings apply, otherwise a new session
+// authenticating with a private key that is not saved in the tree
+func openSSHTarget(app *application.App, sessions *SessionService, t sshTarget) (UIOpenResult, error) {
+	if t.Host == "" {
+		return UIOpenResult{}, fmt.Errorf("host is required")
+	}
+	if node := findSSHSession(sessions, t); node != nil {
+		return openSessionTab(app, node), nil
+	}
+
+	if t.User == "" {
+		return UIOpenResult{}, fmt.Errorf("no saved session for %s; give a user, e.g. user@%s", t.Host, t.Host)
+	}
+	if t.KeyPath == "" {
+		home, _ := os.UserHomeDir()
+		for _, name := range defaultSSHKeys {
+			p := filepath.Join(home, ".ssh", name)
+			if _, err := os.Stat(p); home != "" && err == nil {
+				t.KeyPath = p
+				break
+			}
+		}
+		if t.KeyPath == "" {
+			return UIOpenResult{}, fmt.Errorf("no saved session for %s and no private key in ~/.ssh; pass a key or save a session", t.Host)
+		}
+	}
+	if t.Port == 0 {
+		t.Port = 22
+	}
+	config := map[string]string{
+		"ssh_host":        t.Host,
+		"ssh_port":        strconv.Itoa(t.Port),
+		"ssh_username":    t.User,
+		"ssh_auth_method": "key",
+		"ssh_key_path":    t.KeyPath,
+	}
+	if errs := validateSessionConfig("ssh", config, true); len(errs) > 0 {
+		return UIOpenResult{}, &ConfigValidationError{SessionType: "ssh", Errors: errs}
+	}
+	name := t.User + "@" + t.Host
+	app.Event.Emit("ui:open_adhoc", map[string]interface{}{
+		"windowId":    uiWindow(app, ""),
+		"name":        name,
+		"sessionType": "ssh",
+		"config":      config,
+	})
+	return UIOpenResult{Mode: "adhoc", Name: name}, nil
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"term/database"
+	"term/logging"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+var updateLog = logging.For("update")
+
+// Update settings
+const (
+	settingUpdateChannel = "update_channel"
+	settingUpdateCheck   = "update_check"
+	settingUpdateFeedURL = "update_feed_url"
+
+	updateCheckInterval = 24 * time.Hour
+	updateFirstCheck    = time.Minute // after startup, so it does not slow it down
+	updateFeedMaxSize   = 1 << 20
+	updateMaxSize       = 512 << 20
+	updateHTTPTimeout   = 30 * time.Second
+	updateStagedFile    = "staged.json"
+)
+
+// updateFeed is the release feed: every release with a download per platform.
+// The feed itself is not trusted; each asset carries an Ed25519 signature over
+// updateSignedMessage, made with the release key.
+type updateFeed struct {
+	Releases []UpdateRelease `json:"releases"`
+}
+
+// UpdateRelease is a release of the feed
+type UpdateRelease struct {
+	Version string        `json:"version"`
+	Channel string        `json:"channel"` // "stable" or "beta"
+	Date    string        `json:"date,omitempty"`
+	Notes   string        `json:"notes,omitempty"`
+	Assets  []updateAsset `json:"assets,omitempty"`
+	Asset   *updateAsset  `json:"-"` // the asset for this platform
+}
+
+// updateAsset is the executable of a release for one platform
+type updateAsset struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	URL       string `json:"url"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"` // base64
+}
+
+// updateSignedMessage is what a release signature covers. Naming the version and
+// platform keeps a validly signed old or foreign binary from being served instead.
+func updateSignedMessage(version string, a updateAsset) []byte {
+	return []byte(fmt.Sprintf("term %s %s/%s sha256:%s", version, a.OS, a.Arch, strings.ToLower(a.SHA256)))
+}
+
+// UpdateStatus is the state of the updater, as shown in the settings
+type UpdateStatus struct {
+	CurrentVersion string         `json:"currentVersion"`
+	Channel        string         `json:"channel"`
+	CheckDaily     bool           `json:"checkDaily"`
+	Enabled        bool           `json:"enabled"` // a feed and a release key are configured
+	State          string         `json:"state"`   // "idle", "available", "downloading", "ready" or "error"
+	Latest         *UpdateRelease `json:"latest,omitempty"`
+	Error          string         `json:"error,omitempty"`
+	CheckedAt      *time.Time     `json:"checkedAt,omitempty"`
+	CanInstall     bool           `json:"canInstall"` // the app can replace its own executable
+}
+
+// stagedUpdate is a verified update waiting to be installed on restart
+type stagedUpdate struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256"`
+}
+
+// UpdateService checks the release feed of the selected channel, downloads and
+// verifies updates, and installs them when the app restarts
+type UpdateService struct {
+	app *application.App
+	db  *database.DB
+	dir string // downloads and the staged update
+
+	mu      sync.Mutex
+	status  UpdateStatus
+	cancel  context.CancelFunc
+	restart bool
+}
+
+// NewUpdateService creates the update service; dir holds downloaded updates
+func NewUpdateService(db *database.DB, dir string) *UpdateService {
+	u := &UpdateService{db: db, dir: dir}
+	u.status = UpdateStatus{CurrentVersion: appVersion, State: "idle", CanInstall: canSelfUpdate()}
+	if staged, err := u.readStaged(); err == nil && compareVersions(staged.Version, appVersion) > 0 {
+		u.status.State = "ready"
+		u.status.Latest = &UpdateRelease{Version: staged.Version}
+	}
+	return u
+}
+
+// SetApp sets the Wails application instance
+func (u *UpdateService) SetApp(app *application.App) {
+	u.app = app
+}
+
+// Start checks for updates in the background, shortly after startup and then
+// daily, while update_check is on
+func (u *UpdateService) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+	go func() {
+		wait := updateFirstCheck
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			wait = updateCheckInterval
+			if !settingBool(u.db, settingUpdateCheck) || u.db.Locked() {
+				continue
+			}
+			if _, err := u.CheckForUpdate(); err != nil {
+				updateLog.Warn("update check failed", "err", err)
+			}
+		}
+	}()
+}
+
+// Stop ends the background checks
+func (u *UpdateService) Stop() {
+	if u.cancel != nil {
+		u.cancel()
+	}
+}
+
+// GetUpdateStatus returns the state of the updater
+func (u *UpdateService) GetUpdateStatus() UpdateStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	st := u.status
+	st.Channel = settingValue(u.db, settingUpdateChannel)
+	st.CheckDaily = settingBool(u.db, settingUpdateCheck)
+	st.Enabled = u.feedURL() != "" && updatePublicKey != ""
+	return st
+}
+
+func (u *UpdateService) feedURL() string {
+	return settingValue(u.db, settingUpdateFeedURL)
+}
+
+func (u *UpdateService) setStatus(change func(st *UpdateStatus)) UpdateStatus {
+	u.mu.Lock()
+	change(&u.status)
+	u.mu.Unlock()
+	return u.GetUpdateStatus()
+}
+
+// CheckForUpdate reads the feed and returns the newest release of the channel
+// for this platform when it is newer than this build, or nil. update:available
+// is emitted for it.
+func (u *UpdateService) CheckForUpdate() (*UpdateRelease, error) {
+	feedURL := u.feedURL()
+	if feedURL == "" || updatePublicKey == "" {
+		return nil, fmt.Errorf("updates are not configured for this build")
+	}
+	release, err := u.latestRelease(feedURL, settingValue(u.db, settingUpdateChannel))
+	now := time.Now()
+	if err != nil {
+		u.setStatus(func(st *UpdateStatus) {
+			st.State, st.Error, st.CheckedAt = "error", err.Error(), &now
+		})
+		return nil, err
+	}
+
+	announced := false
+	st := u.setStatus(func(st *UpdateStatus) {
+		// Announce each release once, not at every daily check
+		announced = st.State == "available" && st.Latest != nil && release != nil && st.Latest.Version == release.Version
+		st.CheckedAt, st.Error = &now, ""
+		switch {
+		case st.State == "downloading",
+			st.State == "ready" && (release == nil || st.Latest != nil && st.Latest.Version == release.Version):
+			// keep the download in progress or staged
+		case release != nil:
+			st.State, st.Latest = "available", release
+		default:
+			st.State, st.Latest = "idle", nil
+		}
+	})
+	if release != nil && st.State == "available" && !announced {
+		updateLog.Info("update available", "version", release.Version, "channel", release.Channel)
+		u.emit("update:available", map[string]interface{}{
+			"version": release.Version,
+			"channel": release.Channel,
+			"notes":   release.Notes,
+			"date":    release.Date,
+		})
+	}
+	return release, nil
+}
+
+// latestRelease fetches the feed and picks the newest release the channel
+// offers for this platform; beta also offers stable releases
+func (u *UpdateService) latestRelease(feedURL, channel string) (*UpdateRelease, error) {
+	data, err := u.fetch(feedURL, updateFeedMaxSize, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch release feed: %w", err)
+	}
+	var feed updateFeed
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, fmt.Errorf("invalid release feed: %w", err)
+	}
+	var best *UpdateRelease
+	for i := range feed.Releases {
+		r := &feed.Releases[i]
+		if r.Channel != "stable" && !(channel == "beta" && r.Channel == "beta") {
+			continue
+		}
+		if compareVersions(r.Version, appVersion) <= 0 || best != nil && compareVersions(r.Version, best.Version) <= 0 {
+			continue
+		}
+		for j := range r.Assets {
+			if r.Assets[j].OS == runtime.GOOS && r.Assets[j].Arch == runtime.GOARCH {
+				r.Asset = &r.Assets[j]
+				best = r
+				break
+			}
+		}
+	}
+	return best, nil
+}
+
+// DownloadUpdate downloads the available update and verifies its checksum and
+// signature. The verified file is staged and installed when the app restarts;
+// update:ready is emitted once it is.
+func (u *UpdateService) DownloadUpdate() error {
+	u.mu.Lock()
+	release := u.status.Latest
+	if u.status.State == "downloading" {
+		u.mu.Unlock()
+		return fmt.Errorf("the update is already downloading")
+	}
+	if release == nil || release.Asset == nil || u.status.State != "available" && u.status.State != "error" {
+		u.mu.Unlock()
+		return fmt.Errorf("no update to download; check for updates first")
+	}
+	u.status.State, u.status.Error = "downloading", ""
+	u.mu.Unlock()
+
+	path, err := u.download(release)
+	if err != nil {
+		updateLog.Error("update download failed", "version", release.Version, "err", err)
+		u.setStatus(func(st *UpdateStatus) { st.State, st.Error = "error", err.Error() })
+		u.emit("update:error", map[string]interface{}{"version": release.Version, "error": err.Error()})
+		return err
+	}
+	u.setStatus(func(st *UpdateStatus) { st.State = "ready" })
+	updateLog.Info("update staged", "version", release.Version, "path", path)
+	u.emit("update:ready", map[string]interface{}{"version": release.Version, "canInstall": canSelfUpdate(), "path": path})
+	return nil
+}
+
+func (u *UpdateService) download(release *UpdateRelease) (string, error) {
+	asset := *release.Asset
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("invalid release key in this build")
+	}
+	sig, err := base64.StdEncoding.DecodeString(asset.Signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature in release feed")
+	}
+	// Check the signature of what the feed announces before fetching anything
+	if !ed25519.Verify(ed25519.PublicKey(key), updateSignedMessage(release.Version, asset), sig) {
+		return "", fmt.Errorf("release %s is not signed with the release key", release.Version)
+	}
+	if err := httpsURL(asset.URL); err != nil {
+		return "", fmt.Errorf("update URL %w", err)
+	}
+
+	if err := os.MkdirAll(u.dir, 0700); err != nil {
+		return "", err
+	}
+	name := "term-" + sanitize(release.Version)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	path := filepath.Join(u.dir, name)
+	tmp := path + ".part"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0700)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	progress := &updateProgress{u: u, version: release.Version, total: asset.Size}
+	_, err = u.fetch(asset.URL, updateMaxSize, io.MultiWriter(f, hash, progress))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), asset.SHA256) {
+		err = fmt.Errorf("checksum mismatch: the download is damaged or was tampered with")
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	data, _ := json.Marshal(stagedUpdate{Version: release.Version, Path: path, SHA256: strings.ToLower(asset.SHA256)})
+	if err := os.WriteFile(filepath.Join(u.dir, updateStagedFile), data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// updateProgress emits update:progress while a download runs, at most a few
+// times per second
+type updateProgress struct {
+	u       *UpdateService
+	version string
+	total   int64
+	done    int64
+	last    time.Time
+}
+
+func (p *updateProgress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if time.Since(p.last) > 250*time.Millisecond || p.done == p.total {
+		p.last = time.Now()
+		p.u.emit("update:progress", map[string]interface{}{"version": p.version, "downloaded": p.done, "total": p.total})
+	}
+	return len(b), nil
+}
+
+// fetch GETs url into w, or returns the body when w is nil, refusing bodies
+// over max bytes
+func (u *UpdateService) fetch(url string, max int64, w io.Writer) ([]byte, error) {
+	timeout := updateHTTPTimeout
+	if w != nil {
+		timeout = 30 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "term/"+appVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	body := io.LimitReader(resp.Body, max+1)
+	if w == nil {
+		data, err := io.ReadAll(body)
+		if err == nil && int64(len(data)) > max {
+			err = fmt.Errorf("response is larger than %d bytes", max)
+		}
+		return data, err
+	}
+	n, err := io.Copy(w, body)
+	if err == nil && n > max {
+		err = fmt.Errorf("download is larger than %d bytes", max)
+	}
+	return nil, err
+}
+
+// RestartToUpdate quits the app; the staged update is installed once it has
+// shut down and the new version is started
+func (u *UpdateService) RestartToUpdate() error {
+	if _, err := u.readStaged(); err != nil {
+		return fmt.Errorf("no update is ready to install")
+	}
+	if !canSelfUpdate() {
+		return fmt.Errorf("this build cannot replace itself; install the downloaded update instead")
+	}
+	u.mu.Lock()
+	u.restart = true
+	u.mu.Unlock()
+	if u.app != nil {
+		u.app.Quit()
+	}
+	return nil
+}
+
+// FinishUpdate is called when the app has shut down: it installs a staged update
+// and reports whether the new version should be started, as RestartToUpdate asked
+func (u *UpdateService) FinishUpdate() (restart bool) {
+	u.Stop()
+	if err := u.InstallStaged(); err != nil {
+		updateLog.Error("update not installed", "err", err)
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.restart
+}
+
+// restartApp starts the app executable again, after an update
+func restartApp() {
+	exe, err := os.Executable()
+	if err == nil {
+		cmd := exec.Command(exe)
+		err = cmd.Start()
+	}
+	if err != nil {
+		updateLog.Error("failed to start the updated app", "err", err)
+	}
+}
+
+func (u *UpdateService) readStaged() (*stagedUpdate, error) {
+	data, err := os.ReadFile(filepath.Join(u.dir, updateStagedFile))
+	if err != nil {
+		return nil, err
+	}
+	var s stagedUpdate
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// InstallStaged replaces the app executable with a staged update, which takes
+// effect at the next start. Nothing is done when no update is staged.
+func (u *UpdateService) InstallStaged() error {
+	staged, err := u.readStaged()
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	stagedPath := filepath.Join(u.dir, updateStagedFile)
+	if compareVersions(staged.Version, appVersion) <= 0 {
+		os.Remove(staged.Path)
+		return os.Remove(stagedPath)
+	}
+	if !canSelfUpdate() {
+		return fmt.Errorf("this build cannot replace itself; install %s from %s", staged.Version, staged.Path)
+	}
+
+	// The file was verified when downloaded; check it was not changed since
+	f, err := os.Open(staged.Path)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != staged.SHA256 {
+		os.Remove(staged.Path)
+		os.Remove(stagedPath)
+		return fmt.Errorf("staged update %s was modified after it was verified; it was discarded", staged.Version)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	// A running executable can be renamed but not overwritten on Windows; the
+	// old one is removed at the next start
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	if err := copyExecutable(staged.Path, exe); err != nil {
+		os.Rename(old, exe)
+		return fmt.Errorf("failed to install update: %w", err)
+	}
+	os.Remove(staged.Path)
+	os.Remove(stagedPath)
+	updateLog.Info("update installed", "version", staged.Version)
+	return nil
+}
+
+// copyExecutable copies the update next to the app executable, so the final
+// rename stays on one file system
+func copyExecutable(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".new"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// removeReplacedExecutable deletes the executable left by the last update
+func removeReplacedExecutable() {
+	if exe, err := os.Executable(); err == nil {
+		if exe, err = filepath.EvalSymlinks(exe); err == nil {
+			os.Remove(exe + ".old")
+		}
+	}
+}
+
+// canSelfUpdate reports whether the app can swap its executable. macOS apps
+// are signed bundles, which a swapped binary would break; there the verified
+// download is left for the user to install.
+func canSelfUpdate() bool {
+	return runtime.GOOS != "darwin"
+}
+
+func (u *UpdateService) emit(name string, data map[string]interface{}) {
+	if u.app != nil {
+		u.app.Event.Emit(name, data)
+	}
+}
+
+// compareVersions orders versions such as 1.2.10 and v1.3.0-beta.2 as semver
+// does: numerically per part, with a pre-release before its release
+func compareVersions(a, b string) int {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+	a, aPre, _ := strings.Cut(a, "-")
+	b, bPre, _ := strings.Cut(b, "-")
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	// 1.2 is 1.2.0, while a shorter pre-release sorts first
+	for len(aParts) < len(bParts) {
+		aParts = append(aParts, "0")
+	}
+	for len(bParts) < len(aParts) {
+		bParts = append(bParts, "0")
+	}
+	if c := compareVersionParts(aParts, bParts); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareVersionParts(strings.Split(aPre, "."), strings.Split(bPre, "."))
+}
+
+func compareVersionParts(a, b []string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y string
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		nx, errX := strconv.Atoi(x)
+		ny, errY := strconv.Atoi(y)
+		switch {
+		case x == y:
+			continue
+		case errX == nil && errY == nil || x == "" || y == "":
+			if x == "" {
+				nx = -1
+			}
+			if y == "" {
+				ny = -1
+			}
+			if nx < ny {
+				return -1
+			}
+			return 1
+		case errX == nil:
+			return -1 // numeric identifiers sort before alphanumeric ones
+		case errY == nil:
+			return 1
+		case x < y:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"term/logging"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+var linkLog = logging.For("links")
+
+// linkSchemes are the URL schemes the app is registered for. sftp:// links open
+// an SSH tab to the host too, as file transfers run over its connection.
+var linkSchemes = []string{"ssh", "sftp"}
+
+// parseSessionURL reads ssh://[user@]host[:port] and sftp:// links. A password
+// in the link is refused rather than used, as links end up in wikis and logs.
+func parseSessionURL(raw string) (sshTarget, error) {
+	var t sshTarget
+	u, err := url.Parse(raw)
+	if err != nil {
+		return t, fmt.Errorf("invalid link: %w", err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	known := false
+	for _, s := range linkSchemes {
+		known = known || s == scheme
+	}
+	if !known {
+		return t, fmt.Errorf("unsupported link scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return t, fmt.Errorf("links with a password are not supported")
+		}
+		// ssh://user;fingerprint=...@host (RFC draft) only keeps the user
+		t.User, _, _ = strings.Cut(u.User.Username(), ";")
+	}
+	t.Host = u.Hostname()
+	if t.Host == "" {
+		return t, fmt.Errorf("host is required")
+	}
+	if port := u.Port(); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return t, fmt.Errorf("invalid port %q", port)
+		}
+		t.Port = n
+	}
+	return t, nil
+}
+
+// isSessionURL reports whether a launch argument is a link the app handles
+func isSessionURL(arg string) bool {
+	lower := strings.ToLower(arg)
+	for _, s := range linkSchemes {
+		if strings.HasPrefix(lower, s+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkHandler opens ssh:// and sftp:// links the OS hands to the app: as
+// arguments of the first launch, forwarded from a second instance, or through
+// the macOS launch event. Links arriving before the window has loaded wait for
+// it, as tabs are opened by the frontend.
+type LinkHandler struct {
+	app      *application.App
+	sessions *SessionService
+
+	mu      sync.Mutex
+	ready   bool
+	pending []string
+}
+
+// NewLinkHandler creates the link handler
+func NewLinkHandler(sessions *SessionService) *LinkHandler {
+	return &LinkHandler{sessions: sessions}
+}
+
+// SetApp sets the application reference used to open tabs
+func (h *LinkHandler) SetApp(app *application.App) {
+	h.app = app
+}
+
+// HandleArgs opens the links among command line arguments; other arguments
+// are ignored
+func (h *LinkHandler) HandleArgs(args []string) {
+	for _, arg := range args {
+		if isSessionURL(arg) {
+			h.HandleURL(arg)
+		}
+	}
+}
+
+// HandleURL opens a link, or queues it until the frontend is ready
+func (h *LinkHandler) HandleURL(raw string) {
+	h.mu.Lock()
+	if !h.ready {
+		h.pending = append(h.pending, raw)
+		h.mu.Unlock()
+		return
+	}
+	h.mu.Unlock()
+	h.open(raw)
+}
+
+// OpenPendingLinks is called by the frontend once it listens for tab events;
+// it opens the links received so far and later ones right away
+func (h *LinkHandler) OpenPendingLinks() {
+	h.mu.Lock()
+	h.ready = true
+	pending := h.pending
+	h.pending = nil
+	h.mu.Unlock()
+	for _, raw := range pending {
+		h.open(raw)
+	}
+}
+
+func (h *LinkHandler) open(raw string) {
+	if h.app == nil {
+		return
+	}
+	// The link itself stays out of the log: it names hosts and users
+	target, err := parseSessionURL(raw)
+	if err == nil {
+		var result UIOpenResult
+		result, err = openSSHTarget(h.app, h.sessions, target)
+		if err == nil {
+			linkLog.Info("Opened link", "mode", result.Mode)
+			h.focusWindow()
+			return
+		}
+	}
+	linkLog.Warn("Failed to open link", "error", err)
+	h.app.Event.Emit("ui:open_error", map[string]interface{}{
+		"url":      raw,
+		"error":    err.Error(),
+		"windowId": uiWindow(h.app, ""),
+	})
+	h.focusWindow()
+}
+
+// focusWindow brings the window forward so the new tab is seen
+func (h *LinkHandler) focusWindow() {
+	showUIWindow(h.app)
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// Vault settings. The vault is the SecretStore running with a master password
+// (secrets_key_source = "passphrase") instead of a keychain-held key.
+const (
+	settingVaultAutoLock            = "vault_auto_lock_minutes" // 0 disables auto-lock
+	settingVaultRecordingPassphrase = "vault_recording_passphrase"
+	defaultVaultAutoLockMinutes     = 15
+	vaultAutoLockCheckInterval      = 30 * time.Second
+)
+
+// SetApp wires the vault events and starts the auto-lock timer once the application exists
+func (s *SecretStore) SetApp(app *application.App) {
+	s.app = app
+
+	app.Event.On("vault:status:request", func(e *application.CustomEvent) {
+		s.emitStatus()
+	})
+
+	// Unlock with the master password: {password}
+	app.Event.On("vault:unlock", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		password, _ := data["password"].(string)
+		dbLocked := s.db.Locked()
+		if err := s.Unlock(password); err != nil {
+			s.emitError(err)
+			return
+		}
+		app.Event.Emit("vault:unlocked", map[string]interface{}{})
+		if dbLocked {
+			// Everything read before this point came from the empty placeholder
+			app.Event.Emit("database:unlocked", map[string]interface{}{})
+		}
+		s.emitStatus()
+	})
+
+	// Lock now
+	app.Event.On("vault:lock", func(e *application.CustomEvent) {
+		s.lockVault("manual")
+	})
+
+	// UI activity keeps the vault open: {}
+	app.Event.On("vault:activity", func(e *application.CustomEvent) {
+		if s.Unlocked() {
+			s.touch()
+		}
+	})
+
+	// Set or change the master password: {password, currentPassword?}
+	app.Event.On("vault:setup", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		password, _ := data["password"].(string)
+		current, _ := data["currentPassword"].(string)
+		if err := s.SetupMasterPassword(password, current); err != nil {
+			s.emitError(err)
+			return
+		}
+		s.emitStatus()
+	})
+
+	// Remove the master password and return to the keychain: {password}
+	app.Event.On("vault:disable", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		password, _ := data["password"].(string)
+		if err := s.DisableMasterPassword(password); err != nil {
+			s.emitError(err)
+			return
+		}
+		s.emitStatus()
+	})
+
+	// Encrypt the whole database with the master password, or stop doing so: {password, enabled}
+	app.Event.On("vault:database_encryption:set", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		password, _ := data["password"].(string)
+		enabled, _ := data["enabled"].(bool)
+		if err := s.SetDatabaseEncryption(password, enabled); err != nil {
+			s.emitError(err)
+			return
+		}
+		s.emitStatus()
+	})
+
+	// Remember the recording passphrase inside the vault: {passphrase}
+	app.Event.On("vault:recording_passphrase:set", func(e *application.CustomEvent) {
+		data, _ := e.Data.(map[string]interface{})
+		passphrase, _ := data["passphrase"].(string)
+		if err := s.SetRecordingPassphrase(passphrase); err != nil {
+			s.emitError(err)
+			return
+		}
+		s.emitStatus()
+	})
+
+	go s.autoLockLoop()
+}
+
+func (s *SecretStore) emitStatus() {
+	if s.app == nil {
+		return
+	}
+	keychainError := ""
+	if s.keychainErr != nil && s.Source() == secretsSourceKeychain {
+		keychainError = s.keychainErr.Error()
+	}
+	hasRecording := false
+	if setting, err := s.db.GetSetting(settingVaultRecordingPassphrase); err == nil && setting != nil && setting.Value != "" {
+		hasRecording = true
+	}
+	s.app.Event.Emit("vault:status", map[string]interface{}{
+		"enabled":                s.Source() == secretsSourcePassphrase,
+		"unlocked":               s.Unlocked(),
+		"source":                 s.Source(),
+		"autoLockMinutes":        int(s.autoLockAfter() / time.Minute),
+		"hasRecordingPassphrase": hasRecording,
+		"databaseEncrypted":      s.db.Encrypted(),
+		"databaseLocked":         s.db.Locked(),
+		"databaseEncryption":     s.db.EncryptionPending(), // "enable" or "disable" until restart
+		"keychainError":          keychainError,
+	})
+}
+
+func (s *SecretStore) emitError(err error) {
+	if s.app == nil {
+		return
+	}
+	s.app.Event.Emit("vault:error", map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+// requestUnlock asks the frontend to prompt for the master password, or to set
+// one up when there is no keychain to hold the key
+func (s *SecretStore) requestUnlock(reason string) {
+	if s.app == nil {
+		return
+	}
+	if s.Source() != secretsSourcePassphrase {
+		if s.keychainErr != nil {
+			s.app.Event.Emit("vault:setup_required", map[string]interface{}{
+				"reason": reason,
+				"error":  s.keychainErr.Error(),
+			})
+		}
+		return
+	}
+	s.app.Event.Emit("vault:unlock_required", map[string]interface{}{
+		"reason": reason,
+	})
+}
+
+// lockVault forgets the master key; keychain-backed stores stay open
+func (s *SecretStore) lockVault(reason string) {
+	if s.Source() != secretsSourcePassphrase {
+		return
+	}
+	s.Lock()
+	if s.app != nil {
+		s.app.Event.Emit("vault:locked", map[string]interface{}{
+			"reason": reason, // "manual" or "inactivity"
+		})
+	}
+}
+
+// autoLockAfter returns the configured inactivity timeout, or 0 when disabled
+func (s *SecretStore) autoLockAfter() time.Duration {
+	return time.Duration(settingInt(s.db, settingVaultAutoLock)) * time.Minute
+}
+
+func (s *SecretStore) autoLockLoop() {
+	ticker := time.NewTicker(vaultAutoLockCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !s.Unlocked() || s.Source() != secretsSourcePassphrase {
+			continue
+		}
+		after := s.autoLockAfter()
+		if after <= 0 {
+			continue
+		}
+		s.mu.RLock()
+		idle := time.Since(s.lastUsed)
+		s.mu.RUnlock()
+		if idle >= after {
+			s.lockVault("inactivity")
+		}
+	}
+}
+
+// SetupMasterPassword protects all secrets with a master password, or changes an existing one
+func (s *SecretStore) SetupMasterPassword(password, currentPassword string) error {
+	if len(password) < 8 {
+		return fmt.Errorf("master password must be at least 8 characters")
+	}
+	if s.Source() == secretsSourcePassphrase {
+		// Changing the password requires proving the current one
+		if err := s.Unlock(currentPassword); err != nil {
+			return err
+		}
+	}
+
+	salt, err := randBytes(16)
+	if err != nil {
+		return err
+	}
+	newKey := deriveKeyArgon2([]byte(password), salt, defaultArgon2)
+	check, err := sealSecret(newKey, secretsKeyCheckPlain)
+	if err != nil {
+		return err
+	}
+
+	rw, err := s.rekey(newKey)
+	if err != nil {
+		return err
+	}
+	rw.Settings[settingSecretsKeySource] = secretsSourcePassphrase
+	rw.Settings[settingSecretsKDFSalt] = b64(salt)
+	rw.Settings[settingSecretsKeyCheck] = check
+	if err := s.db.RewriteSecrets(rw); err != nil {
+		return fmt.Errorf("failed to re-encrypt secrets: %w", err)
+	}
+	if err := s.db.RekeyEncryption(password); err != nil {
+		return fmt.Errorf("failed to re-encrypt database: %w", err)
+	}
+
+	s.mu.Lock()
+	s.key = newKey
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// DisableMasterPassword moves secrets back under a keychain-held key
+func (s *SecretStore) DisableMasterPassword(password string) error {
+	if s.Source() != secretsSourcePassphrase {
+		return fmt.Errorf("no master password is set")
+	}
+	if s.db.Encrypted() || s.db.EncryptionPending() == database.EncryptionPendingEnable {
+		return fmt.Errorf("turn off database encryption before removing the master password")
+	}
+	if err := s.Unlock(password); err != nil {
+		return err
+	}
+
+	var newKey []byte
+	stored, err := keychainGet(secretsKeychainAccount)
+	switch {
+	case err == nil:
+		if newKey, err = decodeB64(string(stored)); err != nil || len(newKey) != 32 {
+			return fmt.Errorf("invalid master key in keychain")
+		}
+	case errors.Is(err, errKeychainNotFound):
+		if newKey, err = randBytes(32); err != nil {
+			return err
+		}
+		if err := keychainSet(secretsKeychainAccount, []byte(b64(newKey))); err != nil {
+			return fmt.Errorf("failed to store key in keychain: %w", err)
+		}
+	default:
+		return fmt.Errorf("keychain unavailable: %w", err)
+	}
+
+	rw, err := s.rekey(newKey)
+	if err != nil {
+		return err
+	}
+	rw.Settings[settingSecretsKeySource] = secretsSourceKeychain
+	rw.Settings[settingSecretsKDFSalt] = ""
+	rw.Settings[settingSecretsKeyCheck] = ""
+	if err := s.db.RewriteSecrets(rw); err != nil {
+		return fmt.Errorf("failed to re-encrypt secrets: %w", err)
+	}
+
+	s.mu.Lock()
+	s.key = newKey
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// SetDatabaseEncryption switches at-rest encryption of the whole database. The master
+// password must already be set up; the change is completed when the app exits.
+func (s *SecretStore) SetDatabaseEncryption(password string, enabled bool) error {
+	if s.Source() != secretsSourcePassphrase {
+		return fmt.Errorf("set up a master password first")
+	}
+	if err := s.Unlock(password); err != nil {
+		return err
+	}
+	if enabled {
+		return s.db.EnableEncryption(password)
+	}
+	return s.db.DisableEncryption(password)
+}
+
+// rekey re-seals every stored secret under newKey without writing anything yet
+func (s *SecretStore) rekey(newKey []byte) (database.SecretRewrite, error) {
+	rw := database.SecretRewrite{
+		Configs:     make(map[int]string),
+		PrivateKeys: make(map[int]string),
+		Settings:    make(map[string]string),
+		Credentials: make(map[string]string),
+	}
+	reseal := func(value string) (string, error) {
+		plain, err := s.Decrypt(value)
+		if err != nil {
+			return "", err
+		}
+		return sealSecret(newKey, plain)
+	}
+
+	configs, err := s.db.ListSecretConfigs(secretConfigKeys)
+	if err != nil {
+		return rw, err
+	}
+	for _, c := range configs {
+		if c.Value == "" {
+			continue
+		}
+		sealed, err := reseal(c.Value)
+		if err != nil {
+			return rw, fmt.Errorf("failed to re-encrypt %s: %w", c.Key, err)
+		}
+		rw.Configs[c.ID] = sealed
+	}
+
+	keys, err := s.db.ListLocalUserKeys()
+	if err != nil {
+		return rw, err
+	}
+	for _, k := range keys {
+		if k.PrivateKey == "" {
+			continue
+		}
+		sealed, err := reseal(k.PrivateKey)
+		if err != nil {
+			return rw, fmt.Errorf("failed to re-encrypt private key %q: %w", k.Name, err)
+		}
+		rw.PrivateKeys[k.ID] = sealed
+	}
+
+	creds, err := s.db.ListCredentials()
+	if err != nil {
+		return rw, err
+	}
+	for _, c := range creds {
+		if c.Secret == "" {
+			continue
+		}
+		sealed, err := reseal(c.Secret)
+		if err != nil {
+			return rw, fmt.Errorf("failed to re-encrypt credential %q: %w", c.Name, err)
+		}
+		rw.Credentials[c.ID] = sealed
+	}
+
+	if setting, err := s.db.GetSetting(settingVaultRecordingPassphrase); err == nil && setting != nil && setting.Value != "" {
+		sealed, err := reseal(setting.Value)
+		if err != nil {
+			return rw, fmt.Errorf("failed to re-encrypt recording passphrase: %w", err)
+		}
+		rw.Settings[settingVaultRecordingPassphrase] = sealed
+	}
+
+	if value, err := resealSyncConfig(s.db, reseal); err != nil {
+		return rw, fmt.Errorf("failed to re-encrypt sync credentials: %w", err)
+	} else if value != "" {
+		rw.Settings[settingSyncConfig] = value
+	}
+
+	if value, err := resealHashiCorpVaultConfig(s.db, reseal); err != nil {
+		return rw, fmt.Errorf("failed to re-encrypt Vault credentials: %w", err)
+	} else if value != "" {
+		rw.Settings[settingHashiCorpVault] = value
+	}
+
+	if value, err := resealAssistantConfig(s.db, reseal); err != nil {
+		return rw, fmt.Errorf("failed to re-encrypt the assistant API key: %w", err)
+	} else if value != "" {
+		rw.Settings[settingAssistant] = value
+	}
+	return rw, nil
+}
+
+// SetRecordingPassphrase stores the recording passphrase sealed in the vault ("" forgets it)
+func (s *SecretStore) SetRecordingPassphrase(passphrase string) error {
+	if passphrase == "" {
+		return s.db.SetSetting(settingVaultRecordingPassphrase, "", "string")
+	}
+	sealed, err := s.Encrypt(passphrase)
+	if err != nil {
+		return err
+	}
+	return s.db.SetSetting(settingVaultRecordingPassphrase, sealed, "string")
+}
+
+// RecordingPassphrase returns the remembered recording passphrase, or "" when none is
+// stored or the vault is locked (in which case an unlock prompt is requested)
+func (s *SecretStore) RecordingPassphrase() string {
+	setting, err := s.db.GetSetting(settingVaultRecordingPassphrase)
+	if err != nil || setting == nil || setting.Value == "" {
+		return ""
+	}
+	plain, err := s.Decrypt(setting.Value)
+	if err != nil {
+		if !errors.Is(err, errSecretsLocked) {
+			log.Printf("Failed to read recording passphrase from vault: %v", err)
+		}
+		return ""
+	}
+	return plain
+}
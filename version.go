@@ -0,0 +1,12 @@
+package main
+
+// appVersion is the version of this build, set by release builds with
+// -ldflags "-X main.appVersion=<version>"
+var appVersion = "0.0.1"
+
+// Release builds also set where updates come from, and the base64 Ed25519
+// public key their signatures are checked with; without a key, updates are off
+var (
+	updateFeedURL   = ""
+	updatePublicKey = ""
+)
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VNC repeater and reverse-connection config keys. With vnc_dest_host set,
+// vnc_host/vnc_port is a repeater asked to forward to the destination; with
+// vnc_reverse_connect, guacd listens on vnc_host/vnc_port (on the guacd machine)
+// for the VNC server to connect out to it, e.g. from behind NAT.
+const (
+	vncDestHostKey          = "vnc_dest_host"
+	vncDestPortKey          = "vnc_dest_port"
+	vncReverseKey           = "vnc_reverse_connect"
+	vncListenTimeoutKey     = "vnc_listen_timeout" // seconds guacd waits for the reverse connection
+	defaultVNCListenTimeout = 300
+)
+
+// VNCListener is a reverse VNC tunnel waiting for, or connected to, the remote machine
+type VNCListener struct {
+	SessionID string    `json:"sessionId"`
+	Address   string    `json:"address"` // listen address on the guacd machine
+	State     string    `json:"state"`   // "listening" or "connected"
+	Since     time.Time `json:"since"`
+
+	stop func() // closes the tunnel
+}
+
+func vncReverse(config map[string]string) bool {
+	reverse, _ := strconv.ParseBool(config[vncReverseKey])
+	return reverse
+}
+
+// vncConnectionParams adds the repeater and reverse-connection parameters of a VNC
+// session to its guacd parameters
+func vncConnectionParams(params map[string]string, config map[string]string) {
+	if host := strings.TrimSpace(config[vncDestHostKey]); host != "" {
+		params["dest-host"] = host
+		if port := strings.TrimSpace(config[vncDestPortKey]); port != "" {
+			params["dest-port"] = port
+		}
+	}
+	if vncReverse(config) {
+		timeout := defaultVNCListenTimeout
+		if n, err := strconv.Atoi(strings.TrimSpace(config[vncListenTimeoutKey])); err == nil && n > 0 {
+			timeout = n
+		}
+		params["reverse-connect"] = "true"
+		params["listen-timeout"] = strconv.Itoa(timeout * 1000)
+	}
+}
+
+// ListVNCListeners returns the reverse VNC sessions currently listening or connected
+func (g *GuacamoleService) ListVNCListeners() []VNCListener {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	listeners := make([]VNCListener, 0, len(g.listeners))
+	for _, l := range g.listeners {
+		listeners = append(listeners, *l)
+	}
+	return listeners
+}
+
+// StopVNCListener closes the reverse VNC tunnel of a session, which makes guacd
+// stop listening
+func (g *GuacamoleService) StopVNCListener(sessionID string) error {
+	g.mu.RLock()
+	l, ok := g.listeners[sessionID]
+	g.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("session %s is not listening", sessionID)
+	}
+	l.stop()
+	return nil
+}
+
+// startListening registers the reverse VNC tunnel of a session. A session listens
+// only once at a time since guacd cannot bind the same port twice.
+func (g *GuacamoleService) startListening(sessionID string, config map[string]string, stop func()) (*VNCListener, error) {
+	port := strings.TrimSpace(config["vnc_port"])
+	if port == "" {
+		port = "5900"
+	}
+	l := &VNCListener{
+		SessionID: sessionID,
+		Address:   net.JoinHostPort(config["vnc_host"], port),
+		State:     "listening",
+		Since:     time.Now(),
+		stop:      stop,
+	}
+	g.mu.Lock()
+	if _, exists := g.listeners[sessionID]; exists {
+		g.mu.Unlock()
+		return nil, fmt.Errorf("session %s is already listening for a reverse VNC connection", sessionID)
+	}
+	g.listeners[sessionID] = l
+	g.mu.Unlock()
+	g.emitListener(l, "listening", "")
+	return l, nil
+}
+
+// watchListener follows instructions from guacd: the first "size" means the VNC
+// server connected, an "error" (such as the listen timeout) is reported
+func (g *GuacamoleService) watchListener(l *VNCListener, data []byte) {
+	filterGuacInstructions(data, func(elements []string) bool {
+		switch elements[0] {
+		case "size":
+			g.mu.Lock()
+			connected := l.State == "listening"
+			if connected {
+				l.State = "connected"
+				l.Since = time.Now()
+			}
+			g.mu.Unlock()
+			if connected {
+				g.emitListener(l, "connected", "")
+			}
+		case "error":
+			if len(elements) > 1 {
+				g.emitListener(l, "failed", elements[1])
+			}
+		}
+		return false
+	})
+}
+
+// listening reports whether the reverse connection has yet to arrive
+func (g *GuacamoleService) listening(l *VNCListener) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return l.State == "listening"
+}
+
+// endListening removes the listener when its tunnel closed
+func (g *GuacamoleService) endListening(l *VNCListener) {
+	g.mu.Lock()
+	delete(g.listeners, l.SessionID)
+	g.mu.Unlock()
+	g.emitListener(l, "closed", "")
+}
+
+// emitListener announces a listener state change as vnc:listen
+func (g *GuacamoleService) emitListener(l *VNCListener, state, errMsg string) {
+	if app := g.sessionService.app; app != nil {
+		app.Event.Emit("vnc:listen", map[string]interface{}{
+			"sessionId": l.SessionID,
+			"state":     state,
+			"address":   l.Address,
+			"error":     errMsg,
+		})
+	}
+}
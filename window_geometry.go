@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+
+	"term/database"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"github.com/wailsapp/wails/v3/pkg/events"
+)
+
+// settingWindowGeometry holds where each window was when it closed, by window
+// ID; it is device-specific, like the monitors it refers to
+const settingWindowGeometry = "window_geometry"
+
+// Windows are never restored smaller than this
+const (
+	minWindowWidth  = 400
+	minWindowHeight = 300
+)
+
+// windowGeometry is the size and place of a window, restored when it opens again
+type windowGeometry struct {
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Maximized bool   `json:"maximized"`
+	Screen    string `json:"screen,omitempty"` // ID of the monitor the window was on
+}
+
+// windowGeometries follows the geometry of the open windows and saves it in
+// the window_geometry setting when they close
+type windowGeometries struct {
+	app *application.App
+	db  *database.DB
+
+	mu      sync.Mutex
+	current map[string]windowGeometry // bounds are the last ones while not maximized or minimized
+}
+
+func newWindowGeometries(app *application.App, db *database.DB) *windowGeometries {
+	return &windowGeometries{app: app, db: db, current: make(map[string]windowGeometry)}
+}
+
+func (g *windowGeometries) load() map[string]windowGeometry {
+	saved := make(map[string]windowGeometry)
+	if err := json.Unmarshal([]byte(settingValue(g.db, settingWindowGeometry)), &saved); err != nil {
+		windowLog.Warn("ignoring invalid window geometry", "err", err)
+	}
+	return saved
+}
+
+// applySize sets a window's saved size in its options; the position is only
+// known to be valid once the monitors can be listed, when the window shows
+func (g *windowGeometries) applySize(name string, opts *application.WebviewWindowOptions) {
+	saved, ok := g.load()[name]
+	if !ok {
+		return
+	}
+	opts.Width = max(saved.Width, minWindowWidth)
+	opts.Height = max(saved.Height, minWindowHeight)
+}
+
+// watch follows a window's geometry, restores its saved position when it first
+// shows and saves the geometry when it closes
+func (g *windowGeometries) watch(window *application.WebviewWindow) {
+	name := window.Name()
+	record := func(*application.WindowEvent) {
+		if window.IsMinimised() || window.IsFullscreen() {
+			return
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		geometry := g.current[name]
+		geometry.Maximized = window.IsMaximised()
+		if !geometry.Maximized {
+			if bounds := window.Bounds(); bounds.Width > 0 && bounds.Height > 0 {
+				geometry.X, geometry.Y = bounds.X, bounds.Y
+				geometry.Width, geometry.Height = bounds.Width, bounds.Height
+			}
+		}
+		if geometry.Width > 0 {
+			g.current[name] = geometry
+		}
+	}
+	window.OnWindowEvent(events.Common.WindowDidMove, record)
+	window.OnWindowEvent(events.Common.WindowDidResize, record)
+
+	var once sync.Once
+	window.OnWindowEvent(events.Common.WindowShow, func(e *application.WindowEvent) {
+		once.Do(func() {
+			g.restorePosition(window)
+			record(e)
+		})
+	})
+
+	// A hook runs before the window is gone, also when background mode only hides it
+	window.RegisterHook(events.Common.WindowClosing, func(*application.WindowEvent) {
+		g.save(name)
+	})
+}
+
+// restorePosition moves a window back to where it was, when that monitor is
+// still connected and the window's top left corner would be on it
+func (g *windowGeometries) restorePosition(window *application.WebviewWindow) {
+	saved, ok := g.load()[window.Name()]
+	if !ok {
+		return
+	}
+	if screen := g.screenAt(saved.X, saved.Y, saved.Screen); screen != nil {
+		window.SetBounds(application.Rect{
+			X:      saved.X,
+			Y:      saved.Y,
+			Width:  max(saved.Width, minWindowWidth),
+			Height: max(saved.Height, minWindowHeight),
+		})
+	} else {
+		windowLog.Info("saved window position is off screen, centering", "window", window.Name(), "screen", saved.Screen)
+	}
+	if saved.Maximized {
+		window.Maximise()
+	}
+}
+
+// screenAt returns the connected monitor holding a point, preferring the one
+// with ID prefer where monitors overlap
+func (g *windowGeometries) screenAt(x, y int, prefer string) *application.Screen {
+	var found *application.Screen
+	for _, screen := range g.app.Screen.GetAll() {
+		area := screen.WorkArea
+		if area.Width == 0 || area.Height == 0 {
+			area = screen.Bounds
+		}
+		if x < area.X || y < area.Y || x >= area.X+area.Width || y >= area.Y+area.Height {
+			continue
+		}
+		if prefer == "" || screen.ID == prefer {
+			return screen
+		}
+		if found == nil {
+			found = screen
+		}
+	}
+	return found
+}
+
+// save stores a window's geometry with the others', e.g. when it closes
+func (g *windowGeometries) save(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	geometry, ok := g.current[name]
+	if !ok {
+		return
+	}
+	if screen := g.screenAt(geometry.X, geometry.Y, ""); screen != nil {
+		geometry.Screen = screen.ID
+	}
+	saved := g.load()
+	saved[name] = geometry
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return
+	}
+	if err := setSettingValue(g.db, settingWindowGeometry, string(data)); err != nil {
+		windowLog.Warn("failed to save window geometry", "window", name, "err", err)
+	}
+}
+
+// saveAll stores the geometry of every open window, as quitting closes them
+// without the closing hooks
+func (g *windowGeometries) saveAll() {
+	g.mu.Lock()
+	names := make([]string, 0, len(g.current))
+	for name := range g.current {
+		names = append(names, name)
+	}
+	g.mu.Unlock()
+	for _, name := range names {
+		g.save(name)
+	}
+}
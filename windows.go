@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"term/database"
+	"term/logging"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"github.com/wailsapp/wails/v3/pkg/events"
+)
+
+var windowLog = logging.For("window")
+
+// mainWindowName names the first window. Tabs opened from outside the app, by
+// links, the tray or `term open`, go to it while it is open.
+const mainWindowName = "main"
+
+// AppWindow is an open app window, e.g. for a "Move to window" menu
+type AppWindow struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// WindowService opens more app windows. Their tabs run in the one
+// TerminalService; terminal events carry the ID of the window showing the tab,
+// and each window's frontend only handles its own.
+type WindowService struct {
+	app       *application.App
+	terminals *TerminalService
+	geometry  *windowGeometries
+
+	mu       sync.Mutex
+	next     int
+	titles   map[string]string
+	quitting bool
+}
+
+// NewWindowService creates the window service; windows open where they were
+// last closed
+func NewWindowService(app *application.App, db *database.DB, terminals *TerminalService) *WindowService {
+	w := &WindowService{
+		app:       app,
+		terminals: terminals,
+		geometry:  newWindowGeometries(app, db),
+		next:      2,
+		titles:    make(map[string]string),
+	}
+	app.OnShutdown(func() {
+		w.geometry.saveAll()
+		w.mu.Lock()
+		w.quitting = true
+		w.mu.Unlock()
+	})
+	return w
+}
+
+// appWindowOptions are the options of every app window. The frontend reads its
+// window ID from the URL.
+func appWindowOptions(name string) application.WebviewWindowOptions {
+	url := "/"
+	if name != mainWindowName {
+		url = "/?window=" + name
+	}
+	return application.WebviewWindowOptions{
+		Name:  name,
+		Title: "Terminal Manager",
+		Mac: application.MacWindow{
+			InvisibleTitleBarHeight: 50,
+			Backdrop:                application.MacBackdropTranslucent,
+			TitleBar:                application.MacTitleBarDefault,
+		},
+		BackgroundColour: application.NewRGB(27, 38, 54),
+		URL:              url,
+		Width:            1200,
+		Height:           800,
+	}
+}
+
+// NewMainWindow creates the main window
+func (w *WindowService) NewMainWindow() *application.WebviewWindow {
+	opts := appWindowOptions(mainWindowName)
+	w.geometry.applySize(mainWindowName, &opts)
+	window := w.app.Window.NewWithOptions(opts)
+	w.track(window, "Main window")
+	return window
+}
+
+// OpenWindow opens another app window and returns its ID, e.g. to move a tab to it
+func (w *WindowService) OpenWindow() (string, error) {
+	w.mu.Lock()
+	name := fmt.Sprintf("window-%d", w.next)
+	title := fmt.Sprintf("Window %d", w.next)
+	w.next++
+	w.mu.Unlock()
+
+	opts := appWindowOptions(name)
+	w.geometry.applySize(name, &opts)
+	window := w.app.Window.NewWithOptions(opts)
+	if window == nil {
+		return "", fmt.Errorf("failed to open window")
+	}
+	w.track(window, title)
+	window.Show()
+	window.Focus()
+	windowLog.Info("window opened", "window", name)
+	return name, nil
+}
+
+// ListWindows returns the open app windows, the main window first
+func (w *WindowService) ListWindows() []AppWindow {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := make([]AppWindow, 0, len(w.titles))
+	for id, title := range w.titles {
+		list = append(list, AppWindow{ID: id, Title: title})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].ID == mainWindowName) != (list[j].ID == mainWindowName) {
+			return list[i].ID == mainWindowName
+		}
+		return windowNumber(list[i].ID) < windowNumber(list[j].ID)
+	})
+	return list
+}
+
+func windowNumber(id string) int {
+	var n int
+	fmt.Sscanf(strings.TrimPrefix(id, "window-"), "%d", &n)
+	return n
+}
+
+// track lists a window until it closes. The running sessions of a closed
+// window move to another one, so closing a window never drops a connection;
+// only quitting does.
+func (w *WindowService) track(window *application.WebviewWindow, title string) {
+	name := window.Name()
+	w.mu.Lock()
+	w.titles[name] = title
+	w.mu.Unlock()
+	w.geometry.watch(window)
+	w.emitChanged()
+
+	// Listeners only run when the close was not cancelled, e.g. by background mode
+	window.OnWindowEvent(events.Common.WindowClosing, func(*application.WindowEvent) {
+		w.mu.Lock()
+		delete(w.titles, name)
+		quitting := w.quitting
+		w.mu.Unlock()
+		windowLog.Info("window closed", "window", name)
+		if quitting {
+			return
+		}
+		if target := uiWindow(w.app, name); target != "" && w.terminals != nil {
+			w.terminals.moveWindowSessions(name, target)
+		}
+		w.emitChanged()
+	})
+}
+
+func (w *WindowService) emitChanged() {
+	w.app.Event.Emit("windows:changed", map[string]interface{}{
+		"windows": w.ListWindows(),
+	})
+}
+
+// uiWindow returns the window that tabs opened from outside the app go to: the
+// main window, or another open one when it was closed. except is a window
+// that is closing.
+func uiWindow(app *application.App, except string) string {
+	if except != mainWindowName {
+		if _, ok := app.Window.GetByName(mainWindowName); ok {
+			return mainWindowName
+		}
+	}
+	var names []string
+	for _, window := range app.Window.GetAll() {
+		if window.Name() != except {
+			names = append(names, window.Name())
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// showUIWindow brings forward the window that tabs opened from outside the app
+// go to, also when it was hidden to the tray
+func showUIWindow(app *application.App) {
+	if window, ok := app.Window.GetByName(uiWindow(app, "")); ok {
+		window.Show()
+		window.Restore()
+		window.Focus()
+	}
+}
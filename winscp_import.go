@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WinSCP keeps saved sessions below this registry key, or in [Sessions\...] sections of WinSCP.ini
+const winscpSessionsKey = `Software\Martin Prikryl\WinSCP 2\Sessions`
+
+// ImportWinSCP creates SSH sessions from WinSCP SFTP/SCP sites, read from WinSCP.ini
+// (or a .reg export) or, when no path is given, the Windows registry. WinSCP site
+// folders ("Folder/Site") become session folders below a "WinSCP" folder.
+func (s *SessionService) ImportWinSCP(opts ConnectionImportOptions) (*SessionImportResult, error) {
+	var sessions []savedSession
+	var err error
+	if opts.Path != "" {
+		data, readErr := os.ReadFile(opts.Path)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read WinSCP file: %w", readErr)
+		}
+		if strings.HasSuffix(strings.ToLower(opts.Path), ".reg") {
+			sessions, err = parseRegSessions(data, winscpSessionsKey)
+		} else {
+			sessions, err = parseWinSCPIni(data)
+		}
+	} else {
+		sessions, err = readRegistrySessions(winscpSessionsKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SessionImportResult{}
+	var nodes []SessionExportNode
+	for _, ws := range sessions {
+		path := strings.Split(ws.Name, "/")
+		node, ok := winscpSessionNode(path[len(path)-1], ws.Values)
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		nodes = insertAtPath(nodes, path[:len(path)-1], node)
+	}
+	err = s.importIntoFolder("WinSCP", nodes, opts, result)
+	return result, err
+}
+
+// winscpSessionNode maps a WinSCP site onto an SSH session; FTP, WebDAV and S3 sites are skipped
+func winscpSessionNode(name string, v map[string]string) (SessionExportNode, bool) {
+	node := SessionExportNode{Name: name, Type: "session", SessionType: "ssh", Config: map[string]string{}}
+
+	// FSProtocol: 0 SCP, 1 SFTP with SCP fallback (default), 2 SFTP, 5 FTP, 6 WebDAV, 7 S3
+	switch regDword(v["FSProtocol"]) {
+	case "", "0", "1", "2":
+	default:
+		log.Printf("Skipping WinSCP site %q: not an SFTP/SCP site", name)
+		return node, false
+	}
+	host := strings.TrimSpace(v["HostName"])
+	if host == "" {
+		return node, false
+	}
+	user := v["UserName"]
+
+	node.Config["ssh_host"] = host
+	node.Config["ssh_port"] = orDefault(regDword(v["PortNumber"]), "22")
+	if user != "" {
+		node.Config["ssh_username"] = user
+	}
+	if keyFile := v["PublicKeyFile"]; keyFile != "" {
+		node.Config["ssh_auth_method"] = "key"
+		node.Config["ssh_key_path"] = keyFile
+	} else {
+		node.Config["ssh_auth_method"] = "password"
+		if enc := v["Password"]; enc != "" {
+			if password, err := winscpDecryptPassword(host, user, enc); err == nil {
+				node.Config["ssh_password"] = password
+			}
+		}
+	}
+
+	// ProxyMethod: 0 none, 1 SOCKS4, 2 SOCKS5, 3 HTTP, 4 telnet, 5 local command
+	switch regDword(v["ProxyMethod"]) {
+	case "1":
+		node.Config["ssh_proxy_type"] = "socks4"
+	case "2":
+		node.Config["ssh_proxy_type"] = "socks5"
+	case "3":
+		node.Config["ssh_proxy_type"] = "http"
+	}
+	if node.Config["ssh_proxy_type"] != "" {
+		node.Config["ssh_proxy_host"] = v["ProxyHost"]
+		if port := regDword(v["ProxyPort"]); port != "" {
+			node.Config["ssh_proxy_port"] = port
+		}
+		if v["ProxyUsername"] != "" {
+			node.Config["ssh_proxy_username"] = v["ProxyUsername"]
+		}
+	}
+	return node, true
+}
+
+// parseWinSCPIni reads [Sessions\<name>] sections from WinSCP.ini; values are %XX-escaped
+func parseWinSCPIni(data []byte) ([]savedSession, error) {
+	text := decodeRegText(data)
+	sessions := map[string]*savedSession{}
+	var current *savedSession
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = nil
+			section := line[1 : len(line)-1]
+			rest, ok := strings.CutPrefix(section, `Sessions\`)
+			if !ok {
+				continue
+			}
+			name := unescapeSessionName(rest)
+			if name == "" || name == "Default Settings" {
+				continue
+			}
+			current = &savedSession{Name: name, Values: map[string]string{}}
+			sessions[name] = current
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		current.Values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read WinSCP.ini: %w", err)
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no WinSCP sessions found")
+	}
+	return sortedSessions(sessions), nil
+}
+
+// winscpDecryptPassword reverses WinSCP's stored password obfuscation (used when no
+// master password is set); the plaintext is prefixed with user+host
+func winscpDecryptPassword(host, user, enc string) (string, error) {
+	const magic = 0xA3
+	const flagSimple = 0xFF
+
+	pos := 0
+	next := func() (byte, error) {
+		if pos+2 > len(enc) {
+			return 0, fmt.Errorf("truncated password")
+		}
+		n, err := strconv.ParseUint(enc[pos:pos+2], 16, 8)
+		if err != nil {
+			return 0, err
+		}
+		pos += 2
+		return ^(byte(n) ^ magic), nil
+	}
+
+	flag, err := next()
+	if err != nil {
+		return "", err
+	}
+	var length byte
+	if flag == flagSimple {
+		if _, err := next(); err != nil {
+			return "", err
+		}
+		if length, err = next(); err != nil {
+			return "", err
+		}
+	} else {
+		length = flag
+	}
+	skip, err := next()
+	if err != nil {
+		return "", err
+	}
+	pos += int(skip) * 2
+
+	out := make([]byte, 0, length)
+	for i := 0; i < int(length); i++ {
+		c, err := next()
+		if err != nil {
+			return "", err
+		}
+		out = append(out, c)
+	}
+	password := string(out)
+	if flag == flagSimple {
+		prefix := user + host
+		if !strings.HasPrefix(password, prefix) {
+			return "", fmt.Errorf("password is protected by a WinSCP master password")
+		}
+		password = password[len(prefix):]
+	}
+	return password, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// Wake-on-LAN config keys; like any config they can be set once on a folder
+const (
+	wolMACKey           = "wol_mac"
+	wolBroadcastKey     = "wol_broadcast" // default 255.255.255.255
+	wolPortKey          = "wol_port"      // default 9
+	wolAutoKey          = "wol_auto"      // wake and wait for the port before connecting
+	wolWaitKey          = "wol_wait_seconds"
+	defaultWOLWait      = 90
+	wolPollInterval     = 3 * time.Second
+	wolResendInterval   = 20 * time.Second
+	wolReachableProbe   = 2 * time.Second
+	defaultWOLBroadcast = "255.255.255.255"
+)
+
+var wolFields = []ConfigField{
+	{Key: wolMACKey, Label: "Wake-on-LAN MAC address", Type: fieldString},
+	{Key: wolBroadcastKey, Label: "Wake-on-LAN broadcast address", Type: fieldString, Default: defaultWOLBroadcast},
+	{Key: wolPortKey, Label: "Wake-on-LAN port", Type: fieldInt, Default: "9", Min: intPtr(1), Max: intPtr(65535)},
+	{Key: wolAutoKey, Label: "Wake before connecting", Type: fieldBool, Default: "false"},
+	{Key: wolWaitKey, Label: "Seconds to wait for wake-up", Type: fieldInt, Default: strconv.Itoa(defaultWOLWait), Min: intPtr(1), Max: intPtr(900)},
+}
+
+// WakeSession sends a Wake-on-LAN magic packet to the machine behind a session
+func (s *SessionService) WakeSession(sessionID string) error {
+	cfg, err := s.db.GetEffectiveConfig(sessionID)
+	if err != nil {
+		return err
+	}
+	return sendWakeOnLAN(cfg)
+}
+
+// sendWakeOnLAN sends the magic packet described by a session config
+func sendWakeOnLAN(cfg map[string]string) error {
+	mac, err := net.ParseMAC(strings.TrimSpace(cfg[wolMACKey]))
+	if err != nil {
+		return fmt.Errorf("invalid or missing %s: %w", wolMACKey, err)
+	}
+	broadcast := strings.TrimSpace(cfg[wolBroadcastKey])
+	if broadcast == "" {
+		broadcast = defaultWOLBroadcast
+	}
+	port := 9
+	if v := strings.TrimSpace(cfg[wolPortKey]); v != "" {
+		if port, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid %s: %w", wolPortKey, err)
+		}
+	}
+	return sendMagicPacket(mac, broadcast, port)
+}
+
+// sendMagicPacket broadcasts 6 x 0xFF followed by the MAC address 16 times
+func sendMagicPacket(mac net.HardwareAddr, broadcast string, port int) error {
+	if len(mac) != 6 {
+		return fmt.Errorf("a Wake-on-LAN MAC address must be 6 bytes, got %s", mac)
+	}
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		packet = append(packet, mac...)
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(broadcast, strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed to open Wake-on-LAN socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("failed to send Wake-on-LAN packet: %w", err)
+	}
+	return nil
+}
+
+// autoWake wakes a sleeping host before connecting when wol_auto is set: if the
+// session's port does not answer, the magic packet is sent and the port polled until
+// it opens or wol_wait_seconds pass. Progress is emitted as session:wol.
+func autoWake(app *application.App, nodeID, sessionType string, cfg map[string]string) error {
+	if auto, _ := strconv.ParseBool(cfg[wolAutoKey]); !auto || strings.TrimSpace(cfg[wolMACKey]) == "" {
+		return nil
+	}
+	host, port, ok := sessionEndpoint(sessionType, cfg)
+	if !ok {
+		return nil
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if endpointReachable(addr) {
+		return nil
+	}
+
+	emit := func(state string) {
+		if app != nil {
+			app.Event.Emit("session:wol", map[string]interface{}{"sessionId": nodeID, "state": state, "host": host})
+		}
+	}
+	wait := defaultWOLWait
+	if n, err := strconv.Atoi(strings.TrimSpace(cfg[wolWaitKey])); err == nil && n > 0 {
+		wait = n
+	}
+
+	deadline := time.Now().Add(time.Duration(wait) * time.Second)
+	var lastSent time.Time
+	for time.Now().Before(deadline) {
+		// Packets are not acknowledged, so repeat them while waiting
+		if time.Since(lastSent) >= wolResendInterval {
+			if err := sendWakeOnLAN(cfg); err != nil {
+				emit("failed")
+				return err
+			}
+			if lastSent.IsZero() {
+				emit("waking")
+			}
+			lastSent = time.Now()
+		}
+		time.Sleep(wolPollInterval)
+		if endpointReachable(addr) {
+			emit("awake")
+			return nil
+		}
+	}
+	emit("timeout")
+	return fmt.Errorf("%s did not wake up within %d seconds", host, wait)
+}
+
+func endpointReachable(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, wolReachableProbe)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}